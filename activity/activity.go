@@ -17,6 +17,7 @@ package activity
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
@@ -56,9 +57,15 @@ var (
 	// gcPauseRE is the regular expression that matches ART garbage collection pauses.
 	// e.g. "Explicit concurrent mark sweep GC freed 706(30KB) AllocSpace objects, 0(0B) LOS objects, 40% free, 16MB/26MB, paused 632us total 52.753ms"
 	gcPauseRE = regexp.MustCompile(`(?P<type>(Background partial|Background sticky|Explicit))` + ` concurrent mark sweep GC.*paused\s+` + `(?P<pausedDur>[^\s]+)`)
+
+	// pidSuffixRE is the regular expression that matches an app name followed by its PID, as outputted for Bluetooth events.
+	pidSuffixRE = regexp.MustCompile(`^(?P<app>.*) \(PID: \d+\)$`)
 )
 
 const (
+	// cancelCheckLines is the number of lines parsed between checks for cancellation.
+	cancelCheckLines = 1000
+
 	// strictModePre matches the prefix of the first line of a StrictMode policy violation event.
 	strictModePre = "StrictMode policy violation;"
 
@@ -90,6 +97,9 @@ const (
 	// crashes is the the CSV description of Crash events.
 	crashes = "Crashes"
 
+	// BLEAdvertising is the CSV description of Bluetooth LE advertising events.
+	BLEAdvertising = "BLE advertising"
+
 	// unknownTime is used when the start or end time of an event is unknown.
 	// This is not zero as csv.AddEntryWithOpt ignores events with a zero time.
 	unknownTime = -1
@@ -108,20 +118,35 @@ type Log struct {
 	CSV     string
 }
 
+// AdvertisingSummary is the Bluetooth LE advertising done by an app.
+type AdvertisingSummary struct {
+	App           string
+	UID           string
+	Count         int
+	TotalDuration time.Duration
+}
+
 // LogsData contains the CSV generated from the system and event logs and the start times of the logs.
 type LogsData struct {
 	// Logs is a map from section name to Log data.
-	Logs     map[string]*Log
-	Warnings []string
-	Errs     []error
+	Logs map[string]*Log
+	// BLEAdvertising is the per app advertising time over all logs, sorted by descending duration.
+	BLEAdvertising []AdvertisingSummary
+	Warnings       []string
+	Errs           []error
 }
 
 // String returns a string representation of the LogsData.
 func (ld LogsData) String() string {
 	var b bytes.Buffer
-	for n, l := range ld.Logs {
+	var names []string
+	for n := range ld.Logs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
 		// l is a pointer to the log. It shouldn't ever be nil, but add a check just in case.
-		if l != nil {
+		if l := ld.Logs[n]; l != nil {
 			fmt.Fprintf(&b, "\n%s startMs: %d\n%s\n", n, l.StartMs, l.CSV)
 		}
 	}
@@ -219,6 +244,12 @@ func (p *parser) fullTimestamp(month, day, partialTimestamp, remainder string) (
 // Parse writes a CSV entry for each line matching activity manager proc start and died, ANR and low memory events.
 // Package info is used to match crash events to UIDs. Errors encountered during parsing will be collected into an errors slice and will continue parsing remaining events.
 func Parse(pkgs []*usagepb.PackageInfo, f string) LogsData {
+	return ParseContext(context.Background(), pkgs, f)
+}
+
+// ParseContext is the same as Parse, but stops parsing once the context is done. The data of a
+// stopped parse only has the warnings and errors, ending with the context error.
+func ParseContext(ctx context.Context, pkgs []*usagepb.PackageInfo, f string) LogsData {
 	p, warnings, err := newParser(f)
 	res := LogsData{Warnings: warnings, Logs: make(map[string]*Log)}
 	if err != nil {
@@ -228,7 +259,12 @@ func Parse(pkgs []*usagepb.PackageInfo, f string) LogsData {
 	var lastTimestamp int64
 	// Pointer to the log data to modify. Will be stored in the Logs map.
 	var log *Log
-	for _, line := range strings.Split(f, "\n") {
+	for i, line := range strings.Split(f, "\n") {
+		if i%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return LogsData{Warnings: res.Warnings, Errs: append(res.Errs, err)}
+			}
+		}
 		// We don't want to falsely match log lines that contain text matching the BugReportSectionRE.
 		// Even if we're not interested in these events, matching it as an unknown section heading
 		// leads to log lines being skipped.
@@ -303,9 +339,52 @@ func Parse(pkgs []*usagepb.PackageInfo, f string) LogsData {
 	if log != nil {
 		log.CSV = appendCSVs(log.CSV, p.outputCSV(lastTimestamp))
 	}
+	res.BLEAdvertising = advertisingSummaries(res.Logs)
 	return res
 }
 
+// advertisingSummaries aggregates the BLE advertising events in the logs per app.
+func advertisingSummaries(logs map[string]*Log) []AdvertisingSummary {
+	apps := make(map[string]*AdvertisingSummary)
+	for _, l := range logs {
+		if l == nil {
+			continue
+		}
+		// Errors would have been reported when generating the CSV.
+		es, _ := csv.ExtractEvents(l.CSV, []string{BLEAdvertising})
+		for _, e := range es[BLEAdvertising] {
+			app := e.Value
+			if m, result := historianutils.SubexpNames(pidSuffixRE, e.Value); m {
+				app = result["app"]
+			}
+			k := app + "," + e.Opt
+			if apps[k] == nil {
+				apps[k] = &AdvertisingSummary{App: app, UID: e.Opt}
+			}
+			apps[k].Count++
+			apps[k].TotalDuration += time.Duration(e.End-e.Start) * time.Millisecond
+		}
+	}
+	var res []AdvertisingSummary
+	for _, s := range apps {
+		res = append(res, *s)
+	}
+	sort.Sort(byAdvertisingDuration(res))
+	return res
+}
+
+// byAdvertisingDuration sorts advertising summaries in descending order of duration, then by app name.
+type byAdvertisingDuration []AdvertisingSummary
+
+func (a byAdvertisingDuration) Len() int      { return len(a) }
+func (a byAdvertisingDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAdvertisingDuration) Less(i, j int) bool {
+	if a[i].TotalDuration != a[j].TotalDuration {
+		return a[i].TotalDuration > a[j].TotalDuration
+	}
+	return a[i].App < a[j].App
+}
+
 // msToTime converts milliseconds since Unix Epoch to a time.Time object.
 func msToTime(ms int64) time.Time {
 	return time.Unix(0, ms*int64(time.Millisecond))
@@ -435,6 +514,23 @@ func (p *parser) parseEvent(pkgs []*usagepb.PackageInfo, timestamp int64, event,
 			})
 		}
 		return "", nil
+	case "BluetoothLeAdvertiser":
+		// Advertising continues until explicitly stopped, so is tracked per process.
+		switch {
+		case strings.Contains(details, "startAdvertising"):
+			appName, uid := p.pidInfo(pid)
+			p.csvState.StartEvent(csv.Entry{
+				Desc:       BLEAdvertising,
+				Start:      timestamp,
+				Type:       "service",
+				Value:      fmt.Sprintf("%s (PID: %s)", appName, pid),
+				Opt:        uid,
+				Identifier: pid,
+			})
+		case strings.Contains(details, "stopAdvertising"):
+			p.csvState.EndEvent(BLEAdvertising, pid, timestamp)
+		}
+		return "", nil
 	case "AndroidRuntime":
 		if m, result := historianutils.SubexpNames(crashStartRE, details); m {
 			// Don't print out a crash event until we have the process details of what crashed.
@@ -21,6 +21,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/google/battery-historian/csv"
@@ -742,6 +743,47 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "BLE advertising",
+			input: []string{
+				`========================================================`,
+				`== dumpstate: 2015-11-05 06:30:29`,
+				`========================================================`,
+				`...`,
+				`------ SYSTEM LOG (logcat -v threadtime -d *:v) ------`,
+				`11-05 06:19:14.095 17745 17745 D BluetoothLeAdvertiser: startAdvertising`,
+				`11-05 06:19:15.815  1691  5180 D BluetoothLeAdvertiser: stopAdvertising`, // No corresponding start.
+				`11-05 06:20:14.095 17745 17745 D BluetoothLeAdvertiser: stopAdvertising`,
+				`11-05 06:21:00.000  1691  5180 D BluetoothLeAdvertiser: startAdvertisingSet`,
+				`11-05 06:25:00.000 17745 17745 D BluetoothLeAdvertiser: startAdvertising`,
+				`11-05 06:26:00.000 17745 17745 D BluetoothLeAdvertiser: stopAdvertising`,
+				`11-05 06:29:35.969  9662  9662 I dumpstate: begin`,
+				`...`,
+				`[persist.sys.timezone]: [America/Los_Angeles]`,
+				`...`,
+				`  PID mappings:`,
+				`    PID #17745: ProcessRecord{4fe996a 17745:gbis.gbandroid/u0a105}`,
+			},
+			wantLogsData: LogsData{
+				Logs: map[string]*Log{
+					SystemLogSection: &Log{
+						CSV: strings.Join([]string{
+							csv.FileHeader,
+							`BLE advertising,service,1446733154095,1446733214095,gbis.gbandroid (PID: 17745),10105`,
+							// Still advertising when the bug report was taken.
+							`BLE advertising,service,1446733260000,1446733775969,Unknown PID 1691 (PID: 1691),`,
+							`BLE advertising,service,1446733500000,1446733560000,gbis.gbandroid (PID: 17745),10105`,
+							`Logcat misc,string,1446733775969,1446733775969,bug report collection triggered,`,
+						}, "\n"),
+						StartMs: 1446733154095,
+					},
+				},
+				BLEAdvertising: []AdvertisingSummary{
+					{App: "Unknown PID 1691", Count: 1, TotalDuration: 515969 * time.Millisecond},
+					{App: "gbis.gbandroid", UID: "10105", Count: 2, TotalDuration: 2 * time.Minute},
+				},
+			},
+		},
 		{
 			desc: "Event log, system log and bug report taken events",
 			input: []string{
@@ -17,6 +17,9 @@ package analyzer
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +31,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,18 +39,36 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	"github.com/google/battery-historian/activity"
+	"github.com/google/battery-historian/apperrors"
+	"github.com/google/battery-historian/audio"
+	"github.com/google/battery-historian/automotive"
+	"github.com/google/battery-historian/bluetooth"
 	"github.com/google/battery-historian/broadcasts"
 	"github.com/google/battery-historian/bugreportutils"
 	"github.com/google/battery-historian/checkindelta"
 	"github.com/google/battery-historian/checkinparse"
 	"github.com/google/battery-historian/checkinutil"
+	"github.com/google/battery-historian/consistency"
+	"github.com/google/battery-historian/csv"
 	"github.com/google/battery-historian/dmesg"
 	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/jobscheduler"
 	"github.com/google/battery-historian/kernel"
+	"github.com/google/battery-historian/location"
+	"github.com/google/battery-historian/markers"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/netstats"
 	"github.com/google/battery-historian/packageutils"
 	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/powermanager"
 	"github.com/google/battery-historian/powermonitor"
+	"github.com/google/battery-historian/powerrails"
 	"github.com/google/battery-historian/presenter"
+	"github.com/google/battery-historian/rollup"
+	"github.com/google/battery-historian/storage"
+	"github.com/google/battery-historian/sysupdate"
+	"github.com/google/battery-historian/telephony"
+	"github.com/google/battery-historian/tethering"
 	"github.com/google/battery-historian/wearable"
 
 	bspb "github.com/google/battery-historian/pb/batterystats_proto"
@@ -55,8 +77,8 @@ import (
 )
 
 const (
-	// maxFileSize is the maximum file size allowed for uploaded package.
-	maxFileSize = 100 * 1024 * 1024 // 100 MB Limit
+	// defaultMaxFileSize is the default maximum size of an upload.
+	defaultMaxFileSize = 100 * 1024 * 1024 // 100 MB Limit
 
 	minSupportedSDK        = 21 // We only support Lollipop bug reports and above
 	numberOfFilesToCompare = 2
@@ -70,6 +92,7 @@ const (
 	lastLogcat      = "Last Logcat"
 	locationLog     = "Location"
 	powerMonitorLog = "Power Monitor"
+	powerRailsLog   = "Power Rails"
 	systemLog       = "System"
 	wearableLog     = "Wearable"
 
@@ -77,7 +100,9 @@ const (
 	bugreportFT    = "bugreport"
 	bugreport2FT   = "bugreport2"
 	kernelFT       = "kernel"
+	markersFT      = "markers"
 	powerMonitorFT = "powermonitor"
+	powerRailsFT   = "powerrails"
 )
 
 var (
@@ -93,6 +118,57 @@ var (
 	// Initialized in SetResVersion()
 	resVersion int
 
+	// Initialized in SetStore(). Uploaded files and analyses aren't persisted if nil.
+	store storage.Store
+
+	// Initialized in SetMaxUnknownPercent(). Strict mode is disabled if not positive.
+	maxUnknownPercent float64
+
+	// Initialized in SetProfileNames().
+	profileNames bool
+
+	// Initialized in SetRedactions(). No apps are redacted if nil.
+	redactions *parseutils.Redactions
+
+	// Initialized in SetLanguage().
+	language = messages.DefaultLanguage
+
+	// Initialized in SetSnapshotInterval(). Device state snapshots aren't captured if not positive.
+	snapshotInterval time.Duration
+
+	// Initialized in SetSummaryTopN(). Summary maps aren't truncated if not positive.
+	summaryTopN int
+
+	// Initialized in SetSummarizeCharging().
+	summarizeCharging bool
+
+	// Initialized in SetScreenMergeGap().
+	screenMergeGap = parseutils.DefaultScreenMergeGap
+
+	// Initialized in SetMobileRadioTail().
+	mobileRadioTail = parseutils.DefaultMobileRadioTail
+
+	// Initialized in SetChargingDebounce().
+	chargingDebounce = parseutils.DefaultChargingDebounce
+
+	// Initialized in SetCSVMetricFilter(). All metrics are returned if nil.
+	csvMetricFilter *csv.MetricFilter
+
+	// Initialized in SetMaxFileSize().
+	maxFileSize int64 = defaultMaxFileSize
+
+	// Initialized in SetMaxHistoryLines(). The battery history isn't limited if not positive.
+	maxHistoryLines int
+
+	// Initialized in SetMaxConcurrentPerIP(). Concurrent analyses aren't limited if not positive.
+	maxConcurrentPerIP int
+
+	// Initialized in SetAnalysisTimeout(). Analyses don't time out if not positive.
+	analysisTimeout time.Duration
+
+	// zstdResponses is whether JSON responses can be zstd compressed, which requires the zstd tool.
+	zstdResponses = historianutils.ZstdAvailable()
+
 	// batteryRE is a regular expression that matches the time information for battery.
 	// e.g. 9,0,l,bt,0,86546081,70845214,99083316,83382448,1458155459650,83944766,68243903
 	batteryRE = regexp.MustCompile(`9,0,l,bt,(?P<batteryTime>.*)`)
@@ -115,6 +191,40 @@ type historianV2Log struct {
 	CSV    string `json:"csv"`
 	// Optional start time of the log as unix time in milliseconds.
 	StartMs int64 `json:"startMs"`
+	// Optional pre-aggregated versions of the dense numeric series of the CSV, in increasing order of
+	// resolution.
+	Downsampled []downsampledLog `json:"downsampled"`
+}
+
+// downsampledLog is a CSV pre-aggregated to a coarser time resolution, for zoomed out views.
+type downsampledLog struct {
+	ResolutionMs int64  `json:"resolutionMs"`
+	CSV          string `json:"csv"`
+}
+
+// denseHistoryMetrics are the numeric series of the battery history CSV sampled often enough to be
+// pre-aggregated. All the series of the power monitor and power rails logs are dense.
+var denseHistoryMetrics = []string{
+	parseutils.ChargingCurrentMetric,
+	parseutils.DpstBusyMetric,
+	parseutils.DpstIOWaitMetric,
+}
+
+// downsample returns the pre-aggregated versions of the dense metrics of the CSV, or of all its
+// metrics if none are given, at each of csv.Resolutions. Nil is returned if none of them are in the CSV.
+func downsample(csvInput string, dense []string) []downsampledLog {
+	var logs []downsampledLog
+	for _, r := range csv.Resolutions {
+		c, errs := csv.Downsample(csvInput, r, dense)
+		if len(errs) > 0 {
+			log.Printf("failed to downsample CSV to %v: %s", r, historianutils.ErrorsToString(errs))
+		}
+		if strings.TrimSpace(c) == strings.TrimSpace(csv.FileHeader) {
+			return nil
+		}
+		logs = append(logs, downsampledLog{int64(r / time.Millisecond), c})
+	}
+	return logs
 }
 
 type uploadResponse struct {
@@ -134,10 +244,36 @@ type uploadResponse struct {
 	Location            string                   `json:"location"`
 	OverflowMs          int64                    `json:"overflowMs"`
 	IsDiff              bool                     `json:"isDiff"`
+	// Unsupported is set in strict mode if too many history lines have unknown event codes, in which case no history or summaries are returned.
+	Unsupported *parseutils.UnsupportedReport `json:"unsupported"`
+	// Snapshots are the device states captured every snapshot interval, if set.
+	Snapshots []parseutils.DeviceStateSnapshot `json:"snapshots"`
+	// FinalState is the device state at the end of the history, around when the bug report was captured.
+	FinalState *parseutils.DeviceStateSnapshot `json:"finalState"`
+	// Heatmap is the drain rate, screen on time and wakeups of each hour of each day of the history, nil if there is no history.
+	Heatmap *parseutils.Heatmap `json:"heatmap"`
+	// TimedOut are the results omitted because the analysis timed out, if any.
+	TimedOut []string `json:"timedOut"`
+	// Summaries are the battery history summaries, only returned if requested, e.g. in summaries only mode.
+	Summaries []parseutils.ActivitySummary `json:"summaries,omitempty"`
+	// DeviceKey identifies the device in the device history endpoint, empty if the device ID is unknown.
+	DeviceKey string `json:"deviceKey,omitempty"`
+	// ReportID identifies the uploaded files in the state at endpoint, empty if no store is set.
+	ReportID string `json:"reportId,omitempty"`
+	// Changes are the app KPI changes since the previous stored report of the device, nil if there is none.
+	Changes *ReportChanges `json:"changedSinceLast,omitempty"`
+	// RollUp is the single row KPI summary of the report, for tracking sheets and chat bots, nil if no timeline is returned, e.g. in summaries only mode.
+	RollUp *rollup.Row `json:"rollUp,omitempty"`
+	// Intervals are the half-open time intervals of the events of each battery history metric, only
+	// returned if the intervals block is requested.
+	Intervals map[string][]csv.Interval `json:"intervals,omitempty"`
+	// LevelSteps are the time spent and activity over each battery level drop, keyed by level drop,
+	// e.g. "100->99".
+	LevelSteps map[string]parseutils.LevelStep `json:"levelSteps,omitempty"`
 }
 
 type uploadResponseCompare struct {
-	UploadResponse  []uploadResponse                 `json:"UploadResponse"`
+	UploadResponse  interface{}                      `json:"UploadResponse"`
 	HTML            string                           `json:"html"`
 	UsingComparison bool                             `json:"usingComparison"`
 	CombinedCheckin presenter.CombinedCheckinSummary `json:"combinedCheckin"`
@@ -151,6 +287,18 @@ type summariesData struct {
 	timeToDelta     map[string]string
 	errs            []error
 	overflowMs      int64
+	chargeStats     []parseutils.ChargeStats
+	unsupported     *parseutils.UnsupportedReport
+	maintenance     *parseutils.MaintenanceSummary
+	snapshots       []parseutils.DeviceStateSnapshot
+	finalState      *parseutils.DeviceStateSnapshot
+	periodic        []parseutils.PeriodicPattern
+	standby         []parseutils.AppStandby
+	coverage        []parseutils.MetricCoverage
+	tempAlerts      []parseutils.ChargingTemperatureAlert
+	cycles          *parseutils.CycleSummary
+	immortal        []parseutils.ImmortalWakelock
+	levelSteps      map[string]parseutils.LevelStep
 }
 
 type checkinData struct {
@@ -176,11 +324,26 @@ type ParsedData struct {
 	// Error if kernel trace file could not be saved.
 	kernelSaveErr error
 	deviceType    string
+	// blocks are the response blocks requested by the client, nil if all blocks are returned.
+	blocks map[string]bool
+	// csvFilter is the filter of the metrics in the returned CSVs requested by the client, nil if not filtered.
+	csvFilter *csv.MetricFilter
 
 	responseArr []uploadResponse
 	kd          *csvData
 	md          *csvData
+	mk          *csvData
+	pr          *csvData
 	data        []presenter.HTMLData
+
+	// deviceRecords are the KPIs of the analyzed bug reports, keyed by device key.
+	deviceRecords map[string]DeviceRecord
+	// uploads is the storage key of the uploaded files, see uploadsKey. Empty if no store is set.
+	uploads string
+	// progress tracks the progress of the analysis for the client, nil if not requested.
+	progress *progress
+	// parsers tracks the parser goroutines, which can outlive a timed out analysis until they notice it.
+	parsers sync.WaitGroup
 }
 
 // BatteryStatsInfo holds the extracted batterystats details for a bugreport.
@@ -201,27 +364,46 @@ func (pd *ParsedData) Cleanup() {
 	}
 }
 
+// afterParsers calls f in the background once all the parser goroutines have exited.
+func (pd *ParsedData) afterParsers(f func()) {
+	go func() {
+		pd.parsers.Wait()
+		f()
+	}()
+}
+
 // SendAsJSON creates and sends the HTML output and json response from the ParsedData.
 func (pd *ParsedData) SendAsJSON(w http.ResponseWriter, r *http.Request) {
-	if err := pd.processKernelTrace(); err != nil {
+	b, err := pd.responseJSON()
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, r, b)
+}
+
+// responseJSON creates the HTML output and returns the json response from the ParsedData.
+func (pd *ParsedData) responseJSON() ([]byte, error) {
+	if err := pd.processKernelTrace(); err != nil {
+		return nil, err
+	}
 	// Append any parsed kernel or power monitor CSVs to the Historian V2 CSV.
 	if err := pd.appendCSVs(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	pd.filterCSVs()
 
 	var buf bytes.Buffer
 	var merge presenter.MultiFileHTMLData
 	if len(pd.data) == numberOfFilesToCompare {
 		merge = presenter.MultiFileData(pd.data)
-		if err := compareTempl.Execute(&buf, merge); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// The HTML is only rendered if requested, but the combined checkin is always returned.
+		if pd.wants(htmlBlock) {
+			if err := compareTempl.Execute(&buf, merge); err != nil {
+				return nil, err
+			}
 		}
-	} else {
+	} else if pd.wants(htmlBlock) {
 		if pd.brSaveErr != nil {
 			pd.data[0].Error = strings.Join([]string{pd.data[0].Error, pd.brSaveErr.Error()}, "\n")
 		}
@@ -229,33 +411,45 @@ func (pd *ParsedData) SendAsJSON(w http.ResponseWriter, r *http.Request) {
 			pd.data[0].Error = strings.Join([]string{pd.data[0].Error, pd.kernelSaveErr.Error()}, "\n")
 		}
 		if err := resultTempl.Execute(&buf, pd.data[0]); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, err
 		}
 	}
-	unzipped, err := json.Marshal(uploadResponseCompare{
-		UploadResponse:  pd.responseArr,
+	var responses interface{} = pd.responseArr
+	if pd.blocks != nil {
+		filtered, err := filterBlocks(pd.responseArr, pd.blocks)
+		if err != nil {
+			return nil, err
+		}
+		responses = filtered
+	}
+	return json.Marshal(uploadResponseCompare{
+		UploadResponse:  responses,
 		HTML:            buf.String(),
 		UsingComparison: (len(pd.data) == numberOfFilesToCompare),
 		CombinedCheckin: merge.CombinedCheckinData,
 		SystemUIDecoder: activity.Decoder(),
 	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+}
+
+// writeJSON sends the json response, compressed with the encoding most preferred by the requester.
+// zstd is only offered if the zstd tool is installed.
+func writeJSON(w http.ResponseWriter, r *http.Request, unzipped []byte) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
 
-	// Gzip data if it's accepted by the requester.
-	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-		gzipped, err := historianutils.GzipCompress(unzipped)
+	offered := []string{historianutils.EncodingGzip}
+	if zstdResponses {
+		offered = []string{historianutils.EncodingZstd, historianutils.EncodingGzip}
+	}
+	if enc := historianutils.NegotiateEncoding(r.Header.Get("Accept-Encoding"), offered...); enc != "" {
+		compressed, err := historianutils.Compress(unzipped, enc)
 		if err == nil {
-			w.Header().Add("Content-Encoding", "gzip")
-			w.Write(gzipped)
+			w.Header().Add("Content-Encoding", enc)
+			w.Write(compressed)
 			return
 		}
-		// Send ungzipped data.
-		log.Printf("failed to gzip data: %v", err)
+		// Send uncompressed data.
+		log.Printf("failed to %s compress data: %v", enc, err)
 	}
 	w.Write(unzipped)
 }
@@ -289,7 +483,8 @@ func (pd *ParsedData) Data() []presenter.HTMLData {
 	return pd.data
 }
 
-// appendCSVs adds the parsed kernel and/or power monitor CSVs to the HistorianV2Logs slice.
+// appendCSVs adds the parsed kernel, power monitor and/or power rails CSVs to the HistorianV2Logs
+// slice, and the markers to the battery history CSV.
 func (pd *ParsedData) appendCSVs() error {
 	// Need to append the kernel and power monitor CSV entries to the end of the existing CSV.
 	if pd.kd != nil {
@@ -312,12 +507,82 @@ func (pd *ParsedData) appendCSVs() error {
 		}
 		pd.responseArr[0].DisplayPowerMonitor = true
 		// Need to append the power monitor CSV entries to the end of the existing CSV.
-		pd.responseArr[0].HistorianV2Logs = append(pd.responseArr[0].HistorianV2Logs, historianV2Log{Source: powerMonitorLog, CSV: pd.md.csv})
+		pd.responseArr[0].HistorianV2Logs = append(pd.responseArr[0].HistorianV2Logs, historianV2Log{Source: powerMonitorLog, CSV: pd.md.csv, Downsampled: downsample(pd.md.csv, nil)})
 		pd.data[0].Error += historianutils.ErrorsToString(pd.md.errs)
 	}
+
+	if pd.mk != nil {
+		if len(pd.data) == 0 {
+			return errors.New("no bug report found for the provided markers file")
+		}
+		if len(pd.data) > 1 {
+			return errors.New("markers file uploaded with more than one bug report")
+		}
+		logs := pd.responseArr[0].HistorianV2Logs
+		for i := range logs {
+			if logs[i].Source == batteryHistory {
+				logs[i].CSV += pd.mk.csv
+				logs[i].Downsampled = downsample(logs[i].CSV, denseHistoryMetrics)
+			}
+		}
+		pd.data[0].Error += historianutils.ErrorsToString(pd.mk.errs)
+	}
+
+	if pd.pr != nil {
+		if len(pd.data) == 0 {
+			return errors.New("no bug report found for the provided power rails file")
+		}
+		if len(pd.data) > 1 {
+			return errors.New("power rails file uploaded with more than one bug report")
+		}
+		logs := pd.responseArr[0].HistorianV2Logs
+		for _, l := range logs {
+			if l.Source == batteryHistory {
+				correlations, errs := powerrails.Correlate(pd.pr.csv, l.CSV)
+				pd.data[0].PowerRailCorrelations = correlations
+				pd.pr.errs = append(pd.pr.errs, errs...)
+			}
+		}
+		pd.responseArr[0].HistorianV2Logs = append(logs, historianV2Log{Source: powerRailsLog, CSV: pd.pr.csv, Downsampled: downsample(pd.pr.csv, nil)})
+		pd.data[0].Error += historianutils.ErrorsToString(pd.pr.errs)
+	}
 	return nil
 }
 
+// filterCSVs drops the metrics not allowed by the filter set with SetCSVMetricFilter, or by the
+// client's CSV metric filter, from the Historian V2 CSVs, and the metrics not allowed by the filter set
+// with SetCSVMetricFilter from the intervals. It's only done once all the analyses derived from the
+// CSVs are done, so that they aren't missing any metric.
+func (pd *ParsedData) filterCSVs() {
+	for _, f := range []*csv.MetricFilter{csvMetricFilter, pd.csvFilter} {
+		if f == nil {
+			continue
+		}
+		for i := range pd.responseArr {
+			logs := pd.responseArr[i].HistorianV2Logs
+			for j := range logs {
+				var errs []error
+				logs[j].CSV, errs = csv.FilterMetrics(logs[j].CSV, f)
+				for k := range logs[j].Downsampled {
+					var dErrs []error
+					logs[j].Downsampled[k].CSV, dErrs = csv.FilterMetrics(logs[j].Downsampled[k].CSV, f)
+					errs = append(errs, dErrs...)
+				}
+				if len(errs) > 0 {
+					log.Printf("dropped %d invalid rows filtering the %s CSV: %v", len(errs), logs[j].Source, errs[0])
+				}
+			}
+		}
+	}
+	for i := range pd.responseArr {
+		for m := range pd.responseArr[i].Intervals {
+			if !csvMetricFilter.Allows(m) {
+				delete(pd.responseArr[i].Intervals, m)
+			}
+		}
+	}
+}
+
 // parseKernelFile processes the kernel file and stores the result in the ParsedData.
 func (pd *ParsedData) parseKernelFile(fname, contents string) error {
 	// Try to parse the file as a kernel file.
@@ -337,6 +602,24 @@ func (pd *ParsedData) parsePowerMonitorFile(fname, contents string) error {
 	return fmt.Errorf("%v: invalid power monitor file", fname)
 }
 
+// parseMarkersFile processes the markers file and stores the result in the ParsedData.
+func (pd *ParsedData) parseMarkersFile(fname, contents string) error {
+	if valid, output, extraErrs := markers.Parse(contents); valid {
+		pd.mk = &csvData{output, extraErrs}
+		return nil
+	}
+	return fmt.Errorf("%v: invalid markers file", fname)
+}
+
+// parsePowerRailsFile processes the power rails file and stores the result in the ParsedData.
+func (pd *ParsedData) parsePowerRailsFile(fname, contents string) error {
+	if valid, output, extraErrs := powerrails.Parse(contents); valid {
+		pd.pr = &csvData{output, extraErrs}
+		return nil
+	}
+	return fmt.Errorf("%v: invalid power rails file", fname)
+}
+
 // templatePath expands a template filename into a full resource path for that template.
 func templatePath(dir, tmpl string) string {
 	if len(dir) == 0 {
@@ -412,6 +695,103 @@ func SetResVersion(v int) {
 	resVersion = v
 }
 
+// SetStore sets the store that uploaded files and cached analyses are persisted to.
+func SetStore(s storage.Store) {
+	store = s
+}
+
+// SetMaxUnknownPercent enables strict mode, where the analysis of a report fails if more than
+// the given percentage of history lines contain unknown event codes. A non positive value disables it.
+func SetMaxUnknownPercent(p float64) {
+	maxUnknownPercent = p
+}
+
+// SetProfileNames sets whether apps of secondary users, such as work profiles, are kept separate
+// from the primary user's apps in the summaries and CSV, e.g. as "com.google.android.gm (work)".
+func SetProfileNames(keep bool) {
+	profileNames = keep
+}
+
+// SetRedactions sets the apps anonymized in the analyses of the reports, whose package names are
+// replaced by parseutils.Redacted everywhere in the bug reports before they are parsed.
+func SetRedactions(r *parseutils.Redactions) {
+	redactions = r
+}
+
+// SetLanguage sets the language of the warnings in the analyses, with messages of other languages
+// than the default language loaded with messages.LoadCatalog.
+func SetLanguage(lang string) {
+	language = lang
+}
+
+// SetSnapshotInterval sets how often, in history time, a snapshot of the device state is captured
+// into the analysis response. A non positive interval disables snapshots.
+func SetSnapshotInterval(d time.Duration) {
+	snapshotInterval = d
+}
+
+// SetSummaryTopN sets the number of entries kept in each per app or per state summary breakdown,
+// with the remaining entries rolled up into parseutils.OthersKey. A non positive n keeps all entries.
+func SetSummaryTopN(n int) {
+	summaryTopN = n
+}
+
+// SetSummarizeCharging sets whether charging periods are summarized too, in summaries labelled as
+// charging, rather than only the discharge intervals.
+func SetSummarizeCharging(summarize bool) {
+	summarizeCharging = summarize
+}
+
+// SetScreenMergeGap sets the longest time the screen can be off between two screen ons for them to
+// be counted as a single screen on session.
+func SetScreenMergeGap(d time.Duration) {
+	screenMergeGap = d
+}
+
+// SetMobileRadioTail sets the time the mobile radio is modeled to stay active after each app network
+// burst, for the radio cost attribution.
+func SetMobileRadioTail(d time.Duration) {
+	mobileRadioTail = d
+}
+
+// SetChargingDebounce sets the longest time a charging status change can be reverted within for it
+// to be taken for charger flapping, which doesn't split the summaries. Disabled if not positive.
+func SetChargingDebounce(d time.Duration) {
+	chargingDebounce = d
+}
+
+// SetCSVMetricFilter sets the metrics returned in the CSVs and intervals of all analyses, e.g. to drop
+// the series carrying service names. The summaries and series derived from the battery history are
+// still computed from all metrics. Clients can filter the metrics further with the csv_allow and
+// csv_deny query parameters. A nil filter returns all metrics.
+func SetCSVMetricFilter(f *csv.MetricFilter) {
+	csvMetricFilter = f
+}
+
+// SetMaxFileSize sets the maximum size in bytes of an upload, and of its decompressed contents.
+func SetMaxFileSize(n int64) {
+	maxFileSize = n
+	historianutils.SetMaxDecompressedSize(n)
+}
+
+// SetMaxHistoryLines sets the maximum number of battery history lines in an uploaded bug report.
+// Bug reports with more lines are rejected before being analyzed. A non positive value disables the limit.
+func SetMaxHistoryLines(n int) {
+	maxHistoryLines = n
+}
+
+// SetMaxConcurrentPerIP sets the maximum number of analyses in progress for a client IP. Further
+// uploads from the IP are rejected until one finishes. A non positive value disables the limit.
+func SetMaxConcurrentPerIP(n int) {
+	maxConcurrentPerIP = n
+}
+
+// SetAnalysisTimeout sets how long the analysis of a bug report can take. The results of the parsers
+// that didn't finish in time are omitted from the response. A non positive value disables the timeout.
+func SetAnalysisTimeout(d time.Duration) {
+	analysisTimeout = d
+}
+
 // SetIsOptimized sets whether the JS will be optimized.
 func SetIsOptimized(optimized bool) {
 	isOptimizedJs = optimized
@@ -450,11 +830,20 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 
 // HTTPAnalyzeHandler processes the bugreport package uploaded via an http request's multipart body.
 func HTTPAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
-	// Do not accept files that are greater than 100 MBs.
+	// Do not accept files that are greater than the maximum file size.
 	if r.ContentLength > maxFileSize {
-		closeConnection(w, "File too large (>100MB).")
+		closeConnection(w, fmt.Sprintf("File too large (>%dMB).", maxFileSize/(1024*1024)))
 		return
 	}
+	ip := clientIP(r)
+	if !analyses.acquire(ip, maxConcurrentPerIP) {
+		http.Error(w, "Too many analyses in progress. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+	release := func() { analyses.release(ip) }
+	defer func() { release() }()
+	progressID := r.URL.Query().Get("progress")
+	defer finishProgress(progressID, startProgress(progressID))
 	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
 	log.Printf("Trace starting reading uploaded file. %d bytes", r.ContentLength)
 	defer log.Printf("Trace ended analyzing file.")
@@ -521,6 +910,20 @@ func HTTPAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 					fname = n
 					break contentLoop
 				}
+			case "markers":
+				if markers.IsValid(f) {
+					valid = true
+					contents = f
+					fname = n
+					break contentLoop
+				}
+			case "powerrails":
+				if powerrails.IsValid(f) {
+					valid = true
+					contents = f
+					fname = n
+					break contentLoop
+				}
 			default:
 				valid = true
 				contents = f
@@ -536,22 +939,178 @@ func HTTPAnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 
 		fs[part.FormName()] = UploadedFile{part.FormName(), fname, contents}
 	}
-	AnalyzeAndResponse(w, r, fs)
+	if pd := analyzeAndResponse(w, r, fs); pd != nil {
+		// The parsers of a timed out analysis are still running, so the IP keeps the analysis until they exit.
+		pd.afterParsers(release)
+		release = func() {}
+	}
+}
+
+// uploadsKey returns the storage key prefix for the given uploaded files, which is derived from their
+// contents so that uploading the same files again from any server instance maps to the same key.
+func uploadsKey(files map[string]UploadedFile) string {
+	var types []string
+	for t := range files {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	h := sha256.New()
+	for _, t := range types {
+		fmt.Fprintf(h, "%s\x00%d\x00", t, len(files[t].Contents))
+		h.Write(files[t].Contents)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// analysisKey returns the storage key of the cached analysis of the uploaded files with the given key.
+// The key depends on the JS and CSS version, so that cached analyses aren't served to incompatible frontends,
+// and on the strict mode threshold, profile names option, snapshot interval, summary top N, charging summaries, screen session merge gap,
+// mobile radio tail, charging debounce, redacted apps, requested blocks and CSV metric filters, which change the result of the analysis.
+func analysisKey(uploads string, blocks map[string]bool, filter *csv.MetricFilter) string {
+	dir := fmt.Sprintf("analyses/v%d", resVersion)
+	if maxUnknownPercent > 0 {
+		dir = fmt.Sprintf("%s/strict%g", dir, maxUnknownPercent)
+	}
+	if profileNames {
+		dir += "/profiles"
+	}
+	if snapshotInterval > 0 {
+		dir = fmt.Sprintf("%s/snapshots%v", dir, snapshotInterval)
+	}
+	if summaryTopN > 0 {
+		dir = fmt.Sprintf("%s/top%d", dir, summaryTopN)
+	}
+	if summarizeCharging {
+		dir += "/charging"
+	}
+	if screenMergeGap != parseutils.DefaultScreenMergeGap {
+		dir = fmt.Sprintf("%s/screengap%v", dir, screenMergeGap)
+	}
+	if mobileRadioTail != parseutils.DefaultMobileRadioTail {
+		dir = fmt.Sprintf("%s/radiotail%v", dir, mobileRadioTail)
+	}
+	if chargingDebounce != parseutils.DefaultChargingDebounce {
+		dir = fmt.Sprintf("%s/debounce%v", dir, chargingDebounce)
+	}
+	if k := redactions.Key(); k != "" {
+		dir += "/redact/" + k
+	}
+	if language != messages.DefaultLanguage {
+		dir += "/lang/" + language
+	}
+	if k := blocksKey(blocks); k != "" {
+		dir += "/blocks/" + k
+	}
+	if k := csvMetricFilter.Key(); k != "" {
+		dir += "/metrics/" + k
+	}
+	if k := filter.Key(); k != "" {
+		dir += "/request_metrics/" + k
+	}
+	return fmt.Sprintf("%s/%s.json", dir, uploads)
+}
+
+// storeUploads saves the uploaded files to the store, so they can be retrieved later from any server instance.
+func storeUploads(uploads string, files map[string]UploadedFile) {
+	for t, f := range files {
+		if err := store.Put(path.Join("uploads", uploads, t), f.Contents); err != nil {
+			log.Printf("failed to store uploaded %s file %s: %v", t, f.FileName, err)
+		}
+	}
 }
 
 // AnalyzeAndResponse analyzes the uploaded files and sends the HTTP response in JSON.
+// If a store is set, the uploaded files are saved and the analysis is cached in it.
+// Clients can request only some blocks of the response with the blocks query parameter or Accept header
+// parameter, in which case the analyses only needed for the other blocks are skipped. If the
+// summaries_only query parameter is true, the timelines are left out, which takes much less memory for
+// clients such as batch pipelines computing KPIs.
+// The csv_allow and csv_deny query parameters are comma separated lists of the only metrics kept in,
+// and the metrics dropped from, the returned CSVs, on top of the filter set with SetCSVMetricFilter.
+// If the progress query parameter is set, the progress of the analysis can be polled with
+// ProgressHandler under that ID while the request is pending.
 func AnalyzeAndResponse(w http.ResponseWriter, r *http.Request, files map[string]UploadedFile) {
-	pd := &ParsedData{}
+	analyzeAndResponse(w, r, files)
+}
+
+// analyzeAndResponse is the same as AnalyzeAndResponse, and returns the data of the analysis, or nil
+// if no analysis was started, e.g. when a cached analysis was served.
+func analyzeAndResponse(w http.ResponseWriter, r *http.Request, files map[string]UploadedFile) *ParsedData {
+	progressID := r.URL.Query().Get("progress")
+	p := startProgress(progressID)
+	defer finishProgress(progressID, p)
+	blocks, err := requestedBlocks(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	filter := csv.NewMetricFilter(csv.SplitMetrics(r.URL.Query().Get("csv_allow")), csv.SplitMetrics(r.URL.Query().Get("csv_deny")))
+	var uploads string
+	if store != nil {
+		uploads = uploadsKey(files)
+		b, err := store.Get(analysisKey(uploads, blocks, filter))
+		switch err {
+		case nil:
+			log.Printf("Trace serving cached analysis %s", uploads)
+			writeJSON(w, r, b)
+			return nil
+		case storage.ErrNotFound:
+		default:
+			log.Printf("failed to get cached analysis %s: %v", uploads, err)
+		}
+		storeUploads(uploads, files)
+	}
+
+	pd := &ParsedData{blocks: blocks, csvFilter: filter, uploads: uploads, progress: p}
 	defer pd.Cleanup()
-	if err := pd.AnalyzeFiles(files); err != nil {
-		http.Error(w, fmt.Sprintf("failed to analyze file: %v", err), http.StatusInternalServerError)
-		return
+	if err := pd.AnalyzeFilesContext(r.Context(), files); err != nil {
+		if r.Context().Err() != nil {
+			// The client is gone, so there's no one to send the error to.
+			log.Printf("Trace analysis canceled: %v", err)
+			return pd
+		}
+		code := http.StatusInternalServerError
+		if _, ok := err.(*LimitError); ok {
+			code = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, fmt.Sprintf("failed to analyze file: %v", err), code)
+		return pd
+	}
+	p.setStage(stageResponse)
+	b, err := pd.responseJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return pd
+	}
+	if store != nil && !pd.partial() {
+		if err := store.Put(analysisKey(uploads, blocks, filter), b); err != nil {
+			log.Printf("failed to cache analysis %s: %v", uploads, err)
+		}
+		pd.storeDeviceRecords(uploads)
 	}
-	pd.SendAsJSON(w, r)
+	writeJSON(w, r, b)
+	return pd
+}
+
+// partial returns whether any results were omitted because the analysis timed out.
+// Partial analyses aren't cached, so that the full results are computed for the next request.
+func (pd *ParsedData) partial() bool {
+	for _, r := range pd.responseArr {
+		if len(r.TimedOut) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 // AnalyzeFiles processes and analyzes the list of uploaded files.
 func (pd *ParsedData) AnalyzeFiles(files map[string]UploadedFile) error {
+	return pd.AnalyzeFilesContext(context.Background(), files)
+}
+
+// AnalyzeFilesContext is the same as AnalyzeFiles, but stops the analysis and returns the context
+// error once the context is done, e.g. when the client that uploaded the files disconnects.
+func (pd *ParsedData) AnalyzeFilesContext(ctx context.Context, files map[string]UploadedFile) error {
 	fB, okB := files[bugreportFT]
 	if !okB {
 		return errors.New("missing bugreport file")
@@ -559,12 +1118,19 @@ func (pd *ParsedData) AnalyzeFiles(files map[string]UploadedFile) error {
 
 	// Parse the bugreport.
 	fB2 := files[bugreport2FT]
-	if err := pd.parseBugReport(fB.FileName, string(fB.Contents), fB2.FileName, string(fB2.Contents)); err != nil {
+	for _, f := range []UploadedFile{fB, fB2} {
+		if err := checkHistoryLines(f.FileName, string(f.Contents)); err != nil {
+			return err
+		}
+	}
+	// The redacted package names are scrubbed from the bug reports, so that none of the parsers emit them.
+	contentsA, contentsB := redactions.RedactBugReport(string(fB.Contents)), redactions.RedactBugReport(string(fB2.Contents))
+	if err := pd.parseBugReport(ctx, fB.FileName, contentsA, fB2.FileName, contentsB); err != nil {
 		return fmt.Errorf("error parsing bugreport: %v", err)
 	}
 	// Write the bug report to a file in case we need it to process a kernel trace file.
 	if len(pd.data) < numberOfFilesToCompare {
-		tmpFile, err := writeTempFile(string(fB.Contents))
+		tmpFile, err := writeTempFile(contentsA)
 		if err != nil {
 			return fmt.Errorf("could not write bugreport: %v", err)
 		}
@@ -591,6 +1157,16 @@ func (pd *ParsedData) AnalyzeFiles(files map[string]UploadedFile) error {
 			return fmt.Errorf("error parsing power monitor file: %v", err)
 		}
 	}
+	if file, ok := files[markersFT]; ok {
+		if err := pd.parseMarkersFile(file.FileName, string(file.Contents)); err != nil {
+			return fmt.Errorf("error parsing markers file: %v", err)
+		}
+	}
+	if file, ok := files[powerRailsFT]; ok {
+		if err := pd.parsePowerRailsFile(file.FileName, string(file.Contents)); err != nil {
+			return fmt.Errorf("error parsing power rails file: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -656,24 +1232,36 @@ func writeTempFile(contents string) (string, error) {
 // contentsB is an optional second bug report. If it's given and the Android IDs and batterystats
 // checkin start times are the same, a diff of the checkins will be saved, otherwise, they will be
 // saved as separate reports.
-func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string) error {
+// The parsers are stopped when the context is done, and the context error is returned.
+func (pd *ParsedData) parseBugReport(ctx context.Context, fnameA, contentsA, fnameB, contentsB string) error {
 
-	doActivity := func(ch chan activity.LogsData, contents string, pkgs []*usagepb.PackageInfo) {
-		ch <- activity.Parse(pkgs, contents)
+	// The parsers don't send incomplete results once the context is done, so they're reported as timed out.
+	doActivity := func(ctx context.Context, ch chan activity.LogsData, contents string, pkgs []*usagepb.PackageInfo) {
+		d := activity.ParseContext(ctx, pkgs, contents)
+		if ctx.Err() != nil {
+			return
+		}
+		ch <- d
 	}
 
-	doBroadcasts := func(ch chan csvData, contents string) {
-		csv, errs := broadcasts.Parse(contents)
+	doBroadcasts := func(ctx context.Context, ch chan csvData, contents string) {
+		csv, errs := broadcasts.ParseContext(ctx, contents)
+		if ctx.Err() != nil {
+			return
+		}
 		ch <- csvData{csv: csv, errs: errs}
 	}
 
-	doCheckin := func(ch chan checkinData, meta *bugreportutils.MetaInfo, bs string, pkgs []*usagepb.PackageInfo) {
+	doCheckin := func(ctx context.Context, ch chan checkinData, meta *bugreportutils.MetaInfo, bs string, pkgs []*usagepb.PackageInfo) {
 		var ctr checkinutil.IntCounter
 		s := &sessionpb.Checkin{
 			Checkin:          proto.String(bs),
 			BuildFingerprint: proto.String(meta.BuildFingerprint),
 		}
-		stats, warnings, errs := checkinparse.ParseBatteryStats(&ctr, checkinparse.CreateBatteryReport(s), pkgs)
+		stats, warnings, errs := checkinparse.ParseBatteryStatsContext(ctx, &ctr, checkinparse.CreateBatteryReport(s), pkgs)
+		if ctx.Err() != nil {
+			return
+		}
 		if stats == nil {
 			errs = append(errs, errors.New("could not parse aggregated battery stats"))
 		} else {
@@ -683,11 +1271,15 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 		log.Printf("Trace finished processing checkin.")
 	}
 
-	doDmesg := func(ch chan dmesg.Data, contents string) {
-		ch <- dmesg.Parse(contents)
+	doDmesg := func(ctx context.Context, ch chan dmesg.Data, contents string) {
+		d := dmesg.ParseContext(ctx, contents)
+		if ctx.Err() != nil {
+			return
+		}
+		ch <- d
 	}
 
-	doHistorian := func(ch chan historianData, fname, contents string) {
+	doHistorian := func(ctx context.Context, ch chan historianData, fname, contents string) {
 		// Create a temporary file to save the bug report, for the Historian script.
 		brFile, err := writeTempFile(contents)
 		if err != nil {
@@ -696,19 +1288,32 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 		}
 		// Don't run the Historian script if it could not create temporary file.
 		defer os.Remove(brFile)
-		html, err := generateHistorianPlot(fname, brFile)
+		html, err := generateHistorianPlot(ctx, fname, brFile)
+		if ctx.Err() != nil {
+			// The script was killed, so the plot is reported as timed out rather than failed.
+			return
+		}
 		ch <- historianData{html, err}
 		log.Printf("Trace finished generating Historian plot.")
 	}
 
 	// bs is the batterystats section of the bug report
-	doSummaries := func(ch chan summariesData, bs string, pkgs []*usagepb.PackageInfo) {
-		ch <- analyze(bs, pkgs)
+	doSummaries := func(ctx context.Context, ch chan summariesData, fname, bs, model string, pkgs []*usagepb.PackageInfo) {
+		d := analyze(ctx, bs, model, pkgs, !pd.wants(timelineBlocks...), pd.wants("levelSteps"), pd.progress.historyLines(fname))
+		if ctx.Err() != nil {
+			// The analysis was stopped, so the results are incomplete and reported as timed out.
+			return
+		}
+		ch <- d
 		log.Printf("Trace finished processing summary data.")
 	}
 
-	doWearable := func(ch chan string, loc, contents string) {
-		if valid, output, _ := wearable.Parse(contents, loc); valid {
+	doWearable := func(ctx context.Context, ch chan string, loc, contents string) {
+		valid, output, _ := wearable.ParseContext(ctx, contents, loc)
+		if ctx.Err() != nil {
+			return
+		}
+		if valid {
 			ch <- output
 		} else {
 			ch <- ""
@@ -784,12 +1389,15 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 		}
 
 		// Generate the Historian plot and Volta parsing simultaneously.
-		historianCh := make(chan historianData)
-		summariesCh := make(chan summariesData)
-		activityManagerCh := make(chan activity.LogsData)
-		broadcastsCh := make(chan csvData)
-		dmesgCh := make(chan dmesg.Data)
-		wearableCh := make(chan string)
+		// The channels are buffered so that parsers still running after the analysis timed out can finish.
+		historianCh := make(chan historianData, 1)
+		summariesCh := make(chan summariesData, 1)
+		activityManagerCh := make(chan activity.LogsData, 1)
+		broadcastsCh := make(chan csvData, 1)
+		dmesgCh := make(chan dmesg.Data, 1)
+		wearableCh := make(chan string, 1)
+		checkinECh := make(chan checkinData, 1)
+		checkinLCh := make(chan checkinData, 1)
 		var checkinL, checkinE checkinData
 		var warnings []string
 		var bsStats *bspb.BatteryStats
@@ -798,25 +1406,48 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 
 		ce := ""
 
+		// The timeout only applies to the parsers, so that a timed out analysis can still return partial results.
+		parsersCtx := ctx
+		if analysisTimeout > 0 {
+			var cancel context.CancelFunc
+			parsersCtx, cancel = context.WithTimeout(ctx, analysisTimeout)
+			defer cancel()
+		}
+		var parsers sync.WaitGroup
+		run := func(f func()) {
+			parsers.Add(1)
+			pd.parsers.Add(1)
+			go func() {
+				defer pd.parsers.Done()
+				defer parsers.Done()
+				f()
+			}()
+		}
+
 		// Only need to generate it for the later report.
-		go doHistorian(historianCh, late.fileName, late.contents)
+		if !pd.wants(htmlBlock) {
+			historianCh <- historianData{}
+		} else {
+			run(func() { doHistorian(parsersCtx, historianCh, late.fileName, late.contents) })
+		}
+		var bsL string
+		var pkgsL []*usagepb.PackageInfo
 		if !supV {
 			ce = "Unsupported bug report version."
 			errs = append(errs, errors.New("unsupported bug report version"))
 		} else {
 			// No point running these if we don't support the sdk version since we won't get any data from them.
 
-			bsL := bugreportutils.ExtractBatterystatsCheckin(late.contents)
+			bsL = bugreportutils.ExtractBatterystatsCheckin(late.contents)
 			if strings.Contains(bsL, "Exception occurred while dumping") {
 				ce = "Exception found in battery dump."
 				errs = append(errs, errors.New("exception found in battery dump"))
 			}
 
-			pkgsL, pkgErrs := packageutils.ExtractAppsFromBugReport(late.contents)
+			var pkgErrs []error
+			pkgsL, pkgErrs = packageutils.ExtractAppsFromBugReport(late.contents)
 			errs = append(errs, pkgErrs...)
-			checkinECh := make(chan checkinData)
-			checkinLCh := make(chan checkinData)
-			go doCheckin(checkinLCh, late.meta, bsL, pkgsL)
+			run(func() { doCheckin(parsersCtx, checkinLCh, late.meta, bsL, pkgsL) })
 			if diff {
 				// Calculate batterystats for the earlier report.
 				bsE := bugreportutils.ExtractBatterystatsCheckin(earl.contents)
@@ -826,36 +1457,47 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 				}
 				pkgsE, pkgErrs := packageutils.ExtractAppsFromBugReport(earl.contents)
 				errs = append(errs, pkgErrs...)
-				go doCheckin(checkinECh, earl.meta, bsE, pkgsE)
+				run(func() { doCheckin(parsersCtx, checkinECh, earl.meta, bsE, pkgsE) })
 			}
 
 			// These are only parsed for supported sdk versions, even though they are still
 			// present in unsupported sdk version reports, because the events are rendered
 			// with Historian v2, which is not generated for unsupported sdk versions.
-			go doActivity(activityManagerCh, late.contents, pkgsL)
-			go doBroadcasts(broadcastsCh, late.contents)
-			go doDmesg(dmesgCh, late.contents)
-			go doWearable(wearableCh, late.dt.Location().String(), late.contents)
-			go doSummaries(summariesCh, bsL, pkgsL)
-
-			checkinL = <-checkinLCh
-			errs = append(errs, checkinL.err...)
-			warnings = append(warnings, checkinL.warnings...)
-			if diff {
-				checkinE = <-checkinECh
-				errs = append(errs, checkinE.err...)
-				warnings = append(warnings, checkinE.warnings...)
+			if !pd.wants(timelineBlocks...) {
+				// These only generate timelines.
+				activityManagerCh <- activity.LogsData{}
+				broadcastsCh <- csvData{}
+				dmesgCh <- dmesg.Data{}
+				wearableCh <- ""
+			} else {
+				run(func() { doActivity(parsersCtx, activityManagerCh, late.contents, pkgsL) })
+				run(func() { doBroadcasts(parsersCtx, broadcastsCh, late.contents) })
+				run(func() { doDmesg(parsersCtx, dmesgCh, late.contents) })
+				run(func() { doWearable(parsersCtx, wearableCh, late.dt.Location().String(), late.contents) })
 			}
-			if checkinL.batterystats == nil || (diff && checkinE.batterystats == nil) {
-				ce = "Could not parse aggregated battery stats."
-			} else if diff {
-				bsStats = checkindelta.ComputeDeltaFromSameDevice(checkinL.batterystats, checkinE.batterystats)
+			if pd.wants(historyBlocks...) {
+				run(func() { doSummaries(parsersCtx, summariesCh, late.fileName, bsL, late.meta.ModelName, pkgsL) })
 			} else {
-				bsStats = checkinL.batterystats
+				summariesCh <- summariesData{}
 			}
 		}
 
-		historianOutput := <-historianCh
+		// If the analysis times out, the results of the parsers that haven't finished are omitted.
+		var timedOut []string
+		if !waitDone(parsersCtx, &parsers) {
+			if ctx.Err() != nil {
+				log.Printf("Trace analysis of %q canceled.", late.fileName)
+				return
+			}
+			log.Printf("Trace analysis of %q timed out after %v.", late.fileName, analysisTimeout)
+		}
+		var historianOutput historianData
+		select {
+		case historianOutput = <-historianCh:
+		default:
+			timedOut = append(timedOut, "Historian plot")
+			historianOutput.err = errors.New("analysis timed out")
+		}
 		if historianOutput.err != nil {
 			historianOutput.html = fmt.Sprintf("Error generating historian plot: %v", historianOutput.err)
 		}
@@ -867,15 +1509,246 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 		var wearableOutput string
 
 		if supV {
-			summariesOutput = <-summariesCh
-			activityManagerOutput = <-activityManagerCh
-			broadcastsOutput = <-broadcastsCh
-			dmesgOutput = <-dmesgCh
-			wearableOutput = <-wearableCh
+			select {
+			case checkinL = <-checkinLCh:
+			default:
+				timedOut = append(timedOut, "aggregated battery stats")
+			}
+			errs = append(errs, checkinL.err...)
+			warnings = append(warnings, checkinL.warnings...)
+			if diff {
+				select {
+				case checkinE = <-checkinECh:
+				default:
+					timedOut = append(timedOut, "earlier aggregated battery stats")
+				}
+				errs = append(errs, checkinE.err...)
+				warnings = append(warnings, checkinE.warnings...)
+			}
+			if checkinL.batterystats == nil || (diff && checkinE.batterystats == nil) {
+				ce = messages.New(messages.CheckinUnparsed).In(language)
+			} else if diff {
+				bsStats = checkindelta.ComputeDeltaFromSameDevice(checkinL.batterystats, checkinE.batterystats)
+			} else {
+				bsStats = checkinL.batterystats
+			}
+
+			select {
+			case summariesOutput = <-summariesCh:
+			default:
+				timedOut = append(timedOut, "battery history")
+			}
+			select {
+			case activityManagerOutput = <-activityManagerCh:
+			default:
+				timedOut = append(timedOut, "logcat")
+			}
+			select {
+			case broadcastsOutput = <-broadcastsCh:
+			default:
+				timedOut = append(timedOut, "broadcasts")
+			}
+			select {
+			case dmesgOutput = <-dmesgCh:
+			default:
+				timedOut = append(timedOut, "kernel dmesg")
+			}
+			select {
+			case wearableOutput = <-wearableCh:
+			default:
+				timedOut = append(timedOut, "wearable logs")
+			}
+			if summariesOutput.unsupported != nil {
+				ce = messages.New(messages.UnsupportedReport).In(language)
+			}
 			errs = append(errs, append(broadcastsOutput.errs, append(dmesgOutput.Errs, append(summariesOutput.errs, activityManagerOutput.Errs...)...)...)...)
 		}
-
+		// The parsers below run one after the other on the results of the ones above. Once the analysis
+		// timed out, the remaining ones are skipped, so that the partial results are returned in time.
+		next := func(name string) bool {
+			if parsersCtx.Err() != nil {
+				timedOut = append(timedOut, name)
+				return false
+			}
+			return true
+		}
 		warnings = append(warnings, activityManagerOutput.Warnings...)
+		// The summaries aren't checked if the history wasn't analyzed.
+		if supV && !diff && pd.wants(historyBlocks...) && next("consistency checks") {
+			for _, d := range consistency.Check(summariesOutput.summaries, bsStats, consistency.DefaultThreshold) {
+				warnings = append(warnings, d.Message().In(language))
+			}
+			tr, trErrs := consistency.CheckTruncation(summariesOutput.historianV2CSV, bsStats)
+			errs = append(errs, trErrs...)
+			if tr != nil {
+				warnings = append(warnings, tr.Message().In(language))
+			}
+		}
+		var powerConfig *powermanager.Config
+		if next("power manager") {
+			var powerErrs []error
+			powerConfig, powerErrs = powermanager.Parse(late.contents)
+			errs = append(errs, powerErrs...)
+		}
+		var appErrors []apperrors.Event
+		if next("app errors") {
+			var appErrs []error
+			appErrors, appErrs = apperrors.Parse(late.contents, late.dt.Location())
+			errs = append(errs, appErrs...)
+		}
+		var crashLoops []apperrors.CrashLoop
+		if supV && next("crash loops") {
+			summariesOutput.historianV2CSV += apperrors.CSV(appErrors)
+			var loopErrs []error
+			crashLoops, loopErrs = apperrors.CrashLoops(appErrors, summariesOutput.historianV2CSV)
+			errs = append(errs, loopErrs...)
+		}
+		var audioApps []audio.AppSummary
+		if supV && next("audio focus") {
+			holders, focusErrs := audio.Parse(late.contents, late.dt)
+			errs = append(errs, focusErrs...)
+			// Without any audio focus events, all playback would be attributed to unknown.
+			if len(holders) > 0 {
+				audioCSV, apps, audioErrs := audio.Attribute(holders, summariesOutput.historianV2CSV)
+				errs = append(errs, audioErrs...)
+				summariesOutput.historianV2CSV += audioCSV
+				audioApps = apps
+			}
+		}
+		// The calls are read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("VoIP calls") {
+			calls, callErrs := audio.ParseVoIP(late.contents, late.dt)
+			errs = append(errs, callErrs...)
+			summariesOutput.historianV2CSV += audio.VoIPCSV(calls)
+			errs = append(errs, parseutils.AddCallSummaries(summariesOutput.historianV2CSV, summariesOutput.summaries)...)
+			// All the activity the drain can be attributed to, including the calls, is now in the timeline.
+			errs = append(errs, parseutils.AddUnattributedDrain(summariesOutput.historianV2CSV, summariesOutput.summaries)...)
+		}
+		var updates []sysupdate.Update
+		var updateDrain *sysupdate.Comparison
+		// The package installs and battery levels are read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("system updates") {
+			updateEvents, updateErrs := sysupdate.Parse(late.contents, late.dt)
+			errs = append(errs, updateErrs...)
+			updates, updateErrs = sysupdate.Updates(updateEvents, summariesOutput.historianV2CSV)
+			errs = append(errs, updateErrs...)
+			updateDrain, updateErrs = sysupdate.Compare(updates, summariesOutput.historianV2CSV)
+			errs = append(errs, updateErrs...)
+			summariesOutput.historianV2CSV += sysupdate.CSV(updates)
+		}
+		var gnss *parseutils.GNSSSummary
+		// The GPS on periods are read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("location requests") {
+			requests, reqErrs := location.Parse(late.contents, late.dt)
+			errs = append(errs, reqErrs...)
+			summariesOutput.historianV2CSV += location.CSV(requests)
+			var gnssErrs []error
+			gnss, gnssErrs = parseutils.GNSSUsage(summariesOutput.historianV2CSV)
+			errs = append(errs, gnssErrs...)
+		}
+		// The tethering sessions are added to the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("tethering") {
+			sessions, tetherErrs := tethering.Parse(late.contents, late.dt)
+			errs = append(errs, tetherErrs...)
+			summariesOutput.historianV2CSV += tethering.CSV(sessions)
+			errs = append(errs, parseutils.AddTetheringSummaries(summariesOutput.historianV2CSV, summariesOutput.summaries)...)
+		}
+		var parked []automotive.Session
+		// The parked sessions are added to the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("parked sessions") {
+			var parkedErrs []error
+			parked, parkedErrs = automotive.Parse(late.contents, late.dt)
+			errs = append(errs, parkedErrs...)
+			errs = append(errs, automotive.AddDrain(parked, summariesOutput.historianV2CSV)...)
+			summariesOutput.historianV2CSV += automotive.CSV(parked)
+		}
+		var tmpWhiteListNetwork []netstats.AppUsage
+		// The temporary whitelist grants are read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("network stats") {
+			buckets, netErrs := netstats.Parse(late.contents)
+			errs = append(errs, netErrs...)
+			grants, netErrs := netstats.Grants(buckets, summariesOutput.historianV2CSV)
+			errs = append(errs, netErrs...)
+			tmpWhiteListNetwork = netstats.ByApp(grants)
+		}
+		var bleScans []bluetooth.AppScans
+		// The BLE scanning time is read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("BLE scans") {
+			apps, btErrs := bluetooth.Parse(late.contents, pkgsL)
+			errs = append(errs, btErrs...)
+			bleScans, btErrs = bluetooth.Join(apps, summariesOutput.historianV2CSV)
+			errs = append(errs, btErrs...)
+		}
+		var unconstrainedJobs []jobscheduler.Summary
+		// The job runs are read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("jobs") {
+			jobs, jobErrs := jobscheduler.Parse(late.contents)
+			errs = append(errs, jobErrs...)
+			runs, jobErrs := jobscheduler.Unconstrained(jobs, summariesOutput.historianV2CSV)
+			errs = append(errs, jobErrs...)
+			summariesOutput.historianV2CSV += jobscheduler.CSV(runs)
+			unconstrainedJobs = jobscheduler.Summarize(runs)
+		}
+		var radio *telephony.Summary
+		// The phone state is read from the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("telephony") {
+			changes, radioErrs := telephony.Parse(late.contents, late.dt)
+			errs = append(errs, radioErrs...)
+			radio, radioErrs = telephony.Summarize(changes, summariesOutput.historianV2CSV, late.dt.UnixNano()/int64(time.Millisecond))
+			errs = append(errs, radioErrs...)
+			summariesOutput.historianV2CSV += telephony.CSV(radio)
+			// The history samples the signal strength sparsely, so the registry's levels are merged in.
+			signal, signalErrs := telephony.ParseSignalStrengths(late.contents, late.dt)
+			errs = append(errs, signalErrs...)
+			summariesOutput.historianV2CSV, signalErrs = telephony.DensifySignalStrength(summariesOutput.historianV2CSV, signal)
+			errs = append(errs, signalErrs...)
+		}
+		var activityDrain *parseutils.ActivityDrain
+		// The steps are added to the timeline, which is only generated for the timeline blocks.
+		if supV && pd.wants(timelineBlocks...) && next("steps") {
+			steps, stepErrs := bugreportutils.ParseStepCounts(late.contents, late.dt)
+			errs = append(errs, stepErrs...)
+			summariesOutput.historianV2CSV += parseutils.StepsCSV(steps)
+			activityDrain, stepErrs = parseutils.ActivityDrainRates(summariesOutput.historianV2CSV)
+			errs = append(errs, stepErrs...)
+		}
+		var heatmap *parseutils.Heatmap
+		if supV && pd.wants("heatmap") && next("heatmap") {
+			var heatmapErrs []error
+			heatmap, heatmapErrs = parseutils.DayHourHeatmap(summariesOutput.historianV2CSV, late.dt.Location())
+			errs = append(errs, heatmapErrs...)
+		}
+		var intervals map[string][]csv.Interval
+		if supV && pd.requested(intervalsBlock) && next("intervals") {
+			var intervalErrs []error
+			intervals, intervalErrs = csv.ExtractIntervals(summariesOutput.historianV2CSV, nil)
+			errs = append(errs, intervalErrs...)
+		}
+		var device string
+		var changes *ReportChanges
+		if late.meta.DeviceID != "" {
+			device = deviceKey(late.meta.DeviceID)
+			// The summaries aren't generated if no history block was requested.
+			if len(summariesOutput.summaries) > 0 {
+				if pd.deviceRecords == nil {
+					pd.deviceRecords = make(map[string]DeviceRecord)
+				}
+				pd.deviceRecords[device] = newDeviceRecord(late.dt, late.meta.BuildFingerprint, summariesOutput.summaries)
+				if store != nil && !diff {
+					changes = changesSinceLast(store, device, pd.uploads, pd.deviceRecords[device])
+				}
+			}
+		}
+		var rollUp *rollup.Row
+		if supV && pd.wants("rollUp") && len(summariesOutput.summaries) > 0 && next("roll-up") {
+			var rollUpErrs []error
+			rollUp, rollUpErrs = rollup.New(late.meta, summariesOutput.historianV2CSV, summariesOutput.summaries)
+			errs = append(errs, rollUpErrs...)
+		}
+		if len(timedOut) > 0 {
+			warnings = append(warnings, messages.New(messages.AnalysisTimedOut, analysisTimeout, strings.Join(timedOut, ", ")).In(language))
+		}
+		parseutils.TruncateSummaries(summariesOutput.summaries, summaryTopN)
 		fn := late.fileName
 		if diff {
 			fn = fmt.Sprintf("%s - %s", earl.fileName, late.fileName)
@@ -885,49 +1758,83 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 			bsStats, historianOutput.html,
 			warnings,
 			errs, summariesOutput.overflowMs > 0, true)
-
-		historianV2Logs := []historianV2Log{
-			{
-				Source: batteryHistory,
-				CSV:    summariesOutput.historianV2CSV,
-			},
-			{
-				Source: wearableLog,
-				CSV:    wearableOutput,
-			},
-			{
-				Source:  kernelDmesg,
-				CSV:     dmesgOutput.CSV,
-				StartMs: dmesgOutput.StartMs,
-			},
-			{
-				Source: broadcastsLog,
-				CSV:    broadcastsOutput.csv,
-			},
-		}
-		for s, l := range activityManagerOutput.Logs {
-			if l == nil {
-				log.Print("Nil logcat log received")
-				continue
+		data.ChargeStats = summariesOutput.chargeStats
+		data.ImmortalWakelocks = summariesOutput.immortal
+		data.ActivityDrain = activityDrain
+		data.ChargingTemperatureAlerts = summariesOutput.tempAlerts
+		data.ChargeCycles = summariesOutput.cycles
+		data.PowerConfig = powerConfig
+		data.BLEAdvertising = activityManagerOutput.BLEAdvertising
+		data.CrashLoops = crashLoops
+		data.MaintenanceWindows = summariesOutput.maintenance
+		data.AudioPlayback = audioApps
+		data.PeriodicWakeups = summariesOutput.periodic
+		data.AppStandby = summariesOutput.standby
+		data.MetricCoverage = summariesOutput.coverage
+		data.SystemUpdates = updates
+		data.UpdateDrain = updateDrain
+		data.GNSS = gnss
+		data.ParkedSessions = parked
+		data.ParkedTotals = automotive.Totals(parked)
+		data.TmpWhiteListNetwork = tmpWhiteListNetwork
+		data.BLEScans = bleScans
+		data.UnconstrainedJobs = unconstrainedJobs
+		data.Telephony = radio
+		data.FinalState = summariesOutput.finalState
+
+		var historianV2Logs []historianV2Log
+		if pd.wants("historianV2Logs") {
+			historianV2Logs = []historianV2Log{
+				{
+					Source:      batteryHistory,
+					CSV:         summariesOutput.historianV2CSV,
+					Downsampled: downsample(summariesOutput.historianV2CSV, denseHistoryMetrics),
+				},
+				{
+					Source: wearableLog,
+					CSV:    wearableOutput,
+				},
+				{
+					Source:  kernelDmesg,
+					CSV:     dmesgOutput.CSV,
+					StartMs: dmesgOutput.StartMs,
+				},
+				{
+					Source: broadcastsLog,
+					CSV:    broadcastsOutput.csv,
+				},
 			}
-			source := ""
-			switch s {
-			case activity.EventLogSection:
-				source = eventLog
-			case activity.SystemLogSection:
-				source = systemLog
-			case activity.LastLogcatSection:
-				source = lastLogcat
-			default:
-				log.Printf("Logcat section %q not handled", s)
-				// Show it anyway.
-				source = s
+			// Add the logcat sections in a fixed order, so the response is the same for the same bug report.
+			var sections []string
+			for s := range activityManagerOutput.Logs {
+				sections = append(sections, s)
+			}
+			sort.Strings(sections)
+			for _, s := range sections {
+				l := activityManagerOutput.Logs[s]
+				if l == nil {
+					log.Print("Nil logcat log received")
+					continue
+				}
+				source := ""
+				switch s {
+				case activity.EventLogSection:
+					source = eventLog
+				case activity.SystemLogSection:
+					source = systemLog
+				case activity.LastLogcatSection:
+					source = lastLogcat
+				default:
+					log.Printf("Logcat section %q not handled", s)
+					// Show it anyway.
+					source = s
+				}
+				historianV2Logs = append(historianV2Logs, historianV2Log{
+					Source:  source,
+					CSV:     l.CSV,
+					StartMs: l.StartMs,
+				})
 			}
-			historianV2Logs = append(historianV2Logs, historianV2Log{
-				Source:  source,
-				CSV:     l.CSV,
-				StartMs: l.StartMs,
-			})
 		}
 
 		var note string
@@ -950,7 +1857,21 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 			Location:        late.dt.Location().String(),
 			OverflowMs:      summariesOutput.overflowMs,
 			IsDiff:          diff,
+			Unsupported:     summariesOutput.unsupported,
+			Snapshots:       summariesOutput.snapshots,
+			FinalState:      summariesOutput.finalState,
+			Heatmap:         heatmap,
+			TimedOut:        timedOut,
+			DeviceKey:       device,
+			ReportID:        pd.uploads,
+			Changes:         changes,
+			RollUp:          rollUp,
+			Intervals:       intervals,
+			LevelSteps:      summariesOutput.levelSteps,
 		})
+		if pd.requested("summaries") {
+			pd.responseArr[len(pd.responseArr)-1].Summaries = summariesOutput.summaries
+		}
 		pd.data = append(pd.data, data)
 
 		if diff {
@@ -994,18 +1915,56 @@ func (pd *ParsedData) parseBugReport(fnameA, contentsA, fnameB, contentsB string
 	}
 	doParsing(brA, brB)
 
-	return nil
+	return ctx.Err()
 }
 
-func analyze(bugReport string, pkgs []*usagepb.PackageInfo) summariesData {
+// analyze returns the summaries of the bug report. If summariesOnly is true, no CSV is generated, and
+// the level steps are only computed if levelSteps is true. progress is called with the progress of the
+// battery history analysis, if not nil.
+func analyze(ctx context.Context, bugReport, model string, pkgs []*usagepb.PackageInfo, summariesOnly, levelSteps bool, progress func(done, total int)) summariesData {
 	upm, errs := parseutils.UIDAndPackageNameMapping(bugReport, pkgs)
+	upm.SetProfileNames(profileNames)
+	upm.SetRedactions(redactions)
 
 	var bufTotal, bufLevel bytes.Buffer
+	var csvWriter io.Writer = &bufTotal
+	if summariesOnly {
+		// No CSV is generated, so the analyses derived from it are skipped too.
+		csvWriter = nil
+	}
 	// repTotal contains summaries over discharge intervals
-	repTotal := parseutils.AnalyzeHistory(&bufTotal, bugReport, parseutils.FormatTotalTime, upm, false)
+	repTotal := parseutils.AnalyzeHistoryContext(ctx, csvWriter, bugReport, parseutils.FormatTotalTime, upm, false, parseutils.HistoryOptions{
+		SnapshotInterval:  snapshotInterval,
+		SummarizeCharging: summarizeCharging,
+		DeviceModel:       model,
+		ChargingDebounce:  chargingDebounce,
+		Progress:          progress,
+	})
+	if err := ctx.Err(); err != nil {
+		// The results of a stopped analysis are discarded.
+		return summariesData{errs: append(errs, err)}
+	}
+	if u := parseutils.CheckUnknownCodes(repTotal, maxUnknownPercent); u != nil {
+		// The summaries would be misleading, so only the unsupported report is returned.
+		return summariesData{errs: append(errs, u), unsupported: u}
+	}
+	if summariesOnly {
+		var summariesTotal []parseutils.ActivitySummary
+		for _, s := range repTotal.Summaries {
+			if s.InitialBatteryLevel != s.FinalBatteryLevel {
+				summariesTotal = append(summariesTotal, s)
+			}
+		}
+		d := summariesData{summaries: summariesTotal, timeToDelta: repTotal.TimeToDelta, errs: append(errs, repTotal.Errs...), overflowMs: repTotal.OverflowMs, snapshots: repTotal.Snapshots, finalState: repTotal.FinalState}
+		if levelSteps {
+			repLevel := parseutils.AnalyzeHistoryContext(ctx, nil, bugReport, parseutils.FormatBatteryLevel, upm, false, parseutils.HistoryOptions{DeviceModel: model, ChargingDebounce: chargingDebounce})
+			d.levelSteps = parseutils.BatteryLevelSummariesToSteps(repLevel.Summaries)
+		}
+		return d
+	}
 	// repLevel contains summaries for each battery level drop.
 	// The generated errors would be the exact same as repTotal.Errs so no need to track or add them again.
-	parseutils.AnalyzeHistory(&bufLevel, bugReport, parseutils.FormatBatteryLevel, upm, false)
+	repLevel := parseutils.AnalyzeHistoryContext(ctx, &bufLevel, bugReport, parseutils.FormatBatteryLevel, upm, false, parseutils.HistoryOptions{DeviceModel: model, ChargingDebounce: chargingDebounce})
 
 	// Exclude summaries with no change in battery level
 	var summariesTotal []parseutils.ActivitySummary
@@ -1016,12 +1975,40 @@ func analyze(bugReport string, pkgs []*usagepb.PackageInfo) summariesData {
 	}
 
 	errs = append(errs, repTotal.Errs...)
-	return summariesData{summariesTotal, bufTotal.String(), bufLevel.String(), repTotal.TimeToDelta, errs, repTotal.OverflowMs}
+	// The coverage is computed before the derived metrics are added, as it's only for the logged metrics.
+	coverage, cErrs := parseutils.MetricCoverages(bufTotal.String())
+	errs = append(errs, cErrs...)
+	errs = append(errs, parseutils.WriteDerivedMetrics(&bufTotal, bugReport)...)
+	errs = append(errs, parseutils.AddWorstWindows(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddNetworkSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddAlarmSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddWakelockChurnSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddSyncCostSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddWakeAttributionSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddSuspendAbortSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddIdleRadioSummaries(bufTotal.String(), summariesTotal)...)
+	errs = append(errs, parseutils.AddMobileRadioTailSummaries(bufTotal.String(), summariesTotal, mobileRadioTail)...)
+	errs = append(errs, parseutils.AddScreenSessionSummaries(bufTotal.String(), summariesTotal, screenMergeGap)...)
+	errs = append(errs, parseutils.AddMotionSummaries(bufTotal.String(), summariesTotal)...)
+	sessions, sErrs := parseutils.ChargeSessions(bufTotal.String())
+	errs = append(errs, sErrs...)
+	maintenance, mErrs := parseutils.MaintenanceWindowUsage(bufTotal.String())
+	errs = append(errs, mErrs...)
+	periodic, pErrs := parseutils.PeriodicWakeups(bufTotal.String())
+	errs = append(errs, pErrs...)
+	standby, stErrs := parseutils.AppStandbyUsage(bufTotal.String())
+	errs = append(errs, stErrs...)
+	// The errors are the same as the charging session and current errors already added.
+	tempAlerts, _ := parseutils.ChargingTemperatureAlerts(bufTotal.String())
+	cycles, _ := parseutils.ChargeCycles(bufTotal.String())
+	immortal, iErrs := parseutils.ImmortalWakelocks(bufTotal.String())
+	errs = append(errs, iErrs...)
+	return summariesData{summariesTotal, bufTotal.String(), bufLevel.String(), repTotal.TimeToDelta, errs, repTotal.OverflowMs, parseutils.ChargeStatsByPlugType(sessions), nil, maintenance, repTotal.Snapshots, repTotal.FinalState, periodic, standby, coverage, tempAlerts, cycles, immortal, parseutils.BatteryLevelSummariesToSteps(repLevel.Summaries)}
 }
 
 // generateHistorianPlot calls the Historian python script to generate html charts.
-func generateHistorianPlot(reportName, filepath string) (string, error) {
-	return historianutils.RunCommand("python", scriptsPath(scriptsDir, "historian.py"), "-c", "-m", "-r", reportName, filepath)
+func generateHistorianPlot(ctx context.Context, reportName, filepath string) (string, error) {
+	return historianutils.RunCommandContext(ctx, "python", scriptsPath(scriptsDir, "historian.py"), "-c", "-m", "-r", reportName, filepath)
 }
 
 // generateKernelCSV calls the python script to convert kernel trace files into a CSV format parseable by kernel.Parse.
@@ -0,0 +1,214 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// TestAnalysisKey tests that the settings changing the result of the analysis change its cache key.
+func TestAnalysisKey(t *testing.T) {
+	tests := []struct {
+		desc  string
+		set   func()
+		reset func()
+	}{
+		{
+			desc:  "Screen merge gap",
+			set:   func() { SetScreenMergeGap(time.Minute) },
+			reset: func() { SetScreenMergeGap(parseutils.DefaultScreenMergeGap) },
+		},
+		{
+			desc:  "Mobile radio tail",
+			set:   func() { SetMobileRadioTail(time.Minute) },
+			reset: func() { SetMobileRadioTail(parseutils.DefaultMobileRadioTail) },
+		},
+		{
+			desc:  "Charging debounce",
+			set:   func() { SetChargingDebounce(parseutils.ChargerFlapDuration) },
+			reset: func() { SetChargingDebounce(parseutils.DefaultChargingDebounce) },
+		},
+		{
+			desc:  "Redactions",
+			set:   func() { SetRedactions(parseutils.ParseRedactions("com.example.app")) },
+			reset: func() { SetRedactions(nil) },
+		},
+		{
+			desc:  "Language",
+			set:   func() { SetLanguage("fr") },
+			reset: func() { SetLanguage(messages.DefaultLanguage) },
+		},
+	}
+	const uploads = "abcd"
+	def := analysisKey(uploads, nil, nil)
+	for _, test := range tests {
+		test.set()
+		got := analysisKey(uploads, nil, nil)
+		test.reset()
+		if got == def {
+			t.Errorf("%s: analysisKey = %q, want it to differ from the key with the default settings", test.desc, got)
+		}
+		if again := analysisKey(uploads, nil, nil); again != def {
+			t.Errorf("%s: analysisKey after reset = %q, want %q", test.desc, again, def)
+		}
+	}
+}
+
+// TestRedactedAnalysis tests that the names of redacted packages are missing from the whole analysis response.
+func TestRedactedAnalysis(t *testing.T) {
+	br := strings.Join([]string{
+		`========================================================`,
+		`== dumpstate: 2015-01-30 12:00:00`,
+		`========================================================`,
+		``,
+		`Build: LMY06B`,
+		`Build fingerprint: 'google/shamu/shamu:5.1/LMY06B/1:userdebug/dev-keys'`,
+		``,
+		`------ SYSTEM PROPERTIES (getprop) ------`,
+		`[ro.build.version.sdk]: [22]`,
+		`[ro.product.model]: [Nexus 6]`,
+		``,
+		`------ EVENT LOG (logcat -b events -v threadtime -d *:v) ------`,
+		`01-30 04:20:52.000  1000  1200 I am_proc_start: [0,2345,10045,com.example.app,service,com.example.app/.SyncService]`,
+		`01-30 04:20:55.000  1000  1200 I am_crash: [2345,0,com.example.app,1,java.lang.NullPointerException,null,SyncService.java,42]`,
+		`01-30 04:20:56.000  1000  1200 I am_proc_died: [0,2345,com.example.app]`,
+		``,
+		`------ SYSTEM LOG (logcat -v threadtime -d *:v) ------`,
+		`01-30 04:20:53.000  2345  2345 E AndroidRuntime: Process: com.example.app, PID: 2345`,
+		``,
+		`------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------`,
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,10045,l,apk,1,com.example.app,com.example.app.SyncService,10,0,0`,
+		`9,10045,l,pr,com.example.app,100,200,3,0,0,0`,
+		`9,hsp,1,10045,"*job*/com.example.app/.SyncJob"`,
+		`9,hsp,2,10045,"com.example.provider"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=80,Bs=d,Bh=g,Bp=n,Bt=250,Bv=3900,+r,+S`,
+		`9,h,5000,+w=1,+Ejb=1`,
+		`9,h,3000,+Esy=2`,
+		`9,h,4000,-Esy=2`,
+		`9,h,2000,-w,-Ejb=1`,
+		`9,h,10000,-S,Bl=79`,
+		`9,h,30000,-r`,
+	}, "\n")
+
+	defer SetRedactions(redactions)
+	SetRedactions(parseutils.ParseRedactions("com.example.app"))
+	InitTemplates("../templates")
+	pd := &ParsedData{}
+	defer pd.Cleanup()
+	if err := pd.AnalyzeFiles(map[string]UploadedFile{bugreportFT: {FileName: "bugreport.txt", Contents: []byte(br)}}); err != nil {
+		t.Fatalf("AnalyzeFiles got unexpected error: %v", err)
+	}
+	b, err := pd.responseJSON()
+	if err != nil {
+		t.Fatalf("responseJSON got unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), parseutils.Redacted) {
+		t.Errorf("responseJSON() = %s, want it to contain %q", b, parseutils.Redacted)
+	}
+	if strings.Contains(string(b), "com.example.app") {
+		t.Errorf("responseJSON() contains redacted %q", "com.example.app")
+	}
+}
+
+// TestLevelSteps tests that the level steps are returned, whether or not the timelines are generated.
+func TestLevelSteps(t *testing.T) {
+	br := strings.Join([]string{
+		`========================================================`,
+		`== dumpstate: 2015-01-30 12:00:00`,
+		`========================================================`,
+		``,
+		`Build: LMY06B`,
+		`Build fingerprint: 'google/shamu/shamu:5.1/LMY06B/1:userdebug/dev-keys'`,
+		``,
+		`------ SYSTEM PROPERTIES (getprop) ------`,
+		`[ro.build.version.sdk]: [22]`,
+		`[ro.product.model]: [Nexus 6]`,
+		``,
+		`------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------`,
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=80,Bs=d,Bh=g,Bp=n,Bt=250,Bv=3900,+r,+S`,
+		`9,h,10000,-S,Bl=79`,
+		`9,h,30000,-r,Bl=78`,
+	}, "\n")
+	want := map[string]parseutils.LevelStep{
+		"80->79": {Count: 1, DurationMs: 10000, Wakeups: 1, ScreenOnMs: 10000},
+		"79->78": {Count: 1, DurationMs: 30000, Wakeups: 1},
+	}
+
+	InitTemplates("../templates")
+	tests := []struct {
+		desc   string
+		blocks map[string]bool
+	}{
+		{desc: "All blocks"},
+		{desc: "Summaries only", blocks: map[string]bool{"fileName": true, "levelSteps": true}},
+	}
+	for _, test := range tests {
+		pd := &ParsedData{blocks: test.blocks}
+		if err := pd.AnalyzeFiles(map[string]UploadedFile{bugreportFT: {FileName: "bugreport.txt", Contents: []byte(br)}}); err != nil {
+			t.Errorf("%s: AnalyzeFiles got unexpected error: %v", test.desc, err)
+			pd.Cleanup()
+			continue
+		}
+		if got := pd.responseArr[0].LevelSteps; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: LevelSteps = %+v, want %+v", test.desc, got, want)
+		}
+		pd.Cleanup()
+	}
+}
+
+// TestFilterCSVs tests that the metrics not allowed by the deployment and client filters are dropped
+// from the returned CSVs, and the ones not allowed by the deployment filter from the intervals.
+func TestFilterCSVs(t *testing.T) {
+	defer SetCSVMetricFilter(csvMetricFilter)
+	SetCSVMetricFilter(csv.NewMetricFilter(nil, []string{"Partial wakelock"}))
+
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Screen,bool,1000,2000,true,`,
+		`Partial wakelock,service,1000,3000,"com.google.android.gms",10010`,
+		`Battery Level,int,1000,4000,50,`,
+	}, "\n")
+	pd := &ParsedData{
+		csvFilter: csv.NewMetricFilter(nil, []string{"Battery Level"}),
+		responseArr: []uploadResponse{{
+			HistorianV2Logs: []historianV2Log{{Source: batteryHistory, CSV: input}},
+			Intervals: map[string][]csv.Interval{
+				"Screen":           {{Start: 1000, End: 2000}},
+				"Partial wakelock": {{Start: 1000, End: 3000}},
+			},
+		}},
+	}
+	pd.filterCSVs()
+
+	want := strings.Join([]string{csv.FileHeader, `Screen,bool,1000,2000,true,`}, "\n")
+	if got := strings.TrimSpace(pd.responseArr[0].HistorianV2Logs[0].CSV); got != want {
+		t.Errorf("filterCSVs() CSV\n got: %v\n want: %v", got, want)
+	}
+	wantIntervals := map[string][]csv.Interval{"Screen": {{Start: 1000, End: 2000}}}
+	if got := pd.responseArr[0].Intervals; !reflect.DeepEqual(got, wantIntervals) {
+		t.Errorf("filterCSVs() intervals\n got: %v\n want: %v", got, wantIntervals)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+// blocks.go negotiates the blocks of the JSON response a client needs, e.g. only the summaries and app
+// stats, so the analyses only feeding the other blocks can be skipped. The summaries only mode is a
+// preset of blocks without the timelines.
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// blocksParam is the query parameter, and the parameter of the application/json media type in the
+	// Accept header, listing the requested blocks.
+	// e.g. ?blocks=summaries,appStats or Accept: application/json; blocks="summaries appStats"
+	blocksParam = "blocks"
+	// summariesOnlyParam is the query parameter requesting all blocks but the timelines, e.g. for batch
+	// pipelines computing KPIs.
+	summariesOnlyParam = "summaries_only"
+
+	// htmlBlock is the block of the rendered HTML of the analysis.
+	htmlBlock = "html"
+	// intervalsBlock is the block of the raw time intervals of each metric of the battery history. It
+	// repeats the whole timeline, so it's only returned if requested explicitly.
+	intervalsBlock = "intervals"
+)
+
+var (
+	// responseBlocks are the blocks that can be requested: the keys of each uploadResponse, and the HTML.
+	responseBlocks = jsonKeys(reflect.TypeOf(uploadResponse{}), htmlBlock)
+
+	// requiredBlocks are always returned, as they say whether the analysis succeeded.
+	requiredBlocks = []string{"fileName", "criticalError", "note", "timedOut"}
+
+	// historyBlocks are the blocks generated from the battery history analysis.
+	historyBlocks = []string{htmlBlock, "historianV2Logs", "levelSummaryCsv", "timeToDelta", "overflowMs", "snapshots", "finalState", "heatmap", "summaries", "changedSinceLast", "rollUp", intervalsBlock, "levelSteps"}
+
+	// timelineBlocks are the blocks of the battery history timelines and of the analyses derived from
+	// them. The battery history CSV is only generated if one of them is returned.
+	timelineBlocks = []string{htmlBlock, "historianV2Logs", "levelSummaryCsv", "heatmap", "rollUp", intervalsBlock}
+)
+
+// jsonKeys returns the JSON keys of the fields of the struct type, and the extra keys.
+func jsonKeys(t reflect.Type, extra ...string) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		if k := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; k != "" && k != "-" {
+			keys[k] = true
+		}
+	}
+	for _, k := range extra {
+		keys[k] = true
+	}
+	return keys
+}
+
+// requestedBlocks returns the set of blocks requested in the blocks query parameter, separated by
+// commas, or else in the blocks parameter of the application/json media type of the Accept header,
+// separated by spaces. If the summaries_only query parameter is true, the timeline blocks are left out,
+// and all other blocks are returned if none were requested. It returns nil if the request doesn't
+// restrict the blocks, and an error if an unknown block is requested.
+func requestedBlocks(r *http.Request) (map[string]bool, error) {
+	summariesOnly, _ := strconv.ParseBool(r.URL.Query().Get(summariesOnlyParam))
+	var names []string
+	if q := r.URL.Query().Get(blocksParam); q != "" {
+		names = strings.Split(q, ",")
+	} else {
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mt, params, err := mime.ParseMediaType(accept)
+			if err != nil || mt != "application/json" || params[blocksParam] == "" {
+				continue
+			}
+			names = strings.Fields(params[blocksParam])
+			break
+		}
+	}
+	if len(names) == 0 {
+		if !summariesOnly {
+			return nil, nil
+		}
+		for n := range responseBlocks {
+			names = append(names, n)
+		}
+	}
+	blocks := make(map[string]bool)
+	for _, n := range requiredBlocks {
+		blocks[n] = true
+	}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if !responseBlocks[n] {
+			return nil, fmt.Errorf("unknown response block %q", n)
+		}
+		blocks[n] = true
+	}
+	if summariesOnly {
+		for _, n := range timelineBlocks {
+			delete(blocks, n)
+		}
+	}
+	return blocks, nil
+}
+
+// blocksKey returns the sorted requested blocks, joined for use in a storage key, or "" if all blocks are returned.
+func blocksKey(blocks map[string]bool) string {
+	var names []string
+	for n := range blocks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "+")
+}
+
+// wants returns whether any of the blocks are returned in the response.
+func (pd *ParsedData) wants(blocks ...string) bool {
+	if pd.blocks == nil {
+		return true
+	}
+	for _, b := range blocks {
+		if pd.blocks[b] {
+			return true
+		}
+	}
+	return false
+}
+
+// requested returns whether the block was requested explicitly, for the blocks that aren't returned by
+// default.
+func (pd *ParsedData) requested(block string) bool {
+	return pd.blocks[block]
+}
+
+// filterBlocks returns the responses with only the requested blocks.
+func filterBlocks(responses []uploadResponse, blocks map[string]bool) ([]map[string]json.RawMessage, error) {
+	var filtered []map[string]json.RawMessage
+	for _, r := range responses {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		for k := range m {
+			if !blocks[k] {
+				delete(m, k)
+			}
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestRequestedBlocks tests the negotiation of the response blocks from the query and Accept header.
+func TestRequestedBlocks(t *testing.T) {
+	tests := []struct {
+		desc    string
+		url     string
+		accept  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			desc:   "All blocks",
+			url:    "/",
+			accept: "application/json",
+		},
+		{
+			desc: "Summaries only",
+			url:  "/?summaries_only=true",
+			want: []string{"appStats", "batteryStats", "changedSinceLast", "criticalError", "deviceCapacity", "deviceKey", "displayPowerMonitor", "fileName", "finalState", "histogramStats", "isDiff", "levelSteps", "location", "note", "overflowMs", "reportId", "reportVersion", "sdkVersion", "snapshots", "summaries", "timeToDelta", "timedOut", "unsupported"},
+		},
+		{
+			desc: "Summaries only drops the requested timelines",
+			url:  "/?summaries_only=true&blocks=summaries,heatmap,html",
+			want: []string{"criticalError", "fileName", "note", "summaries", "timedOut"},
+		},
+		{
+			desc: "Query parameter",
+			url:  "/?blocks=summaries,appStats",
+			want: []string{"appStats", "criticalError", "fileName", "note", "summaries", "timedOut"},
+		},
+		{
+			desc:   "Accept header",
+			url:    "/",
+			accept: `text/html;q=0.9, application/json; blocks="html finalState"`,
+			want:   []string{"criticalError", "fileName", "finalState", "html", "note", "timedOut"},
+		},
+		{
+			desc:   "Query parameter overrides the header",
+			url:    "/?blocks=heatmap",
+			accept: `application/json; blocks="html"`,
+			want:   []string{"criticalError", "fileName", "heatmap", "note", "timedOut"},
+		},
+		{
+			desc:    "Unknown block",
+			url:     "/?blocks=summaries,wakelocks",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		blocks, err := requestedBlocks(r)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: requestedBlocks returned error %v, want error: %t", test.desc, err, test.wantErr)
+			continue
+		}
+		var got []string
+		for b := range blocks {
+			got = append(got, b)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: requestedBlocks = %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+// TestFilterBlocks tests that only the requested blocks are kept in the responses.
+func TestFilterBlocks(t *testing.T) {
+	responses := []uploadResponse{{SDKVersion: 23, FileName: "bugreport.zip", OverflowMs: 1000}}
+	got, err := filterBlocks(responses, map[string]bool{"fileName": true, "overflowMs": true})
+	if err != nil {
+		t.Fatalf("filterBlocks returned unexpected error: %v", err)
+	}
+	if len(got) != 1 || len(got[0]) != 2 || string(got[0]["fileName"]) != `"bugreport.zip"` || string(got[0]["overflowMs"]) != "1000" {
+		t.Errorf("filterBlocks(%+v) = %s, want only the fileName and overflowMs blocks", responses, got)
+	}
+}
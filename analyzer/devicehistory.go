@@ -0,0 +1,391 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+// devicehistory.go keeps an index of the KPIs of each analyzed bug report in the store, keyed by
+// device, so that repeated uploads from a device can be viewed as a time series of its health.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/storage"
+)
+
+const (
+	// deviceTopApps is the number of apps with the most attributed CPU running time kept per report.
+	deviceTopApps = 3
+	// deviceChangedApps is the number of apps with the largest KPI changes reported since the previous report.
+	deviceChangedApps = 10
+)
+
+// DeviceRecord is the KPIs of a single bug report of a device.
+type DeviceRecord struct {
+	// ID is the key of the uploaded files, see uploadsKey. It's only returned for the report the
+	// history is requested with.
+	ID string `json:"id,omitempty"`
+	// Captured is when the bug report was taken.
+	Captured         time.Time `json:"captured"`
+	BuildFingerprint string    `json:"buildFingerprint"`
+	// DrainPerDay is the battery level drop per day unplugged, in percent.
+	DrainPerDay float64 `json:"drainPerDay"`
+	// WakeupsPerHour is the number of times the CPU started running per hour unplugged.
+	WakeupsPerHour float64 `json:"wakeupsPerHour"`
+	// TopApps are the apps with the most attributed CPU running time, in descending order.
+	TopApps []string `json:"topApps"`
+	// Apps are the KPIs of each app, nil for records stored before they were kept.
+	Apps map[string]AppRecord `json:"apps,omitempty"`
+}
+
+// AppRecord is the KPIs of an app in a single bug report, per hour unplugged so that reports of
+// different lengths compare.
+type AppRecord struct {
+	// WakelockSecsPerHour is the time the app held the wakelock, in seconds per hour.
+	WakelockSecsPerHour float64 `json:"wakelockSecsPerHour"`
+	SyncsPerHour        float64 `json:"syncsPerHour"`
+	// CPUSecsPerHour is the CPU running time attributed to the app, in seconds per hour.
+	CPUSecsPerHour float64 `json:"cpuSecsPerHour"`
+}
+
+// AppChange is the change of the KPIs of an app since the previous report of the device.
+type AppChange struct {
+	App      string    `json:"app"`
+	Previous AppRecord `json:"previous"`
+	Current  AppRecord `json:"current"`
+}
+
+// size returns how much the KPIs of the app changed, to order the changes.
+func (c AppChange) size() float64 {
+	return math.Abs(c.Current.WakelockSecsPerHour-c.Previous.WakelockSecsPerHour) +
+		math.Abs(c.Current.SyncsPerHour-c.Previous.SyncsPerHour) +
+		math.Abs(c.Current.CPUSecsPerHour-c.Previous.CPUSecsPerHour)
+}
+
+// byChangeSize sorts app changes in descending order of size, then by app.
+type byChangeSize []AppChange
+
+func (a byChangeSize) Len() int      { return len(a) }
+func (a byChangeSize) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byChangeSize) Less(i, j int) bool {
+	if si, sj := a[i].size(), a[j].size(); si != sj {
+		return si > sj
+	}
+	return a[i].App < a[j].App
+}
+
+// ReportChanges are the changes of the app KPIs of a report since the previous report of the same device.
+type ReportChanges struct {
+	// PreviousID and PreviousCaptured identify the previous report of the device. The ID gives access
+	// to the previous bug report, so it isn't returned.
+	PreviousID       string    `json:"-"`
+	PreviousCaptured time.Time `json:"previousCaptured"`
+	// Apps are the apps with the largest changes, in descending order of the change.
+	Apps []AppChange `json:"apps"`
+}
+
+// DevicePoint is a point of the time series of a device, with the top app changes since the previous point.
+type DevicePoint struct {
+	DeviceRecord
+	// TopAppsAdded and TopAppsRemoved are the apps added to and removed from the top apps since the previous point.
+	TopAppsAdded   []string `json:"topAppsAdded,omitempty"`
+	TopAppsRemoved []string `json:"topAppsRemoved,omitempty"`
+}
+
+// byCaptured sorts records by capture time, then ID.
+type byCaptured []DeviceRecord
+
+func (a byCaptured) Len() int      { return len(a) }
+func (a byCaptured) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byCaptured) Less(i, j int) bool {
+	if !a[i].Captured.Equal(a[j].Captured) {
+		return a[i].Captured.Before(a[j].Captured)
+	}
+	return a[i].ID < a[j].ID
+}
+
+// appDuration is the attributed CPU running time of an app.
+type appDuration struct {
+	app string
+	d   time.Duration
+}
+
+// byAppDuration sorts apps in descending order of duration, then by name.
+type byAppDuration []appDuration
+
+func (a byAppDuration) Len() int      { return len(a) }
+func (a byAppDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAppDuration) Less(i, j int) bool {
+	if a[i].d != a[j].d {
+		return a[i].d > a[j].d
+	}
+	return a[i].app < a[j].app
+}
+
+// deviceKey returns the key of the device with the given Android ID. The ID is hashed, so it isn't
+// exposed in storage keys and URLs.
+func deviceKey(deviceID string) string {
+	h := sha256.Sum256([]byte(deviceID))
+	return hex.EncodeToString(h[:])
+}
+
+// deviceRecordKey returns the storage key of the record of the uploaded files for the device.
+func deviceRecordKey(device, uploads string) string {
+	return path.Join("devices", device, uploads+".json")
+}
+
+// newDeviceRecord returns the KPIs of the discharge summaries of a bug report. Charging summaries and
+// summaries from before the clock was set are skipped.
+func newDeviceRecord(captured time.Time, buildFingerprint string, summaries []parseutils.ActivitySummary) DeviceRecord {
+	r := DeviceRecord{Captured: captured, BuildFingerprint: buildFingerprint, TopApps: []string{}}
+	var drop int
+	var wakeups int32
+	var unplugged time.Duration
+	apps := make(map[string]time.Duration)
+	wakelocks := make(map[string]time.Duration)
+	syncs := make(map[string]int32)
+	for _, s := range summaries {
+		if s.Charging || s.Untimed {
+			continue
+		}
+		d := time.Duration(s.EndTimeMs-s.StartTimeMs)*time.Millisecond - s.PluggedInSummary.TotalDuration
+		if d <= 0 {
+			continue
+		}
+		unplugged += d
+		drop += s.InitialBatteryLevel - s.FinalBatteryLevel
+		wakeups += s.CPURunningSummary.Num
+		for app, dist := range s.AttributedCPURunningSummary {
+			apps[app] += dist.TotalDuration
+		}
+		for app, dist := range s.WakeLockSummary {
+			wakelocks[app] += dist.TotalDuration
+		}
+		for app, dist := range s.PerAppSyncSummary {
+			syncs[app] += dist.Num
+		}
+	}
+	if unplugged > 0 {
+		h := unplugged.Hours()
+		r.DrainPerDay = float64(drop) / h * 24
+		r.WakeupsPerHour = float64(wakeups) / h
+		r.Apps = make(map[string]AppRecord)
+		for app, d := range apps {
+			a := r.Apps[app]
+			a.CPUSecsPerHour = d.Seconds() / h
+			r.Apps[app] = a
+		}
+		for app, d := range wakelocks {
+			a := r.Apps[app]
+			a.WakelockSecsPerHour = d.Seconds() / h
+			r.Apps[app] = a
+		}
+		for app, n := range syncs {
+			a := r.Apps[app]
+			a.SyncsPerHour = float64(n) / h
+			r.Apps[app] = a
+		}
+	}
+	var sorted []appDuration
+	for app, d := range apps {
+		sorted = append(sorted, appDuration{app, d})
+	}
+	sort.Sort(byAppDuration(sorted))
+	for i := 0; i < len(sorted) && i < deviceTopApps; i++ {
+		r.TopApps = append(r.TopApps, sorted[i].app)
+	}
+	return r
+}
+
+// storeDeviceRecords saves the device records of the analysis of the uploaded files to the store.
+func (pd *ParsedData) storeDeviceRecords(uploads string) {
+	for device, r := range pd.deviceRecords {
+		r.ID = uploads
+		b, err := json.Marshal(r)
+		if err != nil {
+			log.Printf("failed to encode device record %s: %v", uploads, err)
+			continue
+		}
+		if err := store.Put(deviceRecordKey(device, uploads), b); err != nil {
+			log.Printf("failed to store device record %s: %v", uploads, err)
+		}
+	}
+}
+
+// deviceSeries returns the records of the device in the store, in capture time order, with the top
+// app changes between consecutive records.
+func deviceSeries(s storage.Store, device string) ([]DevicePoint, error) {
+	l, ok := s.(storage.Lister)
+	if !ok {
+		return nil, errNoLister
+	}
+	objs, err := l.List(path.Join("devices", device) + "/")
+	if err != nil {
+		return nil, err
+	}
+	var records []DeviceRecord
+	for _, o := range objs {
+		b, err := s.Get(o.Key)
+		if err == storage.ErrNotFound {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r DeviceRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("invalid device record %s: %v", o.Key, err)
+		}
+		records = append(records, r)
+	}
+	sort.Sort(byCaptured(records))
+
+	points := []DevicePoint{}
+	for i, r := range records {
+		p := DevicePoint{DeviceRecord: r}
+		if i > 0 {
+			p.TopAppsAdded = difference(r.TopApps, records[i-1].TopApps)
+			p.TopAppsRemoved = difference(records[i-1].TopApps, r.TopApps)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// previousRecord returns the latest record of the device in the store captured before the given time,
+// other than the one of the given uploaded files. Nil is returned if there is none.
+func previousRecord(s storage.Store, device, uploads string, captured time.Time) (*DeviceRecord, error) {
+	points, err := deviceSeries(s, device)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(points) - 1; i >= 0; i-- {
+		if r := points[i].DeviceRecord; r.ID != uploads && r.Captured.Before(captured) {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// appChanges returns the changes of the app KPIs between the previous and current records of a device,
+// largest first. Apps whose KPIs didn't change are skipped.
+func appChanges(prev, cur DeviceRecord) *ReportChanges {
+	c := &ReportChanges{PreviousID: prev.ID, PreviousCaptured: prev.Captured, Apps: []AppChange{}}
+	seen := make(map[string]bool)
+	for _, apps := range []map[string]AppRecord{cur.Apps, prev.Apps} {
+		for app := range apps {
+			if seen[app] {
+				continue
+			}
+			seen[app] = true
+			if ch := (AppChange{App: app, Previous: prev.Apps[app], Current: cur.Apps[app]}); ch.size() > 0 {
+				c.Apps = append(c.Apps, ch)
+			}
+		}
+	}
+	sort.Sort(byChangeSize(c.Apps))
+	if len(c.Apps) > deviceChangedApps {
+		c.Apps = c.Apps[:deviceChangedApps]
+	}
+	return c
+}
+
+// changesSinceLast returns the changes of the app KPIs of the record of the uploaded files since the
+// previous stored report of the device. Nil is returned if there is no previous report with app KPIs,
+// or if the store can't be listed.
+func changesSinceLast(s storage.Store, device, uploads string, r DeviceRecord) *ReportChanges {
+	prev, err := previousRecord(s, device, uploads, r.Captured)
+	if err != nil {
+		if err != errNoLister {
+			log.Printf("failed to get the previous record of device %s: %v", device, err)
+		}
+		return nil
+	}
+	if prev == nil || prev.Apps == nil {
+		return nil
+	}
+	return appChanges(*prev, r)
+}
+
+// difference returns the values of a not in b, in order.
+func difference(a, b []string) []string {
+	in := make(map[string]bool)
+	for _, v := range b {
+		in[v] = true
+	}
+	var res []string
+	for _, v := range a {
+		if !in[v] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// DeviceHistoryHandler returns the KPI time series of the device with the key in the device query
+// parameter, as returned in the deviceKey block of the analysis, as JSON. The id query parameter must
+// be the reportId of an analyzed report of the device, so that only the uploaders of the device's
+// bug reports can see its history. The IDs of the other reports aren't returned, as they give access
+// to their bug reports.
+func DeviceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "No storage configured", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	device := q.Get("device")
+	if _, err := hex.DecodeString(device); err != nil || len(device) != sha256.Size*2 {
+		http.Error(w, fmt.Sprintf("Invalid device key %q", device), http.StatusBadRequest)
+		return
+	}
+	id := q.Get("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != sha256.Size*2 {
+		http.Error(w, fmt.Sprintf("Invalid report ID %q", id), http.StatusBadRequest)
+		return
+	}
+	if _, err := store.Get(deviceRecordKey(device, id)); err == storage.ErrNotFound {
+		http.Error(w, "Report of the device not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	points, err := deviceSeries(store, device)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range points {
+		if points[i].ID != id {
+			points[i].ID = ""
+		}
+	}
+	b, err := json.Marshal(points)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,271 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/storage"
+)
+
+// TestNewDeviceRecord tests the KPIs computed from the summaries of a bug report.
+func TestNewDeviceRecord(t *testing.T) {
+	captured := time.Date(2017, time.February, 15, 12, 0, 0, 0, time.UTC)
+	summaries := []parseutils.ActivitySummary{
+		{
+			StartTimeMs:         0,
+			EndTimeMs:           int64(3 * time.Hour / time.Millisecond),
+			InitialBatteryLevel: 90,
+			FinalBatteryLevel:   84,
+			// Only the unplugged time counts.
+			PluggedInSummary:  parseutils.Dist{TotalDuration: time.Hour},
+			CPURunningSummary: parseutils.Dist{Num: 30},
+			AttributedCPURunningSummary: map[string]parseutils.Dist{
+				"com.example.chat": {TotalDuration: 10 * time.Minute},
+				"com.example.mail": {TotalDuration: 5 * time.Minute},
+			},
+			WakeLockSummary:   map[string]parseutils.Dist{"com.example.mail": {Num: 4, TotalDuration: 20 * time.Minute}},
+			PerAppSyncSummary: map[string]parseutils.Dist{"com.example.mail": {Num: 8}},
+		},
+		{
+			StartTimeMs:         int64(3 * time.Hour / time.Millisecond),
+			EndTimeMs:           int64(5 * time.Hour / time.Millisecond),
+			InitialBatteryLevel: 84,
+			FinalBatteryLevel:   82,
+			CPURunningSummary:   parseutils.Dist{Num: 10},
+			AttributedCPURunningSummary: map[string]parseutils.Dist{
+				"com.example.mail": {TotalDuration: 10 * time.Minute},
+				"android":          {TotalDuration: time.Minute},
+				"com.example.news": {TotalDuration: time.Second},
+			},
+		},
+		{
+			StartTimeMs:         int64(5 * time.Hour / time.Millisecond),
+			EndTimeMs:           int64(6 * time.Hour / time.Millisecond),
+			InitialBatteryLevel: 82,
+			FinalBatteryLevel:   100,
+			Charging:            true,
+		},
+	}
+	want := DeviceRecord{
+		Captured:         captured,
+		BuildFingerprint: "google/sailfish/sailfish:7.1.1/NMF26Q/1:user/release-keys",
+		DrainPerDay:      48,
+		WakeupsPerHour:   10,
+		TopApps:          []string{"com.example.mail", "com.example.chat", "android"},
+		// Per hour of the 4h unplugged.
+		Apps: map[string]AppRecord{
+			"com.example.mail": {WakelockSecsPerHour: 300, SyncsPerHour: 2, CPUSecsPerHour: 225},
+			"com.example.chat": {CPUSecsPerHour: 150},
+			"android":          {CPUSecsPerHour: 15},
+			"com.example.news": {CPUSecsPerHour: 0.25},
+		},
+	}
+	if got := newDeviceRecord(captured, want.BuildFingerprint, summaries); !reflect.DeepEqual(got, want) {
+		t.Errorf("newDeviceRecord(%v)\n got: %+v\n want: %+v", summaries, got, want)
+	}
+}
+
+// TestDeviceSeries tests the time series of the records of a device, and that they're deleted with their report.
+func TestDeviceSeries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historian-devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	device := deviceKey("3612563215415127183")
+	day := func(d int) time.Time { return time.Date(2017, time.February, d, 12, 0, 0, 0, time.UTC) }
+	records := []DeviceRecord{
+		{ID: "b", Captured: day(2), DrainPerDay: 20, TopApps: []string{"com.example.chat", "com.example.news"}},
+		{ID: "a", Captured: day(1), DrainPerDay: 10, TopApps: []string{"com.example.chat", "com.example.mail"}},
+	}
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(deviceRecordKey(device, r.ID), b); err != nil {
+			t.Fatalf("Put got unexpected error: %v", err)
+		}
+	}
+	// Records of other devices aren't returned.
+	if err := s.Put(deviceRecordKey(deviceKey("other"), "c"), []byte("{}")); err != nil {
+		t.Fatalf("Put got unexpected error: %v", err)
+	}
+
+	want := []DevicePoint{
+		{DeviceRecord: records[1]},
+		{DeviceRecord: records[0], TopAppsAdded: []string{"com.example.news"}, TopAppsRemoved: []string{"com.example.mail"}},
+	}
+	got, err := deviceSeries(s, device)
+	if err != nil {
+		t.Fatalf("deviceSeries got unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deviceSeries(%v)\n got: %+v\n want: %+v", device, got, want)
+	}
+
+	reports, err := listReports(s)
+	if err != nil {
+		t.Fatalf("listReports got unexpected error: %v", err)
+	}
+	for _, r := range reports {
+		if r.ID != "a" {
+			continue
+		}
+		if err := deleteReport(s, r); err != nil {
+			t.Fatalf("deleteReport got unexpected error: %v", err)
+		}
+	}
+	got, err = deviceSeries(s, device)
+	if err != nil {
+		t.Fatalf("deviceSeries got unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "b" || got[0].TopAppsAdded != nil {
+		t.Errorf("deviceSeries after deleting report a = %+v, want only report b", got)
+	}
+}
+
+// TestChangesSinceLast tests the app KPI changes since the previous report of a device.
+func TestChangesSinceLast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historian-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	device := deviceKey("3612563215415127183")
+	day := func(d int) time.Time { return time.Date(2017, time.February, d, 12, 0, 0, 0, time.UTC) }
+	records := []DeviceRecord{
+		// Stored before the app KPIs were kept.
+		{ID: "a", Captured: day(1)},
+		{ID: "b", Captured: day(2), Apps: map[string]AppRecord{
+			"com.example.chat": {CPUSecsPerHour: 10},
+			"com.example.mail": {WakelockSecsPerHour: 30, SyncsPerHour: 2, CPUSecsPerHour: 20},
+			"com.example.news": {CPUSecsPerHour: 1},
+		}},
+		// Captured after the current report.
+		{ID: "d", Captured: day(4), Apps: map[string]AppRecord{}},
+	}
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(deviceRecordKey(device, r.ID), b); err != nil {
+			t.Fatalf("Put got unexpected error: %v", err)
+		}
+	}
+
+	cur := DeviceRecord{ID: "c", Captured: day(3), Apps: map[string]AppRecord{
+		"com.example.chat": {CPUSecsPerHour: 10},
+		"com.example.mail": {WakelockSecsPerHour: 300, SyncsPerHour: 2, CPUSecsPerHour: 25},
+		"com.example.game": {CPUSecsPerHour: 5},
+	}}
+	want := &ReportChanges{
+		PreviousID:       "b",
+		PreviousCaptured: day(2),
+		Apps: []AppChange{
+			{App: "com.example.mail", Previous: records[1].Apps["com.example.mail"], Current: cur.Apps["com.example.mail"]},
+			{App: "com.example.game", Current: cur.Apps["com.example.game"]},
+			{App: "com.example.news", Previous: records[1].Apps["com.example.news"]},
+		},
+	}
+	if got := changesSinceLast(s, device, cur.ID, cur); !reflect.DeepEqual(got, want) {
+		t.Errorf("changesSinceLast(%v)\n got: %+v\n want: %+v", cur.ID, got, want)
+	}
+	// The previous report has no app KPIs to compare with.
+	cur.Captured = day(2)
+	if got := changesSinceLast(s, device, cur.ID, cur); got != nil {
+		t.Errorf("changesSinceLast(%v) captured on day 2 = %+v, want nil", cur.ID, got)
+	}
+}
+
+// TestDeviceHistoryHandler tests that the history of a device is only returned for one of its reports,
+// without the IDs of the other reports.
+func TestDeviceHistoryHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historian-device-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	defer SetStore(store)
+	SetStore(s)
+
+	device, other := deviceKey("3612563215415127183"), deviceKey("other")
+	a, b, c := strings.Repeat("aa", 32), strings.Repeat("bb", 32), strings.Repeat("cc", 32)
+	day := func(d int) time.Time { return time.Date(2017, time.February, d, 12, 0, 0, 0, time.UTC) }
+	for _, r := range []struct {
+		device string
+		rec    DeviceRecord
+	}{
+		{device, DeviceRecord{ID: a, Captured: day(1)}},
+		{device, DeviceRecord{ID: b, Captured: day(2)}},
+		{other, DeviceRecord{ID: c, Captured: day(3)}},
+	} {
+		buf, err := json.Marshal(r.rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(deviceRecordKey(r.device, r.rec.ID), buf); err != nil {
+			t.Fatalf("Put got unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		desc, url string
+		wantCode  int
+		wantBody  string
+	}{
+		{"Report of the device", "/device_history?device=" + device + "&id=" + b, 200, `"id":"` + b + `"`},
+		{"Report of another device", "/device_history?device=" + device + "&id=" + c, 404, "not found"},
+		{"Missing report ID", "/device_history?device=" + device, 400, "Invalid report ID"},
+		{"Invalid device", "/device_history?device=abc&id=" + b, 400, "Invalid device key"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		DeviceHistoryHandler(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d", test.desc, w.Code, test.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %q, want it to contain %q", test.desc, w.Body.String(), test.wantBody)
+		}
+		for _, id := range []string{a, c} {
+			if strings.Contains(w.Body.String(), id) {
+				t.Errorf("%s: got body %q, want it not to contain the ID of report %s", test.desc, w.Body.String(), id)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/storage"
+)
+
+// explainStep returns the explanations of each occurrence of the given battery level drop in the
+// history of the stored bug report of the given report and file type.
+func explainStep(s storage.Store, id, fileType string, from, to int) ([]parseutils.StepExplanation, error) {
+	b, err := s.Get(path.Join("uploads", id, fileType))
+	if err != nil {
+		return nil, err
+	}
+	br := redactions.RedactBugReport(string(b))
+	pkgs, errs := packageutils.ExtractAppsFromBugReport(br)
+	upm, pErrs := parseutils.UIDAndPackageNameMapping(br, pkgs)
+	upm.SetProfileNames(profileNames)
+	upm.SetRedactions(redactions)
+	errs = append(errs, pErrs...)
+
+	var buf bytes.Buffer
+	rep := parseutils.AnalyzeHistory(&buf, br, parseutils.FormatBatteryLevel, upm, true)
+	errs = append(errs, rep.Errs...)
+	errs = append(errs, parseutils.AddNetworkSummaries(buf.String(), rep.Summaries)...)
+	for _, err := range errs {
+		log.Printf("Trace explain step %d->%d of report %s: %v", from, to, id, err)
+	}
+	return parseutils.ExplainLevelStep(rep.Summaries, from, to), nil
+}
+
+// ExplainStepHandler returns the explanation of a battery level drop in the battery history of a
+// stored report as JSON, one for each time the level dropped from and to the same levels: the
+// duration of the step, the screen on and CPU running fractions, the top wakelock, job, sync and app,
+// the top consumers of the Dcpu events, the default network, and all of these ranked by duration.
+// The id and file query parameters are as for StateAtHandler, and the step query parameter is the
+// level drop, e.g. 47->46.
+func ExplainStepHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "No storage configured", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	id, fileType, err := storedReport(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseutils.ParseLevelStepKey(q.Get("step"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exps, err := explainStep(store, id, fileType, from, to)
+	switch {
+	case err == storage.ErrNotFound:
+		http.Error(w, fmt.Sprintf("Report %q not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(exps) == 0:
+		http.Error(w, fmt.Sprintf("No level drop %s in report %q", q.Get("step"), id), http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(exps)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/storage"
+)
+
+// TestExplainStepHandler tests the explanation returned for a level drop in a stored report.
+func TestExplainStepHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historian-explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	defer SetStore(store)
+	SetStore(s)
+
+	id := strings.Repeat("ab", 32)
+	br := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,19,10008,"com.android.providers.downloads/.DownloadIdleService"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=47,Bs=d,Bp=n`,
+		`9,h,30000,+S,+Ejb=19`,
+		`9,h,60000,-S,-Ejb=19`,
+		`9,h,10000,Bl=46`,
+		`9,h,10000,Bl=45`,
+	}, "\n")
+	if err := s.Put("uploads/"+id+"/bugreport", []byte(br)); err != nil {
+		t.Fatalf("Put got unexpected error: %v", err)
+	}
+
+	step := "&step=" + url.QueryEscape("47->46")
+	tests := []struct {
+		desc, url string
+		wantCode  int
+		wantBody  string
+	}{
+		{"Level drop", "/explain_step?id=" + id + step, 200, `"step":"47-\u003e46","startMs":1422620451417,"endMs":1422620551417,"durationMs":100000,"screenOnFraction":0.6`},
+		{"Ranked factors", "/explain_step?id=" + id + step, 200, `"factors":[{"kind":"job","name":"\"com.android.providers.downloads/.DownloadIdleService\"","durationMs":60000,"fraction":0.6},{"kind":"screen","durationMs":60000,"fraction":0.6}]`},
+		{"Level drop not in the history", "/explain_step?id=" + id + "&step=" + url.QueryEscape("46->44"), 404, "No level drop"},
+		{"Invalid step", "/explain_step?id=" + id + "&step=46", 400, "invalid level step"},
+		{"Invalid ID", "/explain_step?id=../secret" + step, 400, "Invalid report ID"},
+		{"Missing report", "/explain_step?id=" + strings.Repeat("cd", 32) + step, 404, "not found"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ExplainStepHandler(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d", test.desc, w.Code, test.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %q, want it to contain %q", test.desc, w.Body.String(), test.wantBody)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+// limits.go enforces the limits on uploads and analyses, so that a public Historian instance
+// can't be overloaded by giant or crafted bug reports.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/battery-historian/parseutils"
+)
+
+// LimitError is returned when an upload exceeds one of the configured limits.
+type LimitError struct {
+	Msg string
+}
+
+func (e *LimitError) Error() string {
+	return e.Msg
+}
+
+// historyLinePrefix is the prefix of the battery history lines in the checkin batterystats section.
+var historyLinePrefix = parseutils.BatteryStatsCheckinVersion + "," + parseutils.HistoryData + ","
+
+// countHistoryLines returns the number of battery history lines in the bug report.
+func countHistoryLines(contents string) int {
+	n := 0
+	for len(contents) > 0 {
+		line := contents
+		if i := strings.IndexByte(contents, '\n'); i >= 0 {
+			line, contents = contents[:i], contents[i+1:]
+		} else {
+			contents = ""
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), historyLinePrefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkHistoryLines returns a LimitError if the bug report has more battery history lines than allowed.
+func checkHistoryLines(fname, contents string) error {
+	if maxHistoryLines <= 0 {
+		return nil
+	}
+	if n := countHistoryLines(contents); n > maxHistoryLines {
+		return &LimitError{fmt.Sprintf("%s has %d battery history lines, more than the limit of %d", fname, n, maxHistoryLines)}
+	}
+	return nil
+}
+
+// ipLimiter limits the number of concurrent analyses per client IP.
+type ipLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// acquire reserves an analysis for the IP, and returns whether it's within the limit.
+// A successful acquire must be followed by a release.
+func (l *ipLimiter) acquire(ip string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit > 0 && l.active[ip] >= limit {
+		return false
+	}
+	if l.active == nil {
+		l.active = make(map[string]int)
+	}
+	l.active[ip]++
+	return true
+}
+
+// release ends an analysis for the IP.
+func (l *ipLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+// analyses is the number of analyses in progress per client IP.
+var analyses ipLimiter
+
+// clientIP returns the IP address of the client that made the request. Forwarding headers are
+// ignored, as they can be set by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// waitDone waits for the wait group until the context is done, and returns whether it finished in time.
+// A context that is never done waits indefinitely.
+func waitDone(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
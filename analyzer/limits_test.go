@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCheckHistoryLines tests that bug reports with too many battery history lines are rejected.
+func TestCheckHistoryLines(t *testing.T) {
+	br := strings.Join([]string{
+		"========================================================",
+		"== dumpstate: 2015-06-08 18:54:24",
+		"------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------",
+		`9,hsp,0,10073,"com.google.android.volta"`,
+		"9,h,0:RESET:TIME:1422620451417",
+		"9,h,1000,+r",
+		"  9,h,2000,-r",
+	}, "\n")
+	if got := countHistoryLines(br); got != 3 {
+		t.Errorf("countHistoryLines(%q) = %d, want 3", br, got)
+	}
+
+	defer SetMaxHistoryLines(maxHistoryLines)
+	tests := []struct {
+		max     int
+		wantErr bool
+	}{
+		{0, false},
+		{3, false},
+		{2, true},
+	}
+	for _, test := range tests {
+		SetMaxHistoryLines(test.max)
+		err := checkHistoryLines("bugreport.txt", br)
+		if _, ok := err.(*LimitError); ok != test.wantErr {
+			t.Errorf("checkHistoryLines with max %d returned %v, want LimitError: %t", test.max, err, test.wantErr)
+		}
+	}
+}
+
+// TestIPLimiter tests that concurrent analyses are limited per IP.
+func TestIPLimiter(t *testing.T) {
+	var l ipLimiter
+	if !l.acquire("1.2.3.4", 2) || !l.acquire("1.2.3.4", 2) {
+		t.Fatal("acquire failed within the limit")
+	}
+	if l.acquire("1.2.3.4", 2) {
+		t.Error("acquire succeeded over the limit")
+	}
+	if !l.acquire("5.6.7.8", 2) {
+		t.Error("acquire for a different IP failed")
+	}
+	l.release("1.2.3.4")
+	if !l.acquire("1.2.3.4", 2) {
+		t.Error("acquire failed after release")
+	}
+	if !l.acquire("1.2.3.4", 0) {
+		t.Error("acquire failed without a limit")
+	}
+}
+
+// TestWaitDone tests waiting for parsers until a context is done.
+func TestWaitDone(t *testing.T) {
+	var wg sync.WaitGroup
+	if !waitDone(context.Background(), &wg) {
+		t.Error("waitDone without running parsers returned false")
+	}
+	wg.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if waitDone(ctx, &wg) {
+		t.Error("waitDone for a running parser returned true")
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if waitDone(ctx, &wg) {
+		t.Error("waitDone for a running parser with a canceled context returned true")
+	}
+	wg.Done()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !waitDone(ctx, &wg) {
+		t.Error("waitDone for finished parsers returned false")
+	}
+}
+
+// TestAfterParsers tests that the callback is only called once all the parsers have exited.
+func TestAfterParsers(t *testing.T) {
+	var pd ParsedData
+	pd.parsers.Add(1)
+	called := make(chan struct{})
+	pd.afterParsers(func() { close(called) })
+	select {
+	case <-called:
+		t.Fatal("afterParsers called the callback while a parser was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	pd.parsers.Done()
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Error("afterParsers didn't call the callback after the parsers exited")
+	}
+}
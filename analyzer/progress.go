@@ -0,0 +1,171 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+// progress.go tracks the progress of the analyses of uploads, so that the upload page can show how
+// far the analysis of a large bug report is while the upload request is pending.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// Stages of the analysis of an upload.
+	stageUpload    = "upload"
+	stageHistory   = "history"
+	stageSummaries = "summaries"
+	stageResponse  = "response"
+
+	// progressTTL is how long the progress of a finished analysis can still be polled.
+	progressTTL = time.Minute
+)
+
+// progressIDRE matches the progress IDs chosen by the clients.
+var progressIDRE = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Progress is the progress of the analysis of an upload.
+type Progress struct {
+	// Stage is the current stage of the analysis: "upload" while the files are received, "history"
+	// while the battery history is analyzed, "summaries" while the rest of the analysis finishes and
+	// "response" while the response is written.
+	Stage string `json:"stage"`
+	// Percent is the percentage of the battery history lines analyzed, of all the uploaded bug reports.
+	Percent int `json:"percent"`
+	// Done is whether the analysis has finished, successfully or not.
+	Done bool `json:"done"`
+}
+
+// progress tracks the progress of the analysis of an upload. A nil progress tracks nothing, for
+// analyses without a progress ID.
+type progress struct {
+	mu    sync.Mutex
+	stage string
+	// lines are the analyzed and total battery history lines of each bug report, keyed by file name.
+	lines map[string][2]int
+	done  bool
+}
+
+// progresses are the progress of the analyses in progress, and recently finished, keyed by progress ID.
+var progresses = struct {
+	sync.Mutex
+	m map[string]*progress
+}{m: make(map[string]*progress)}
+
+// startProgress returns the progress tracked under the ID, or nil if the ID is empty or invalid.
+// The unfinished progress of the same ID is continued, e.g. after the upload was received, and a
+// finished one is replaced.
+func startProgress(id string) *progress {
+	if !progressIDRE.MatchString(id) {
+		return nil
+	}
+	progresses.Lock()
+	defer progresses.Unlock()
+	if p, ok := progresses.m[id]; ok && !p.get().Done {
+		return p
+	}
+	p := &progress{stage: stageUpload, lines: make(map[string][2]int)}
+	progresses.m[id] = p
+	return p
+}
+
+// finishProgress marks the progress as done, and stops tracking it under the ID after progressTTL.
+func finishProgress(id string, p *progress) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done = true
+	p.mu.Unlock()
+	time.AfterFunc(progressTTL, func() {
+		progresses.Lock()
+		defer progresses.Unlock()
+		if progresses.m[id] == p {
+			delete(progresses.m, id)
+		}
+	})
+}
+
+// setStage sets the current stage of the analysis.
+func (p *progress) setStage(stage string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.stage = stage
+	p.mu.Unlock()
+}
+
+// historyLines returns the function reporting the progress of the battery history analysis of the
+// bug report with the given file name, for parseutils.HistoryOptions. It's nil for a nil progress.
+// The stage moves on to the summaries once the histories of all the bug reports are analyzed.
+func (p *progress) historyLines(fname string) func(done, total int) {
+	if p == nil {
+		return nil
+	}
+	return func(done, total int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.lines[fname] = [2]int{done, total}
+		p.stage = stageSummaries
+		for _, l := range p.lines {
+			if l[0] < l[1] {
+				p.stage = stageHistory
+				break
+			}
+		}
+	}
+}
+
+// get returns the current progress.
+func (p *progress) get() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := Progress{Stage: p.stage, Done: p.done}
+	var done, total int
+	for _, l := range p.lines {
+		done += l[0]
+		total += l[1]
+	}
+	if total > 0 {
+		res.Percent = 100 * done / total
+	}
+	return res
+}
+
+// ProgressHandler returns the progress of the analysis of an upload as JSON. The id query parameter
+// is the progress ID sent with the progress query parameter of the upload request, chosen by the
+// client, e.g. a random token. The progress can be polled until a minute after the analysis finished.
+func ProgressHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	progresses.Lock()
+	p, ok := progresses.m[id]
+	progresses.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("No analysis in progress for %q", id), http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(p.get())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
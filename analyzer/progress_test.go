@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestProgress tests the progress reported over the stages of an analysis.
+func TestProgress(t *testing.T) {
+	if p := startProgress("../invalid"); p != nil {
+		t.Errorf("startProgress(%q) = %v, want nil", "../invalid", p)
+	}
+	// A nil progress is ignored.
+	var np *progress
+	np.setStage(stageResponse)
+	if f := np.historyLines("bugreport.txt"); f != nil {
+		t.Error("historyLines of a nil progress returned a function, want nil")
+	}
+	finishProgress("", np)
+
+	id := "test-progress"
+	p := startProgress(id)
+	if p == nil {
+		t.Fatalf("startProgress(%q) = nil, want a progress", id)
+	}
+	defer func() {
+		progresses.Lock()
+		delete(progresses.m, id)
+		progresses.Unlock()
+	}()
+	if got := startProgress(id); got != p {
+		t.Errorf("startProgress(%q) of an unfinished progress returned a new progress, want it continued", id)
+	}
+	a, b := p.historyLines("a.txt"), p.historyLines("b.txt")
+
+	tests := []struct {
+		desc   string
+		update func()
+		want   Progress
+	}{
+		{"Upload", func() {}, Progress{Stage: stageUpload}},
+		{"First history", func() { a(1000, 4000) }, Progress{Stage: stageHistory, Percent: 25}},
+		{"Both histories", func() { b(0, 1000) }, Progress{Stage: stageHistory, Percent: 20}},
+		{"One history done", func() { a(4000, 4000) }, Progress{Stage: stageHistory, Percent: 80}},
+		{"All histories done", func() { b(1000, 1000) }, Progress{Stage: stageSummaries, Percent: 100}},
+		{"Response", func() { p.setStage(stageResponse) }, Progress{Stage: stageResponse, Percent: 100}},
+		{"Done", func() { finishProgress(id, p) }, Progress{Stage: stageResponse, Percent: 100, Done: true}},
+	}
+	for _, test := range tests {
+		test.update()
+		if got := p.get(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got progress %+v, want %+v", test.desc, got, test.want)
+		}
+	}
+	if got := startProgress(id); got == p {
+		t.Errorf("startProgress(%q) of a finished progress continued it, want a new progress", id)
+	}
+}
+
+// TestProgressHandler tests the progress returned for an upload.
+func TestProgressHandler(t *testing.T) {
+	id := "test-handler"
+	p := startProgress(id)
+	defer func() {
+		progresses.Lock()
+		delete(progresses.m, id)
+		progresses.Unlock()
+	}()
+	p.historyLines("bugreport.txt")(500, 1000)
+
+	tests := []struct {
+		desc, url string
+		wantCode  int
+		wantBody  string
+	}{
+		{"In progress", "/progress?id=" + id, 200, `{"stage":"history","percent":50,"done":false}`},
+		{"Unknown ID", "/progress?id=unknown", 404, "No analysis in progress"},
+		{"Missing ID", "/progress", 404, "No analysis in progress"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ProgressHandler(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d", test.desc, w.Code, test.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %q, want it to contain %q", test.desc, w.Body.String(), test.wantBody)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+// retention.go deletes the stored uploads and their cached analyses once they expire or the store
+// grows too large, as uploaded bug reports contain personal data that shouldn't be kept forever.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/storage"
+)
+
+// RetentionPolicy is how long, and how many, stored reports are kept.
+type RetentionPolicy struct {
+	// TTL is how long a report is kept after it was uploaded. Reports don't expire if not positive.
+	TTL time.Duration
+	// MaxBytes is the maximum total size of the stored reports. The oldest reports are deleted first
+	// to stay under it. The size isn't limited if not positive.
+	MaxBytes int64
+}
+
+// StoredReport is an upload in the store, with its cached analyses and device records.
+type StoredReport struct {
+	// ID is the key of the uploaded files, see uploadsKey.
+	ID string `json:"id"`
+	// Uploaded is when the report was first stored.
+	Uploaded time.Time `json:"uploaded"`
+	// Size is the total size in bytes of the uploaded files, cached analyses and device records.
+	Size int64 `json:"size"`
+
+	// keys are the store keys of the uploaded files, cached analyses and device records.
+	keys []string
+}
+
+// byUploaded sorts reports by upload time, then ID.
+type byUploaded []*StoredReport
+
+func (a byUploaded) Len() int      { return len(a) }
+func (a byUploaded) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUploaded) Less(i, j int) bool {
+	if !a[i].Uploaded.Equal(a[j].Uploaded) {
+		return a[i].Uploaded.Before(a[j].Uploaded)
+	}
+	return a[i].ID < a[j].ID
+}
+
+var (
+	// Initialized in SetAdminToken(). The admin endpoint is disabled if empty.
+	adminToken string
+
+	// errNoLister is returned if the store can't list its objects.
+	errNoLister = errors.New("the store doesn't support listing its objects")
+)
+
+// SetAdminToken sets the bearer token required by the admin endpoints. An empty token disables them.
+func SetAdminToken(token string) {
+	adminToken = token
+}
+
+// reportID returns the ID of the report the store key belongs to, or "" if it isn't a report object.
+// e.g. "uploads/<id>/bugreport", "analyses/v2/summaries/<id>.json" or "devices/<device>/<id>.json"
+func reportID(key string) string {
+	switch {
+	case strings.HasPrefix(key, "uploads/"):
+		if parts := strings.Split(key, "/"); len(parts) == 3 {
+			return parts[1]
+		}
+	case strings.HasPrefix(key, "devices/") && strings.HasSuffix(key, ".json"):
+		if parts := strings.Split(key, "/"); len(parts) == 3 {
+			return strings.TrimSuffix(parts[2], ".json")
+		}
+	case strings.HasPrefix(key, "analyses/") && strings.HasSuffix(key, ".json"):
+		return strings.TrimSuffix(path.Base(key), ".json")
+	}
+	return ""
+}
+
+// listReports returns the reports in the store, oldest first.
+func listReports(s storage.Store) ([]*StoredReport, error) {
+	l, ok := s.(storage.Lister)
+	if !ok {
+		return nil, errNoLister
+	}
+	objs, err := l.List("")
+	if err != nil {
+		return nil, err
+	}
+	reports := make(map[string]*StoredReport)
+	for _, o := range objs {
+		id := reportID(o.Key)
+		if id == "" {
+			continue
+		}
+		r, ok := reports[id]
+		if !ok {
+			r = &StoredReport{ID: id, Uploaded: o.Modified}
+			reports[id] = r
+		}
+		if o.Modified.Before(r.Uploaded) {
+			r.Uploaded = o.Modified
+		}
+		r.Size += o.Size
+		r.keys = append(r.keys, o.Key)
+	}
+	var list []*StoredReport
+	for _, r := range reports {
+		list = append(list, r)
+	}
+	sort.Sort(byUploaded(list))
+	return list, nil
+}
+
+// deleteReport deletes the uploaded files and cached analyses of the report.
+func deleteReport(s storage.Store, r *StoredReport) error {
+	for _, k := range r.keys {
+		if err := s.Delete(k); err != nil {
+			return fmt.Errorf("could not delete %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// cleanReports deletes the reports of the store that are expired at the given time, then the oldest
+// reports until the total size is under the policy's maximum, and returns the deleted reports.
+func cleanReports(s storage.Store, p RetentionPolicy, now time.Time) ([]*StoredReport, error) {
+	reports, err := listReports(s)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, r := range reports {
+		total += r.Size
+	}
+	var deleted []*StoredReport
+	for _, r := range reports {
+		expired := p.TTL > 0 && now.Sub(r.Uploaded) > p.TTL
+		if !expired && (p.MaxBytes <= 0 || total <= p.MaxBytes) {
+			continue
+		}
+		if err := deleteReport(s, r); err != nil {
+			return deleted, err
+		}
+		total -= r.Size
+		deleted = append(deleted, r)
+	}
+	return deleted, nil
+}
+
+// StartCleaner deletes the stored reports not allowed by the policy every interval, until the program
+// exits. It returns an error if no store is set or the store can't list its objects.
+func StartCleaner(p RetentionPolicy, interval time.Duration) error {
+	if store == nil {
+		return errors.New("no store set")
+	}
+	if _, ok := store.(storage.Lister); !ok {
+		return errNoLister
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid cleanup interval %v", interval)
+	}
+	go func() {
+		for ; ; time.Sleep(interval) {
+			deleted, err := cleanReports(store, p, time.Now())
+			if err != nil {
+				log.Printf("failed to clean up stored reports: %v", err)
+			}
+			if len(deleted) > 0 {
+				log.Printf("Trace deleted %d stored reports", len(deleted))
+			}
+		}
+	}()
+	return nil
+}
+
+// AdminReportsHandler lists the stored reports as JSON on GET, and deletes the report with the id
+// query parameter on DELETE. Requests must have the admin token set with SetAdminToken as a bearer token.
+func AdminReportsHandler(w http.ResponseWriter, r *http.Request) {
+	auth := []byte(r.Header.Get("Authorization"))
+	if adminToken == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+adminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if store == nil {
+		http.Error(w, "No storage configured", http.StatusNotFound)
+		return
+	}
+	reports, err := listReports(store)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if reports == nil {
+			reports = []*StoredReport{}
+		}
+		b, err := json.Marshal(reports)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		for _, rep := range reports {
+			if rep.ID != id {
+				continue
+			}
+			if err := deleteReport(store, rep); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Trace deleted stored report %s", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Report %q not found", id), http.StatusNotFound)
+	default:
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/storage"
+)
+
+// newTestStore returns a disk store with reports a, b and c uploaded 3, 2 and 1 days before now,
+// each 100 bytes in total, and a function removing the store directory.
+func newTestStore(t *testing.T, now time.Time) (*storage.DiskStore, func()) {
+	dir, err := ioutil.TempDir("", "historian-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		modified := now.Add(-time.Duration(3-i) * 24 * time.Hour)
+		for _, key := range []string{"uploads/" + id + "/bugreport", "analyses/v2/summaries/" + id + ".json"} {
+			if err := s.Put(key, []byte(strings.Repeat("x", 50))); err != nil {
+				t.Fatalf("Put(%q) got unexpected error: %v", key, err)
+			}
+			if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// TestCleanReports tests the deletion of the stored reports not allowed by the retention policy.
+func TestCleanReports(t *testing.T) {
+	now := time.Date(2017, time.February, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc        string
+		policy      RetentionPolicy
+		wantDeleted []string
+	}{
+		{
+			desc: "No limits",
+		},
+		{
+			desc:        "Expired",
+			policy:      RetentionPolicy{TTL: 36 * time.Hour},
+			wantDeleted: []string{"a", "b"},
+		},
+		{
+			desc:        "Too large",
+			policy:      RetentionPolicy{MaxBytes: 150},
+			wantDeleted: []string{"a", "b"},
+		},
+		{
+			desc:        "Expired and too large",
+			policy:      RetentionPolicy{TTL: 60 * time.Hour, MaxBytes: 200},
+			wantDeleted: []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		s, cleanup := newTestStore(t, now)
+		deleted, err := cleanReports(s, test.policy, now)
+		if err != nil {
+			t.Errorf("%s: cleanReports got unexpected error: %v", test.desc, err)
+		}
+		var got []string
+		for _, r := range deleted {
+			got = append(got, r.ID)
+		}
+		if !reflect.DeepEqual(got, test.wantDeleted) {
+			t.Errorf("%s: cleanReports deleted %v, want %v", test.desc, got, test.wantDeleted)
+		}
+		reports, err := listReports(s)
+		if err != nil {
+			t.Errorf("%s: listReports got unexpected error: %v", test.desc, err)
+		}
+		if n := len(reports) + len(deleted); n != 3 {
+			t.Errorf("%s: %d reports left after deleting %d, want 3 in total", test.desc, len(reports), len(deleted))
+		}
+		cleanup()
+	}
+}
+
+// TestAdminReportsHandler tests the listing and deletion of stored reports by the admin endpoint.
+func TestAdminReportsHandler(t *testing.T) {
+	s, cleanup := newTestStore(t, time.Now())
+	defer cleanup()
+	defer SetStore(store)
+	defer SetAdminToken(adminToken)
+	SetStore(s)
+	SetAdminToken("secret")
+	// The device records of a report are deleted with it.
+	if err := s.Put(deviceRecordKey(deviceKey("3612563215415127183"), "b"), []byte("{}")); err != nil {
+		t.Fatalf("Put got unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		desc, method, url, auth string
+		wantCode                int
+		wantBody                string
+	}{
+		{"No token", "GET", "/admin/reports", "", 401, ""},
+		{"Wrong token", "GET", "/admin/reports", "Bearer guess", 401, ""},
+		{"Delete", "DELETE", "/admin/reports?id=b", "Bearer secret", 204, ""},
+		{"Delete missing", "DELETE", "/admin/reports?id=b", "Bearer secret", 404, ""},
+		{"List", "GET", "/admin/reports", "Bearer secret", 200, `"id":"c"`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		AdminReportsHandler(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d", test.desc, w.Code, test.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %q, want it to contain %q", test.desc, w.Body.String(), test.wantBody)
+		}
+	}
+	reports, err := listReports(s)
+	if err != nil {
+		t.Fatalf("listReports got unexpected error: %v", err)
+	}
+	if len(reports) != 2 || reports[0].ID != "a" || reports[1].ID != "c" {
+		t.Errorf("listReports after deleting b got %+v, want reports a and c", reports)
+	}
+	if _, err := s.Get(deviceRecordKey(deviceKey("3612563215415127183"), "b")); err != storage.ErrNotFound {
+		t.Errorf("Get of the device record of b after deleting it got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/storage"
+)
+
+// parseStateTime parses the time of a state at query, either in unix ms or in RFC 3339 format.
+func parseStateTime(v string) (int64, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want unix ms or RFC 3339", v)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// storedReport returns the report ID and file type of the stored bug report of a query, from the id
+// query parameter and the optional file query parameter, bugreport or bugreport2.
+func storedReport(q url.Values) (string, string, error) {
+	id := q.Get("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != sha256.Size*2 {
+		return "", "", fmt.Errorf("Invalid report ID %q", id)
+	}
+	switch f := q.Get("file"); f {
+	case "", bugreportFT:
+		return id, bugreportFT, nil
+	case bugreport2FT:
+		return id, f, nil
+	default:
+		return "", "", fmt.Errorf("Invalid file %q", f)
+	}
+}
+
+// stateAt returns the device state at the given time in the history of the stored bug report of the
+// given report and file type. Nil is returned if the time isn't covered by the history.
+func stateAt(s storage.Store, id, fileType string, timeMs int64) (*parseutils.DeviceStateSnapshot, error) {
+	b, err := s.Get(path.Join("uploads", id, fileType))
+	if err != nil {
+		return nil, err
+	}
+	br := redactions.RedactBugReport(string(b))
+	pkgs, errs := packageutils.ExtractAppsFromBugReport(br)
+	upm, pErrs := parseutils.UIDAndPackageNameMapping(br, pkgs)
+	upm.SetProfileNames(profileNames)
+	upm.SetRedactions(redactions)
+	state, sErrs := parseutils.DeviceStateAt(br, upm, timeMs)
+	for _, err := range append(append(errs, pErrs...), sErrs...) {
+		log.Printf("Trace state at %d of report %s: %v", timeMs, id, err)
+	}
+	return state, nil
+}
+
+// StateAtHandler returns the device state reconstructed from the battery history of a stored report
+// at a given time as JSON: the device booleans, connectivity, and the active wakelocks, jobs, syncs
+// and top app. The id query parameter is the report ID, as returned in the reportId block of the
+// analysis, and the time query parameter is in unix ms or in RFC 3339 format. The state is taken from
+// the second bug report of a comparison if the file query parameter is bugreport2.
+func StateAtHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		http.Error(w, "No storage configured", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	id, fileType, err := storedReport(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeMs, err := parseStateTime(q.Get("time"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	state, err := stateAt(store, id, fileType, timeMs)
+	switch {
+	case err == storage.ErrNotFound:
+		http.Error(w, fmt.Sprintf("Report %q not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case state == nil:
+		http.Error(w, fmt.Sprintf("No device state at %d in report %q", timeMs, id), http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
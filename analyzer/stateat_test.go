@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/storage"
+)
+
+// TestStateAtHandler tests the device state returned for a time in a stored report.
+func TestStateAtHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "historian-stateat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := storage.NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore(%v) got unexpected error: %v", dir, err)
+	}
+	defer SetStore(store)
+	SetStore(s)
+
+	id := strings.Repeat("ab", 32)
+	br := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,19,10008,"com.android.providers.downloads/.DownloadIdleService"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=80,Bs=d,Bp=n`,
+		`9,h,30000,+S,+Ejb=19`,
+		`9,h,60000,-S,-Ejb=19`,
+	}, "\n")
+	if err := s.Put("uploads/"+id+"/bugreport", []byte(br)); err != nil {
+		t.Fatalf("Put got unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		desc, url string
+		wantCode  int
+		wantBody  string
+	}{
+		{"Unix ms", "/state_at?id=" + id + "&time=1422620491417", 200, `"jobs":[{"service":"com.android.providers.downloads/.DownloadIdleService","uid":"10008"}]`},
+		{"RFC 3339", "/state_at?id=" + id + "&time=2015-01-30T12:21:31.417Z", 200, `"screenOn":true`},
+		{"Before the history", "/state_at?id=" + id + "&time=1422620451416", 404, "No device state"},
+		{"Invalid time", "/state_at?id=" + id + "&time=03:14:07", 400, "invalid time"},
+		{"Invalid ID", "/state_at?id=../secret&time=1422620491417", 400, "Invalid report ID"},
+		{"Missing report", "/state_at?id=" + strings.Repeat("cd", 32) + "&time=1422620491417", 404, "not found"},
+		{"Missing second bug report", "/state_at?id=" + id + "&file=bugreport2&time=1422620491417", 404, "not found"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		StateAtHandler(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d", test.desc, w.Code, test.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %q, want it to contain %q", test.desc, w.Body.String(), test.wantBody)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/battery-historian/validate"
+)
+
+// ValidateHandler checks the bug report uploaded in the first file part of a multipart POST body
+// without analyzing it, and returns the validation report as JSON: whether it can be analyzed and
+// the problems otherwise, the SDK version, the battery history time span, report version and
+// event counts, and the number of lines of each bug report section. The status is 422 if the bug
+// report can't be analyzed.
+func ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ContentLength > maxFileSize {
+		closeConnection(w, fmt.Sprintf("File too large (>%dMB).", maxFileSize/(1024*1024)))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var rep *validate.Report
+	for rep == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			http.Error(w, "Failed to read file. Please try again.", http.StatusInternalServerError)
+			return
+		}
+		rep = validate.Check(part.FileName(), b)
+	}
+	b, err := json.Marshal(rep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.Valid {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	w.Write(b)
+}
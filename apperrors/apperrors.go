@@ -0,0 +1,252 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apperrors extracts the app crashes and ANRs recorded by the activity manager and the
+// drop box in a bug report, and checks whether crash loops coincide with CPU or wakelock spikes.
+package apperrors
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// Metric is the CSV description of app crash and ANR events.
+	Metric = "App crash / ANR"
+
+	// crashLoopMinCount is the minimum number of errors of a process to be considered a crash loop.
+	crashLoopMinCount = 3
+	// crashLoopMaxGap is the maximum time between consecutive errors of a process in a crash loop.
+	crashLoopMaxGap = 10 * time.Minute
+	// minLoopWindow is the minimum window over which CPU and wakelock usage are measured for a crash loop.
+	minLoopWindow = time.Minute
+	// spikeFactor is how many times the average usage over the report a crash loop must reach to be counted as a spike.
+	spikeFactor = 1.5
+
+	// dropBoxTimeFormat is the format of drop box entry timestamps.
+	dropBoxTimeFormat = "2006-01-02 15:04:05"
+
+	// Battery history metrics used for the correlation.
+	batteryLevel    = "Battery Level"
+	cpuRunning      = "CPU running"
+	partialWakelock = "Partial wakelock"
+)
+
+var (
+	// badProcessRE matches a process the activity manager marked as bad after crashing repeatedly.
+	// e.g. "    Bad process com.google.android.apps.maps uid 10023: crashed at time 1433786064000"
+	badProcessRE = regexp.MustCompile(`^\s*Bad process (?P<process>\S+) uid (?P<uid>\d+): crashed at time (?P<timeMs>\d+)`)
+
+	// dropBoxEntryRE matches the header of a drop box crash or ANR entry.
+	// e.g. "2015-06-08 10:54:24 data_app_crash (text, 1234 bytes)"
+	dropBoxEntryRE = regexp.MustCompile(`^(?P<timestamp>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) (?P<tag>\w+_(crash|anr|native_crash)) \(`)
+
+	// dropBoxProcessRE matches the process in the preview line following a drop box entry header.
+	// e.g. "    Process: com.google.android.gms/PID: 1234/Flags: 0x38c83e45/..."
+	dropBoxProcessRE = regexp.MustCompile(`^\s*Process: (?P<process>[^/\s]+)`)
+)
+
+// Event is an app crash or ANR.
+type Event struct {
+	// Kind is the drop box tag (e.g. data_app_crash, system_app_anr), or "bad_process" for
+	// processes the activity manager stopped restarting.
+	Kind    string
+	Process string
+	TimeMs  int64
+}
+
+// byTime sorts events in ascending order of time.
+type byTime []Event
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].TimeMs < a[j].TimeMs }
+
+// Parse returns the app crashes and ANRs found in the bug report, sorted by time. The drop box
+// timestamps are in the device local time, given by loc.
+func Parse(bugreport string, loc *time.Location) ([]Event, []error) {
+	var events []Event
+	var errs []error
+	seen := make(map[string]bool)
+	add := func(e Event) {
+		// The same error can be listed in several places, so dedupe by process and second.
+		k := fmt.Sprintf("%s,%d", e.Process, e.TimeMs/1000)
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		events = append(events, e)
+	}
+
+	lines := strings.Split(bugreport, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if m, result := historianutils.SubexpNames(badProcessRE, line); m {
+			ms, err := strconv.ParseInt(result["timeMs"], 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid bad process time %q: %v", line, err))
+				continue
+			}
+			add(Event{Kind: "bad_process", Process: result["process"], TimeMs: ms})
+			continue
+		}
+		m, result := historianutils.SubexpNames(dropBoxEntryRE, line)
+		if !m {
+			continue
+		}
+		t, err := time.ParseInLocation(dropBoxTimeFormat, result["timestamp"], loc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid drop box timestamp %q: %v", line, err))
+			continue
+		}
+		process := ""
+		if i+1 < len(lines) {
+			if m, p := historianutils.SubexpNames(dropBoxProcessRE, lines[i+1]); m {
+				process = p["process"]
+			}
+		}
+		add(Event{Kind: result["tag"], Process: process, TimeMs: t.UnixNano() / int64(time.Millisecond)})
+	}
+	sort.Stable(byTime(events))
+	return events, errs
+}
+
+// CSV returns the events as instant CSV events, so they can be seen on the timeline.
+func CSV(events []Event) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, e := range events {
+		v := e.Kind
+		if e.Process != "" {
+			v = fmt.Sprintf("%s: %s", e.Kind, e.Process)
+		}
+		csvState.Print(Metric, "string", e.TimeMs, e.TimeMs, v, "")
+	}
+	return b.String()
+}
+
+// CrashLoop is a process repeatedly crashing or not responding, with the CPU running and partial
+// wakelock usage during the loop compared to the average over the report.
+type CrashLoop struct {
+	Process        string
+	Count          int
+	StartMs, EndMs int64
+	// Percentages of the loop window (at least a minute long) the CPU was running and a partial wakelock was held.
+	CPURunningPercent, WakelockPercent float64
+	// Percentages of the whole battery history the CPU was running and a partial wakelock was held.
+	AvgCPURunningPercent, AvgWakelockPercent float64
+	// Spike is true if the CPU running or wakelock usage during the loop was well above average.
+	Spike bool
+}
+
+// Start returns the time of the first error in the loop.
+func (l CrashLoop) Start() time.Time {
+	return time.Unix(0, l.StartMs*int64(time.Millisecond))
+}
+
+// End returns the time of the last error in the loop.
+func (l CrashLoop) End() time.Time {
+	return time.Unix(0, l.EndMs*int64(time.Millisecond))
+}
+
+// percent returns the percentage of [startMs, endMs] covered by the events.
+func percent(events []csv.Event, startMs, endMs int64) float64 {
+	if endMs <= startMs {
+		return 0
+	}
+	return 100 * float64(csv.Overlap(events, startMs, endMs)) / float64(endMs-startMs)
+}
+
+// CrashLoops finds the processes with at least 3 errors, each less than 10 minutes apart, and compares
+// the CPU running and partial wakelock usage during each loop with the average over the battery history
+// CSV generated by AnalyzeHistory. Loops are sorted by start time.
+func CrashLoops(events []Event, historyCSV string) ([]CrashLoop, []error) {
+	byProcess := make(map[string][]Event)
+	for _, e := range events {
+		if e.Process != "" {
+			byProcess[e.Process] = append(byProcess[e.Process], e)
+		}
+	}
+	var loops []CrashLoop
+	gapMs := int64(crashLoopMaxGap / time.Millisecond)
+	for p, es := range byProcess {
+		sort.Stable(byTime(es))
+		start := 0
+		for i := 1; i <= len(es); i++ {
+			if i < len(es) && es[i].TimeMs-es[i-1].TimeMs <= gapMs {
+				continue
+			}
+			if n := i - start; n >= crashLoopMinCount {
+				loops = append(loops, CrashLoop{Process: p, Count: n, StartMs: es[start].TimeMs, EndMs: es[i-1].TimeMs})
+			}
+			start = i
+		}
+	}
+	if len(loops) == 0 {
+		return nil, nil
+	}
+
+	es, errs := csv.ExtractEvents(historyCSV, []string{batteryLevel, cpuRunning, partialWakelock})
+	cpu := csv.MergeEvents(es[cpuRunning])
+	wakelocks := csv.MergeEvents(es[partialWakelock])
+	var histStart, histEnd int64
+	for i, e := range es[batteryLevel] {
+		if i == 0 || e.Start < histStart {
+			histStart = e.Start
+		}
+		if e.End > histEnd {
+			histEnd = e.End
+		}
+	}
+	avgCPU := percent(cpu, histStart, histEnd)
+	avgWakelock := percent(wakelocks, histStart, histEnd)
+	minMs := int64(minLoopWindow / time.Millisecond)
+	for i := range loops {
+		l := &loops[i]
+		end := l.EndMs
+		if end-l.StartMs < minMs {
+			end = l.StartMs + minMs
+		}
+		l.CPURunningPercent = percent(cpu, l.StartMs, end)
+		l.WakelockPercent = percent(wakelocks, l.StartMs, end)
+		l.AvgCPURunningPercent = avgCPU
+		l.AvgWakelockPercent = avgWakelock
+		// Usage can't be compared if the loop is outside the battery history.
+		if l.StartMs < histEnd && end > histStart {
+			l.Spike = l.CPURunningPercent > spikeFactor*avgCPU || l.WakelockPercent > spikeFactor*avgWakelock
+		}
+	}
+	sort.Sort(byStart(loops))
+	return loops, errs
+}
+
+// byStart sorts crash loops in ascending order of start time, then by process.
+type byStart []CrashLoop
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].StartMs != a[j].StartMs {
+		return a[i].StartMs < a[j].StartMs
+	}
+	return a[i].Process < a[j].Process
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apperrors
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		"========================================================",
+		"== dumpstate: 2015-06-08 11:00:00",
+		"========================================================",
+		"------ DROPBOX SYSTEM SERVER CRASHES (dumpsys dropbox -p system_server_crash) ------",
+		"DUMP OF SERVICE dropbox:",
+		"Drop box contents: 4 entries",
+		"",
+		"2015-06-08 10:54:24 data_app_crash (text, 1234 bytes)",
+		"    Process: com.google.android.apps.maps/PID: 1234/Flags: 0x38c83e45/Package: com.google.android.apps.maps",
+		"2015-06-08 10:55:00 data_app_anr (text, 5678 bytes)",
+		"    Process: com.google.android.gms/PID: 2345/Flags: 0x38c83e45",
+		"2015-06-08 10:56:00 SYSTEM_BOOT (text, 100 bytes)",
+		"2015-06-08 10:57:00 system_app_native_crash (text, 100 bytes)",
+		"",
+		"  Bad processes:",
+		// Same crash as the drop box entry.
+		"    Bad process com.google.android.apps.maps uid 10023: crashed at time 1433760864000",
+		"      Short msg: java.lang.NullPointerException",
+		"    Bad process com.example.app uid 10099: crashed at time 1433760000000",
+	}, "\n")
+	want := []Event{
+		{Kind: "bad_process", Process: "com.example.app", TimeMs: 1433760000000},
+		{Kind: "data_app_crash", Process: "com.google.android.apps.maps", TimeMs: 1433760864000},
+		{Kind: "data_app_anr", Process: "com.google.android.gms", TimeMs: 1433760900000},
+		{Kind: "system_app_native_crash", TimeMs: 1433761020000},
+	}
+	got, errs := Parse(input, time.UTC)
+	if len(errs) > 0 {
+		t.Errorf("Parse(%v) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", input, got, want)
+	}
+}
+
+func TestCrashLoops(t *testing.T) {
+	min := int64(time.Minute / time.Millisecond)
+	events := []Event{
+		// Three crashes less than 10 minutes apart form a loop.
+		{Kind: "data_app_crash", Process: "com.example.app", TimeMs: 10 * min},
+		{Kind: "data_app_crash", Process: "com.example.app", TimeMs: 12 * min},
+		{Kind: "data_app_anr", Process: "com.example.app", TimeMs: 20 * min},
+		{Kind: "data_app_crash", Process: "com.example.app", TimeMs: 50 * min},
+		// Not enough crashes.
+		{Kind: "data_app_crash", Process: "com.google.android.gms", TimeMs: 10 * min},
+		{Kind: "data_app_crash", Process: "com.google.android.gms", TimeMs: 11 * min},
+	}
+	history := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,3600000,100,`,
+		`CPU running,string,600000,1200000,,`,
+		`Partial wakelock,service,600000,900000,com.example.app,`,
+	}, "\n")
+	want := []CrashLoop{
+		{
+			Process:              "com.example.app",
+			Count:                3,
+			StartMs:              10 * min,
+			EndMs:                20 * min,
+			CPURunningPercent:    100,
+			WakelockPercent:      50,
+			AvgCPURunningPercent: 100 * 10.0 / 60,
+			AvgWakelockPercent:   100 * 5.0 / 60,
+			Spike:                true,
+		},
+	}
+	got, errs := CrashLoops(events, history)
+	if len(errs) > 0 {
+		t.Errorf("CrashLoops(%v, %v) generated unexpected errors: %v", events, history, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CrashLoops(%v, %v)\n got: %v\n want: %v", events, history, got, want)
+	}
+}
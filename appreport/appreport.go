@@ -0,0 +1,249 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appreport generates a standalone HTML report of a single app's battery history events,
+// such as its wakelocks, syncs and jobs, together with device context like doze and connectivity.
+// The report doesn't include the events of other apps, so it can be shared with the app's developers.
+package appreport
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// timelineWidth is the width in pixels of the timeline in the HTML report.
+	timelineWidth = 1000
+	// labelWidth is the width in pixels of the metric labels left of the timeline.
+	labelWidth = 200
+	// rowHeight is the height in pixels of each metric row of the timeline.
+	rowHeight = 20
+	// barColor is the fill color of the timeline events.
+	barColor = "#4285f4"
+)
+
+// ContextMetrics are the device wide battery history metrics included in every report, to give
+// context to the app's events.
+var ContextMetrics = []string{
+	"Battery Level",
+	"Plugged",
+	"Screen",
+	"Doze",
+	"Mobile network type",
+	"Mobile radio active",
+	"Wifi on",
+}
+
+// Row is the events of a single metric.
+type Row struct {
+	Metric string
+	// Context is true for the device wide metrics in ContextMetrics.
+	Context bool
+	Events  []csv.Event
+	// Total is the total duration of the events. Overlapping events are counted once.
+	Total time.Duration
+}
+
+// Report is the battery history of a single app.
+type Report struct {
+	Package string
+	UID     int32
+	// StartMs and EndMs are the time range of the battery history.
+	StartMs, EndMs int64
+	// Rows are the context metrics in ContextMetrics order, followed by the app's metrics sorted by name.
+	Rows []Row
+}
+
+// New returns the report for the app with the given UID, from the battery history CSV generated by
+// parseutils.AnalyzeHistory. The UID is matched against the app ID the CSV events are logged with.
+func New(historyCSV string, uid int32, pkg string) (*Report, []error) {
+	es, errs := csv.ExtractEvents(historyCSV, nil)
+	r := &Report{Package: pkg, UID: uid, StartMs: math.MaxInt64, EndMs: math.MinInt64}
+	isContext := make(map[string]bool)
+	for _, m := range ContextMetrics {
+		isContext[m] = true
+		if len(es[m]) > 0 {
+			r.Rows = append(r.Rows, newRow(m, true, es[m]))
+		}
+	}
+	var metrics []string
+	appEvents := make(map[string][]csv.Event)
+	id := fmt.Sprint(uid)
+	for m, events := range es {
+		if isContext[m] {
+			continue
+		}
+		for _, e := range events {
+			if e.Opt == id {
+				if len(appEvents[m]) == 0 {
+					metrics = append(metrics, m)
+				}
+				appEvents[m] = append(appEvents[m], e)
+			}
+		}
+	}
+	sort.Strings(metrics)
+	for _, m := range metrics {
+		r.Rows = append(r.Rows, newRow(m, false, appEvents[m]))
+	}
+	for _, row := range r.Rows {
+		for _, e := range row.Events {
+			if e.Start >= 0 && e.Start < r.StartMs {
+				r.StartMs = e.Start
+			}
+			if e.End > r.EndMs {
+				r.EndMs = e.End
+			}
+		}
+	}
+	if r.StartMs > r.EndMs {
+		r.StartMs, r.EndMs = 0, 0
+	}
+	return r, errs
+}
+
+// newRow returns the row for the events of a metric, sorted by start time.
+func newRow(metric string, context bool, events []csv.Event) Row {
+	sorted := append([]csv.Event(nil), events...)
+	csv.SortByStart(sorted)
+	var total int64
+	// MergeEvents reorders the events, so is given a copy.
+	for _, e := range csv.MergeEvents(append([]csv.Event(nil), sorted...)) {
+		total += e.End - e.Start
+	}
+	return Row{
+		Metric:  metric,
+		Context: context,
+		Events:  sorted,
+		Total:   time.Duration(total) * time.Millisecond,
+	}
+}
+
+// AppRows returns the rows of the app's metrics, excluding the context metrics.
+func (r *Report) AppRows() []Row {
+	var rows []Row
+	for _, row := range r.Rows {
+		if !row.Context {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// WriteCSV writes the events in the report as a battery history CSV.
+func (r *Report) WriteCSV(w io.Writer) {
+	csvState := csv.NewState(w, true)
+	for _, row := range r.Rows {
+		for _, e := range row.Events {
+			csvState.PrintEvent(row.Metric, e)
+		}
+	}
+}
+
+// bar is an event positioned on the timeline.
+type bar struct {
+	X, Width float64
+	Title    string
+}
+
+// timelineRow is a metric row positioned on the timeline.
+type timelineRow struct {
+	Row
+	Y    int
+	Bars []bar
+}
+
+// timeline positions the events of each row on a timeline of the given width.
+func (r *Report) timeline(width float64) []timelineRow {
+	var rows []timelineRow
+	span := float64(r.EndMs - r.StartMs)
+	for i, row := range r.Rows {
+		tr := timelineRow{Row: row, Y: i * rowHeight}
+		for _, e := range row.Events {
+			if e.Start < 0 || span <= 0 {
+				continue
+			}
+			x := width * float64(e.Start-r.StartMs) / span
+			w := width * float64(e.End-e.Start) / span
+			// Keep instant events visible.
+			if w < 1 {
+				w = 1
+			}
+			tr.Bars = append(tr.Bars, bar{
+				X:     x,
+				Width: w,
+				Title: fmt.Sprintf("%s: %s (%v)", e.Value, msToTime(e.Start).UTC().Format(time.RFC3339), time.Duration(e.End-e.Start)*time.Millisecond),
+			})
+		}
+		rows = append(rows, tr)
+	}
+	return rows
+}
+
+// msToTime converts a unix timestamp in milliseconds to a time.Time.
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+var reportTempl = template.Must(template.New("appreport").Parse(`<div class="app-report">
+<h2>Battery history of {{.Package}} (UID {{.UID}})</h2>
+<p>{{.Start}} - {{.End}} (UTC)</p>
+<table>
+<tr><th>Metric</th><th>Events</th><th>Total duration</th></tr>
+{{range .AppRows}}<tr><td>{{.Metric}}</td><td>{{len .Events}}</td><td>{{.Total}}</td></tr>
+{{else}}<tr><td colspan="3">No events for this app.</td></tr>
+{{end}}</table>
+<svg width="{{.Width}}" height="{{.Height}}" xmlns="http://www.w3.org/2000/svg">
+{{range .Timeline}}<g transform="translate(0,{{.Y}})">
+<text x="0" y="14" font-size="12">{{.Metric}}</text>
+{{range .Bars}}<rect x="{{printf "%.2f" .X}}" y="2" width="{{printf "%.2f" .Width}}" height="16" fill="{{$.Fill}}" transform="translate({{$.LabelWidth}},0)"><title>{{.Title}}</title></rect>
+{{end}}</g>
+{{end}}</svg>
+</div>
+`))
+
+// WriteHTML writes the report as an HTML snippet, with a summary table of the app's metrics and a
+// timeline of all rows.
+func (r *Report) WriteHTML(w io.Writer) error {
+	var b bytes.Buffer
+	err := reportTempl.Execute(&b, struct {
+		*Report
+		Start, End    string
+		Width, Height int
+		LabelWidth    int
+		Fill          string
+		Timeline      []timelineRow
+	}{
+		Report:     r,
+		Start:      msToTime(r.StartMs).UTC().Format(time.RFC3339),
+		End:        msToTime(r.EndMs).UTC().Format(time.RFC3339),
+		Width:      labelWidth + timelineWidth,
+		Height:     len(r.Rows) * rowHeight,
+		LabelWidth: labelWidth,
+		Fill:       barColor,
+		Timeline:   r.timeline(timelineWidth),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appreport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+var history = strings.Join([]string{
+	csv.FileHeader,
+	`Battery Level,int,0,3600000,100,`,
+	`Doze,string,600000,1200000,full,`,
+	`Partial wakelock,service,100000,200000,"10023,*sync*/gmail",10023`,
+	`Partial wakelock,service,150000,250000,"10023,GCM",10023`,
+	`Partial wakelock,service,100000,300000,"10045,Other app",10045`,
+	`SyncManager,service,300000,400000,"10023,gmail-ls/com.google",10023`,
+	`JobScheduler,service,500000,600000,"10045,com.other/.Job",10045`,
+	`Screen,bool,0,60000,true,`,
+}, "\n")
+
+// TestNew tests that only the app's events and the context metrics are included.
+func TestNew(t *testing.T) {
+	r, errs := New(history, 10023, "com.google.android.gm")
+	if len(errs) > 0 {
+		t.Fatalf("New generated unexpected errors: %v", errs)
+	}
+	var b bytes.Buffer
+	r.WriteCSV(&b)
+	want := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,3600000,100,`,
+		`Screen,bool,0,60000,true,`,
+		`Doze,string,600000,1200000,full,`,
+		`Partial wakelock,service,100000,200000,"10023,*sync*/gmail",10023`,
+		`Partial wakelock,service,150000,250000,"10023,GCM",10023`,
+		`SyncManager,service,300000,400000,"10023,gmail-ls/com.google",10023`,
+	}, "\n")
+	if got := strings.TrimSpace(b.String()); got != want {
+		t.Errorf("New(%v).WriteCSV()\n got: %v\n want: %v", history, got, want)
+	}
+	if r.StartMs != 0 || r.EndMs != 3600000 {
+		t.Errorf("New(%v) got range [%d, %d], want [0, 3600000]", history, r.StartMs, r.EndMs)
+	}
+	rows := r.AppRows()
+	if len(rows) != 2 {
+		t.Fatalf("New(%v).AppRows() got %d rows, want 2", history, len(rows))
+	}
+	// The overlapping wakelocks are counted once.
+	if got, want := rows[0].Total.String(), "2m30s"; got != want {
+		t.Errorf("New(%v) got total wakelock duration %v, want %v", history, got, want)
+	}
+}
+
+// TestWriteHTML tests that the HTML report doesn't include other apps.
+func TestWriteHTML(t *testing.T) {
+	r, _ := New(history, 10023, "com.google.android.gm")
+	var b bytes.Buffer
+	if err := r.WriteHTML(&b); err != nil {
+		t.Fatalf("WriteHTML() returned error: %v", err)
+	}
+	got := b.String()
+	for _, s := range []string{"com.google.android.gm", "SyncManager", "Doze"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("WriteHTML() output doesn't contain %q:\n%s", s, got)
+		}
+	}
+	for _, s := range []string{"Other app", "JobScheduler"} {
+		if strings.Contains(got, s) {
+			t.Errorf("WriteHTML() output contains other app's event %q:\n%s", s, got)
+		}
+	}
+}
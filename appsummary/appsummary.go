@@ -0,0 +1,219 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package appsummary exports the per app stats of the summaries generated by parseutils as a
+// documented JSON file, so that app developers can load Historian findings into their own tooling.
+//
+// The file is a single JSON object:
+//
+//	{
+//	  "format": "battery-historian-app-summary",
+//	  "version": 1,
+//	  "device": {"model": "Pixel", "sdkVersion": 25, "buildFingerprint": "..."},
+//	  "startTimeMs": 1422620000000,
+//	  "endTimeMs": 1422623600000,
+//	  "apps": [
+//	    {
+//	      "uid": 10045,
+//	      "package": "com.example.app",
+//	      "stats": [
+//	        {"metric": "WakeLockSummary", "displayName": "Wakelocks", "label": "*job*/com.example.app", "count": 3, "totalDurationMs": 5000, "maxDurationMs": 3000}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// Times are in milliseconds since epoch, and durations in milliseconds. The metrics are the names of
+// the per app ActivitySummary maps the stats are from, e.g. WakeLockSummary or ScheduledJobSummary,
+// and the display names are the readable names of the metrics in the "language" of the file.
+// Fields may be added within a version, so readers should ignore unknown fields.
+package appsummary
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// Format identifies the exported files.
+	Format = "battery-historian-app-summary"
+	// Version is the version of the file format, incremented on incompatible changes.
+	Version = 1
+)
+
+// File is the exported per app summary.
+type File struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Language is the language of the display names, see the messages package.
+	Language string `json:"language"`
+	Device   Device `json:"device"`
+	// StartTimeMs and EndTimeMs are the time range of the summaries the stats are from.
+	StartTimeMs int64 `json:"startTimeMs"`
+	EndTimeMs   int64 `json:"endTimeMs"`
+	// Apps are sorted by UID, then package.
+	Apps []App `json:"apps"`
+}
+
+// Device identifies the device the bug report is from.
+type Device struct {
+	Model            string `json:"model,omitempty"`
+	SDKVersion       int    `json:"sdkVersion,omitempty"`
+	BuildFingerprint string `json:"buildFingerprint,omitempty"`
+}
+
+// App is the stats attributed to a single app.
+type App struct {
+	// UID is the app ID of the app, 0 if unknown.
+	UID int32 `json:"uid"`
+	// Package is the name of the app package, empty if unknown, e.g. for shared UIDs.
+	Package string `json:"package,omitempty"`
+	// Stats are sorted by metric, then in descending order of total duration.
+	Stats []Stat `json:"stats"`
+}
+
+// Stat is the distribution of an app's entries of a metric, over all the summaries.
+type Stat struct {
+	Metric string `json:"metric"`
+	// DisplayName is the readable name of the metric.
+	DisplayName string `json:"displayName,omitempty"`
+	// Label is the service name of the entry, e.g. the wakelock tag or sync authority. It's empty
+	// for metrics that are only broken down by app.
+	Label           string `json:"label,omitempty"`
+	Count           int32  `json:"count"`
+	TotalDurationMs int64  `json:"totalDurationMs"`
+	MaxDurationMs   int64  `json:"maxDurationMs"`
+}
+
+// byUIDAndPackage sorts apps by UID, then package.
+type byUIDAndPackage []App
+
+func (a byUIDAndPackage) Len() int      { return len(a) }
+func (a byUIDAndPackage) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUIDAndPackage) Less(i, j int) bool {
+	if a[i].UID != a[j].UID {
+		return a[i].UID < a[j].UID
+	}
+	return a[i].Package < a[j].Package
+}
+
+// byMetricAndDuration sorts stats by metric, then in descending order of total duration, then by label.
+type byMetricAndDuration []Stat
+
+func (a byMetricAndDuration) Len() int      { return len(a) }
+func (a byMetricAndDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byMetricAndDuration) Less(i, j int) bool {
+	switch {
+	case a[i].Metric != a[j].Metric:
+		return a[i].Metric < a[j].Metric
+	case a[i].TotalDurationMs != a[j].TotalDurationMs:
+		return a[i].TotalDurationMs > a[j].TotalDurationMs
+	}
+	return a[i].Label < a[j].Label
+}
+
+// New returns the per app summary of the AppDists of the given summaries. Summaries from before the
+// device clock was set are skipped, as their times are meaningless. meta may be nil. The display
+// names are in the default language.
+func New(meta *bugreportutils.MetaInfo, summaries []parseutils.ActivitySummary) *File {
+	f := &File{Format: Format, Version: Version, Language: messages.DefaultLanguage, Apps: []App{}}
+	if meta != nil {
+		f.Device = Device{Model: meta.ModelName, SDKVersion: meta.SdkVersion, BuildFingerprint: meta.BuildFingerprint}
+	}
+
+	type appKey struct {
+		uid int32
+		pkg string
+	}
+	type statKey struct {
+		app           appKey
+		metric, label string
+	}
+	stats := make(map[statKey]Stat)
+	for _, s := range summaries {
+		if s.Untimed {
+			continue
+		}
+		if f.StartTimeMs == 0 || s.StartTimeMs < f.StartTimeMs {
+			f.StartTimeMs = s.StartTimeMs
+		}
+		if s.EndTimeMs > f.EndTimeMs {
+			f.EndTimeMs = s.EndTimeMs
+		}
+		for _, d := range s.AppDists {
+			k := statKey{appKey{d.Key.UID, d.Key.Package}, d.Metric, d.Key.Label}
+			st := stats[k]
+			st.Metric, st.Label = d.Metric, d.Key.Label
+			st.DisplayName = messages.MetricName(f.Language, d.Metric)
+			st.Count += d.Num
+			st.TotalDurationMs += d.TotalDurationMs
+			if d.MaxDurationMs > st.MaxDurationMs {
+				st.MaxDurationMs = d.MaxDurationMs
+			}
+			stats[k] = st
+		}
+	}
+
+	apps := make(map[appKey]*App)
+	for k, st := range stats {
+		a, ok := apps[k.app]
+		if !ok {
+			a = &App{UID: k.app.uid, Package: k.app.pkg}
+			apps[k.app] = a
+		}
+		a.Stats = append(a.Stats, st)
+	}
+	for _, a := range apps {
+		sort.Sort(byMetricAndDuration(a.Stats))
+		f.Apps = append(f.Apps, *a)
+	}
+	sort.Sort(byUIDAndPackage(f.Apps))
+	return f
+}
+
+// Filter removes the apps with a different UID than the given one, e.g. to share the summary with
+// the developers of a single app.
+func (f *File) Filter(uid int32) {
+	apps := []App{}
+	for _, a := range f.Apps {
+		if a.UID == uid {
+			apps = append(apps, a)
+		}
+	}
+	f.Apps = apps
+}
+
+// Localize sets the display names to the given language, falling back to the default language for
+// the metrics without names in it.
+func (f *File) Localize(lang string) {
+	f.Language = lang
+	for i := range f.Apps {
+		for j := range f.Apps[i].Stats {
+			st := &f.Apps[i].Stats[j]
+			st.DisplayName = messages.MetricName(lang, st.Metric)
+		}
+	}
+}
+
+// Write writes the summary as indented JSON.
+func (f *File) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
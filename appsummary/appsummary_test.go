@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appsummary
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// TestNew tests the aggregation of the app stats of several summaries.
+func TestNew(t *testing.T) {
+	maps := parseutils.AppKey{UID: 10023, Package: "com.google.android.apps.maps"}
+	wl := parseutils.AppKey{UID: 10045, Package: "com.example.app", Label: "*job*/com.example.app"}
+	summaries := []parseutils.ActivitySummary{
+		{
+			// Untimed summaries are skipped.
+			StartTimeMs: 1000,
+			EndTimeMs:   2000,
+			Untimed:     true,
+			AppDists:    []parseutils.AppDist{{Metric: "WakeLockSummary", Key: wl, Num: 9, TotalDurationMs: 9000, MaxDurationMs: 9000}},
+		},
+		{
+			StartTimeMs: 1422620000000,
+			EndTimeMs:   1422621000000,
+			AppDists: []parseutils.AppDist{
+				{Metric: "TopApplicationSummary", Key: maps, Num: 1, TotalDurationMs: 1500, MaxDurationMs: 1500},
+				{Metric: "WakeLockSummary", Key: wl, Num: 2, TotalDurationMs: 3000, MaxDurationMs: 2000},
+			},
+		},
+		{
+			StartTimeMs: 1422621000000,
+			EndTimeMs:   1422622000000,
+			AppDists: []parseutils.AppDist{
+				{Metric: "ScheduledJobSummary", Key: parseutils.AppKey{UID: 10045, Package: "com.example.app", Label: "com.example.app/.SyncJob"}, Num: 1, TotalDurationMs: 500, MaxDurationMs: 500},
+				{Metric: "WakeLockSummary", Key: wl, Num: 1, TotalDurationMs: 2500, MaxDurationMs: 2500},
+			},
+		},
+	}
+	meta := &bugreportutils.MetaInfo{ModelName: "Pixel", SdkVersion: 25, BuildFingerprint: "google/sailfish/sailfish:7.1.1/NMF26Q/1:user/release-keys"}
+	want := &File{
+		Format:      Format,
+		Version:     Version,
+		Language:    "en",
+		Device:      Device{Model: "Pixel", SDKVersion: 25, BuildFingerprint: "google/sailfish/sailfish:7.1.1/NMF26Q/1:user/release-keys"},
+		StartTimeMs: 1422620000000,
+		EndTimeMs:   1422622000000,
+		Apps: []App{
+			{
+				UID:     10023,
+				Package: "com.google.android.apps.maps",
+				Stats:   []Stat{{Metric: "TopApplicationSummary", DisplayName: "Top app", Count: 1, TotalDurationMs: 1500, MaxDurationMs: 1500}},
+			},
+			{
+				UID:     10045,
+				Package: "com.example.app",
+				Stats: []Stat{
+					{Metric: "ScheduledJobSummary", DisplayName: "Jobs", Label: "com.example.app/.SyncJob", Count: 1, TotalDurationMs: 500, MaxDurationMs: 500},
+					{Metric: "WakeLockSummary", DisplayName: "Wakelocks", Label: "*job*/com.example.app", Count: 3, TotalDurationMs: 5500, MaxDurationMs: 2500},
+				},
+			},
+		},
+	}
+	got := New(meta, summaries)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("New(%v, %v)\n got: %+v\n want: %+v", meta, summaries, got, want)
+	}
+
+	got.Filter(10045)
+	if len(got.Apps) != 1 || got.Apps[0].UID != 10045 {
+		t.Errorf("Filter(10045) kept apps %+v, want only UID 10045", got.Apps)
+	}
+	var b bytes.Buffer
+	if err := got.Write(&b); err != nil {
+		t.Fatalf("Write returned unexpected error: %v", err)
+	}
+	var read File
+	if err := json.Unmarshal(b.Bytes(), &read); err != nil {
+		t.Fatalf("Could not read written summary: %v", err)
+	}
+	if !reflect.DeepEqual(&read, got) {
+		t.Errorf("Written summary read as %+v, want %+v", read, got)
+	}
+}
+
+// TestLocalize tests that the display names are set in the given language, falling back to the default language.
+func TestLocalize(t *testing.T) {
+	if err := messages.AddCatalog(`{"fr": {"metric.WakeLockSummary": "Wakelocks partiels"}}`); err != nil {
+		t.Fatalf("AddCatalog returned unexpected error: %v", err)
+	}
+	defer messages.RemoveLanguage("fr")
+	f := &File{Apps: []App{{UID: 10045, Stats: []Stat{{Metric: "ScheduledJobSummary"}, {Metric: "WakeLockSummary"}, {Metric: "UnknownSummary"}}}}}
+	f.Localize("fr")
+	want := []string{"Jobs", "Wakelocks partiels", "UnknownSummary"}
+	var got []string
+	for _, st := range f.Apps[0].Stats {
+		got = append(got, st.DisplayName)
+	}
+	if f.Language != "fr" || !reflect.DeepEqual(got, want) {
+		t.Errorf("Localize(fr) set language %q and display names %q, want fr and %q", f.Language, got, want)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audio attributes the audio playback in the battery history to apps, using the audio focus
+// events logged in the audio service dump (dumpsys audio) of a bug report.
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// Metric is the CSV description of the app attributed for audio playback.
+	Metric = "Audio app"
+
+	// audioService is the name of the audio service dump.
+	audioService = "audio"
+
+	// audioOn is the battery history CSV metric for audio playback.
+	audioOn = "Audio"
+
+	// focusGain is the AUDIOFOCUS_GAIN request, where the previous focus holders lose focus permanently.
+	focusGain = 1
+
+	// Unknown is the app attributed for playback while no app held audio focus.
+	Unknown = "unknown"
+)
+
+var (
+	// requestRE matches an audio focus request in the focus event log.
+	// e.g. "02-15 10:24:11:123 requestAudioFocus() from uid/pid 10067/4321 clientId=android.media.AudioManager@a1b2c3 callingPack=com.spotify.music req=1 flags=0x0 sdk=28"
+	requestRE = regexp.MustCompile(`^\s*(?P<month>\d{2})-(?P<day>\d{2}) (?P<time>\d{2}:\d{2}:\d{2})[:.](?P<remainder>\d+) requestAudioFocus\(\) from uid/pid (?P<uid>\d+)/\d+ clientId=(?P<client>\S+) callingPack=(?P<pkg>\S+) req=(?P<req>\d+)`)
+
+	// abandonRE matches an audio focus abandon in the focus event log.
+	// e.g. "02-15 10:30:00:001 abandonAudioFocus() from uid/pid 10067/4321 clientId=android.media.AudioManager@a1b2c3"
+	abandonRE = regexp.MustCompile(`^\s*(?P<month>\d{2})-(?P<day>\d{2}) (?P<time>\d{2}:\d{2}:\d{2})[:.](?P<remainder>\d+) abandonAudioFocus\(\) from uid/pid (?P<uid>\d+)/\d+ clientId=(?P<client>\S+)`)
+)
+
+// Holder is an app holding audio focus over a time range.
+type Holder struct {
+	Package string
+	UID     int32
+	// StartMs and EndMs are the time range the app held focus, in unix time ms.
+	StartMs, EndMs int64
+}
+
+// focusEntry is an entry of the audio focus stack.
+type focusEntry struct {
+	client, pkg string
+	uid         int32
+}
+
+// extractAudioDump returns the lines of the audio service dump in the bug report.
+func extractAudioDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == audioService
+			continue
+		}
+		if in {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse replays the audio focus events in the bug report, taken at the given time, and returns the
+// ranges each app was at the top of the audio focus stack, sorted by start time. An app still holding
+// focus when the bug report was taken holds it until then.
+func Parse(bugreport string, taken time.Time) ([]Holder, []error) {
+	var errs []error
+	var stack []focusEntry
+	var holders []Holder
+	var cur *Holder
+	takenMs := taken.UnixNano() / int64(time.Millisecond)
+
+	// update ends the current holder's range and starts the next one, if the top of the stack changed.
+	update := func(ms int64) {
+		var top *focusEntry
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		if cur != nil && top != nil && cur.Package == top.pkg && cur.UID == top.uid {
+			return
+		}
+		if cur != nil {
+			cur.EndMs = ms
+			holders = append(holders, *cur)
+			cur = nil
+		}
+		if top != nil {
+			cur = &Holder{Package: top.pkg, UID: top.uid, StartMs: ms}
+		}
+	}
+	remove := func(client string) {
+		for i, e := range stack {
+			if e.client == client {
+				stack = append(stack[:i], stack[i+1:]...)
+				return
+			}
+		}
+	}
+
+	for _, line := range extractAudioDump(bugreport) {
+		if m, result := historianutils.SubexpNames(requestRE, line); m {
+			ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid audio focus timestamp %q: %v", line, err))
+				continue
+			}
+			uid, err := packageutils.AppIDFromString(result["uid"])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			remove(result["client"])
+			if req, _ := strconv.Atoi(result["req"]); req == focusGain {
+				// Transient focus requests are stacked, permanent ones replace all previous holders.
+				stack = nil
+			}
+			stack = append(stack, focusEntry{client: result["client"], pkg: result["pkg"], uid: uid})
+			update(ms)
+			continue
+		}
+		if m, result := historianutils.SubexpNames(abandonRE, line); m {
+			ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid audio focus timestamp %q: %v", line, err))
+				continue
+			}
+			remove(result["client"])
+			update(ms)
+		}
+	}
+	if cur != nil {
+		cur.EndMs = takenMs
+		holders = append(holders, *cur)
+	}
+	sort.Stable(byStart(holders))
+	return holders, errs
+}
+
+// byStart sorts holders in ascending order of start time.
+type byStart []Holder
+
+func (a byStart) Len() int           { return len(a) }
+func (a byStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool { return a[i].StartMs < a[j].StartMs }
+
+// AppSummary is the audio playback attributed to an app.
+type AppSummary struct {
+	Package string
+	UID     int32
+	// Count is the number of audio playback periods the app was attributed for.
+	Count         int
+	TotalDuration time.Duration
+}
+
+// byDuration sorts summaries in descending order of duration, then by package.
+type byDuration []AppSummary
+
+func (a byDuration) Len() int      { return len(a) }
+func (a byDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDuration) Less(i, j int) bool {
+	if a[i].TotalDuration != a[j].TotalDuration {
+		return a[i].TotalDuration > a[j].TotalDuration
+	}
+	return a[i].Package < a[j].Package
+}
+
+// Attribute splits each audio playback period in the battery history CSV generated by AnalyzeHistory
+// by the apps holding audio focus, and returns the attributed periods as Metric CSV events, along with
+// the total playback per app. Playback while no app held focus is attributed to Unknown.
+func Attribute(holders []Holder, historyCSV string) (string, []AppSummary, []error) {
+	es, errs := csv.ExtractEvents(historyCSV, []string{audioOn})
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	type key struct {
+		pkg string
+		uid int32
+	}
+	sums := make(map[key]*AppSummary)
+	add := func(pkg string, uid int32, start, end int64) {
+		if end <= start {
+			return
+		}
+		opt := ""
+		if pkg != Unknown {
+			opt = fmt.Sprint(uid)
+		}
+		csvState.Print(Metric, "service", start, end, pkg, opt)
+		k := key{pkg, uid}
+		s, ok := sums[k]
+		if !ok {
+			s = &AppSummary{Package: pkg, UID: uid}
+			sums[k] = s
+		}
+		s.Count++
+		s.TotalDuration += time.Duration(end-start) * time.Millisecond
+	}
+	for _, a := range csv.MergeEvents(es[audioOn]) {
+		cur := a.Start
+		for _, h := range holders {
+			if h.EndMs <= cur || h.StartMs >= a.End {
+				continue
+			}
+			start, end := h.StartMs, h.EndMs
+			if start < cur {
+				start = cur
+			}
+			if end > a.End {
+				end = a.End
+			}
+			add(Unknown, 0, cur, start)
+			add(h.Package, h.UID, start, end)
+			cur = end
+		}
+		add(Unknown, 0, cur, a.End)
+	}
+	var summaries []AppSummary
+	for _, s := range sums {
+		summaries = append(summaries, *s)
+	}
+	sort.Sort(byDuration(summaries))
+	return b.String(), summaries, errs
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.February, 15, 0, 0, 0, 0, time.UTC)
+
+// TestParseAndAttribute tests the replay of audio focus events and the attribution of audio playback.
+func TestParseAndAttribute(t *testing.T) {
+	br := strings.Join([]string{
+		"DUMP OF SERVICE audio:",
+		"Audio event log: focus commands as seen by MediaFocusControl",
+		"02-15 10:00:00:000 requestAudioFocus() from uid/pid 10067/4321 clientId=c1 callingPack=com.spotify.music req=1 flags=0x0 sdk=28",
+		// Transient focus request, e.g. navigation directions.
+		"02-15 10:10:00:000 requestAudioFocus() from uid/pid 1010020/555 clientId=c2 callingPack=com.google.android.apps.maps req=3 flags=0x0 sdk=28",
+		"02-15 10:10:05:000 abandonAudioFocus() from uid/pid 1010020/555 clientId=c2",
+		"02-15 10:20:00:000 abandonAudioFocus() from uid/pid 10067/4321 clientId=c1",
+		"02-15 10:40:00:000 requestAudioFocus() from uid/pid 10067/4321 clientId=c3 callingPack=com.spotify.music req=1 flags=0x0 sdk=28",
+		"DUMP OF SERVICE batterystats:",
+		"02-15 10:50:00:000 abandonAudioFocus() from uid/pid 10067/4321 clientId=c3",
+	}, "\n")
+	taken := time.Date(2017, time.February, 15, 11, 0, 0, 0, time.UTC)
+
+	wantHolders := []Holder{
+		{Package: "com.spotify.music", UID: 10067, StartMs: historianutils.ClockMs(reportDay, 10, 0, 0), EndMs: historianutils.ClockMs(reportDay, 10, 10, 0)},
+		{Package: "com.google.android.apps.maps", UID: 10020, StartMs: historianutils.ClockMs(reportDay, 10, 10, 0), EndMs: historianutils.ClockMs(reportDay, 10, 10, 5)},
+		{Package: "com.spotify.music", UID: 10067, StartMs: historianutils.ClockMs(reportDay, 10, 10, 5), EndMs: historianutils.ClockMs(reportDay, 10, 20, 0)},
+		// Still holding focus when the bug report was taken.
+		{Package: "com.spotify.music", UID: 10067, StartMs: historianutils.ClockMs(reportDay, 10, 40, 0), EndMs: historianutils.ClockMs(reportDay, 11, 0, 0)},
+	}
+	holders, errs := Parse(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(holders, wantHolders) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", br, holders, wantHolders)
+	}
+
+	history := strings.Join([]string{
+		csv.FileHeader,
+		fmt.Sprintf("Audio,bool,%d,%d,true,", historianutils.ClockMs(reportDay, 9, 59, 0), historianutils.ClockMs(reportDay, 10, 15, 0)),
+		fmt.Sprintf("Audio,bool,%d,%d,true,", historianutils.ClockMs(reportDay, 10, 30, 0), historianutils.ClockMs(reportDay, 10, 31, 0)),
+	}, "\n")
+	wantCSV := strings.Join([]string{
+		fmt.Sprintf("Audio app,service,%d,%d,unknown,", historianutils.ClockMs(reportDay, 9, 59, 0), historianutils.ClockMs(reportDay, 10, 0, 0)),
+		fmt.Sprintf("Audio app,service,%d,%d,com.spotify.music,10067", historianutils.ClockMs(reportDay, 10, 0, 0), historianutils.ClockMs(reportDay, 10, 10, 0)),
+		fmt.Sprintf("Audio app,service,%d,%d,com.google.android.apps.maps,10020", historianutils.ClockMs(reportDay, 10, 10, 0), historianutils.ClockMs(reportDay, 10, 10, 5)),
+		fmt.Sprintf("Audio app,service,%d,%d,com.spotify.music,10067", historianutils.ClockMs(reportDay, 10, 10, 5), historianutils.ClockMs(reportDay, 10, 15, 0)),
+		fmt.Sprintf("Audio app,service,%d,%d,unknown,", historianutils.ClockMs(reportDay, 10, 30, 0), historianutils.ClockMs(reportDay, 10, 31, 0)),
+		"",
+	}, "\n")
+	wantSummaries := []AppSummary{
+		{Package: "com.spotify.music", UID: 10067, Count: 2, TotalDuration: 14*time.Minute + 55*time.Second},
+		{Package: Unknown, Count: 2, TotalDuration: 2 * time.Minute},
+		{Package: "com.google.android.apps.maps", UID: 10020, Count: 1, TotalDuration: 5 * time.Second},
+	}
+	gotCSV, summaries, errs := Attribute(holders, history)
+	if len(errs) > 0 {
+		t.Fatalf("Attribute generated unexpected errors: %v", errs)
+	}
+	if gotCSV != wantCSV {
+		t.Errorf("Attribute(%v) CSV\n got: %q\n want: %q", history, gotCSV, wantCSV)
+	}
+	if !reflect.DeepEqual(summaries, wantSummaries) {
+		t.Errorf("Attribute(%v) summaries\n got: %v\n want: %v", history, summaries, wantSummaries)
+	}
+}
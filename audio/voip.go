@@ -0,0 +1,149 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+// voip.go detects VoIP calls from the audio mode changes logged in the audio service dump. Apps set
+// MODE_IN_COMMUNICATION for the duration of a VoIP call, while cellular calls use MODE_IN_CALL.
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// VoIPMetric is the CSV description of VoIP calls.
+	VoIPMetric = "VoIP call"
+
+	// modeInCommunication is the audio mode set by apps during VoIP calls.
+	modeInCommunication = "MODE_IN_COMMUNICATION"
+)
+
+var (
+	// setModeRE matches an audio mode change in the phone state event log. The selected mode is only
+	// logged by newer platforms, and can differ from the requested one if another app owns the mode.
+	// e.g. "02-15 10:24:11:123 setMode(MODE_IN_COMMUNICATION) from package=com.whatsapp pid=4321 selected mode=MODE_IN_COMMUNICATION by pid=4321"
+	setModeRE = regexp.MustCompile(`^\s*(?P<month>\d{2})-(?P<day>\d{2}) (?P<time>\d{2}:\d{2}:\d{2})[:.](?P<remainder>\d+) setMode\((?P<requested>\w+)\) from package=(?P<pkg>\S+) pid=(?P<pid>\d+)( selected mode=(?P<selected>\w+))?`)
+
+	// communicationDeviceRE matches a communication device change in the audio event log, which is the
+	// only place the caller UID is logged.
+	// e.g. "02-15 10:24:11:456 setCommunicationDevice(AudioDeviceAttributes: role:output type:earpiece addr: name:) from u/pid:10123/4321"
+	communicationDeviceRE = regexp.MustCompile(`^\s*(?P<month>\d{2})-(?P<day>\d{2}) (?P<time>\d{2}:\d{2}:\d{2})[:.](?P<remainder>\d+) setCommunicationDevice\(.*\) from u/pid:(?P<uid>\d+)/(?P<pid>\d+)`)
+)
+
+// Call is a VoIP call made by an app.
+type Call struct {
+	Package string
+	// UID is the app ID of the app, from its communication device changes, 0 if unknown.
+	UID int32
+	// StartMs and EndMs are the time range the app held the communication mode, in unix time ms.
+	StartMs, EndMs int64
+}
+
+// ParseVoIP replays the audio mode changes in the bug report, taken at the given time, and returns the
+// VoIP calls, sorted by start time. A call still in progress when the bug report was taken lasts until then.
+func ParseVoIP(bugreport string, taken time.Time) ([]Call, []error) {
+	var errs []error
+	var calls []Call
+	// pids are the PIDs of the apps that set the mode for the calls.
+	var pids []string
+	var cur *Call
+	var curPid string
+	type deviceChange struct {
+		ms       int64
+		uid, pid string
+	}
+	var devices []deviceChange
+
+	end := func(ms int64) {
+		if cur != nil {
+			cur.EndMs = ms
+			calls = append(calls, *cur)
+			pids = append(pids, curPid)
+			cur = nil
+		}
+	}
+	for _, line := range extractAudioDump(bugreport) {
+		if m, result := historianutils.SubexpNames(setModeRE, line); m {
+			ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid audio mode timestamp %q: %v", line, err))
+				continue
+			}
+			mode := result["selected"]
+			if mode == "" {
+				mode = result["requested"]
+			}
+			if cur != nil && mode == modeInCommunication && cur.Package == result["pkg"] {
+				continue
+			}
+			// The audio mode is global, so any other mode ends the call in progress.
+			end(ms)
+			if mode == modeInCommunication {
+				cur = &Call{Package: result["pkg"], StartMs: ms}
+				curPid = result["pid"]
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(communicationDeviceRE, line); m {
+			ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid communication device timestamp %q: %v", line, err))
+				continue
+			}
+			devices = append(devices, deviceChange{ms, result["uid"], result["pid"]})
+		}
+	}
+	end(taken.UnixNano() / int64(time.Millisecond))
+
+	// The communication device and audio mode are logged separately, so match them by the caller PID.
+	for i := range calls {
+		c := &calls[i]
+		for _, d := range devices {
+			if d.pid != pids[i] || d.ms < c.StartMs || d.ms > c.EndMs {
+				continue
+			}
+			uid, err := packageutils.AppIDFromString(d.uid)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			c.UID = uid
+			break
+		}
+	}
+	// Calls are only ended in order, so are already sorted by start time.
+	return calls, errs
+}
+
+// VoIPCSV returns the calls as VoIPMetric CSV events, so they can be seen on the timeline.
+func VoIPCSV(calls []Call) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, c := range calls {
+		opt := ""
+		if c.UID != 0 {
+			opt = fmt.Sprint(c.UID)
+		}
+		csvState.Print(VoIPMetric, "service", c.StartMs, c.EndMs, c.Package, opt)
+	}
+	return b.String()
+}
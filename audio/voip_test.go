@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audio
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+// TestParseVoIP tests the detection of VoIP calls from the audio mode changes.
+func TestParseVoIP(t *testing.T) {
+	br := strings.Join([]string{
+		"DUMP OF SERVICE audio:",
+		"Audio event log: phone state (logged after successful call to AudioService.setMode())",
+		"02-15 10:00:00:000 setMode(MODE_IN_COMMUNICATION) from package=com.whatsapp pid=4321",
+		// Repeated mode changes by the same app don't start a new call.
+		"02-15 10:01:00:000 setMode(MODE_IN_COMMUNICATION) from package=com.whatsapp pid=4321",
+		"02-15 10:05:00:000 setMode(MODE_NORMAL) from package=com.whatsapp pid=4321",
+		// Cellular call.
+		"02-15 10:10:00:000 setMode(MODE_IN_CALL) from package=com.android.phone pid=1001",
+		"02-15 10:20:00:000 setMode(MODE_NORMAL) from package=com.android.phone pid=1001",
+		// Another app requesting the communication mode while it's owned by a cellular call doesn't get it.
+		"02-15 10:25:00:000 setMode(MODE_IN_CALL) from package=com.android.phone pid=1001 selected mode=MODE_IN_CALL by pid=1001",
+		"02-15 10:26:00:000 setMode(MODE_IN_COMMUNICATION) from package=org.telegram.messenger pid=5555 selected mode=MODE_IN_CALL by pid=1001",
+		"02-15 10:30:00:000 setMode(MODE_IN_COMMUNICATION) from package=com.skype.raider pid=6666 selected mode=MODE_IN_COMMUNICATION by pid=6666",
+		"Audio event log: communication route",
+		"02-15 10:00:01:000 setCommunicationDevice(AudioDeviceAttributes: role:output type:earpiece addr: name:) from u/pid:1010123/4321",
+		// Different PID from the call in progress.
+		"02-15 10:30:01:000 setCommunicationDevice(AudioDeviceAttributes: role:output type:speaker addr: name:) from u/pid:10099/7777",
+		"DUMP OF SERVICE batterystats:",
+	}, "\n")
+	taken := time.Date(2017, time.February, 15, 11, 0, 0, 0, time.UTC)
+
+	want := []Call{
+		{Package: "com.whatsapp", UID: 10123, StartMs: historianutils.ClockMs(reportDay, 10, 0, 0), EndMs: historianutils.ClockMs(reportDay, 10, 5, 0)},
+		// Still in progress when the bug report was taken.
+		{Package: "com.skype.raider", StartMs: historianutils.ClockMs(reportDay, 10, 30, 0), EndMs: historianutils.ClockMs(reportDay, 11, 0, 0)},
+	}
+	calls, errs := ParseVoIP(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("ParseVoIP generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("ParseVoIP(%v)\n got: %v\n want: %v", br, calls, want)
+	}
+
+	wantCSV := strings.Join([]string{
+		fmt.Sprintf("VoIP call,service,%d,%d,com.whatsapp,10123", historianutils.ClockMs(reportDay, 10, 0, 0), historianutils.ClockMs(reportDay, 10, 5, 0)),
+		fmt.Sprintf("VoIP call,service,%d,%d,com.skype.raider,", historianutils.ClockMs(reportDay, 10, 30, 0), historianutils.ClockMs(reportDay, 11, 0, 0)),
+		"",
+	}, "\n")
+	if got := VoIPCSV(calls); got != wantCSV {
+		t.Errorf("VoIPCSV(%v)\n got: %q\n want: %q", calls, got, wantCSV)
+	}
+}
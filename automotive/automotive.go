@@ -0,0 +1,239 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package automotive detects the parked sessions of Android Automotive bug reports, when the car
+// runs garage mode maintenance after the driver leaves or is suspended to RAM, from the car power
+// management logs, and computes the battery drain of each session separately from the driving time.
+package automotive
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// Metric is the CSV description of the parked sessions.
+	Metric = "Parked session"
+
+	// Session types.
+	GarageMode   = "Garage mode"
+	SuspendToRAM = "Suspend to RAM"
+
+	// batteryLevel is the battery history CSV metric for the battery level.
+	batteryLevel = "Battery Level"
+)
+
+var (
+	// logRE matches a car power management logcat line.
+	// e.g. "02-15 22:04:11.123  1234  1250 I CAR.GarageMode: Entering GarageMode"
+	logRE = bugreportutils.LogcatTagRE(`\S*(GarageMode|CAR\.POWER|CarPower)\S*`)
+
+	// Messages of the car power management logs starting and ending the sessions.
+	garageModeStartRE = regexp.MustCompile(`(?i)entering garage ?mode`)
+	garageModeEndRE   = regexp.MustCompile(`(?i)exiting garage ?mode`)
+	suspendStartRE    = regexp.MustCompile(`(?i)entering deep sleep|suspend(ing)? to ram`)
+	suspendEndRE      = regexp.MustCompile(`(?i)resum(ed|ing) (from|after) (deep sleep|suspend)`)
+)
+
+// Session is a period the car was parked, either running garage mode or suspended to RAM.
+type Session struct {
+	// Type is GarageMode or SuspendToRAM.
+	Type           string
+	StartMs, EndMs int64
+	// LevelDrop is the battery level drop over the session, in percent, negative if the battery charged.
+	LevelDrop int
+	// LevelKnown is whether the battery level is known at both ends of the session.
+	LevelKnown bool
+}
+
+// Start returns the start of the session.
+func (s Session) Start() time.Time {
+	return time.Unix(0, s.StartMs*int64(time.Millisecond))
+}
+
+// Duration returns the length of the session.
+func (s Session) Duration() time.Duration {
+	return time.Duration(s.EndMs-s.StartMs) * time.Millisecond
+}
+
+// PercentPerHour returns the battery level drop per hour of the session.
+func (s Session) PercentPerHour() float64 {
+	if s.EndMs <= s.StartMs {
+		return 0
+	}
+	return float64(s.LevelDrop) / s.Duration().Hours()
+}
+
+// marker is a log line starting or ending a session.
+type marker struct {
+	ms    int64
+	start bool
+	typ   string
+}
+
+// byTime sorts markers in ascending order of time.
+type byTime []marker
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].ms < a[j].ms }
+
+// Parse returns the garage mode and suspend to RAM sessions logged in the bug report, taken at the
+// given time, sorted by start time. A session ends when the next one starts, and a session still in
+// progress when the bug report was taken lasts until then. It returns nil for non automotive reports.
+func Parse(bugreport string, taken time.Time) ([]Session, []error) {
+	var errs []error
+	var markers []marker
+	for _, line := range strings.Split(bugreport, "\n") {
+		m, result := historianutils.SubexpNames(logRE, strings.TrimRight(line, "\r"))
+		if !m {
+			continue
+		}
+		var mk marker
+		switch msg := result["msg"]; {
+		case garageModeStartRE.MatchString(msg):
+			mk = marker{start: true, typ: GarageMode}
+		case garageModeEndRE.MatchString(msg):
+			mk = marker{typ: GarageMode}
+		case suspendStartRE.MatchString(msg):
+			mk = marker{start: true, typ: SuspendToRAM}
+		case suspendEndRE.MatchString(msg):
+			mk = marker{typ: SuspendToRAM}
+		default:
+			continue
+		}
+		ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s timestamp %q: %v", result["tag"], line, err))
+			continue
+		}
+		mk.ms = ms
+		markers = append(markers, mk)
+	}
+	// The logcat sections aren't necessarily in time order, e.g. the last logcat before a reboot.
+	sort.Stable(byTime(markers))
+
+	var sessions []Session
+	var cur *Session
+	end := func(ms int64) {
+		if cur != nil {
+			cur.EndMs = ms
+			sessions = append(sessions, *cur)
+			cur = nil
+		}
+	}
+	for _, mk := range markers {
+		switch {
+		case !mk.start:
+			if cur != nil && cur.Type == mk.typ {
+				end(mk.ms)
+			}
+		case cur == nil || cur.Type != mk.typ:
+			end(mk.ms)
+			cur = &Session{Type: mk.typ, StartMs: mk.ms}
+		}
+	}
+	end(taken.UnixNano() / int64(time.Millisecond))
+	return sessions, errs
+}
+
+// levelAt returns the battery level at the given time from the sorted battery level events.
+func levelAt(levels []csv.Event, ms int64) (int, bool) {
+	for _, e := range levels {
+		if e.Interval().Contains(ms) {
+			l, err := strconv.Atoi(e.Value)
+			return l, err == nil
+		}
+	}
+	return 0, false
+}
+
+// AddDrain sets the battery level drop of the sessions from the battery history CSV generated by AnalyzeHistory.
+func AddDrain(sessions []Session, historyCSV string) []error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{batteryLevel})
+	levels := es[batteryLevel]
+	csv.SortByStart(levels)
+	for i := range sessions {
+		s := &sessions[i]
+		start, ok1 := levelAt(levels, s.StartMs)
+		end, ok2 := levelAt(levels, s.EndMs)
+		s.LevelKnown = ok1 && ok2
+		if s.LevelKnown {
+			s.LevelDrop = start - end
+		}
+	}
+	return errs
+}
+
+// Total is the combined duration and battery drain of the sessions of a type.
+type Total struct {
+	Type  string
+	Count int
+	// Duration and LevelDrop only include the sessions with a known battery level drop.
+	Duration  time.Duration
+	LevelDrop int
+}
+
+// PercentPerHour returns the battery level drop per hour of the sessions.
+func (t Total) PercentPerHour() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.LevelDrop) / t.Duration.Hours()
+}
+
+// Totals returns the totals of the garage mode and suspend to RAM sessions, in that order, for the
+// types with any sessions.
+func Totals(sessions []Session) []Total {
+	var totals []Total
+	for _, typ := range []string{GarageMode, SuspendToRAM} {
+		t := Total{Type: typ}
+		for _, s := range sessions {
+			if s.Type != typ {
+				continue
+			}
+			t.Count++
+			if s.LevelKnown {
+				t.Duration += s.Duration()
+				t.LevelDrop += s.LevelDrop
+			}
+		}
+		if t.Count > 0 {
+			totals = append(totals, t)
+		}
+	}
+	return totals
+}
+
+// CSV returns the sessions as Metric CSV events, so they can be seen on the timeline.
+func CSV(sessions []Session) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, s := range sessions {
+		csvState.Print(Metric, "string", s.StartMs, s.EndMs, s.Type, "")
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package automotive
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.February, 15, 0, 0, 0, 0, time.UTC)
+
+// TestParse tests the detection of the garage mode and suspend to RAM sessions.
+func TestParse(t *testing.T) {
+	taken := time.Date(2017, time.February, 16, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc  string
+		input []string
+		want  []Session
+	}{
+		{
+			desc: "Not an automotive report",
+			input: []string{
+				`02-15 22:04:11.000  1234  1250 I ActivityManager: Start proc 4321:com.example.app/u0a123`,
+			},
+		},
+		{
+			desc: "Garage mode followed by suspend to RAM",
+			input: []string{
+				`02-15 22:00:00.000  1234  1250 I CAR.GarageMode: Entering GarageMode`,
+				`02-15 22:10:00.000  1234  1250 I CAR.GarageMode: Entering GarageMode`,
+				`02-15 22:30:00.000  1234  1250 I CAR.GarageMode: Exiting GarageMode`,
+				`02-15 22:31:00.000  1234  1250 I CAR.POWER: Entering deep sleep`,
+				`02-15 23:31:00.000  1234  1250 I CAR.POWER: Resuming after suspending`,
+				// Not a session marker.
+				`02-15 23:32:00.000  1234  1250 I CAR.POWER: setCurrentState CpmsState: ON`,
+			},
+			want: []Session{
+				{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 22, 0, 0), EndMs: historianutils.ClockMs(reportDay, 22, 30, 0)},
+				{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 31, 0), EndMs: historianutils.ClockMs(reportDay, 23, 31, 0)},
+			},
+		},
+		{
+			desc: "Suspended without garage mode ending, still running garage mode when taken",
+			input: []string{
+				`02-15 22:00:00.000  1234  1250 I CAR.GarageMode: Entering GarageMode`,
+				`02-15 22:20:00.000  1234  1250 I CarPowerManagementService: Suspend to RAM`,
+				`02-15 23:00:00.000  1234  1250 I CarPowerManagementService: Resumed from suspend`,
+				`02-16 07:00:00.000  1234  1250 I CAR.GarageMode: Entering GarageMode`,
+			},
+			want: []Session{
+				{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 22, 0, 0), EndMs: historianutils.ClockMs(reportDay, 22, 20, 0)},
+				{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 20, 0), EndMs: historianutils.ClockMs(reportDay, 23, 0, 0)},
+				{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 31, 0, 0), EndMs: historianutils.ClockMs(reportDay, 32, 0, 0)},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, errs := Parse(strings.Join(test.input, "\n"), taken)
+		if len(errs) > 0 {
+			t.Errorf("%s: Parse generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Parse(%v)\n got: %+v\n want: %+v", test.desc, test.input, got, test.want)
+		}
+	}
+}
+
+// TestAddDrain tests the battery drain of the sessions and the totals per session type.
+func TestAddDrain(t *testing.T) {
+	historyCSV := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,1487196000000,1487197800000,80,`, // 22:00 - 22:30
+		`Battery Level,int,1487197800000,1487201400000,79,`, // 22:30 - 23:30
+		`Battery Level,int,1487201400000,1487203200000,77,`, // 23:30 - 24:00
+	}, "\n")
+	sessions := []Session{
+		{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 22, 0, 0), EndMs: historianutils.ClockMs(reportDay, 22, 40, 0)},
+		{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 40, 0), EndMs: historianutils.ClockMs(reportDay, 23, 40, 0)},
+		// At the level changes, which start the new levels.
+		{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 30, 0), EndMs: historianutils.ClockMs(reportDay, 23, 30, 0)},
+		// After the battery history.
+		{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 23, 50, 0), EndMs: historianutils.ClockMs(reportDay, 24, 30, 0)},
+	}
+	if errs := AddDrain(sessions, historyCSV); len(errs) > 0 {
+		t.Fatalf("AddDrain generated unexpected errors: %v", errs)
+	}
+	want := []Session{
+		{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 22, 0, 0), EndMs: historianutils.ClockMs(reportDay, 22, 40, 0), LevelDrop: 1, LevelKnown: true},
+		{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 40, 0), EndMs: historianutils.ClockMs(reportDay, 23, 40, 0), LevelDrop: 2, LevelKnown: true},
+		{Type: SuspendToRAM, StartMs: historianutils.ClockMs(reportDay, 22, 30, 0), EndMs: historianutils.ClockMs(reportDay, 23, 30, 0), LevelDrop: 2, LevelKnown: true},
+		{Type: GarageMode, StartMs: historianutils.ClockMs(reportDay, 23, 50, 0), EndMs: historianutils.ClockMs(reportDay, 24, 30, 0)},
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("AddDrain(...)\n got: %+v\n want: %+v", sessions, want)
+	}
+
+	wantTotals := []Total{
+		{Type: GarageMode, Count: 2, Duration: 40 * time.Minute, LevelDrop: 1},
+		{Type: SuspendToRAM, Count: 2, Duration: 2 * time.Hour, LevelDrop: 4},
+	}
+	if got := Totals(sessions); !reflect.DeepEqual(got, wantTotals) {
+		t.Errorf("Totals(%v)\n got: %+v\n want: %+v", sessions, got, wantTotals)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bluetooth reads the per app BLE scan statistics from the Bluetooth manager service dump
+// (dumpsys bluetooth_manager) of a bug report, and joins them with the BLE scanning periods of the
+// battery history. The battery history only logs whether any app was scanning, and some devices log
+// few scan events, so the dump gives the per app picture of the BLE scan cost.
+package bluetooth
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+	usagepb "github.com/google/battery-historian/pb/usagestats_proto"
+)
+
+const (
+	// bluetoothService is the name of the Bluetooth manager service dump.
+	bluetoothService = "bluetooth_manager"
+
+	// bleScanning is the battery history CSV metric for BLE scans.
+	bleScanning = "BLE scanning"
+)
+
+var (
+	// mapRE matches the start of a map of the GATT service, the scanner map has the per app scan stats.
+	// e.g. "GATT Scanner Map"
+	mapRE = regexp.MustCompile(`^\s*GATT (?P<map>\w+) Map\s*$`)
+
+	// statRE matches a scan statistic of the current app.
+	// e.g. "  LE scans (started/stopped)         : 52 / 52"
+	statRE = regexp.MustCompile(`^\s*(?P<label>[A-Za-z][A-Za-z ()/]*?)\s*:\s*(?P<value>\d.*)$`)
+
+	// appRE matches the app name starting the statistics of an app, with optional flags, and with the
+	// UID after the name for apps sharing a UID.
+	// e.g. "  com.google.android.gms (Registered)", "  android.uid.system:1000"
+	appRE = regexp.MustCompile(`^\s*(?P<app>[A-Za-z][\w.]*)(:(?P<uid>\d+))?(\s+\([^)]*\))*\s*$`)
+)
+
+// AppScans is the BLE scan statistics of an app since Bluetooth was turned on.
+type AppScans struct {
+	// Name is the app name in the dump, usually the package name.
+	Name string
+	// UID is the app ID of the app, 0 if unknown.
+	UID                int32
+	Scans              int
+	BackgroundScans    int
+	BatchScans         int
+	OpportunisticScans int
+	// Results is the number of scan results delivered to the app.
+	Results int
+	// ScanTimeMs is the total time the app was scanning.
+	ScanTimeMs int64
+	// HistoryScanMs is the BLE scanning time in the battery history attributed to the app, set by Join.
+	HistoryScanMs int64
+}
+
+// UnbatchedScans returns the number of scans delivering each result as it's found, which keep the
+// application processor awake more than batched scans.
+func (a AppScans) UnbatchedScans() int {
+	if a.BatchScans > a.Scans {
+		return 0
+	}
+	return a.Scans - a.BatchScans
+}
+
+// byScanTime sorts apps in descending order of scan time, then scans, then ascending order of name.
+type byScanTime []AppScans
+
+func (a byScanTime) Len() int      { return len(a) }
+func (a byScanTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScanTime) Less(i, j int) bool {
+	switch {
+	case a[i].ScanTimeMs != a[j].ScanTimeMs:
+		return a[i].ScanTimeMs > a[j].ScanTimeMs
+	case a[i].Scans != a[j].Scans:
+		return a[i].Scans > a[j].Scans
+	}
+	return a[i].Name < a[j].Name
+}
+
+// extractBluetoothDump returns the lines of the Bluetooth manager service dump in the bug report.
+func extractBluetoothDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == bluetoothService
+			continue
+		}
+		if in {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	return lines
+}
+
+// firstInt returns the first number in the value, e.g. 52 for "52 / 52".
+func firstInt(v string) (int, error) {
+	return strconv.Atoi(strings.Fields(v)[0])
+}
+
+// Parse returns the BLE scan statistics of each app in the Bluetooth manager dump of the bug report,
+// in descending order of scan time. The apps are matched to their UIDs using the package list.
+// Statistics of the same app, e.g. registered and not, are combined.
+func Parse(bugreport string, pkgs []*usagepb.PackageInfo) ([]AppScans, []error) {
+	uids := make(map[string]int32)
+	for _, p := range pkgs {
+		uids[p.GetPkgName()] = packageutils.AppID(p.GetUid())
+	}
+
+	var errs []error
+	apps := make(map[string]*AppScans)
+	var names []string
+	var cur *AppScans
+	inScanners := false
+	for _, l := range extractBluetoothDump(bugreport) {
+		if m, result := historianutils.SubexpNames(mapRE, l); m {
+			inScanners = result["map"] == "Scanner"
+			cur = nil
+			continue
+		}
+		if !inScanners {
+			continue
+		}
+		if m, result := historianutils.SubexpNames(statRE, l); m {
+			if cur == nil {
+				// e.g. the number of entries of the map.
+				continue
+			}
+			label, value := result["label"], result["value"]
+			var err error
+			switch {
+			case strings.HasPrefix(label, "LE scans"):
+				var n int
+				n, err = firstInt(value)
+				cur.Scans += n
+			case strings.HasPrefix(label, "Scan time in ms"):
+				// The total is the last of the times, e.g. "9 / 30024 / 9640 / 501295".
+				parts := strings.Split(value, "/")
+				var ms int64
+				ms, err = strconv.ParseInt(strings.TrimSpace(parts[len(parts)-1]), 10, 64)
+				cur.ScanTimeMs += ms
+			case label == "Total number of results":
+				var n int
+				n, err = firstInt(value)
+				cur.Results += n
+			case label == "Background scans":
+				var n int
+				n, err = firstInt(value)
+				cur.BackgroundScans += n
+			case label == "Batch scans":
+				var n int
+				n, err = firstInt(value)
+				cur.BatchScans += n
+			case label == "Opportunistic scans":
+				var n int
+				n, err = firstInt(value)
+				cur.OpportunisticScans += n
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s of %s: %q", label, cur.Name, value))
+			}
+			continue
+		}
+		m, result := historianutils.SubexpNames(appRE, l)
+		if !m {
+			continue
+		}
+		name := result["app"]
+		if a, ok := apps[name]; ok {
+			cur = a
+			continue
+		}
+		cur = &AppScans{Name: name, UID: uids[name]}
+		if u := result["uid"]; u != "" {
+			uid, err := strconv.ParseInt(u, 10, 32)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid uid of %s: %q", name, u))
+			} else {
+				cur.UID = packageutils.AppID(int32(uid))
+			}
+		}
+		apps[name] = cur
+		names = append(names, name)
+	}
+
+	var res []AppScans
+	for _, n := range names {
+		res = append(res, *apps[n])
+	}
+	sort.Sort(byScanTime(res))
+	return res, errs
+}
+
+// Join returns the apps with the BLE scanning time of the battery history CSV generated by
+// AnalyzeHistory split between them, in proportion to their scan time in the dump, or to their
+// number of scans if the dump has no scan times. The apps are returned in the same order.
+func Join(apps []AppScans, historyCSV string) ([]AppScans, []error) {
+	if len(apps) == 0 {
+		return nil, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{bleScanning})
+	var historyMs int64
+	for _, e := range csv.MergeEvents(es[bleScanning]) {
+		historyMs += e.Interval().Duration()
+	}
+	weight := func(a AppScans) int64 { return a.ScanTimeMs }
+	var total int64
+	for _, a := range apps {
+		total += a.ScanTimeMs
+	}
+	if total == 0 {
+		weight = func(a AppScans) int64 { return int64(a.Scans) }
+		for _, a := range apps {
+			total += int64(a.Scans)
+		}
+	}
+	res := append([]AppScans(nil), apps...)
+	if total == 0 {
+		return res, errs
+	}
+	for i := range res {
+		res[i].HistoryScanMs = historyMs * weight(res[i]) / total
+	}
+	return res, errs
+}
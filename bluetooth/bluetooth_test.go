@@ -0,0 +1,131 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluetooth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/battery-historian/csv"
+	usagepb "github.com/google/battery-historian/pb/usagestats_proto"
+)
+
+// TestParse tests the parsing of the per app BLE scan statistics from the Bluetooth manager dump.
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		`DUMP OF SERVICE bluetooth_manager:`,
+		`Bluetooth Status`,
+		`  enabled: true`,
+		`GATT Client Map`,
+		`  Entries: 1`,
+		`  com.example.watch`,
+		`  LE scans (started/stopped)         : 99 / 99`,
+		`GATT Scanner Map`,
+		`  Entries: 3`,
+		``,
+		`  com.google.android.gms (Registered)`,
+		`  LE scans (started/stopped)         : 52 / 52`,
+		`  Scan time in ms (min/max/avg/total): 9 / 30024 / 9640 / 501295`,
+		`  Total number of results            : 147`,
+		`  Opportunistic scans                : 2`,
+		`  Background scans                   : 25`,
+		`  Batch scans                        : 10`,
+		``,
+		`  com.example.beacons`,
+		`  LE scans (started/stopped)         : 300 / 299`,
+		`  Scan time in ms (active/suspend/total): 1000 / 4000 / 5000`,
+		`  Total number of results            : 0`,
+		`  Background scans                   : 300`,
+		``,
+		`  android.uid.system:1000 (Registered)`,
+		`  LE scans (started/stopped)         : 1 / 1`,
+		`  Scan time in ms (min/max/avg/total): 5000 / 5000 / 5000 / 5000`,
+		`  com.google.android.gms`,
+		`  LE scans (started/stopped)         : 8 / 8`,
+		`  Scan time in ms (min/max/avg/total): 100 / 100 / 100 / 800`,
+		`GATT Advertiser Map`,
+		`  com.example.ads`,
+		`  LE scans (started/stopped)         : 7 / 7`,
+		`DUMP OF SERVICE bluetooth_le:`,
+		`  com.example.other`,
+		`  LE scans (started/stopped)         : 5 / 5`,
+	}, "\n")
+	pkgs := []*usagepb.PackageInfo{
+		{PkgName: proto.String("com.google.android.gms"), Uid: proto.Int32(1010012)},
+	}
+	want := []AppScans{
+		// The registered and unregistered stats of the same app are combined.
+		{Name: "com.google.android.gms", UID: 10012, Scans: 60, BackgroundScans: 25, BatchScans: 10, OpportunisticScans: 2, Results: 147, ScanTimeMs: 502095},
+		// Apps are sorted by scan time, then scans.
+		{Name: "com.example.beacons", Scans: 300, BackgroundScans: 300, ScanTimeMs: 5000},
+		{Name: "android.uid.system", UID: 1000, Scans: 1, ScanTimeMs: 5000},
+	}
+	got, errs := Parse(input, pkgs)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse()\n got: %+v\n want: %+v", got, want)
+	}
+	if n := got[0].UnbatchedScans(); n != 50 {
+		t.Errorf("UnbatchedScans() = %d, want 50", n)
+	}
+}
+
+// TestJoin tests the split of the history BLE scanning time between the apps.
+func TestJoin(t *testing.T) {
+	historyCSV := strings.Join([]string{
+		csv.FileHeader,
+		`BLE scanning,bool,0,60000,true,`,
+		`BLE scanning,bool,30000,90000,true,`,
+	}, "\n")
+	tests := []struct {
+		desc string
+		apps []AppScans
+		want []int64
+	}{
+		{
+			desc: "Split by scan time",
+			apps: []AppScans{{Name: "a", Scans: 1, ScanTimeMs: 3000}, {Name: "b", Scans: 5, ScanTimeMs: 1500}},
+			want: []int64{60000, 30000},
+		},
+		{
+			desc: "Split by scans without scan times",
+			apps: []AppScans{{Name: "a", Scans: 1}, {Name: "b", Scans: 2}},
+			want: []int64{30000, 60000},
+		},
+		{
+			desc: "No scans",
+			apps: []AppScans{{Name: "a"}},
+			want: []int64{0},
+		},
+	}
+	for _, test := range tests {
+		res, errs := Join(test.apps, historyCSV)
+		if len(errs) > 0 {
+			t.Errorf("%v: Join generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		var got []int64
+		for _, a := range res {
+			got = append(got, a.HistoryScanMs)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Join() history scan times = %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package broadcasts
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -112,9 +113,18 @@ func (p *parser) valid() bool {
 	return p.idx < len(p.lines)
 }
 
+// cancelCheckLines is the number of lines parsed between checks for cancellation.
+const cancelCheckLines = 1000
+
 // Parse writes a CSV entry for each broadcast summary event found.
 // Errors encountered during parsing will be collected into an errors slice and will continue parsing remaining events.
 func Parse(f string) (string, []error) {
+	return ParseContext(context.Background(), f)
+}
+
+// ParseContext is the same as Parse, but stops parsing once the context is done. No CSV is returned
+// for a stopped parse, and the errors end with the context error.
+func ParseContext(ctx context.Context, f string) (string, []error) {
 	loc, err := bugreportutils.TimeZone(f)
 	if err != nil {
 		return "", []error{err}
@@ -128,7 +138,12 @@ func Parse(f string) (string, []error) {
 		historicalBroadcastsUIDs: make(map[string]map[string]string),
 	}
 
-	for p.valid() {
+	for n := 0; p.valid(); n++ {
+		if n%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return "", append(p.errs, err)
+			}
+		}
 		l := p.line() // Read the current line and advance the line position.
 		// Active broadcast parsing.
 		if m, result := historianutils.SubexpNames(activeStartRE, l); m {
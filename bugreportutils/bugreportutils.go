@@ -43,6 +43,11 @@ var (
 	// BugReportSectionRE is a regular expression to match the beginning of a bug report section.
 	BugReportSectionRE = regexp.MustCompile(`------\s+(?P<section>.*)\s+-----`)
 
+	// LogcatLineRE is a regular expression that matches a logcat line in the threadtime format, with an
+	// optional UID before the PID, e.g. "11-19 11:29:07.341 10045  2206  2933 I Tethering: Tethering rndis0".
+	// The month, day, time and remainder groups are the arguments of LogTimeStampToMs.
+	LogcatLineRE = LogcatTagRE(`[^:]+?`)
+
 	// deviceIDRE is a regular expression that matches the "DeviceID" line
 	deviceIDRE = regexp.MustCompile("DeviceID: (?P<deviceID>[0-9]+)")
 
@@ -76,10 +81,17 @@ var (
 )
 
 // Contents returns a map of the contents of each file from the given bytes slice, with the key being the file name.
-// Supported file formats are text/plain and application/zip.
+// Supported file formats are text/plain and application/zip, optionally gzip or zstd compressed.
 // For zipped files, each file name will be prepended by the zip file's name.
 // An error will be non-nil for processing issues.
 func Contents(fname string, b []byte) (map[string][]byte, error) {
+	b, enc, err := historianutils.Decompress(b)
+	if err != nil {
+		return nil, err
+	}
+	if enc != "" {
+		fname = trimCompressionExt(fname)
+	}
 	contentType := http.DetectContentType(b)
 	switch {
 	case strings.Contains(contentType, "text/plain"):
@@ -91,6 +103,16 @@ func Contents(fname string, b []byte) (map[string][]byte, error) {
 	}
 }
 
+// trimCompressionExt removes a gzip or zstd file extension from the file name, e.g. bugreport.zip.gz -> bugreport.zip.
+func trimCompressionExt(fname string) string {
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		if strings.HasSuffix(fname, ext) {
+			return strings.TrimSuffix(fname, ext)
+		}
+	}
+	return fname
+}
+
 // IsBugReport tries to determine if the given bytes resembles a bug report.
 func IsBugReport(b []byte) bool {
 	// Check for a few expected lines in all bug reports.
@@ -112,10 +134,18 @@ func unzipAndExtract(fname string, b []byte) (map[string][]byte, error) {
 		}
 		defer rc.Close()
 		var zc bytes.Buffer
-		_, err = io.Copy(&zc, rc)
+		var zr io.Reader = rc
+		if max := historianutils.MaxDecompressedSize(); max > 0 {
+			// One more byte is read to tell files of exactly the maximum size from larger files.
+			zr = io.LimitReader(rc, max+1)
+		}
+		n, err := io.Copy(&zc, zr)
 		if err != nil {
 			return nil, fmt.Errorf("error copying from ZIP file: %v", err)
 		}
+		if max := historianutils.MaxDecompressedSize(); max > 0 && n > max {
+			return nil, fmt.Errorf("file %s in ZIP file is larger than %d bytes", f.Name, max)
+		}
 		// Don't recursively extract from any sub-ZIP files since we use this to also extract .jar files for Closure.
 		files[fname+"~"+f.Name] = zc.Bytes()
 	}
@@ -328,6 +358,28 @@ func TimeStampToMs(timestamp, remainder string, loc *time.Location) (int64, erro
 	return ((t.Unix() * 1000) + ms), nil
 }
 
+// LogTimeStampToMs converts the month, day, time of day in the "15:04:05" format and fraction of a
+// second of a log line, which doesn't include the year, to a unix ms timestamp in the location of the
+// time the bug report was taken. The year is the year the bug report was taken, or the previous year
+// if the month is later than the month the bug report was taken in.
+func LogTimeStampToMs(month, day, clock, remainder string, taken time.Time) (int64, error) {
+	m, err := strconv.Atoi(month)
+	if err != nil {
+		return 0, err
+	}
+	year := taken.Year()
+	if time.Month(m) > taken.Month() {
+		year--
+	}
+	return TimeStampToMs(fmt.Sprintf("%d-%s-%s %s", year, month, day, clock), remainder, taken.Location())
+}
+
+// LogcatTagRE returns a regular expression that matches the logcat lines like LogcatLineRE, but only
+// those with a tag matching the given regular expression.
+func LogcatTagRE(tag string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?P<month>\d{2})-(?P<day>\d{2})\s+(?P<time>\d{2}:\d{2}:\d{2})[.](?P<remainder>\d+)\s+(\S+\s+)?\d+\s+\d+\s+[VDIWEF]\s+(?P<tag>` + tag + `)\s*:\s*(?P<msg>.*)`)
+}
+
 // SecFractionAsMs converts the fraction of a second to milliseconds.
 // e.g. "123456" from "27.123456" corresponds to 123ms (and 27 seconds).
 func SecFractionAsMs(fr string) (int64, error) {
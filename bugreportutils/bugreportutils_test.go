@@ -15,11 +15,15 @@
 package bugreportutils
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/battery-historian/historianutils"
 )
 
 // Tests the conversion of times in the format: "2015-05-28 19:50:27.636636" to unix time in ms.
@@ -78,6 +82,84 @@ func TestTimeStampToMs(t *testing.T) {
 	}
 }
 
+// TestLogTimeStampToMs tests the conversion of log timestamps without a year.
+func TestLogTimeStampToMs(t *testing.T) {
+	taken := time.Date(2015, time.May, 28, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc       string
+		month, day string
+		want       time.Time
+	}{
+		{"Same month", "05", "28", time.Date(2015, time.May, 28, 19, 50, 27, 636000000, time.UTC)},
+		{"Earlier month", "01", "15", time.Date(2015, time.January, 15, 19, 50, 27, 636000000, time.UTC)},
+		{"Later month", "12", "31", time.Date(2014, time.December, 31, 19, 50, 27, 636000000, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := LogTimeStampToMs(test.month, test.day, "19:50:27", "636", taken)
+		if err != nil {
+			t.Errorf("%v: LogTimeStampToMs(%v, %v) generated unexpected error: %v", test.desc, test.month, test.day, err)
+			continue
+		}
+		if want := test.want.UnixNano() / int64(time.Millisecond); got != want {
+			t.Errorf("%v: LogTimeStampToMs(%v, %v) = %v, want %v", test.desc, test.month, test.day, got, want)
+		}
+	}
+	if _, err := LogTimeStampToMs("xx", "28", "19:50:27", "", taken); err == nil {
+		t.Error("LogTimeStampToMs with an invalid month generated no error")
+	}
+}
+
+// Tests the matching of logcat lines, with and without a tag filter.
+func TestLogcatTagRE(t *testing.T) {
+	tests := []struct {
+		desc string
+		line string
+		tag  string
+		want map[string]string // nil if the line doesn't match.
+	}{
+		{
+			desc: "Any tag",
+			line: "02-15 22:04:11.123  1234  1250 D IccCardProxy: Broadcasting intent ACTION_SIM_STATE_CHANGED",
+			tag:  `[^:]+?`,
+			want: map[string]string{"month": "02", "day": "15", "time": "22:04:11", "remainder": "123", "tag": "IccCardProxy", "msg": "Broadcasting intent ACTION_SIM_STATE_CHANGED"},
+		},
+		{
+			desc: "With UID",
+			line: "02-15 03:12:45.123 10045  1234  1250 I update_engine: Completed 1023/1024 operations",
+			tag:  `update_engine|update_verifier`,
+			want: map[string]string{"month": "02", "day": "15", "time": "03:12:45", "remainder": "123", "tag": "update_engine", "msg": "Completed 1023/1024 operations"},
+		},
+		{
+			desc: "Other tag",
+			line: "02-15 03:12:45.123  1234  1250 D Tethering: Tethering wlan0",
+			tag:  `update_engine|update_verifier`,
+		},
+		{
+			desc: "Not a logcat line",
+			line: "------ SYSTEM LOG (logcat -v threadtime -d *:v) ------",
+			tag:  `[^:]+?`,
+		},
+	}
+	for _, test := range tests {
+		m, result := historianutils.SubexpNames(LogcatTagRE(test.tag), test.line)
+		if !m {
+			if test.want != nil {
+				t.Errorf("%v: LogcatTagRE(%q) didn't match %q", test.desc, test.tag, test.line)
+			}
+			continue
+		}
+		if test.want == nil {
+			t.Errorf("%v: LogcatTagRE(%q) unexpectedly matched %q", test.desc, test.tag, test.line)
+			continue
+		}
+		for k, want := range test.want {
+			if got := result[k]; got != want {
+				t.Errorf("%v: LogcatTagRE(%q) matched %s %q, want %q", test.desc, test.tag, k, got, want)
+			}
+		}
+	}
+}
+
 // Tests the extracting of the time zone from a bug report.
 func TestTimeZone(t *testing.T) {
 	tests := []struct {
@@ -418,3 +500,52 @@ func TestExtractPIDMappings(t *testing.T) {
 		}
 	}
 }
+
+// TestContentsCompressed tests that gzip compressed text and zip files are decompressed.
+func TestContentsCompressed(t *testing.T) {
+	text := []byte("== dumpstate: 2015-06-08 18:54:24\n")
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, err := zw.Create("bugreport.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(text)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc  string
+		fname string
+		input []byte
+		want  map[string][]byte
+	}{
+		{
+			desc:  "gzipped text file",
+			fname: "bugreport.txt.gz",
+			input: text,
+			want:  map[string][]byte{"bugreport.txt": text},
+		},
+		{
+			desc:  "gzipped zip file",
+			fname: "bugreport.zip.gz",
+			input: zipped.Bytes(),
+			want:  map[string][]byte{"bugreport.zip~bugreport.txt": text},
+		},
+	}
+	for _, test := range tests {
+		gz, err := historianutils.GzipCompress(test.input)
+		if err != nil {
+			t.Fatalf("%s: GzipCompress returned unexpected error: %v", test.desc, err)
+		}
+		got, err := Contents(test.fname, gz)
+		if err != nil {
+			t.Errorf("%s: Contents(%q) returned unexpected error: %v", test.desc, test.fname, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Contents(%q) = %q, want %q", test.desc, test.fname, got, test.want)
+		}
+	}
+}
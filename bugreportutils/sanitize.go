@@ -0,0 +1,78 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bugreportutils
+
+// sanitize.go trims a bug report down to the parts needed by Historian.
+
+import (
+	"strings"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+var (
+	// sanitizeSections are the prefixes of the bug report sections that are kept in full.
+	sanitizeSections = []string{
+		"SYSTEM PROPERTIES",
+		"CHECKIN BATTERYSTATS",
+		"KERNEL LOG (dmesg)",
+		"EVENT LOG",
+		"SYSTEM LOG",
+		"LAST LOGCAT",
+	}
+
+	// sanitizeServices are the service dumps that are kept, regardless of the section they are in.
+	sanitizeServices = map[string]bool{
+		"activity":      true, // Broadcasts, PID mappings and the wearable service dump.
+		"appops":        true,
+		"batterystats":  true,
+		"package":       true,
+		"power":         true,
+		"sensorservice": true,
+	}
+)
+
+// keepSection returns whether the bug report section with the given name is kept in full.
+func keepSection(section string) bool {
+	for _, s := range sanitizeSections {
+		if strings.HasPrefix(section, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sanitize returns a subset of the bug report containing only the header, the sections and
+// the service dumps used by Historian, with PII (e.g. email addresses) scrubbed from every line.
+// All section headers are preserved so that kept service dumps aren't mistaken as part of the
+// previous section, and the result can be uploaded and parsed like the original bug report.
+func Sanitize(contents string) string {
+	var out []string
+	// The header lines before the first section, such as the dumpstate time and build fingerprint, are kept.
+	inSection, inService := true, false
+	for _, line := range strings.Split(contents, "\n") {
+		header := false
+		if m, result := historianutils.SubexpNames(BugReportSectionRE, line); m {
+			inSection, inService = keepSection(result["section"]), false
+			header = true
+		} else if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			inService = sanitizeServices[result["service"]]
+		}
+		if header || inSection || inService {
+			out = append(out, historianutils.ScrubPIIText(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bugreportutils
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSanitize tests the trimming and scrubbing of bug reports.
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []string
+	}{
+		{
+			desc: "Unneeded sections and service dumps removed",
+			input: []string{
+				`========================================================`,
+				`== dumpstate: 2015-08-10 13:39:20`,
+				`========================================================`,
+				`Build fingerprint: 'google/shamu/shamu:6.0/MRA58K/2256973:userdebug/dev-keys'`,
+				`------ MEMORY INFO (/proc/meminfo) ------`,
+				`MemTotal:        2857148 kB`,
+				`------ SYSTEM PROPERTIES ------`,
+				`[ro.build.version.sdk]: [23]`,
+				`------ ACCOUNTS (dumpsys account) ------`,
+				`Account {name=john.doe@gmail.com, type=com.google}`,
+				`------ DUMPSYS (dumpsys) ------`,
+				`DUMP OF SERVICE account:`,
+				`Account {name=john.doe@gmail.com, type=com.google}`,
+				`DUMP OF SERVICE batterystats:`,
+				`9,h,0:RESET:TIME:1422620451417`,
+				`9,hsp,2,10011,"com.google.android.gms/com.google/john.doe@gmail.com"`,
+				`9,hsp,3,10012,"*sync*/com.google.android.gm.email.provider/com.google.android.gm.exchange/Jane Doe"`,
+				`DUMP OF SERVICE connectivity:`,
+				`NetworkAgentInfo [WIFI () - 100]`,
+				`------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------`,
+				`9,0,i,vers,14,135,LMY06B,LMY06B`,
+			},
+			want: []string{
+				`========================================================`,
+				`== dumpstate: 2015-08-10 13:39:20`,
+				`========================================================`,
+				`Build fingerprint: 'google/shamu/shamu:6.0/MRA58K/2256973:userdebug/dev-keys'`,
+				`------ MEMORY INFO (/proc/meminfo) ------`,
+				`------ SYSTEM PROPERTIES ------`,
+				`[ro.build.version.sdk]: [23]`,
+				`------ ACCOUNTS (dumpsys account) ------`,
+				`------ DUMPSYS (dumpsys) ------`,
+				`DUMP OF SERVICE batterystats:`,
+				`9,h,0:RESET:TIME:1422620451417`,
+				`9,hsp,2,10011,"com.google.android.gms/com.google/XXX@gmail.com"`,
+				`9,hsp,3,10012,"*sync*/com.google.android.gm.email.provider/com.google.android.gm.exchange/XXX"`,
+				`------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------`,
+				`9,0,i,vers,14,135,LMY06B,LMY06B`,
+			},
+		},
+		{
+			desc: "PII scrubbed from kept logs",
+			input: []string{
+				`------ SYSTEM LOG (logcat -v threadtime -d *:v) ------`,
+				`08-10 13:30:01.123  1234  1250 I SyncManager: Sync started for john.doe@gmail.com (com.google)`,
+			},
+			want: []string{
+				`------ SYSTEM LOG (logcat -v threadtime -d *:v) ------`,
+				`08-10 13:30:01.123  1234  1250 I SyncManager: Sync started for XXX@gmail.com (com.google)`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		want := strings.Join(test.want, "\n")
+		if got := Sanitize(input); got != want {
+			t.Errorf("%v: Sanitize(%v)\n got: %v\n want: %v", test.desc, input, got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bugreportutils
+
+// steps.go extracts the step counts from the recent sensor events of the sensorservice dump, so that
+// the drain can be put in relation with the physical activity of the user.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+var (
+	// stepCounterRE matches the header of the recent events of a step counter in the sensorservice dump.
+	// e.g. "Step Counter: last 10 events" or "BMI160 Step counter                 : last 10 events"
+	stepCounterRE = regexp.MustCompile(`(?i)^\s*(.*\b)?step[ _]?counter\b[^:]*:\s*last\s+\d+\s+events`)
+
+	// sensorEventRE matches a recent sensor event logged with its time since boot in seconds and its
+	// wall clock time, with the step counter value as the first value.
+	// e.g. "	 1 (ts=6186.913583232, wall=15:12:02.871) 8241.00, "
+	sensorEventRE = regexp.MustCompile(`^\s*\d+\s+\(ts=(?P<ts>[\d.]+),\s*wall=(?P<time>\d{2}:\d{2}:\d{2})[.](?P<remainder>\d+)\)\s+(?P<value>-?[\d.]+),`)
+)
+
+// maxStepClockSkew is how much the wall clock time of a step count can differ from the time expected
+// from its time since boot, e.g. because the clock was adjusted, for the count to be kept.
+const maxStepClockSkew = 10 * time.Minute
+
+// StepCount is the value of the step counter at a time, the number of steps taken since the device
+// booted.
+type StepCount struct {
+	TimeMs int64
+	Steps  int64
+}
+
+// byStepTime sorts step counts in ascending order of time.
+type byStepTime []StepCount
+
+func (a byStepTime) Len() int           { return len(a) }
+func (a byStepTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStepTime) Less(i, j int) bool { return a[i].TimeMs < a[j].TimeMs }
+
+// ParseStepCounts returns the step counts in the recent sensor events of the sensorservice dump of
+// the bug report, taken at the given time, sorted by time. Only the events logged with their wall
+// clock time are returned. The wall clock time has no date, so it's assumed to be within the day
+// before the bug report was taken, and checked against the time since boot of the event: all events
+// share the same boot, so the latest event of any sensor gives the wall clock time of the boot.
+// Counts that don't fit, e.g. logged more than a day before the report, are dropped with an error.
+// Only the last few events of each sensor are kept by the sensor service, so the counts are sparse.
+func ParseStepCounts(bugreport string, taken time.Time) ([]StepCount, []error) {
+	var errs []error
+	var counts []StepCount
+	// bootMs are the wall clock times of the boot implied by each count, in the same order.
+	var bootMs []int64
+	// latestMs and latestBootMs are the time since boot of the latest event of any sensor, and the
+	// wall clock time of the boot it implies.
+	var latestMs, latestBootMs int64
+	inService, inCounter := false, false
+	for _, line := range strings.Split(bugreport, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if inService {
+				break
+			}
+			inService = result["service"] == "sensorservice"
+			continue
+		}
+		if !inService {
+			continue
+		}
+		if stepCounterRE.MatchString(line) {
+			inCounter = true
+			continue
+		}
+		m, result := historianutils.SubexpNames(sensorEventRE, line)
+		if !m {
+			// The events of a sensor are listed right after its header.
+			inCounter = false
+			continue
+		}
+		ts, err := strconv.ParseFloat(result["ts"], 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid sensor event time since boot %q: %v", line, err))
+			continue
+		}
+		sinceBootMs := int64(ts * 1000)
+		ms, err := TimeStampToMs(fmt.Sprintf("%s %s", taken.Format("2006-01-02"), result["time"]), result["remainder"], taken.Location())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid sensor event time %q: %v", line, err))
+			continue
+		}
+		if ms > taken.UnixNano()/int64(time.Millisecond) {
+			// Logged the day before the bug report was taken.
+			ms -= int64(24 * time.Hour / time.Millisecond)
+		}
+		if sinceBootMs >= latestMs {
+			latestMs, latestBootMs = sinceBootMs, ms-sinceBootMs
+		}
+		if !inCounter {
+			continue
+		}
+		steps, err := strconv.ParseFloat(result["value"], 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid step count %q: %v", line, err))
+			continue
+		}
+		counts = append(counts, StepCount{TimeMs: ms, Steps: int64(steps)})
+		bootMs = append(bootMs, ms-sinceBootMs)
+	}
+	var res []StepCount
+	for i, c := range counts {
+		if d := time.Duration(bootMs[i]-latestBootMs) * time.Millisecond; d > maxStepClockSkew || d < -maxStepClockSkew {
+			t := time.Unix(0, c.TimeMs*int64(time.Millisecond)).In(taken.Location())
+			errs = append(errs, fmt.Errorf("step count %d at %s is %v off its time since boot, it may be from more than a day before the report", c.Steps, t.Format("15:04:05"), d))
+			continue
+		}
+		res = append(res, c)
+	}
+	sort.Stable(byStepTime(res))
+	return res, errs
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bugreportutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseStepCounts tests the parsing of the step counts of the recent sensor events.
+func TestParseStepCounts(t *testing.T) {
+	taken := time.Date(2017, time.February, 16, 8, 0, 0, 0, time.UTC)
+	ms := func(day, hour, min int) int64 {
+		return time.Date(2017, time.February, day, hour, min, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	}
+	input := strings.Join([]string{
+		`DUMP OF SERVICE sensorservice:`,
+		`Recent Sensor events:`,
+		// The device booted on February 13 at 20:00.
+		`BMI160 Accelerometer: last 2 events`,
+		`	 1 (ts=212400.000000000, wall=07:00:00.000) -0.10, 9.80, 0.20, `,
+		`BMI160 Step counter                 : last 4 events`,
+		// Logged on February 14, more than a day before the report.
+		`	 1 (ts=93600.000000000, wall=22:00:00.000) 7000.00, `,
+		`	 2 (ts=185400.000000000, wall=23:30:00.000) 8000.00, `,
+		`	 3 (ts=212400.000000000, wall=07:00:00.000) 8241.00, `,
+		`	 4 (ts=214200.500000000, wall=07:30:00.500) 9241.00, `,
+		`Significant Motion: last 1 events`,
+		`	 1 (ts=213000.000000000, wall=07:10:00.000) 1.00, `,
+		// Older releases don't log the wall clock time.
+		`Step Counter: last 1 events`,
+		`	 1 (timestamp=33806.911331839) 2149.00, `,
+		`DUMP OF SERVICE telephony.registry:`,
+		`Step Counter: last 1 events`,
+		`	 1 (ts=300.000000000, wall=07:40:00.000) 9300.00, `,
+	}, "\n")
+	want := []StepCount{
+		{TimeMs: ms(15, 23, 30), Steps: 8000},
+		{TimeMs: ms(16, 7, 0), Steps: 8241},
+		{TimeMs: ms(16, 7, 30) + 500, Steps: 9241},
+	}
+	got, errs := ParseStepCounts(input, taken)
+	if len(errs) != 1 {
+		t.Errorf("ParseStepCounts(%s) generated errors %v, want one for the count from more than a day before", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseStepCounts(%s)\n got: %+v\n want: %+v", input, got, want)
+	}
+}
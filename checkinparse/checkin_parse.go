@@ -16,6 +16,7 @@
 package checkinparse
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -44,6 +45,8 @@ const (
 	// Current range of supported/expected checkin versions.
 	minParseReportVersion = 11
 	maxParseReportVersion = 21
+	// number of checkin lines parsed between checks for cancellation
+	cancelCheckLines = 1000
 )
 
 // Possible battery stats categories generated by on device java code.
@@ -133,198 +136,6 @@ var (
 		"flashlight": bspb.BatteryStats_System_PowerUseItem_FLASHLIGHT,
 	}
 
-	// sharedUIDLabelMap contains a mapping of known shared UID labels to predefined group names.
-	sharedUIDLabelMap = map[string]string{
-		"android.media":                          "MEDIA",
-		"android.uid.bluetooth":                  "BLUETOOTH",
-		"android.uid.nfc":                        "NFC",
-		"android.uid.phone":                      "RADIO",             // Associated with UID 1001.
-		"android.uid.shared":                     "CONTACTS_PROVIDER", // "com.android.providers.contacts" is a prominent member of the UID group
-		"android.uid.shell":                      "SHELL",
-		"android.uid.system":                     "ANDROID_SYSTEM",
-		"android.uid.systemui":                   "SYSTEM_UI",
-		"com.google.android.calendar.uid.shared": "GOOGLE_CALENDAR",
-		"com.google.uid.shared":                  "GOOGLE_SERVICES",
-	}
-
-	// TODO: get rid of packageNameToSharedUIDMap
-	// Contains a mapping of known packages with their shared UIDs.
-	packageNameToSharedUIDMap = map[string]string{
-		// com.google.uid.shared
-		"com.google.android.apps.gtalkservice":    "GOOGLE_SERVICES",
-		"com.google.android.backuptransport":      "GOOGLE_SERVICES",
-		"com.google.android.gms":                  "GOOGLE_SERVICES",
-		"com.google.android.gms.car.userfeedback": "GOOGLE_SERVICES",
-		"com.google.android.googleapps":           "GOOGLE_SERVICES",
-		"com.google.android.gsf":                  "GOOGLE_SERVICES",
-		"com.google.android.gsf.login":            "GOOGLE_SERVICES",
-		"com.google.android.gsf.notouch":          "GOOGLE_SERVICES",
-		"com.google.android.providers.gmail":      "GOOGLE_SERVICES",
-		"com.google.android.sss.authbridge":       "GOOGLE_SERVICES",
-		"com.google.gch.gateway":                  "GOOGLE_SERVICES",
-
-		// com.google.android.calendar.uid.shared
-		"com.android.calendar":                     "GOOGLE_CALENDAR",
-		"com.google.android.calendar":              "GOOGLE_CALENDAR",
-		"com.google.android.syncadapters.calendar": "GOOGLE_CALENDAR",
-
-		// android.uid.system
-		"android":                            "ANDROID_SYSTEM",
-		"com.android.changesettings":         "ANDROID_SYSTEM",
-		"com.android.inputdevices":           "ANDROID_SYSTEM",
-		"com.android.keychain":               "ANDROID_SYSTEM",
-		"com.android.location.fused":         "ANDROID_SYSTEM",
-		"com.android.providers.settings":     "ANDROID_SYSTEM",
-		"com.android.settings":               "ANDROID_SYSTEM",
-		"com.google.android.canvas.settings": "ANDROID_SYSTEM",
-		"com.lge.SprintHiddenMenu":           "ANDROID_SYSTEM",
-		"com.nvidia.tegraprofiler.security":  "ANDROID_SYSTEM",
-		"com.qualcomm.atfwd":                 "ANDROID_SYSTEM",
-		"com.qualcomm.display":               "ANDROID_SYSTEM",
-
-		// android.uid.phone, associated with UID 1001
-		"com.android.phone":                    "RADIO",
-		"com.android.providers.telephony":      "RADIO",
-		"com.android.sdm.plugins.connmo":       "RADIO",
-		"com.android.sdm.plugins.dcmo":         "RADIO",
-		"com.android.sdm.plugins.sprintdm":     "RADIO",
-		"com.htc.android.qxdm2sd":              "RADIO",
-		"com.android.mms.service":              "RADIO",
-		"com.android.server.telecom":           "RADIO",
-		"com.android.sprint.lifetimedata":      "RADIO",
-		"com.android.stk":                      "RADIO",
-		"com.motorola.service.ims":             "RADIO",
-		"com.qualcomm.qti.imstestrunner":       "RADIO",
-		"com.qualcomm.qti.rcsbootstraputil":    "RADIO",
-		"com.qualcomm.qti.rcsimsbootstraputil": "RADIO",
-		"com.qualcomm.qcrilmsgtunnel":          "RADIO",
-		"com.qualcomm.shutdownlistner":         "RADIO",
-		"org.codeaurora.ims":                   "RADIO",
-		"com.asus.atcmd":                       "RADIO",
-		"com.mediatek.imeiwriter":              "RADIO",
-
-		// android.uid.nfc
-		"com.android.nfc":                 "NFC",
-		"com.android.nfc3":                "NFC",
-		"com.google.android.uiccwatchdog": "NFC",
-
-		// android.uid.shared
-		"com.android.contacts":                 "CONTACTS_PROVIDER",
-		"com.android.providers.applications":   "CONTACTS_PROVIDER",
-		"com.android.providers.contacts":       "CONTACTS_PROVIDER",
-		"com.android.providers.userdictionary": "CONTACTS_PROVIDER",
-
-		// android.media
-		"com.android.gallery":                "MEDIA",
-		"com.android.providers.downloads":    "MEDIA",
-		"com.android.providers.downloads.ui": "MEDIA",
-		"com.android.providers.drm":          "MEDIA",
-		"com.android.providers.media":        "MEDIA",
-
-		// android.uid.systemui
-		"com.android.keyguard": "SYSTEM_UI",
-		"com.android.systemui": "SYSTEM_UI",
-
-		// android.uid.bluetooth
-		"com.android.bluetooth": "BLUETOOTH",
-
-		// android.uid.shell
-		"com.android.shell": "SHELL",
-	}
-
-	// KnownUIDs lists all constant UIDs defined in system/core/include/private/android_filesystem_config.h.
-	// GIDs are excluded. These should never be renumbered.
-	KnownUIDs = map[int32]string{
-		0:    "ROOT",           // traditional unix root user
-		1000: "ANDROID_SYSTEM", // system server
-		1001: "RADIO",          // telephony subsystem, RIL
-		1002: "BLUETOOTH",      // bluetooth subsystem
-		1003: "GRAPHICS",       // graphics devices
-		1004: "INPUT",          // input devices
-		1005: "AUDIO",          // audio devices
-		1006: "CAMERA",         // camera devices
-		1007: "LOG",            // log devices
-		1008: "COMPASS",        // compass device
-		1009: "MOUNT",          // mountd socket
-		1010: "WIFI",           // wifi subsystem
-		1011: "ADB",            // android debug bridge (adbd)
-		1012: "INSTALL",        // group for installing packages
-		1013: "MEDIA",          // mediaserver process
-		1014: "DHCP",           // dhcp client
-		1015: "SDCARD_RW",      // external storage write access
-		1016: "VPN",            // vpn system
-		1017: "KEYSTORE",       // keystore subsystem
-		1018: "USB",            // USB devices
-		1019: "DRM",            // DRM server
-		1020: "MDNSR",          // MulticastDNSResponder (service discovery)
-		1021: "GPS",            // GPS daemon
-		// 1022 is deprecated and unused
-		1023: "MEDIA_RW", // internal media storage write access
-		1024: "MTP",      // MTP USB driver access
-		// 1025 is deprecated and unused
-		1026: "DRMRPC",          // group for drm rpc
-		1027: "NFC",             // nfc subsystem
-		1028: "SDCARD_R",        // external storage read access
-		1029: "CLAT",            // clat part of nat464
-		1030: "LOOP_RADIO",      // loop radio devices
-		1031: "MEDIA_DRM",       // MediaDrm plugins
-		1032: "PACKAGE_INFO",    // access to installed package details
-		1033: "SDCARD_PICS",     // external storage photos access
-		1034: "SDCARD_AV",       // external storage audio/video access
-		1035: "SDCARD_ALL",      // access all users external storage
-		1036: "LOGD",            // log daemon
-		1037: "SHARED_RELRO",    // creator of shared GNU RELRO files
-		1038: "DBUS",            // dbus-daemon IPC broker process
-		1039: "TLSDATE",         // tlsdate unprivileged user
-		1040: "MEDIA_EX",        // mediaextractor process
-		1041: "AUDIOSERVER",     // audioserver process
-		1042: "METRICS_COLL",    // metrics_collector process
-		1043: "METRICSD",        // metricsd process
-		1044: "WEBSERV",         // webservd process
-		1045: "DEBUGGERD",       // debuggerd unprivileged user
-		1046: "MEDIA_CODEC",     // mediacodec process
-		1047: "CAMERASERVER",    // cameraserver process
-		1048: "FIREWALL",        // firewalld process
-		1049: "TRUNKS",          // trunksd process (TPM daemon)
-		1050: "NVRAM",           // Access-controlled NVRAM
-		1051: "DNS",             // DNS resolution daemon (system: netd)
-		1052: "DNS_TETHER",      // DNS resolution daemon (tether: dnsmasq)
-		1053: "WEBVIEW_ZYGOTE",  // WebView zygote process
-		1054: "VEHICLE_NETWORK", // Vehicle network service
-		1055: "MEDIA_AUDIO",     // GID for audio files on internal media storage
-		1056: "MEDIA_VIDEO",     // GID for video files on internal media storage
-		1057: "MEDIA_IMAGE",     // GID for image files on internal media storage
-		1058: "TOMBSTONED",      // tombstoned user
-		1059: "MEDIA_OBB",       // GID for OBB files on internal media storage
-		1060: "ESE",             // embedded secure element (eSE) subsystem
-		1061: "OTA_UPDATE",      // resource tracking UID for OTA updates
-
-		2000: "SHELL", // adb and debug shell user
-		2001: "CACHE", // cache access
-		2002: "DIAG",  // access to diagnostic resources
-
-		// 2900-2999 is reserved for OEM
-
-		// The 3000 series are intended for use as supplemental group id's only.
-		// They indicate special Android capabilities that the kernel is aware of.
-		3001: "NET_BT_ADMIN", // bluetooth: create any socket
-		3002: "NET_BT",       // bluetooth: create sco, rfcomm or l2cap sockets
-		3003: "INET",         // can create AF_INET and AF_INET6 sockets
-		3004: "NET_RAW",      // can create raw INET sockets
-		3005: "NET_ADMIN",    // can configure interfaces and routing tables
-		3006: "NET_BW_STATS", // read bandwidth statistics
-		3007: "NET_BW_ACCT",  // change bandwidth statistics accounting
-		3008: "NET_BT_STACK", // bluetooth: access config files
-		3009: "READPROC",     // allow /proc read access
-		3010: "WAKELOCK",     // allow system wakelock read/write access
-
-		// 5000-5999 is reserved for OEM
-
-		9997: "EVERYBODY", // shared between all apps in the same profile
-		9998: "MISC",      // access to misc storage
-		9999: "NOBODY",
-	}
-
 	// BatteryStatsIDMap contains a list of all the fields in BatteryStats_* messages that are the IDs for the message.
 	BatteryStatsIDMap = map[reflect.Type]map[int]bool{
 		// App fields
@@ -481,6 +292,12 @@ func SortByCount(items []*WakelockInfo) {
 // ParseBatteryStats parses the aggregated battery stats in checkin report
 // according to frameworks/base/core/java/android/os/BatteryStats.java.
 func ParseBatteryStats(pc checkinutil.Counter, cr *checkinutil.BatteryReport, pkgs []*usagepb.PackageInfo) (*bspb.BatteryStats, []string, []error) {
+	return ParseBatteryStatsContext(context.Background(), pc, cr, pkgs)
+}
+
+// ParseBatteryStatsContext is the same as ParseBatteryStats, but stops parsing once the context
+// is done. No stats are returned for a stopped parse, and the errors end with the context error.
+func ParseBatteryStatsContext(ctx context.Context, pc checkinutil.Counter, cr *checkinutil.BatteryReport, pkgs []*usagepb.PackageInfo) (*bspb.BatteryStats, []string, []error) {
 	// Support a single version and single aggregation type in a checkin report.
 	var aggregationType bspb.BatteryStats_AggregationType
 	var allAppComputedPowerMah float32
@@ -494,7 +311,12 @@ func ParseBatteryStats(pc checkinutil.Counter, cr *checkinutil.BatteryReport, pk
 	if len(errs) > 0 {
 		return nil, warnings, errs
 	}
-	for _, r := range cr.RawBatteryStats {
+	for i, r := range cr.RawBatteryStats {
+		if i%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, warnings, append(errs, err)
+			}
+		}
 		var rawUID int32
 		var rawAggregationType, section string
 		// The first element in r is '9', which used to be the report version but is now just there as a legacy field.
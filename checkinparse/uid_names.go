@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkinparse
+
+// uid_names.go loads the readable names of system UIDs and shared UID groups from the uid_names.txt
+// data file packaged with the binary, and from any additional files given at runtime.
+
+import (
+	_ "embed" // For the packaged UID names.
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// defaultUIDNames is the content of the packaged UID names file.
+//
+//go:embed uid_names.txt
+var defaultUIDNames string
+
+var (
+	// KnownUIDs maps the constant system UIDs, such as 1000, to their names, such as ANDROID_SYSTEM.
+	KnownUIDs = make(map[int32]string)
+
+	// sharedUIDLabelMap maps known shared UID labels to predefined group names.
+	sharedUIDLabelMap = make(map[string]string)
+
+	// TODO: get rid of packageNameToSharedUIDMap
+	// packageNameToSharedUIDMap maps known packages to the group names of their shared UIDs.
+	packageNameToSharedUIDMap = make(map[string]string)
+)
+
+func init() {
+	if err := AddUIDNames(defaultUIDNames); err != nil {
+		panic(fmt.Sprintf("invalid packaged UID names: %v", err))
+	}
+}
+
+// AddUIDNames adds the names in the given UID names file content, overriding any existing names for
+// the same UIDs, shared UID labels or packages. Each line has the format "uid <uid> <name>",
+// "shared_uid <label> <name>" or "package <package> <name>", and text after a # is ignored.
+// Names must be added before any parsing starts, as the name tables aren't locked.
+func AddUIDNames(content string) error {
+	uids := make(map[int32]string)
+	labels := make(map[string]string)
+	pkgs := make(map[string]string)
+	for i, line := range strings.Split(content, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return fmt.Errorf("line %d: expected 3 fields, got %d in %q", i+1, len(f), line)
+		}
+		switch f[0] {
+		case "uid":
+			uid, err := strconv.ParseInt(f[1], 10, 32)
+			if err != nil || uid < 0 {
+				return fmt.Errorf("line %d: invalid uid %q", i+1, f[1])
+			}
+			uids[int32(uid)] = f[2]
+		case "shared_uid":
+			labels[f[1]] = f[2]
+		case "package":
+			pkgs[f[1]] = f[2]
+		default:
+			return fmt.Errorf("line %d: unknown entry type %q", i+1, f[0])
+		}
+	}
+	// Only update the tables once the whole content is valid.
+	for k, v := range uids {
+		KnownUIDs[k] = v
+	}
+	for k, v := range labels {
+		sharedUIDLabelMap[k] = v
+	}
+	for k, v := range pkgs {
+		packageNameToSharedUIDMap[k] = v
+	}
+	return nil
+}
+
+// LoadUIDNames adds the names in the UID names file at the given path. See AddUIDNames for the format.
+func LoadUIDNames(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := AddUIDNames(string(b)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkinparse
+
+import (
+	"testing"
+)
+
+// TestPackagedUIDNames tests that the packaged UID names are loaded.
+func TestPackagedUIDNames(t *testing.T) {
+	uids := map[int32]string{
+		0:    "ROOT",
+		1000: "ANDROID_SYSTEM",
+		1013: "MEDIA",
+		1073: "NETWORK_STACK",
+		9999: "NOBODY",
+	}
+	for uid, want := range uids {
+		if got := KnownUIDs[uid]; got != want {
+			t.Errorf("KnownUIDs[%d] = %q, want %q", uid, got, want)
+		}
+	}
+	if got, want := GroupName("com.google.uid.shared"), "GOOGLE_SERVICES"; got != want {
+		t.Errorf("GroupName(%q) = %q, want %q", "com.google.uid.shared", got, want)
+	}
+	if got, want := PackageUIDGroupName("com.android.providers.contacts"), "CONTACTS_PROVIDER"; got != want {
+		t.Errorf("PackageUIDGroupName(%q) = %q, want %q", "com.android.providers.contacts", got, want)
+	}
+}
+
+// TestAddUIDNames tests adding names at runtime.
+func TestAddUIDNames(t *testing.T) {
+	defer func() {
+		delete(KnownUIDs, 2901)
+		delete(sharedUIDLabelMap, "com.example.uid.shared")
+		KnownUIDs[1000] = "ANDROID_SYSTEM"
+	}()
+
+	tests := []struct {
+		desc    string
+		content string
+		wantErr bool
+	}{
+		{
+			desc: "valid names",
+			content: `# OEM daemons
+uid 2901 OEM_THERMAL   # thermal daemon
+uid 1000 SYSTEM_SERVER
+shared_uid com.example.uid.shared EXAMPLE_SERVICES
+`,
+		},
+		{
+			desc:    "missing name",
+			content: "uid 2902\n",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid uid",
+			content: "uid -1 INVALID\n",
+			wantErr: true,
+		},
+		{
+			desc:    "unknown entry type",
+			content: "gid 3003 INET\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		err := AddUIDNames(test.content)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: AddUIDNames(%q) returned error %v, want error: %v", test.desc, test.content, err, test.wantErr)
+		}
+	}
+	if got, want := KnownUIDs[2901], "OEM_THERMAL"; got != want {
+		t.Errorf("KnownUIDs[2901] = %q, want %q", got, want)
+	}
+	if got, want := KnownUIDs[1000], "SYSTEM_SERVER"; got != want {
+		t.Errorf("KnownUIDs[1000] = %q, want %q", got, want)
+	}
+	if got, want := GroupName("com.example.uid.shared"), "EXAMPLE_SERVICES"; got != want {
+		t.Errorf("GroupName(%q) = %q, want %q", "com.example.uid.shared", got, want)
+	}
+}
@@ -20,9 +20,16 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"path"
+	"time"
 
 	"github.com/google/battery-historian/analyzer"
+	"github.com/google/battery-historian/checkinparse"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/storage"
 )
 
 var (
@@ -36,6 +43,33 @@ var (
 	templateDir   = flag.String("template_dir", "./templates", "Directory containing HTML templates.")
 	thirdPartyDir = flag.String("third_party_dir", "./third_party", "Directory containing third party files for Historian v2.")
 
+	maxUnknownPercent = flag.Float64("max_unknown_percent", 0, "Strict mode: fail the analysis of reports where more than this percentage of battery history lines have unknown event codes. Disabled if 0.")
+	profileNames      = flag.Bool("profile_names", false, "Whether apps of secondary users, such as work profiles, are kept separate from the primary user's apps, e.g. as \"com.google.android.gm (work)\".")
+	uidNames          = flag.String("uid_names", "", "Path to a file of additional or overriding names for system UIDs, shared UID labels and packages, in the format of checkinparse/uid_names.txt.")
+	wakeupReasons     = flag.String("wakeup_reason_names", "", "Path to a file, optionally gzip compressed, of readable names for the encoded wakeup reasons of vendor devices, in the format of parseutils/wakeup_reasons.txt.")
+	summaryTopN       = flag.Int("summary_top_n", 0, "Number of entries kept in each per app or per state summary breakdown, by total duration, with the rest rolled up into an \"others\" entry. All entries are kept if 0.")
+	summarizeCharging = flag.Bool("summarize_charging", false, "Whether charging periods are also summarized, in summaries labelled as charging, instead of only discharge intervals.")
+	screenMergeGap    = flag.Duration("screen_merge_gap", parseutils.DefaultScreenMergeGap, "Longest time the screen can be off between two screen ons for them to be counted as a single screen on session, so that brief flickers don't inflate the number of sessions.")
+	chargingDebounce  = flag.Duration("charging_debounce", parseutils.DefaultChargingDebounce, "Longest time a charging status change can be reverted within for it to be taken for charger flapping, e.g. from a loose cable, rather than starting a new summary, e.g. 30s. Disabled if 0.")
+	mobileRadioTail   = flag.Duration("mobile_radio_tail", parseutils.DefaultMobileRadioTail, "Time the mobile radio is modeled to stay active after each app network burst, when charging the radio active time to the apps.")
+	csvAllowMetrics   = flag.String("csv_allow_metrics", os.Getenv("BATTERY_HISTORIAN_CSV_ALLOW_METRICS"), "Comma separated list of the only metrics emitted into the generated CSVs, e.g. \"Screen,Plugged\". All metrics are emitted if empty. Defaults to the BATTERY_HISTORIAN_CSV_ALLOW_METRICS environment variable.")
+	csvDenyMetrics    = flag.String("csv_deny_metrics", os.Getenv("BATTERY_HISTORIAN_CSV_DENY_METRICS"), "Comma separated list of metrics never emitted into the generated CSVs, e.g. \"Partial wakelock,SyncManager\" to drop the series with service names. Takes precedence over --csv_allow_metrics. Defaults to the BATTERY_HISTORIAN_CSV_DENY_METRICS environment variable.")
+	redact            = flag.String("redact", os.Getenv("BATTERY_HISTORIAN_REDACT"), "Comma separated list of UIDs and package names of apps to anonymize in the analyses, e.g. \"10015,com.example.app\". Their package names are replaced everywhere in the bug reports, and their usage is reported under a single \"redacted\" app, so the totals are kept. Defaults to the BATTERY_HISTORIAN_REDACT environment variable.")
+	lang              = flag.String("lang", messages.DefaultLanguage, "Language of the warnings in the analyses.")
+	messageCatalog    = flag.String("message_catalog", "", "Path to a message catalog file with the warnings in other languages, or overriding the default ones, in the format of messages.AddCatalog.")
+	snapshotInterval  = flag.Duration("snapshot_interval", 0, "How often, in battery history time, to capture a snapshot of the device state (e.g. held wakelocks, running jobs) into the analysis, e.g. 5m. Disabled if 0.")
+
+	maxFileSize        = flag.Int64("max_file_size", 100*1024*1024, "Maximum size in bytes of an upload.")
+	maxHistoryLines    = flag.Int("max_history_lines", 0, "Maximum number of battery history lines in an uploaded bug report. Larger reports are rejected. Disabled if 0.")
+	maxConcurrentPerIP = flag.Int("max_concurrent_per_ip", 0, "Maximum number of analyses in progress for a client IP. Disabled if 0.")
+	analysisTimeout    = flag.Duration("analysis_timeout", 0, "How long the analysis of an upload can take, e.g. 2m. Results that aren't ready in time are omitted from the response. Disabled if 0.")
+
+	storageSpec     = flag.String("storage", "", "Where to persist uploaded reports and cached analyses: a local directory, gs://bucket[/prefix] or s3://bucket[/prefix]. Disabled if empty.")
+	retentionTTL    = flag.Duration("retention_ttl", 0, "How long uploaded reports and their cached analyses are kept in the storage, e.g. 168h. Kept forever if 0.")
+	maxStorageBytes = flag.Int64("max_storage_bytes", 0, "Maximum total size in bytes of the stored reports. The oldest reports are deleted first to stay under it. Disabled if 0.")
+	cleanupInterval = flag.Duration("cleanup_interval", time.Hour, "How often the stored reports are checked against --retention_ttl and --max_storage_bytes. Only local directory storage can be cleaned up.")
+	adminToken      = flag.String("admin_token", "", "Bearer token required by the /admin/reports endpoint, which lists the stored reports on GET and deletes the report with the id query parameter on DELETE. Disabled if empty.")
+
 	// resVersion should be incremented whenever the JS or CSS files are modified.
 	resVersion = flag.Int("res_version", 2, "The current version of JS and CSS files. Used to force JS and CSS reloading to avoid cache issues when rolling out new versions.")
 )
@@ -115,11 +149,62 @@ func initFrontend() {
 func main() {
 	flag.Parse()
 
+	if *uidNames != "" {
+		if err := checkinparse.LoadUIDNames(*uidNames); err != nil {
+			log.Fatalf("Could not load UID names: %v", err)
+		}
+	}
+	if *messageCatalog != "" {
+		if err := messages.LoadCatalog(*messageCatalog); err != nil {
+			log.Fatalf("Could not load message catalog: %v", err)
+		}
+	}
+	if *wakeupReasons != "" {
+		if err := parseutils.LoadWakeupReasonNames(*wakeupReasons); err != nil {
+			log.Fatalf("Could not load wakeup reason names: %v", err)
+		}
+	}
 	initFrontend()
 	analyzer.InitTemplates(*templateDir)
 	analyzer.SetScriptsDir(*scriptsDir)
 	analyzer.SetResVersion(*resVersion)
 	analyzer.SetIsOptimized(*optimized)
+	analyzer.SetMaxUnknownPercent(*maxUnknownPercent)
+	analyzer.SetProfileNames(*profileNames)
+	analyzer.SetRedactions(parseutils.ParseRedactions(*redact))
+	analyzer.SetLanguage(*lang)
+	analyzer.SetSnapshotInterval(*snapshotInterval)
+	analyzer.SetSummaryTopN(*summaryTopN)
+	analyzer.SetSummarizeCharging(*summarizeCharging)
+	analyzer.SetScreenMergeGap(*screenMergeGap)
+	analyzer.SetMobileRadioTail(*mobileRadioTail)
+	analyzer.SetChargingDebounce(*chargingDebounce)
+	analyzer.SetCSVMetricFilter(csv.NewMetricFilter(csv.SplitMetrics(*csvAllowMetrics), csv.SplitMetrics(*csvDenyMetrics)))
+	analyzer.SetMaxFileSize(*maxFileSize)
+	analyzer.SetMaxHistoryLines(*maxHistoryLines)
+	analyzer.SetMaxConcurrentPerIP(*maxConcurrentPerIP)
+	analyzer.SetAnalysisTimeout(*analysisTimeout)
+	http.HandleFunc("/validate", analyzer.ValidateHandler)
+	http.HandleFunc("/progress", analyzer.ProgressHandler)
+	if *storageSpec != "" {
+		s, err := storage.New(*storageSpec)
+		if err != nil {
+			log.Fatalf("Could not initialize storage %q: %v", *storageSpec, err)
+		}
+		analyzer.SetStore(s)
+		http.HandleFunc("/device_history", analyzer.DeviceHistoryHandler)
+		http.HandleFunc("/state_at", analyzer.StateAtHandler)
+		http.HandleFunc("/explain_step", analyzer.ExplainStepHandler)
+		if *retentionTTL > 0 || *maxStorageBytes > 0 {
+			if err := analyzer.StartCleaner(analyzer.RetentionPolicy{TTL: *retentionTTL, MaxBytes: *maxStorageBytes}, *cleanupInterval); err != nil {
+				log.Fatalf("Could not start the storage cleanup: %v", err)
+			}
+		}
+	}
+	if *adminToken != "" {
+		analyzer.SetAdminToken(*adminToken)
+		http.HandleFunc("/admin/reports", analyzer.AdminReportsHandler)
+	}
 	log.Println("Listening on port: ", *port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
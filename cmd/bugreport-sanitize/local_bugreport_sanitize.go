@@ -0,0 +1,55 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// local_bugreport_sanitize trims a bug report down to the sections needed by Historian and
+// scrubs PII from it, producing a smaller file that can be attached to public issue trackers.
+//
+// Example Usage:
+//  ./local_bugreport_sanitize -input=bugreport.zip -output=bugreport_sanitized.txt
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/google/battery-historian/bugreportutils"
+)
+
+var (
+	inputFile  = flag.String("input", "", "Bugreport to be read. Can be a text file or a zip file.")
+	outputFile = flag.String("output", "bugreport_sanitized.txt", "Sanitized bugreport file to write to")
+)
+
+func main() {
+	flag.Parse()
+
+	c, err := ioutil.ReadFile(*inputFile)
+	if err != nil {
+		log.Fatalf("Cannot open the file %s: %v", *inputFile, err)
+	}
+	br, fname, err := bugreportutils.ExtractBugReport(*inputFile, c)
+	if err != nil {
+		log.Fatalf("Error getting file contents: %v", err)
+	}
+	fmt.Printf("Sanitizing %s\n", fname)
+
+	s := bugreportutils.Sanitize(br)
+	if err := ioutil.WriteFile(*outputFile, []byte(s), 0644); err != nil {
+		log.Fatalf("Cannot write to the file %s: %v", *outputFile, err)
+	}
+	fmt.Printf("Wrote %s (%d of %d bytes kept)\n", *outputFile, len(s), len(br))
+}
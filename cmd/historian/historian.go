@@ -0,0 +1,533 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// historian runs the Historian analysis from the command line, without the UI.
+//
+// Example Usage:
+//  ./historian csv bugreport.zip > history.csv
+//  ./historian csv --metrics="Screen,CPU running" --format=json bugreport.txt
+//  ./historian csv --redact=10015,com.example.app bugreport.zip > history.csv
+//  ./historian join --labels=Phone,Watch phone_bugreport.zip watch_bugreport.zip > joined.csv
+//  ./historian app --uid=10023 bugreport.zip > app.html
+//  ./historian heatmap bugreport.zip > heatmap.json
+//  ./historian appsummary --uid=10023 bugreport.zip > app_summary.json
+//  ./historian appsummary --lang=fr --message_catalog=messages_fr.json bugreport.zip > app_summary.json
+//  ./historian findings bugreport.zip > findings.json
+//  ./historian validate bugreport.zip
+//  ./historian rollup bugreport.zip >> tracking.csv
+//  ./historian rollup --format=text bugreport.zip
+
+package main
+
+import (
+	"bytes"
+	encodingcsv "encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/battery-historian/activity"
+	"github.com/google/battery-historian/appreport"
+	"github.com/google/battery-historian/appsummary"
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/companion"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/findings"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/rollup"
+	"github.com/google/battery-historian/validate"
+)
+
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+)
+
+// commands are the supported subcommands.
+var commands = map[string]func(args []string){
+	"app":        appCommand,
+	"appsummary": appSummaryCommand,
+	"csv":        csvCommand,
+	"findings":   findingsCommand,
+	"heatmap":    heatmapCommand,
+	"join":       joinCommand,
+	"rollup":     rollUpCommand,
+	"validate":   validateCommand,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: historian <command> [flags] <bugreport>")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  app         Prints an HTML report of a single app's battery history to stdout. Run `historian app --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  appsummary  Prints the per app stats as JSON to stdout, for app developer tooling. Run `historian appsummary --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  csv         Prints the battery history CSV to stdout. Run `historian csv --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  findings    Prints the findings of the heuristics, with severities and evidence, as JSON to stdout. Run `historian findings --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  heatmap     Prints the day by hour heatmap of the drain rate, screen on time and wakeups as JSON to stdout. Run `historian heatmap --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  join        Prints the combined history CSV of two paired devices to stdout. Run `historian join --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  rollup      Prints a single row KPI summary, as CSV, JSON or text, to stdout. Run `historian rollup --help` for flags.")
+	fmt.Fprintln(os.Stderr, "  validate    Checks that a bug report can be analyzed, without analyzing it, and prints the report as JSON to stdout. Exits with status 1 if it can't.")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	cmd(os.Args[2:])
+}
+
+// csvCommand generates the battery history CSV for a bug report and prints it to stdout.
+func csvCommand(args []string) {
+	fs := flag.NewFlagSet("csv", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	format := fs.String("format", formatCSV, "Output format: csv or json.")
+	maxUnknown := fs.Float64("max_unknown_percent", 0, "Strict mode: if more than this percentage of history lines have unknown event codes, print an unsupported report as JSON instead and exit with status 1. Disabled if 0.")
+	metrics := fs.String("metrics", "", "Comma separated list of the only metrics to output, e.g. \"Screen,CPU running\". All metrics are output if empty.")
+	denyMetrics := fs.String("deny_metrics", "", "Comma separated list of metrics never output, e.g. \"Partial wakelock,SyncManager\" to drop the series with service names. Takes precedence over --metrics.")
+	compress := fs.String("compress", "", "Compress the output: gzip or zstd. zstd requires the zstd tool. Not compressed if empty.")
+	redact := fs.String("redact", "", "Comma separated list of UIDs and package names of the apps to anonymize, e.g. \"10015,com.example.app\". Their package names are replaced by \"redacted\" everywhere in the bug report, and their events are combined under it.")
+	lineIndex := fs.String("line_index", "", "File to write the bug report line numbers each battery history event was read from to, as CSV rows identifying the event by metric, start and end time and value. Not written if empty.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian csv [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	validCompress := *compress == "" || *compress == historianutils.EncodingGzip || *compress == historianutils.EncodingZstd
+	if fs.NArg() != 1 || (*format != formatCSV && *format != formatJSON) || !validCompress {
+		fs.Usage()
+		os.Exit(2)
+	}
+	redactions := parseutils.ParseRedactions(*redact)
+	br := redactions.RedactBugReport(readBugReport(fs.Arg(0)))
+	var buf bytes.Buffer
+	var index io.Writer
+	if *lineIndex != "" {
+		f, err := os.Create(*lineIndex)
+		if err != nil {
+			log.Fatalf("Error creating line index: %v", err)
+		}
+		defer f.Close()
+		index = f
+	}
+	rep := historyCSV(&buf, index, br, *scrub, redactions)
+	if u := parseutils.CheckUnknownCodes(rep, *maxUnknown); u != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(u); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		os.Exit(1)
+	}
+
+	// The CSV is filtered once generated, as the server does, so the derived metrics aren't affected.
+	filtered, errs := csv.FilterMetrics(buf.String(), csv.NewMetricFilter(csv.SplitMetrics(*metrics), csv.SplitMetrics(*denyMetrics)))
+	for _, err := range errs {
+		log.Println(err)
+	}
+	if *compress == "" {
+		if err := writeOutput(os.Stdout, strings.NewReader(filtered), *format); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	var out bytes.Buffer
+	if err := writeOutput(&out, strings.NewReader(filtered), *format); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+	c, err := historianutils.Compress(out.Bytes(), *compress)
+	if err != nil {
+		log.Fatalf("Error compressing output: %v", err)
+	}
+	if _, err := os.Stdout.Write(c); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// joinCommand combines the battery history of two paired devices, e.g. a phone and a watch, into
+// a single CSV. The clock of the second device is aligned to the first using the Bluetooth
+// connection events logged on both devices.
+func joinCommand(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	labels := fs.String("labels", "Phone,Watch", "Comma separated labels of the two devices, prepended to the metric names.")
+	maxSkew := fs.Duration("max_skew", companion.DefaultMaxSkew, "Maximum clock difference between the devices considered when matching Bluetooth connection events.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian join [flags] <reference bugreport> <other bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	l := strings.Split(*labels, ",")
+	if fs.NArg() != 2 || len(l) != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var devices []companion.Device
+	var events [][]companion.ConnectionEvent
+	for i, input := range fs.Args() {
+		br := readBugReport(input)
+		var buf bytes.Buffer
+		historyCSV(&buf, nil, br, *scrub, nil)
+		e := connectionEvents(br)
+		companion.WriteConnectionEvents(&buf, e)
+		devices = append(devices, companion.Device{Label: strings.TrimSpace(l[i]), CSV: buf.String()})
+		events = append(events, e)
+	}
+	offset, matched := companion.EstimateOffset(events[0], events[1], *maxSkew)
+	if matched == 0 {
+		log.Printf("No matching Bluetooth connection events found in %d and %d events, clocks are not aligned", len(events[0]), len(events[1]))
+	} else {
+		log.Printf("Shifting %s by %v, estimated from %d Bluetooth connection events", devices[1].Label, offset, matched)
+	}
+	devices[1].Offset = offset
+
+	if err := companion.Join(os.Stdout, devices); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// appCommand prints a report of a single app's battery history events, with device context
+// but without the events of other apps, so it can be shared with the app's developers.
+func appCommand(args []string) {
+	fs := flag.NewFlagSet("app", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	uid := fs.Int("uid", 0, "UID of the app to report on. Required.")
+	format := fs.String("format", "html", "Output format: html or csv.")
+	redact := fs.String("redact", "", "Comma separated list of UIDs and package names of the apps to anonymize, e.g. \"10015,com.example.app\". Their package names are replaced by \"redacted\" everywhere in the bug report, and their events are combined under it.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian app --uid=<uid> [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *uid <= 0 || (*format != "html" && *format != formatCSV) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	redactions := parseutils.ParseRedactions(*redact)
+	br := redactions.RedactBugReport(readBugReport(fs.Arg(0)))
+	var buf bytes.Buffer
+	historyCSV(&buf, nil, br, *scrub, redactions)
+
+	appID := packageutils.AppID(int32(*uid))
+	name := fmt.Sprintf("UID %d", appID)
+	pkgs, _ := packageutils.ExtractAppsFromBugReport(br)
+	for _, p := range pkgs {
+		if p.GetUid() == appID {
+			name = p.GetPkgName()
+			break
+		}
+	}
+	r, errs := appreport.New(buf.String(), appID, name)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	if *format == formatCSV {
+		r.WriteCSV(os.Stdout)
+		return
+	}
+	if err := r.WriteHTML(os.Stdout); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// appSummaryCommand prints the per app stats of the battery history summaries in the documented
+// appsummary JSON format, so app developers can load them into their own tooling.
+func appSummaryCommand(args []string) {
+	fs := flag.NewFlagSet("appsummary", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	uid := fs.Int("uid", 0, "UID of the app to include. All apps are included if 0.")
+	lang := fs.String("lang", messages.DefaultLanguage, "Language of the metric display names.")
+	catalog := fs.String("message_catalog", "", "Path to a message catalog file with the display names in other languages, in the format of messages.AddCatalog.")
+	redact := fs.String("redact", "", "Comma separated list of UIDs and package names of the apps to anonymize, e.g. \"10015,com.example.app\". Their package names are replaced by \"redacted\" everywhere in the bug report, and their events are combined under it.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian appsummary [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *uid < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *catalog != "" {
+		if err := messages.LoadCatalog(*catalog); err != nil {
+			log.Fatalf("Error loading message catalog: %v", err)
+		}
+	}
+	redactions := parseutils.ParseRedactions(*redact)
+	br := redactions.RedactBugReport(readBugReport(fs.Arg(0)))
+	var buf bytes.Buffer
+	rep := historyCSV(&buf, nil, br, *scrub, redactions)
+	meta, err := bugreportutils.ParseMetaInfo(br)
+	if err != nil {
+		log.Printf("Error parsing device info: %v", err)
+	}
+	f := appsummary.New(meta, rep.Summaries)
+	if *uid > 0 {
+		f.Filter(packageutils.AppID(int32(*uid)))
+	}
+	f.Localize(*lang)
+	if err := f.Write(os.Stdout); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// findingsCommand prints the findings of the heuristics run on the battery history in the documented
+// findings JSON format, so triage tooling can route battery bugs.
+func findingsCommand(args []string) {
+	fs := flag.NewFlagSet("findings", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	lang := fs.String("lang", messages.DefaultLanguage, "Language of the finding summaries.")
+	catalog := fs.String("message_catalog", "", "Path to a message catalog file with the summaries in other languages, in the format of messages.AddCatalog.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian findings [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *catalog != "" {
+		if err := messages.LoadCatalog(*catalog); err != nil {
+			log.Fatalf("Error loading message catalog: %v", err)
+		}
+	}
+	br := readBugReport(fs.Arg(0))
+	var buf bytes.Buffer
+	historyCSV(&buf, nil, br, *scrub, nil)
+	meta, err := bugreportutils.ParseMetaInfo(br)
+	if err != nil {
+		log.Printf("Error parsing device info: %v", err)
+	}
+	f, errs := findings.New(meta, buf.String())
+	for _, err := range errs {
+		log.Println(err)
+	}
+	f.Localize(*lang)
+	if err := f.Write(os.Stdout); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// rollUpCommand prints the KPIs of a bug report as a single row, to be appended to a tracking sheet
+// or posted to chat.
+func rollUpCommand(args []string) {
+	fs := flag.NewFlagSet("rollup", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	format := fs.String("format", formatCSV, "Output format: csv, json or text.")
+	header := fs.Bool("header", false, "Whether the CSV header is printed before the row.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian rollup [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*format != formatCSV && *format != formatJSON && *format != "text") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	br := readBugReport(fs.Arg(0))
+	var buf bytes.Buffer
+	rep := historyCSV(&buf, nil, br, *scrub, nil)
+	meta, err := bugreportutils.ParseMetaInfo(br)
+	if err != nil {
+		log.Printf("Error parsing device info: %v", err)
+	}
+	r, errs := rollup.New(meta, buf.String(), rep.Summaries)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	switch *format {
+	case formatJSON:
+		err = json.NewEncoder(os.Stdout).Encode(r)
+	case "text":
+		_, err = fmt.Println(r)
+	default:
+		err = r.WriteCSV(os.Stdout, *header)
+	}
+	if err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// validateCommand checks that a bug report can be analyzed without analyzing it, and prints the
+// validation report as JSON. It exits with status 1 if the bug report can't be analyzed.
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian validate <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	c, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Cannot open the file %s: %v", fs.Arg(0), err)
+	}
+	rep := validate.Check(fs.Arg(0), c)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+	if !rep.Valid {
+		os.Exit(1)
+	}
+}
+
+// heatmapCommand prints the drain rate, screen on time and wakeups of each hour of each day of the
+// battery history, in the device time zone, as JSON.
+func heatmapCommand(args []string) {
+	fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	scrub := fs.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: historian heatmap [flags] <bugreport>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	br := readBugReport(fs.Arg(0))
+	var buf bytes.Buffer
+	historyCSV(&buf, nil, br, *scrub, nil)
+	loc, err := bugreportutils.TimeZone(br)
+	if err != nil {
+		log.Fatalf("Error getting time zone: %v", err)
+	}
+	h, errs := parseutils.DayHourHeatmap(buf.String(), loc)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	if h == nil {
+		log.Fatal("No battery history found")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// readBugReport reads the bug report contents from the given file, decompressing and extracting it from a zip if needed.
+func readBugReport(input string) string {
+	c, err := ioutil.ReadFile(input)
+	if err != nil {
+		log.Fatalf("Cannot open the file %s: %v", input, err)
+	}
+	br, _, err := bugreportutils.ExtractBugReport(input, c)
+	if err != nil {
+		log.Fatalf("Error getting file contents: %v", err)
+	}
+	return br
+}
+
+// connectionEvents returns the Bluetooth connection events found in the current logs of the bug report.
+// The last logcat is from before the last reboot, so is not used.
+func connectionEvents(br string) []companion.ConnectionEvent {
+	pkgs, _ := packageutils.ExtractAppsFromBugReport(br)
+	data := activity.Parse(pkgs, br)
+	for _, err := range data.Errs {
+		log.Println(err)
+	}
+	var events []companion.ConnectionEvent
+	for _, section := range []string{activity.SystemLogSection, activity.EventLogSection} {
+		l, ok := data.Logs[section]
+		if !ok || l == nil {
+			continue
+		}
+		e, errs := companion.ConnectionEvents(l.CSV)
+		for _, err := range errs {
+			log.Println(err)
+		}
+		events = append(events, e...)
+	}
+	return events
+}
+
+// historyCSV writes the battery history CSV of the bug report, including the step fingerprints,
+// suspend efficiency and charging current, and returns the analysis report. If index isn't nil, the
+// bug report lines the battery history events were read from are written to it. The services of the
+// redacted apps are anonymized. Errors are written to stderr so they don't mix with the output.
+func historyCSV(w *bytes.Buffer, index io.Writer, br string, scrub bool, redactions *parseutils.Redactions) *parseutils.AnalysisReport {
+	pkgs, errs := packageutils.ExtractAppsFromBugReport(br)
+	if len(errs) > 0 {
+		log.Printf("Errors encountered when getting package list: %v\n", errs)
+	}
+	upm, errs := parseutils.UIDAndPackageNameMapping(br, pkgs)
+	if len(errs) > 0 {
+		log.Printf("Errors encountered when generating package mapping: %v\n", errs)
+	}
+	upm.SetRedactions(redactions)
+	rep := parseutils.AnalyzeHistoryWithOptions(w, br, parseutils.FormatTotalTime, upm, scrub, parseutils.HistoryOptions{LineIndex: index, ChargingDebounce: parseutils.DefaultChargingDebounce})
+	errs = append(rep.Errs, parseutils.WriteDerivedMetrics(w, br)...)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	return rep
+}
+
+// writeOutput writes the CSV rows in the given format.
+func writeOutput(w io.Writer, r io.Reader, format string) error {
+	reader := encodingcsv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, rec := range records {
+		if len(rec) == 0 || strings.Join(rec, ",") == csv.FileHeader {
+			continue
+		}
+		rows = append(rows, rec)
+	}
+
+	if format == formatJSON {
+		header := strings.Split(csv.FileHeader, ",")
+		var objs []map[string]string
+		for _, row := range rows {
+			obj := make(map[string]string)
+			for i, h := range header {
+				if i < len(row) {
+					obj[h] = row[i]
+				}
+			}
+			objs = append(objs, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objs)
+	}
+	writer := encodingcsv.NewWriter(w)
+	writer.Write(strings.Split(csv.FileHeader, ","))
+	writer.WriteAll(rows)
+	return writer.Error()
+}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -24,20 +25,38 @@ import (
 	"path/filepath"
 
 	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/eventsink"
 	"github.com/google/battery-historian/packageutils"
 	"github.com/google/battery-historian/parseutils"
+	"github.com/google/battery-historian/sqlexport"
+	"github.com/google/battery-historian/xlsxexport"
 )
 
 var (
-	summaryFormat = flag.String("summary", parseutils.FormatBatteryLevel, "1. batteryLevel 2. totalTime")
-	input         = flag.String("input", "", "A bug report or a battery history file generated by `adb shell dumpsys batterystats -c --history-start <start>`")
-	csvFile       = flag.String("csv", "", "Output filename to write csv data to.")
-	scrubPII      = flag.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
-	multiple      = flag.Bool("multiple", false, "If true, generates the combined results from multiple bugreports. In this case input should be a directory containing bugreports.")
+	summaryFormat     = flag.String("summary", parseutils.FormatBatteryLevel, "1. batteryLevel 2. totalTime")
+	input             = flag.String("input", "", "A bug report or a battery history file generated by `adb shell dumpsys batterystats -c --history-start <start>`")
+	csvFile           = flag.String("csv", "", "Output filename to write csv data to.")
+	sqliteFile        = flag.String("sqlite", "", "SQLite database filename to export the summaries to. Requires the sqlite3 tool.")
+	xlsxFile          = flag.String("xlsx", "", "Output filename to write the summaries to as an Excel workbook, with a cover sheet of KPIs and a sheet per summary breakdown.")
+	jsonFile          = flag.String("json", "", "Output filename to write the batteryLevel summaries to, as a JSON map keyed by level drop (e.g. \"100->99\").")
+	scrubPII          = flag.Bool("scrub", true, "Whether ScrubPII is applied to addresses.")
+	multiple          = flag.Bool("multiple", false, "If true, generates the combined results from multiple bugreports. In this case input should be a directory containing bugreports.")
+	profileNames      = flag.Bool("profile_names", false, "Whether apps of secondary users, such as work profiles, are kept separate from the primary user's apps, e.g. as \"com.google.android.gm (work)\".")
+	summarizeCharging = flag.Bool("summarize_charging", false, "If true, charging periods are also summarized, in summaries marked as charging, instead of only discharge intervals.")
+	topN              = flag.Int("top_n", 0, "Number of entries kept in each per app or per state summary breakdown, by total duration, with the rest rolled up into an \"others\" entry. All entries are kept if 0.")
+	wakeupReasons     = flag.String("wakeup_reason_names", "", "Path to a file, optionally gzip compressed, of readable names for the encoded wakeup reasons of vendor devices, in the format of parseutils/wakeup_reasons.txt.")
+	eventsFile        = flag.String("events_ndjson", "", "Output filename to write the battery history events to as they are parsed, as newline delimited JSON records, e.g. for loading into a data warehouse.")
+	redact            = flag.String("redact", "", "Comma separated list of UIDs and package names of apps to anonymize in the CSV, summaries and exports, e.g. \"10015,com.example.app\". Their usage is reported under a single \"redacted\" app.")
+	summariesOnly     = flag.Bool("summaries_only", false, "If true, no battery history CSV is generated, which uses much less memory when only the summaries are needed. Can't be used with --csv for the totalTime summary format, or with --events_ndjson.")
+
+	// levelSummaries are the batteryLevel summaries of all processed files, written to jsonFile.
+	levelSummaries []parseutils.ActivitySummary
+	// xlsxReports are the summaries of all processed files, written to xlsxFile.
+	xlsxReports []xlsxexport.Report
 )
 
 func usage() {
-	fmt.Println("Incorrect summary argument. Format: --summary=[batteryLevel|totalTime] [--csv=<csv-output-file>]")
+	fmt.Println("Incorrect summary argument. Format: --summary=[batteryLevel|totalTime] [--csv=<csv-output-file>] [--sqlite=<sqlite-output-file>] [--xlsx=<xlsx-output-file>] [--json=<json-output-file>] [--top_n=<n>] [--summarize_charging] [--summaries_only] [--wakeup_reason_names=<names-file>] [--redact=<uids-and-packages>]")
 	fmt.Println("Single report: --input=<report-file>")
 	fmt.Println("Multiple reports: --input=<report-directory> --multiple")
 	os.Exit(1)
@@ -51,14 +70,27 @@ func checkFlags() {
 		usage()
 	}
 
-	if *input == "" {
+	if *input == "" || (*jsonFile != "" && *summaryFormat != parseutils.FormatBatteryLevel) ||
+		(*summariesOnly && *csvFile != "" && *summaryFormat == parseutils.FormatTotalTime) ||
+		(*summariesOnly && *eventsFile != "") {
 		usage()
 	}
 }
 
+// writeLevelSteps writes the batteryLevel summaries of all processed files to jsonFile, keyed by level drop.
+func writeLevelSteps() {
+	b, err := json.MarshalIndent(parseutils.BatteryLevelSummariesToSteps(levelSummaries), "", "  ")
+	if err != nil {
+		log.Fatalf("Error generating JSON: %v", err)
+	}
+	if err := ioutil.WriteFile(*jsonFile, b, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *jsonFile, err)
+	}
+}
+
 // processFile processes a single bugreport file, and returns the parsing result as a string.
 // Writes csv data to csvWriter if a csv file is specified.
-func processFile(filePath string, csvWriter *bufio.Writer, isFirstFile bool) string {
+func processFile(filePath string, csvWriter, eventsWriter *bufio.Writer, isFirstFile bool) string {
 	// Read the whole file
 	c, err := ioutil.ReadFile(filePath)
 	if err != nil {
@@ -69,9 +101,14 @@ func processFile(filePath string, csvWriter *bufio.Writer, isFirstFile bool) str
 		log.Fatalf("Error getting file contents: %v", err)
 	}
 	fmt.Printf("Parsing %s\n", fname)
+	redactions := parseutils.ParseRedactions(*redact)
+	br = redactions.RedactBugReport(br)
 
 	writer := ioutil.Discard
-	if csvWriter != nil && *summaryFormat == parseutils.FormatTotalTime {
+	if *summariesOnly {
+		// The battery level summaries CSV is still written below, as it's generated from the summaries.
+		writer = nil
+	} else if csvWriter != nil && *summaryFormat == parseutils.FormatTotalTime {
 		writer = csvWriter
 	}
 
@@ -83,7 +120,18 @@ func processFile(filePath string, csvWriter *bufio.Writer, isFirstFile bool) str
 	if len(errs) > 0 {
 		log.Printf("Errors encountered when generating package mapping: %v\n", errs)
 	}
-	rep := parseutils.AnalyzeHistory(writer, br, *summaryFormat, upm, *scrubPII)
+	upm.SetProfileNames(*profileNames)
+	upm.SetRedactions(redactions)
+	// Battery history files don't have the device model, so vendor wakeup reasons are only decoded with the names for all devices.
+	var model string
+	if meta, err := bugreportutils.ParseMetaInfo(br); err == nil {
+		model = meta.ModelName
+	}
+	opts := parseutils.HistoryOptions{SummarizeCharging: *summarizeCharging, DeviceModel: model}
+	if eventsWriter != nil {
+		opts.Sink = eventsink.NewNDJSON(eventsWriter, fname)
+	}
+	rep := parseutils.AnalyzeHistoryWithOptions(writer, br, *summaryFormat, upm, *scrubPII, opts)
 
 	// Exclude summaries with no change in battery level
 	var a []parseutils.ActivitySummary
@@ -93,9 +141,14 @@ func processFile(filePath string, csvWriter *bufio.Writer, isFirstFile bool) str
 		}
 	}
 
+	parseutils.TruncateSummaries(a, *topN)
+
 	if rep.TimestampsAltered {
 		fmt.Println("Some timestamps were changed while processing the log.")
 	}
+	for _, c := range rep.ClockChanges {
+		fmt.Printf("Clock changed by %v at %d.\n", c.Shift(), c.ReportedMs)
+	}
 	if len(rep.Errs) > 0 {
 		fmt.Println("Errors encountered:")
 		for _, err := range rep.Errs {
@@ -113,12 +166,31 @@ func processFile(filePath string, csvWriter *bufio.Writer, isFirstFile bool) str
 		parseutils.BatteryLevelSummariesToCSV(csvWriter, &a, isFirstFile)
 	}
 
+	if *jsonFile != "" {
+		levelSummaries = append(levelSummaries, a...)
+	}
+
+	if *sqliteFile != "" {
+		if err := sqlexport.Export(*sqliteFile, fname, a); err != nil {
+			log.Printf("Error exporting to sqlite: %v\n", err)
+		}
+	}
+
+	if *xlsxFile != "" {
+		xlsxReports = append(xlsxReports, xlsxexport.Report{Name: fname, Summaries: a})
+	}
+
 	return rep.OutputBuffer.String()
 }
 
 func main() {
 	flag.Parse()
 	checkFlags()
+	if *wakeupReasons != "" {
+		if err := parseutils.LoadWakeupReasonNames(*wakeupReasons); err != nil {
+			log.Fatalf("Could not load wakeup reason names: %v", err)
+		}
+	}
 
 	var csvWriter *bufio.Writer
 	if *csvFile != "" {
@@ -131,6 +203,16 @@ func main() {
 		defer csvWriter.Flush()
 
 	}
+	var eventsWriter *bufio.Writer
+	if *eventsFile != "" {
+		f, err := os.Create(*eventsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		eventsWriter = bufio.NewWriter(f)
+		defer eventsWriter.Flush()
+	}
 	isFirstFile := true
 	if *multiple {
 		// Process multiple history files
@@ -139,13 +221,21 @@ func main() {
 				return nil
 			}
 			fmt.Println("Processing ", filePath, "...")
-			result := processFile(filePath, csvWriter, isFirstFile)
+			result := processFile(filePath, csvWriter, eventsWriter, isFirstFile)
 			fmt.Println(result)
 			isFirstFile = false
 			return nil
 		})
 	} else {
-		result := processFile(*input, csvWriter, isFirstFile)
+		result := processFile(*input, csvWriter, eventsWriter, isFirstFile)
 		fmt.Println(result)
 	}
+	if *jsonFile != "" {
+		writeLevelSteps()
+	}
+	if *xlsxFile != "" {
+		if err := xlsxexport.Export(*xlsxFile, xlsxReports); err != nil {
+			log.Fatalf("Error exporting to xlsx: %v", err)
+		}
+	}
 }
@@ -0,0 +1,200 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package companion joins the timelines of two devices paired over Bluetooth (e.g. a watch and
+// a phone), aligning their clocks using the Bluetooth connection events seen on both devices.
+package companion
+
+import (
+	encodingcsv "encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// Connection is the CSV description of Bluetooth connection events.
+	Connection = "Bluetooth connection"
+
+	// DefaultMaxSkew is the default maximum clock difference between devices considered when matching connection events.
+	DefaultMaxSkew = 5 * time.Minute
+
+	connected    = "connected"
+	disconnected = "disconnected"
+)
+
+var (
+	// connectedRE matches log lines for a Bluetooth link being established.
+	// e.g. "Intent { act=android.bluetooth.device.action.ACL_CONNECTED ...}" or "btif_dm_upstreams_cback ev: BTA_DM_LINK_UP_EVT"
+	connectedRE = regexp.MustCompile(`\bACL_CONNECTED\b|\bBTA_DM_LINK_UP_EVT\b`)
+	// disconnectedRE matches log lines for a Bluetooth link being lost.
+	disconnectedRE = regexp.MustCompile(`\bACL_DISCONNECTED\b|\bBTA_DM_LINK_DOWN_EVT\b`)
+)
+
+// ConnectionEvent is a Bluetooth link being established or lost.
+type ConnectionEvent struct {
+	Ms        int64
+	Connected bool
+}
+
+// ConnectionEvents returns the Bluetooth connection events found in a CSV generated from the logs
+// by activity.Parse, sorted by time.
+func ConnectionEvents(csvInput string) ([]ConnectionEvent, []error) {
+	r := encodingcsv.NewReader(strings.NewReader(csvInput))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, []error{err}
+	}
+	var events []ConnectionEvent
+	var errs []error
+	for _, rec := range records {
+		// Fields are: metric, type, start_time, end_time, value, opt.
+		if len(rec) < 5 || strings.Join(rec, ",") == csv.FileHeader {
+			continue
+		}
+		c := connectedRE.MatchString(rec[4])
+		if !c && !disconnectedRE.MatchString(rec[4]) {
+			continue
+		}
+		ms, err := strconv.ParseInt(rec[2], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid start time for %q: %v", rec[0], err))
+			continue
+		}
+		events = append(events, ConnectionEvent{ms, c})
+	}
+	sort.Sort(byTime(events))
+	return events, errs
+}
+
+// byTime sorts connection events in ascending order of time.
+type byTime []ConnectionEvent
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].Ms < a[j].Ms }
+
+// EstimateOffset returns the offset to add to the other device's timestamps to align them with the
+// reference device, and the number of connection events matched to compute it. Each event of the
+// other device is matched to the closest event of the same kind on the reference device within
+// maxSkew, and the median difference is used so that the occasional wrong match is ignored.
+// The offset is 0 if no events could be matched.
+func EstimateOffset(ref, other []ConnectionEvent, maxSkew time.Duration) (time.Duration, int) {
+	maxMs := int64(maxSkew / time.Millisecond)
+	var diffs []int64
+	for _, o := range other {
+		best, found := int64(0), false
+		for _, r := range ref {
+			if r.Connected != o.Connected {
+				continue
+			}
+			d := r.Ms - o.Ms
+			if d > maxMs || d < -maxMs {
+				continue
+			}
+			if !found || abs(d) < abs(best) {
+				best, found = d, true
+			}
+		}
+		if found {
+			diffs = append(diffs, best)
+		}
+	}
+	if len(diffs) == 0 {
+		return 0, 0
+	}
+	sort.Sort(int64s(diffs))
+	median := diffs[len(diffs)/2]
+	if len(diffs)%2 == 0 {
+		median = (diffs[len(diffs)/2-1] + median) / 2
+	}
+	return time.Duration(median) * time.Millisecond, len(diffs)
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
+
+// WriteConnectionEvents writes the connection events as instant CSV events, so they can be seen on the joined timeline.
+func WriteConnectionEvents(w io.Writer, events []ConnectionEvent) {
+	csvState := csv.NewState(w, false)
+	for _, e := range events {
+		v := disconnected
+		if e.Connected {
+			v = connected
+		}
+		csvState.Print(Connection, "string", e.Ms, e.Ms, v, "")
+	}
+}
+
+// Device is the timeline of one of the devices to join.
+type Device struct {
+	// Label is prepended to the metric names of the device, e.g. "Watch".
+	Label string
+	// CSV is the timeline of the device, in the format generated by AnalyzeHistory.
+	CSV string
+	// Offset is added to the timestamps of the device.
+	Offset time.Duration
+}
+
+// Join writes a single CSV containing the events of all devices, with each metric prefixed by the
+// device label and timestamps shifted by the device offset.
+func Join(w io.Writer, devices []Device) error {
+	io.WriteString(w, csv.FileHeader+"\n")
+	writer := encodingcsv.NewWriter(w)
+	for _, d := range devices {
+		r := encodingcsv.NewReader(strings.NewReader(d.CSV))
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.Label, err)
+		}
+		offsetMs := int64(d.Offset / time.Millisecond)
+		for _, rec := range records {
+			if len(rec) < 4 || strings.Join(rec, ",") == csv.FileHeader {
+				continue
+			}
+			rec[0] = fmt.Sprintf("%s: %s", d.Label, rec[0])
+			for _, i := range []int{2, 3} {
+				t, err := strconv.ParseInt(rec[i], 10, 64)
+				if err != nil {
+					return fmt.Errorf("%s: invalid time for %q: %v", d.Label, rec[0], err)
+				}
+				// Negative times are markers for unknown times, so are left as is.
+				if t >= 0 {
+					rec[i] = strconv.FormatInt(t+offsetMs, 10)
+				}
+			}
+			writer.Write(rec)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
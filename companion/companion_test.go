@@ -0,0 +1,117 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package companion
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+func TestConnectionEvents(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`bt_btif,service,3000,3000,btif_dm_upstreams_cback ev: BTA_DM_LINK_DOWN_EVT,`,
+		`ActivityManager,service,1000,1000,"Intent { act=android.bluetooth.device.action.ACL_CONNECTED flg=0x4000010 (has extras) }",`,
+		`bt_btif,service,2000,2000,btif_dm_upstreams_cback ev: BTA_DM_LINK_UP_EVT,`,
+		`ActivityManager,service,2500,2500,"Intent { act=android.bluetooth.device.action.ACL_DISCONNECTED flg=0x4000010 (has extras) }",`,
+		`Vold,service,2600,2600,Vold 2.1 (the revenge) firing up,`,
+	}, "\n")
+	want := []ConnectionEvent{{1000, true}, {2000, true}, {2500, false}, {3000, false}}
+	got, errs := ConnectionEvents(input)
+	if len(errs) > 0 {
+		t.Errorf("ConnectionEvents(%v) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConnectionEvents(%v)\n got: %v\n want: %v", input, got, want)
+	}
+}
+
+func TestEstimateOffset(t *testing.T) {
+	tests := []struct {
+		desc        string
+		ref, other  []ConnectionEvent
+		wantOffset  time.Duration
+		wantMatched int
+	}{
+		{
+			desc:  "No events",
+			ref:   []ConnectionEvent{{1000, true}},
+			other: nil,
+		},
+		{
+			desc:  "Events too far apart",
+			ref:   []ConnectionEvent{{1000, true}},
+			other: []ConnectionEvent{{1000 + int64(DefaultMaxSkew/time.Millisecond) + 1, true}},
+		},
+		{
+			desc: "Other device clock ahead, with one wrong match",
+			ref: []ConnectionEvent{
+				{100000, true}, {200000, false}, {300000, true}, {400000, false},
+			},
+			other: []ConnectionEvent{
+				{102000, true}, {202000, false}, {302100, true}, {401900, false},
+				{250000, true}, // Matched to the connection at 300000, but outweighed by the other events.
+			},
+			wantOffset:  -2000 * time.Millisecond,
+			wantMatched: 5,
+		},
+	}
+	for _, test := range tests {
+		offset, matched := EstimateOffset(test.ref, test.other, DefaultMaxSkew)
+		if offset != test.wantOffset || matched != test.wantMatched {
+			t.Errorf("%v: EstimateOffset(%v, %v) = (%v, %d), want (%v, %d)", test.desc, test.ref, test.other, offset, matched, test.wantOffset, test.wantMatched)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	devices := []Device{
+		{
+			Label: "Phone",
+			CSV: strings.Join([]string{
+				csv.FileHeader,
+				`CPU running,string,1000,2000,,`,
+			}, "\n"),
+		},
+		{
+			Label: "Watch",
+			CSV: strings.Join([]string{
+				csv.FileHeader,
+				`Screen,bool,1500,2500,true,"unknown, reason"`,
+				`Activity Manager Proc,service,-1,3000,"0,123,com.google.android.wearable.app",`,
+			}, "\n"),
+			Offset: -500 * time.Millisecond,
+		},
+	}
+	want := strings.Join([]string{
+		csv.FileHeader,
+		`Phone: CPU running,string,1000,2000,,`,
+		`Watch: Screen,bool,1000,2000,true,"unknown, reason"`,
+		`Watch: Activity Manager Proc,service,-1,2500,"0,123,com.google.android.wearable.app",`,
+		``,
+	}, "\n")
+	var b bytes.Buffer
+	if err := Join(&b, devices); err != nil {
+		t.Fatalf("Join(%v) got unexpected error: %v", devices, err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("Join(%v)\n got: %v\n want: %v", devices, got, want)
+	}
+}
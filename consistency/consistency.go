@@ -0,0 +1,230 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package consistency compares the durations derived from the battery history against the
+// aggregated checkin totals. Large deviations usually indicate a truncated history buffer
+// or a parsing bug.
+package consistency
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+
+	bspb "github.com/google/battery-historian/pb/batterystats_proto"
+)
+
+const (
+	// DefaultThreshold is the default relative difference above which a deviation is reported.
+	DefaultThreshold = 0.1
+	// minDifference is the absolute difference below which deviations are ignored, as
+	// short durations are dominated by rounding and the timing of the dump.
+	minDifference = time.Minute
+	// minTruncation is how long after the start of the checkin data the history must start to be
+	// reported as truncated, as the two aren't reset at exactly the same time.
+	minTruncation = 5 * time.Minute
+)
+
+// Deviation is a metric whose history derived total differs from the checkin total.
+type Deviation struct {
+	Metric  string
+	History time.Duration
+	Checkin time.Duration
+}
+
+// Ratio returns the difference between the totals, relative to the checkin total.
+func (d Deviation) Ratio() float64 {
+	if d.Checkin == 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(float64(d.History-d.Checkin)) / float64(d.Checkin)
+}
+
+// String returns a human readable description of the deviation.
+func (d Deviation) String() string {
+	return d.Message().String()
+}
+
+// Message returns the warning about the deviation, to be presented in any language.
+func (d Deviation) Message() messages.Message {
+	return messages.New(messages.HistoryDeviation, d.Metric, d.History, d.Checkin, 100*d.Ratio())
+}
+
+// deviates returns whether the two totals differ by more than the threshold.
+func deviates(history, checkin time.Duration, threshold float64) bool {
+	diff := history - checkin
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff >= minDifference && float64(diff) > threshold*float64(checkin)
+}
+
+func msec(f float32) time.Duration {
+	return time.Duration(f) * time.Millisecond
+}
+
+// Check compares the history summaries against the checkin totals, and returns the metrics
+// differing by more than the given relative threshold. Only summaries starting after the
+// checkin data collection started are counted. Per wakelock totals are only compared if the
+// history contains wakelock_in events (i.e. full wake history was enabled). Checkin only records
+// partial wakelocks while the screen is off, so the threshold should allow for screen on holds.
+// The mobile radio active time the history attributes to the app that woke up the radio is compared
+// against the per app checkin time, which apportions the active time by the app's packets, so the
+// deviations tell how far the wakeup heuristic is off for the apps it charges.
+func Check(summaries []parseutils.ActivitySummary, bs *bspb.BatteryStats, threshold float64) []Deviation {
+	if bs == nil {
+		return nil
+	}
+	start := bs.GetSystem().GetBattery().GetStartClockTimeMsec()
+
+	var screenOn, mobileActive time.Duration
+	wakelocks := make(map[string]time.Duration)
+	radioApps := make(map[string]time.Duration)
+	for _, s := range summaries {
+		// Checkin only covers the time since the device was last unplugged.
+		if s.StartTimeMs < start || s.Charging {
+			continue
+		}
+		screenOn += s.ScreenOnSummary.TotalDuration
+		mobileActive += s.MobileRadioOnSummary.TotalDuration
+		for n, d := range s.WakeLockDetailedSummary {
+			wakelocks[strings.Trim(n, `"`)] += d.TotalDuration
+		}
+		for n, d := range s.MobileRadioActiveAppSummary {
+			radioApps[strings.Trim(n, `"`)] += d.TotalDuration
+		}
+	}
+
+	misc := bs.GetSystem().GetMisc()
+	var devs []Deviation
+	for _, d := range []Deviation{
+		{"Screen on", screenOn, msec(misc.GetScreenOnTimeMsec())},
+		{"Mobile radio active", mobileActive, msec(misc.GetMobileActiveTimeMsec())},
+	} {
+		if deviates(d.History, d.Checkin, threshold) {
+			devs = append(devs, d)
+		}
+	}
+
+	devs = append(devs, checkRadioApps(radioApps, bs, threshold)...)
+
+	if len(wakelocks) == 0 {
+		return devs
+	}
+	checkin := make(map[string]time.Duration)
+	for _, app := range bs.GetApp() {
+		for _, w := range app.GetWakelock() {
+			// The total duration isn't apportioned between concurrent wakelocks, so matches the history.
+			d := time.Duration(w.GetPartialTotalDurationMsec()) * time.Millisecond
+			if d == 0 {
+				d = msec(w.GetPartialTimeMsec())
+			}
+			checkin[w.GetName()] += d
+		}
+	}
+	var names []string
+	for n := range wakelocks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if c, ok := checkin[n]; ok && deviates(wakelocks[n], c, threshold) {
+			devs = append(devs, Deviation{"Partial wakelock " + n, wakelocks[n], c})
+		}
+	}
+	return devs
+}
+
+// checkRadioApps compares the mobile radio active time attributed to each app by the history, keyed
+// by package name or "UID <app ID>" for unknown packages, against the app's checkin time. Apps without
+// checkin time aren't compared, as for wakelocks.
+func checkRadioApps(history map[string]time.Duration, bs *bspb.BatteryStats, threshold float64) []Deviation {
+	if len(history) == 0 {
+		return nil
+	}
+	checkin := make(map[string]time.Duration)
+	for _, app := range bs.GetApp() {
+		d := msec(app.GetNetwork().GetMobileActiveTimeMsec())
+		if d == 0 {
+			continue
+		}
+		checkin[app.GetName()] += d
+		checkin[fmt.Sprintf("UID %d", packageutils.AppID(app.GetUid()))] += d
+	}
+	var names []string
+	for n := range history {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var devs []Deviation
+	for _, n := range names {
+		if c, ok := checkin[n]; ok && deviates(history[n], c, threshold) {
+			devs = append(devs, Deviation{"Mobile radio active app " + n, history[n], c})
+		}
+	}
+	return devs
+}
+
+// Truncation is a battery history that doesn't reach back to the start of the checkin data, i.e. the
+// last charge, usually because the history buffer filled up on a busy device.
+type Truncation struct {
+	HistoryStartMs, CheckinStartMs int64
+}
+
+// Missing returns how much of the time since the last charge isn't covered by the history.
+func (t Truncation) Missing() time.Duration {
+	return time.Duration(t.HistoryStartMs-t.CheckinStartMs) * time.Millisecond
+}
+
+// String returns a human readable description of the truncation.
+func (t Truncation) String() string {
+	return t.Message().String()
+}
+
+// Message returns the warning about the truncation, to be presented in any language.
+func (t Truncation) Message() messages.Message {
+	return messages.New(messages.HistoryTruncated, t.Missing().Hours())
+}
+
+// CheckTruncation returns the truncation of the battery history CSV generated by parseutils.AnalyzeHistory,
+// if it starts more than 5 minutes after the checkin data collection, or nil otherwise.
+func CheckTruncation(historyCSV string, bs *bspb.BatteryStats) (*Truncation, []error) {
+	start := bs.GetSystem().GetBattery().GetStartClockTimeMsec()
+	if start <= 0 {
+		return nil, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{parseutils.BatteryLevel})
+	levels := es[parseutils.BatteryLevel]
+	if len(levels) == 0 {
+		return nil, errs
+	}
+	histStart := levels[0].Start
+	for _, e := range levels {
+		if e.Start < histStart {
+			histStart = e.Start
+		}
+	}
+	t := &Truncation{HistoryStartMs: histStart, CheckinStartMs: start}
+	if t.Missing() < minTruncation {
+		return nil, errs
+	}
+	return t, errs
+}
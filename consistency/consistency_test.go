@@ -0,0 +1,193 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consistency
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/parseutils"
+
+	bspb "github.com/google/battery-historian/pb/batterystats_proto"
+)
+
+// TestCheck tests the comparison of history derived totals against checkin totals.
+func TestCheck(t *testing.T) {
+	bs := &bspb.BatteryStats{
+		System: &bspb.BatteryStats_System{
+			Battery: &bspb.BatteryStats_System_Battery{
+				StartClockTimeMsec: proto.Int64(1000),
+			},
+			Misc: &bspb.BatteryStats_System_Misc{
+				ScreenOnTimeMsec:     proto.Float32(600000),  // 10 minutes.
+				MobileActiveTimeMsec: proto.Float32(3600000), // 1 hour.
+			},
+		},
+		App: []*bspb.BatteryStats_App{
+			{
+				Name: proto.String("com.example.chat"),
+				Uid:  proto.Int32(10045),
+				Network: &bspb.BatteryStats_App_Network{
+					MobileActiveTimeMsec: proto.Float32(600000), // 10 minutes.
+				},
+			},
+			{
+				Uid: proto.Int32(10031),
+				Network: &bspb.BatteryStats_App_Network{
+					MobileActiveTimeMsec: proto.Float32(120000), // 2 minutes.
+				},
+			},
+			{
+				Wakelock: []*bspb.BatteryStats_App_Wakelock{
+					{
+						Name:                     proto.String("*alarm*"),
+						PartialTimeMsec:          proto.Float32(60000),
+						PartialTotalDurationMsec: proto.Int64(300000),
+					},
+					{
+						Name:            proto.String("NlpWakeLock"),
+						PartialTimeMsec: proto.Float32(300000),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc      string
+		summaries []parseutils.ActivitySummary
+		want      []Deviation
+	}{
+		{
+			desc: "Consistent totals",
+			summaries: []parseutils.ActivitySummary{
+				{
+					StartTimeMs:          1000,
+					ScreenOnSummary:      parseutils.Dist{TotalDuration: 10 * time.Minute},
+					MobileRadioOnSummary: parseutils.Dist{TotalDuration: 59 * time.Minute},
+				},
+			},
+		},
+		{
+			desc: "Truncated history",
+			summaries: []parseutils.ActivitySummary{
+				{
+					// Before the checkin data collection started, so ignored.
+					StartTimeMs:     0,
+					ScreenOnSummary: parseutils.Dist{TotalDuration: time.Hour},
+				},
+				{
+					StartTimeMs:          2000,
+					ScreenOnSummary:      parseutils.Dist{TotalDuration: 5 * time.Minute},
+					MobileRadioOnSummary: parseutils.Dist{TotalDuration: time.Hour},
+					WakeLockDetailedSummary: map[string]parseutils.Dist{
+						`"*alarm*"`:      {TotalDuration: 5 * time.Minute},
+						`"NlpWakeLock"`:  {TotalDuration: 2 * time.Minute},
+						`"NotInCheckin"`: {TotalDuration: time.Hour},
+					},
+				},
+			},
+			want: []Deviation{
+				{"Screen on", 5 * time.Minute, 10 * time.Minute},
+				{"Partial wakelock NlpWakeLock", 2 * time.Minute, 5 * time.Minute},
+			},
+		},
+		{
+			desc: "Mobile radio active apps",
+			summaries: []parseutils.ActivitySummary{
+				{
+					StartTimeMs:          1000,
+					ScreenOnSummary:      parseutils.Dist{TotalDuration: 10 * time.Minute},
+					MobileRadioOnSummary: parseutils.Dist{TotalDuration: time.Hour},
+					// The chat app woke up the radio for periods mostly used by other apps.
+					MobileRadioActiveAppSummary: map[string]parseutils.Dist{
+						`"com.example.chat"`: {TotalDuration: 30 * time.Minute},
+						`"UID 10031"`:        {TotalDuration: 2 * time.Minute},
+						`"NotInCheckin"`:     {TotalDuration: time.Minute},
+					},
+				},
+			},
+			want: []Deviation{
+				{"Mobile radio active app com.example.chat", 30 * time.Minute, 10 * time.Minute},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := Check(test.summaries, bs, DefaultThreshold); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Check(%v, ...) = %v, want %v", test.desc, test.summaries, got, test.want)
+		}
+	}
+}
+
+// TestCheckTruncation tests the detection of a battery history starting after the last charge.
+func TestCheckTruncation(t *testing.T) {
+	bs := &bspb.BatteryStats{
+		System: &bspb.BatteryStats_System{
+			Battery: &bspb.BatteryStats_System_Battery{
+				StartClockTimeMsec: proto.Int64(1000),
+			},
+		},
+	}
+	tests := []struct {
+		desc  string
+		input []string
+		bs    *bspb.BatteryStats
+		want  *Truncation
+	}{
+		{
+			desc: "History reaches back to the last charge",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,60000,3600000,100,`,
+				`Battery Level,int,3600000,7200000,99,`,
+			},
+			bs: bs,
+		},
+		{
+			desc: "Truncated history",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,10801000,14400000,80,`,
+				`Battery Level,int,14400000,18000000,79,`,
+			},
+			bs:   bs,
+			want: &Truncation{HistoryStartMs: 10801000, CheckinStartMs: 1000},
+		},
+		{
+			desc: "No checkin data",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,10801000,14400000,80,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		got, errs := CheckTruncation(input, test.bs)
+		if len(errs) > 0 {
+			t.Errorf("%v: CheckTruncation(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: CheckTruncation(%v)\n got: %v\n want: %v", test.desc, input, got, test.want)
+		}
+	}
+	if got, want := (Truncation{HistoryStartMs: 10801000, CheckinStartMs: 1000}).Missing(), 3*time.Hour; got != want {
+		t.Errorf("Truncation.Missing() = %v, want %v", got, want)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -28,6 +29,10 @@ const (
 	// FileHeader is outputted as the first line in csv files.
 	FileHeader = "metric,type,start_time,end_time,value,opt"
 
+	// LineIndexHeader is outputted as the first line in line index files. Each row identifies a csv entry
+	// by its metric, times and value, and gives the source lines its start and end were read from.
+	LineIndexHeader = "metric,start_time,end_time,value,start_line,end_line"
+
 	// UnknownWakeup is emitted for running events if no wake up reason is set for it.
 	UnknownWakeup = "Unknown wakeup reason"
 
@@ -51,6 +56,9 @@ type Entry struct {
 
 	// Unique identifier for the event. e.g. The name of the app that triggered the event.
 	Identifier string
+
+	// Line is the source line the entry was started on, set from the State. 0 if unknown.
+	Line int
 }
 
 // Functions expected by the EntryState interface.
@@ -90,6 +98,9 @@ func (s *State) StartEvent(e Entry) {
 
 // HasEvent returns whether an event for the metric with the given identifier is currently active.
 func (s *State) HasEvent(metric, eventIdentifier string) bool {
+	if s == nil {
+		return false
+	}
 	k := Key{
 		Metric:     metric,
 		Identifier: eventIdentifier,
@@ -116,6 +127,8 @@ func (s *State) EndEvent(metric, eventIdentifier string, curTime int64) {
 type RunningEvent struct {
 	e   Entry
 	end int64
+	// endLine is the source line the event ended on.
+	endLine int
 }
 
 type wakeupReason struct {
@@ -124,9 +137,12 @@ type wakeupReason struct {
 }
 
 // State holds the csv writer, and the map from metric key to active entry.
+// All methods can be called on a nil State, which discards all entries, for callers that only need summaries.
 type State struct {
 	// For printing the CSV entries.
 	writer *csv.Writer
+	// out is the writer the CSV entries are printed to, which merged shards are written to directly.
+	out io.Writer
 
 	entries map[Key]Entry
 
@@ -143,6 +159,33 @@ type State struct {
 	curWakeupReason *wakeupReason
 
 	rebootEvent *Entry
+
+	// index writes the source lines of each printed entry, if set with SetLineIndex.
+	index *csv.Writer
+	// indexOut is the writer of the line index, if set with SetLineIndex.
+	indexOut io.Writer
+
+	// line is the source line currently being processed, set with SetLine.
+	line int
+
+	// sink is sent each printed entry, if set with SetSink.
+	sink EventSink
+
+	// sinkErr is the first error returned by the sink. No more entries are sent to it after an error.
+	sinkErr error
+
+	// filter is the filter of the printed metrics, set with SetMetricFilter. All metrics are printed if nil.
+	filter *MetricFilter
+
+	// shard holds the output of a State returned by Shard until it's merged.
+	shard *shardOutput
+}
+
+// EventSink receives the events of the battery history as they are finalized during the analysis,
+// e.g. to stream them to an external store in addition to the generated CSV.
+type EventSink interface {
+	// Emit is called once for each event, with the event's metric, in the order the events are printed.
+	Emit(metric string, e Event) error
 }
 
 // Key is the unique identifier for an entry.
@@ -158,31 +201,83 @@ func NewState(csvWriter io.Writer, printHeader bool) *State {
 	}
 	return &State{
 		writer:  csv.NewWriter(csvWriter),
+		out:     csvWriter,
 		entries: make(map[Key]Entry),
 	}
 }
 
+// SetLineIndex makes the State also write the source lines of each printed entry to w, in the
+// LineIndexHeader format, so that the events can be traced back to the lines they were read from.
+// Only entries printed while a source line is set with SetLine are written.
+func (s *State) SetLineIndex(w io.Writer) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintln(w, LineIndexHeader)
+	s.index = csv.NewWriter(w)
+	s.indexOut = w
+}
+
+// SetSink makes the State also send each printed entry to the sink. If the sink returns an error, no
+// more entries are sent to it, and the error is returned by SinkErr.
+func (s *State) SetSink(sink EventSink) {
+	if s == nil {
+		return
+	}
+	s.sink = sink
+}
+
+// SinkErr returns the first error returned by the sink set with SetSink, or nil if there was none.
+func (s *State) SinkErr() error {
+	if s == nil {
+		return nil
+	}
+	return s.sinkErr
+}
+
+// SetLine sets the source line number, starting from 1, of the entries added or printed from now on.
+func (s *State) SetLine(n int) {
+	if s == nil {
+		return
+	}
+	s.line = n
+}
+
 // HasRebootEvent returns true if a reboot event is currently stored, false otherwise.
 func (s *State) HasRebootEvent() bool {
-	return (s.rebootEvent != nil)
+	return s != nil && s.rebootEvent != nil
 }
 
 // AddRebootEvent stores the entry for the reboot event,
 // using the given curTime as the start time.
 func (s *State) AddRebootEvent(curTime int64) {
+	s.AddRebootEventWithOpt(curTime, "")
+}
+
+// AddRebootEventWithOpt stores the entry for the reboot event, with the optional value set,
+// e.g. to the type of reboot, using the given curTime as the start time.
+func (s *State) AddRebootEventWithOpt(curTime int64, opt string) {
+	if s == nil {
+		return
+	}
 	s.rebootEvent = &Entry{
 		Desc:  Reboot,
 		Start: curTime,
 		Type:  "bool",
 		Value: "true",
+		Opt:   opt,
+		Line:  s.line,
 	}
 }
 
 // PrintRebootEvent prints out the stored reboot event,
 // using the given curTime as the end time.
 func (s *State) PrintRebootEvent(curTime int64) {
+	if s == nil {
+		return
+	}
 	if e := s.rebootEvent; e != nil {
-		s.Print(e.Desc, e.Type, e.Start, curTime, e.Value, e.Opt)
+		s.printEntry(*e, curTime, s.line)
 		s.rebootEvent = nil
 	}
 }
@@ -196,15 +291,18 @@ func (s *State) AddEntry(desc string, newState EntryState, curTime int64) {
 // AddEntryWithOpt adds the given entry into the existing map, with the optional value set.
 // If the entry already exists, it prints out the entry and deletes it.
 func (s *State) AddEntryWithOpt(desc string, newState EntryState, curTime int64, opt string) {
+	if s == nil {
+		return
+	}
 	key := newState.GetKey(desc)
 
 	if e, ok := s.entries[key]; ok {
 		if desc == CPURunning {
 			// Save the running event, rather than printing it out immediately.
 			// This is because wake up reasons can arrive after the running event ends.
-			s.assignRunningEvent(&RunningEvent{e, curTime})
+			s.assignRunningEvent(&RunningEvent{e, curTime, s.line})
 		} else {
-			s.Print(e.Desc, e.Type, e.Start, curTime, e.Value, e.Opt)
+			s.printEntry(e, curTime, s.line)
 		}
 		delete(s.entries, key)
 		return
@@ -223,12 +321,16 @@ func (s *State) AddEntryWithOpt(desc string, newState EntryState, curTime int64,
 		Type:  newState.GetType(),
 		Value: newState.GetValue(),
 		Opt:   opt,
+		Line:  s.line,
 	}
 }
 
 // AddOptToEntry adds the given optional value to an existing entry in the map.
 // No changes are made if the entry doesn't already exist.
 func (s *State) AddOptToEntry(desc string, state EntryState, opt string) {
+	if s == nil {
+		return
+	}
 	key := state.GetKey(desc)
 	if e, ok := s.entries[key]; ok {
 		e.Opt = opt
@@ -249,7 +351,20 @@ func stripQuotes(value string) string {
 
 // Print directly prints a csv entry to CSV format and writes it to the writer.
 func (s *State) Print(desc, metricType string, start, end int64, value, opt string) {
-	if s.writer == nil {
+	if s == nil {
+		return
+	}
+	s.print(desc, metricType, start, end, value, opt, s.line, s.line)
+}
+
+// printEntry prints a stored entry ending at the given time and source line.
+func (s *State) printEntry(e Entry, end int64, endLine int) {
+	s.print(e.Desc, e.Type, e.Start, end, e.Value, e.Opt, e.Line, endLine)
+}
+
+// print prints a csv entry, and its source lines to the line index if set.
+func (s *State) print(desc, metricType string, start, end int64, value, opt string, startLine, endLine int) {
+	if s.writer == nil || !s.filter.Allows(desc) {
 		return
 	}
 	// Strip first and last quote if present. The CSV library will escape any double quotes,
@@ -261,6 +376,13 @@ func (s *State) Print(desc, metricType string, start, end int64, value, opt stri
 	opt = stripQuotes(opt)
 	s.writer.Write([]string{desc, metricType, strconv.FormatInt(start, 10), strconv.FormatInt(end, 10), value, opt})
 	s.writer.Flush()
+	if s.index != nil && startLine > 0 {
+		s.index.Write([]string{desc, strconv.FormatInt(start, 10), strconv.FormatInt(end, 10), value, strconv.Itoa(startLine), strconv.Itoa(endLine)})
+		s.index.Flush()
+	}
+	if s.sink != nil && s.sinkErr == nil {
+		s.sinkErr = s.sink.Emit(desc, Event{Type: metricType, Start: start, End: end, Value: value, Opt: opt})
+	}
 }
 
 // PrintEvent writes an event extracted by ExtractEvents to the writer.
@@ -281,13 +403,16 @@ func (s *State) assignRunningEvent(newEvent *RunningEvent) {
 		e := s.runningEvent.e
 		e.Value = s.wakeupReasons(s.runningEvent.end)
 		s.wakeupReasonBuf.Reset()
-		s.Print(e.Desc, e.Type, e.Start, s.runningEvent.end, e.Value, e.Opt)
+		s.printEntry(e, s.runningEvent.end, s.runningEvent.endLine)
 	}
 	s.runningEvent = newEvent
 }
 
 // StartWakeupReason adds the wakeup reason to the wakeup reason buffer.
 func (s *State) StartWakeupReason(service string, curTime int64) {
+	if s == nil {
+		return
+	}
 	if s.curWakeupReason != nil {
 		s.appendWakeupReason(s.curWakeupReason, curTime)
 	}
@@ -300,6 +425,9 @@ func (s *State) StartWakeupReason(service string, curTime int64) {
 
 // EndWakeupReason adds the wakeup reason to the wakeup reason buffer.
 func (s *State) EndWakeupReason(service string, curTime int64) error {
+	if s == nil {
+		return nil
+	}
 	if s.curWakeupReason != nil {
 		if s.curWakeupReason.name != service {
 			return fmt.Errorf("tried to end a different wakeup reason (%q) than was started (%q)", service, s.curWakeupReason.name)
@@ -356,14 +484,56 @@ func (s *State) wakeupReasons(curTime int64) string {
 	return fmt.Sprintf(`"%s"`, s.wakeupReasonBuf.String())
 }
 
+// keyedEntry is an active entry with its key in the entries map.
+type keyedEntry struct {
+	key Key
+	e   Entry
+}
+
+// byStartMetricUID sorts entries in ascending order of start time, then metric, then UID, so that
+// entries ended at the same time are always printed in the same order.
+type byStartMetricUID []keyedEntry
+
+func (a byStartMetricUID) Len() int      { return len(a) }
+func (a byStartMetricUID) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStartMetricUID) Less(i, j int) bool {
+	x, y := a[i].e, a[j].e
+	switch {
+	case x.Start != y.Start:
+		return x.Start < y.Start
+	case x.Desc != y.Desc:
+		return x.Desc < y.Desc
+	case x.Opt != y.Opt:
+		return x.Opt < y.Opt
+	}
+	return a[i].key.Identifier < a[j].key.Identifier
+}
+
+// activeEntries returns the active entries for the given metric, or all active entries if the metric
+// is empty, in the order they're printed in.
+func (s *State) activeEntries(metric string) []keyedEntry {
+	var es []keyedEntry
+	for k, e := range s.entries {
+		if metric == "" || e.Desc == metric {
+			es = append(es, keyedEntry{k, e})
+		}
+	}
+	sort.Sort(byStartMetricUID(es))
+	return es
+}
+
 // PrintAllReset prints all active entries and resets the map.
 func (s *State) PrintAllReset(curTime int64) {
-	for _, e := range s.entries {
+	if s == nil {
+		return
+	}
+	for _, ke := range s.activeEntries("") {
+		e := ke.e
 		if e.Desc == CPURunning {
 			e.Value = s.wakeupReasons(curTime)
 			s.wakeupReasonBuf.Reset()
 		}
-		s.Print(e.Desc, e.Type, e.Start, curTime, e.Value, e.Opt)
+		s.printEntry(e, curTime, s.line)
 	}
 	s.assignRunningEvent(nil)
 	s.entries = make(map[Key]Entry)
@@ -372,11 +542,12 @@ func (s *State) PrintAllReset(curTime int64) {
 // PrintActiveEvent prints out all active entries for the given metric name with the given end time,
 // and deletes those entries from the map.
 func (s *State) PrintActiveEvent(metric string, endMs int64) {
-	for k, e := range s.entries {
-		if e.Desc == metric {
-			s.Print(e.Desc, e.Type, e.Start, endMs, e.Value, e.Opt)
-			delete(s.entries, k)
-		}
+	if s == nil {
+		return
+	}
+	for _, ke := range s.activeEntries(metric) {
+		s.printEntry(ke.e, endMs, s.line)
+		delete(s.entries, ke.key)
 	}
 }
 
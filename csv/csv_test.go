@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestPrintActiveOrder tests that active entries ended together are printed in order of start time,
+// then metric, then UID, then identifier.
+func TestPrintActiveOrder(t *testing.T) {
+	entries := []Entry{
+		{Desc: "Wakelock", Start: 2000, Type: "service", Value: "b", Opt: "10001", Identifier: "b"},
+		{Desc: "Wakelock", Start: 1000, Type: "service", Value: "c", Opt: "10002", Identifier: "c"},
+		{Desc: "Job", Start: 2000, Type: "service", Value: "d", Opt: "10003", Identifier: "d"},
+		{Desc: "Wakelock", Start: 2000, Type: "service", Value: "a", Opt: "10000", Identifier: "a"},
+		{Desc: "Wakelock", Start: 2000, Type: "service", Value: "e", Opt: "10000", Identifier: "e"},
+		{Desc: "Sync", Start: 500, Type: "service", Value: "f", Opt: "10004", Identifier: "f"},
+	}
+	tests := []struct {
+		desc   string
+		metric string
+		want   []string
+	}{
+		{
+			desc: "All entries",
+			want: []string{
+				"Sync,service,500,3000,f,10004",
+				"Wakelock,service,1000,3000,c,10002",
+				"Job,service,2000,3000,d,10003",
+				"Wakelock,service,2000,3000,a,10000",
+				"Wakelock,service,2000,3000,e,10000",
+				"Wakelock,service,2000,3000,b,10001",
+			},
+		},
+		{
+			desc:   "Single metric",
+			metric: "Wakelock",
+			want: []string{
+				"Wakelock,service,1000,3000,c,10002",
+				"Wakelock,service,2000,3000,a,10000",
+				"Wakelock,service,2000,3000,e,10000",
+				"Wakelock,service,2000,3000,b,10001",
+			},
+		},
+	}
+	for _, test := range tests {
+		// Run several times, as map iteration order is random.
+		for i := 0; i < 10; i++ {
+			var b bytes.Buffer
+			s := NewState(&b, false)
+			for _, e := range entries {
+				s.StartEvent(e)
+			}
+			if test.metric == "" {
+				s.PrintAllReset(3000)
+			} else {
+				s.PrintActiveEvent(test.metric, 3000)
+			}
+			if got, want := strings.TrimSpace(b.String()), strings.Join(test.want, "\n"); got != want {
+				t.Errorf("%s: printed entries:\n  got: %s\n  want: %s", test.desc, got, want)
+				break
+			}
+		}
+	}
+}
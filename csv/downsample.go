@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+// downsample.go pre-aggregates the dense numeric series of the CSV generated by csv.go, such as the
+// power monitor readings, to a coarser time resolution, so that zoomed out views don't need to
+// render every sample.
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Resolutions are the time resolutions of the pre-aggregated CSVs generated alongside the full resolution CSV.
+var Resolutions = []time.Duration{time.Minute, 10 * time.Minute}
+
+// isNumeric returns whether events of the metric type are plotted as a numeric series.
+func isNumeric(metricType string) bool {
+	return metricType == "int" || metricType == "float"
+}
+
+// bucketAverage accumulates the time weighted average of a numeric series within a time bucket.
+type bucketAverage struct {
+	sum, weight float64
+	end         int64
+}
+
+// downsampleNumeric returns an event per bucket of the given resolution, with the value being the
+// time weighted average of the events in the bucket. Instant events are given a weight of 1ms.
+func downsampleNumeric(events []Event, resMs int64) ([]Event, []error) {
+	var errs []error
+	buckets := make(map[int64]*bucketAverage)
+	var starts []int64
+	add := func(bucket, end int64, v float64, weight int64) {
+		b, ok := buckets[bucket]
+		if !ok {
+			b = &bucketAverage{}
+			buckets[bucket] = b
+			starts = append(starts, bucket)
+		}
+		b.sum += v * float64(weight)
+		b.weight += float64(weight)
+		if end > b.end {
+			b.end = end
+		}
+	}
+	for _, e := range events {
+		v, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if e.End <= e.Start {
+			add(e.Start-e.Start%resMs, e.Start, v, 1)
+			continue
+		}
+		for b := e.Start - e.Start%resMs; b < e.End; b += resMs {
+			s, en := b, b+resMs
+			if s < e.Start {
+				s = e.Start
+			}
+			if en > e.End {
+				en = e.End
+			}
+			add(b, en, v, en-s)
+		}
+	}
+	sort.Sort(int64Slice(starts))
+	var res []Event
+	for _, s := range starts {
+		b := buckets[s]
+		avg := b.sum / b.weight
+		t := "float"
+		v := strconv.FormatFloat(avg, 'f', 3, 64)
+		if events[0].Type == "int" {
+			t = "int"
+			v = strconv.FormatInt(int64(math.Floor(avg+0.5)), 10)
+		}
+		res = append(res, Event{Type: t, Start: s, End: b.end, Value: v})
+	}
+	return res, errs
+}
+
+// Downsample returns the CSV with the numeric events of the given dense metrics, or of all metrics
+// if none are given, averaged over consecutive buckets of the resolution. Only the aggregated
+// events are output, in alphabetical order of metric, so the frontend can swap them in for the full
+// resolution series when zoomed out. Events of other types, and events with unknown (negative)
+// times, are dropped as they're not averaged.
+func Downsample(csvInput string, resolution time.Duration, metrics []string) (string, []error) {
+	es, errs := ExtractEvents(csvInput, metrics)
+	resMs := int64(resolution / time.Millisecond)
+	if resMs <= 0 {
+		return "", append(errs, fmt.Errorf("invalid resolution %v", resolution))
+	}
+	var names []string
+	for m := range es {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	s := NewState(&b, true)
+	for _, m := range names {
+		var numeric []Event
+		for _, e := range es[m] {
+			if e.Start >= 0 && e.End >= 0 && isNumeric(e.Type) {
+				numeric = append(numeric, e)
+			}
+		}
+		if len(numeric) == 0 {
+			continue
+		}
+		agg, nErrs := downsampleNumeric(numeric, resMs)
+		errs = append(errs, nErrs...)
+		for _, e := range agg {
+			s.PrintEvent(m, e)
+		}
+	}
+	return b.String(), errs
+}
+
+// int64Slice sorts int64s in ascending order.
+type int64Slice []int64
+
+func (a int64Slice) Len() int           { return len(a) }
+func (a int64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64Slice) Less(i, j int) bool { return a[i] < a[j] }
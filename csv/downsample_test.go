@@ -0,0 +1,78 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDownsample tests the aggregation of events to a coarser time resolution.
+func TestDownsample(t *testing.T) {
+	tests := []struct {
+		desc    string
+		metrics []string
+		input   []string
+		want    []string
+	}{
+		{
+			desc: "Numeric series averaged per bucket",
+			input: []string{
+				FileHeader,
+				`Brightness,int,0,30000,1,`,
+				`Brightness,int,30000,90000,4,`,
+				`Brightness,int,90000,90000,2,`,
+				`Power Monitor (mA),float,0,60000,10.5,`,
+				`Power Monitor (mA),float,60000,120000,20,`,
+			},
+			want: []string{
+				FileHeader,
+				// Bucket 0: 30s at 1 and 30s at 4. Bucket 1: 30s at 4 and an instant event at 2.
+				`Brightness,int,0,60000,3,`,
+				`Brightness,int,60000,90000,4,`,
+				`Power Monitor (mA),float,0,60000,10.500,`,
+				`Power Monitor (mA),float,60000,120000,20.000,`,
+			},
+		},
+		{
+			desc:    "Only the dense numeric series aggregated",
+			metrics: []string{"Power Monitor (mA)", "CPU running"},
+			input: []string{
+				FileHeader,
+				`Brightness,int,0,30000,1,`,
+				`CPU running,string,0,1000,,`,
+				`Power Monitor (mA),float,0,30000,10,`,
+				`Power Monitor (mA),float,30000,60000,20,`,
+				`Power Monitor (mA),float,-1,3000,30,`,
+			},
+			want: []string{
+				FileHeader,
+				`Power Monitor (mA),float,0,60000,15.000,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		got, errs := Downsample(input, time.Minute, test.metrics)
+		if len(errs) > 0 {
+			t.Errorf("%v: Downsample(%v, %v) generated unexpected errors: %v", test.desc, input, test.metrics, errs)
+		}
+		got = strings.TrimSpace(got)
+		if want := strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%v: Downsample(%v, %v)\n got: %v\n want: %v", test.desc, input, test.metrics, got, want)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package csv
 // events.go processes the CSV generated by csv.go, and creates a map from metric to events.
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"sort"
@@ -36,6 +37,12 @@ func (a sortByStartTime) Less(i, j int) bool {
 	return a[i].Start < a[j].Start
 }
 
+// SortByStart sorts the events in ascending order of start time. Events starting at the same time keep
+// their order.
+func SortByStart(events []Event) {
+	sort.Stable(sortByStartTime(events))
+}
+
 // Event stores the details contained in a CSV line.
 type Event struct {
 	Type       string
@@ -82,6 +89,43 @@ func ExtractEvents(csvInput string, metrics []string) (map[string][]Event, []err
 	return events, errs
 }
 
+// MapEvents returns the CSV with each event replaced by the result of f on it, and dropped if f
+// returns false, e.g. to annotate or filter the events of a metric. The header is kept. Rows that
+// can't be parsed are dropped, with an error for each.
+func MapEvents(csvInput string, f func(metric string, e Event) (Event, bool)) (string, []error) {
+	return mapEvents(csvInput, nil, f)
+}
+
+// mapEvents is the same as MapEvents, but the events are printed with the given metric filter.
+func mapEvents(csvInput string, filter *MetricFilter, f func(metric string, e Event) (Event, bool)) (string, []error) {
+	records := checkinutil.ParseCSV(csvInput)
+	if records == nil {
+		return "", []error{errors.New("nil result generated by ParseCSV")}
+	}
+	var b bytes.Buffer
+	s := NewState(&b, false)
+	s.SetMetricFilter(filter)
+	var errs []error
+	for i, parts := range records {
+		if len(parts) == 0 {
+			continue
+		}
+		if strings.Join(parts, ",") == FileHeader {
+			fmt.Fprintln(&b, FileHeader)
+			continue
+		}
+		e, err := eventFromRecord(parts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("record %v: %v", i, err))
+			continue
+		}
+		if e, ok := f(parts[0], e); ok {
+			s.PrintEvent(parts[0], e)
+		}
+	}
+	return b.String(), errs
+}
+
 // eventFromRecord parses the parts and either returns an event if in the correct format, else an error.
 // Parts expected are desc,metricType,start,end,value,opt.
 func eventFromRecord(parts []string) (Event, error) {
@@ -105,7 +149,8 @@ func eventFromRecord(parts []string) (Event, error) {
 	}, nil
 }
 
-// MergeEvents merges all overlapping events.
+// MergeEvents merges all overlapping events. Events that touch, where one ends when the next one
+// starts, are merged too, as their intervals together cover a single interval.
 func MergeEvents(events []Event) []Event {
 	if len(events) == 0 {
 		return nil
@@ -0,0 +1,113 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+// filter.go restricts the metrics a State prints, so that deployments with privacy requirements can
+// drop the series carrying service or package names, while keeping aggregate series such as the screen.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricFilter is an allow and deny list of CSV metrics. A nil filter allows all metrics.
+type MetricFilter struct {
+	// allow is the set of allowed metrics. All metrics not denied are allowed if empty.
+	allow map[string]bool
+	// deny is the set of denied metrics. It takes precedence over allow.
+	deny map[string]bool
+}
+
+// NewMetricFilter returns a filter only allowing the metrics in allow, or all metrics if allow is empty,
+// except the metrics in deny. It returns nil if both lists are empty.
+func NewMetricFilter(allow, deny []string) *MetricFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	f := &MetricFilter{allow: make(map[string]bool), deny: make(map[string]bool)}
+	for _, m := range allow {
+		f.allow[m] = true
+	}
+	for _, m := range deny {
+		f.deny[m] = true
+	}
+	return f
+}
+
+// SplitMetrics splits a comma separated list of metrics, e.g. "Screen,Partial wakelock".
+// Surrounding spaces and empty names are dropped.
+func SplitMetrics(list string) []string {
+	var metrics []string
+	for _, m := range strings.Split(list, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Allows returns whether the metric is printed by States with the filter.
+func (f *MetricFilter) Allows(metric string) bool {
+	if f == nil {
+		return true
+	}
+	if f.deny[metric] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[metric]
+}
+
+// Key returns a short identifier of the filter, the same for filters of the same lists, or "" for a nil filter.
+// e.g. for keys of cached analyses generated with the filter.
+func (f *MetricFilter) Key() string {
+	if f == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, set := range []map[string]bool{f.allow, f.deny} {
+		var metrics []string
+		for m := range set {
+			metrics = append(metrics, m)
+		}
+		sort.Strings(metrics)
+		io.WriteString(h, strconv.Quote(strings.Join(metrics, "\n")))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// SetMetricFilter sets the filter of the metrics printed by the State. States print all metrics by default.
+// Entries of filtered out metrics are still tracked, but aren't written, indexed or sent to the sink.
+func (s *State) SetMetricFilter(f *MetricFilter) {
+	if s == nil {
+		return
+	}
+	s.filter = f
+}
+
+// FilterMetrics returns the CSV without the rows of the metrics not allowed by the filter. The header,
+// if any, is kept, and invalid rows are dropped. It is for CSVs that other analyses were derived from
+// before they're returned, so that the derived analyses don't miss the filtered out metrics.
+func FilterMetrics(csvInput string, f *MetricFilter) (string, []error) {
+	if f == nil {
+		return csvInput, nil
+	}
+	return mapEvents(csvInput, f, func(metric string, e Event) (Event, bool) {
+		return e, true
+	})
+}
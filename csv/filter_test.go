@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestMetricFilter tests the metrics printed by States with allow and deny lists.
+func TestMetricFilter(t *testing.T) {
+	tests := []struct {
+		desc        string
+		allow, deny string
+		want        []string
+	}{
+		{
+			desc: "No filter",
+			want: []string{
+				`Screen,bool,1000,2000,true,`,
+				`Partial wakelock,service,1000,3000,com.google.android.gms,10010`,
+				`Plugged,bool,2000,5000,true,`,
+			},
+		},
+		{
+			desc: "Deny list",
+			deny: " Partial wakelock ,SyncManager",
+			want: []string{
+				`Screen,bool,1000,2000,true,`,
+				`Plugged,bool,2000,5000,true,`,
+			},
+		},
+		{
+			desc:  "Deny list takes precedence",
+			allow: "Screen,Partial wakelock",
+			deny:  "Partial wakelock",
+			want: []string{
+				`Screen,bool,1000,2000,true,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		s := NewState(&b, false)
+		s.SetMetricFilter(NewMetricFilter(SplitMetrics(test.allow), SplitMetrics(test.deny)))
+		s.Print("Screen", "bool", 1000, 2000, "true", "")
+		s.Print("Partial wakelock", "service", 1000, 3000, `"com.google.android.gms"`, "10010")
+		s.Print("Plugged", "bool", 2000, 5000, "true", "")
+		if got, want := strings.TrimSpace(b.String()), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%v: printed\n got: %v\n want: %v", test.desc, got, want)
+		}
+	}
+}
+
+// TestFilterMetrics tests the filtering of already generated CSVs.
+func TestFilterMetrics(t *testing.T) {
+	input := strings.Join([]string{
+		FileHeader,
+		`Screen,bool,1000,2000,true,`,
+		`Partial wakelock,service,1000,3000,"1000,com.google.android.gms",10010`,
+		`Overflow line in 9,h,1000:*OVERFLOW*`,
+		`Plugged,bool,2000,5000,true,`,
+	}, "\n")
+	got, errs := FilterMetrics(input, NewMetricFilter(nil, []string{"Partial wakelock"}))
+	if len(errs) != 1 {
+		t.Errorf("FilterMetrics(%v) generated errors %v, want 1 for the invalid row", input, errs)
+	}
+	want := strings.Join([]string{
+		FileHeader,
+		`Screen,bool,1000,2000,true,`,
+		`Plugged,bool,2000,5000,true,`,
+	}, "\n")
+	if got = strings.TrimSpace(got); got != want {
+		t.Errorf("FilterMetrics(%v)\n got: %v\n want: %v", input, got, want)
+	}
+	if got, _ := FilterMetrics(input, nil); got != input {
+		t.Errorf("FilterMetrics(%v, nil) = %v, want the input unchanged", input, got)
+	}
+}
+
+// TestMetricFilterKey tests that filters of the same lists have the same key.
+func TestMetricFilterKey(t *testing.T) {
+	a := NewMetricFilter([]string{"Screen", "Plugged"}, nil)
+	b := NewMetricFilter([]string{"Plugged", "Screen"}, nil)
+	c := NewMetricFilter(nil, []string{"Screen", "Plugged"})
+	if a.Key() != b.Key() {
+		t.Errorf("Key() = %q and %q for the same allow list in a different order, want the same keys", a.Key(), b.Key())
+	}
+	if a.Key() == c.Key() {
+		t.Errorf("Key() = %q for both the allow and deny lists, want different keys", a.Key())
+	}
+	var nilFilter *MetricFilter
+	if k := nilFilter.Key(); k != "" {
+		t.Errorf("Key() = %q for a nil filter, want empty", k)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+// interval.go defines the time intervals covered by the events, with the boundary semantics used by
+// all the metrics.
+
+import (
+	"sort"
+)
+
+// Interval is the half-open time range [Start, End) covered by an event, in unix ms.
+//
+// The start is included and the end is excluded. When State ends the active events at a RESET, START
+// or SHUTDOWN, or at the end of the history, it uses the time the next events start at, so an event
+// ending at a boundary and the one starting there don't overlap, and their durations add up to the
+// time between them.
+//
+// An instant event, e.g. an app install, is the empty interval [t, t). It has no duration, and is
+// attributed to whichever interval contains t, so an instant event logged at a boundary belongs to the
+// interval starting there.
+type Interval struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Interval returns the time interval covered by the event.
+func (e Event) Interval() Interval {
+	return Interval{Start: e.Start, End: e.End}
+}
+
+// Empty returns whether the interval contains no time, as for instant events.
+func (i Interval) Empty() bool {
+	return i.End <= i.Start
+}
+
+// Duration returns the length of the interval in ms, 0 if it's empty.
+func (i Interval) Duration() int64 {
+	if i.Empty() {
+		return 0
+	}
+	return i.End - i.Start
+}
+
+// Contains returns whether the time ms is in the interval, i.e. Start <= ms < End.
+func (i Interval) Contains(ms int64) bool {
+	return ms >= i.Start && ms < i.End
+}
+
+// Intersect returns the intersection of the two intervals, which is empty if they don't overlap.
+// Intervals that only touch, where one ends when the other starts, don't overlap.
+func (i Interval) Intersect(o Interval) Interval {
+	if o.Start > i.Start {
+		i.Start = o.Start
+	}
+	if o.End < i.End {
+		i.End = o.End
+	}
+	if i.Empty() {
+		return Interval{Start: i.Start, End: i.Start}
+	}
+	return i
+}
+
+// Overlaps returns whether the two intervals share any time.
+func (i Interval) Overlaps(o Interval) bool {
+	return !i.Intersect(o).Empty()
+}
+
+// Overlap returns the total duration the events overlap with the interval [startMs, endMs). The
+// events must not overlap each other, so no time is counted twice, but don't need to be sorted.
+func Overlap(events []Event, startMs, endMs int64) int64 {
+	in := Interval{Start: startMs, End: endMs}
+	var d int64
+	for _, e := range events {
+		d += in.Intersect(e.Interval()).Duration()
+	}
+	return d
+}
+
+// byStart sorts intervals in ascending order of start time, then end time.
+type byStart []Interval
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].Start != a[j].Start {
+		return a[i].Start < a[j].Start
+	}
+	return a[i].End < a[j].End
+}
+
+// ExtractIntervals returns the intervals of the events of each of the given metrics, sorted by start
+// time. Each event has its own interval, so overlapping events, e.g. of different apps, are kept apart,
+// and instant events are kept as empty intervals. If the metrics slice is nil, the intervals of all
+// metrics are returned.
+func ExtractIntervals(csvInput string, metrics []string) (map[string][]Interval, []error) {
+	es, errs := ExtractEvents(csvInput, metrics)
+	res := make(map[string][]Interval, len(es))
+	for m, events := range es {
+		if len(events) == 0 {
+			continue
+		}
+		is := make([]Interval, 0, len(events))
+		for _, e := range events {
+			is = append(is, e.Interval())
+		}
+		sort.Stable(byStart(is))
+		res[m] = is
+	}
+	return res, errs
+}
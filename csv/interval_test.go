@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestIntervalBoundaries tests that the intervals include their start and exclude their end.
+func TestIntervalBoundaries(t *testing.T) {
+	in := Interval{Start: 1000, End: 2000}
+	tests := []struct {
+		desc         string
+		other        Interval
+		wantOverlaps bool
+		wantDuration int64
+	}{
+		{
+			desc:  "Ends at the start",
+			other: Interval{Start: 500, End: 1000},
+		},
+		{
+			desc:  "Starts at the end",
+			other: Interval{Start: 2000, End: 3000},
+		},
+		{
+			desc:  "Instant event at the start",
+			other: Interval{Start: 1000, End: 1000},
+		},
+		{
+			desc:         "Partial overlap",
+			other:        Interval{Start: 1500, End: 2500},
+			wantOverlaps: true,
+			wantDuration: 500,
+		},
+		{
+			desc:         "Contains the interval",
+			other:        Interval{Start: 0, End: 3000},
+			wantOverlaps: true,
+			wantDuration: 1000,
+		},
+	}
+	for _, test := range tests {
+		if got := in.Overlaps(test.other); got != test.wantOverlaps {
+			t.Errorf("%v: %v.Overlaps(%v) = %t, want %t", test.desc, in, test.other, got, test.wantOverlaps)
+		}
+		if got := in.Intersect(test.other).Duration(); got != test.wantDuration {
+			t.Errorf("%v: %v.Intersect(%v).Duration() = %d, want %d", test.desc, in, test.other, got, test.wantDuration)
+		}
+	}
+	for ms, want := range map[int64]bool{999: false, 1000: true, 1999: true, 2000: false} {
+		if got := in.Contains(ms); got != want {
+			t.Errorf("%v.Contains(%d) = %t, want %t", in, ms, got, want)
+		}
+	}
+}
+
+// TestOverlap tests the total duration of the events within an interval.
+func TestOverlap(t *testing.T) {
+	events := []Event{
+		{Start: 2500, End: 4000},
+		{Start: 0, End: 1000},
+		{Start: 1500, End: 1500},
+		{Start: 1800, End: 2200},
+	}
+	tests := []struct {
+		start, end int64
+		want       int64
+	}{
+		{1000, 2000, 200},
+		{0, 4000, 2900},
+		{1000, 1800, 0},
+		{3000, 5000, 1000},
+	}
+	for _, test := range tests {
+		if got := Overlap(events, test.start, test.end); got != test.want {
+			t.Errorf("Overlap(%v, %d, %d) = %d, want %d", events, test.start, test.end, got, test.want)
+		}
+	}
+}
+
+// TestExtractIntervals tests the extracting of the intervals of each metric from the CSV output.
+func TestExtractIntervals(t *testing.T) {
+	input := strings.Join([]string{
+		FileHeader,
+		`Partial wakelock,service,1422620456417,1422620458417,"*alarm*",1000`,
+		`Partial wakelock,service,1422620452417,1422620457417,"*job*/com.google.android.gms",10015`,
+		"Reboot,bool,1422620454417,1430000000000,true,",
+		`Package install,service,1430000000000,1430000000000,"com.google.android.gm",`,
+	}, "\n")
+	got, errs := ExtractIntervals(input, []string{"Partial wakelock", "Package install", "Screen"})
+	if len(errs) > 0 {
+		t.Fatalf("ExtractIntervals generated unexpected errors: %v", errs)
+	}
+	want := map[string][]Interval{
+		"Partial wakelock": {
+			{Start: 1422620452417, End: 1422620457417},
+			{Start: 1422620456417, End: 1422620458417},
+		},
+		"Package install": {{Start: 1430000000000, End: 1430000000000}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractIntervals()\n got: %v\n want: %v", got, want)
+	}
+}
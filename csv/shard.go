@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+// shard.go lets several goroutines print entries in parallel. A State isn't safe for concurrent use,
+// and locking it wouldn't help as the order of the printed entries would then depend on scheduling,
+// so each goroutine prints to its own shard, and the shards are merged back in a fixed order.
+
+import (
+	"bytes"
+	"encoding/csv"
+)
+
+// shardOutput holds everything printed to a shard until it's merged.
+type shardOutput struct {
+	csv   bytes.Buffer
+	index bytes.Buffer
+	// events are the printed entries to send to the sink of the State the shard is merged into.
+	events []shardEvent
+}
+
+// shardEvent is an event printed to a shard, with its metric.
+type shardEvent struct {
+	metric string
+	e      Event
+}
+
+// Emit records the event to send it on merge.
+func (o *shardOutput) Emit(metric string, e Event) error {
+	o.events = append(o.events, shardEvent{metric, e})
+	return nil
+}
+
+// Shard returns a new State with the same metric filter as s, that also writes the line index and
+// sends the entries to a sink if s does, but holds all its output until it's merged into s with Merge.
+// Each shard is independent of s and of the other shards, so they can be used by different goroutines,
+// but a single shard isn't safe for concurrent use. Sharding a nil State returns a nil State.
+func (s *State) Shard() *State {
+	if s == nil {
+		return nil
+	}
+	o := &shardOutput{}
+	sh := &State{
+		writer:  csv.NewWriter(&o.csv),
+		out:     &o.csv,
+		entries: make(map[Key]Entry),
+		filter:  s.filter,
+		shard:   o,
+	}
+	if s.index != nil {
+		sh.index = csv.NewWriter(&o.index)
+		sh.indexOut = &o.index
+	}
+	if s.sink != nil {
+		sh.sink = o
+	}
+	return sh
+}
+
+// Merge writes out the entries printed to the shards so far, one shard after the other in the given
+// order, so that the output doesn't depend on the order the goroutines using them finished in. The
+// merged output is cleared from the shards, which can keep being used. Entries still active in the
+// shards aren't merged, so they should be printed with PrintAllReset beforehand. Merge must not be
+// called while the shards or s are in use.
+func (s *State) Merge(shards ...*State) {
+	if s == nil {
+		return
+	}
+	for _, sh := range shards {
+		if sh == nil || sh.shard == nil {
+			continue
+		}
+		o := sh.shard
+		if s.out != nil {
+			s.out.Write(o.csv.Bytes())
+		}
+		if s.indexOut != nil {
+			s.indexOut.Write(o.index.Bytes())
+		}
+		for _, ev := range o.events {
+			if s.sink != nil && s.sinkErr == nil {
+				s.sinkErr = s.sink.Emit(ev.metric, ev.e)
+			}
+		}
+		o.csv.Reset()
+		o.index.Reset()
+		o.events = nil
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingSink records the metrics of the events it's sent.
+type recordingSink struct {
+	metrics []string
+}
+
+func (r *recordingSink) Emit(metric string, e Event) error {
+	r.metrics = append(r.metrics, metric)
+	return nil
+}
+
+// TestShardMerge tests that the entries printed to shards in parallel are merged in shard order.
+func TestShardMerge(t *testing.T) {
+	var b, index bytes.Buffer
+	s := NewState(&b, true)
+	s.SetLineIndex(&index)
+	sink := &recordingSink{}
+	s.SetSink(sink)
+	s.Print("First", "bool", 0, 10, "true", "")
+
+	shards := []*State{s.Shard(), s.Shard(), s.Shard()}
+	var wg sync.WaitGroup
+	for i, sh := range shards {
+		wg.Add(1)
+		go func(i int, sh *State) {
+			defer wg.Done()
+			sh.SetLine(i + 1)
+			metric := fmt.Sprintf("Shard %d", i)
+			sh.StartEvent(Entry{Desc: metric, Start: 100, Type: "service", Value: "a", Identifier: "a"})
+			sh.Print(metric, "bool", 200, 300, "true", "")
+			sh.PrintAllReset(1000)
+		}(i, sh)
+	}
+	wg.Wait()
+	s.Merge(shards...)
+	// The shards were cleared by the merge.
+	s.Merge(shards...)
+
+	wantCSV := strings.Join([]string{
+		FileHeader,
+		"First,bool,0,10,true,",
+		"Shard 0,bool,200,300,true,",
+		"Shard 0,service,100,1000,a,",
+		"Shard 1,bool,200,300,true,",
+		"Shard 1,service,100,1000,a,",
+		"Shard 2,bool,200,300,true,",
+		"Shard 2,service,100,1000,a,",
+	}, "\n")
+	if got := strings.TrimSpace(b.String()); got != wantCSV {
+		t.Errorf("Merged CSV:\n  got: %s\n  want: %s", got, wantCSV)
+	}
+	wantIndex := strings.Join([]string{
+		LineIndexHeader,
+		"Shard 0,200,300,true,1,1",
+		"Shard 0,100,1000,a,1,1",
+		"Shard 1,200,300,true,2,2",
+		"Shard 1,100,1000,a,2,2",
+		"Shard 2,200,300,true,3,3",
+		"Shard 2,100,1000,a,3,3",
+	}, "\n")
+	if got := strings.TrimSpace(index.String()); got != wantIndex {
+		t.Errorf("Merged line index:\n  got: %s\n  want: %s", got, wantIndex)
+	}
+	wantMetrics := "First,Shard 0,Shard 0,Shard 1,Shard 1,Shard 2,Shard 2"
+	if got := strings.Join(sink.metrics, ","); got != wantMetrics {
+		t.Errorf("Metrics sent to sink = %q, want %q", got, wantMetrics)
+	}
+}
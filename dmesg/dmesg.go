@@ -17,6 +17,7 @@ package dmesg
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -39,8 +40,13 @@ var (
 	timeRE = regexp.MustCompile(`PM: suspend ` + `(?P<transition>(exit|entry))` + `\s+` + `(?P<timeStamp>[\d-\s:]+)` + `[.]` + `(?P<remainder>\d+)` + `\s+UTC`)
 )
 
-// section is the expected section heading for the kernel dmesg log.
-const section = "KERNEL LOG (dmesg)"
+const (
+	// section is the expected section heading for the kernel dmesg log.
+	section = "KERNEL LOG (dmesg)"
+
+	// cancelCheckLines is the number of lines parsed between checks for cancellation.
+	cancelCheckLines = 1000
+)
 
 // Data stores the CSV and first seen event start time parsed from the kernel dmesg log.
 type Data struct {
@@ -83,6 +89,12 @@ type timeMapping struct {
 
 // Parse writes a CSV entry for each line matching activity manager proc start and died, ANR and low memory events.
 func Parse(f string) Data {
+	return ParseContext(context.Background(), f)
+}
+
+// ParseContext is the same as Parse, but stops parsing once the context is done. The data of a
+// stopped parse only has the errors, ending with the context error.
+func ParseContext(ctx context.Context, f string) Data {
 	var inSection, inSuspend bool
 	// Track the first seen time in the log, and most recent bootMs-unixMs mapping.
 	// We need to use the most recent suspend entry mapping as the "since boot" times
@@ -94,7 +106,12 @@ func Parse(f string) Data {
 
 	var pending []csv.Entry
 	var errs []error
-	for _, line := range strings.Split(f, "\n") {
+	for i, line := range strings.Split(f, "\n") {
+		if i%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return Data{Errs: append(errs, err)}
+			}
+		}
 		if m, result := historianutils.SubexpNames(bugreportutils.BugReportSectionRE, line); m {
 			if strings.TrimSpace(result["section"]) == section {
 				inSection = true
@@ -15,6 +15,7 @@
 package dmesg
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"strings"
@@ -121,3 +122,18 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+// TestParseContext tests that parsing stops once the context is done.
+func TestParseContext(t *testing.T) {
+	input := strings.Join([]string{
+		`<6>[24448.456280] PM: suspend exit 2015-08-28 01:32:45.111006517 UTC`,
+		`<6>[24450.470350] lowmemorykiller: Killing 'facebook.katana' (20003), adj 1000,`,
+	}, "\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got := ParseContext(ctx, input)
+	want := Data{Errs: []error{context.Canceled}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseContext(canceled, %v)\n got: %v\n\n want: %v", input, got, want)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventsink contains implementations of csv.EventSink, for streaming the battery history
+// events out of the analysis as they are finalized, e.g. into a data warehouse.
+package eventsink
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// Record is the JSON encoding of a single event sent to a sink.
+type Record struct {
+	Metric    string `json:"metric"`
+	Type      string `json:"type"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	Value     string `json:"value"`
+	Opt       string `json:"opt,omitempty"`
+	// Source identifies the analyzed report, e.g. the bug report file name. Omitted if empty.
+	Source string `json:"source,omitempty"`
+}
+
+// newRecord returns the record for the event of the given metric.
+func newRecord(source, metric string, e csv.Event) Record {
+	return Record{
+		Metric:    metric,
+		Type:      e.Type,
+		StartTime: e.Start,
+		EndTime:   e.End,
+		Value:     e.Value,
+		Opt:       e.Opt,
+		Source:    source,
+	}
+}
+
+// NDJSON writes each event as a JSON Record on its own line, the newline delimited JSON format
+// accepted by most data warehouse bulk loaders. It's safe for concurrent use, so a single file can
+// collect the events of several analyses.
+type NDJSON struct {
+	// Source is set in every written record.
+	Source string
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewNDJSON returns an NDJSON sink writing to w. The caller is responsible for closing w.
+func NewNDJSON(w io.Writer, source string) *NDJSON {
+	return &NDJSON{Source: source, enc: json.NewEncoder(w)}
+}
+
+// Emit writes the event to the underlying writer.
+func (n *NDJSON) Emit(metric string, e csv.Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.enc.Encode(newRecord(n.Source, metric, e))
+}
+
+// Publisher sends a message to a topic of a message bus, such as Kafka or Cloud Pub/Sub.
+// Implementations wrap the client library of the bus, which this package doesn't depend on.
+type Publisher interface {
+	Publish(topic string, msg []byte) error
+}
+
+// ErrNoPublisher is returned by a Bus without a Publisher.
+var ErrNoPublisher = errors.New("eventsink: no message bus publisher configured")
+
+// Bus publishes each event as a JSON Record message to a message bus topic. It's a stub which only
+// handles the encoding: the transport is provided by the Publisher.
+type Bus struct {
+	// Topic is the topic the events are published to.
+	Topic string
+	// Source is set in every published record.
+	Source string
+	// Publisher sends the messages. Every event fails with ErrNoPublisher if nil.
+	Publisher Publisher
+}
+
+// Emit publishes the event to the topic.
+func (b *Bus) Emit(metric string, e csv.Event) error {
+	if b.Publisher == nil {
+		return ErrNoPublisher
+	}
+	msg, err := json.Marshal(newRecord(b.Source, metric, e))
+	if err != nil {
+		return err
+	}
+	return b.Publisher.Publish(b.Topic, msg)
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventsink
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// fakePublisher records the published messages.
+type fakePublisher struct {
+	topics []string
+	msgs   []string
+}
+
+func (f *fakePublisher) Publish(topic string, msg []byte) error {
+	f.topics = append(f.topics, topic)
+	f.msgs = append(f.msgs, string(msg))
+	return nil
+}
+
+// TestSinks tests the records written by the NDJSON and Bus sinks for the printed entries of a State.
+func TestSinks(t *testing.T) {
+	print := func(sink csv.EventSink) error {
+		s := csv.NewState(&bytes.Buffer{}, false)
+		s.SetSink(sink)
+		s.Print("Screen", "bool", 1000, 2000, "true", "")
+		s.Print("Wakelock", "service", 1500, 1800, `"com.example.app"`, "10045")
+		return s.SinkErr()
+	}
+	want := []string{
+		`{"metric":"Screen","type":"bool","start_time":1000,"end_time":2000,"value":"true","source":"bugreport.zip"}`,
+		`{"metric":"Wakelock","type":"service","start_time":1500,"end_time":1800,"value":"com.example.app","opt":"10045","source":"bugreport.zip"}`,
+	}
+
+	var b bytes.Buffer
+	if err := print(NewNDJSON(&b, "bugreport.zip")); err != nil {
+		t.Fatalf("NDJSON sink returned unexpected error: %v", err)
+	}
+	if got := strings.Split(strings.TrimSpace(b.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("NDJSON sink wrote:\n got: %q\n want: %q", got, want)
+	}
+
+	p := &fakePublisher{}
+	if err := print(&Bus{Topic: "battery-events", Source: "bugreport.zip", Publisher: p}); err != nil {
+		t.Fatalf("Bus sink returned unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(p.msgs, want) {
+		t.Errorf("Bus sink published:\n got: %q\n want: %q", p.msgs, want)
+	}
+	if wantTopics := []string{"battery-events", "battery-events"}; !reflect.DeepEqual(p.topics, wantTopics) {
+		t.Errorf("Bus sink published to topics %v, want %v", p.topics, wantTopics)
+	}
+
+	if err := print(&Bus{Topic: "battery-events"}); err != ErrNoPublisher {
+		t.Errorf("Bus sink without a publisher returned error %v, want %v", err, ErrNoPublisher)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package findings turns the heuristics run on the battery history into a documented JSON list of
+// findings, so that triage tooling can route battery bugs without reading the Historian UI.
+//
+// The file is a single JSON object:
+//
+//	{
+//	  "format": "battery-historian-findings",
+//	  "version": 1,
+//	  "language": "en",
+//	  "device": {"model": "Pixel", "sdkVersion": 25, "buildFingerprint": "..."},
+//	  "findings": [
+//	    {
+//	      "id": "sync_storm",
+//	      "severity": "high",
+//	      "summary": "UID 10045 ran 32 syncs within 10m0s",
+//	      "evidence": [{"startMs": 1422620000000, "endMs": 1422620600000}],
+//	      "uids": [10045]
+//	    }
+//	  ]
+//	}
+//
+// The IDs are the kinds of finding, so several findings can have the same ID, e.g. for different
+// apps. Findings are sorted by decreasing severity, then ID, then the start of their first evidence.
+// Evidence time ranges are in milliseconds since epoch, and the UIDs are app IDs. Fields may be added
+// within a version, so readers should ignore unknown fields.
+package findings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/appsummary"
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// Format identifies the exported files.
+	Format = "battery-historian-findings"
+	// Version is the version of the file format, incremented on incompatible changes.
+	Version = 1
+)
+
+// The finding IDs.
+const (
+	// WakelockAbuser is an app holding wakelocks for longer than the system flags as long.
+	WakelockAbuser = "wakelock_abuser"
+	// SyncStorm is an app running many syncs in a short time.
+	SyncStorm = "sync_storm"
+	// SuspendFailures is a high rate of aborted suspends among the CPU running periods.
+	SuspendFailures = "suspend_failures"
+	// ChargingThermal is a charging session with an abnormal battery temperature.
+	ChargingThermal = "charging_thermal"
+	// ChargerFlapping is the charging status flapping between charging and discharging.
+	ChargerFlapping = "charger_flapping"
+	// ImmortalWakelock is a wakelock held across the report, which keeps the device from suspending.
+	ImmortalWakelock = "immortal_wakelock"
+)
+
+// Severity is how much a finding likely contributes to the drain.
+type Severity string
+
+// The severities, from lowest to highest.
+const (
+	Low    Severity = "low"
+	Medium Severity = "medium"
+	High   Severity = "high"
+)
+
+// rank orders the severities.
+var rank = map[Severity]int{Low: 0, Medium: 1, High: 2}
+
+const (
+	// maxEvidence is the most evidence time ranges kept per finding, the earliest ones.
+	maxEvidence = 20
+
+	// Long wakelocks held for at least these in total are of medium or high severity.
+	wakelockMedium = 15 * time.Minute
+	wakelockHigh   = time.Hour
+
+	// syncStormWindow and syncStormCount are the time and number of syncs started in it that make
+	// a storm. Storms with at least syncStormMedium or syncStormHigh syncs in a window are of medium or
+	// high severity.
+	syncStormWindow = 10 * time.Minute
+	syncStormCount  = 10
+	syncStormMedium = 20
+	syncStormHigh   = 30
+
+	// suspendMinEvents is the least number of CPU running periods the suspend failure rate is
+	// computed for, as the rate of a handful isn't meaningful.
+	suspendMinEvents = 20
+	// Suspend failure rates of at least these are of low, medium or high severity, in percent.
+	suspendLow    = 10.0
+	suspendMedium = 25.0
+	suspendHigh   = 50.0
+
+	// flapMinCount is the least number of charging status flaps reported, as the odd flap happens when
+	// plugging in. Flapping with at least flapMedium or flapHigh flaps is of medium or high severity.
+	flapMinCount = 10
+	flapMedium   = 30
+	flapHigh     = 100
+)
+
+// File is the exported list of findings.
+type File struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Language is the language of the summaries, see the messages package.
+	Language string            `json:"language"`
+	Device   appsummary.Device `json:"device"`
+	Findings []Finding         `json:"findings"`
+}
+
+// Finding is an issue found in the battery history.
+type Finding struct {
+	ID       string   `json:"id"`
+	Severity Severity `json:"severity"`
+	// Summary is the readable description of the finding, in the language of the file.
+	Summary string `json:"summary"`
+	// Evidence are the time ranges the finding is based on, sorted by start time.
+	Evidence []TimeRange `json:"evidence"`
+	// UIDs are the sorted app IDs of the apps implicated, if any.
+	UIDs []int32 `json:"uids,omitempty"`
+
+	// Messages make up the summary, joined with "; ".
+	Messages []messages.Message `json:"-"`
+}
+
+// TimeRange is a period of the battery history, in milliseconds since epoch.
+type TimeRange struct {
+	StartMs int64 `json:"startMs"`
+	EndMs   int64 `json:"endMs"`
+}
+
+// byRank sorts findings by decreasing severity, then ID, then the start of their first evidence.
+type byRank []Finding
+
+func (a byRank) Len() int      { return len(a) }
+func (a byRank) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRank) Less(i, j int) bool {
+	switch {
+	case a[i].Severity != a[j].Severity:
+		return rank[a[i].Severity] > rank[a[j].Severity]
+	case a[i].ID != a[j].ID:
+		return a[i].ID < a[j].ID
+	case len(a[i].Evidence) == 0 || len(a[j].Evidence) == 0:
+		return len(a[i].Evidence) > len(a[j].Evidence)
+	}
+	return a[i].Evidence[0].StartMs < a[j].Evidence[0].StartMs
+}
+
+// byStart sorts time ranges by start time.
+type byStart []TimeRange
+
+func (a byStart) Len() int           { return len(a) }
+func (a byStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool { return a[i].StartMs < a[j].StartMs }
+
+// newFinding returns the finding with its evidence sorted and capped to maxEvidence, and its summary
+// in the default language.
+func newFinding(id string, sev Severity, evidence []TimeRange, uids []int32, msgs ...messages.Message) Finding {
+	sort.Sort(byStart(evidence))
+	if len(evidence) > maxEvidence {
+		evidence = evidence[:maxEvidence]
+	}
+	f := Finding{ID: id, Severity: sev, Evidence: evidence, UIDs: uids, Messages: msgs}
+	f.localize(messages.DefaultLanguage)
+	return f
+}
+
+// localize sets the summary to the given language.
+func (f *Finding) localize(lang string) {
+	var parts []string
+	for _, m := range f.Messages {
+		parts = append(parts, m.In(lang))
+	}
+	f.Summary = strings.Join(parts, "; ")
+}
+
+// eventsByApp returns the events of the metric keyed by the app ID in their opt field. Events without
+// one are skipped.
+func eventsByApp(es []csv.Event, metric string) (map[int32][]csv.Event, []error) {
+	var errs []error
+	apps := make(map[int32][]csv.Event)
+	for _, e := range es {
+		if e.Opt == "" {
+			continue
+		}
+		uid, err := packageutils.AppIDFromString(e.Opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s uid %q: %v", metric, e.Opt, err))
+			continue
+		}
+		apps[uid] = append(apps[uid], e)
+	}
+	return apps, errs
+}
+
+// wakelockAbusers returns a finding for each app that held long wakelocks.
+func wakelockAbusers(es []csv.Event) ([]Finding, []error) {
+	apps, errs := eventsByApp(es, parseutils.LongWakelocks)
+	var fs []Finding
+	for uid, ws := range apps {
+		var total time.Duration
+		var evidence []TimeRange
+		for _, e := range ws {
+			total += time.Duration(e.End-e.Start) * time.Millisecond
+			evidence = append(evidence, TimeRange{e.Start, e.End})
+		}
+		sev := Low
+		switch {
+		case total >= wakelockHigh:
+			sev = High
+		case total >= wakelockMedium:
+			sev = Medium
+		}
+		fs = append(fs, newFinding(WakelockAbuser, sev, evidence, []int32{uid}, messages.New(messages.FindingWakelockAbuser, uid, total)))
+	}
+	return fs, errs
+}
+
+// syncStorms returns a finding for each app that started at least syncStormCount syncs within
+// syncStormWindow, with the storms as evidence.
+func syncStorms(es []csv.Event) ([]Finding, []error) {
+	apps, errs := eventsByApp(es, "SyncManager")
+	window := int64(syncStormWindow / time.Millisecond)
+	var fs []Finding
+	for uid, syncs := range apps {
+		starts := syncs
+		csv.SortByStart(starts)
+		var storms []TimeRange
+		most := 0
+		for i, j := 0, 0; j < len(starts); j++ {
+			for starts[j].Start-starts[i].Start >= window {
+				i++
+			}
+			n := j - i + 1
+			if n < syncStormCount {
+				continue
+			}
+			if n > most {
+				most = n
+			}
+			// Storms with overlapping windows are one storm.
+			if k := len(storms); k > 0 && starts[i].Start <= storms[k-1].EndMs {
+				if starts[j].End > storms[k-1].EndMs {
+					storms[k-1].EndMs = starts[j].End
+				}
+				continue
+			}
+			storms = append(storms, TimeRange{starts[i].Start, starts[j].End})
+		}
+		if len(storms) == 0 {
+			continue
+		}
+		sev := Low
+		switch {
+		case most >= syncStormHigh:
+			sev = High
+		case most >= syncStormMedium:
+			sev = Medium
+		}
+		fs = append(fs, newFinding(SyncStorm, sev, storms, []int32{uid}, messages.New(messages.FindingSyncStorm, uid, most, syncStormWindow)))
+	}
+	return fs, errs
+}
+
+// suspendFailures returns a finding if a high enough percentage of the CPU running periods were
+// aborted suspends, with the aborted suspends as evidence.
+func suspendFailures(es []csv.Event) []Finding {
+	if len(es) < suspendMinEvents {
+		return nil
+	}
+	var evidence []TimeRange
+	for _, e := range es {
+		if parseutils.IsSuspendAbort(e) {
+			evidence = append(evidence, TimeRange{e.Start, e.End})
+		}
+	}
+	rate := 100 * float64(len(evidence)) / float64(len(es))
+	var sev Severity
+	switch {
+	case rate >= suspendHigh:
+		sev = High
+	case rate >= suspendMedium:
+		sev = Medium
+	case rate >= suspendLow:
+		sev = Low
+	default:
+		return nil
+	}
+	return []Finding{newFinding(SuspendFailures, sev, evidence, nil, messages.New(messages.FindingSuspendFailures, rate, len(es)))}
+}
+
+// chargingThermal returns a finding for each charging session with an abnormal battery temperature.
+// Sessions over the maximum temperature are of high severity, and the others of medium severity.
+func chargingThermal(csvInput string) ([]Finding, []error) {
+	alerts, errs := parseutils.ChargingTemperatureAlerts(csvInput)
+	var fs []Finding
+	for _, a := range alerts {
+		sev := Medium
+		if a.MaxTemperatureC > parseutils.MaxChargingTemperatureC {
+			sev = High
+		}
+		msgs := append([]messages.Message{messages.New(messages.FindingChargingThermal)}, a.Reasons...)
+		fs = append(fs, newFinding(ChargingThermal, sev, []TimeRange{{a.Session.StartMs, a.Session.EndMs}}, nil, msgs...))
+	}
+	return fs, errs
+}
+
+// chargerFlapping returns a finding if the charging status flapped at least flapMinCount times, with
+// the bursts of flaps as evidence.
+func chargerFlapping(csvInput string) ([]Finding, []error) {
+	flaps, errs := parseutils.ChargerFlaps(csvInput, parseutils.ChargerFlapDuration)
+	if len(flaps) < flapMinCount {
+		return nil, errs
+	}
+	var evidence []TimeRange
+	for _, e := range csv.MergeEvents(flaps) {
+		evidence = append(evidence, TimeRange{e.Start, e.End})
+	}
+	sev := Low
+	switch {
+	case len(flaps) >= flapHigh:
+		sev = High
+	case len(flaps) >= flapMedium:
+		sev = Medium
+	}
+	return []Finding{newFinding(ChargerFlapping, sev, evidence, nil, messages.New(messages.FindingChargerFlapping, len(flaps)))}, errs
+}
+
+// immortalWakelocks returns a finding of high severity for each wakelock held across the report,
+// with its holds as evidence and the app it's attributed to, if known.
+func immortalWakelocks(csvInput string) ([]Finding, []error) {
+	ws, errs := parseutils.ImmortalWakelocks(csvInput)
+	var fs []Finding
+	for _, w := range ws {
+		var evidence []TimeRange
+		for _, h := range w.Holds {
+			evidence = append(evidence, TimeRange{h.Start, h.End})
+		}
+		var uids []int32
+		if w.UID != "" {
+			uid, err := packageutils.AppIDFromString(w.UID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid wakelock uid %q: %v", w.UID, err))
+			} else {
+				uids = []int32{uid}
+			}
+		}
+		msgs := append([]messages.Message{messages.New(messages.FindingImmortalWakelock, w.Tag)}, w.Reasons...)
+		fs = append(fs, newFinding(ImmortalWakelock, High, evidence, uids, msgs...))
+	}
+	return fs, errs
+}
+
+// Find returns the findings in the battery history CSV generated by AnalyzeHistory, sorted by
+// decreasing severity.
+func Find(csvInput string) ([]Finding, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{parseutils.LongWakelocks, "SyncManager", csv.CPURunning})
+	fs := []Finding{}
+	wl, wErrs := wakelockAbusers(es[parseutils.LongWakelocks])
+	errs = append(errs, wErrs...)
+	fs = append(fs, wl...)
+	storms, sErrs := syncStorms(es["SyncManager"])
+	errs = append(errs, sErrs...)
+	fs = append(fs, storms...)
+	fs = append(fs, suspendFailures(es[csv.CPURunning])...)
+	thermal, tErrs := chargingThermal(csvInput)
+	errs = append(errs, tErrs...)
+	fs = append(fs, thermal...)
+	flapping, fErrs := chargerFlapping(csvInput)
+	errs = append(errs, fErrs...)
+	fs = append(fs, flapping...)
+	immortal, iErrs := immortalWakelocks(csvInput)
+	errs = append(errs, iErrs...)
+	fs = append(fs, immortal...)
+	sort.Sort(byRank(fs))
+	return fs, errs
+}
+
+// New returns the file of the findings in the battery history CSV generated by AnalyzeHistory. meta
+// may be nil. The summaries are in the default language.
+func New(meta *bugreportutils.MetaInfo, csvInput string) (*File, []error) {
+	f := &File{Format: Format, Version: Version, Language: messages.DefaultLanguage}
+	if meta != nil {
+		f.Device = appsummary.Device{Model: meta.ModelName, SDKVersion: meta.SdkVersion, BuildFingerprint: meta.BuildFingerprint}
+	}
+	var errs []error
+	f.Findings, errs = Find(csvInput)
+	return f, errs
+}
+
+// Localize sets the summaries to the given language, falling back to the default language for the
+// messages missing in it.
+func (f *File) Localize(lang string) {
+	f.Language = lang
+	for i := range f.Findings {
+		f.Findings[i].localize(lang)
+	}
+}
+
+// Write writes the findings as indented JSON.
+func (f *File) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
@@ -0,0 +1,157 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package findings
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+)
+
+// TestFind tests the findings of the heuristics and their order.
+func TestFind(t *testing.T) {
+	rows := []string{
+		csv.FileHeader,
+		// 70 minutes of long wakelocks.
+		`Long Wakelocks,service,0,2400000,*sync*/com.example.mail,10045`,
+		`Long Wakelocks,service,3000000,4800000,*sync*/com.example.mail,10045`,
+	}
+	// 12 syncs within 5 minutes, then a single sync later on.
+	for i := 0; i < 12; i++ {
+		start := int64(600000 + i*25000)
+		rows = append(rows, fmt.Sprintf(`SyncManager,service,%d,%d,com.example.provider,10030`, start, start+1000))
+	}
+	rows = append(rows, `SyncManager,service,5000000,5001000,com.example.provider,10030`)
+	// 6 of 20 CPU running periods are aborted suspends.
+	for i := 0; i < 20; i++ {
+		start := int64(i * 10000)
+		reason := "Unknown wakeup reason"
+		if i < 6 {
+			reason = "Abort:Last active Wakeup Source: eventpoll"
+		}
+		rows = append(rows, fmt.Sprintf(`CPU running,string,%d,%d,%d~%s,`, start, start+500, start, reason))
+	}
+
+	got, errs := Find(strings.Join(rows, "\n"))
+	if len(errs) > 0 {
+		t.Fatalf("Find generated unexpected errors: %v", errs)
+	}
+	want := []Finding{
+		{
+			ID:       WakelockAbuser,
+			Severity: High,
+			Summary:  "UID 10045 held long wakelocks for 1h10m0s in total",
+			Evidence: []TimeRange{{0, 2400000}, {3000000, 4800000}},
+			UIDs:     []int32{10045},
+		},
+		{
+			ID:       SuspendFailures,
+			Severity: Medium,
+			Summary:  "30% of the 20 CPU running periods were aborted suspends",
+			Evidence: []TimeRange{{0, 500}, {10000, 10500}, {20000, 20500}, {30000, 30500}, {40000, 40500}, {50000, 50500}},
+		},
+		{
+			ID:       SyncStorm,
+			Severity: Low,
+			Summary:  "UID 10030 ran 12 syncs within 10m0s",
+			Evidence: []TimeRange{{600000, 876000}},
+			UIDs:     []int32{10030},
+		},
+	}
+	for i := range got {
+		got[i].Messages = nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Find()\n got: %+v\n want: %+v", got, want)
+	}
+}
+
+// TestLocalize tests that the summaries are set to the requested language.
+func TestLocalize(t *testing.T) {
+	if err := messages.AddCatalog(`{"xx": {"finding.sync_storm": "storm %d %d %v"}}`); err != nil {
+		t.Fatalf("AddCatalog failed: %v", err)
+	}
+	defer messages.RemoveLanguage("xx")
+	f := &File{Findings: []Finding{
+		newFinding(SyncStorm, Low, nil, []int32{10030}, messages.New(messages.FindingSyncStorm, int32(10030), 12, "10m0s")),
+		newFinding(SuspendFailures, Low, nil, nil, messages.New(messages.FindingSuspendFailures, 12.0, 20)),
+	}}
+	f.Localize("xx")
+	var got []string
+	for _, fd := range f.Findings {
+		got = append(got, fd.Summary)
+	}
+	want := []string{"storm 10030 12 10m0s", "12% of the 20 CPU running periods were aborted suspends"}
+	if f.Language != "xx" || !reflect.DeepEqual(got, want) {
+		t.Errorf("Localize(%q) = %q, %q, want %q, %q", "xx", f.Language, got, "xx", want)
+	}
+}
+
+// TestChargerFlapping tests the finding of charging status flapping.
+func TestChargerFlapping(t *testing.T) {
+	rows := []string{csv.FileHeader}
+	// 12 flaps of a second each, then charging for good.
+	var start int64
+	for i := 0; i < 12; i++ {
+		rows = append(rows, fmt.Sprintf(`Charging status,string,%d,%d,c,`, start, start+1000))
+		rows = append(rows, fmt.Sprintf(`Charging status,string,%d,%d,d,`, start+1000, start+60000))
+		start += 60000
+	}
+	rows = append(rows, fmt.Sprintf(`Charging status,string,%d,%d,c,`, start, start+3600000))
+
+	got, errs := Find(strings.Join(rows, "\n"))
+	if len(errs) > 0 {
+		t.Fatalf("Find generated unexpected errors: %v", errs)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Find() = %+v, want a single finding", got)
+	}
+	if f := got[0]; f.ID != ChargerFlapping || f.Severity != Low || len(f.Evidence) != 12 {
+		t.Errorf("Find() = %+v, want a low severity %s finding with 12 evidence ranges", f, ChargerFlapping)
+	}
+}
+
+// TestImmortalWakelock tests the finding of a wakelock held across the report.
+func TestImmortalWakelock(t *testing.T) {
+	rows := []string{
+		csv.FileHeader,
+		`Battery Level,int,0,3600000,100,`,
+		`Battery Level,int,3600000,7200000,99,`,
+		`Partial wakelock,service,0,7200000,"*alarm*",1000`,
+	}
+	got, errs := Find(strings.Join(rows, "\n"))
+	if len(errs) > 0 {
+		t.Fatalf("Find generated unexpected errors: %v", errs)
+	}
+	for i := range got {
+		got[i].Messages = nil
+	}
+	want := []Finding{
+		{
+			ID:       ImmortalWakelock,
+			Severity: High,
+			Summary:  "the *alarm* wakelock was held across the report; held at both the start and the end of the report; held for 100% of the 2h0m0s unplugged, over 95%",
+			Evidence: []TimeRange{{0, 7200000}},
+			UIDs:     []int32{1000},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Find()\n got: %+v\n want: %+v", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package goldentest runs the battery history pipeline on sample bug reports and compares the
+// results to golden JSON files, so that embedders can check their integration and regressions on
+// sample reports of new devices are caught.
+//
+// A directory of samples holds the bug reports, in any format accepted by
+// bugreportutils.ExtractBugReport, each next to its golden file named after it with a
+// ".golden.json" suffix, e.g. pixel.zip and pixel.zip.golden.json. A test of the samples is:
+//
+//	var update = flag.Bool("update_golden", false, "Whether to rewrite the golden files.")
+//
+//	func TestSamples(t *testing.T) {
+//		goldentest.CheckDir(t, "testdata", *update)
+//	}
+//
+// Running the test with --update_golden writes the golden files of new samples, or rewrites them
+// after intended changes to the output, which then show up in the diff of the golden files.
+package goldentest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/appsummary"
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/packageutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// GoldenSuffix is appended to the name of a sample bug report to get the name of its golden file.
+	GoldenSuffix = ".golden.json"
+
+	// maxDiffLines is the most differing lines reported by Diff.
+	maxDiffLines = 50
+)
+
+// Report is the output of the pipeline on a bug report that golden files are compared to. It's
+// limited to the stable parts of the output, so that golden files only change with the analysis.
+type Report struct {
+	Device appsummary.Device `json:"device"`
+	// HistoryLines is the number of battery history event lines analyzed.
+	HistoryLines int `json:"historyLines"`
+	// UnknownKeys maps each unsupported history event code to the number of lines it was seen in.
+	UnknownKeys map[string]int `json:"unknownKeys,omitempty"`
+	// Metrics are the battery history CSV metrics, sorted by name.
+	Metrics []Metric `json:"metrics"`
+	// Apps are the per app stats, as exported by appsummary.
+	Apps []appsummary.App `json:"apps"`
+	// Errors are the sorted errors of the pipeline.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Metric is the number and total duration of the battery history CSV events of a metric.
+type Metric struct {
+	Name            string `json:"name"`
+	Events          int    `json:"events"`
+	TotalDurationMs int64  `json:"totalDurationMs"`
+}
+
+// byName sorts metrics by name.
+type byName []Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// Run runs the battery history pipeline on the bug report, with the same derived metrics as the
+// analyzer and the historian tool, see parseutils.WriteDerivedMetrics.
+func Run(bugReport string) *Report {
+	var errs []error
+	r := &Report{Metrics: []Metric{}}
+	meta, err := bugreportutils.ParseMetaInfo(bugReport)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if meta != nil {
+		r.Device = appsummary.Device{Model: meta.ModelName, SDKVersion: meta.SdkVersion, BuildFingerprint: meta.BuildFingerprint}
+	}
+
+	pkgs, pErrs := packageutils.ExtractAppsFromBugReport(bugReport)
+	errs = append(errs, pErrs...)
+	upm, uErrs := parseutils.UIDAndPackageNameMapping(bugReport, pkgs)
+	errs = append(errs, uErrs...)
+	w := &bytes.Buffer{}
+	rep := parseutils.AnalyzeHistoryWithOptions(w, bugReport, parseutils.FormatTotalTime, upm, true, parseutils.HistoryOptions{ChargingDebounce: parseutils.DefaultChargingDebounce})
+	errs = append(errs, rep.Errs...)
+	errs = append(errs, parseutils.WriteDerivedMetrics(w, bugReport)...)
+	r.HistoryLines = rep.HistoryLines
+	if len(rep.UnknownKeys) > 0 {
+		r.UnknownKeys = rep.UnknownKeys
+	}
+
+	events, eErrs := csv.ExtractEvents(w.String(), nil)
+	errs = append(errs, eErrs...)
+	for name, es := range events {
+		m := Metric{Name: name, Events: len(es)}
+		for _, e := range es {
+			m.TotalDurationMs += e.End - e.Start
+		}
+		r.Metrics = append(r.Metrics, m)
+	}
+	sort.Sort(byName(r.Metrics))
+
+	r.Apps = appsummary.New(meta, rep.Summaries).Apps
+	for _, err := range errs {
+		r.Errors = append(r.Errors, err.Error())
+	}
+	sort.Strings(r.Errors)
+	return r
+}
+
+// JSON returns the report as indented JSON, the format of the golden files.
+func (r *Report) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Diff returns the lines that differ between want and got, prefixed with "-" and "+" respectively
+// along with their line numbers, or an empty string if they're the same.
+func Diff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// Only the lines between the common prefix and suffix need to be compared.
+	prefix := 0
+	for prefix < len(w) && prefix < len(g) && w[prefix] == g[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(w)-prefix && suffix < len(g)-prefix && w[len(w)-1-suffix] == g[len(g)-1-suffix] {
+		suffix++
+	}
+	wm, gm := w[prefix:len(w)-suffix], g[prefix:len(g)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of wm[i:] and gm[j:].
+	lcs := make([][]int, len(wm)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(gm)+1)
+	}
+	for i := len(wm) - 1; i >= 0; i-- {
+		for j := len(gm) - 1; j >= 0; j-- {
+			switch {
+			case wm[i] == gm[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(wm) || j < len(gm) {
+		switch {
+		case i < len(wm) && j < len(gm) && wm[i] == gm[j]:
+			i++
+			j++
+		case j == len(gm) || (i < len(wm) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, fmt.Sprintf("-%d: %s", prefix+i+1, wm[i]))
+			i++
+		default:
+			lines = append(lines, fmt.Sprintf("+%d: %s", prefix+j+1, gm[j]))
+			j++
+		}
+	}
+	if len(lines) > maxDiffLines {
+		lines = append(lines[:maxDiffLines], fmt.Sprintf("... and %d more lines", len(lines)-maxDiffLines))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check runs the pipeline on the sample bug report at the given path and compares the report to its
+// golden file, or writes the golden file if update is true.
+func Check(t testing.TB, path string, update bool) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read sample: %v", err)
+	}
+	br, _, err := bugreportutils.ExtractBugReport(path, c)
+	if err != nil {
+		t.Fatalf("%s: could not extract bug report: %v", path, err)
+	}
+	got, err := Run(br).JSON()
+	if err != nil {
+		t.Fatalf("%s: could not encode report: %v", path, err)
+	}
+	golden := path + GoldenSuffix
+	if update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("Could not write golden file: %v", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Errorf("%s: no golden file, run the test with update set to write it", path)
+		return
+	}
+	if err != nil {
+		t.Fatalf("Could not read golden file: %v", err)
+	}
+	if d := Diff(string(want), string(got)); d != "" {
+		t.Errorf("%s: report differs from %s (-want +got):\n%s", path, golden, d)
+	}
+}
+
+// CheckDir runs Check on each sample bug report in the directory, i.e. each file other than the
+// golden files and hidden files.
+func CheckDir(t testing.TB, dir string, update bool) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Could not read samples: %v", err)
+	}
+	samples := 0
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, GoldenSuffix) {
+			continue
+		}
+		Check(t, filepath.Join(dir, name), update)
+		samples++
+	}
+	if samples == 0 {
+		t.Errorf("No sample bug reports in %s", dir)
+	}
+}
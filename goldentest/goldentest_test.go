@@ -0,0 +1,61 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goldentest
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update_golden", false, "Whether to rewrite the golden files of the samples in testdata.")
+
+// TestSamples tests the pipeline on the sample bug reports.
+func TestSamples(t *testing.T) {
+	CheckDir(t, "testdata", *update)
+}
+
+// TestDiff tests the readable diffs of golden files.
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		desc      string
+		want, got []string
+		wantDiff  []string
+	}{
+		{
+			desc: "Same",
+			want: []string{"{", `  "a": 1`, "}"},
+			got:  []string{"{", `  "a": 1`, "}"},
+		},
+		{
+			desc:     "Changed line",
+			want:     []string{"{", `  "a": 1,`, `  "b": 2`, "}"},
+			got:      []string{"{", `  "a": 1,`, `  "b": 3`, "}"},
+			wantDiff: []string{`-3:   "b": 2`, `+3:   "b": 3`},
+		},
+		{
+			desc:     "Added and removed lines",
+			want:     []string{"{", `  "a": 1,`, `  "b": 2,`, `  "c": 3`, "}"},
+			got:      []string{"{", `  "b": 2,`, `  "c": 3,`, `  "d": 4`, "}"},
+			wantDiff: []string{`-2:   "a": 1,`, `-4:   "c": 3`, `+3:   "c": 3,`, `+4:   "d": 4`},
+		},
+	}
+	for _, test := range tests {
+		got := Diff(strings.Join(test.want, "\n"), strings.Join(test.got, "\n"))
+		if want := strings.Join(test.wantDiff, "\n"); got != want {
+			t.Errorf("%v: Diff()\n got: %v\n want: %v", test.desc, got, want)
+		}
+	}
+}
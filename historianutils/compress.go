@@ -0,0 +1,230 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package historianutils
+
+// compress.go handles gzip and zstd compressed data. There's no zstd package in the standard library,
+// so zstd data is processed with the zstd command line tool, which must be installed.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported compression encodings, named as in the HTTP Content-Encoding header.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// DefaultMaxDecompressedSize is the default maximum size in bytes of decompressed data.
+const DefaultMaxDecompressedSize = 100 * 1024 * 1024
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// Initialized in SetMaxDecompressedSize(). The size of decompressed data isn't limited if not positive.
+	maxDecompressedSize int64 = DefaultMaxDecompressedSize
+
+	errTooLarge = errors.New("output limit exceeded")
+)
+
+// SetMaxDecompressedSize sets the maximum size in bytes of the data returned by Decompress, so that
+// small compressed uploads can't expand to exhaust the memory. A non positive size disables the limit.
+func SetMaxDecompressedSize(n int64) {
+	maxDecompressedSize = n
+}
+
+// MaxDecompressedSize returns the maximum size in bytes of decompressed data, or a non positive
+// value if it isn't limited.
+func MaxDecompressedSize() int64 {
+	return maxDecompressedSize
+}
+
+// tooLargeError returns the error of decompressed data larger than the maximum size.
+func tooLargeError(enc string) error {
+	return fmt.Errorf("decompressed %s data is larger than %d bytes", enc, maxDecompressedSize)
+}
+
+// Encoding returns the compression encoding of the data, or an empty string if it isn't compressed
+// with a supported encoding.
+func Encoding(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return EncodingGzip
+	case bytes.HasPrefix(b, zstdMagic):
+		return EncodingZstd
+	default:
+		return ""
+	}
+}
+
+// Decompress returns the decompressed data and its encoding if the data is gzip or zstd compressed,
+// and otherwise returns the data unchanged with an empty encoding. An error is returned if the
+// decompressed data is larger than the size set with SetMaxDecompressedSize.
+func Decompress(b []byte) ([]byte, string, error) {
+	switch enc := Encoding(b); enc {
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, enc, fmt.Errorf("failed to open gzip data: %v", err)
+		}
+		defer r.Close()
+		var lr io.Reader = r
+		if maxDecompressedSize > 0 {
+			// One more byte is read to tell data of exactly the maximum size from larger data.
+			lr = io.LimitReader(r, maxDecompressedSize+1)
+		}
+		d, err := ioutil.ReadAll(lr)
+		if err != nil {
+			return nil, enc, fmt.Errorf("failed to decompress gzip data: %v", err)
+		}
+		if maxDecompressedSize > 0 && int64(len(d)) > maxDecompressedSize {
+			return nil, enc, tooLargeError(enc)
+		}
+		return d, enc, nil
+	case EncodingZstd:
+		d, err := runZstd(b, maxDecompressedSize, "-d")
+		if err == errTooLarge {
+			return nil, enc, tooLargeError(enc)
+		}
+		return d, enc, err
+	default:
+		return b, "", nil
+	}
+}
+
+// ZstdCompress compresses byte data with the zstd command line tool.
+func ZstdCompress(uncompressed []byte) ([]byte, error) {
+	return runZstd(uncompressed, 0)
+}
+
+// ZstdAvailable returns whether the zstd command line tool is installed.
+func ZstdAvailable() bool {
+	_, err := exec.LookPath("zstd")
+	return err == nil
+}
+
+// runZstd runs the zstd command line tool with the given arguments on the data, returning its output.
+// If the limit is positive and the output is larger, the tool is killed and errTooLarge is returned.
+func runZstd(b []byte, limit int64, args ...string) ([]byte, error) {
+	cmd := exec.Command("zstd", append([]string{"-q", "-c"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run zstd (is it installed?):\n  %v", err)
+	}
+	var out io.Reader = stdout
+	if limit > 0 {
+		// One more byte is read to tell output of exactly the limit from larger output.
+		out = io.LimitReader(stdout, limit+1)
+	}
+	d, rErr := ioutil.ReadAll(out)
+	if limit > 0 && int64(len(d)) > limit {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errTooLarge
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to run zstd (is it installed?):\n  %v\n  %s", err, stderr.String())
+	}
+	if rErr != nil {
+		return nil, fmt.Errorf("failed to read zstd output: %v", rErr)
+	}
+	return d, nil
+}
+
+// Compress compresses byte data with the given encoding.
+func Compress(uncompressed []byte, enc string) ([]byte, error) {
+	switch enc {
+	case EncodingGzip:
+		return GzipCompress(uncompressed)
+	case EncodingZstd:
+		return ZstdCompress(uncompressed)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", enc)
+	}
+}
+
+// acceptedEncoding is an encoding listed in an Accept-Encoding header, with its quality value.
+type acceptedEncoding struct {
+	name string
+	q    float64
+}
+
+// byQuality sorts encodings in descending order of quality value.
+type byQuality []acceptedEncoding
+
+func (a byQuality) Len() int           { return len(a) }
+func (a byQuality) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byQuality) Less(i, j int) bool { return a[i].q > a[j].q }
+
+// NegotiateEncoding returns the most preferred of the offered encodings accepted by an HTTP
+// Accept-Encoding header, e.g. "gzip;q=0.5, zstd", or an empty string if none are accepted.
+// Ties are broken by the order of the offered encodings.
+func NegotiateEncoding(header string, offered ...string) string {
+	var accepted []acceptedEncoding
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+		accepted = append(accepted, acceptedEncoding{name, q})
+	}
+	var candidates []acceptedEncoding
+	for _, o := range offered {
+		q := -1.0
+		for _, a := range accepted {
+			// An explicit entry for the encoding takes precedence over the wildcard.
+			if a.name == o || (a.name == "*" && q < 0) {
+				q = a.q
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, acceptedEncoding{o, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Stable(byQuality(candidates))
+	return candidates[0].name
+}
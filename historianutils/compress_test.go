@@ -0,0 +1,103 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package historianutils
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestDecompress tests that compressed data is decompressed, and uncompressed data is returned unchanged.
+func TestDecompress(t *testing.T) {
+	data := []byte("========================================================\n== dumpstate: 2015-06-08 18:54:24\n")
+	encs := []string{EncodingGzip}
+	if ZstdAvailable() {
+		encs = append(encs, EncodingZstd)
+	} else {
+		t.Log("zstd not installed, skipping zstd test")
+	}
+	for _, enc := range encs {
+		c, err := Compress(data, enc)
+		if err != nil {
+			t.Errorf("Compress(%q) returned unexpected error: %v", enc, err)
+			continue
+		}
+		if got := Encoding(c); got != enc {
+			t.Errorf("Encoding(%q compressed data) = %q, want %q", enc, got, enc)
+		}
+		d, gotEnc, err := Decompress(c)
+		if err != nil {
+			t.Errorf("Decompress(%q compressed data) returned unexpected error: %v", enc, err)
+			continue
+		}
+		if gotEnc != enc || !bytes.Equal(d, data) {
+			t.Errorf("Decompress(%q compressed data) = %q, %q, want %q, %q", enc, d, gotEnc, data, enc)
+		}
+	}
+	d, enc, err := Decompress(data)
+	if err != nil || enc != "" || !bytes.Equal(d, data) {
+		t.Errorf("Decompress(%q) = %q, %q, %v, want data unchanged", data, d, enc, err)
+	}
+}
+
+// TestDecompressLimit tests that data decompressing to more than the maximum size is rejected.
+func TestDecompressLimit(t *testing.T) {
+	defer SetMaxDecompressedSize(MaxDecompressedSize())
+	data := bytes.Repeat([]byte("9,h,1000,+r\n"), 100)
+	encs := []string{EncodingGzip}
+	if ZstdAvailable() {
+		encs = append(encs, EncodingZstd)
+	} else {
+		t.Log("zstd not installed, skipping zstd test")
+	}
+	for _, enc := range encs {
+		c, err := Compress(data, enc)
+		if err != nil {
+			t.Errorf("Compress(%q) returned unexpected error: %v", enc, err)
+			continue
+		}
+		SetMaxDecompressedSize(int64(len(data)))
+		if d, _, err := Decompress(c); err != nil || !bytes.Equal(d, data) {
+			t.Errorf("Decompress(%q compressed data) of the maximum size = %q, %v, want %q, nil", enc, d, err, data)
+		}
+		SetMaxDecompressedSize(int64(len(data) - 1))
+		if d, _, err := Decompress(c); err == nil {
+			t.Errorf("Decompress(%q compressed data) larger than the maximum size = %d bytes, want error", enc, len(d))
+		}
+	}
+}
+
+// TestNegotiateEncoding tests the selection of a response encoding from the Accept-Encoding header.
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header  string
+		offered []string
+		want    string
+	}{
+		{"", []string{EncodingZstd, EncodingGzip}, ""},
+		{"gzip, deflate, br", []string{EncodingZstd, EncodingGzip}, EncodingGzip},
+		{"gzip, deflate, br, zstd", []string{EncodingZstd, EncodingGzip}, EncodingZstd},
+		{"zstd;q=0.5, gzip", []string{EncodingZstd, EncodingGzip}, EncodingGzip},
+		{"gzip;q=0", []string{EncodingZstd, EncodingGzip}, ""},
+		{"*", []string{EncodingZstd, EncodingGzip}, EncodingZstd},
+		{"*;q=0.1, zstd;q=0", []string{EncodingZstd, EncodingGzip}, EncodingGzip},
+		{"GZIP", []string{EncodingGzip}, EncodingGzip},
+	}
+	for _, test := range tests {
+		if got := NegotiateEncoding(test.header, test.offered...); got != test.want {
+			t.Errorf("NegotiateEncoding(%q, %v) = %q, want %q", test.header, test.offered, got, test.want)
+		}
+	}
+}
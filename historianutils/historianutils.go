@@ -18,6 +18,7 @@ package historianutils
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"os/exec"
@@ -36,6 +37,15 @@ var (
 
 	// piiSyncRE is a regular expression to match any PII string of the form *sync*/blah/blah/pii
 	piiSyncRE = regexp.MustCompile(`(?P<prefix>\*sync\*/\S+/)(?P<account>\S+)`)
+
+	// piiTextEmailRE is a regular expression to match an email address within arbitrary text.
+	piiTextEmailRE = regexp.MustCompile(`[^\s"'/=:,;<>()\[\]{}]+@` + `(?P<suffix>[\w-]+(\.[\w-]+)+)`)
+
+	// piiTextQuotedSyncRE is a regular expression to match a quoted sync name within arbitrary text. The account may contain spaces.
+	piiTextQuotedSyncRE = regexp.MustCompile(`(?P<prefix>"\*sync\*/[^"]+/)[^"/]+"`)
+
+	// piiTextSyncRE is a regular expression to match an unquoted sync name within arbitrary text.
+	piiTextSyncRE = regexp.MustCompile(`(?P<prefix>\*sync\*/[^\s"]+/)[^\s"/]+`)
 )
 
 // ScrubPII scrubs any part of the string that looks like PII (eg. an email address).
@@ -57,6 +67,15 @@ func ScrubPII(input string) string {
 	return input
 }
 
+// ScrubPIIText scrubs all parts of the text that look like PII, leaving the rest of the text
+// (e.g. the surrounding quotes and fields of a CSV line) intact. Unlike ScrubPII, the
+// input doesn't need to be a single name.
+func ScrubPIIText(input string) string {
+	s := piiTextEmailRE.ReplaceAllString(input, "XXX@${suffix}")
+	s = piiTextQuotedSyncRE.ReplaceAllString(s, `${prefix}XXX"`)
+	return piiTextSyncRE.ReplaceAllString(s, "${prefix}XXX")
+}
+
 // SubexpNames returns a mapping of the sub-expression names to values if the Regexp
 // successfully matches the string, otherwise, it returns false.
 func SubexpNames(r *regexp.Regexp, s string) (bool, map[string]string) {
@@ -105,6 +124,13 @@ func MaxInt64(a int64, b int64) int64 {
 	return b
 }
 
+// ClockMs returns the unix time in ms of the given time of day on the day of t, in the location of t.
+// Values out of range are normalized, e.g. hour 25 is 1am the next day.
+func ClockMs(t time.Time, hour, min, sec int) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, hour, min, sec, 0, t.Location()).UnixNano() / int64(time.Millisecond)
+}
+
 // ParseDurationWithDays parses a duration string and returns the milliseconds. e.g. 3d1h2m
 // This is the same as Golang's time.ParseDuration, but also handles days. Assumes days are 24 hours, which is not exact but usually good enough for what we care about.
 func ParseDurationWithDays(input string) (int64, error) {
@@ -136,7 +162,12 @@ func ParseDurationWithDays(input string) (int64, error) {
 
 // RunCommand executes the given command and returns the output.
 func RunCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
+	return RunCommandContext(context.Background(), name, args...)
+}
+
+// RunCommandContext is the same as RunCommand, but kills the command if the context is done first.
+func RunCommandContext(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
 	// Stdout pipe for reading the generated output.
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
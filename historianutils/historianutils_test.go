@@ -16,6 +16,7 @@ package historianutils
 
 import (
 	"testing"
+	"time"
 )
 
 func TestScrubPII(t *testing.T) {
@@ -44,6 +45,22 @@ func TestScrubPII(t *testing.T) {
 	}
 }
 
+func TestScrubPIIText(t *testing.T) {
+	test := map[string]string{
+		"no pii here":      "no pii here",
+		"wake.lock@1a23b4": "wake.lock@1a23b4",
+		"Account {name=noogler@google.com, type=com.google}":                                         "Account {name=XXX@google.com, type=com.google}",
+		`9,hsp,2,10011,"com.android.calendar/com.google/noogler@google.com"`:                         `9,hsp,2,10011,"com.android.calendar/com.google/XXX@google.com"`,
+		`9,hsp,3,10012,"*sync*/com.app1.android.conversations/com.app1.android.account/Mr. Noogler"`: `9,hsp,3,10012,"*sync*/com.app1.android.conversations/com.app1.android.account/XXX"`,
+		"Sync *sync*/com.app2.android.provider/com.app2.android.auth.login/noogler314159 started":    "Sync *sync*/com.app2.android.provider/com.app2.android.auth.login/XXX started",
+	}
+	for in, want := range test {
+		if got := ScrubPIIText(in); got != want {
+			t.Errorf("ScrubPIIText(%s) output incorrect:\n  got: %s\n  want: %s", in, got, want)
+		}
+	}
+}
+
 func TestParseDurationWithDays(t *testing.T) {
 	tests := []struct {
 		unparsedDur string
@@ -69,3 +86,21 @@ func TestParseDurationWithDays(t *testing.T) {
 		}
 	}
 }
+
+// TestClockMs tests the conversion of times of day on a given day.
+func TestClockMs(t *testing.T) {
+	loc := time.FixedZone("an hour east of UTC", 3600)
+	day := time.Date(2017, time.February, 15, 13, 45, 0, 0, loc)
+	tests := []struct {
+		hour, min, sec int
+		want           time.Time
+	}{
+		{8, 30, 5, time.Date(2017, time.February, 15, 8, 30, 5, 0, loc)},
+		{25, 0, 0, time.Date(2017, time.February, 16, 1, 0, 0, 0, loc)},
+	}
+	for _, test := range tests {
+		if got, want := ClockMs(day, test.hour, test.min, test.sec), test.want.UnixNano()/int64(time.Millisecond); got != want {
+			t.Errorf("ClockMs(%v, %d, %d, %d) = %d, want %d", day, test.hour, test.min, test.sec, got, want)
+		}
+	}
+}
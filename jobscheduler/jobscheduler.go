@@ -0,0 +1,344 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jobscheduler reads the constraints of the registered jobs from the job scheduler service
+// dump (dumpsys jobscheduler) of a bug report, and flags the job runs (Ejb) of the battery history
+// during which a declared charging, idle or unmetered network constraint wasn't met by the device
+// state, e.g. a job requiring an unmetered network that ran while on mobile data.
+package jobscheduler
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// Metric is the CSV description of the job runs with unmet constraints.
+	Metric = "Unconstrained job"
+
+	// Constraints that can be checked against the device state in the battery history.
+	Charging  = "CHARGING"
+	Idle      = "IDLE"
+	Unmetered = "UNMETERED"
+
+	// jobSchedulerService is the name of the job scheduler service dump.
+	jobSchedulerService = "jobscheduler"
+
+	// Battery history CSV metrics used to check the constraints.
+	jobMetric      = "JobScheduler"
+	pluggedMetric  = "Plugged"
+	screenMetric   = "Screen"
+	networkMetric  = "Default network"
+	meteredNetwork = "TYPE_MOBILE"
+)
+
+// checkedConstraints are the constraints checked, in the order they are listed.
+var checkedConstraints = []string{Charging, Idle, Unmetered}
+
+var (
+	// jobRE matches the start of a registered job, with the UID and service component of the job.
+	// e.g. "  JOB #u0a45/1001: 9d5b8b6 com.google.android.gms/.gcm.nts.TaskExecutionService"
+	jobRE = regexp.MustCompile(`^\s*JOB #(?P<uid>u\d+a\d+|u\d+s\d+|\d+)/-?\d+: \S+ (?P<component>\S+)`)
+
+	// requiredRE matches the constraints required by the job.
+	// e.g. "    Required constraints: CHARGING IDLE UNMETERED"
+	requiredRE = regexp.MustCompile(`^\s*Required constraints:(?P<constraints>.*)`)
+)
+
+// Job is a registered job, with the constraints it requires.
+type Job struct {
+	// UID is the app ID of the app owning the job.
+	UID int32
+	// Component is the service of the job, e.g. "com.google.android.gms/.gcm.nts.TaskExecutionService".
+	Component string
+	// Constraints are the required constraints that can be checked, sorted.
+	Constraints []string
+}
+
+// byUIDAndComponent sorts jobs in ascending order of UID, then component.
+type byUIDAndComponent []Job
+
+func (a byUIDAndComponent) Len() int      { return len(a) }
+func (a byUIDAndComponent) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUIDAndComponent) Less(i, j int) bool {
+	if a[i].UID != a[j].UID {
+		return a[i].UID < a[j].UID
+	}
+	return a[i].Component < a[j].Component
+}
+
+// extractJobSchedulerDump returns the lines of the job scheduler service dump in the bug report.
+func extractJobSchedulerDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == jobSchedulerService
+			continue
+		}
+		if in {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	return lines
+}
+
+// Parse returns the registered jobs in the job scheduler dump of the bug report that require any
+// checked constraint, sorted by UID then component. The battery history only names jobs by service,
+// so a service scheduled with several job IDs is only given the constraints all of them require.
+func Parse(bugreport string) ([]Job, []error) {
+	var errs []error
+	type key struct {
+		uid       int32
+		component string
+	}
+	// required counts the jobs of each service requiring each constraint.
+	required := make(map[key]map[string]int)
+	counts := make(map[key]int)
+	var cur *key
+	for _, line := range extractJobSchedulerDump(bugreport) {
+		if m, result := historianutils.SubexpNames(jobRE, line); m {
+			cur = nil
+			uid, err := packageutils.AppIDFromString(result["uid"])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid job uid %q: %v", line, err))
+				continue
+			}
+			k := key{uid, result["component"]}
+			if required[k] == nil {
+				required[k] = make(map[string]int)
+			}
+			counts[k]++
+			cur = &k
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if m, result := historianutils.SubexpNames(requiredRE, line); m {
+			for _, c := range strings.Fields(result["constraints"]) {
+				required[*cur][c]++
+			}
+			// Only the first constraints line after the job line is the job's.
+			cur = nil
+		}
+	}
+
+	var jobs []Job
+	for k, n := range counts {
+		j := Job{UID: k.uid, Component: k.component}
+		for _, c := range checkedConstraints {
+			if required[k][c] == n {
+				j.Constraints = append(j.Constraints, c)
+			}
+		}
+		if len(j.Constraints) > 0 {
+			sort.Strings(j.Constraints)
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Sort(byUIDAndComponent(jobs))
+	return jobs, errs
+}
+
+// Run is a job run with constraints that weren't met for at least part of the run.
+type Run struct {
+	UID            int32
+	Component      string
+	StartMs, EndMs int64
+	// Unmet are the required constraints that weren't met, sorted.
+	Unmet []string
+}
+
+// byStart sorts runs in ascending order of start time, then UID.
+type byStart []Run
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].StartMs != a[j].StartMs {
+		return a[i].StartMs < a[j].StartMs
+	}
+	return a[i].UID < a[j].UID
+}
+
+// overlaps returns whether any of the events overlaps [startMs, endMs].
+func overlaps(events []csv.Event, startMs, endMs int64) bool {
+	for _, e := range events {
+		if e.Start < endMs && e.End > startMs {
+			return true
+		}
+	}
+	return false
+}
+
+// covered returns whether the events, which must be sorted and non overlapping, cover all of [startMs, endMs].
+func covered(events []csv.Event, startMs, endMs int64) bool {
+	cur := startMs
+	for _, e := range events {
+		if e.Start > cur {
+			break
+		}
+		if e.End > cur {
+			cur = e.End
+		}
+	}
+	return cur >= endMs
+}
+
+// Unconstrained returns the runs of the jobs in the battery history CSV generated by AnalyzeHistory,
+// with the default network added, during which any of their required constraints wasn't met: the
+// device was unplugged while the job requires charging, the screen was on while the job requires
+// the device to be idle, or the default network was mobile while the job requires an unmetered network.
+func Unconstrained(jobs []Job, historyCSV string) ([]Run, []error) {
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{jobMetric, pluggedMetric, screenMetric, networkMetric})
+	var plugged, metered []csv.Event
+	for _, e := range es[pluggedMetric] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	for _, e := range es[networkMetric] {
+		if e.Value == meteredNetwork {
+			metered = append(metered, e)
+		}
+	}
+
+	type key struct {
+		uid       int32
+		component string
+	}
+	constraints := make(map[key][]string)
+	for _, j := range jobs {
+		constraints[key{j.UID, j.Component}] = j.Constraints
+	}
+
+	var runs []Run
+	for _, e := range es[jobMetric] {
+		uid, err := packageutils.AppIDFromString(e.Opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid job uid %q: %v", e.Opt, err))
+			continue
+		}
+		k := key{uid, strings.Trim(e.Value, `"`)}
+		var unmet []string
+		for _, c := range constraints[k] {
+			switch c {
+			case Charging:
+				if !covered(plugged, e.Start, e.End) {
+					unmet = append(unmet, c)
+				}
+			case Idle:
+				if overlaps(es[screenMetric], e.Start, e.End) {
+					unmet = append(unmet, c)
+				}
+			case Unmetered:
+				if overlaps(metered, e.Start, e.End) {
+					unmet = append(unmet, c)
+				}
+			}
+		}
+		if len(unmet) > 0 {
+			runs = append(runs, Run{UID: uid, Component: k.component, StartMs: e.Start, EndMs: e.End, Unmet: unmet})
+		}
+	}
+	sort.Sort(byStart(runs))
+	return runs, errs
+}
+
+// Summary is the runs of a job with an unmet constraint.
+type Summary struct {
+	UID        int32
+	Component  string
+	Constraint string
+	Count      int
+	Duration   time.Duration
+}
+
+// byCount sorts summaries in descending order of count, then ascending order of UID, component and constraint.
+type byCount []Summary
+
+func (a byCount) Len() int      { return len(a) }
+func (a byCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byCount) Less(i, j int) bool {
+	switch {
+	case a[i].Count != a[j].Count:
+		return a[i].Count > a[j].Count
+	case a[i].UID != a[j].UID:
+		return a[i].UID < a[j].UID
+	case a[i].Component != a[j].Component:
+		return a[i].Component < a[j].Component
+	}
+	return a[i].Constraint < a[j].Constraint
+}
+
+// Summarize aggregates the runs per job and unmet constraint, sorted in descending order of count.
+func Summarize(runs []Run) []Summary {
+	type key struct {
+		uid                   int32
+		component, constraint string
+	}
+	sums := make(map[key]*Summary)
+	for _, r := range runs {
+		for _, c := range r.Unmet {
+			k := key{r.UID, r.Component, c}
+			s, ok := sums[k]
+			if !ok {
+				s = &Summary{UID: r.UID, Component: r.Component, Constraint: c}
+				sums[k] = s
+			}
+			s.Count++
+			s.Duration += time.Duration(r.EndMs-r.StartMs) * time.Millisecond
+		}
+	}
+	var res []Summary
+	for _, s := range sums {
+		res = append(res, *s)
+	}
+	sort.Sort(byCount(res))
+	return res
+}
+
+// CSV returns the runs as Metric CSV events, with the unmet constraints as value and the UID as opt,
+// so they can be seen on the timeline.
+func CSV(runs []Run) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, r := range runs {
+		csvState.Print(Metric, "service", r.StartMs, r.EndMs, fmt.Sprintf("%s: %s", r.Component, strings.Join(r.Unmet, " ")), fmt.Sprint(r.UID))
+	}
+	return b.String()
+}
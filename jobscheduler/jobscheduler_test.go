@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jobscheduler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestParse tests the parsing of the job constraints from the job scheduler dump.
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		`DUMP OF SERVICE jobscheduler:`,
+		`Registered 4 jobs:`,
+		`  JOB #u0a45/1001: 9d5b8b6 com.example.sync/.SyncJobService`,
+		`    u0a45 tag=*job*/com.example.sync/.SyncJobService`,
+		`    Required constraints: CHARGING UNMETERED`,
+		`    Satisfied constraints: CONNECTIVITY UNMETERED`,
+		`  JOB #u0a45/1002: 1a2b3c4 com.example.sync/.SyncJobService`,
+		`    Required constraints: UNMETERED`,
+		`  JOB #10050/7: 5e6f7a8 com.example.backup/.BackupJob`,
+		`    Required constraints: IDLE TIMING_DELAY`,
+		`  JOB #u0a60/3: 0f1e2d3 com.example.news/.RefreshJob`,
+		`    Required constraints: CONNECTIVITY`,
+		`DUMP OF SERVICE jobscheduler_other:`,
+		`  JOB #u0a70/1: 1111111 com.example.other/.Job`,
+		`    Required constraints: CHARGING`,
+	}, "\n")
+
+	want := []Job{
+		// Only the constraints required by both jobs of the service are kept.
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", Constraints: []string{Unmetered}},
+		{UID: 10050, Component: "com.example.backup/.BackupJob", Constraints: []string{Idle}},
+	}
+	got, errs := Parse(input)
+	if len(errs) > 0 {
+		t.Fatalf("Parse(%s) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%s)\n got: %+v\n want: %+v", input, got, want)
+	}
+}
+
+// TestUnconstrained tests the flagging of the job runs with unmet constraints, and the per job summaries.
+func TestUnconstrained(t *testing.T) {
+	jobs := []Job{
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", Constraints: []string{Charging, Unmetered}},
+		{UID: 10050, Component: "com.example.backup/.BackupJob", Constraints: []string{Idle}},
+	}
+	historyCSV := strings.Join([]string{
+		csv.FileHeader,
+		`Plugged,bool,0,10000,true,`,
+		`Plugged,bool,10000,30000,false,`,
+		`Screen,bool,20000,25000,true,`,
+		`Default network,string,0,15000,TYPE_WIFI,`,
+		`Default network,string,15000,30000,TYPE_MOBILE,`,
+		// All constraints met.
+		`JobScheduler,service,1000,5000,"com.example.sync/.SyncJobService",10045`,
+		// Unplugged for part of the run.
+		`JobScheduler,service,8000,12000,"com.example.sync/.SyncJobService",10045`,
+		// Unplugged and on mobile data.
+		`JobScheduler,service,16000,18000,"com.example.sync/.SyncJobService",1010045`,
+		`JobScheduler,service,19000,21000,"com.example.backup/.BackupJob",10050`,
+		`JobScheduler,service,26000,28000,"com.example.backup/.BackupJob",10050`,
+		// No constraints known.
+		`JobScheduler,service,16000,18000,"com.example.news/.RefreshJob",10060`,
+	}, "\n")
+
+	wantRuns := []Run{
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", StartMs: 8000, EndMs: 12000, Unmet: []string{Charging}},
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", StartMs: 16000, EndMs: 18000, Unmet: []string{Charging, Unmetered}},
+		{UID: 10050, Component: "com.example.backup/.BackupJob", StartMs: 19000, EndMs: 21000, Unmet: []string{Idle}},
+	}
+	runs, errs := Unconstrained(jobs, historyCSV)
+	if len(errs) > 0 {
+		t.Fatalf("Unconstrained(%v, %s) generated unexpected errors: %v", jobs, historyCSV, errs)
+	}
+	if !reflect.DeepEqual(runs, wantRuns) {
+		t.Errorf("Unconstrained(%v, %s)\n got: %+v\n want: %+v", jobs, historyCSV, runs, wantRuns)
+	}
+
+	wantSummaries := []Summary{
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", Constraint: Charging, Count: 2, Duration: 6 * time.Second},
+		{UID: 10045, Component: "com.example.sync/.SyncJobService", Constraint: Unmetered, Count: 1, Duration: 2 * time.Second},
+		{UID: 10050, Component: "com.example.backup/.BackupJob", Constraint: Idle, Count: 1, Duration: 2 * time.Second},
+	}
+	if got := Summarize(runs); !reflect.DeepEqual(got, wantSummaries) {
+		t.Errorf("Summarize(%v)\n got: %+v\n want: %+v", runs, got, wantSummaries)
+	}
+
+	wantCSV := strings.Join([]string{
+		`Unconstrained job,service,8000,12000,com.example.sync/.SyncJobService: CHARGING,10045`,
+		`Unconstrained job,service,16000,18000,com.example.sync/.SyncJobService: CHARGING UNMETERED,10045`,
+		`Unconstrained job,service,19000,21000,com.example.backup/.BackupJob: IDLE,10050`,
+	}, "\n") + "\n"
+	if got := CSV(runs); got != wantCSV {
+		t.Errorf("CSV(%v)\n got: %q\n want: %q", runs, got, wantCSV)
+	}
+
+	if got, errs := Unconstrained(nil, historyCSV); got != nil || errs != nil {
+		t.Errorf("Unconstrained(nil, %s) = %v, %v, want nil, nil", historyCSV, got, errs)
+	}
+}
@@ -491,8 +491,14 @@ historian.Bars.prototype.renderLabels_ = function() {
         if (group.name in historian.metrics.logcatMetrics) {
           return group.name + ' (logcat)';
         }
+        var resolutionMs = this.downsampledMs_(group);
+        if (resolutionMs) {
+          // The pre-aggregated resolutions are whole minutes.
+          var mins = Math.round(resolutionMs / historian.time.MSECS_IN_MIN);
+          return group.name + ' (' + mins + ' min avg)';
+        }
         return group.name;
-      })
+      }.bind(this))
       .on('mouseover', function(group) { showLegend(group); })
       .on('mouseout', function(group) { hideLegend(); })
       .call(this.drag_);
@@ -564,6 +570,26 @@ historian.Bars.prototype.renderLabels_ = function() {
 };
 
 
+/**
+ * Returns the coarsest resolution the series of the group are averaged over
+ * at the current zoom level, or 0 if they're rendered at full resolution.
+ * @param {!historian.SeriesGroup} group The group.
+ * @return {number}
+ * @private
+ */
+historian.Bars.prototype.downsampledMs_ = function(group) {
+  var msPerPixel = this.context_.msPerPixel();
+  var resolutionMs = 0;
+  group.series.forEach(function(series) {
+    var downsampled = historian.data.downsampledValues(series, msPerPixel);
+    if (downsampled && downsampled.resolutionMs > resolutionMs) {
+      resolutionMs = downsampled.resolutionMs;
+    }
+  });
+  return resolutionMs;
+};
+
+
 /**
  * Renders the bars for each series.
  * @param {!Array<!historian.SeriesGroup>} data The array of series to render.
@@ -869,6 +895,7 @@ historian.Bars.prototype.updateSeries_ = function() {
 
   var uid = this.getSelectedUid_();
   var timeRange = this.context_.getViewableTimeRange();
+  var msPerPixel = this.context_.msPerPixel();
   var filteredData = [];
   var regexp = /** @type {string} */ (
       this.container_.find(REGEXP_FILTER_).val());
@@ -876,8 +903,10 @@ historian.Bars.prototype.updateSeries_ = function() {
   this.groupsToRender_.forEach(function(seriesGroup) {
     var allSeries = [];
     seriesGroup.series.forEach(function(series) {
-      var values = historian.utils.inTimeRange(
-          timeRange.min, timeRange.max, series.values);
+      // Dense series are rendered averaged when zoomed out.
+      var downsampled = historian.data.downsampledValues(series, msPerPixel);
+      var values = historian.utils.inTimeRange(timeRange.min, timeRange.max,
+          downsampled ? downsampled.values : series.values);
       if (series.type != historian.metrics.UNAVAILABLE_TYPE && regexp &&
           historian.utils.isValidRegExp(regexp)) {
         values = this.filterByRegexp_(series.name, values, regexp);
@@ -893,7 +922,8 @@ historian.Bars.prototype.updateSeries_ = function() {
         color: series.color,
         values: values,
         originalValues: series.originalValues,
-        cluster: series.cluster
+        cluster: series.cluster,
+        downsampledMs: downsampled ? downsampled.resolutionMs : undefined
       });
     }, this);
     filteredData.push({
@@ -1311,6 +1341,7 @@ historian.Bars.prototype.valuesToTable_ = function(series, values, cluster) {
   // CPU_RUNNING and KERNEL_UPTIME have an extra column.
   if (series.name == historian.metrics.Csv.CPU_RUNNING) {
     headRow.push('Timestamps when wakeup reason was recorded');
+    headRow.push('Cause chains');
   } else if (series.name == historian.metrics.KERNEL_UPTIME) {
     headRow.push('Source CPU running event caused by userspace');
   }
@@ -1361,6 +1392,14 @@ historian.Bars.prototype.valuesToTable_ = function(series, values, cluster) {
         return historian.time.getTime(t.endTime, this.context_.location);
       }.bind(this)).join(', ');  // Comma separated wakeup reason times.
       tblRow.push(formattedTimes);
+      // Distinct cause chains of the running entries with the wakeup reason.
+      var causes = [];
+      wakeupReasonTimes.forEach(function(t) {
+        if (t.cause && causes.indexOf(t.cause) == -1) {
+          causes.push(t.cause);
+        }
+      });
+      tblRow.push(causes.join(', '));
 
     } else if (series.name == historian.metrics.KERNEL_UPTIME) {
       var hasUserspace = clusterValue.value.wakelockCategory ==
@@ -229,6 +229,12 @@ historian.color.colorMap_[
     goog.functions.constant('black');
 
 
+/** @private {function(string): string} */
+historian.color.colorMap_[
+    historian.metrics.Csv.SUSPEND_ABORT] =
+    goog.functions.constant('#ff8c00');
+
+
 /** @private {function(string): string} */
 historian.color.colorMap_[
     historian.metrics.KERNEL_UPTIME] = d3.scaleOrdinal()
@@ -434,6 +440,9 @@ historian.color.valueFormatter = function(metric, v, opt_shortForm) {
     case historian.metrics.Csv.COULOMB_CHARGE:
       // Units are in mAh.
       return {value: goog.string.subs('%s mAh', v)};
+    case historian.metrics.Csv.CHARGING_CURRENT:
+      // Same precision as the CSV, which is printed with 3 decimals.
+      return {value: goog.string.subs('%s mA', v.toFixed(3))};
     case historian.metrics.Csv.ACTIVE_BROADCAST_BACKGROUND:
     case historian.metrics.Csv.ACTIVE_BROADCAST_FOREGROUND:
     case historian.metrics.Csv.BROADCAST_ENQUEUE_BACKGROUND:
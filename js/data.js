@@ -63,6 +63,7 @@ goog.require('historian.utils');
  *     or a string (for bool, string or service series).
  * id: Unique number corresponding to the original entry.
  *     Only exists for entries which are part of an AggregatedEntry.
+ * cause: The readable cause chain of a CPU running wake up reason.
  *
  * @typedef {{
  *   startTime: number,
@@ -71,6 +72,7 @@ goog.require('historian.utils');
  *   id: (number|undefined),
  *   uid: (number|undefined),
  *   opt: (string|undefined),
+ *   cause: (string|undefined),
  *   unknownEndTime: (boolean|undefined),
  *   duringScreenOff: (boolean|undefined)
  * }}
@@ -186,6 +188,10 @@ historian.SeriesGroup;
  *     aggregated series.
  * color: A function that maps a value to a color.
  * cluster: Whether clustering should be applied to the metric.
+ * downsampled: The data points averaged over coarser time resolutions, in
+ *     increasing order of resolution. Only populated for dense numeric series.
+ * downsampledMs: The resolution of the averaged data points in values. Only
+ *     populated if zoomed out enough for them to be rendered.
  *
  * @typedef {{
  *   name: string,
@@ -194,12 +200,25 @@ historian.SeriesGroup;
  *   values: !Array<(!historian.Entry|!historian.AggregatedEntry)>,
  *   originalValues: (Array<!historian.Entry>|undefined),
  *   color: (function(string): string | undefined),
- *   cluster: boolean
+ *   cluster: boolean,
+ *   downsampled: (Array<!historian.data.Downsampled>|undefined),
+ *   downsampledMs: (number|undefined)
  * }}
  */
 historian.SeriesData;
 
 
+/**
+ * The data points of a series averaged over buckets of the given resolution.
+ *
+ * @typedef {{
+ *   resolutionMs: number,
+ *   values: !Array<!historian.Entry>
+ * }}
+ */
+historian.data.Downsampled;
+
+
 /**
  * The clustered data for a series.
  *
@@ -211,7 +230,8 @@ historian.SeriesData;
  *   originalValues: (Array<!historian.Entry>|undefined),
  *   index: number,
  *   color: (function(string): string | undefined),
- *   cluster: boolean
+ *   cluster: boolean,
+ *   downsampledMs: (number|undefined)
  * }}
  */
 historian.ClusteredSeriesData;
@@ -416,6 +436,14 @@ historian.data.processHistorianV2Data = function(logs, deviceCapacity,
     historian.data.addEntry(allSeries, d, data.logToExtent);
   });
 
+  // The dense numeric series have versions averaged over coarser resolutions,
+  // which are rendered instead when zoomed out.
+  logs.forEach(function(log) {
+    (log.downsampled || []).forEach(function(downsampled) {
+      historian.data.addDownsampled_(allSeries, log.source, downsampled);
+    });
+  });
+
   var running = /** @type {!historian.SeriesData} */ (
       allSeries.getBatteryHistoryData(historian.metrics.Csv.CPU_RUNNING));
   if (running) {
@@ -437,6 +465,13 @@ historian.data.processHistorianV2Data = function(logs, deviceCapacity,
     allSeries.add(kernelUptimeSeries);
   }
 
+  var aborts = /** @type {!historian.SeriesData} */ (
+      allSeries.getBatteryHistoryData(historian.metrics.Csv.SUSPEND_ABORT));
+  if (aborts) {
+    // The suspend abort entries have the same wakeup reasons as running.
+    aborts.values = historian.data.splitRunningValues_(aborts);
+  }
+
   var sysuiAction = allSeries.get(historian.historianV2Logs.Sources.EVENT_LOG,
       historian.metrics.Csv.SYSUI_ACTION);
   if (sysuiAction) {
@@ -879,6 +914,63 @@ historian.data.addEntry = function(allSeries, entryInfo, logToExtent) {
 };
 
 
+/**
+ * Adds the data points of a CSV pre-aggregated to a coarser resolution to the
+ * downsampled values of the matching series of the log.
+ *
+ * @param {!historian.metrics.DataHasher} allSeries The series of the logs.
+ * @param {!historian.historianV2Logs.Sources} source The log source.
+ * @param {{resolutionMs: number, csv: string}} downsampled The CSV.
+ * @private
+ */
+historian.data.addDownsampled_ = function(allSeries, source, downsampled) {
+  var nameToValues = {};
+  d3.csvParse(downsampled.csv).forEach(function(d) {
+    var name = d['metric'];
+    if (!(name in nameToValues)) {
+      nameToValues[name] = [];
+    }
+    nameToValues[name].push({
+      startTime: parseInt(d['start_time'], 10),
+      endTime: parseInt(d['end_time'], 10),
+      value: parseFloat(d['value'])
+    });
+  });
+  for (var name in nameToValues) {
+    var series = allSeries.get(source, name);
+    if (!series) {
+      continue;
+    }
+    series.downsampled = series.downsampled || [];
+    series.downsampled.push({
+      resolutionMs: downsampled.resolutionMs,
+      values: nameToValues[name]
+    });
+  }
+};
+
+
+/**
+ * Returns the coarsest averaged version of the series with buckets no wider
+ * than a pixel at the current zoom level, so no visible detail is lost, or
+ * null if the series should be rendered at full resolution.
+ *
+ * @param {!historian.SeriesData} series The series.
+ * @param {number} msPerPixel The duration of a pixel at the zoom level.
+ * @return {?historian.data.Downsampled}
+ */
+historian.data.downsampledValues = function(series, msPerPixel) {
+  var chosen = null;
+  (series.downsampled || []).forEach(function(d) {
+    // The versions are in increasing order of resolution.
+    if (d.resolutionMs <= msPerPixel) {
+      chosen = d;
+    }
+  });
+  return chosen;
+};
+
+
 /**
  * Creates a 0 ms entry for each entry in the given series data.
  * Used to show a series of ticks (e.g. for battery level).
@@ -944,10 +1036,34 @@ historian.data.splitCPUUsage_ = function(value) {
 };
 
 
+/**
+ * Returns the readable cause chain in the opt field of a running entry, e.g.
+ * "Abort:wlan -> NlpWakeLock -> Alarm: com.google.android.gms", with '?' for
+ * the unknown links. The opt field is a JSON object with the wakeupReason,
+ * wakelock, appMetric and appEvent fields, or the attributed UID in reports
+ * generated without cause chains, for which an empty string is returned.
+ *
+ * @param {string|undefined} opt The opt field of the running entry.
+ * @return {string} The readable cause chain.
+ * @private
+ */
+historian.data.formatRunningCause_ = function(opt) {
+  if (!opt || opt.charAt(0) != '{') {
+    return '';
+  }
+  var cause = JSON.parse(opt);
+  var app = cause.appEvent ? cause.appMetric + ': ' + cause.appEvent : '';
+  return [cause.wakeupReason, cause.wakelock, app].map(function(link) {
+    return link || '?';
+  }).join(' -> ');
+};
+
+
 /**
  * Each entry in the running metric can have multiple wake up reasons.
  * For each entry, convert the pipe delimited string of wake up reasons
- * into an array of wake up reasons.
+ * into an array of wake up reasons. Each wake up reason carries the readable
+ * cause chain of its entry, if any.
  *
  * @param {historian.SeriesData} running The running metric.
  * @return {!Array<!historian.AggregatedEntry>} The split running metric values.
@@ -959,6 +1075,7 @@ historian.data.splitRunningValues_ = function(running) {
   running.values.forEach(function(r) {
     var values = r.value.split('|');
     var processed = [];
+    var cause = historian.data.formatRunningCause_(r.opt);
 
     values.forEach(function(v) {
       // Each value is of the format startTime~endTime~wakeupreason OR
@@ -978,7 +1095,8 @@ historian.data.splitRunningValues_ = function(running) {
       processed.push({
         startTime: parseInt(startTime, 10),
         endTime: parseInt(endTime, 10),
-        value: reason
+        value: reason,
+        cause: cause
       });
     });
     split.push({
@@ -1326,7 +1444,8 @@ historian.data.cluster = function(seriesData, minDuration) {
         originalValues: series.originalValues,
         color: series.color,
         cluster: series.cluster,
-        source: series.source
+        source: series.source,
+        downsampledMs: series.downsampledMs
       });
     });
   });
@@ -1356,8 +1475,9 @@ historian.data.clusterSingle_ = function(series, minDuration) {
   // Entries in the CPU_RUNNING metric can have multiple wake up reasons
   // per entry stored in a services array. This will increase the cluster
   // count by the number of wake up reasons, but we only want to count each
-  // entry as one instance of CPU_RUNNING.
-  var forceSingleCount = (series.name == historian.metrics.Csv.CPU_RUNNING);
+  // entry as one instance of CPU_RUNNING. The same goes for SUSPEND_ABORT.
+  var forceSingleCount = (series.name == historian.metrics.Csv.CPU_RUNNING ||
+      series.name == historian.metrics.Csv.SUSPEND_ABORT);
 
   // Skip blank entries.
   while (startIndex < series.values.length &&
@@ -649,6 +649,48 @@ testSuite({
     var cluster = clusteredSeries.values[0];
     assertEquals('expected single cluster count', 1, cluster.clusteredCount);
   },
+  /**
+   * Tests that the averaged versions of dense series are only used when each
+   * of their buckets spans no more than a pixel.
+   */
+  testDownsampledValues: function() {
+    var header = 'metric,type,start_time,end_time,value,opt\n';
+    var logs = [{
+      source: historianV2Logs.Sources.BATTERY_HISTORY,
+      csv: header +
+          'CPU busy fraction,float,0,30000,0.2,\n' +
+          'CPU busy fraction,float,30000,60000,0.4,\n',
+      downsampled: [
+        {
+          resolutionMs: 60000,
+          csv: header + 'CPU busy fraction,float,0,60000,0.300,\n'
+        },
+        {
+          resolutionMs: 600000,
+          csv: header + 'CPU busy fraction,float,0,60000,0.300,\n'
+        }
+      ]
+    }];
+    var testData = data.processHistorianV2Data(logs, 2300, {}, '', false);
+    var group = testData.barGroups.getBatteryHistoryData(
+        Csv.CPU_BUSY_FRACTION);
+    assertNotNull(group);
+    var series = group.series[0];
+
+    assertNull('full resolution', data.downsampledValues(series, 1000));
+    var tests = [
+      {msPerPixel: 60000, resolutionMs: 60000},
+      {msPerPixel: 300000, resolutionMs: 60000},
+      {msPerPixel: 600000, resolutionMs: 600000}
+    ];
+    tests.forEach(function(t) {
+      var got = data.downsampledValues(series, t.msPerPixel);
+      assertEquals(t.msPerPixel + ' ms per pixel', t.resolutionMs,
+          got.resolutionMs);
+      assertObjectEquals([{startTime: 0, endTime: 60000, value: 0.3}],
+          got.values);
+    });
+  },
   /**
    * Tests the sampling of entries for a metric.
    */
@@ -156,7 +156,10 @@ historian.singleView_ = [
     container: '#historian-v2',
     barOrder: historian.metrics.BATTERY_HISTORY_ORDER,
     barHidden: historian.metrics.BATTERY_HISTORY_HIDDEN,
-    logSources: [historian.historianV2Logs.Sources.BATTERY_HISTORY],
+    logSources: [
+      historian.historianV2Logs.Sources.BATTERY_HISTORY,
+      historian.historianV2Logs.Sources.POWER_RAILS
+    ],
     logSourcesHidden: [],
     defaultXExtentLogs: [
       historian.historianV2Logs.Sources.BATTERY_HISTORY,
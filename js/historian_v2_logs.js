@@ -20,8 +20,14 @@ goog.module.declareLegacyNamespace();
 
 /**
  * The CSV data, the name of the log source it was constructed from,
- * and optionally the start time of the log.
- * @typedef {{source: !Sources, csv: string, startMs: (number|undefined)}}
+ * optionally the start time of the log, and for dense logs, versions of the
+ * CSV pre-aggregated to coarser resolutions for zoomed out views.
+ * @typedef {{
+ *   source: !Sources,
+ *   csv: string,
+ *   startMs: (number|undefined),
+ *   downsampled: (?Array<{resolutionMs: number, csv: string}>|undefined)
+ * }}
  */
 exports.Log;
 
@@ -39,6 +45,7 @@ var Sources = {
   KERNEL_TRACE: 'Kernel Trace',
   LAST_LOGCAT: 'Last Logcat',
   POWER_MONITOR: 'Power Monitor',
+  POWER_RAILS: 'Power Rails',
   SYSTEM_LOG: 'System',
   WEARABLE: 'Wearable',
 
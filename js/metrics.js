@@ -90,27 +90,46 @@ historian.metrics.Csv = {
   BATTERY_LEVEL: 'Battery Level',
   BRIGHTNESS: 'Brightness',
   COULOMB_CHARGE: 'Coulomb charge',
+  CPU_BUSY_FRACTION: 'CPU busy fraction',
+  CPU_IOWAIT_FRACTION: 'CPU iowait fraction',
   POWER_MONITOR: 'Power Monitor (mA)',
   POWER_MONITOR_MW: 'Power Monitor (mW)',
   POWER_MONITOR_MAH: 'Power Monitor (cumulative mAh)',
   // Group name for power monitor metrics.
   POWER_MONITOR_MA_MW_GROUP: 'Power Monitor mA / mW [group]',
   POWER_MONITOR_MA_MAH_GROUP: 'Power Monitor mA / cumulative mAh [group]',
+  STEPS: 'Steps',
+  SUSPEND_EFFICIENCY: 'Suspend efficiency',
   TEMPERATURE: 'Temperature',
   VOLTAGE: 'Voltage',
 
+  // Float metrics
+  CHARGING_CURRENT: 'Charging current (mA)',
+
   // String metrics
+  APP_ERRORS: 'App crash / ANR',
+  CHARGING_PHASE: 'Charging phase',
   CHARGING_STATUS: 'Charging status',
   DATA_CONNECTION: 'Mobile network type',
+  DEFAULT_NETWORK: 'Default network',
   HEALTH: 'Health',
   IDLE_MODE_ON: 'Doze',
+  MARKER: 'Marker',
+  ON_BODY: 'On body',
+  PARKED_SESSION: 'Parked session',
   PHONE_STATE: 'Phone state',
   PLUG_TYPE: 'Plug',
   SIGNAL_STRENGTH: 'Mobile signal strength',
+  SIM_STATE: 'SIM state',
+  STEP_FINGERPRINT: 'Battery step fingerprint',
+  SYSTEM_UPDATE: 'System update',
+  TETHERING: 'Tethering',
+  USB: 'USB',
   WIFI_SIGNAL_STRENGTH: 'Wifi signal strength',
   WIFI_SUPPLICANT: 'Wifi supplicant',
 
   // Bool metrics
+  AIRPLANE_MODE: 'Airplane mode',
   AUDIO: 'Audio',
   BLE_SCANNING: 'BLE scanning',
   CAMERA: 'Camera',
@@ -120,7 +139,9 @@ historian.metrics.Csv = {
   FLASHLIGHT: 'Flashlight on',
   GPS_ON: 'GPS',
   LOW_POWER_MODE: 'Battery Saver',
+  MAINTENANCE_WINDOW: 'Doze maintenance window',
   MOBILE_RADIO_ON: 'Mobile radio active',
+  NO_CONNECTIVITY: 'No connectivity',
   PHONE_IN_CALL: 'Phone call',
   PHONE_SCANNING: 'Phone scanning',
   PLUGGED: 'Plugged',
@@ -128,6 +149,8 @@ historian.metrics.Csv = {
   SCREEN_ON: 'Screen',
   SENSOR_ON: 'Sensor',
   SIGNIFICANT_MOTION: 'Significant motion',
+  SUSPEND_ABORT: 'CPU running (suspend abort)',
+  USB_DATA: 'USB data',
   VIDEO: 'Video',
   WIFI_FULL_LOCK: 'Wifi full lock',
   WIFI_MULTICAST_ON: 'Wifi multicast',
@@ -139,9 +162,14 @@ historian.metrics.Csv = {
   // Service metrics
   ACTIVE_PROCESS: 'Active process',
   APPLICATION_PROCESSOR_WAKEUP: 'App Processor wakeup',
+  APP_INACTIVE: 'App inactive',
+  AUDIO_APP: 'Audio app',
   CONNECTIVITY: 'Network connectivity',
+  CPU_RUNNING_APP: 'CPU running app',
   FOREGROUND_PROCESS: 'Foreground process',
+  GPS_REQUEST: 'GPS request',
   LONG_WAKELOCK: 'Long Wakelocks',
+  MOBILE_RADIO_APP: 'Mobile radio active app',
   PACKAGE_ACTIVE: 'Package active',
   PACKAGE_INACTIVE: 'Package inactive',
   PACKAGE_INSTALL: 'Package install',
@@ -150,6 +178,8 @@ historian.metrics.Csv = {
   SYNC_APP: 'SyncManager',
   TMP_WHITE_LIST: 'Temp White List',
   TOP_APPLICATION: 'Top app',
+  UNCONSTRAINED_JOB: 'Unconstrained job',
+  VOIP_CALL: 'VoIP call',
   WAKE_LOCK_HELD: 'Partial wakelock',
   WAKELOCK_IN: 'Wakelock_in',
 
@@ -159,6 +189,7 @@ historian.metrics.Csv = {
   // Logcat metrics
   BACKGROUND_COMPILATION: 'dex2oat',
   BATTERY_TEST_UTIL: 'BatteryTestUtil',
+  BLE_ADVERTISING: 'BLE advertising',
   BLUETOOTH_SCAN: 'Bluetooth Scan',
   CHOREOGRAPHER_SKIPPED: 'Choreographer (skipped frames)',
   CRASHES: 'Crashes',
@@ -608,8 +639,18 @@ historian.metrics.BATTERY_HISTORY_ORDER = [].concat(
     historian.metrics.makeGroupProperties(
         historian.historianV2Logs.Sources.BATTERY_HISTORY,
         [
+          historian.metrics.Csv.MARKER,
+          historian.metrics.Csv.STEP_FINGERPRINT,
+          historian.metrics.Csv.SUSPEND_EFFICIENCY,
+          historian.metrics.Csv.CPU_BUSY_FRACTION,
+          historian.metrics.Csv.CPU_IOWAIT_FRACTION,
           historian.metrics.Csv.REBOOT,
+          historian.metrics.Csv.SYSTEM_UPDATE,
+          historian.metrics.Csv.PARKED_SESSION,
+          historian.metrics.Csv.APP_ERRORS,
           historian.metrics.Csv.CPU_RUNNING,
+          historian.metrics.Csv.CPU_RUNNING_APP,
+          historian.metrics.Csv.SUSPEND_ABORT,
           historian.metrics.Csv.APPLICATION_PROCESSOR_WAKEUP
         ]
     ),
@@ -652,21 +693,30 @@ historian.metrics.BATTERY_HISTORY_ORDER = [].concat(
           // Battery saver and Doze
           historian.metrics.Csv.LOW_POWER_MODE,
           historian.metrics.Csv.IDLE_MODE_ON,
+          historian.metrics.Csv.MAINTENANCE_WINDOW,
           historian.metrics.Csv.DEVICE_ACTIVE,
           historian.metrics.Csv.SIGNIFICANT_MOTION,
+          historian.metrics.Csv.ON_BODY,
+          historian.metrics.Csv.STEPS,
           historian.metrics.Csv.SCHEDULED_JOB,
+          historian.metrics.Csv.UNCONSTRAINED_JOB,
           historian.metrics.Csv.SYNC_APP,
           historian.metrics.Csv.TMP_WHITE_LIST,
 
           historian.metrics.Csv.PHONE_IN_CALL,
+          historian.metrics.Csv.VOIP_CALL,
           historian.metrics.Csv.GPS_ON,
+          historian.metrics.Csv.GPS_REQUEST,
           historian.metrics.Csv.SENSOR_ON
         ]
     ),
-    {
-      source: historian.historianV2Logs.Sources.SYSTEM_LOG,
-      name: historian.metrics.Csv.BLUETOOTH_SCAN
-    },
+    historian.metrics.makeGroupProperties(
+        historian.historianV2Logs.Sources.SYSTEM_LOG,
+        [
+          historian.metrics.Csv.BLUETOOTH_SCAN,
+          historian.metrics.Csv.BLE_ADVERTISING
+        ]
+    ),
     historian.metrics.makeGroupProperties(
         historian.historianV2Logs.Sources.BATTERY_HISTORY,
         [
@@ -674,9 +724,15 @@ historian.metrics.BATTERY_HISTORY_ORDER = [].concat(
           historian.metrics.Csv.BLE_SCANNING,
           historian.metrics.Csv.PHONE_SCANNING,
           historian.metrics.Csv.PHONE_STATE,
+          historian.metrics.Csv.AIRPLANE_MODE,
+          historian.metrics.Csv.SIM_STATE,
           historian.metrics.Csv.CONNECTIVITY,
+          historian.metrics.Csv.DEFAULT_NETWORK,
+          historian.metrics.Csv.NO_CONNECTIVITY,
+          historian.metrics.Csv.TETHERING,
           historian.metrics.Csv.DATA_CONNECTION,
           historian.metrics.Csv.MOBILE_RADIO_ON,
+          historian.metrics.Csv.MOBILE_RADIO_APP,
           historian.metrics.Csv.SIGNAL_STRENGTH,
 
           // WiFi related
@@ -690,6 +746,7 @@ historian.metrics.BATTERY_HISTORY_ORDER = [].concat(
           historian.metrics.Csv.WIFI_ON,
 
           historian.metrics.Csv.AUDIO,
+          historian.metrics.Csv.AUDIO_APP,
           historian.metrics.Csv.FLASHLIGHT,
           historian.metrics.Csv.CAMERA,
           historian.metrics.Csv.VIDEO,
@@ -700,12 +757,17 @@ historian.metrics.BATTERY_HISTORY_ORDER = [].concat(
           historian.metrics.Csv.PACKAGE_UNINSTALL,
           historian.metrics.Csv.PACKAGE_ACTIVE,
           historian.metrics.Csv.PACKAGE_INACTIVE,
+          historian.metrics.Csv.APP_INACTIVE,
 
           historian.metrics.Csv.BATTERY_LEVEL,
           historian.metrics.Csv.COULOMB_CHARGE,
+          historian.metrics.Csv.CHARGING_CURRENT,
+          historian.metrics.Csv.CHARGING_PHASE,
           historian.metrics.Csv.TEMPERATURE,
           historian.metrics.Csv.PLUGGED,
-          historian.metrics.Csv.CHARGING_ON
+          historian.metrics.Csv.CHARGING_ON,
+          historian.metrics.Csv.USB,
+          historian.metrics.Csv.USB_DATA
         ]
     ),
     {
@@ -845,6 +907,7 @@ historian.metrics.isBuckettedLevelGroup = function(groupName) {
 historian.metrics.expectedStrings = {
   // These are the values found in the CSV. Formatting will be applied
   // before being displayed as y-axis labels.
+  [historian.metrics.Csv.CHARGING_PHASE]: ['trickle', 'taper', 'fast'],
   [historian.metrics.Csv.CHARGING_STATUS]: ['?', 'n', 'd', 'c', 'f'],
   [historian.metrics.Csv.DATA_CONNECTION]: [
     'none', '1xrtt', 'cdma', 'edge', 'ehrpd', 'evdo_0',
@@ -853,6 +916,7 @@ historian.metrics.expectedStrings = {
   ],
   [historian.metrics.Csv.HEALTH]: ['?', 'g', 'h', 'd', 'v', 'c', 'f'],
   [historian.metrics.Csv.IDLE_MODE_ON]: ['???', 'off', 'light', 'full'],
+  [historian.metrics.Csv.ON_BODY]: ['off', 'on'],
   [historian.metrics.Csv.PHONE_STATE]: ['off', 'em', 'out', 'in'],
   [historian.metrics.Csv.PLUG_TYPE]: ['n', 'w', 'u', 'a'],
   [historian.metrics.Csv.SIGNAL_STRENGTH]:  // Mobile signal strength
@@ -904,6 +968,7 @@ historian.metrics.METRICS_TO_AGGREGATE_ = [
   historian.metrics.Csv.KERNEL_WAKESOURCE,
   historian.metrics.Csv.LONG_WAKELOCK,
   historian.metrics.Csv.SCHEDULED_JOB,
+  historian.metrics.Csv.UNCONSTRAINED_JOB,
   historian.metrics.Csv.SYNC_APP,
   historian.metrics.Csv.WAKELOCK_IN,
   historian.metrics.Csv.WEARABLE_TRANSPORT
@@ -924,17 +989,24 @@ historian.metrics.appSpecificMetrics = {};
  */
 historian.metrics.APP_SPECIFIC_METRICS_ = [
   historian.metrics.Csv.APPLICATION_PROCESSOR_WAKEUP,
+  historian.metrics.Csv.AUDIO_APP,
+  historian.metrics.Csv.CPU_RUNNING_APP,
+  historian.metrics.Csv.VOIP_CALL,
+  historian.metrics.Csv.GPS_REQUEST,
+  historian.metrics.Csv.MOBILE_RADIO_APP,
   historian.metrics.Csv.SYNC_APP,
   historian.metrics.Csv.FOREGROUND_PROCESS,
   historian.metrics.Csv.LONG_WAKELOCK,
   historian.metrics.Csv.WAKELOCK_IN,
   historian.metrics.Csv.TOP_APPLICATION,
   historian.metrics.Csv.SCHEDULED_JOB,
+  historian.metrics.Csv.UNCONSTRAINED_JOB,
   historian.metrics.Csv.TMP_WHITE_LIST,
   historian.metrics.Csv.PACKAGE_INSTALL,
   historian.metrics.Csv.PACKAGE_UNINSTALL,
   historian.metrics.Csv.PACKAGE_ACTIVE,
   historian.metrics.Csv.PACKAGE_INACTIVE,
+  historian.metrics.Csv.APP_INACTIVE,
   historian.metrics.Csv.ACTIVE_BROADCAST_BACKGROUND,
   historian.metrics.Csv.ACTIVE_BROADCAST_FOREGROUND,
   historian.metrics.Csv.BROADCAST_ENQUEUE_FOREGROUND,
@@ -944,6 +1016,7 @@ historian.metrics.APP_SPECIFIC_METRICS_ = [
   historian.metrics.Csv.AM_PROC_START,
   historian.metrics.Csv.AM_PROC_DIED,
   historian.metrics.Csv.AM_ANR,
+  historian.metrics.Csv.BLE_ADVERTISING,
   historian.metrics.Csv.BLUETOOTH_SCAN,
   historian.metrics.Csv.CHOREOGRAPHER_SKIPPED,
   historian.metrics.Csv.CRASHES,
@@ -1030,6 +1103,7 @@ historian.metrics.logcatMetrics = {};
  */
 historian.metrics.LOGCAT_METRICS_ = [
   historian.metrics.Csv.CRASHES,
+  historian.metrics.Csv.BLE_ADVERTISING,
   historian.metrics.Csv.BLUETOOTH_SCAN
 ];
 
@@ -1165,6 +1239,10 @@ historian.metrics.initMetrics = function(systemUiDecoder) {
       'is attributed to kernel only uptime. This metric is generated by ' +
       'comparing CPU running and Userspace wakelock events and is not ' +
       'present in the battery history log.';
+  historian.metrics.descriptors[historian.metrics.Csv.STEPS] =
+      'Steps taken between the step counter events kept by the sensor ' +
+      'service. Only the last few events are kept, so the steps are only ' +
+      'shown for the hours before the bug report was taken.';
 };
 
 
@@ -39,7 +39,8 @@ goog.forwardDeclare('batterystats.BatteryStats');
  *   fileName: string,
  *   location: string,
  *   overflowMs: number,
- *   isDiff: boolean
+ *   isDiff: boolean,
+ *   unsupported: ?Object
  * }}
  */
 var UploadResponse;
@@ -33,10 +33,79 @@ historian.upload.fileEntries_ = [
   'bugreport',
   'bugreport2',
   'kernel',
-  'powermonitor'
+  'markers',
+  'powermonitor',
+  'powerrails'
 ];
 
 
+/** @private @const {number} */
+historian.upload.PROGRESS_POLL_INTERVAL_MS_ = 1000;
+
+
+/** @private @const {!Object<string, string>} */
+historian.upload.progressStages_ = {
+  'upload': 'Uploading Complete!',
+  'history': 'Analyzing history',
+  'summaries': 'Summarizing...',
+  'response': 'Generating results...'
+};
+
+
+/**
+ * The ID of the progress of the analysis of the current upload.
+ * @private {string}
+ */
+historian.upload.progressId_ = '';
+
+
+/**
+ * The timer polling the progress of the analysis, null if not polling.
+ * @private {?number}
+ */
+historian.upload.progressTimer_ = null;
+
+
+/**
+ * Polls the progress of the analysis of the current upload, and shows it in
+ * the progress bar until the analysis is done.
+ * @param {!jQuery} bar The progress bar.
+ * @private
+ */
+historian.upload.pollProgress_ = function(bar) {
+  historian.upload.stopPollingProgress_();
+  var id = historian.upload.progressId_;
+  historian.upload.progressTimer_ = setInterval(function() {
+    $.getJSON('progress', {id: id}, function(progress) {
+      if (id != historian.upload.progressId_ || progress.done) {
+        return;
+      }
+      var text = historian.upload.progressStages_[progress.stage];
+      if (progress.stage == 'history') {
+        var percentVal = progress.percent + '%';
+        bar.css('width', percentVal);
+        text += ': ' + percentVal;
+      }
+      if (text) {
+        bar.text(text);
+      }
+    });
+  }, historian.upload.PROGRESS_POLL_INTERVAL_MS_);
+};
+
+
+/**
+ * Stops polling the progress of the analysis.
+ * @private
+ */
+historian.upload.stopPollingProgress_ = function() {
+  if (historian.upload.progressTimer_ != null) {
+    clearInterval(historian.upload.progressTimer_);
+    historian.upload.progressTimer_ = null;
+  }
+};
+
+
 /**
  * Shows the submit button using animation.
  * @private
@@ -98,14 +167,60 @@ historian.upload.hidePowerMonitorOption_ = function() {
 };
 
 
+/**
+ * Shows the extra file option for markers file.
+ * @private
+ */
+historian.upload.showMarkersOption_ = function() {
+  $('#add-markers').hide();
+  $('#markers-option').show();
+  $('#markers-filename').text('Choose a Markers File');
+};
+
+
+/**
+ * Hides the extra file option for markers file.
+ * @private
+ */
+historian.upload.hideMarkersOption_ = function() {
+  $('#add-markers').show();
+  $('#markers-option').hide();
+  $('#markers').val('');
+};
+
+
+/**
+ * Shows the extra file option for power rails file.
+ * @private
+ */
+historian.upload.showPowerRailsOption_ = function() {
+  $('#add-powerrails').hide();
+  $('#powerrails-option').show();
+  $('#powerrails-filename').text('Choose a Power Rails File');
+};
+
+
+/**
+ * Hides the extra file option for power rails file.
+ * @private
+ */
+historian.upload.hidePowerRailsOption_ = function() {
+  $('#add-powerrails').show();
+  $('#powerrails-option').hide();
+  $('#powerrails').val('');
+};
+
+
 /**
  * Shows the extra file option for A/B comparison.
  * @private
  */
 historian.upload.showComparisonOption_ = function() {
   $('#comparison-option').show();
-  $('#add-kernel, #add-powermonitor, #add-comparison').hide();
-  $('#kernel-option, #powermonitor-option').hide();
+  $('#add-kernel, #add-powermonitor, #add-markers, #add-powerrails, ' +
+    '#add-comparison').hide();
+  $('#kernel-option, #powermonitor-option, #markers-option, ' +
+    '#powerrails-option').hide();
 };
 
 
@@ -115,7 +230,8 @@ historian.upload.showComparisonOption_ = function() {
  */
 historian.upload.hideComparisonOption_ = function() {
   $('#comparison-option').hide();
-  $('#add-kernel, #add-powermonitor, #add-comparison').show();
+  $('#add-kernel, #add-powermonitor, #add-markers, #add-powerrails, ' +
+    '#add-comparison').show();
   $('#bugreport2').val('');
 };
 
@@ -146,6 +262,12 @@ historian.upload.initialize = function() {
   $('#add-powermonitor').click(function() {
     historian.upload.showPowerMonitorOption_();
   });
+  $('#add-markers').click(function() {
+    historian.upload.showMarkersOption_();
+  });
+  $('#add-powerrails').click(function() {
+    historian.upload.showPowerRailsOption_();
+  });
   $('#add-comparison').click(function() {
     historian.upload.showComparisonOption_();
   });
@@ -156,6 +278,12 @@ historian.upload.initialize = function() {
   $('#remove-powermonitor').click(function() {
     historian.upload.hidePowerMonitorOption_();
   });
+  $('#remove-markers').click(function() {
+    historian.upload.hideMarkersOption_();
+  });
+  $('#remove-powerrails').click(function() {
+    historian.upload.hidePowerRailsOption_();
+  });
   $('#remove-comparison').click(function() {
     historian.upload.hideComparisonOption_();
   });
@@ -170,6 +298,16 @@ historian.upload.initialize = function() {
     if (!filename) filename = '';
     $('#powermonitor-filename').text(filename);
   });
+  $('#markers').on('change', function(event) {
+    var filename = event.target.files[0].name;
+    if (!filename) filename = '';
+    $('#markers-filename').text(filename);
+  });
+  $('#powerrails').on('change', function(event) {
+    var filename = event.target.files[0].name;
+    if (!filename) filename = '';
+    $('#powerrails-filename').text(filename);
+  });
   $('#bugreport2').on('change', function(event) {
     var filename = event.target.files[0].name;
     if (filename == null) filename = '';
@@ -180,6 +318,14 @@ historian.upload.initialize = function() {
   var status = $('#status');
 
   $('form').ajaxForm({
+    beforeSubmit: function(arr, $form, options) {
+      // The server reports the progress of the analysis under this ID.
+      historian.upload.progressId_ =
+          Date.now().toString(36) + Math.random().toString(36).slice(2);
+      var url = options.url || window.location.href;
+      options.url = url + (url.indexOf('?') < 0 ? '?' : '&') +
+          'progress=' + historian.upload.progressId_;
+    },
     beforeSend: function() {
       var formData = new FormData();
       var compareFormData = [new FormData(), new FormData()];
@@ -207,11 +353,14 @@ historian.upload.initialize = function() {
       bar.css('width', percentVal);
       bar.text('Uploading:' + percentVal);
       if (percentComplete === 100) {
-        setTimeout(function() { bar.text('Uploading Complete!'); }, 1000);
-        setTimeout(function() { bar.text('Analyzing...'); }, 3000);
+        bar.text('Uploading Complete!');
+        historian.upload.pollProgress_(bar);
       }
     },
-    complete: historian.requests.uploadComplete
+    complete: function(xhr) {
+      historian.upload.stopPollingProgress_();
+      historian.requests.uploadComplete(xhr);
+    }
   });
 };
 
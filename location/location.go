@@ -0,0 +1,169 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package location finds the apps requesting GNSS locations, using the provider registrations logged
+// in the event log of the location service dump (dumpsys location) of a bug report.
+package location
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// Metric is the CSV description of the GNSS location requests of apps.
+	Metric = "GPS request"
+
+	// locationService is the name of the location service dump.
+	locationService = "location"
+
+	// gpsProvider is the location provider backed by the GNSS chip.
+	gpsProvider = "gps"
+)
+
+// registrationRE matches a location provider registration in the location event log.
+// e.g. "10-16 09:12:33.123: gps provider +registration 10123/com.example.app/listener -> Request[@+1s0ms HIGH_ACCURACY]"
+var registrationRE = regexp.MustCompile(`^\s*(?P<month>\d{2})-(?P<day>\d{2}) (?P<time>\d{2}:\d{2}:\d{2})[.](?P<remainder>\d+): (?P<provider>\w+) provider (?P<transition>[+-])registration (?P<uid>\d+)/(?P<pkg>[^/\s]+)`)
+
+// Request is an app requesting GNSS locations over a time range.
+type Request struct {
+	Package string
+	// UID is the app ID of the app.
+	UID int32
+	// StartMs and EndMs are the time range the app had a registration, in unix time ms.
+	StartMs, EndMs int64
+}
+
+// byStart sorts requests in ascending order of start time, then by package.
+type byStart []Request
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].StartMs != a[j].StartMs {
+		return a[i].StartMs < a[j].StartMs
+	}
+	return a[i].Package < a[j].Package
+}
+
+// extractLocationDump returns the lines of the location service dump in the bug report.
+func extractLocationDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == locationService
+			continue
+		}
+		if in {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse replays the GNSS provider registrations in the bug report, taken at the given time, and returns
+// the ranges each app requested locations, sorted by start time. An app can have several registrations,
+// which are merged. The event log is a ring buffer, so an app whose first event is an unregistration
+// is assumed to be registered since the start of the log. An app still registered when the bug report
+// was taken is registered until then.
+func Parse(bugreport string, taken time.Time) ([]Request, []error) {
+	var errs []error
+	var requests []Request
+	// active maps the UID and package of the apps with registrations to their current request.
+	active := make(map[string]*Request)
+	// registrations is the number of registrations of each app in active.
+	registrations := make(map[string]int)
+	var firstMs int64
+	seen := make(map[string]bool)
+
+	for _, line := range extractLocationDump(bugreport) {
+		m, result := historianutils.SubexpNames(registrationRE, line)
+		if !m || result["provider"] != gpsProvider {
+			continue
+		}
+		ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid location event timestamp %q: %v", line, err))
+			continue
+		}
+		if firstMs == 0 {
+			firstMs = ms
+		}
+		uid, err := packageutils.AppIDFromString(result["uid"])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		k := fmt.Sprintf("%d/%s", uid, result["pkg"])
+		switch result["transition"] {
+		case "+":
+			if registrations[k] == 0 {
+				active[k] = &Request{Package: result["pkg"], UID: uid, StartMs: ms}
+			}
+			registrations[k]++
+		case "-":
+			if registrations[k] == 0 {
+				if seen[k] {
+					errs = append(errs, fmt.Errorf("unregistration without a registration for %q at %d", k, ms))
+					continue
+				}
+				active[k] = &Request{Package: result["pkg"], UID: uid, StartMs: firstMs}
+				registrations[k] = 1
+			}
+			if registrations[k]--; registrations[k] == 0 {
+				r := active[k]
+				r.EndMs = ms
+				requests = append(requests, *r)
+				delete(active, k)
+			}
+		}
+		seen[k] = true
+	}
+	takenMs := taken.UnixNano() / int64(time.Millisecond)
+	for _, r := range active {
+		r.EndMs = takenMs
+		requests = append(requests, *r)
+	}
+	sort.Sort(byStart(requests))
+	return requests, errs
+}
+
+// CSV returns the requests as Metric CSV events, so they can be seen on the timeline.
+func CSV(requests []Request) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, r := range requests {
+		csvState.Print(Metric, "service", r.StartMs, r.EndMs, r.Package, fmt.Sprint(r.UID))
+	}
+	return b.String()
+}
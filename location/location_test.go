@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package location
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// TestParse tests replaying the GNSS provider registrations.
+func TestParse(t *testing.T) {
+	br := strings.Join([]string{
+		"DUMP OF SERVICE location:",
+		"Location Manager State:",
+		"  Event Log:",
+		"    10-16 09:00:00.000: gps provider +registration 1010123/com.example.tracker/listener -> Request[@+1s0ms HIGH_ACCURACY]",
+		// Registered before the start of the log.
+		"    10-16 09:05:00.000: gps provider -registration 10045/com.google.android.apps.maps",
+		// Other providers are ignored.
+		"    10-16 09:11:00.000: network provider +registration 10099/com.example.weather -> Request[@+10m0s0ms BALANCED]",
+		// A second registration of the same app is merged.
+		"    10-16 09:15:00.000: gps provider +registration 1010123/com.example.tracker/geofence -> Request[@+5s0ms HIGH_ACCURACY]",
+		"    10-16 09:20:00.000: gps provider -registration 1010123/com.example.tracker/listener",
+		"    10-16 09:30:00.000: gps provider -registration 1010123/com.example.tracker/geofence",
+		"    10-16 10:00:00.000: gps provider +registration 10200/com.example.run -> Request[@+1s0ms HIGH_ACCURACY]",
+		"DUMP OF SERVICE lock_settings:",
+		"    10-16 10:30:00.000: gps provider -registration 10200/com.example.run",
+	}, "\n")
+	taken := time.Date(2017, time.October, 16, 11, 0, 0, 0, time.UTC)
+
+	want := []Request{
+		{Package: "com.example.tracker", UID: 10123, StartMs: historianutils.ClockMs(reportDay, 9, 0, 0), EndMs: historianutils.ClockMs(reportDay, 9, 30, 0)},
+		{Package: "com.google.android.apps.maps", UID: 10045, StartMs: historianutils.ClockMs(reportDay, 9, 0, 0), EndMs: historianutils.ClockMs(reportDay, 9, 5, 0)},
+		// Still registered when the bug report was taken.
+		{Package: "com.example.run", UID: 10200, StartMs: historianutils.ClockMs(reportDay, 10, 0, 0), EndMs: historianutils.ClockMs(reportDay, 11, 0, 0)},
+	}
+	requests, errs := Parse(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", br, requests, want)
+	}
+
+	wantCSV := strings.Join([]string{
+		fmt.Sprintf("GPS request,service,%d,%d,com.example.tracker,10123", historianutils.ClockMs(reportDay, 9, 0, 0), historianutils.ClockMs(reportDay, 9, 30, 0)),
+		fmt.Sprintf("GPS request,service,%d,%d,com.google.android.apps.maps,10045", historianutils.ClockMs(reportDay, 9, 0, 0), historianutils.ClockMs(reportDay, 9, 5, 0)),
+		fmt.Sprintf("GPS request,service,%d,%d,com.example.run,10200", historianutils.ClockMs(reportDay, 10, 0, 0), historianutils.ClockMs(reportDay, 11, 0, 0)),
+		"",
+	}, "\n")
+	if got := CSV(requests); got != wantCSV {
+		t.Errorf("CSV(%v)\n got: %q\n want: %q", requests, got, wantCSV)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package markers parses auxiliary files of labelled timestamps, such as the event log of a test
+// harness, and outputs CSV entries so they can be shown as annotations on the Historian v2 timeline.
+package markers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// Metric is the CSV description of marker events.
+	Metric = "Marker"
+
+	// msThreshold is the value above which unix timestamps are assumed to be in milliseconds,
+	// as a timestamp in seconds would be many millennia in the future.
+	msThreshold = 1e11
+)
+
+// markerRE matches a line in the markers file, in the format: timestamp[,end_timestamp],label
+// The fields can be separated by commas or tabs. Lines starting with # are comments.
+// e.g. "1433786064000,test step 3 started"
+//      "2015-06-08T18:54:24Z	2015-06-08T18:55:00Z	download"
+var markerRE = regexp.MustCompile(`^(?P<start>[^,\t]+)[,\t]\s*((?P<end>[^,\t]+)[,\t]\s*)?(?P<label>.+)$`)
+
+// parseTimestamp returns the unix time in milliseconds of a timestamp in unix seconds (optionally
+// fractional), unix milliseconds or RFC 3339 format.
+func parseTimestamp(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > msThreshold {
+			return int64(f), nil
+		}
+		return int64(f * 1000), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// parseLine returns the marker event in the line, and whether the line contains a marker.
+// The end field is only used if it is a valid timestamp, otherwise it is part of the label.
+func parseLine(l string) (csv.Event, bool) {
+	m, result := historianutils.SubexpNames(markerRE, l)
+	if !m || strings.HasPrefix(l, "#") {
+		return csv.Event{}, false
+	}
+	start, err := parseTimestamp(result["start"])
+	if err != nil {
+		return csv.Event{}, false
+	}
+	e := csv.Event{Type: "string", Start: start, End: start, Value: strings.TrimSpace(result["label"])}
+	if result["end"] != "" {
+		if end, err := parseTimestamp(result["end"]); err == nil && end >= start {
+			e.End = end
+		} else {
+			e.Value = strings.TrimSpace(result["end"]) + ", " + e.Value
+		}
+	}
+	return e, true
+}
+
+// IsValid returns whether the file contains at least one marker.
+func IsValid(f []byte) bool {
+	for _, l := range strings.Split(string(f), "\n") {
+		if _, ok := parseLine(strings.TrimSpace(l)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse writes a CSV entry for each marker in the file, and returns whether the file contained
+// any markers. The CSV has no header, so it can be appended to the battery history CSV.
+func Parse(f string) (bool, string, []error) {
+	var buf bytes.Buffer
+	var errs []error
+	csvState := csv.NewState(&buf, false)
+	found := false
+	for i, l := range strings.Split(f, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		e, ok := parseLine(l)
+		if !ok {
+			errs = append(errs, fmt.Errorf("line %d did not match format: timestamp[,end_timestamp],label : %q", i+1, l))
+			continue
+		}
+		found = true
+		csvState.PrintEvent(Metric, e)
+	}
+	return found, buf.String(), errs
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package markers
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParse tests the parsing of markers files in the supported timestamp formats.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     []string
+		wantValid bool
+		want      []string
+		wantErrs  int
+	}{
+		{
+			desc: "Unix timestamps",
+			input: []string{
+				"# test harness log",
+				"1433786064000,test step 3 started",
+				"1433786070.5,1433786080,download, large file",
+				"",
+			},
+			wantValid: true,
+			want: []string{
+				`Marker,string,1433786064000,1433786064000,test step 3 started,`,
+				`Marker,string,1433786070500,1433786080000,"download, large file",`,
+			},
+		},
+		{
+			desc: "RFC 3339 timestamps, tab separated",
+			input: []string{
+				"2015-06-08T18:54:24Z\t2015-06-08T18:55:00.250Z\tstep 4",
+				"2015-06-08T18:56:00-07:00\tstep 5, retry",
+				"invalid line",
+			},
+			wantValid: true,
+			want: []string{
+				`Marker,string,1433789664000,1433789700250,step 4,`,
+				`Marker,string,1433814960000,1433814960000,"step 5, retry",`,
+			},
+			wantErrs: 1,
+		},
+		{
+			desc:  "No markers",
+			input: []string{"Power Monitor file", "1 2 3"},
+			// Both lines are invalid.
+			wantErrs: 2,
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		valid, got, errs := Parse(input)
+		if valid != test.wantValid {
+			t.Errorf("%v: Parse(%q) got valid %v, want %v", test.desc, input, valid, test.wantValid)
+		}
+		if len(errs) != test.wantErrs {
+			t.Errorf("%v: Parse(%q) got %d errors, want %d: %v", test.desc, input, len(errs), test.wantErrs, errs)
+		}
+		if want := strings.Join(test.want, "\n"); strings.TrimSpace(got) != want {
+			t.Errorf("%v: Parse(%q)\n got: %v\n want: %v", test.desc, input, got, want)
+		}
+		if v := IsValid([]byte(input)); v != test.wantValid {
+			t.Errorf("%v: IsValid(%q) = %v, want %v", test.desc, input, v, test.wantValid)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package messages is the catalog of the user facing strings generated by the analysis and the
+// exporters, e.g. metric display names and warnings, so that they can be presented in other
+// languages without post-processing the output.
+//
+// Messages are identified by IDs and are fmt format strings. The catalog is built in for English,
+// the default language, and other languages are added from catalog files with LoadCatalog. Messages
+// missing from a language fall back to English.
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// DefaultLanguage is the language of the built in catalog, which the other languages fall back to.
+const DefaultLanguage = "en"
+
+// Message IDs of the warnings, errors and findings.
+const (
+	ChargingTooHot        = "charging.too_hot"
+	ChargingFastRise      = "charging.fast_rise"
+	ChargingFastRisePerMA = "charging.fast_rise_per_amp"
+
+	ImmortalWakelockStartEnd  = "wakelock.immortal_start_end"
+	ImmortalWakelockUnplugged = "wakelock.immortal_unplugged"
+
+	AnalysisTimedOut  = "warning.analysis_timed_out"
+	HistoryDeviation  = "warning.history_deviation"
+	HistoryTruncated  = "warning.history_truncated"
+	CheckinUnparsed   = "error.checkin_unparsed"
+	UnsupportedReport = "error.unsupported_report"
+
+	FindingWakelockAbuser   = "finding.wakelock_abuser"
+	FindingSyncStorm        = "finding.sync_storm"
+	FindingSuspendFailures  = "finding.suspend_failures"
+	FindingChargingThermal  = "finding.charging_thermal"
+	FindingChargerFlapping  = "finding.charger_flapping"
+	FindingImmortalWakelock = "finding.immortal_wakelock"
+)
+
+// metricPrefix prefixes the metric names in the message IDs of their display names.
+const metricPrefix = "metric."
+
+// catalogs maps from language to message ID to message format.
+var catalogs = map[string]map[string]string{
+	DefaultLanguage: {
+		ChargingTooHot:        "battery reached %.1f°C, over %.0f°C",
+		ChargingFastRise:      "temperature rose %.1f°C/h, over %.0f°C/h",
+		ChargingFastRisePerMA: "temperature rose %.1f°C/h at %.0f mA, over %.0f°C/h per amp",
+
+		ImmortalWakelockStartEnd:  "held at both the start and the end of the report",
+		ImmortalWakelockUnplugged: "held for %.0f%% of the %v unplugged, over %.0f%%",
+
+		AnalysisTimedOut:  "The analysis timed out after %v, so the following results are missing: %s.",
+		HistoryDeviation:  "%s: history total %v differs from checkin total %v by %.0f%%",
+		HistoryTruncated:  "Battery history starts %.1f hours after the last charge, so drain per charge cycle computed from the history is incomplete",
+		CheckinUnparsed:   "Could not parse aggregated battery stats.",
+		UnsupportedReport: "Unsupported report: too many battery history events could not be parsed.",
+
+		FindingWakelockAbuser:   "UID %d held long wakelocks for %v in total",
+		FindingSyncStorm:        "UID %d ran %d syncs within %v",
+		FindingSuspendFailures:  "%.0f%% of the %d CPU running periods were aborted suspends",
+		FindingChargingThermal:  "abnormal battery temperature while charging",
+		FindingChargerFlapping:  "the charging status flapped %d times, e.g. from a loose cable or a flaky charger",
+		FindingImmortalWakelock: "the %s wakelock was held across the report",
+
+		// The per app ActivitySummary maps, as exported by appsummary.
+		metricPrefix + "ActiveProcessSummary":        "Active processes",
+		metricPrefix + "AlarmSummary":                "Alarms",
+		metricPrefix + "AttributedCPURunningSummary": "CPU running",
+		metricPrefix + "ForegroundProcessSummary":    "Foreground processes",
+		metricPrefix + "LongWakelockSummary":         "Long wakelocks",
+		metricPrefix + "MobileRadioActiveAppSummary": "Mobile radio activity",
+		metricPrefix + "PerAppSyncSummary":           "Syncs",
+		metricPrefix + "ScheduledJobSummary":         "Jobs",
+		metricPrefix + "TmpWhiteListSummary":         "Temporary whitelisting",
+		metricPrefix + "TopApplicationSummary":       "Top app",
+		metricPrefix + "WakeLockDetailedSummary":     "Wakelocks (detailed)",
+		metricPrefix + "WakeLockSummary":             "Wakelocks",
+	},
+}
+
+// AddCatalog adds the messages in the given catalog file contents, replacing any existing messages
+// with the same language and ID. The catalog is a JSON object from language to message ID to
+// message, e.g.
+//
+//	{"fr": {"metric.WakeLockSummary": "Wakelocks", "metric.ScheduledJobSummary": "Tâches"}}
+//
+// It's not safe to call concurrently with lookups.
+func AddCatalog(text string) error {
+	var c map[string]map[string]string
+	if err := json.Unmarshal([]byte(text), &c); err != nil {
+		return err
+	}
+	for lang, msgs := range c {
+		if lang == "" {
+			return fmt.Errorf("empty language for %d messages", len(msgs))
+		}
+		if catalogs[lang] == nil {
+			catalogs[lang] = make(map[string]string)
+		}
+		for id, m := range msgs {
+			catalogs[lang][id] = m
+		}
+	}
+	return nil
+}
+
+// LoadCatalog adds the messages in the catalog file at the given path. See AddCatalog for the format.
+func LoadCatalog(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := AddCatalog(string(b)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// RemoveLanguage removes the messages added for the given language. The built in messages of the
+// default language are kept. It's not safe to call concurrently with lookups.
+func RemoveLanguage(lang string) {
+	if lang != DefaultLanguage {
+		delete(catalogs, lang)
+	}
+}
+
+// Languages returns the sorted languages with messages.
+func Languages() []string {
+	var langs []string
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// lookup returns the format of the message in the given language, falling back to the default language.
+func lookup(lang, id string) (string, bool) {
+	if m, ok := catalogs[lang][id]; ok {
+		return m, true
+	}
+	m, ok := catalogs[DefaultLanguage][id]
+	return m, ok
+}
+
+// Get returns the message with the given ID in the given language, formatted with the args. The
+// message ID is returned if there's no such message in any language.
+func Get(lang, id string, args ...interface{}) string {
+	m, ok := lookup(lang, id)
+	if !ok {
+		return id
+	}
+	return fmt.Sprintf(m, args...)
+}
+
+// MetricName returns the display name of the given metric in the given language, or the metric
+// itself if it has none.
+func MetricName(lang, metric string) string {
+	if m, ok := lookup(lang, metricPrefix+metric); ok {
+		return m
+	}
+	return metric
+}
+
+// Message is a message with its args, so that it can be generated once and presented in any language.
+type Message struct {
+	ID   string        `json:"id"`
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// New returns the message with the given ID and args.
+func New(id string, args ...interface{}) Message {
+	return Message{ID: id, Args: args}
+}
+
+// In returns the message in the given language.
+func (m Message) In(lang string) string {
+	return Get(lang, m.ID, m.Args...)
+}
+
+// String returns the message in the default language.
+func (m Message) String() string {
+	return m.In(DefaultLanguage)
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package messages
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestGet tests the lookup of messages in added languages, and the fallbacks.
+func TestGet(t *testing.T) {
+	if err := AddCatalog(`{"de": {"charging.too_hot": "Akku erreichte %.1f°C, über %.0f°C", "metric.AlarmSummary": "Alarme"}}`); err != nil {
+		t.Fatalf("AddCatalog returned unexpected error: %v", err)
+	}
+	tests := []struct {
+		desc, got, want string
+	}{
+		{"Added language", Get("de", ChargingTooHot, 46.0, 45.0), "Akku erreichte 46.0°C, über 45°C"},
+		{"Default language", Get(DefaultLanguage, ChargingTooHot, 46.0, 45.0), "battery reached 46.0°C, over 45°C"},
+		{"Missing message falls back", Get("de", ChargingFastRise, 18.0, 10.0), "temperature rose 18.0°C/h, over 10°C/h"},
+		{"Unknown language falls back", Get("ja", ChargingFastRise, 18.0, 10.0), "temperature rose 18.0°C/h, over 10°C/h"},
+		{"Unknown message", Get("de", "no.such.message"), "no.such.message"},
+		{"Metric name", MetricName("de", "AlarmSummary"), "Alarme"},
+		{"Unknown metric", MetricName("de", "UnknownSummary"), "UnknownSummary"},
+		{"Message", New(ChargingTooHot, 46.0, 45.0).In("de"), "Akku erreichte 46.0°C, über 45°C"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%v: got %q, want %q", test.desc, test.got, test.want)
+		}
+	}
+	if got, want := Languages(), []string{"de", "en"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Languages() = %v, want %v", got, want)
+	}
+
+	RemoveLanguage("de")
+	RemoveLanguage(DefaultLanguage)
+	if got, want := Languages(), []string{"en"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Languages() after RemoveLanguage = %v, want %v", got, want)
+	}
+	if got, want := MetricName("de", "AlarmSummary"), "Alarms"; got != want {
+		t.Errorf("MetricName(de, AlarmSummary) after RemoveLanguage = %q, want %q", got, want)
+	}
+}
+
+// TestAddCatalogErrors tests that invalid catalogs are rejected.
+func TestAddCatalogErrors(t *testing.T) {
+	for _, text := range []string{`not json`, `{"": {"metric.AlarmSummary": "Alarms"}}`, `{"fr": ["Alarmes"]}`} {
+		if err := AddCatalog(text); err == nil {
+			t.Errorf("AddCatalog(%q) returned no error, want error", text)
+		}
+	}
+}
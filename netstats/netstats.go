@@ -0,0 +1,342 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netstats reads the per app network usage history from the network stats service dump
+// (dumpsys netstats) of a bug report, and attributes it to the temporary whitelist grants (Etw) the
+// apps get when they receive high priority messages, e.g. to find apps downloading large payloads
+// on every push.
+package netstats
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// netstatsService is the name of the network stats service dump.
+	netstatsService = "netstats"
+
+	// uidStats is the section of the netstats dump with the untagged per UID history.
+	uidStats = "UID stats"
+
+	// tmpWhiteList is the battery history CSV metric for apps on the temporary whitelist.
+	tmpWhiteList = "Temp White List"
+)
+
+var (
+	// sectionRE matches the start of a section of the netstats dump.
+	// e.g. "  UID stats:"
+	sectionRE = regexp.MustCompile(`^\s*(?P<section>[A-Za-z ]+ stats):\s*$`)
+
+	// identRE matches the network identity and UID of the history that follows it.
+	// e.g. "    ident=[{type=MOBILE, subType=COMBINED, subscriberId=310260...}] uid=10123 set=DEFAULT tag=0x0"
+	identRE = regexp.MustCompile(`^\s*ident=.*\suid=(?P<uid>-?\d+) set=(?P<set>\S+) tag=(?P<tag>0x[0-9a-fA-F]+)`)
+
+	// historyRE matches the start of a history, with the bucket duration in seconds.
+	// e.g. "      NetworkStatsHistory: bucketDuration=7200"
+	historyRE = regexp.MustCompile(`^\s*NetworkStatsHistory: bucketDuration=(?P<duration>\d+)`)
+
+	// bucketRE matches a history bucket, with the start time in unix seconds and the bytes received and sent.
+	// e.g. "        st=1422612000 rb=12345 rp=30 tb=2345 tp=20 op=0"
+	bucketRE = regexp.MustCompile(`^\s*st=(?P<start>\d+) rb=(?P<rb>\d+) rp=\d+ tb=(?P<tb>\d+)`)
+)
+
+// Bucket is the network usage of an app over a time range.
+type Bucket struct {
+	// UID is the app ID of the app, with the usage of all users combined.
+	UID int32
+	// StartMs and EndMs are the time range of the bucket, in unix time ms.
+	StartMs, EndMs   int64
+	RxBytes, TxBytes int64
+}
+
+// byUIDAndStart sorts buckets in ascending order of UID, then start time.
+type byUIDAndStart []Bucket
+
+func (a byUIDAndStart) Len() int      { return len(a) }
+func (a byUIDAndStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUIDAndStart) Less(i, j int) bool {
+	if a[i].UID != a[j].UID {
+		return a[i].UID < a[j].UID
+	}
+	return a[i].StartMs < a[j].StartMs
+}
+
+// extractNetstatsDump returns the lines of the network stats service dump in the bug report.
+func extractNetstatsDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == netstatsService
+			continue
+		}
+		if in {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	return lines
+}
+
+// Parse returns the untagged per app network usage buckets in the network stats dump of the bug
+// report, sorted by UID then start time. The usage over the different networks of an app is combined.
+func Parse(bugreport string) ([]Bucket, []error) {
+	var errs []error
+	// The dump can have the same history more than once, e.g. as both the complete history and the
+	// history since boot, so each bucket of each identity is only counted once.
+	type identBucket struct {
+		ident   string
+		startMs int64
+	}
+	seen := make(map[identBucket]bool)
+	type uidBucket struct {
+		uid     int32
+		startMs int64
+	}
+	buckets := make(map[uidBucket]*Bucket)
+
+	section := ""
+	// ident is the identity line of the current history, empty if it isn't an untagged app history.
+	ident := ""
+	var uid int32
+	var durationMs int64
+	for _, line := range extractNetstatsDump(bugreport) {
+		if m, result := historianutils.SubexpNames(sectionRE, line); m {
+			section = result["section"]
+			ident = ""
+			continue
+		}
+		if section != uidStats {
+			continue
+		}
+		if m, result := historianutils.SubexpNames(identRE, line); m {
+			ident = ""
+			if result["tag"] != "0x0" || strings.HasPrefix(result["uid"], "-") {
+				// Tagged usage is already included in the untagged usage, and negative UIDs aren't apps.
+				continue
+			}
+			id, err := packageutils.AppIDFromString(result["uid"])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid netstats uid %q: %v", line, err))
+				continue
+			}
+			ident, uid, durationMs = strings.TrimSpace(line), id, 0
+			continue
+		}
+		if ident == "" {
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historyRE, line); m {
+			d, err := strconv.ParseInt(result["duration"], 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid netstats bucket duration %q: %v", line, err))
+				continue
+			}
+			durationMs = d * 1000
+			continue
+		}
+		m, result := historianutils.SubexpNames(bucketRE, line)
+		if !m || durationMs == 0 {
+			continue
+		}
+		var v [3]int64
+		var err error
+		for i, k := range []string{"start", "rb", "tb"} {
+			if v[i], err = strconv.ParseInt(result[k], 10, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid netstats bucket %q: %v", line, err))
+			continue
+		}
+		startMs := v[0] * 1000
+		ib := identBucket{ident, startMs}
+		if seen[ib] {
+			continue
+		}
+		seen[ib] = true
+		k := uidBucket{uid, startMs}
+		b, ok := buckets[k]
+		if !ok {
+			b = &Bucket{UID: uid, StartMs: startMs, EndMs: startMs + durationMs}
+			buckets[k] = b
+		}
+		b.RxBytes += v[1]
+		b.TxBytes += v[2]
+	}
+
+	var res []Bucket
+	for _, b := range buckets {
+		res = append(res, *b)
+	}
+	sort.Sort(byUIDAndStart(res))
+	return res, errs
+}
+
+// Grant is a temporary whitelist grant of an app, with the network usage attributed to it.
+type Grant struct {
+	// Name is the whitelisted service logged in the battery history, e.g. the GCM broadcast.
+	Name string
+	// UID is the app ID of the app.
+	UID            int32
+	StartMs, EndMs int64
+	// RxBytes and TxBytes are the bytes transferred by the app in the buckets overlapping the grant.
+	// The bytes of a bucket overlapping several grants of the app are split evenly between them.
+	// Buckets are usually hours long, so this includes any other use the app made of the network.
+	RxBytes, TxBytes int64
+}
+
+// Grants returns the temporary whitelist grants in the battery history CSV generated by AnalyzeHistory
+// that are covered by the buckets, with the bytes transferred by the app attributed to them. It
+// returns nil if there are no buckets, e.g. if the bug report has no network stats dump.
+func Grants(buckets []Bucket, historyCSV string) ([]Grant, []error) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{tmpWhiteList})
+	start, end := buckets[0].StartMs, buckets[0].EndMs
+	byUID := make(map[int32][]Bucket)
+	for _, b := range buckets {
+		if b.StartMs < start {
+			start = b.StartMs
+		}
+		if b.EndMs > end {
+			end = b.EndMs
+		}
+		byUID[b.UID] = append(byUID[b.UID], b)
+	}
+
+	var grants []Grant
+	for _, e := range es[tmpWhiteList] {
+		// Grants outside the netstats history can't be measured.
+		if e.End <= start || e.Start >= end {
+			continue
+		}
+		uid, err := packageutils.AppIDFromString(e.Opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid temporary whitelist uid %q: %v", e.Opt, err))
+			continue
+		}
+		grants = append(grants, Grant{Name: strings.Trim(e.Value, `"`), UID: uid, StartMs: e.Start, EndMs: e.End})
+	}
+	sort.Sort(byStart(grants))
+
+	// overlaps returns whether the grant overlaps the bucket. Grants without a duration are only in
+	// the bucket they start in.
+	overlaps := func(g Grant, b Bucket) bool {
+		end := g.EndMs
+		if end <= g.StartMs {
+			end = g.StartMs + 1
+		}
+		return g.StartMs < b.EndMs && end > b.StartMs
+	}
+	for _, b := range buckets {
+		var in []int
+		for i, g := range grants {
+			if g.UID == b.UID && overlaps(g, b) {
+				in = append(in, i)
+			}
+		}
+		for _, i := range in {
+			grants[i].RxBytes += b.RxBytes / int64(len(in))
+			grants[i].TxBytes += b.TxBytes / int64(len(in))
+		}
+	}
+	return grants, errs
+}
+
+// byStart sorts grants in ascending order of start time, then UID.
+type byStart []Grant
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].StartMs != a[j].StartMs {
+		return a[i].StartMs < a[j].StartMs
+	}
+	return a[i].UID < a[j].UID
+}
+
+// AppUsage is the network usage attributed to the temporary whitelist grants of an app.
+type AppUsage struct {
+	UID int32
+	// Names are the whitelisted services of the grants, sorted.
+	Names            []string
+	Grants           int
+	RxBytes, TxBytes int64
+}
+
+// BytesPerGrant returns the average bytes transferred per grant.
+func (a AppUsage) BytesPerGrant() int64 {
+	if a.Grants == 0 {
+		return 0
+	}
+	return (a.RxBytes + a.TxBytes) / int64(a.Grants)
+}
+
+// byBytes sorts app usage in descending order of bytes transferred, then ascending order of UID.
+type byBytes []AppUsage
+
+func (a byBytes) Len() int      { return len(a) }
+func (a byBytes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byBytes) Less(i, j int) bool {
+	if x, y := a[i].RxBytes+a[i].TxBytes, a[j].RxBytes+a[j].TxBytes; x != y {
+		return x > y
+	}
+	return a[i].UID < a[j].UID
+}
+
+// ByApp aggregates the grants per app, sorted in descending order of bytes transferred.
+func ByApp(grants []Grant) []AppUsage {
+	apps := make(map[int32]*AppUsage)
+	var uids []int32
+	for _, g := range grants {
+		a, ok := apps[g.UID]
+		if !ok {
+			a = &AppUsage{UID: g.UID}
+			apps[g.UID] = a
+			uids = append(uids, g.UID)
+		}
+		if i := sort.SearchStrings(a.Names, g.Name); i == len(a.Names) || a.Names[i] != g.Name {
+			a.Names = append(a.Names[:i], append([]string{g.Name}, a.Names[i:]...)...)
+		}
+		a.Grants++
+		a.RxBytes += g.RxBytes
+		a.TxBytes += g.TxBytes
+	}
+	var res []AppUsage
+	for _, uid := range uids {
+		res = append(res, *apps[uid])
+	}
+	sort.Sort(byBytes(res))
+	return res
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestParse tests the parsing of the per app network usage history from the netstats dump.
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		`DUMP OF SERVICE netstats:`,
+		`Active interfaces:`,
+		`  Dev stats:`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=-1 set=ALL tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=3600`,
+		`        st=1422612000 rb=99999 rp=10 tb=99999 tp=10 op=0`,
+		`  UID stats:`,
+		`    Pending bytes: 1234`,
+		`    Complete history:`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=10123 set=DEFAULT tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422612000 rb=1000 rp=10 tb=100 tp=2 op=0`,
+		`        st=1422619200 rb=2000 rp=20 tb=200 tp=4 op=0`,
+		`    ident=[{type=WIFI, subType=COMBINED}] uid=1010123 set=FOREGROUND tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422612000 rb=500 rp=5 tb=50 tp=1 op=0`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=10123 set=DEFAULT tag=0xffffff01`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422612000 rb=700 rp=7 tb=70 tp=1 op=0`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=-4 set=DEFAULT tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422612000 rb=800 rp=8 tb=80 tp=1 op=0`,
+		`    History since boot:`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=10123 set=DEFAULT tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422619200 rb=2000 rp=20 tb=200 tp=4 op=0`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=1000 set=DEFAULT tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422619200 rb=300 rp=3 tb=30 tp=1 op=0`,
+		`  UID tag stats:`,
+		`    ident=[{type=MOBILE, subType=COMBINED}] uid=10123 set=DEFAULT tag=0x0`,
+		`      NetworkStatsHistory: bucketDuration=7200`,
+		`        st=1422612000 rb=1000 rp=10 tb=100 tp=2 op=0`,
+		`DUMP OF SERVICE network_management:`,
+		`        st=1422612000 rb=1000 rp=10 tb=100 tp=2 op=0`,
+	}, "\n")
+
+	want := []Bucket{
+		{UID: 1000, StartMs: 1422619200000, EndMs: 1422626400000, RxBytes: 300, TxBytes: 30},
+		// The usage of the secondary user and of the different networks is combined.
+		{UID: 10123, StartMs: 1422612000000, EndMs: 1422619200000, RxBytes: 1500, TxBytes: 150},
+		// The history since boot repeats the bucket of the complete history.
+		{UID: 10123, StartMs: 1422619200000, EndMs: 1422626400000, RxBytes: 2000, TxBytes: 200},
+	}
+	got, errs := Parse(input)
+	if len(errs) > 0 {
+		t.Fatalf("Parse(%s) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%s)\n got: %+v\n want: %+v", input, got, want)
+	}
+}
+
+// TestGrants tests the attribution of the network usage to the temporary whitelist grants, and the per app totals.
+func TestGrants(t *testing.T) {
+	const hourMs = 3600 * 1000
+	buckets := []Bucket{
+		{UID: 10016, StartMs: 0, EndMs: 2 * hourMs, RxBytes: 3000, TxBytes: 300},
+		{UID: 10016, StartMs: 2 * hourMs, EndMs: 4 * hourMs, RxBytes: 1000, TxBytes: 100},
+		{UID: 10035, StartMs: 0, EndMs: 2 * hourMs, RxBytes: 500, TxBytes: 50},
+	}
+	historyCSV := strings.Join([]string{
+		csv.FileHeader,
+		`Temp White List,service,1000,11000,"broadcast:u0a16:com.example.push.GCM",10016`,
+		`Temp White List,service,3600000,3610000,"broadcast:u0a16:com.example.push.GCM",10016`,
+		// Spans both buckets.
+		`Temp White List,service,7195000,7205000,"broadcast:u0a16:com.example.push.SYNC",1010016`,
+		`Temp White List,service,5000,15000,"com.example.chat",10035`,
+		// Outside the netstats history.
+		`Temp White List,service,14400000,14410000,"com.example.chat",10035`,
+	}, "\n")
+
+	wantGrants := []Grant{
+		{Name: "broadcast:u0a16:com.example.push.GCM", UID: 10016, StartMs: 1000, EndMs: 11000, RxBytes: 1000, TxBytes: 100},
+		{Name: "com.example.chat", UID: 10035, StartMs: 5000, EndMs: 15000, RxBytes: 500, TxBytes: 50},
+		{Name: "broadcast:u0a16:com.example.push.GCM", UID: 10016, StartMs: 3600000, EndMs: 3610000, RxBytes: 1000, TxBytes: 100},
+		{Name: "broadcast:u0a16:com.example.push.SYNC", UID: 10016, StartMs: 7195000, EndMs: 7205000, RxBytes: 2000, TxBytes: 200},
+	}
+	grants, errs := Grants(buckets, historyCSV)
+	if len(errs) > 0 {
+		t.Fatalf("Grants(%v, %s) generated unexpected errors: %v", buckets, historyCSV, errs)
+	}
+	if !reflect.DeepEqual(grants, wantGrants) {
+		t.Errorf("Grants(%v, %s)\n got: %+v\n want: %+v", buckets, historyCSV, grants, wantGrants)
+	}
+
+	wantApps := []AppUsage{
+		{UID: 10016, Names: []string{"broadcast:u0a16:com.example.push.GCM", "broadcast:u0a16:com.example.push.SYNC"}, Grants: 3, RxBytes: 4000, TxBytes: 400},
+		{UID: 10035, Names: []string{"com.example.chat"}, Grants: 1, RxBytes: 500, TxBytes: 50},
+	}
+	if got := ByApp(grants); !reflect.DeepEqual(got, wantApps) {
+		t.Errorf("ByApp(%v)\n got: %+v\n want: %+v", grants, got, wantApps)
+	}
+
+	if got, errs := Grants(nil, historyCSV); got != nil || errs != nil {
+		t.Errorf("Grants(nil, %s) = %v, %v, want nil, nil", historyCSV, got, errs)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packageutils
+
+// infer.go infers package names from the service strings of the history string pool alone, for
+// reports without a checkin log or package list to match them against.
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// tagPrefixRE matches the prefix of wakelock tags of the form "*<kind>*" followed by a separator,
+	// e.g. "*sync*/", "*job*/", "*alarm*:" or "*walarm*:".
+	tagPrefixRE = regexp.MustCompile(`^\*[\w.-]+\*[/:]?`)
+
+	// packageNameRE matches a dotted name that could be a package name, e.g. "com.whatsapp".
+	packageNameRE = regexp.MustCompile(`^[a-zA-Z][\w]*(\.[a-zA-Z][\w]*)+$`)
+)
+
+// InferPackageName returns the name of the package that likely logged the given service string,
+// or "" if it doesn't contain one. The known service string formats are:
+//   - package and process names, e.g. "com.whatsapp" or "com.example.app:remote".
+//   - sync wakelocks, "*sync*/<authority>/<account type>/<account>", whose authority is mapped to
+//     its package if known, e.g. "com.google.android.gmail.provider" to "com.google.android.gm".
+//   - job wakelocks and component names, e.g. "*job*/com.example.app/.SyncService".
+//   - alarm wakelocks and intent actions, e.g. "*alarm*:com.example.app.ACTION_SYNC", whose class
+//     and action segments, starting with an upper case letter, are dropped. Framework actions, e.g.
+//     "android.intent.action.TIME_TICK", are from the "android" package.
+//
+// The result is only a guess, and should only be used when the package can't be matched from the
+// checkin log or package list.
+func InferPackageName(service string) string {
+	s := strings.Trim(service, `"`)
+	s = tagPrefixRE.ReplaceAllString(s, "")
+	if i := strings.IndexAny(s, "/:;"); i >= 0 {
+		s = s[:i]
+	}
+	if !packageNameRE.MatchString(s) {
+		return ""
+	}
+	if p, ok := syncAdapterToPackageName[s]; ok {
+		return p
+	}
+	if strings.HasPrefix(s, "android.") {
+		// Framework actions, e.g. "android.intent.action.TIME_TICK".
+		return "android"
+	}
+	// Drop the class and action names, e.g. "ACTION_SYNC" in "com.example.app.ACTION_SYNC".
+	parts := strings.Split(s, ".")
+	n := len(parts)
+	for n > 2 && unicode.IsUpper(rune(parts[n-1][0])) {
+		n--
+	}
+	return strings.Join(parts[:n], ".")
+}
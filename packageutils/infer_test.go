@@ -0,0 +1,46 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packageutils
+
+import "testing"
+
+// TestInferPackageName tests the package names inferred from the known service string formats.
+func TestInferPackageName(t *testing.T) {
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{`"com.whatsapp"`, "com.whatsapp"},
+		{`com.example.app:remote`, "com.example.app"},
+		{`*sync*/com.google.android.gms.fitness/com.google/XXX@gmail.com`, "com.google.android.gms.fitness"},
+		{`*sync*/com.google.android.gmail.provider/com.google/XXX@gmail.com`, "com.google.android.gm"},
+		{`*job*/com.example.app/.SyncService`, "com.example.app"},
+		{`*job*/com.example.app/com.example.app.SyncService`, "com.example.app"},
+		{`*alarm*:com.example.app.ACTION_SYNC`, "com.example.app"},
+		{`*walarm*:com.google.android.gms.gcm.HEARTBEAT_ALARM`, "com.google.android.gms.gcm"},
+		{`com.google.android.gms/.auth.GetToken`, "com.google.android.gms"},
+		{`*alarm*:android.intent.action.TIME_TICK`, "android"},
+		// Not package names.
+		{`*alarm*:TIME_TICK`, ""},
+		{`NlpWakeLock`, ""},
+		{`*launch*`, ""},
+		{`""`, ""},
+	}
+	for _, test := range tests {
+		if got := InferPackageName(test.service); got != test.want {
+			t.Errorf("InferPackageName(%q) = %q, want %q", test.service, got, test.want)
+		}
+	}
+}
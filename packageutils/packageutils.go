@@ -200,13 +200,34 @@ func AppIDFromString(uid string) (int32, error) {
 		}
 	}
 
-	i, err := strconv.Atoi(uid)
+	// UIDs too large for an int32 are invalid, rather than wrapped around to an unrelated app.
+	i, err := strconv.ParseInt(uid, 10, 32)
 	if err != nil {
 		return 0, fmt.Errorf("error getting appID from string: %v", err)
 	}
 	return AppID(int32(i)), nil
 }
 
+// UserIDFromString returns the user ID for a given uid, 0 being the primary user.
+// (ie. "1010001" -> 10,nil; "u10a25" -> 10,nil; "text" -> 0,error)
+func UserIDFromString(uid string) (int32, error) {
+	if uid == "" {
+		return 0, nil
+	}
+	if m, result := historianutils.SubexpNames(abrUIDRE, uid); m {
+		i, err := strconv.Atoi(result["userId"])
+		if err != nil {
+			return 0, fmt.Errorf("error getting userID from string: %v", err)
+		}
+		return int32(i), nil
+	}
+	i, err := strconv.ParseInt(uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("error getting userID from string: %v", err)
+	}
+	return int32(i) / perUserRange, nil
+}
+
 // IsSandboxedProcess returns true if the given UID is the UID of a fully isolated sandboxed process.
 func IsSandboxedProcess(uid int32) bool {
 	return firstIsolatedUID <= uid && uid <= lastIsolatedUID
@@ -0,0 +1,160 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// activitydrain.go normalizes the drain by the physical activity of the user, from the step counter.
+// The significant motion events only tell that the device moved, while the steps tell how much, which
+// distinguishes a device drained by being used on a hike from a genuine idle regression.
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// StepsMetric is the battery history CSV metric for the steps taken, with the number of steps as value.
+	StepsMetric = "Steps"
+
+	// maxStepDuration is the longest a step is assumed to take. Consecutive step counts are usually
+	// far apart, so the steps between them are placed at the end of the interval, at this pace.
+	maxStepDuration = time.Second
+)
+
+// ActivityDrain is the battery level drop and the unplugged time while walking, and while idle with
+// the screen off.
+type ActivityDrain struct {
+	// Steps are the steps taken while unplugged. They are estimated, so aren't whole numbers.
+	Steps float64
+	// ActiveLevelDrop and IdleLevelDrop are the battery level drops in percent while walking and
+	// while idle. They are estimated, so aren't whole numbers.
+	ActiveLevelDrop float64
+	IdleLevelDrop   float64
+	Active          time.Duration
+	Idle            time.Duration
+}
+
+// PerThousandSteps returns the battery level drop per 1000 steps taken while unplugged, or 0 if no
+// steps were taken.
+func (d ActivityDrain) PerThousandSteps() float64 {
+	if d.Steps <= 0 {
+		return 0
+	}
+	return 1000 * d.ActiveLevelDrop / d.Steps
+}
+
+// IdlePerHour returns the battery level drop per hour unplugged and idle, or 0 if the device was
+// never idle while unplugged.
+func (d ActivityDrain) IdlePerHour() float64 {
+	if d.Idle <= 0 {
+		return 0
+	}
+	return d.IdleLevelDrop / d.Idle.Hours()
+}
+
+// StepsCSV returns the battery history CSV rows of the steps taken between the consecutive step
+// counts, which must be sorted by time. The steps are placed at the end of each interval, taking at
+// most maxStepDuration each. Counts going down, after a reboot, are skipped.
+func StepsCSV(counts []bugreportutils.StepCount) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	stepMs := int64(maxStepDuration / time.Millisecond)
+	for i := 1; i < len(counts); i++ {
+		prev, cur := counts[i-1], counts[i]
+		steps := cur.Steps - prev.Steps
+		if steps <= 0 || cur.TimeMs <= prev.TimeMs {
+			continue
+		}
+		start := prev.TimeMs
+		if s := cur.TimeMs - steps*stepMs; s > start {
+			start = s
+		}
+		csvState.Print(StepsMetric, "int", start, cur.TimeMs, strconv.FormatInt(steps, 10), "")
+	}
+	return b.String()
+}
+
+// ActivityDrainRates returns the battery drain while walking and while idle from the battery history
+// CSV generated by AnalyzeHistory, with the steps of StepsCSV. The level drop of each battery level
+// step is split between the walking, idle and screen on time of the unplugged parts of the step,
+// proportionally to their length. Walking time with the screen on counts as walking. It returns nil
+// if no steps were taken.
+func ActivityDrainRates(csvInput string) (*ActivityDrain, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{BatteryLevel, Plugged, screen, StepsMetric})
+	if len(es[StepsMetric]) == 0 {
+		return nil, errs
+	}
+	var plugged []csv.Event
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	screenOn := csv.MergeEvents(es[screen])
+	walking := append([]csv.Event(nil), es[StepsMetric]...)
+	sort.Sort(sortByStart(walking))
+	active := csv.MergeEvents(append([]csv.Event(nil), walking...))
+	// busy is the time walking or with the screen on.
+	busy := csv.MergeEvents(append(append([]csv.Event(nil), walking...), screenOn...))
+
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	sort.Sort(sortByStart(levels))
+
+	d := &ActivityDrain{}
+	for i, cur := range levels {
+		var act, idle, total int64
+		for _, u := range unplugged(plugged, cur.Start, cur.End) {
+			a := csv.Overlap(active, u.Start, u.End)
+			act += a
+			idle += u.End - u.Start - csv.Overlap(busy, u.Start, u.End)
+			total += u.End - u.Start
+			for _, w := range walking {
+				if w.Start >= u.End {
+					break
+				}
+				n, err := strconv.ParseFloat(w.Value, 64)
+				if err != nil || w.End <= w.Start {
+					continue
+				}
+				d.Steps += n * float64(csv.Overlap([]csv.Event{w}, u.Start, u.End)) / float64(w.End-w.Start)
+			}
+		}
+		d.Active += time.Duration(act) * time.Millisecond
+		d.Idle += time.Duration(idle) * time.Millisecond
+		if i+1 == len(levels) || total == 0 {
+			continue
+		}
+		from, _ := strconv.Atoi(cur.Value)
+		to, _ := strconv.Atoi(levels[i+1].Value)
+		if to >= from {
+			continue
+		}
+		d.ActiveLevelDrop += float64(from-to) * float64(act) / float64(total)
+		d.IdleLevelDrop += float64(from-to) * float64(idle) / float64(total)
+	}
+	return d, errs
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+)
+
+// TestStepsCSV tests the steps taken between consecutive step counts.
+func TestStepsCSV(t *testing.T) {
+	counts := []bugreportutils.StepCount{
+		{TimeMs: 0, Steps: 100},
+		// 1000 steps over an hour are placed in its last 1000 seconds.
+		{TimeMs: 3600000, Steps: 1100},
+		// 10 steps faster than one a second take the whole interval.
+		{TimeMs: 3605000, Steps: 1110},
+		// No steps.
+		{TimeMs: 3700000, Steps: 1110},
+		// Reset by a reboot.
+		{TimeMs: 3800000, Steps: 5},
+		{TimeMs: 3900000, Steps: 25},
+	}
+	want := strings.Join([]string{
+		`Steps,int,2600000,3600000,1000,`,
+		`Steps,int,3600000,3605000,10,`,
+		`Steps,int,3880000,3900000,20,`,
+	}, "\n") + "\n"
+	if got := StepsCSV(counts); got != want {
+		t.Errorf("StepsCSV(%v)\n got: %q\n want: %q", counts, got, want)
+	}
+}
+
+// TestActivityDrainRates tests the split of the drain between the time walking and idle.
+func TestActivityDrainRates(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  *ActivityDrain
+	}{
+		{
+			desc: "No steps",
+			input: []string{
+				`Battery Level,int,0,3600000,100,`,
+				`Battery Level,int,3600000,7200000,99,`,
+			},
+		},
+		{
+			desc: "Walking and idle",
+			input: []string{
+				`Battery Level,int,0,3600000,100,`,
+				`Battery Level,int,3600000,7200000,98,`,
+				`Battery Level,int,7200000,10800000,97,`,
+				`Battery Level,int,10800000,14400000,96,`,
+				// Walking for half of the first level, 2000 steps.
+				`Steps,int,0,1800000,2000,`,
+				// The screen on for the second level.
+				`Screen,bool,3600000,7200000,true,`,
+				// Plugged in for the last level, with steps that aren't counted.
+				`Plugged,bool,10800000,14400000,true,`,
+				`Steps,int,10800000,11000000,500,`,
+			},
+			want: &ActivityDrain{
+				Steps:           2000,
+				ActiveLevelDrop: 1,
+				IdleLevelDrop:   2,
+				Active:          30 * time.Minute,
+				Idle:            90 * time.Minute,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(append([]string{csv.FileHeader}, test.input...), "\n")
+		got, errs := ActivityDrainRates(input)
+		if len(errs) > 0 {
+			t.Errorf("%s: ActivityDrainRates(%s) generated unexpected errors: %v", test.desc, input, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ActivityDrainRates(%s)\n got: %+v\n want: %+v", test.desc, input, got, test.want)
+		}
+	}
+
+	d := ActivityDrain{Steps: 2000, ActiveLevelDrop: 1, IdleLevelDrop: 2, Active: 30 * time.Minute, Idle: 90 * time.Minute}
+	if got := d.PerThousandSteps(); got != 0.5 {
+		t.Errorf("PerThousandSteps() = %v, want 0.5", got)
+	}
+	if got := d.IdlePerHour(); got != 2.0/1.5 {
+		t.Errorf("IdlePerHour() = %v, want %v", got, 2.0/1.5)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// alarms.go splits the alarms going off (Eal) into wakeup alarms, which woke the device from suspend
+// while the screen was off, and non-wakeup alarms, which went off while the device was awake anyway.
+// Only wakeup alarms add to the idle drain, by keeping the CPU awake for as long as the app's work takes.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// alarmMetric is the battery history CSV metric for the alarms going off.
+	alarmMetric = "Alarm"
+
+	// wakeupAlarmTag is the prefix of the tags of alarms scheduled as wakeup alarms.
+	// e.g. "*walarm*:com.example.app.SYNC"
+	wakeupAlarmTag = "*walarm*"
+
+	// wakeupAlarmWindowMs is the longest time after the CPU started running for an alarm going off to
+	// be considered to have woken it up.
+	wakeupAlarmWindowMs = 1000
+)
+
+// isWakeupAlarm returns whether the alarm woke the device from suspend, and the time the CPU then
+// stayed awake from when the alarm went off. The screen and running events must be sorted and non
+// overlapping. If the history doesn't show the CPU running when the alarm went off, an alarm with a
+// wakeup alarm tag going off while the screen was off is assumed to have woken the device.
+func isWakeupAlarm(alarm csv.Event, screenOn, running []csv.Event) (bool, int64) {
+	if inWindow(screenOn, alarm.Start) {
+		return false, 0
+	}
+	for _, r := range running {
+		if alarm.Start < r.Start || alarm.Start >= r.End {
+			continue
+		}
+		if alarm.Start-r.Start > wakeupAlarmWindowMs {
+			return false, 0
+		}
+		return true, r.End - alarm.Start
+	}
+	return strings.HasPrefix(strings.Trim(alarm.Value, `"`), wakeupAlarmTag), 0
+}
+
+// AddAlarmSummaries populates the WakeupAlarmSummary, WakeupAlarmAwakeSummary and NonWakeupAlarmSummary
+// of each summary from the battery history CSV generated by AnalyzeHistory. An alarm is in the summary
+// it went off in.
+func AddAlarmSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{alarmMetric, screen, cpuRunning})
+	var screenOn []csv.Event
+	for _, e := range es[screen] {
+		if e.Value != "false" {
+			screenOn = append(screenOn, e)
+		}
+	}
+	screenOn = csv.MergeEvents(screenOn)
+	running := es[cpuRunning]
+	sort.Sort(sortByStart(running))
+
+	type alarm struct {
+		e      csv.Event
+		app    string
+		wakeup bool
+		awake  time.Duration
+	}
+	var alarms []alarm
+	for _, e := range es[alarmMetric] {
+		appID, err := packageutils.AppIDFromString(e.Opt)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid alarm uid %q: %v", e.Opt, err))
+			continue
+		}
+		wakeup, awakeMs := isWakeupAlarm(e, screenOn, running)
+		alarms = append(alarms, alarm{e, fmt.Sprintf("UID %d", appID), wakeup, time.Duration(awakeMs) * time.Millisecond})
+	}
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.WakeupAlarmSummary = make(map[string]Dist)
+		s.WakeupAlarmAwakeSummary = make(map[string]Dist)
+		s.NonWakeupAlarmSummary = make(map[string]Dist)
+		for _, a := range alarms {
+			if a.e.Start < s.StartTimeMs || a.e.Start >= s.EndTimeMs {
+				continue
+			}
+			d := time.Duration(a.e.End-a.e.Start) * time.Millisecond
+			if !a.wakeup {
+				dist := s.NonWakeupAlarmSummary[a.app]
+				dist.addDuration(d)
+				s.NonWakeupAlarmSummary[a.app] = dist
+				continue
+			}
+			dist := s.WakeupAlarmSummary[a.app]
+			dist.addDuration(d)
+			s.WakeupAlarmSummary[a.app] = dist
+			awake := s.WakeupAlarmAwakeSummary[a.app]
+			awake.addDuration(a.awake)
+			s.WakeupAlarmAwakeSummary[a.app] = awake
+		}
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddAlarmSummaries tests the breakdown of each summary by wakeup and non-wakeup alarms.
+func TestAddAlarmSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Screen,bool,50000,60000,true,`,
+		`CPU running,string,10000,15000,qcom_wake,`,
+		`CPU running,string,20000,30000,qcom_wake,`,
+		`CPU running,string,50000,70000,qcom_wake,`,
+		// Woke the CPU up.
+		`Alarm,service,10200,10700,"*walarm*:com.example.SYNC",10045`,
+		// The CPU was already running.
+		`Alarm,service,25000,25500,"*walarm*:com.example.SYNC",10045`,
+		// Woke the CPU up, even though it isn't tagged as a wakeup alarm.
+		`Alarm,service,20500,21000,com.example.chat,1010060`,
+		// The screen was on.
+		`Alarm,service,55000,56000,"*walarm*:com.example.SYNC",10045`,
+		// No CPU running event, so the tag decides.
+		`Alarm,service,120000,121000,"*walarm*:com.example.SYNC",10045`,
+		`Alarm,service,130000,131000,"*alarm*:android.intent.action.TIME_TICK",1000`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 100000},
+		{StartTimeMs: 100000, EndTimeMs: 200000},
+	}
+	if errs := AddAlarmSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddAlarmSummaries generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		wakeup, awake, nonWakeup map[string]Dist
+	}{
+		{
+			wakeup: map[string]Dist{
+				"UID 10045": {Num: 1, TotalDuration: 500 * time.Millisecond, MaxDuration: 500 * time.Millisecond},
+				"UID 10060": {Num: 1, TotalDuration: 500 * time.Millisecond, MaxDuration: 500 * time.Millisecond},
+			},
+			awake: map[string]Dist{
+				"UID 10045": {Num: 1, TotalDuration: 4800 * time.Millisecond, MaxDuration: 4800 * time.Millisecond},
+				"UID 10060": {Num: 1, TotalDuration: 9500 * time.Millisecond, MaxDuration: 9500 * time.Millisecond},
+			},
+			nonWakeup: map[string]Dist{
+				"UID 10045": {Num: 2, TotalDuration: 1500 * time.Millisecond, MaxDuration: time.Second},
+			},
+		},
+		{
+			wakeup: map[string]Dist{
+				"UID 10045": {Num: 1, TotalDuration: time.Second, MaxDuration: time.Second},
+			},
+			awake: map[string]Dist{
+				"UID 10045": {Num: 1},
+			},
+			nonWakeup: map[string]Dist{
+				"UID 1000": {Num: 1, TotalDuration: time.Second, MaxDuration: time.Second},
+			},
+		},
+	}
+	for i, w := range want {
+		s := summaries[i]
+		if !reflect.DeepEqual(s.WakeupAlarmSummary, w.wakeup) {
+			t.Errorf("Summary %d WakeupAlarmSummary\n got: %v\n want: %v", i, s.WakeupAlarmSummary, w.wakeup)
+		}
+		if !reflect.DeepEqual(s.WakeupAlarmAwakeSummary, w.awake) {
+			t.Errorf("Summary %d WakeupAlarmAwakeSummary\n got: %v\n want: %v", i, s.WakeupAlarmAwakeSummary, w.awake)
+		}
+		if !reflect.DeepEqual(s.NonWakeupAlarmSummary, w.nonWakeup) {
+			t.Errorf("Summary %d NonWakeupAlarmSummary\n got: %v\n want: %v", i, s.NonWakeupAlarmSummary, w.nonWakeup)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// appkey.go keeps a canonical identity for the entries of the per app summaries. The summary map keys
+// are formatted differently depending on the metric (quoted service names, package names or "UID n"),
+// and entries of different UIDs with the same service name are merged, so they can't be reliably
+// joined across summaries or reports.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/packageutils"
+)
+
+// AppKey is the canonical identity of an app attributed summary entry.
+type AppKey struct {
+	// UID is the app ID of the app, 0 if unknown.
+	UID int32 `json:"uid"`
+	// Package is the name of the app package, empty if unknown, e.g. for shared UIDs.
+	Package string `json:"package,omitempty"`
+	// Label is the unquoted service name of the entry, e.g. the wakelock tag or sync authority. It's
+	// empty for summaries that are only broken down by app.
+	Label string `json:"label,omitempty"`
+}
+
+// AppDist is the distribution summary of an app attributed entry of a summary.
+type AppDist struct {
+	// Metric is the name of the ActivitySummary map the entry is in, e.g. WakeLockSummary.
+	Metric          string `json:"metric"`
+	Key             AppKey `json:"key"`
+	Num             int32  `json:"num"`
+	TotalDurationMs int64  `json:"totalDurationMs"`
+	MaxDurationMs   int64  `json:"maxDurationMs"`
+}
+
+// appMetric is the key of the per app stats accumulated during a summary.
+type appMetric struct {
+	metric string
+	key    AppKey
+}
+
+// appKey returns the canonical identity of the service.
+func (s *ServiceUID) appKey() AppKey {
+	var k AppKey
+	if s.Pkg != nil {
+		k.UID = s.Pkg.GetUid()
+		k.Package = s.Pkg.GetPkgName()
+	} else if appID, err := packageutils.AppIDFromString(s.UID); err == nil {
+		k.UID = appID
+	}
+	k.Label = s.Service
+	if l, err := strconv.Unquote(s.Service); err == nil {
+		k.Label = l
+	}
+	// Per app summaries use the app name as the service, which isn't a label.
+	if k.Label == k.Package || k.Label == fmt.Sprintf("UID %d", k.UID) {
+		k.Label = ""
+	}
+	return k
+}
+
+// appStat is the metric of a summary to add the per app stats of a service to, along with the summary map.
+// The zero value doesn't add per app stats, for summaries that aren't broken down by app.
+type appStat struct {
+	summary *ActivitySummary
+	metric  string
+}
+
+// add adds the duration of the service to the per app stats.
+func (a appStat) add(s *ServiceUID, d time.Duration) {
+	if a.summary == nil {
+		return
+	}
+	if a.summary.appStats == nil {
+		a.summary.appStats = make(map[appMetric]Dist)
+	}
+	k := appMetric{a.metric, s.appKey()}
+	dist := a.summary.appStats[k]
+	dist.addDuration(d)
+	a.summary.appStats[k] = dist
+}
+
+// byMetricAndDuration sorts app stats by metric, then in descending order of total duration, then in
+// descending order of count, for the metrics of instant events such as APWakeupSummary, then by key.
+type byMetricAndDuration []AppDist
+
+func (a byMetricAndDuration) Len() int      { return len(a) }
+func (a byMetricAndDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byMetricAndDuration) Less(i, j int) bool {
+	switch {
+	case a[i].Metric != a[j].Metric:
+		return a[i].Metric < a[j].Metric
+	case a[i].TotalDurationMs != a[j].TotalDurationMs:
+		return a[i].TotalDurationMs > a[j].TotalDurationMs
+	case a[i].Num != a[j].Num:
+		return a[i].Num > a[j].Num
+	case a[i].Key.UID != a[j].Key.UID:
+		return a[i].Key.UID < a[j].Key.UID
+	case a[i].Key.Package != a[j].Key.Package:
+		return a[i].Key.Package < a[j].Key.Package
+	}
+	return a[i].Key.Label < a[j].Key.Label
+}
+
+// concludeAppDists sets the AppDists of the summary from the per app stats accumulated during it.
+func (s *ActivitySummary) concludeAppDists() {
+	s.AppDists = nil
+	for k, d := range s.appStats {
+		s.AppDists = append(s.AppDists, AppDist{
+			Metric:          k.metric,
+			Key:             k.key,
+			Num:             d.Num,
+			TotalDurationMs: int64(d.TotalDuration / time.Millisecond),
+			MaxDurationMs:   int64(d.MaxDuration / time.Millisecond),
+		})
+	}
+	sort.Sort(byMetricAndDuration(s.AppDists))
+}
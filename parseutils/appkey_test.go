@@ -0,0 +1,122 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	usagepb "github.com/google/battery-historian/pb/usagestats_proto"
+)
+
+// TestAppKey tests the canonical identity of services.
+func TestAppKey(t *testing.T) {
+	tests := []struct {
+		desc string
+		suid ServiceUID
+		want AppKey
+	}{
+		{
+			desc: "quoted service without package",
+			suid: ServiceUID{Service: `"*alarm*"`, UID: "1010023"},
+			want: AppKey{UID: 10023, Label: "*alarm*"},
+		},
+		{
+			desc: "service with package",
+			suid: ServiceUID{
+				Service: `"com.google.android.gms/.gcm.GcmService"`,
+				UID:     "10011",
+				Pkg:     &usagepb.PackageInfo{PkgName: proto.String("com.google.android.gms"), Uid: proto.Int32(10011)},
+			},
+			want: AppKey{UID: 10011, Package: "com.google.android.gms", Label: "com.google.android.gms/.gcm.GcmService"},
+		},
+		{
+			desc: "per app summary using the package as the service",
+			suid: ServiceUID{
+				Service: `"com.google.android.gms"`,
+				Pkg:     &usagepb.PackageInfo{PkgName: proto.String("com.google.android.gms"), Uid: proto.Int32(10011)},
+			},
+			want: AppKey{UID: 10011, Package: "com.google.android.gms"},
+		},
+		{
+			desc: "per app summary using the UID as the service",
+			suid: ServiceUID{Service: `"UID 10023"`, UID: "10023"},
+			want: AppKey{UID: 10023},
+		},
+		{
+			desc: "unknown UID",
+			suid: ServiceUID{Service: "wakeup"},
+			want: AppKey{Label: "wakeup"},
+		},
+	}
+	for _, test := range tests {
+		if got := test.suid.appKey(); got != test.want {
+			t.Errorf("%s: appKey(%v) = %v, want %v", test.desc, test.suid, got, test.want)
+		}
+	}
+}
+
+// TestAppDists tests that entries of different apps with the same service name are kept separate.
+func TestAppDists(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,1,10011,"*sync*"`,
+		`9,hsp,2,1010023,"*sync*"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,1000,+Ewl=1`,
+		`9,h,2000,+Ewl=2`,
+		`9,h,3000,-Ewl=1`,
+		`9,h,1000,-Ewl=2`,
+	}, "\n")
+
+	want := []AppDist{
+		{
+			Metric:          "WakeLockDetailedSummary",
+			Key:             AppKey{UID: 10011, Label: "*sync*"},
+			Num:             1,
+			TotalDurationMs: 5000,
+			MaxDurationMs:   5000,
+		},
+		{
+			Metric:          "WakeLockDetailedSummary",
+			Key:             AppKey{UID: 10023, Label: "*sync*"},
+			Num:             1,
+			TotalDurationMs: 4000,
+			MaxDurationMs:   4000,
+		},
+	}
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, false)
+	validateHistory(input, t, result, 0, 1)
+
+	var got []AppDist
+	for _, d := range result.Summaries[0].AppDists {
+		if d.Metric == "WakeLockDetailedSummary" {
+			got = append(got, d)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].AppDists = %v, want %v", input, got, want)
+	}
+	// The string keyed summary merges both apps.
+	if d := result.Summaries[0].WakeLockDetailedSummary[`"*sync*"`]; d.Num != 2 {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].WakeLockDetailedSummary = %v, want 2 merged entries", input, result.Summaries[0].WakeLockDetailedSummary)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// apwakeup.go counts the application processor wakeups (Ewa) of each app. They are logged when
+// network traffic for the app wakes up the device, so are the closest proxy for push driven
+// wakeups on devices that no longer log the wakeup reasons of each app.
+
+// addAPWakeup counts an application processor wakeup by the app in the APWakeupSummary, and in the
+// APWakeupScreenOffSummary if the screen was off, as wakeups while the screen is on are mostly free.
+// The string pool entries of Ewa events usually have an empty service, so the app is summarized by
+// its name, or by its UID if unknown.
+func (s *ActivitySummary) addAPWakeup(suid ServiceUID, screenOff bool) error {
+	app, _, err := appServiceUID(suid)
+	if err != nil {
+		return err
+	}
+	add := func(m map[string]Dist, metric string) {
+		d := m[app.Service]
+		d.addDuration(0)
+		m[app.Service] = d
+		appStat{s, metric}.add(&app, 0)
+	}
+	add(s.APWakeupSummary, "APWakeupSummary")
+	if screenOff {
+		add(s.APWakeupScreenOffSummary, "APWakeupScreenOffSummary")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestAPWakeupSummaries tests the per app counts of application processor wakeups.
+func TestAPWakeupSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,1,10066,"com.google.android.apps.messaging"`,
+		`9,hsp,2,1010031,""`,
+		`9,h,0:RESET:TIME:1456809000000`,
+		`9,h,0,+S`,
+		`9,h,1000,Ewa=1`, // Screen on.
+		`9,h,1000,-S`,
+		`9,h,1000,Ewa=1`,
+		`9,h,1000,Ewa=2`,
+		`9,h,1000,Ewa=1`,
+	}, "\n")
+	wantAll := map[string]Dist{
+		`"com.google.android.apps.messaging"`: {Num: 3},
+		`"UID 10031"`:                         {Num: 1},
+	}
+	wantScreenOff := map[string]Dist{
+		`"com.google.android.apps.messaging"`: {Num: 2},
+		`"UID 10031"`:                         {Num: 1},
+	}
+	wantApps := []AppDist{
+		{Metric: "APWakeupScreenOffSummary", Key: AppKey{UID: 10066, Label: "com.google.android.apps.messaging"}, Num: 2},
+		{Metric: "APWakeupScreenOffSummary", Key: AppKey{UID: 10031}, Num: 1},
+		{Metric: "APWakeupSummary", Key: AppKey{UID: 10066, Label: "com.google.android.apps.messaging"}, Num: 3},
+		{Metric: "APWakeupSummary", Key: AppKey{UID: 10031}, Num: 1},
+	}
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	validateHistory(input, t, result, 0, 1)
+	s := result.Summaries[0]
+	if !reflect.DeepEqual(s.APWakeupSummary, wantAll) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].APWakeupSummary = %v, want %v", input, s.APWakeupSummary, wantAll)
+	}
+	if !reflect.DeepEqual(s.APWakeupScreenOffSummary, wantScreenOff) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].APWakeupScreenOffSummary = %v, want %v", input, s.APWakeupScreenOffSummary, wantScreenOff)
+	}
+	var gotApps []AppDist
+	for _, d := range s.AppDists {
+		if strings.HasPrefix(d.Metric, "APWakeup") {
+			gotApps = append(gotApps, d)
+		}
+	}
+	if !reflect.DeepEqual(gotApps, wantApps) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].AppDists =\n  %v\nwant APWakeup entries:\n  %v", input, gotApps, wantApps)
+	}
+}
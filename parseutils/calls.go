@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// calls.go breaks down the time and battery drain of each summary by phone call type, for both
+// cellular calls and the VoIP calls detected from the audio mode changes.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// CellularCall is the CallSummary key for cellular calls.
+	CellularCall = "Cellular"
+	// voIPCallPrefix is the prefix of the CallSummary keys for VoIP calls, followed by the app package.
+	voIPCallPrefix = "VoIP: "
+
+	// Battery history CSV metrics for phone calls. VoIP calls are added by the audio package.
+	phoneCall = "Phone call"
+	voIPCall  = "VoIP call"
+)
+
+// levelDrops returns the battery level drops in the battery level events, with the time of each drop.
+func levelDrops(levels []csv.Event) []csv.Event {
+	levels = append([]csv.Event(nil), levels...)
+	sort.Stable(sortByStart(levels))
+	var drops []csv.Event
+	for i := 1; i < len(levels); i++ {
+		prev, err1 := strconv.Atoi(levels[i-1].Value)
+		cur, err2 := strconv.Atoi(levels[i].Value)
+		if err1 == nil && err2 == nil && cur < prev {
+			drops = append(drops, csv.Event{Start: levels[i].Start, End: levels[i].Start, Value: strconv.Itoa(prev - cur)})
+		}
+	}
+	return drops
+}
+
+// AddCallSummaries populates the CallSummary and CallLevelDrop of each summary from the cellular and
+// VoIP calls in the battery history CSV generated by AnalyzeHistory. VoIP calls are keyed by app.
+func AddCallSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{phoneCall, voIPCall, BatteryLevel})
+	calls := make(map[string][]csv.Event)
+	for _, e := range es[phoneCall] {
+		if e.Value == "true" {
+			calls[CellularCall] = append(calls[CellularCall], e)
+		}
+	}
+	for _, e := range es[voIPCall] {
+		k := voIPCallPrefix + e.Value
+		calls[k] = append(calls[k], e)
+	}
+	drops := levelDrops(es[BatteryLevel])
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.CallSummary = make(map[string]Dist)
+		s.CallLevelDrop = make(map[string]int)
+		for k, cs := range calls {
+			var in []csv.Event
+			for _, c := range cs {
+				if d := csv.Overlap([]csv.Event{c}, s.StartTimeMs, s.EndTimeMs); d > 0 {
+					dist := s.CallSummary[k]
+					dist.addDuration(time.Duration(d) * time.Millisecond)
+					s.CallSummary[k] = dist
+					in = append(in, c)
+				}
+			}
+			for _, d := range drops {
+				if d.Start <= s.StartTimeMs || d.Start > s.EndTimeMs || !inWindow(in, d.Start) {
+					continue
+				}
+				n, _ := strconv.Atoi(d.Value)
+				s.CallLevelDrop[k] += n
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddCallSummaries tests the breakdown of each summary by cellular and VoIP calls.
+func TestAddCallSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,60000,90,`,
+		`Battery Level,int,60000,120000,89,`,
+		`Battery Level,int,120000,180000,87,`,
+		`Battery Level,int,180000,240000,86,`,
+		`Battery Level,int,240000,300000,85,`,
+		`Phone call,bool,30000,130000,true,`,
+		`VoIP call,service,170000,250000,com.whatsapp,10123`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 150000},
+		{StartTimeMs: 150000, EndTimeMs: 300000},
+	}
+	if errs := AddCallSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddCallSummaries generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		calls map[string]Dist
+		drops map[string]int
+	}{
+		{
+			calls: map[string]Dist{
+				CellularCall: {Num: 1, TotalDuration: 100 * time.Second, MaxDuration: 100 * time.Second},
+			},
+			// The level drops at 60s and 120s are during the call.
+			drops: map[string]int{CellularCall: 3},
+		},
+		{
+			calls: map[string]Dist{
+				"VoIP: com.whatsapp": {Num: 1, TotalDuration: 80 * time.Second, MaxDuration: 80 * time.Second},
+			},
+			drops: map[string]int{"VoIP: com.whatsapp": 2},
+		},
+	}
+	for i, w := range want {
+		s := summaries[i]
+		if !reflect.DeepEqual(s.CallSummary, w.calls) {
+			t.Errorf("Summary %d CallSummary\n got: %v\n want: %v", i, s.CallSummary, w.calls)
+		}
+		if !reflect.DeepEqual(s.CallLevelDrop, w.drops) {
+			t.Errorf("Summary %d CallLevelDrop\n got: %v\n want: %v", i, s.CallLevelDrop, w.drops)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// causechain.go links each CPU running event to what kept the CPU awake: the wakeup reason that woke
+// it, the first wakelock taken after it and the first app level event after it. The chain answers
+// "why was the CPU awake here" without lining up the rows of the timeline by hand.
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// causeWakelockMetrics are the battery history CSV metrics of the wakelocks in a cause chain.
+var causeWakelockMetrics = []string{"Partial wakelock", "Wakelock_in"}
+
+// causeAppMetrics are the battery history CSV metrics of the app level events in a cause chain. If
+// several start at the same time, the earlier metric in the list is in the chain.
+var causeAppMetrics = []string{alarm, "SyncManager", "JobScheduler"}
+
+// CPURunningCause is the cause chain of a CPU running event, stored as JSON in the opt field of its
+// battery history CSV row. Links that aren't known are empty.
+type CPURunningCause struct {
+	// WakeupReason is the first wakeup reason of the event.
+	WakeupReason string `json:"wakeupReason,omitempty"`
+	// Wakelock is the first wakelock taken during the event.
+	Wakelock string `json:"wakelock,omitempty"`
+	// AppMetric and AppEvent are the metric and value of the first app level event during the event.
+	AppMetric string `json:"appMetric,omitempty"`
+	AppEvent  string `json:"appEvent,omitempty"`
+}
+
+// String returns the readable chain, e.g. "Abort:wlan -> NlpWakeLock -> Alarm: com.google.android.gms".
+func (c CPURunningCause) String() string {
+	links := []string{c.WakeupReason, c.Wakelock, c.AppEvent}
+	if c.AppEvent != "" {
+		links[2] = c.AppMetric + ": " + c.AppEvent
+	}
+	for i, l := range links {
+		if l == "" {
+			links[i] = "?"
+		}
+	}
+	return strings.Join(links, " -> ")
+}
+
+// firstWakeupReason returns the first wakeup reason in the value of a CPU running event. Each
+// reason is in the format "start~end~reason" or "time~reason", and reasons are separated by "|".
+func firstWakeupReason(value string) string {
+	first := strings.SplitN(value, "|", 2)[0]
+	parts := strings.Split(first, "~")
+	return parts[len(parts)-1]
+}
+
+// firstStarting returns the first of the events, sorted by start time, starting from start to end
+// inclusive, and whether there is one.
+func firstStarting(es []csv.Event, start, end int64) (csv.Event, bool) {
+	i := sort.Search(len(es), func(i int) bool { return es[i].Start >= start })
+	if i == len(es) || es[i].Start > end {
+		return csv.Event{}, false
+	}
+	return es[i], true
+}
+
+// firstOf returns the earliest of the first events of the metrics starting from start to end
+// inclusive, with its metric. Ties go to the earlier metric in the list.
+func firstOf(es map[string][]csv.Event, metrics []string, start, end int64) (string, csv.Event, bool) {
+	var metric string
+	var first csv.Event
+	found := false
+	for _, m := range metrics {
+		e, ok := firstStarting(es[m], start, end)
+		if ok && (!found || e.Start < first.Start) {
+			metric, first, found = m, e, true
+		}
+	}
+	return metric, first, found
+}
+
+// cpuRunningCause returns the cause chain of the CPU running event.
+func cpuRunningCause(r csv.Event, es map[string][]csv.Event) CPURunningCause {
+	c := CPURunningCause{WakeupReason: firstWakeupReason(r.Value)}
+	if _, e, ok := firstOf(es, causeWakelockMetrics, r.Start, r.End); ok {
+		c.Wakelock = e.Value
+	}
+	if m, e, ok := firstOf(es, causeAppMetrics, r.Start, r.End); ok {
+		c.AppMetric, c.AppEvent = m, e.Value
+	}
+	return c
+}
+
+// AddCPURunningCauses returns the battery history CSV generated by AnalyzeHistory with the cause
+// chain of each CPU running event in the opt field of its row, as a JSON CPURunningCause. Rows that
+// can't be parsed are dropped.
+func AddCPURunningCauses(csvInput string) (string, []error) {
+	metrics := append(append([]string{}, causeWakelockMetrics...), causeAppMetrics...)
+	// The invalid rows are reported by MapEvents.
+	es, _ := csv.ExtractEvents(csvInput, metrics)
+	for _, m := range metrics {
+		sort.Sort(sortByStart(es[m]))
+	}
+	return csv.MapEvents(csvInput, func(metric string, e csv.Event) (csv.Event, bool) {
+		if metric == cpuRunning {
+			// Marshaling a struct of strings can't fail.
+			c, _ := json.Marshal(cpuRunningCause(e, es))
+			e.Opt = string(c)
+		}
+		return e, true
+	})
+}
+
+// CPURunningCauseOf returns the cause chain added by AddCPURunningCauses to the CPU running event,
+// and whether it has one.
+func CPURunningCauseOf(e csv.Event) (CPURunningCause, bool) {
+	var c CPURunningCause
+	if !strings.HasPrefix(e.Opt, "{") || json.Unmarshal([]byte(e.Opt), &c) != nil {
+		return CPURunningCause{}, false
+	}
+	return c, true
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddCPURunningCauses tests the cause chains added to the CPU running events.
+func TestAddCPURunningCauses(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`CPU running,string,1000,5000,1000~1000~Abort:wlan|1200~Unknown wakeup reason,`,
+		`Wakelock_in,service,1500,2000,*alarm*,1000`,
+		`Partial wakelock,service,1200,2000,NlpWakeLock,`,
+		`SyncManager,service,1800,3000,com.example.provider,10045`,
+		`Alarm,service,1800,1800,com.google.android.gms,10010`,
+		// Without wakelocks or app events.
+		`CPU running,string,10000,11000,10000~Unknown wakeup reason,`,
+	}, "\n")
+	want := strings.Join([]string{
+		csv.FileHeader,
+		`CPU running,string,1000,5000,1000~1000~Abort:wlan|1200~Unknown wakeup reason,"{""wakeupReason"":""Abort:wlan"",""wakelock"":""NlpWakeLock"",""appMetric"":""Alarm"",""appEvent"":""com.google.android.gms""}"`,
+		`Wakelock_in,service,1500,2000,*alarm*,1000`,
+		`Partial wakelock,service,1200,2000,NlpWakeLock,`,
+		`SyncManager,service,1800,3000,com.example.provider,10045`,
+		`Alarm,service,1800,1800,com.google.android.gms,10010`,
+		`CPU running,string,10000,11000,10000~Unknown wakeup reason,"{""wakeupReason"":""Unknown wakeup reason""}"`,
+	}, "\n")
+	got, errs := AddCPURunningCauses(input)
+	if len(errs) > 0 {
+		t.Fatalf("AddCPURunningCauses(%v) generated unexpected errors: %v", input, errs)
+	}
+	if got = strings.TrimSpace(got); got != want {
+		t.Errorf("AddCPURunningCauses(%v)\n got: %v\n want: %v", input, got, want)
+	}
+
+	es, _ := csv.ExtractEvents(got, []string{cpuRunning})
+	var chains []string
+	for _, e := range es[cpuRunning] {
+		c, _ := CPURunningCauseOf(e)
+		chains = append(chains, c.String())
+	}
+	if want := "Abort:wlan -> NlpWakeLock -> Alarm: com.google.android.gms,Unknown wakeup reason -> ? -> ?"; strings.Join(chains, ",") != want {
+		t.Errorf("Cause chains = %q, want %q", chains, want)
+	}
+}
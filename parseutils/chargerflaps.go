@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// chargerflaps.go handles the charging status flapping between charging and discharging, e.g. with a
+// loose cable or a flaky charger. Each change of status starts a new summary, so hundreds of flaps
+// fragment the analysis into summaries too short to be useful. Changes reverted within the debounce
+// time, which is off by default, are kept on the timeline, but don't start new summaries.
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// DefaultChargingDebounce is the default longest time a charging status change can last for it to
+	// be taken for charger flapping rather than the device being plugged in or unplugged. Debouncing is
+	// off by default, so every change starts a new summary.
+	DefaultChargingDebounce time.Duration = 0
+
+	// ChargerFlapDuration is the longest time a charging status change can last for it to be counted as
+	// a flap of the charging status, e.g. by the charger flapping finding, whether or not it is debounced.
+	ChargerFlapDuration = 30 * time.Second
+
+	chargingStatus = "Charging status"
+)
+
+// statusChange is a change between the charging and discharging status on a history line.
+type statusChange struct {
+	line     int
+	ms       int64
+	charging bool
+}
+
+// lineStatus returns the charging status set on the history line, if the line changes it to
+// charging ("c") or discharging ("d" or "n").
+func lineStatus(line string) (charging, ok bool) {
+	parts := strings.Split(line, ",")
+	if len(parts) < 4 {
+		return false, false
+	}
+	for _, part := range parts[3:] {
+		t, isToken := parseHistoryToken(part)
+		if !isToken || t.Key != "Bs" {
+			continue
+		}
+		switch t.Value {
+		case "c":
+			charging, ok = true, true
+		case "d", "n":
+			charging, ok = false, true
+		}
+	}
+	return charging, ok
+}
+
+// debouncedStatusLines returns the indices of the history lines with a charging status change that is
+// reverted within debounce, and of the lines reverting them. Each change is paired with at most one
+// revert, so that the last change of a burst of flaps, which sticks, isn't debounced. The first status
+// of each boot isn't a change, and changes aren't paired across reboots. Nil is returned if debounce
+// isn't positive.
+func debouncedStatusLines(h []string, debounce time.Duration) (map[int]bool, error) {
+	if debounce <= 0 {
+		return nil, nil
+	}
+	debounced := make(map[int]bool)
+	debounceMs := int64(debounce / time.Millisecond)
+	var changes []statusChange
+	// known and last are whether the status is known in the current boot, and the last status.
+	known, last := false, false
+	pair := func() {
+		for i := 0; i+1 < len(changes); i++ {
+			if changes[i+1].ms-changes[i].ms < debounceMs {
+				debounced[changes[i].line] = true
+				debounced[changes[i+1].line] = true
+				i++
+			}
+		}
+		changes, known = nil, false
+	}
+	var cur int64
+	for i, line := range h {
+		line = strings.TrimSpace(line)
+		if StartRE.MatchString(line) {
+			pair()
+			continue
+		}
+		ts, _, ok, err := timeStatement(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cur = ts
+			continue
+		}
+		d, err := lineDelta(line)
+		if err != nil {
+			return nil, err
+		}
+		cur += d
+		charging, ok := lineStatus(line)
+		if !ok {
+			continue
+		}
+		if known && charging != last {
+			changes = append(changes, statusChange{i, cur, charging})
+		}
+		known, last = true, charging
+	}
+	pair()
+	return debounced, nil
+}
+
+// ChargerFlaps returns the charging and discharging status events shorter than debounce in the battery
+// history CSV generated by AnalyzeHistory, i.e. the flaps of the charging status, sorted by start time.
+// The last status event isn't a flap, as it only ends with the history.
+func ChargerFlaps(csvInput string, debounce time.Duration) ([]csv.Event, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{chargingStatus})
+	statuses := es[chargingStatus]
+	sort.Sort(sortByStart(statuses))
+	debounceMs := int64(debounce / time.Millisecond)
+	var flaps []csv.Event
+	for i := 0; i+1 < len(statuses); i++ {
+		e := statuses[i]
+		if e.Value != "c" && e.Value != "d" && e.Value != "n" {
+			continue
+		}
+		if e.End-e.Start < debounceMs {
+			flaps = append(flaps, e)
+		}
+	}
+	return flaps, errs
+}
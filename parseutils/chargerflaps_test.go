@@ -0,0 +1,87 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestChargingDebounce tests that charging status flaps don't split the summaries when debounced.
+func TestChargingDebounce(t *testing.T) {
+	input := strings.Join([]string{
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=90,Bs=d`,
+		// Two flaps from a loose cable.
+		`9,h,60000,Bs=c`,
+		`9,h,2000,Bs=d`,
+		`9,h,60000,Bs=c`,
+		`9,h,1000,Bs=d`,
+		// Plugged in for good.
+		`9,h,60000,Bs=c`,
+		`9,h,600000,Bl=95`,
+	}, "\n")
+	tests := []struct {
+		desc     string
+		debounce time.Duration
+		want     [][2]int64
+	}{
+		{
+			desc: "Not debounced",
+			want: [][2]int64{
+				{1422620451417, 1422620511417},
+				{1422620513417, 1422620573417},
+				{1422620574417, 1422620634417},
+			},
+		},
+		{
+			desc:     "Debounced",
+			debounce: 30 * time.Second,
+			want:     [][2]int64{{1422620451417, 1422620634417}},
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		rep := AnalyzeHistoryWithOptions(&b, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{ChargingDebounce: test.debounce})
+		if len(rep.Errs) > 0 {
+			t.Errorf("%v: AnalyzeHistory generated unexpected errors: %v", test.desc, rep.Errs)
+			continue
+		}
+		var got [][2]int64
+		for _, s := range rep.Summaries {
+			got = append(got, [2]int64{s.StartTimeMs, s.EndTimeMs})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: AnalyzeHistory generated summaries %v, want %v", test.desc, got, test.want)
+		}
+		// The flaps are on the timeline either way.
+		flaps, errs := ChargerFlaps(b.String(), ChargerFlapDuration)
+		if len(errs) > 0 {
+			t.Errorf("%v: ChargerFlaps generated unexpected errors: %v", test.desc, errs)
+		}
+		want := []csv.Event{
+			{Type: "string", Start: 1422620511417, End: 1422620513417, Value: "c"},
+			{Type: "string", Start: 1422620573417, End: 1422620574417, Value: "c"},
+		}
+		if !reflect.DeepEqual(flaps, want) {
+			t.Errorf("%v: ChargerFlaps()\n got: %v\n want: %v", test.desc, flaps, want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// chargetemp.go flags the charging sessions where the battery got too hot, or heated up unusually
+// fast for the charging current. Both can be signs of a damaged or swelling battery, so they are
+// surfaced as warnings rather than left for the reader to spot in the temperature series.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+)
+
+const (
+	// temperature is the battery history CSV metric for the battery temperature, in tenths of a degree Celsius.
+	temperature = "Temperature"
+
+	// MaxChargingTemperatureC is the battery temperature above which charging is flagged, the upper
+	// limit of the usual charging range of Li-ion batteries.
+	MaxChargingTemperatureC = 45.0
+
+	// maxRisePerAmpCPerHour is the temperature rise per hour, for each amp of charging current,
+	// above which charging is flagged.
+	maxRisePerAmpCPerHour = 10.0
+	// maxRiseCPerHour is the temperature rise per hour above which charging is flagged when the
+	// charging current is unknown or too low to compare to.
+	maxRiseCPerHour = 10.0
+	// minComparableCurrentMA is the lowest mean charging current the temperature rise is compared to.
+	minComparableCurrentMA = 100.0
+	// riseWindow is the shortest time a temperature rise is measured over, so that the noise of
+	// consecutive readings isn't taken for a fast rise.
+	riseWindow = 10 * time.Minute
+)
+
+// ChargingTemperatureAlert is a charging session with an abnormal battery temperature.
+type ChargingTemperatureAlert struct {
+	Session ChargeSession
+	// MaxTemperatureC is the highest battery temperature during the session, in degrees Celsius.
+	MaxTemperatureC float64
+	// MaxRiseCPerHour is the fastest temperature rise during the session, over at least riseWindow.
+	MaxRiseCPerHour float64
+	// MeanCurrentMA is the mean charging current during the session, or 0 if unknown.
+	MeanCurrentMA float64
+	// Reasons are the reasons the session was flagged, printed in the default language.
+	Reasons []messages.Message
+}
+
+// Start returns the start of the charging session.
+func (a ChargingTemperatureAlert) Start() time.Time {
+	return time.Unix(0, a.Session.StartMs*int64(time.Millisecond))
+}
+
+// Duration returns the length of the charging session.
+func (a ChargingTemperatureAlert) Duration() time.Duration {
+	return time.Duration(a.Session.EndMs-a.Session.StartMs) * time.Millisecond
+}
+
+// temperatureReading is a battery temperature in degrees Celsius at a time.
+type temperatureReading struct {
+	ms int64
+	c  float64
+}
+
+// sessionTemperatures returns the readings during the session, starting with the temperature at the
+// start of the session if known. The events are sorted by start time.
+func sessionTemperatures(es []csv.Event, s ChargeSession) []temperatureReading {
+	var readings []temperatureReading
+	for _, e := range es {
+		if e.Start > s.EndMs {
+			break
+		}
+		v, err := strconv.Atoi(e.Value)
+		if err != nil {
+			continue
+		}
+		r := temperatureReading{e.Start, float64(v) / 10}
+		switch {
+		case e.Start >= s.StartMs:
+			readings = append(readings, r)
+		case e.End > s.StartMs:
+			// The temperature the session started at.
+			r.ms = s.StartMs
+			readings = append(readings, r)
+		}
+	}
+	return readings
+}
+
+// maxRise returns the fastest temperature rise in degrees per hour from each reading to the first
+// reading at least riseWindow later.
+func maxRise(readings []temperatureReading) float64 {
+	var rise float64
+	windowMs := int64(riseWindow / time.Millisecond)
+	j := 0
+	for i, r := range readings {
+		if j < i {
+			j = i
+		}
+		for j < len(readings) && readings[j].ms-r.ms < windowMs {
+			j++
+		}
+		if j == len(readings) {
+			break
+		}
+		d := time.Duration(readings[j].ms-r.ms) * time.Millisecond
+		if v := (readings[j].c - r.c) / d.Hours(); v > rise {
+			rise = v
+		}
+	}
+	return rise
+}
+
+// meanCurrent returns the mean charging current of the points in the session, weighted by duration,
+// or 0 if there are none.
+func meanCurrent(points []ChargingCurrentPoint, s ChargeSession) float64 {
+	var sum float64
+	var total int64
+	for _, p := range points {
+		if p.StartMs < s.StartMs || p.EndMs > s.EndMs {
+			continue
+		}
+		sum += p.MilliAmps * float64(p.EndMs-p.StartMs)
+		total += p.EndMs - p.StartMs
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / float64(total)
+}
+
+// ChargingTemperatureAlerts returns the charging sessions where the battery temperature went over
+// MaxChargingTemperatureC, or rose faster than expected for the charging current, from the battery
+// history CSV generated by AnalyzeHistory.
+func ChargingTemperatureAlerts(csvInput string) ([]ChargingTemperatureAlert, []error) {
+	sessions, errs := ChargeSessions(csvInput)
+	if len(sessions) == 0 {
+		return nil, errs
+	}
+	points, cErrs := ChargingCurrent(csvInput)
+	errs = append(errs, cErrs...)
+	es, tErrs := csv.ExtractEvents(csvInput, []string{temperature})
+	errs = append(errs, tErrs...)
+	temps := es[temperature]
+	sort.Sort(sortByStart(temps))
+
+	var alerts []ChargingTemperatureAlert
+	for _, s := range sessions {
+		readings := sessionTemperatures(temps, s)
+		if len(readings) == 0 {
+			continue
+		}
+		a := ChargingTemperatureAlert{
+			Session:         s,
+			MaxTemperatureC: readings[0].c,
+			MaxRiseCPerHour: maxRise(readings),
+			MeanCurrentMA:   meanCurrent(points, s),
+		}
+		for _, r := range readings {
+			if r.c > a.MaxTemperatureC {
+				a.MaxTemperatureC = r.c
+			}
+		}
+		if a.MaxTemperatureC > MaxChargingTemperatureC {
+			a.Reasons = append(a.Reasons, messages.New(messages.ChargingTooHot, a.MaxTemperatureC, MaxChargingTemperatureC))
+		}
+		if a.MeanCurrentMA >= minComparableCurrentMA {
+			if perAmp := a.MaxRiseCPerHour / (a.MeanCurrentMA / 1000); perAmp > maxRisePerAmpCPerHour {
+				a.Reasons = append(a.Reasons, messages.New(messages.ChargingFastRisePerMA, a.MaxRiseCPerHour, a.MeanCurrentMA, maxRisePerAmpCPerHour))
+			}
+		} else if a.MaxRiseCPerHour > maxRiseCPerHour {
+			a.Reasons = append(a.Reasons, messages.New(messages.ChargingFastRise, a.MaxRiseCPerHour, maxRiseCPerHour))
+		}
+		if len(a.Reasons) > 0 {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts, errs
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestChargingTemperatureAlerts tests the detection of abnormal battery temperatures while charging.
+func TestChargingTemperatureAlerts(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []string
+	}{
+		{
+			desc: "Normal charging",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,3600000,a,`,
+				`Coulomb charge,int,0,1800000,1000,`,
+				`Coulomb charge,int,1800000,3600000,1900,`,
+				`Coulomb charge,int,3600000,4000000,2800,`,
+				`Temperature,int,0,1800000,300,`,
+				`Temperature,int,1800000,4000000,320,`,
+			},
+		},
+		{
+			desc: "Too hot",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,3600000,a,`,
+				`Coulomb charge,int,0,1800000,1000,`,
+				`Coulomb charge,int,1800000,3600000,1900,`,
+				`Coulomb charge,int,3600000,4000000,2800,`,
+				`Temperature,int,0,1800000,440,`,
+				`Temperature,int,1800000,4000000,460,`,
+			},
+			want: []string{"battery reached 46.0°C, over 45°C"},
+		},
+		{
+			desc: "Fast rise at a low current",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,3600000,u,`,
+				`Coulomb charge,int,0,3600000,1000,`,
+				`Coulomb charge,int,3600000,4000000,1050,`,
+				// The reading before the session is the temperature it started at.
+				`Temperature,int,-100000,600000,300,`,
+				`Temperature,int,600000,4000000,330,`,
+			},
+			want: []string{"temperature rose 18.0°C/h, over 10°C/h"},
+		},
+		{
+			desc: "Fast rise for the current",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,3600000,a,`,
+				`Coulomb charge,int,0,3600000,1000,`,
+				`Coulomb charge,int,3600000,4000000,1500,`,
+				`Temperature,int,0,300000,300,`,
+				// Too soon after the first reading to measure the rise from it.
+				`Temperature,int,300000,1200000,310,`,
+				`Temperature,int,1200000,4000000,320,`,
+			},
+			want: []string{"temperature rose 6.0°C/h at 500 mA, over 10°C/h per amp"},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		alerts, errs := ChargingTemperatureAlerts(input)
+		if len(errs) > 0 {
+			t.Errorf("%v: ChargingTemperatureAlerts(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		var got []string
+		for _, a := range alerts {
+			for _, r := range a.Reasons {
+				got = append(got, r.String())
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: ChargingTemperatureAlerts(%v) reasons\n got: %q\n want: %q", test.desc, input, got, test.want)
+		}
+	}
+}
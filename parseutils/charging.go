@@ -0,0 +1,265 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// charging.go extracts the charging sessions per plug type and the charging current from the generated
+// battery history CSV.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// plugType is the battery history CSV metric for the plug type.
+	plugType = "Plug"
+	// plugNone is the plug type value when the device is not plugged in.
+	plugNone = "n"
+)
+
+// plugTypeNames maps the battery history plug type values to readable names.
+var plugTypeNames = map[string]string{
+	"n": "None",
+	"a": "AC",
+	"u": "USB",
+	"w": "Wireless",
+}
+
+// PlugTypeName returns the readable name for a battery history plug type value,
+// or the value itself if it is unknown.
+func PlugTypeName(v string) string {
+	if n, ok := plugTypeNames[v]; ok {
+		return n
+	}
+	return v
+}
+
+// namedPlugTypes returns the plug type summary keyed by readable names.
+func namedPlugTypes(m map[string]Dist) map[string]Dist {
+	named := make(map[string]Dist, len(m))
+	for k, v := range m {
+		named[PlugTypeName(k)] = v
+	}
+	return named
+}
+
+// ChargeSession is a continuous period the device was plugged into the same type of charger.
+type ChargeSession struct {
+	PlugType       string
+	StartMs, EndMs int64
+	// The battery levels at the start and end of the session, or -1 if unknown.
+	StartLevel, EndLevel int
+}
+
+// ChargeStats aggregates the charging sessions of a plug type.
+type ChargeStats struct {
+	PlugType      string
+	Sessions      int
+	TotalDuration time.Duration
+	// LevelGained is the total battery level gained over the sessions with known levels.
+	LevelGained int
+	// levelDuration is the total duration of the sessions with known levels.
+	levelDuration time.Duration
+}
+
+// LevelPerHour returns the average charge speed in battery level percent per hour.
+func (c ChargeStats) LevelPerHour() float64 {
+	if c.levelDuration == 0 {
+		return 0
+	}
+	return float64(c.LevelGained) / c.levelDuration.Hours()
+}
+
+// ChargeSessions returns the charging sessions found in the battery history CSV generated by AnalyzeHistory.
+func ChargeSessions(csvInput string) ([]ChargeSession, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{plugType, BatteryLevel})
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	sort.Sort(sortByStart(levels))
+
+	plugs := es[plugType]
+	sort.Sort(sortByStart(plugs))
+	var sessions []ChargeSession
+	for _, e := range plugs {
+		if e.Value == plugNone {
+			continue
+		}
+		s := ChargeSession{
+			PlugType:   PlugTypeName(e.Value),
+			StartMs:    e.Start,
+			EndMs:      e.End,
+			StartLevel: -1,
+			EndLevel:   -1,
+		}
+		if l, ok := levelAt(levels, e.Start); ok {
+			s.StartLevel = l
+		}
+		if l, ok := levelAt(levels, e.End); ok {
+			s.EndLevel = l
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, errs
+}
+
+// ChargeStatsByPlugType aggregates the given sessions per plug type, sorted by plug type name.
+func ChargeStatsByPlugType(sessions []ChargeSession) []ChargeStats {
+	m := make(map[string]*ChargeStats)
+	var types []string
+	for _, s := range sessions {
+		c, ok := m[s.PlugType]
+		if !ok {
+			c = &ChargeStats{PlugType: s.PlugType}
+			m[s.PlugType] = c
+			types = append(types, s.PlugType)
+		}
+		d := time.Duration(s.EndMs-s.StartMs) * time.Millisecond
+		c.Sessions++
+		c.TotalDuration += d
+		if s.StartLevel >= 0 && s.EndLevel >= 0 {
+			c.LevelGained += s.EndLevel - s.StartLevel
+			c.levelDuration += d
+		}
+	}
+	sort.Strings(types)
+	var stats []ChargeStats
+	for _, t := range types {
+		stats = append(stats, *m[t])
+	}
+	return stats
+}
+
+const (
+	// coulombCharge is the battery history CSV metric for the coulomb counter, in mAh.
+	coulombCharge = "Coulomb charge"
+
+	// ChargingCurrentMetric is the battery history CSV metric for the charging current derived from the coulomb counter.
+	ChargingCurrentMetric = "Charging current (mA)"
+	// ChargingPhaseMetric is the battery history CSV metric for the detected charging phases.
+	ChargingPhaseMetric = "Charging phase"
+
+	// Charging phases, relative to the peak charging current of a session.
+	PhaseFast    = "fast"
+	PhaseTaper   = "taper"
+	PhaseTrickle = "trickle"
+
+	// fastFraction is the fraction of the session peak current at or above which charging is considered fast.
+	fastFraction = 0.7
+	// trickleFraction is the fraction of the session peak current below which charging is considered trickle.
+	trickleFraction = 0.2
+)
+
+// ChargingCurrentPoint is the average charging current between two consecutive coulomb counter readings.
+type ChargingCurrentPoint struct {
+	StartMs, EndMs int64
+	MilliAmps      float64
+	Phase          string
+}
+
+// chargingPhase classifies a charging current relative to the peak current of its session.
+func chargingPhase(mA, peak float64) string {
+	switch {
+	case peak > 0 && mA >= fastFraction*peak:
+		return PhaseFast
+	case peak > 0 && mA >= trickleFraction*peak:
+		return PhaseTaper
+	default:
+		return PhaseTrickle
+	}
+}
+
+// ChargingCurrent computes the charging current from the coulomb counter deltas while the device was plugged in,
+// from the battery history CSV generated by AnalyzeHistory. Each point is classified as fast, taper or trickle
+// charging relative to the peak current of its charging session.
+func ChargingCurrent(csvInput string) ([]ChargingCurrentPoint, []error) {
+	sessions, errs := ChargeSessions(csvInput)
+	if len(sessions) == 0 {
+		return nil, errs
+	}
+	es, extractErrs := csv.ExtractEvents(csvInput, []string{coulombCharge})
+	errs = append(errs, extractErrs...)
+	var readings []csv.Event
+	for _, e := range es[coulombCharge] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		readings = append(readings, e)
+	}
+	sort.Sort(sortByStart(readings))
+
+	var points []ChargingCurrentPoint
+	// The sessions are sorted by start time, so the readings before each session are skipped once, and
+	// only the readings of the session are walked.
+	first := 0
+	for _, s := range sessions {
+		var session []ChargingCurrentPoint
+		var peak float64
+		prev := -1
+		for first < len(readings) && readings[first].Start < s.StartMs {
+			first++
+		}
+		for i := first; i < len(readings) && readings[i].Start <= s.EndMs; i++ {
+			r := readings[i]
+			if prev >= 0 && r.Start > readings[prev].Start {
+				from, _ := strconv.Atoi(readings[prev].Value)
+				to, _ := strconv.Atoi(r.Value)
+				d := time.Duration(r.Start-readings[prev].Start) * time.Millisecond
+				mA := float64(to-from) / d.Hours()
+				if mA > peak {
+					peak = mA
+				}
+				session = append(session, ChargingCurrentPoint{StartMs: readings[prev].Start, EndMs: r.Start, MilliAmps: mA})
+			}
+			prev = i
+		}
+		for i := range session {
+			session[i].Phase = chargingPhase(session[i].MilliAmps, peak)
+		}
+		points = append(points, session...)
+	}
+	return points, errs
+}
+
+// WriteChargingCurrent writes a ChargingCurrentMetric row for each charging current point computed from the
+// battery history CSV, and a ChargingPhaseMetric row for each run of points in the same phase, so they can be
+// plotted on the timeline.
+func WriteChargingCurrent(w io.Writer, csvInput string) []error {
+	points, errs := ChargingCurrent(csvInput)
+	csvState := csv.NewState(w, false)
+	for _, p := range points {
+		csvState.Print(ChargingCurrentMetric, "float", p.StartMs, p.EndMs, fmt.Sprintf("%.3f", p.MilliAmps), "")
+	}
+	for i := 0; i < len(points); {
+		j := i + 1
+		for j < len(points) && points[j].Phase == points[i].Phase && points[j].StartMs == points[j-1].EndMs {
+			j++
+		}
+		csvState.Print(ChargingPhaseMetric, "string", points[i].StartMs, points[j-1].EndMs, points[i].Phase, "")
+		i = j
+	}
+	return errs
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestChargeSessions tests the extraction of charging sessions and their aggregation per plug type.
+func TestChargeSessions(t *testing.T) {
+	input := strings.Join([]string{
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=50,Bs=c,Bp=w,+BP`,
+		`9,h,60000,Bl=51`,
+		`9,h,60000,Bp=n,-BP`, // Wireless charger toggled off.
+		`9,h,1000,Bp=w,+BP`,
+		`9,h,119000,Bl=53`,
+		`9,h,1000,Bp=u`,
+		`9,h,3600000,Bl=60`,
+		`9,h,0,Bs=d,Bp=n,-BP`,
+	}, "\n")
+	wantSessions := []ChargeSession{
+		{PlugType: "Wireless", StartMs: 1000000, EndMs: 1120000, StartLevel: 50, EndLevel: 51},
+		{PlugType: "Wireless", StartMs: 1121000, EndMs: 1241000, StartLevel: 51, EndLevel: 53},
+		{PlugType: "USB", StartMs: 1241000, EndMs: 4841000, StartLevel: 53, EndLevel: 60},
+	}
+	wantStats := []ChargeStats{
+		{PlugType: "USB", Sessions: 1, TotalDuration: time.Hour, LevelGained: 7, levelDuration: time.Hour},
+		{PlugType: "Wireless", Sessions: 2, TotalDuration: 4 * time.Minute, LevelGained: 3, levelDuration: 4 * time.Minute},
+	}
+
+	var b bytes.Buffer
+	AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	sessions, errs := ChargeSessions(b.String())
+	if len(errs) > 0 {
+		t.Fatalf("ChargeSessions(%v) generated unexpected errors: %v", b.String(), errs)
+	}
+	if !reflect.DeepEqual(sessions, wantSessions) {
+		t.Errorf("ChargeSessions(%v) = %v, want %v", b.String(), sessions, wantSessions)
+	}
+	stats := ChargeStatsByPlugType(sessions)
+	if !reflect.DeepEqual(stats, wantStats) {
+		t.Errorf("ChargeStatsByPlugType(%v) = %v, want %v", sessions, stats, wantStats)
+	}
+	if got, want := stats[1].LevelPerHour(), 45.0; got != want {
+		t.Errorf("%v.LevelPerHour() = %v, want %v", stats[1], got, want)
+	}
+}
+
+// TestPlugTypeSummaryNames tests that the plug type summary is keyed by readable names.
+func TestPlugTypeSummaryNames(t *testing.T) {
+	input := strings.Join([]string{
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=50,Bs=d,Bp=n`,
+		`9,h,5000,Bl=49`,
+	}, "\n")
+	want := map[string]Dist{
+		"None": {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+	}
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	validateHistory(input, t, result, 0, 1)
+	if got := result.Summaries[0].PlugTypeSummary; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistory(%v,...).Summaries[0].PlugTypeSummary = %v, want %v", input, got, want)
+	}
+}
+
+// TestWriteChargingCurrent tests the generation of the charging current and phase rows.
+func TestWriteChargingCurrent(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []string
+	}{
+		{
+			desc: "Never plugged in",
+			input: []string{
+				csv.FileHeader,
+				`Coulomb charge,int,0,600000,1000,`,
+				`Coulomb charge,int,600000,1200000,990,`,
+			},
+		},
+		{
+			desc: "Fast, taper and trickle charging",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,3600000,a,`,
+				`Plug,string,3600000,4000000,n,`,
+				`Coulomb charge,int,0,600000,1000,`,
+				`Coulomb charge,int,600000,1200000,1300,`,
+				`Coulomb charge,int,1200000,1800000,1550,`,
+				`Coulomb charge,int,1800000,3000000,1650,`,
+				`Coulomb charge,int,3000000,3700000,1700,`,
+				// Discharging after unplugging isn't counted.
+				`Coulomb charge,int,3700000,4000000,1690,`,
+			},
+			want: []string{
+				`Charging current (mA),float,0,600000,1800.000,`,
+				`Charging current (mA),float,600000,1200000,1500.000,`,
+				`Charging current (mA),float,1200000,1800000,600.000,`,
+				`Charging current (mA),float,1800000,3000000,150.000,`,
+				`Charging phase,string,0,1200000,fast,`,
+				`Charging phase,string,1200000,1800000,taper,`,
+				`Charging phase,string,1800000,3000000,trickle,`,
+			},
+		},
+		{
+			desc: "Two sessions",
+			input: []string{
+				csv.FileHeader,
+				`Plug,string,0,1200000,a,`,
+				`Plug,string,1200000,2400000,n,`,
+				`Plug,string,2400000,3600000,u,`,
+				`Coulomb charge,int,0,600000,1000,`,
+				`Coulomb charge,int,600000,1200000,1200,`,
+				`Coulomb charge,int,1200000,1800000,1400,`,
+				`Coulomb charge,int,1800000,2400000,1390,`,
+				`Coulomb charge,int,2400000,3000000,1380,`,
+				`Coulomb charge,int,3000000,3600000,1680,`,
+				`Coulomb charge,int,3600000,4200000,1980,`,
+			},
+			want: []string{
+				`Charging current (mA),float,0,600000,1200.000,`,
+				`Charging current (mA),float,600000,1200000,1200.000,`,
+				`Charging current (mA),float,2400000,3000000,1800.000,`,
+				`Charging current (mA),float,3000000,3600000,1800.000,`,
+				`Charging phase,string,0,1200000,fast,`,
+				`Charging phase,string,2400000,3600000,fast,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		var b bytes.Buffer
+		if errs := WriteChargingCurrent(&b, input); len(errs) > 0 {
+			t.Errorf("%v: WriteChargingCurrent(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		got := strings.TrimSpace(b.String())
+		if want := strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%v: WriteChargingCurrent(%v)\n got: %v\n want: %v", test.desc, input, got, want)
+		}
+	}
+}
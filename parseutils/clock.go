@@ -0,0 +1,213 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// clock.go handles wall clock changes in the battery history, such as NITZ updates from the
+// carrier network, which would otherwise make events appear to move backwards in time.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+// clockChangeTolerance is the difference between the reported and expected time of a TIME
+// statement above which it is considered a wall clock change.
+const clockChangeTolerance = time.Second
+
+// minValidTimeMs is the time before which the times in TIME statements are assumed to be from a
+// clock that wasn't set yet, e.g. a real time clock starting at epoch after a reboot, rather than
+// real times. This is a random timestamp chosen to filter out timestamps that start at epoch.
+var minValidTimeMs = time.Date(2005, time.August, 17, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+// ClockChange is a change of the wall clock in the middle of a boot, e.g. a NITZ update.
+type ClockChange struct {
+	// ReportedMs is the time in the TIME statement logged after the change.
+	ReportedMs int64
+	// ExpectedMs is the time the statement would have had without the change, given the previous TIME statement.
+	ExpectedMs int64
+}
+
+// Shift returns how much the clock was moved. It is negative if the clock was moved backwards.
+func (c ClockChange) Shift() time.Duration {
+	return time.Duration(c.ReportedMs-c.ExpectedMs) * time.Millisecond
+}
+
+// timeStatement returns the time and time delta of a TIME or RESET:TIME history line.
+func timeStatement(line string) (ts, delta int64, ok bool, err error) {
+	m, result := historianutils.SubexpNames(TimeRE, line)
+	if !m {
+		if m, result = historianutils.SubexpNames(ResetRE, line); !m {
+			return 0, 0, false, nil
+		}
+	}
+	if ts, err = strconv.ParseInt(result["timeStamp"], 10, 64); err != nil {
+		return 0, 0, false, err
+	}
+	if delta, err = strconv.ParseInt(result["timeDelta"], 10, 64); err != nil {
+		return 0, 0, false, err
+	}
+	return ts, delta, true, nil
+}
+
+// validTimeBoots returns, for each line of the history, whether its boot has a TIME statement with a
+// time after minValidTimeMs. Boots are separated by START and SHUTDOWN statements, as in fixTimeline.
+func validTimeBoots(h []string) ([]bool, error) {
+	valid := make([]bool, len(h))
+	first := 0
+	found := false
+	for i := 0; i <= len(h); i++ {
+		if i == len(h) || StartRE.MatchString(h[i]) || ShutdownRE.MatchString(h[i]) {
+			for j := first; j < i; j++ {
+				valid[j] = found
+			}
+			first, found = i+1, false
+			continue
+		}
+		ts, _, ok, err := timeStatement(h[i])
+		if err != nil {
+			return nil, err
+		}
+		found = found || (ok && ts >= minValidTimeMs)
+	}
+	return valid, nil
+}
+
+// lineDelta returns the time delta of a history line, or 0 for lines that don't advance the time.
+func lineDelta(line string) (int64, error) {
+	if StartRE.MatchString(line) {
+		// The time is set by the TIME statement following START.
+		return 0, nil
+	}
+	m, result := historianutils.SubexpNames(GenericHistoryLineRE, line)
+	if !m {
+		return 0, nil
+	}
+	return strconv.ParseInt(result["timeDelta"], 10, 64)
+}
+
+// detectClockChanges returns the wall clock changes in the history, found from TIME statements
+// that don't match the time given by the previous TIME statement and the time deltas since.
+// The first TIME statement after a START isn't compared, as the time spent rebooting is unknown, and
+// neither is the first valid TIME statement after ones from a clock that wasn't set yet.
+func detectClockChanges(h []string) ([]ClockChange, error) {
+	var changes []ClockChange
+	var cur int64
+	known := false
+	tolMs := int64(clockChangeTolerance / time.Millisecond)
+	for _, line := range h {
+		line = strings.TrimSpace(line)
+		if StartRE.MatchString(line) {
+			known = false
+			continue
+		}
+		ts, d, ok, err := timeStatement(line)
+		if err != nil {
+			return changes, err
+		}
+		if ok {
+			if want := cur + d; known && !ResetRE.MatchString(line) && (ts-want > tolMs || want-ts > tolMs) {
+				changes = append(changes, ClockChange{ReportedMs: ts, ExpectedMs: want})
+			}
+			cur = ts
+			known = ts >= minValidTimeMs
+			continue
+		}
+		d, err = lineDelta(line)
+		if err != nil {
+			return changes, err
+		}
+		cur += d
+	}
+	return changes, nil
+}
+
+// rebaseBoots shifts the times of the boots in a history processed by fixTimeline back, so that no
+// boot starts before the previous one ended, and returns whether any times were changed. fixTimeline
+// only makes times consistent within a boot, so if the clock was moved backwards across a reboot,
+// e.g. by a NITZ update correcting a clock that was ahead, the events would otherwise overlap.
+// As in fixTimeline, the times of the last boot are assumed to be the most accurate. Boots whose
+// clock was never set, so that their times are before minValidTimeMs, can't be placed relative to
+// the other boots, so they aren't shifted and don't shift the boots before them.
+func rebaseBoots(h []string) (bool, error) {
+	type boot struct {
+		// timeIdx are the indexes of the TIME statements in the boot.
+		timeIdx    []int
+		start, end int64
+	}
+	var boots []*boot
+	var b *boot
+	var cur int64
+	for i, line := range h {
+		if StartRE.MatchString(line) {
+			b = nil
+			continue
+		}
+		ts, d, ok, err := timeStatement(line)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			if b == nil {
+				b = &boot{start: ts - d}
+				boots = append(boots, b)
+			}
+			b.timeIdx = append(b.timeIdx, i)
+			cur = ts
+			b.end = cur
+			continue
+		}
+		if d, err = lineDelta(line); err != nil {
+			return false, err
+		}
+		cur += d
+		if b != nil {
+			b.end = cur
+		}
+	}
+
+	changed := false
+	// next is the closest following boot with valid times.
+	var next *boot
+	for i := len(boots) - 1; i >= 0; i-- {
+		if boots[i].start < minValidTimeMs {
+			continue
+		}
+		if next == nil {
+			next = boots[i]
+			continue
+		}
+		overlap := boots[i].end - next.start
+		next = boots[i]
+		if overlap <= 0 {
+			continue
+		}
+		for _, idx := range boots[i].timeIdx {
+			sep := strings.Split(h[idx], ":TIME:")
+			ts, err := strconv.ParseInt(sep[1], 10, 64)
+			if err != nil {
+				return changed, err
+			}
+			h[idx] = fmt.Sprintf("%s:TIME:%d", sep[0], ts-overlap)
+		}
+		boots[i].start -= overlap
+		boots[i].end -= overlap
+		changed = true
+	}
+	return changed, nil
+}
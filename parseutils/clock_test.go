@@ -0,0 +1,212 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// nitzHistory is a history where the clock was ahead before a reboot, and then moved forward by a
+// NITZ update after the reboot, so that fixTimeline alone would make the second boot start before
+// the first ended.
+var nitzHistory = []string{
+	"9,0,i,vers,12,116,LVX72L,LVY29G",
+	"9,h,0:RESET:TIME:1422620000000",
+	"9,h,0,Bl=46,Bs=d,Bh=g,Bp=n,Bt=326,Bv=3814,+r",
+	"9,h,60000,+s",
+	"9,h,60000,Bl=45,-s",
+	"9,h,100:SHUTDOWN",
+	"9,h,38:START",
+	"9,h,0:TIME:1422616400000",
+	"9,h,1000,Bl=44,Bs=d,Bh=g,Bp=n,Bt=285,Bv=3703,+r",
+	"9,h,60000,+s",
+	"9,h,30000,Bl=43",
+	"9,h,1000:TIME:1422617092000",
+	"9,h,60000,Bl=42,-s",
+}
+
+// TestDetectClockChanges tests the detection of wall clock changes within a boot.
+func TestDetectClockChanges(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []ClockChange
+	}{
+		{
+			desc:  "NITZ update after reboot",
+			input: nitzHistory,
+			want:  []ClockChange{{ReportedMs: 1422617092000, ExpectedMs: 1422616492000}},
+		},
+		{
+			desc: "TIME statements consistent with deltas",
+			input: []string{
+				"9,h,0:RESET:TIME:1422620000000",
+				"9,h,60000,+s",
+				"9,h,1000:TIME:1422620061000",
+				"9,h,500:TIME:1422620061900",
+			},
+		},
+		{
+			desc: "clock moved backwards",
+			input: []string{
+				"9,h,0:RESET:TIME:1422620000000",
+				"9,h,60000,+s",
+				"9,h,1000:TIME:1422616461000",
+			},
+			want: []ClockChange{{ReportedMs: 1422616461000, ExpectedMs: 1422620061000}},
+		},
+	}
+	for _, test := range tests {
+		got, err := detectClockChanges(test.input)
+		if err != nil {
+			t.Errorf("%v: detectClockChanges(%v) returned unexpected error: %v", test.desc, test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: detectClockChanges(%v)\n got: %v\n want: %v", test.desc, test.input, got, test.want)
+		}
+	}
+	if got, want := (ClockChange{ReportedMs: 1422617092000, ExpectedMs: 1422616492000}).Shift(), 10*time.Minute; got != want {
+		t.Errorf("Shift() = %v, want: %v", got, want)
+	}
+}
+
+// TestFixTimelineRebase tests that fixTimeline shifts back boots that would overlap later boots.
+func TestFixTimelineRebase(t *testing.T) {
+	want := []string{
+		"9,0,i,vers,12,116,LVX72L,LVY29G",
+		"9,h,0:RESET:TIME:1422616879900",
+		"9,h,0,Bl=46,Bs=d,Bh=g,Bp=n,Bt=326,Bv=3814,+r",
+		"9,h,60000,+s",
+		"9,h,60000,Bl=45,-s",
+		"9,h,100:SHUTDOWN",
+		"9,h,38:START",
+		"9,h,0:TIME:1422617000000",
+		"9,h,1000,Bl=44,Bs=d,Bh=g,Bp=n,Bt=285,Bv=3703,+r",
+		"9,h,60000,+s",
+		"9,h,30000,Bl=43",
+		"9,h,1000:TIME:1422617092000",
+		"9,h,60000,Bl=42,-s",
+	}
+	output, c, err := fixTimeline(strings.Join(nitzHistory, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c {
+		t.Error("Timestamps weren't changed.")
+	}
+	if !reflect.DeepEqual(want, output) {
+		t.Errorf("fixTimeline(%v)\n got: %v\n want: %v", nitzHistory, output, want)
+	}
+}
+
+// epochHistory is a history where the clock wasn't set for the whole second boot, and only set in
+// the middle of the third boot.
+var epochHistory = []string{
+	"9,0,i,vers,12,116,LVX72L,LVY29G",
+	"9,h,0:RESET:TIME:1422620000000",
+	"9,h,0,Bl=46,Bs=d,Bh=g,Bp=n,Bt=326,Bv=3814,+r",
+	"9,h,60000,Bl=45",
+	"9,h,100:SHUTDOWN",
+	"9,h,38:START",
+	"9,h,0:TIME:5000",
+	"9,h,1000,Bl=44,Bs=d,Bh=g,Bp=n,Bt=285,Bv=3703,+r",
+	"9,h,60000,Bl=43",
+	"9,h,100:SHUTDOWN",
+	"9,h,38:START",
+	"9,h,0:TIME:1000",
+	"9,h,1000,Bl=42,Bs=d,Bh=g,Bp=n,Bt=285,Bv=3703,+r",
+	"9,h,30000,Bl=41",
+	"9,h,1000:TIME:1422630000000",
+	"9,h,60000,Bl=40",
+}
+
+// TestFixTimelineEpoch tests that times from a clock that wasn't set are backfilled from the next
+// valid TIME statement of the boot, and that untimed boots don't shift the boots before them.
+func TestFixTimelineEpoch(t *testing.T) {
+	want := append([]string{}, epochHistory...)
+	want[11] = "9,h,0:TIME:1422629968000"
+	output, c, err := fixTimeline(strings.Join(epochHistory, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c {
+		t.Error("Timestamps weren't changed.")
+	}
+	if !reflect.DeepEqual(want, output) {
+		t.Errorf("fixTimeline(%v)\n got: %v\n want: %v", epochHistory, output, want)
+	}
+
+	rep := AnalyzeHistory(&bytes.Buffer{}, strings.Join(epochHistory, "\n"), FormatBatteryLevel, PackageUIDMapping{}, true)
+	if len(rep.Errs) > 0 {
+		t.Errorf("AnalyzeHistory returned unexpected errors: %v", rep.Errs)
+	}
+	if len(rep.ClockChanges) > 0 {
+		t.Errorf("AnalyzeHistory found clock changes %v, want none", rep.ClockChanges)
+	}
+	var untimed []int
+	for _, s := range rep.Summaries {
+		if s.Untimed {
+			untimed = append(untimed, s.InitialBatteryLevel)
+		}
+	}
+	if want := []int{44, 43}; !reflect.DeepEqual(untimed, want) {
+		t.Errorf("AnalyzeHistory returned untimed summaries starting at levels %v, want %v", untimed, want)
+	}
+	steps := BatteryLevelSummariesToSteps(rep.Summaries)
+	if _, ok := steps[LevelStepKey(44, 43)]; ok {
+		t.Errorf("BatteryLevelSummariesToSteps(%v) included the untimed level drop 44->43", rep.Summaries)
+	}
+}
+
+// TestAnalyzeHistoryClockChange tests that a NITZ update doesn't result in negative durations.
+func TestAnalyzeHistoryClockChange(t *testing.T) {
+	h := strings.Join(nitzHistory, "\n")
+	for _, format := range []string{FormatTotalTime, FormatBatteryLevel} {
+		var b bytes.Buffer
+		rep := AnalyzeHistory(&b, h, format, PackageUIDMapping{}, true)
+		if len(rep.Errs) > 0 {
+			t.Errorf("%v: AnalyzeHistory returned unexpected errors: %v", format, rep.Errs)
+		}
+		if len(rep.ClockChanges) != 1 {
+			t.Errorf("%v: AnalyzeHistory found %d clock changes, want 1", format, len(rep.ClockChanges))
+		}
+		for _, s := range rep.Summaries {
+			if s.EndTimeMs < s.StartTimeMs {
+				t.Errorf("%v: summary has negative duration: start %d, end %d", format, s.StartTimeMs, s.EndTimeMs)
+			}
+		}
+		if format != FormatTotalTime {
+			continue
+		}
+		es, errs := csv.ExtractEvents(b.String(), nil)
+		if len(errs) > 0 {
+			t.Errorf("ExtractEvents returned unexpected errors: %v", errs)
+		}
+		for m, events := range es {
+			for _, e := range events {
+				if e.End < e.Start {
+					t.Errorf("%v event has negative duration: %v", m, e)
+				}
+			}
+		}
+	}
+}
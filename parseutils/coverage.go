@@ -0,0 +1,130 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// coverage.go computes how much of the unplugged time of the history each metric has data for,
+// so that summaries of partially logged metrics, e.g. the wakeup reasons, aren't over-trusted.
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// WakeupReasonCoverage is the MetricCoverage name of the wakeup reasons.
+const WakeupReasonCoverage = "Wakeup reason"
+
+// coverageMetrics are the battery history CSV metrics that always have a value while logged, so any
+// unplugged time without an event of the metric is unknown. Metrics logged only while on, such as the
+// screen, have no coverage as the time without events is off rather than unknown.
+var coverageMetrics = []string{
+	BatteryLevel,
+	"Brightness",
+	"Charging status",
+	"Coulomb charge",
+	"Doze",
+	"Health",
+	"Mobile network type",
+	"Mobile signal strength",
+	"Phone state",
+	"Plug",
+	"Temperature",
+	"Voltage",
+	"Wifi signal strength",
+	"Wifi supplicant",
+}
+
+// MetricCoverage is how much of the time a metric is expected to have data for it actually has data.
+type MetricCoverage struct {
+	Metric string
+	// Expected is the unplugged time of the history, or for the wakeup reasons, the unplugged CPU running time.
+	Expected time.Duration
+	Covered  time.Duration
+}
+
+// Percent returns the percentage of the expected time that has data, or 0 if no time is expected.
+func (c MetricCoverage) Percent() float64 {
+	if c.Expected <= 0 {
+		return 0
+	}
+	return 100 * float64(c.Covered) / float64(c.Expected)
+}
+
+// coveredMs returns the time the events cover within the intervals, which must be sorted and non overlapping.
+func coveredMs(events, intervals []csv.Event) int64 {
+	events = csv.MergeEvents(append([]csv.Event(nil), events...))
+	var ms int64
+	for _, i := range intervals {
+		ms += csv.Overlap(events, i.Start, i.End)
+	}
+	return ms
+}
+
+// MetricCoverages computes the coverage of the wakeup reasons, then of the metrics that always have
+// a value, over the unplugged time of the battery history CSV generated by AnalyzeHistory. The history
+// range is taken from the battery level events. It returns nil if the history has no unplugged time.
+func MetricCoverages(csvInput string) ([]MetricCoverage, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{cpuRunning, Plugged}, coverageMetrics...))
+	levels := es[BatteryLevel]
+	if len(levels) == 0 {
+		return nil, errs
+	}
+	startMs := int64(math.MaxInt64)
+	for _, e := range levels {
+		if e.Start < startMs {
+			startMs = e.Start
+		}
+	}
+	var plugged []csv.Event
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	sort.Sort(sortByStart(plugged))
+	unpluggedIntervals := unplugged(plugged, startMs, historyEnd(levels))
+	var unpluggedMs int64
+	for _, u := range unpluggedIntervals {
+		unpluggedMs += u.End - u.Start
+	}
+	if unpluggedMs == 0 {
+		return nil, errs
+	}
+
+	// Running events without any wakeup reason are logged with csv.UnknownWakeup.
+	var known []csv.Event
+	for _, e := range es[cpuRunning] {
+		if !strings.Contains(e.Value, csv.UnknownWakeup) {
+			known = append(known, e)
+		}
+	}
+	res := []MetricCoverage{{
+		Metric:   WakeupReasonCoverage,
+		Expected: time.Duration(coveredMs(es[cpuRunning], unpluggedIntervals)) * time.Millisecond,
+		Covered:  time.Duration(coveredMs(known, unpluggedIntervals)) * time.Millisecond,
+	}}
+	for _, m := range coverageMetrics {
+		res = append(res, MetricCoverage{
+			Metric:   m,
+			Expected: time.Duration(unpluggedMs) * time.Millisecond,
+			Covered:  time.Duration(coveredMs(es[m], unpluggedIntervals)) * time.Millisecond,
+		})
+	}
+	return res, errs
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestMetricCoverages tests the coverage of the metrics over the unplugged time.
+func TestMetricCoverages(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		// want maps the metrics to check to their expected and covered time.
+		want map[string][2]time.Duration
+	}{
+		{
+			desc: "partial coverage",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,60000,90,`,
+				`Battery Level,int,60000,120000,89,`,
+				`Plugged,bool,90000,120000,true,`,
+				`CPU running,string,10000,20000,10000~20000~200:qcom-rpm,`,
+				`CPU running,string,30000,50000,` + csv.UnknownWakeup + `,`,
+				// The part while plugged in isn't counted.
+				`CPU running,string,80000,100000,80000~100000~57:qcom-modem,`,
+				`Temperature,int,30000,60000,300,`,
+				`Temperature,int,60000,120000,310,`,
+			},
+			want: map[string][2]time.Duration{
+				WakeupReasonCoverage: {40 * time.Second, 20 * time.Second},
+				BatteryLevel:         {90 * time.Second, 90 * time.Second},
+				"Temperature":        {90 * time.Second, 60 * time.Second},
+				"Coulomb charge":     {90 * time.Second, 0},
+			},
+		},
+		{
+			desc: "always plugged in",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,60000,90,`,
+				`Plugged,bool,0,60000,true,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		got, errs := MetricCoverages(strings.Join(test.input, "\n"))
+		if len(errs) > 0 {
+			t.Errorf("%v: MetricCoverages generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if test.want == nil {
+			if got != nil {
+				t.Errorf("%v: MetricCoverages() = %v, want nil", test.desc, got)
+			}
+			continue
+		}
+		if len(got) != len(coverageMetrics)+1 || got[0].Metric != WakeupReasonCoverage {
+			t.Errorf("%v: MetricCoverages() = %v, want the wakeup reasons then %d metrics", test.desc, got, len(coverageMetrics))
+			continue
+		}
+		for _, c := range got {
+			w, ok := test.want[c.Metric]
+			if ok && (c.Expected != w[0] || c.Covered != w[1]) {
+				t.Errorf("%v: %s coverage got %v of %v, want %v of %v", test.desc, c.Metric, c.Covered, c.Expected, w[1], w[0])
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// cycles.go finds the charge cycles in the history, each a discharge followed by a charging session,
+// and estimates how much they aged the battery. Deep discharges age a Li-ion battery more per percent
+// discharged than shallow ones, so the aging is weighted by the depth of discharge of each cycle.
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// depthBucketSize is the size, in battery level percent, of the depth of discharge buckets.
+	depthBucketSize = 20
+
+	// cycleAgingExponent is the exponent of the depth of discharge in the aging of a cycle, relative to a
+	// full cycle. Cycle life of Li-ion cells goes roughly as DoD^-1.5, e.g. a 50% cycle ages the battery
+	// about a third as much as a full one.
+	cycleAgingExponent = 1.5
+	// capacityLossPerFullCycle is the estimated capacity loss, in percent, of a full cycle. Batteries
+	// are typically rated to keep 80% of their capacity after 500 full cycles.
+	capacityLossPerFullCycle = 20.0 / 500
+)
+
+// ChargeCycle is a discharge followed by a charging session.
+type ChargeCycle struct {
+	// DischargeStartMs is the end of the previous charging session, or the start of the history.
+	DischargeStartMs int64
+	Charge           ChargeSession
+	// PeakLevel and TroughLevel are the highest and lowest battery levels during the discharge.
+	PeakLevel, TroughLevel int
+}
+
+// Depth returns the depth of discharge of the cycle, in battery level percent.
+func (c ChargeCycle) Depth() int {
+	return c.PeakLevel - c.TroughLevel
+}
+
+// DischargeDuration returns the length of the discharge of the cycle.
+func (c ChargeCycle) DischargeDuration() time.Duration {
+	return time.Duration(c.Charge.StartMs-c.DischargeStartMs) * time.Millisecond
+}
+
+// aging returns the aging of the cycle in equivalent full cycles, weighted by its depth of discharge.
+func (c ChargeCycle) aging() float64 {
+	return math.Pow(float64(c.Depth())/100, cycleAgingExponent)
+}
+
+// DepthBucket is the number of charge cycles with a depth of discharge in (Min, Max].
+type DepthBucket struct {
+	Min, Max int
+	Cycles   int
+}
+
+// CycleSummary summarizes the charge cycles of a history.
+type CycleSummary struct {
+	Cycles []ChargeCycle
+	// EquivalentFullCycles is the total depth of discharge of the cycles, in full (100%) cycles.
+	EquivalentFullCycles float64
+	// DepthDistribution is the number of cycles per depth of discharge bucket, from shallowest to deepest.
+	DepthDistribution []DepthBucket
+	// AgingFullCycles is the aging of the cycles in full cycles, weighting deep discharges more.
+	AgingFullCycles float64
+	// EstimatedCapacityLoss is the estimated battery capacity loss from the cycles, in percent.
+	EstimatedCapacityLoss float64
+}
+
+// MeanDepth returns the mean depth of discharge of the cycles, in battery level percent.
+func (s *CycleSummary) MeanDepth() float64 {
+	if len(s.Cycles) == 0 {
+		return 0
+	}
+	return s.EquivalentFullCycles * 100 / float64(len(s.Cycles))
+}
+
+// dischargeRange returns the highest and lowest battery levels from startMs to endMs. The levels
+// must be sorted by start time.
+func dischargeRange(levels []csv.Event, startMs, endMs int64) (int, int, bool) {
+	peak, ok := levelAt(levels, startMs)
+	if !ok {
+		if len(levels) == 0 || levels[0].Start > endMs {
+			return 0, 0, false
+		}
+		peak, _ = strconv.Atoi(levels[0].Value)
+	}
+	trough := peak
+	for _, e := range levels {
+		if e.Start <= startMs {
+			continue
+		}
+		if e.Start > endMs {
+			break
+		}
+		l, _ := strconv.Atoi(e.Value)
+		if l > peak {
+			peak = l
+		}
+		if l < trough {
+			trough = l
+		}
+	}
+	return peak, trough, true
+}
+
+// ChargeCycles returns the charge cycles found in the battery history CSV generated by AnalyzeHistory.
+// Each charging session ends a cycle, whose discharge starts at the end of the previous session. The
+// first discharge starts at the first battery level of the history, so its depth is a lower bound.
+// Discharges that aren't followed by a charging session, and plug type changes without any discharge,
+// aren't cycles.
+func ChargeCycles(csvInput string) (*CycleSummary, []error) {
+	sessions, errs := ChargeSessions(csvInput)
+	if len(sessions) == 0 {
+		return nil, errs
+	}
+	es, lErrs := csv.ExtractEvents(csvInput, []string{BatteryLevel})
+	errs = append(errs, lErrs...)
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		// Invalid levels are already reported by ChargeSessions.
+		if _, err := strconv.Atoi(e.Value); err == nil {
+			levels = append(levels, e)
+		}
+	}
+	sort.Sort(sortByStart(levels))
+	if len(levels) == 0 {
+		return nil, errs
+	}
+
+	s := &CycleSummary{}
+	for i := 0; i < 100; i += depthBucketSize {
+		s.DepthDistribution = append(s.DepthDistribution, DepthBucket{Min: i, Max: i + depthBucketSize})
+	}
+	start := levels[0].Start
+	for _, cs := range sessions {
+		peak, trough, ok := dischargeRange(levels, start, cs.StartMs)
+		dischargeStart := start
+		start = cs.EndMs
+		if !ok || peak <= trough {
+			continue
+		}
+		c := ChargeCycle{DischargeStartMs: dischargeStart, Charge: cs, PeakLevel: peak, TroughLevel: trough}
+		s.Cycles = append(s.Cycles, c)
+		s.EquivalentFullCycles += float64(c.Depth()) / 100
+		s.AgingFullCycles += c.aging()
+		b := (c.Depth() - 1) / depthBucketSize
+		if b >= len(s.DepthDistribution) {
+			b = len(s.DepthDistribution) - 1
+		}
+		s.DepthDistribution[b].Cycles++
+	}
+	if len(s.Cycles) == 0 {
+		return nil, errs
+	}
+	s.EstimatedCapacityLoss = s.AgingFullCycles * capacityLossPerFullCycle
+	return s, errs
+}
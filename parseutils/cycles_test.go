@@ -0,0 +1,92 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestChargeCycles tests the charge cycles found in the history and their aging.
+func TestChargeCycles(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		// Discharged from 100 to 40, then charged to 90.
+		`Battery Level,int,0,2000,100,`,
+		`Battery Level,int,2000,4000,70,`,
+		`Battery Level,int,4000,6000,40,`,
+		`Plug,string,5000,8000,a,`,
+		`Battery Level,int,6000,8000,90,`,
+		// Discharged from 90 to 80, then charged to 85.
+		`Battery Level,int,8000,10000,90,`,
+		`Battery Level,int,10000,13000,80,`,
+		`Plug,string,12000,14000,u,`,
+		`Battery Level,int,13000,15000,85,`,
+		// The plug type changed without any discharge.
+		`Plug,string,14000,15000,w,`,
+		// Discharged without charging again.
+		`Battery Level,int,15000,20000,70,`,
+	}, "\n")
+	got, errs := ChargeCycles(input)
+	if len(errs) > 0 {
+		t.Fatalf("ChargeCycles generated unexpected errors: %v", errs)
+	}
+	if got == nil {
+		t.Fatalf("ChargeCycles() = nil, want 2 cycles")
+	}
+	var depths []int
+	for _, c := range got.Cycles {
+		depths = append(depths, c.Depth())
+	}
+	if want := []int{60, 10}; !reflect.DeepEqual(depths, want) {
+		t.Errorf("ChargeCycles() generated cycles of depths %v, want %v", depths, want)
+	}
+	if got.Cycles[1].DischargeStartMs != 8000 {
+		t.Errorf("ChargeCycles() generated second cycle discharging from %d, want 8000", got.Cycles[1].DischargeStartMs)
+	}
+	wantDist := []DepthBucket{
+		{Min: 0, Max: 20, Cycles: 1},
+		{Min: 20, Max: 40},
+		{Min: 40, Max: 60, Cycles: 1},
+		{Min: 60, Max: 80},
+		{Min: 80, Max: 100},
+	}
+	if !reflect.DeepEqual(got.DepthDistribution, wantDist) {
+		t.Errorf("ChargeCycles() generated depth distribution %v, want %v", got.DepthDistribution, wantDist)
+	}
+	for _, f := range []struct {
+		desc      string
+		got, want float64
+	}{
+		{"equivalent full cycles", got.EquivalentFullCycles, 0.7},
+		{"mean depth", got.MeanDepth(), 35},
+		// 0.6^1.5 + 0.1^1.5
+		{"aging", got.AgingFullCycles, 0.4964},
+		{"capacity loss", got.EstimatedCapacityLoss, 0.0199},
+	} {
+		if math.Abs(f.got-f.want) > 0.0001 {
+			t.Errorf("ChargeCycles() generated %s %v, want %v", f.desc, f.got, f.want)
+		}
+	}
+
+	// A history without any charging has no cycles.
+	if got, _ := ChargeCycles(strings.Join([]string{csv.FileHeader, `Battery Level,int,0,2000,100,`, `Battery Level,int,2000,4000,70,`}, "\n")); got != nil {
+		t.Errorf("ChargeCycles() = %v for a history without charging, want nil", got)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// derived.go adds the metrics derived from the battery history CSV, so that the analyzer, the
+// command line tools and the golden tests all output the same CSV.
+
+import (
+	"bytes"
+	"io"
+)
+
+// WriteDerivedMetrics appends the metrics derived from the battery history CSV generated by
+// AnalyzeHistory to the buffer holding it: the step fingerprints, suspend efficiency, USB states,
+// charging current, maintenance windows, suspend aborts, default network and app inactive events.
+// The CPU running events are then annotated with their causes, and the kernel panic of the bug
+// report, if any.
+func WriteDerivedMetrics(b *bytes.Buffer, bugReport string) []error {
+	var errs []error
+	for _, write := range []func(io.Writer, string) []error{
+		WriteStepFingerprints,
+		WriteSuspendEfficiency,
+		WriteUSBStates,
+		WriteChargingCurrent,
+		WriteMaintenanceWindows,
+		WriteSuspendAborts,
+		WriteDefaultNetwork,
+		WriteAppInactive,
+	} {
+		// Each metric is derived from the CSV including the previously derived ones.
+		errs = append(errs, write(b, b.String())...)
+	}
+	csvOut, rErrs := AddCPURunningCauses(b.String())
+	errs = append(errs, rErrs...)
+	csvOut, rErrs = AnnotateKernelPanic(csvOut, bugReport)
+	errs = append(errs, rErrs...)
+	b.Reset()
+	b.WriteString(csvOut)
+	return errs
+}
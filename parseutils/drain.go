@@ -0,0 +1,150 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// drain.go estimates the battery drain of each summary that isn't explained by any of the activity
+// tracked in the battery history, such as the display, the radios or apps keeping the CPU running.
+// A high residual drain rate points to issues the history can't show, e.g. in the kernel or firmware.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// trackedActivityMetrics are the battery history CSV metrics of the activity the drain can be attributed to.
+var trackedActivityMetrics = []string{
+	"Screen",
+	"CPU running",
+	"Mobile radio active",
+	"Wifi radio",
+	"Wifi scan",
+	"GPS",
+	"Phone call",
+	"VoIP call",
+	"Audio",
+	"Video",
+	"Camera",
+	"Flashlight on",
+}
+
+// AddUnattributedDrain populates the UnattributedLevelDrop and UnattributedDuration of each summary
+// from the battery history CSV generated by AnalyzeHistory. The level drop of each battery level step
+// is split between the summaries the step overlaps, and the part of it proportional to the time no
+// tracked activity was on during the step is unattributed. As idle power is lower than active power,
+// this overestimates the unattributed drop, but the drain rate while idle is what matters.
+func AddUnattributedDrain(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, append(trackedActivityMetrics, BatteryLevel))
+	var tracked []csv.Event
+	for _, m := range trackedActivityMetrics {
+		for _, e := range es[m] {
+			if e.Value != "false" {
+				tracked = append(tracked, e)
+			}
+		}
+	}
+	tracked = csv.MergeEvents(tracked)
+
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	sort.Sort(sortByStart(levels))
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.UnattributedLevelDrop, s.UnattributedDuration = 0, 0
+		for j := 0; j+1 < len(levels); j++ {
+			cur := levels[j]
+			from, _ := strconv.Atoi(cur.Value)
+			to, _ := strconv.Atoi(levels[j+1].Value)
+			// Steps while charging don't drain the battery.
+			if to > from || cur.End <= cur.Start {
+				continue
+			}
+			start, end := cur.Start, cur.End
+			if start < s.StartTimeMs {
+				start = s.StartTimeMs
+			}
+			if end > s.EndTimeMs {
+				end = s.EndTimeMs
+			}
+			if end <= start {
+				continue
+			}
+			idle := end - start - csv.Overlap(tracked, start, end)
+			s.UnattributedDuration += time.Duration(idle) * time.Millisecond
+			s.UnattributedLevelDrop += float64(from-to) * float64(idle) / float64(cur.End-cur.Start)
+		}
+	}
+	return errs
+}
+
+// UnattributedLevelDropPerHour returns the unattributed battery level drop per hour of the time no
+// tracked activity was on, or 0 if there was no such time.
+func (s ActivitySummary) UnattributedLevelDropPerHour() float64 {
+	if s.UnattributedDuration <= 0 {
+		return 0
+	}
+	return s.UnattributedLevelDrop / s.UnattributedDuration.Hours()
+}
+
+// Drain is the battery drain while unplugged over part of the history.
+type Drain struct {
+	// Duration is the time the device was unplugged.
+	Duration time.Duration
+	// LevelDrop is the total battery level drop while unplugged, in percent.
+	LevelDrop int
+}
+
+// PercentPerHour returns the battery level drop per hour unplugged.
+func (d Drain) PercentPerHour() float64 {
+	if d.Duration <= 0 {
+		return 0
+	}
+	return float64(d.LevelDrop) / d.Duration.Hours()
+}
+
+// UnpluggedDrain returns the battery drain while unplugged within the windows, which must not overlap
+// each other, from the battery level and plugged in events of the battery history CSV generated by
+// AnalyzeHistory. The level drops happened by the time of the level change, so are counted in the
+// window and plugged in state before it.
+func UnpluggedDrain(levels, pluggedIn, windows []csv.Event) Drain {
+	pluggedIn = csv.MergeEvents(append([]csv.Event(nil), pluggedIn...))
+	var d Drain
+	var unpluggedMs int64
+	for _, e := range levels {
+		for _, w := range windows {
+			in := e.Interval().Intersect(w.Interval())
+			if !in.Empty() {
+				unpluggedMs += in.Duration() - csv.Overlap(pluggedIn, in.Start, in.End)
+			}
+		}
+	}
+	for _, e := range levelDrops(levels) {
+		if inWindow(windows, e.Start-1) && !inWindow(pluggedIn, e.Start-1) {
+			n, _ := strconv.Atoi(e.Value)
+			d.LevelDrop += n
+		}
+	}
+	d.Duration = time.Duration(unpluggedMs) * time.Millisecond
+	return d
+}
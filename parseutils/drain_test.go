@@ -0,0 +1,119 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddUnattributedDrain tests the estimate of the drain while no tracked activity was on.
+func TestAddUnattributedDrain(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,60000,90,`,
+		`Battery Level,int,60000,120000,88,`,
+		`Battery Level,int,120000,180000,87,`,
+		`Battery Level,int,180000,240000,90,`,
+		`Battery Level,int,240000,300000,89,`,
+		`Battery Level,int,300000,360000,88,`,
+		// Overlapping activity is only counted once.
+		`Screen,bool,0,30000,true,`,
+		`CPU running,string,15000,45000,,`,
+		`Mobile radio active,bool,150000,180000,true,`,
+		`Phone call,bool,240000,270000,false,`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 150000},
+		{StartTimeMs: 150000, EndTimeMs: 360000},
+	}
+	if errs := AddUnattributedDrain(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddUnattributedDrain generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		drop     float64
+		duration time.Duration
+	}{
+		{
+			// 15s of the first step, with a drop of 2, and all of the second step, with a drop of 1.
+			// The third step is charging.
+			drop:     2*15.0/60 + 1,
+			duration: 75 * time.Second,
+		},
+		{
+			// The phone call isn't on, and the last level has no step.
+			drop:     2,
+			duration: 120 * time.Second,
+		},
+	}
+	for i, w := range want {
+		s := summaries[i]
+		if math.Abs(s.UnattributedLevelDrop-w.drop) > 1e-9 || s.UnattributedDuration != w.duration {
+			t.Errorf("Summary %d unattributed drain got %.3f over %v, want %.3f over %v", i, s.UnattributedLevelDrop, s.UnattributedDuration, w.drop, w.duration)
+		}
+	}
+	if got, want := summaries[1].UnattributedLevelDropPerHour(), 60.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("Summary 1 UnattributedLevelDropPerHour() = %v, want %v", got, want)
+	}
+}
+
+// TestUnpluggedDrain tests that the drain is only counted while unplugged within the windows, and each
+// level drop in the window and plugged in state before the level change.
+func TestUnpluggedDrain(t *testing.T) {
+	levels := []csv.Event{
+		{Start: 0, End: 3600000, Value: "90"},
+		{Start: 3600000, End: 7200000, Value: "88"},
+		{Start: 7200000, End: 10800000, Value: "87"},
+		{Start: 10800000, End: 14400000, Value: "90"},
+	}
+	// Plugged in for the last hour, and charging.
+	pluggedIn := []csv.Event{{Start: 10800000, End: 14400000, Value: "true"}}
+	tests := []struct {
+		desc    string
+		windows []csv.Event
+		want    Drain
+	}{
+		{
+			desc:    "Whole history",
+			windows: []csv.Event{{Start: 0, End: 14400000}},
+			want:    Drain{Duration: 3 * time.Hour, LevelDrop: 3},
+		},
+		{
+			desc:    "Window ending at a level change",
+			windows: []csv.Event{{Start: 0, End: 3600000}},
+			want:    Drain{Duration: time.Hour, LevelDrop: 2},
+		},
+		{
+			desc:    "Window starting at a level change",
+			windows: []csv.Event{{Start: 3600000, End: 5400000}},
+			want:    Drain{Duration: 30 * time.Minute},
+		},
+		{
+			desc:    "No windows",
+			windows: nil,
+			want:    Drain{},
+		},
+	}
+	for _, test := range tests {
+		if got := UnpluggedDrain(levels, pluggedIn, test.windows); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: UnpluggedDrain(%v, %v, %v) = %+v, want %+v", test.desc, levels, pluggedIn, test.windows, got, test.want)
+		}
+	}
+}
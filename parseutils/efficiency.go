@@ -0,0 +1,159 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// efficiency.go computes the suspend efficiency, the percentage of unplugged time the CPU was asleep.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// SuspendEfficiencyMetric is the battery history CSV metric for the suspend efficiency trend.
+	SuspendEfficiencyMetric = "Suspend efficiency"
+
+	// SuspendEfficiencyInterval is the length of the intervals of the suspend efficiency trend.
+	SuspendEfficiencyInterval = time.Hour
+)
+
+// SuspendEfficiency returns the percentage of the unplugged time the CPU was not running.
+// It returns 0 if there was no unplugged time.
+func SuspendEfficiency(cpuRunning, unplugged time.Duration) float64 {
+	if unplugged <= 0 {
+		return 0
+	}
+	if cpuRunning > unplugged {
+		cpuRunning = unplugged
+	}
+	return 100 * float64(unplugged-cpuRunning) / float64(unplugged)
+}
+
+// EfficiencyPoint is the suspend efficiency over an interval of the report.
+type EfficiencyPoint struct {
+	StartMs, EndMs int64
+	// Unplugged is the time the device was unplugged during the interval.
+	Unplugged time.Duration
+	// Efficiency is the percentage of the unplugged time the CPU was not running.
+	Efficiency float64
+	// USBAwake is the unplugged time the CPU was running while the USB was connected, e.g. not charging
+	// or with a data link up, which explains part of a low efficiency.
+	USBAwake time.Duration
+}
+
+// unplugged returns the intervals within [startMs, endMs) not covered by the plugged events.
+// The plugged events must be non overlapping and sorted by start time.
+func unplugged(plugged []csv.Event, startMs, endMs int64) []csv.Event {
+	var res []csv.Event
+	cur := startMs
+	for _, p := range plugged {
+		if p.End <= cur {
+			continue
+		}
+		if p.Start >= endMs {
+			break
+		}
+		if p.Start > cur {
+			res = append(res, csv.Event{Start: cur, End: p.Start})
+		}
+		cur = p.End
+	}
+	if cur < endMs {
+		res = append(res, csv.Event{Start: cur, End: endMs})
+	}
+	return res
+}
+
+// SuspendEfficiencyTrend computes the suspend efficiency over consecutive intervals of the given length,
+// from the battery history CSV generated by AnalyzeHistory. The report range is taken from the battery level
+// events. Intervals where the device was always plugged in are skipped.
+func SuspendEfficiencyTrend(csvInput string, interval time.Duration) ([]EfficiencyPoint, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{BatteryLevel, cpuRunning}, usbMetrics...))
+	levels := es[BatteryLevel]
+	if len(levels) == 0 || interval <= 0 {
+		return nil, errs
+	}
+	startMs, endMs := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, e := range levels {
+		if e.Start < startMs {
+			startMs = e.Start
+		}
+		if e.End > endMs {
+			endMs = e.End
+		}
+	}
+	cpu := csv.MergeEvents(es[cpuRunning])
+	plugged := csv.MergeEvents(es[Plugged])
+	sort.Sort(sortByStart(cpu))
+	sort.Sort(sortByStart(plugged))
+	usb := usbConnected(usbStates(es))
+
+	step := int64(interval / time.Millisecond)
+	var points []EfficiencyPoint
+	for s := startMs; s < endMs; s += step {
+		e := s + step
+		if e > endMs {
+			e = endMs
+		}
+		var unpluggedMs, runningMs, usbMs int64
+		for _, u := range unplugged(plugged, s, e) {
+			unpluggedMs += u.End - u.Start
+			runningMs += csv.Overlap(cpu, u.Start, u.End)
+			for _, c := range usb {
+				end := c.End
+				if u.End < end {
+					end = u.End
+				}
+				if start := historianutils.MaxInt64(c.Start, u.Start); start < end {
+					usbMs += csv.Overlap(cpu, start, end)
+				}
+			}
+		}
+		if unpluggedMs == 0 {
+			continue
+		}
+		u := time.Duration(unpluggedMs) * time.Millisecond
+		points = append(points, EfficiencyPoint{
+			StartMs:    s,
+			EndMs:      e,
+			Unplugged:  u,
+			Efficiency: SuspendEfficiency(time.Duration(runningMs)*time.Millisecond, u),
+			USBAwake:   time.Duration(usbMs) * time.Millisecond,
+		})
+	}
+	return points, errs
+}
+
+// WriteSuspendEfficiency writes a SuspendEfficiencyMetric row for each interval of the suspend efficiency
+// trend computed from the battery history CSV, so it can be plotted on the timeline. The CPU running
+// time while the USB was connected is noted in the optional field of the row, if any.
+func WriteSuspendEfficiency(w io.Writer, csvInput string) []error {
+	points, errs := SuspendEfficiencyTrend(csvInput, SuspendEfficiencyInterval)
+	csvState := csv.NewState(w, false)
+	for _, p := range points {
+		var note string
+		if p.USBAwake > 0 {
+			note = fmt.Sprintf("USB connected awake %v", p.USBAwake)
+		}
+		csvState.Print(SuspendEfficiencyMetric, "int", p.StartMs, p.EndMs, fmt.Sprintf("%.0f", p.Efficiency), note)
+	}
+	return errs
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestWriteSuspendEfficiency tests the generation of the hourly suspend efficiency rows.
+func TestWriteSuspendEfficiency(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []string
+	}{
+		{
+			desc: "No battery level events",
+			input: []string{
+				csv.FileHeader,
+				`CPU running,string,0,1000,,`,
+			},
+		},
+		{
+			desc: "Multiple hours, partly plugged in",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,3600000,100,`,
+				`Battery Level,int,3600000,9000000,99,`,
+				// Concurrent events are merged, 0 - 900000 is running.
+				`CPU running,string,0,600000,,`,
+				`CPU running,string,300000,900000,,`,
+				// Running while plugged in isn't counted.
+				`CPU running,string,3600000,5400000,,`,
+				`Plugged,bool,3600000,5400000,true,`,
+				`CPU running,string,6300000,7200000,,`,
+				// The last hour is always plugged in, so is skipped.
+				`Plugged,bool,7200000,9000000,true,`,
+			},
+			want: []string{
+				`Suspend efficiency,int,0,3600000,75,`,
+				`Suspend efficiency,int,3600000,7200000,50,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		var b bytes.Buffer
+		if errs := WriteSuspendEfficiency(&b, input); len(errs) > 0 {
+			t.Errorf("%v: WriteSuspendEfficiency(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		got := strings.TrimSpace(b.String())
+		if want := strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%v: WriteSuspendEfficiency(%v)\n got: %v\n want: %v", test.desc, input, got, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// explain.go explains a single battery level drop, e.g. 47->46, the way an expert reads the step in
+// the timeline: how long it took, how much of it the screen was on and the CPU running, what held
+// the CPU awake, which apps used the most CPU time and which network the device was on.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Kinds of the factors of a level step explanation.
+	factorScreen   = "screen"
+	factorCPU      = "cpu"
+	factorWakelock = "wakelock"
+	factorJob      = "job"
+	factorSync     = "sync"
+	factorApp      = "app"
+	factorDcpu     = "dcpu"
+
+	// maxDcpuConsumers is the number of Dcpu top consumers kept in a level step explanation.
+	maxDcpuConsumers = 3
+)
+
+// StepFactor is something that contributed to a battery level drop.
+type StepFactor struct {
+	// Kind is the kind of factor: "screen", "cpu", "wakelock", "job", "sync", "app" or "dcpu", for the
+	// CPU time of the top consumers in the Dcpu events.
+	Kind string `json:"kind"`
+	// Name is the wakelock, job, sync or app name, empty for the screen and CPU running.
+	Name       string `json:"name,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	// Fraction is the duration relative to the step duration.
+	Fraction float64 `json:"fraction"`
+}
+
+// StepExplanation explains a single occurrence of a battery level drop.
+type StepExplanation struct {
+	// Step is the level drop, e.g. "47->46".
+	Step       string `json:"step"`
+	StartMs    int64  `json:"startMs"`
+	EndMs      int64  `json:"endMs"`
+	DurationMs int64  `json:"durationMs"`
+
+	ScreenOnFraction   float64 `json:"screenOnFraction"`
+	CPURunningFraction float64 `json:"cpuRunningFraction"`
+	// Network is the default network the device was on for the longest time of the step, e.g.
+	// "TYPE_WIFI", empty if there was no connectivity.
+	Network string `json:"network,omitempty"`
+
+	// TopWakelock, TopJob, TopSync and TopApp are the top factor of each kind, nil if none.
+	TopWakelock *StepFactor `json:"topWakelock,omitempty"`
+	TopJob      *StepFactor `json:"topJob,omitempty"`
+	TopSync     *StepFactor `json:"topSync,omitempty"`
+	TopApp      *StepFactor `json:"topApp,omitempty"`
+	// DcpuConsumers are the apps with the most CPU time in the Dcpu events of the step.
+	DcpuConsumers []StepFactor `json:"dcpuConsumers,omitempty"`
+
+	// Factors are all of the above factors ranked in descending order of duration.
+	Factors []StepFactor `json:"factors"`
+}
+
+// byFactorDuration sorts factors in descending order of duration, then by kind and name.
+type byFactorDuration []StepFactor
+
+func (a byFactorDuration) Len() int      { return len(a) }
+func (a byFactorDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byFactorDuration) Less(i, j int) bool {
+	switch {
+	case a[i].DurationMs != a[j].DurationMs:
+		return a[i].DurationMs > a[j].DurationMs
+	case a[i].Kind != a[j].Kind:
+		return a[i].Kind < a[j].Kind
+	}
+	return a[i].Name < a[j].Name
+}
+
+// ParseLevelStepKey parses a level drop in the format returned by LevelStepKey, e.g. "47->46".
+func ParseLevelStepKey(k string) (int, int, error) {
+	parts := strings.Split(k, "->")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid level step %q, want e.g. 47->46", k)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid level step %q, want e.g. 47->46", k)
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || to >= from {
+		return 0, 0, fmt.Errorf("invalid level step %q, want e.g. 47->46", k)
+	}
+	return from, to, nil
+}
+
+// newFactor returns the factor of the duration in a step of the given duration.
+func newFactor(kind, name string, d time.Duration, stepMs int64) StepFactor {
+	f := StepFactor{Kind: kind, Name: name, DurationMs: int64(d / time.Millisecond)}
+	if stepMs > 0 {
+		f.Fraction = float64(f.DurationMs) / float64(stepMs)
+	}
+	return f
+}
+
+// topFactor returns the entry of the summary map with the longest duration as a factor, nil if
+// there are no entries with a duration.
+func topFactor(kind string, m map[string]Dist, stepMs int64) *StepFactor {
+	var name string
+	var max time.Duration
+	for k, d := range m {
+		// Ties are broken by name so the result is deterministic.
+		if d.TotalDuration > max || (d.TotalDuration == max && max > 0 && k < name) {
+			name, max = k, d.TotalDuration
+		}
+	}
+	if max == 0 {
+		return nil
+	}
+	f := newFactor(kind, name, max, stepMs)
+	return &f
+}
+
+// dcpuConsumers returns the apps with the most CPU time in the Dcpu events of the summary.
+func dcpuConsumers(s ActivitySummary, stepMs int64) []StepFactor {
+	cpu := make(map[string]time.Duration)
+	for _, d := range s.DcpuStatsSummary {
+		for _, a := range d.CPUUtilizers {
+			n := a.pkgName
+			if n == "" {
+				n = fmt.Sprintf("UID %s", a.UID)
+			}
+			cpu[n] += a.UserTime + a.SystemTime
+		}
+	}
+	var res []StepFactor
+	for n, d := range cpu {
+		res = append(res, newFactor(factorDcpu, n, d, stepMs))
+	}
+	sort.Sort(byFactorDuration(res))
+	if len(res) > maxDcpuConsumers {
+		res = res[:maxDcpuConsumers]
+	}
+	return res
+}
+
+// ExplainLevelStep explains each occurrence of the given battery level drop in the summaries
+// generated with FormatBatteryLevel, in chronological order. The network is only set if
+// AddNetworkSummaries was called on the summaries. Untimed summaries are skipped.
+func ExplainLevelStep(summaries []ActivitySummary, from, to int) []StepExplanation {
+	var res []StepExplanation
+	for _, s := range summaries {
+		if s.Untimed || s.InitialBatteryLevel != from || s.FinalBatteryLevel != to {
+			continue
+		}
+		stepMs := s.EndTimeMs - s.StartTimeMs
+		e := StepExplanation{
+			Step:       LevelStepKey(from, to),
+			StartMs:    s.StartTimeMs,
+			EndMs:      s.EndTimeMs,
+			DurationMs: stepMs,
+		}
+		screen := newFactor(factorScreen, "", s.ScreenOnSummary.TotalDuration, stepMs)
+		cpu := newFactor(factorCPU, "", s.CPURunningSummary.TotalDuration, stepMs)
+		e.ScreenOnFraction, e.CPURunningFraction = screen.Fraction, cpu.Fraction
+		if n := topFactor("", s.DefaultNetworkSummary, stepMs); n != nil {
+			e.Network = n.Name
+		}
+		e.TopWakelock = topFactor(factorWakelock, s.WakeLockSummary, stepMs)
+		e.TopJob = topFactor(factorJob, s.ScheduledJobSummary, stepMs)
+		e.TopSync = topFactor(factorSync, s.PerAppSyncSummary, stepMs)
+		e.TopApp = topFactor(factorApp, s.AttributedCPURunningSummary, stepMs)
+		e.DcpuConsumers = dcpuConsumers(s, stepMs)
+
+		for _, f := range []StepFactor{screen, cpu} {
+			if f.DurationMs > 0 {
+				e.Factors = append(e.Factors, f)
+			}
+		}
+		for _, f := range []*StepFactor{e.TopWakelock, e.TopJob, e.TopSync, e.TopApp} {
+			if f != nil {
+				e.Factors = append(e.Factors, *f)
+			}
+		}
+		e.Factors = append(e.Factors, e.DcpuConsumers...)
+		sort.Sort(byFactorDuration(e.Factors))
+		res = append(res, e)
+	}
+	return res
+}
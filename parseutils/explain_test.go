@@ -0,0 +1,144 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestExplainLevelStep tests the explanation of each occurrence of a level drop.
+func TestExplainLevelStep(t *testing.T) {
+	summaries := []ActivitySummary{
+		{
+			StartTimeMs:         0,
+			EndTimeMs:           100000,
+			InitialBatteryLevel: 47,
+			FinalBatteryLevel:   46,
+			ScreenOnSummary:     Dist{TotalDuration: 20 * time.Second},
+			CPURunningSummary:   Dist{TotalDuration: 50 * time.Second},
+			WakeLockSummary: map[string]Dist{
+				`"*alarm*"`:                    {TotalDuration: 10 * time.Second},
+				`"*sync*/gmail-ls/com.google"`: {TotalDuration: 30 * time.Second},
+			},
+			ScheduledJobSummary: map[string]Dist{
+				`"com.google.android.gms/.gcm.GcmService"`: {TotalDuration: 5 * time.Second},
+			},
+			PerAppSyncSummary: map[string]Dist{},
+			AttributedCPURunningSummary: map[string]Dist{
+				"com.google.android.gm":  {TotalDuration: 30 * time.Second},
+				"com.google.android.gms": {TotalDuration: 15 * time.Second},
+			},
+			DefaultNetworkSummary: map[string]Dist{
+				"TYPE_WIFI":   {TotalDuration: 10 * time.Second},
+				"TYPE_MOBILE": {TotalDuration: 90 * time.Second},
+			},
+			DcpuStatsSummary: []DCPU{
+				{
+					CPUUtilizers: []AppCPUUsage{
+						{pkgName: "ANDROID_SYSTEM", UID: "1000", UserTime: 3 * time.Second, SystemTime: 1 * time.Second},
+						{UID: "10019", UserTime: 1 * time.Second},
+					},
+				},
+				{
+					CPUUtilizers: []AppCPUUsage{
+						{pkgName: "ANDROID_SYSTEM", UID: "1000", UserTime: 2 * time.Second},
+					},
+				},
+			},
+		},
+		// A different level drop.
+		{
+			StartTimeMs:         100000,
+			EndTimeMs:           200000,
+			InitialBatteryLevel: 46,
+			FinalBatteryLevel:   45,
+			ScreenOnSummary:     Dist{TotalDuration: 100 * time.Second},
+		},
+		// The same level drop after charging, without any activity.
+		{
+			StartTimeMs:         500000,
+			EndTimeMs:           800000,
+			InitialBatteryLevel: 47,
+			FinalBatteryLevel:   46,
+		},
+	}
+	wakelock := StepFactor{Kind: "wakelock", Name: `"*sync*/gmail-ls/com.google"`, DurationMs: 30000, Fraction: 0.3}
+	job := StepFactor{Kind: "job", Name: `"com.google.android.gms/.gcm.GcmService"`, DurationMs: 5000, Fraction: 0.05}
+	app := StepFactor{Kind: "app", Name: "com.google.android.gm", DurationMs: 30000, Fraction: 0.3}
+	dcpu := []StepFactor{
+		{Kind: "dcpu", Name: "ANDROID_SYSTEM", DurationMs: 6000, Fraction: 0.06},
+		{Kind: "dcpu", Name: "UID 10019", DurationMs: 1000, Fraction: 0.01},
+	}
+	want := []StepExplanation{
+		{
+			Step:               "47->46",
+			StartMs:            0,
+			EndMs:              100000,
+			DurationMs:         100000,
+			ScreenOnFraction:   0.2,
+			CPURunningFraction: 0.5,
+			Network:            "TYPE_MOBILE",
+			TopWakelock:        &wakelock,
+			TopJob:             &job,
+			TopApp:             &app,
+			DcpuConsumers:      dcpu,
+			Factors: []StepFactor{
+				{Kind: "cpu", DurationMs: 50000, Fraction: 0.5},
+				app,
+				wakelock,
+				{Kind: "screen", DurationMs: 20000, Fraction: 0.2},
+				dcpu[0],
+				job,
+				dcpu[1],
+			},
+		},
+		{
+			Step:       "47->46",
+			StartMs:    500000,
+			EndMs:      800000,
+			DurationMs: 300000,
+		},
+	}
+	if got := ExplainLevelStep(summaries, 47, 46); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExplainLevelStep(summaries, 47, 46)\n got: %+v\n want: %+v", got, want)
+	}
+}
+
+// TestParseLevelStepKey tests the parsing of level drops.
+func TestParseLevelStepKey(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to int
+		wantErr  bool
+	}{
+		{in: "47->46", from: 47, to: 46},
+		{in: "100 -> 98", from: 100, to: 98},
+		{in: "46->47", wantErr: true},
+		{in: "47", wantErr: true},
+		{in: "a->b", wantErr: true},
+	}
+	for _, test := range tests {
+		from, to, err := ParseLevelStepKey(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseLevelStepKey(%q) got error %v, want error %t", test.in, err, test.wantErr)
+			continue
+		}
+		if from != test.from || to != test.to {
+			t.Errorf("ParseLevelStepKey(%q) = %d, %d, want %d, %d", test.in, from, to, test.from, test.to)
+		}
+	}
+}
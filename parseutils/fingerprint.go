@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// fingerprint.go summarizes which major components were on during each battery level drop.
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// StepFingerprint is the battery history CSV metric listing the components on for most of a battery level step.
+	StepFingerprint = "Battery step fingerprint"
+	// noneOn is the fingerprint value if no component was on for most of the step.
+	noneOn = "None"
+)
+
+// fingerprintMetrics are the metrics included in the fingerprint, in the order they are listed.
+// If value is non empty, only events of the metric with that value count as on.
+var fingerprintMetrics = []struct {
+	metric, value, name string
+}{
+	{"Screen", "", "Screen"},
+	{"Mobile radio active", "", "Mobile radio"},
+	{"GPS", "", "GPS"},
+	{"Partial wakelock", "", "Wakelock"},
+	{"Doze", "off", "Doze off"},
+}
+
+// WriteStepFingerprints reads the battery history CSV generated by AnalyzeHistory, and writes a
+// StepFingerprint row for each battery level drop, listing the components (screen, mobile radio,
+// GPS, partial wakelocks and Doze off) that were on for more than half of the step.
+func WriteStepFingerprints(w io.Writer, csvInput string) []error {
+	var metrics []string
+	for _, m := range fingerprintMetrics {
+		metrics = append(metrics, m.metric)
+	}
+	es, errs := csv.ExtractEvents(csvInput, append(metrics, BatteryLevel))
+	on := make([][]csv.Event, len(fingerprintMetrics))
+	for i, m := range fingerprintMetrics {
+		var events []csv.Event
+		for _, e := range es[m.metric] {
+			if m.value == "" || e.Value == m.value {
+				events = append(events, e)
+			}
+		}
+		on[i] = csv.MergeEvents(events)
+	}
+
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	sort.Sort(sortByStart(levels))
+
+	csvState := csv.NewState(w, false)
+	for i := 0; i+1 < len(levels); i++ {
+		cur, next := levels[i], levels[i+1]
+		from, _ := strconv.Atoi(cur.Value)
+		to, _ := strconv.Atoi(next.Value)
+		if to >= from || cur.End <= cur.Start {
+			continue
+		}
+		var names []string
+		for j, m := range fingerprintMetrics {
+			if 2*csv.Overlap(on[j], cur.Start, cur.End) > cur.End-cur.Start {
+				names = append(names, m.name)
+			}
+		}
+		value := noneOn
+		if len(names) > 0 {
+			value = strings.Join(names, ", ")
+		}
+		csvState.Print(StepFingerprint, "string", cur.Start, cur.End, value, "")
+	}
+	return errs
+}
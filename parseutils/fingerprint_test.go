@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestWriteStepFingerprints tests the generation of the per battery step fingerprint rows.
+func TestWriteStepFingerprints(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  []string
+	}{
+		{
+			desc: "No level drops",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,1000,50,`,
+				`Battery Level,int,1000,2000,51,`,
+				`Screen,bool,0,2000,true,`,
+			},
+		},
+		{
+			desc: "Multiple steps",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,1000,100,`,
+				`Battery Level,int,1000,2000,99,`,
+				`Battery Level,int,2000,3000,98,`,
+				`Battery Level,int,3000,3000,97,`,
+				`Screen,bool,0,600,true,`,
+				`Screen,bool,1000,1200,true,`,
+				// Concurrent wakelocks are merged, 1000 - 1600 is held.
+				`Partial wakelock,service,1000,1400,com.google.android.gms,`,
+				`Partial wakelock,service,1200,1600,com.google.android.gm,`,
+				`Mobile radio active,bool,1000,1600,true,`,
+				`Doze,string,0,2500,off,`,
+				`Doze,string,2500,3000,full,`,
+				`GPS,bool,2000,2500,true,`,
+			},
+			want: []string{
+				`Battery step fingerprint,string,0,1000,"Screen, Doze off",`,
+				`Battery step fingerprint,string,1000,2000,"Mobile radio, Wakelock, Doze off",`,
+				`Battery step fingerprint,string,2000,3000,None,`,
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		var b bytes.Buffer
+		if errs := WriteStepFingerprints(&b, input); len(errs) > 0 {
+			t.Errorf("%v: WriteStepFingerprints(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		got := strings.TrimSpace(b.String())
+		if want := strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%v: WriteStepFingerprints(%v)\n got: %v\n want: %v", test.desc, input, got, want)
+		}
+	}
+}
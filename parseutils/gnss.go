@@ -0,0 +1,172 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// gnss.go analyzes the GPS on periods in the battery history: the duty cycle per hour, the longest
+// session, and the sessions kept running in the background while the screen was off, which are
+// attributed to the apps requesting GNSS locations where the location service dump allows it.
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// backgroundGNSSThreshold is the minimum continuous GPS on time with the screen off for a session
+	// to be flagged as background GPS use.
+	backgroundGNSSThreshold = 30 * time.Minute
+
+	// Battery history CSV metrics used for the GNSS analysis. GPS requests are added by the location package.
+	gps        = "GPS"
+	gpsRequest = "GPS request"
+	screen     = "Screen"
+)
+
+// GNSSHour is the GPS on time over an hour of the history.
+type GNSSHour struct {
+	StartMs, EndMs int64
+	On             time.Duration
+}
+
+// Start returns the start of the hour.
+func (h GNSSHour) Start() time.Time {
+	return time.Unix(0, h.StartMs*int64(time.Millisecond))
+}
+
+// DutyCycle returns the percentage of the hour GPS was on.
+func (h GNSSHour) DutyCycle() float64 {
+	if h.EndMs <= h.StartMs {
+		return 0
+	}
+	return 100 * float64(h.On/time.Millisecond) / float64(h.EndMs-h.StartMs)
+}
+
+// GNSSSession is a continuous period GPS was on.
+type GNSSSession struct {
+	StartMs, EndMs int64
+	// Apps are the packages of the apps requesting GNSS locations during the session, empty if unknown.
+	Apps []string
+}
+
+// Start returns the start of the session.
+func (s GNSSSession) Start() time.Time {
+	return time.Unix(0, s.StartMs*int64(time.Millisecond))
+}
+
+// Duration returns the length of the session.
+func (s GNSSSession) Duration() time.Duration {
+	return time.Duration(s.EndMs-s.StartMs) * time.Millisecond
+}
+
+// GNSSSummary is the GPS usage over the battery history.
+type GNSSSummary struct {
+	// Hours are the GPS on time of each hour since the start of the history, the last one possibly partial.
+	Hours []GNSSHour
+	// Total is the total time GPS was on, and ScreenOff the part of it with the screen off.
+	Total, ScreenOff time.Duration
+	// Longest is the longest GPS on session.
+	Longest GNSSSession
+	// Background are the sessions of more than backgroundGNSSThreshold with GPS on and the screen off.
+	Background []GNSSSession
+}
+
+// requestingApps returns the sorted packages of the requests overlapping [startMs, endMs].
+func requestingApps(requests []csv.Event, startMs, endMs int64) []string {
+	seen := make(map[string]bool)
+	var apps []string
+	for _, r := range requests {
+		if r.Start < endMs && r.End > startMs && !seen[r.Value] {
+			seen[r.Value] = true
+			apps = append(apps, r.Value)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// screenOffParts returns the parts of [startMs, endMs] the screen was off, given the sorted, non
+// overlapping screen on events.
+func screenOffParts(screenOn []csv.Event, startMs, endMs int64) []csv.Event {
+	var parts []csv.Event
+	cur := startMs
+	for _, e := range screenOn {
+		if e.Start >= endMs {
+			break
+		}
+		if e.End <= cur {
+			continue
+		}
+		if e.Start > cur {
+			parts = append(parts, csv.Event{Start: cur, End: e.Start})
+		}
+		cur = e.End
+	}
+	if cur < endMs {
+		parts = append(parts, csv.Event{Start: cur, End: endMs})
+	}
+	return parts
+}
+
+// GNSSUsage computes the GPS usage from the battery history CSV generated by AnalyzeHistory, with any
+// GPS requests added to it. It returns nil if GPS was never on.
+func GNSSUsage(csvInput string) (*GNSSSummary, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{gps, gpsRequest, screen, BatteryLevel})
+	on := es[gps]
+	if len(on) == 0 {
+		return nil, errs
+	}
+	sort.Sort(sortByStart(on))
+	screenOn := es[screen]
+	sort.Sort(sortByStart(screenOn))
+
+	g := &GNSSSummary{}
+	for _, e := range on {
+		d := time.Duration(e.End-e.Start) * time.Millisecond
+		g.Total += d
+		g.ScreenOff += d - time.Duration(csv.Overlap(screenOn, e.Start, e.End))*time.Millisecond
+		if d > g.Longest.Duration() {
+			g.Longest = GNSSSession{StartMs: e.Start, EndMs: e.End, Apps: requestingApps(es[gpsRequest], e.Start, e.End)}
+		}
+		for _, p := range screenOffParts(screenOn, e.Start, e.End) {
+			s := GNSSSession{StartMs: p.Start, EndMs: p.End}
+			if s.Duration() > backgroundGNSSThreshold {
+				s.Apps = requestingApps(es[gpsRequest], p.Start, p.End)
+				g.Background = append(g.Background, s)
+			}
+		}
+	}
+
+	// The hours span the whole history, which the battery level events cover.
+	startMs, endMs := on[0].Start, historyEnd(es[BatteryLevel])
+	for _, e := range es[BatteryLevel] {
+		if e.Start < startMs {
+			startMs = e.Start
+		}
+	}
+	if last := on[len(on)-1].End; last > endMs {
+		endMs = last
+	}
+	hourMs := int64(time.Hour / time.Millisecond)
+	for s := startMs; s < endMs; s += hourMs {
+		e := s + hourMs
+		if e > endMs {
+			e = endMs
+		}
+		g.Hours = append(g.Hours, GNSSHour{StartMs: s, EndMs: e, On: time.Duration(csv.Overlap(on, s, e)) * time.Millisecond})
+	}
+	return g, errs
+}
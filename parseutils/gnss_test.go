@@ -0,0 +1,86 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestGNSSUsage tests the GPS duty cycle and background session detection.
+func TestGNSSUsage(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input []string
+		want  *GNSSSummary
+	}{
+		{
+			desc: "GPS never on",
+			input: []string{
+				fmt.Sprintf(`Battery Level,int,0,%d,90,`, 60*minMs),
+				fmt.Sprintf(`Screen,bool,0,%d,true,`, 10*minMs),
+			},
+		},
+		{
+			desc: "foreground and background sessions",
+			input: []string{
+				fmt.Sprintf(`Battery Level,int,0,%d,90,`, 120*minMs),
+				fmt.Sprintf(`Screen,bool,%d,%d,true,`, 5*minMs, 25*minMs),
+				fmt.Sprintf(`Screen,bool,%d,%d,true,`, 50*minMs, 55*minMs),
+				fmt.Sprintf(`GPS,bool,%d,%d,true,`, 10*minMs, 20*minMs),
+				fmt.Sprintf(`GPS,bool,%d,%d,true,`, 40*minMs, 115*minMs),
+				fmt.Sprintf(`GPS request,service,0,%d,com.example.tracker,10123`, 15*minMs),
+				fmt.Sprintf(`GPS request,service,%d,%d,com.example.run,10200`, 30*minMs, 120*minMs),
+			},
+			want: &GNSSSummary{
+				Hours: []GNSSHour{
+					{StartMs: 0, EndMs: hourMs, On: 30 * time.Minute},
+					{StartMs: hourMs, EndMs: 2 * hourMs, On: 55 * time.Minute},
+				},
+				Total:     85 * time.Minute,
+				ScreenOff: 70 * time.Minute,
+				Longest:   GNSSSession{StartMs: 40 * minMs, EndMs: 115 * minMs, Apps: []string{"com.example.run"}},
+				// The 10 minutes before the screen was turned on are too short.
+				Background: []GNSSSession{
+					{StartMs: 55 * minMs, EndMs: 115 * minMs, Apps: []string{"com.example.run"}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(append([]string{csv.FileHeader}, test.input...), "\n")
+		got, errs := GNSSUsage(input)
+		if len(errs) > 0 {
+			t.Errorf("%s: GNSSUsage generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: GNSSUsage(%v)\n got: %+v\n want: %+v", test.desc, input, got, test.want)
+		}
+	}
+}
+
+// TestGNSSHourDutyCycle tests the percentage of an hour GPS was on.
+func TestGNSSHourDutyCycle(t *testing.T) {
+	h := GNSSHour{StartMs: 0, EndMs: 30 * minMs, On: 15 * time.Minute}
+	if got := h.DutyCycle(); got != 50 {
+		t.Errorf("%+v.DutyCycle() = %v, want 50", h, got)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// heatmap.go breaks down the battery drain, screen on time and wakeups of multi-day histories by
+// day and hour of the day, in the device time zone, so that they can be rendered as a heatmap.
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// heatmapDayLayout is the format of the heatmap days.
+const heatmapDayLayout = "2006-01-02"
+
+// HeatmapCell is the device activity during one hour of a day.
+type HeatmapCell struct {
+	// CoveredMs is the part of the hour covered by the history, less than an hour at its ends.
+	CoveredMs int64 `json:"coveredMs"`
+	// PluggedMs is the part of the covered time the device was plugged in.
+	PluggedMs int64 `json:"pluggedMs"`
+	// LevelDrop is the total battery level drop during the hour, in percent.
+	LevelDrop int `json:"levelDrop"`
+	// DrainPerHour is the level drop per hour unplugged, 0 if the device was never unplugged.
+	DrainPerHour float64 `json:"drainPerHour"`
+	ScreenOnMs   int64   `json:"screenOnMs"`
+	// Wakeups is the number of times the CPU started running.
+	Wakeups int `json:"wakeups"`
+}
+
+// Heatmap is the device activity of each hour of each day of the history.
+type Heatmap struct {
+	// Location is the time zone the days and hours are in.
+	Location string `json:"location"`
+	// Days are the dates of the rows, e.g. "2015-01-30", including any days without history.
+	Days []string `json:"days"`
+	// Cells are indexed by day, then by hour of the day.
+	Cells [][24]HeatmapCell `json:"cells"`
+}
+
+// heatmapBuilder accumulates the cells of a heatmap over the history range.
+type heatmapBuilder struct {
+	h              *Heatmap
+	loc            *time.Location
+	startMs, endMs int64
+	// days maps the days to their index in the heatmap.
+	days map[string]int
+}
+
+// newHeatmapBuilder returns a builder with the empty cells of every day in [startMs, endMs].
+func newHeatmapBuilder(startMs, endMs int64, loc *time.Location) *heatmapBuilder {
+	b := &heatmapBuilder{h: &Heatmap{Location: loc.String()}, loc: loc, startMs: startMs, endMs: endMs, days: make(map[string]int)}
+	y, m, d := msTime(startMs, loc).Date()
+	last := msTime(endMs, loc).Format(heatmapDayLayout)
+	for day := time.Date(y, m, d, 0, 0, 0, 0, loc); ; day = day.AddDate(0, 0, 1) {
+		f := day.Format(heatmapDayLayout)
+		b.days[f] = len(b.h.Days)
+		b.h.Days = append(b.h.Days, f)
+		b.h.Cells = append(b.h.Cells, [24]HeatmapCell{})
+		if f >= last {
+			break
+		}
+	}
+	return b
+}
+
+// msTime returns the unix time in ms as a time in the given location.
+func msTime(ms int64, loc *time.Location) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).In(loc)
+}
+
+// cell returns the cell of the wall clock hour the unix time in ms is in, and the start of the next
+// hour. On daylight saving time changes, a repeated hour is counted in the same cell, and a skipped
+// hour is empty. The cell is nil if the time is outside the history.
+func (b *heatmapBuilder) cell(ms int64) (*HeatmapCell, int64) {
+	t := msTime(ms, b.loc)
+	y, m, d := t.Date()
+	next := time.Date(y, m, d, t.Hour()+1, 0, 0, 0, b.loc).UnixNano() / int64(time.Millisecond)
+	if next <= ms {
+		// Only possible around daylight saving time changes.
+		next = ms + int64(time.Hour/time.Millisecond)
+	}
+	i, ok := b.days[t.Format(heatmapDayLayout)]
+	if !ok || ms < b.startMs || ms >= b.endMs {
+		return nil, next
+	}
+	return &b.h.Cells[i][t.Hour()], next
+}
+
+// addDuration adds the part of [startMs, endMs] in each hour of the history to its cell with the given function.
+func (b *heatmapBuilder) addDuration(startMs, endMs int64, add func(c *HeatmapCell, ms int64)) {
+	if startMs < b.startMs {
+		startMs = b.startMs
+	}
+	if endMs > b.endMs {
+		endMs = b.endMs
+	}
+	for cur := startMs; cur < endMs; {
+		c, next := b.cell(cur)
+		if next > endMs {
+			next = endMs
+		}
+		if c != nil {
+			add(c, next-cur)
+		}
+		cur = next
+	}
+}
+
+// DayHourHeatmap computes the drain rate, screen on time and wakeups of each hour of each day of the
+// history, in the given time zone, from the battery history CSV generated by AnalyzeHistory. Events
+// from before the clock was set, e.g. at epoch, are ignored. It returns nil if the history has no
+// battery level events with valid times.
+func DayHourHeatmap(csvInput string, loc *time.Location) (*Heatmap, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{BatteryLevel, Plugged, screen, cpuRunning})
+	levels := es[BatteryLevel]
+	startMs := int64(-1)
+	for _, e := range levels {
+		if e.Start >= minValidTimeMs && (startMs < 0 || e.Start < startMs) {
+			startMs = e.Start
+		}
+	}
+	if startMs < 0 {
+		return nil, errs
+	}
+	b := newHeatmapBuilder(startMs, historyEnd(levels), loc)
+
+	b.addDuration(b.startMs, b.endMs, func(c *HeatmapCell, ms int64) { c.CoveredMs += ms })
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			b.addDuration(e.Start, e.End, func(c *HeatmapCell, ms int64) { c.PluggedMs += ms })
+		}
+	}
+	for _, e := range es[screen] {
+		b.addDuration(e.Start, e.End, func(c *HeatmapCell, ms int64) { c.ScreenOnMs += ms })
+	}
+	for _, e := range es[cpuRunning] {
+		if c, _ := b.cell(e.Start); c != nil {
+			c.Wakeups++
+		}
+	}
+	for _, d := range levelDrops(levels) {
+		n, err := strconv.Atoi(d.Value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// The drop happened by the time of the level change, so is counted in the hour before it.
+		if c, _ := b.cell(d.Start - 1); c != nil {
+			c.LevelDrop += n
+		}
+	}
+	h := b.h
+	for i := range h.Cells {
+		for j := range h.Cells[i] {
+			c := &h.Cells[i][j]
+			if unplugged := c.CoveredMs - c.PluggedMs; unplugged > 0 {
+				c.DrainPerHour = float64(c.LevelDrop) / (time.Duration(unplugged) * time.Millisecond).Hours()
+			}
+		}
+	}
+	return h, errs
+}
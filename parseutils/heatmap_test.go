@@ -0,0 +1,98 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestDayHourHeatmap tests the breakdown of the history by day and hour of the day.
+func TestDayHourHeatmap(t *testing.T) {
+	// 2015-01-30 23:00 UTC.
+	base := time.Date(2015, time.January, 30, 23, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	input := []string{
+		fmt.Sprintf(`Battery Level,int,%d,%d,90,`, base, base+30*minMs),
+		fmt.Sprintf(`Battery Level,int,%d,%d,88,`, base+30*minMs, base+90*minMs),
+		fmt.Sprintf(`Battery Level,int,%d,%d,87,`, base+90*minMs, base+150*minMs),
+		fmt.Sprintf(`Plugged,bool,%d,%d,true,`, base+120*minMs, base+150*minMs),
+		fmt.Sprintf(`Screen,bool,%d,%d,true,`, base+50*minMs, base+70*minMs),
+		fmt.Sprintf(`CPU running,string,%d,%d,%d~%d~Unknown wakeup reason,`, base+10*minMs, base+11*minMs, base+10*minMs, base+11*minMs),
+		fmt.Sprintf(`CPU running,string,%d,%d,%d~%d~Unknown wakeup reason,`, base+65*minMs, base+66*minMs, base+65*minMs, base+66*minMs),
+	}
+
+	utc := &Heatmap{
+		Location: "UTC",
+		Days:     []string{"2015-01-30", "2015-01-31"},
+		Cells:    make([][24]HeatmapCell, 2),
+	}
+	utc.Cells[0][23] = HeatmapCell{CoveredMs: hourMs, LevelDrop: 2, DrainPerHour: 2, ScreenOnMs: 10 * minMs, Wakeups: 1}
+	utc.Cells[1][0] = HeatmapCell{CoveredMs: hourMs, LevelDrop: 1, DrainPerHour: 1, ScreenOnMs: 10 * minMs, Wakeups: 1}
+	utc.Cells[1][1] = HeatmapCell{CoveredMs: 30 * minMs, PluggedMs: 30 * minMs}
+
+	// The hours of a +05:30 time zone are offset by half an hour from the UTC ones.
+	ist := &Heatmap{
+		Location: "IST",
+		Days:     []string{"2015-01-31"},
+		Cells:    make([][24]HeatmapCell, 1),
+	}
+	// The level changes at the end of the hours are counted in them.
+	ist.Cells[0][4] = HeatmapCell{CoveredMs: 30 * minMs, LevelDrop: 2, DrainPerHour: 4, Wakeups: 1}
+	ist.Cells[0][5] = HeatmapCell{CoveredMs: hourMs, LevelDrop: 1, DrainPerHour: 1, ScreenOnMs: 20 * minMs, Wakeups: 1}
+	ist.Cells[0][6] = HeatmapCell{CoveredMs: hourMs, PluggedMs: 30 * minMs}
+
+	tests := []struct {
+		desc  string
+		input []string
+		loc   *time.Location
+		want  *Heatmap
+	}{
+		{
+			desc: "No battery level",
+			input: []string{
+				fmt.Sprintf(`Screen,bool,%d,%d,true,`, base, base+10*minMs),
+			},
+			loc: time.UTC,
+		},
+		{
+			desc:  "Multiple days",
+			input: input,
+			loc:   time.UTC,
+			want:  utc,
+		},
+		{
+			desc:  "Half hour time zone offset",
+			input: input,
+			loc:   time.FixedZone("IST", 5*3600+1800),
+			want:  ist,
+		},
+	}
+	for _, test := range tests {
+		in := strings.Join(append([]string{csv.FileHeader}, test.input...), "\n")
+		got, errs := DayHourHeatmap(in, test.loc)
+		if len(errs) > 0 {
+			t.Errorf("%s: DayHourHeatmap generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: DayHourHeatmap(%v)\n got: %+v\n want: %+v", test.desc, in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// historytoken.go parses the data tokens of the battery history lines into a structured model.
+// Most tokens have a single value, e.g. "+Ewa=3", but newer compound events carry several
+// name=value fields in one token, e.g. "Ecs=rx=1200:tx=300", which are parsed into sub-fields.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// HistoryToken is a data token of a battery history line.
+type HistoryToken struct {
+	// Transition is "+" or "-" for the start and end of an event with a duration, and empty otherwise.
+	Transition string
+	// Key is the event code, e.g. "Ewa".
+	Key string
+	// Value is the value following the key, e.g. "3" for "+Ewa=3". Compound values are kept whole.
+	Value string
+	// Fields are the sub-fields of a compound value, in order. They're nil for single values.
+	Fields []TokenField
+}
+
+// TokenField is a name=value sub-field of a compound token.
+type TokenField struct {
+	Name  string
+	Value string
+}
+
+// TokenHandler handles the tokens of an event the parser doesn't support itself, such as a newer
+// compound event. A returned error is reported as an error in the history line.
+type TokenHandler func(csvState *csv.State, state *DeviceState, summary *ActivitySummary, t HistoryToken) error
+
+// tokenHandlers are the registered handlers, keyed by event code.
+var tokenHandlers = make(map[string]TokenHandler)
+
+// RegisterTokenHandler sets the handler of the tokens with the event code. The parser's own handling
+// of a code takes precedence, so only unsupported codes can be handled.
+// It is not safe to call concurrently with parsing.
+func RegisterTokenHandler(key string, h TokenHandler) {
+	tokenHandlers[key] = h
+}
+
+// parseHistoryToken parses a data token of a battery history line with DataRE. It returns false if
+// the token doesn't have an event code.
+func parseHistoryToken(part string) (HistoryToken, bool) {
+	part = strings.TrimSpace(part)
+	m := DataRE.FindStringSubmatchIndex(part)
+	if m == nil {
+		return HistoryToken{}, false
+	}
+	t := HistoryToken{}
+	for i, name := range DataRE.SubexpNames() {
+		if m[2*i] < 0 {
+			continue
+		}
+		switch name {
+		case "transition":
+			t.Transition = part[m[2*i]:m[2*i+1]]
+		case "key":
+			t.Key = part[m[2*i]:m[2*i+1]]
+			// The compound fields may be separated by spaces, which DataRE doesn't expect in values.
+			rest := strings.TrimPrefix(part[m[2*i+1]:], ",")
+			t.Fields = parseTokenFields(strings.TrimPrefix(rest, "="))
+		case "value":
+			t.Value = part[m[2*i]:m[2*i+1]]
+		}
+	}
+	return t, true
+}
+
+// parseTokenFields returns the fields of a compound value, separated by colons or spaces, or nil if
+// the value isn't only made of name=value fields.
+func parseTokenFields(value string) []TokenField {
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == ':' || unicode.IsSpace(r) })
+	if len(parts) == 0 {
+		return nil
+	}
+	var fields []TokenField
+	for _, p := range parts {
+		i := strings.Index(p, "=")
+		if i <= 0 {
+			return nil
+		}
+		fields = append(fields, TokenField{p[:i], p[i+1:]})
+	}
+	return fields
+}
+
+// Field returns the value of the first sub-field with the name, and whether it was found.
+func (t HistoryToken) Field(name string) (string, bool) {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// IntField returns the integer value of the first sub-field with the name.
+func (t HistoryToken) IntField(name string) (int64, error) {
+	v, ok := t.Field(name)
+	if !ok {
+		return 0, fmt.Errorf("%s has no %q field", t.Key, name)
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q field %q: %v", t.Key, name, v, err)
+	}
+	return i, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestParseHistoryToken tests the parsing of single valued and compound history tokens.
+func TestParseHistoryToken(t *testing.T) {
+	tests := []struct {
+		desc string
+		part string
+		want HistoryToken
+	}{
+		{
+			desc: "Boolean event",
+			part: "+r",
+			want: HistoryToken{Transition: "+", Key: "r"},
+		},
+		{
+			desc: "Single value",
+			part: "-Ewa=3",
+			want: HistoryToken{Transition: "-", Key: "Ewa", Value: "3"},
+		},
+		{
+			desc: "Value without fields",
+			part: "Dcpu=112830:66390/1000:32930:19830",
+			want: HistoryToken{Key: "Dcpu", Value: "112830:66390/1000:32930:19830"},
+		},
+		{
+			desc: "Colon separated fields",
+			part: "Ecs=rx=1200:tx=300",
+			want: HistoryToken{Key: "Ecs", Value: "rx=1200:tx=300", Fields: []TokenField{{"rx", "1200"}, {"tx", "300"}}},
+		},
+		{
+			desc: "Space separated fields",
+			part: "Ecs=rx=1200 tx=300",
+			want: HistoryToken{Key: "Ecs", Value: "rx=1200", Fields: []TokenField{{"rx", "1200"}, {"tx", "300"}}},
+		},
+		{
+			desc: "Field with an empty value",
+			part: "Ecs=rx=:tx=300",
+			want: HistoryToken{Key: "Ecs", Value: "rx=:tx=300", Fields: []TokenField{{"rx", ""}, {"tx", "300"}}},
+		},
+	}
+	for _, test := range tests {
+		got, ok := parseHistoryToken(test.part)
+		if !ok {
+			t.Errorf("%s: parseHistoryToken(%q) didn't match", test.desc, test.part)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseHistoryToken(%q) = %+v, want %+v", test.desc, test.part, got, test.want)
+		}
+	}
+}
+
+// TestRegisterTokenHandler tests that compound events not supported by the parser are passed to the registered handler.
+func TestRegisterTokenHandler(t *testing.T) {
+	defer delete(tokenHandlers, "Ecs")
+	RegisterTokenHandler("Ecs", func(csvState *csv.State, state *DeviceState, summary *ActivitySummary, tok HistoryToken) error {
+		rx, err := tok.IntField("rx")
+		if err != nil {
+			return err
+		}
+		csvState.Print("Compound stat", "int", state.CurrentTime, state.CurrentTime, tok.Fields[0].Value, "")
+		if rx > 1000 {
+			return nil
+		}
+		_, err = tok.IntField("missing")
+		return err
+	})
+
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,1000,Bl=90,Bs=d,Bh=g,Bp=n,Bt=236,Bv=3986,Ecs=rx=1200:tx=300`,
+		`9,h,1000,Ecs=rx=10:tx=3`,
+		`9,h,1000,Bl=89`,
+	}, "\n")
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, false)
+	validateHistory(input, t, result, 1, 1)
+	if len(result.Errs) == 1 && !strings.Contains(result.Errs[0].Error(), `Ecs has no "missing" field`) {
+		t.Errorf("AnalyzeHistory(%s,...) has errs = %v, want the handler error", input, result.Errs)
+	}
+	if want := "Compound stat,int,1422620452417,1422620452417,1200,"; !strings.Contains(b.String(), want) {
+		t.Errorf("AnalyzeHistory(%s,...) wrote:\n%s\nwant %q", input, b.String(), want)
+	}
+}
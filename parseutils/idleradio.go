@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// idleradio.go finds the time the mobile or wifi radio was active while no app was using the
+// network, i.e. the network was available but unused. A lot of it points to long radio tails
+// or firmware keeping the radio up, rather than to the apps.
+
+import (
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	mobileRadio = "Mobile radio active"
+	wifiRadio   = "Wifi radio"
+)
+
+// networkActivityMetrics are the battery history CSV metrics of the app activity using the network.
+// The battery history doesn't log the per app traffic, so syncs, jobs and the top app are the app
+// network activity, as that's where almost all of the traffic comes from.
+var networkActivityMetrics = []string{"SyncManager", "JobScheduler", Top}
+
+// idleRadio returns the parts of the radio active events not covered by the merged activity events,
+// sorted by start time. Both are sorted by start time.
+func idleRadio(radio, activity []csv.Event) []csv.Event {
+	var idle []csv.Event
+	for _, r := range radio {
+		start := r.Start
+		for _, a := range activity {
+			if a.End <= start {
+				continue
+			}
+			if a.Start >= r.End {
+				break
+			}
+			if a.Start > start {
+				idle = append(idle, csv.Event{Start: start, End: a.Start})
+			}
+			start = a.End
+		}
+		if start < r.End {
+			idle = append(idle, csv.Event{Start: start, End: r.End})
+		}
+	}
+	return idle
+}
+
+// AddIdleRadioSummaries populates the IdleMobileRadioSummary and IdleWifiRadioSummary of each summary
+// from the battery history CSV generated by AnalyzeHistory, with each period the radio was active
+// without any app network activity, clipped to the summary.
+func AddIdleRadioSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{mobileRadio, wifiRadio}, networkActivityMetrics...))
+	var activity []csv.Event
+	for _, m := range networkActivityMetrics {
+		activity = append(activity, es[m]...)
+	}
+	activity = csv.MergeEvents(activity)
+
+	radios := map[string][]csv.Event{}
+	for _, m := range []string{mobileRadio, wifiRadio} {
+		var active []csv.Event
+		for _, e := range es[m] {
+			if e.Value == "true" {
+				active = append(active, e)
+			}
+		}
+		radios[m] = idleRadio(csv.MergeEvents(active), activity)
+	}
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.IdleMobileRadioSummary = Dist{}
+		s.IdleWifiRadioSummary = Dist{}
+		for m, d := range map[string]*Dist{mobileRadio: &s.IdleMobileRadioSummary, wifiRadio: &s.IdleWifiRadioSummary} {
+			for _, e := range radios[m] {
+				if ms := csv.Overlap([]csv.Event{e}, s.StartTimeMs, s.EndTimeMs); ms > 0 {
+					d.addDuration(time.Duration(ms) * time.Millisecond)
+				}
+			}
+		}
+	}
+	return errs
+}
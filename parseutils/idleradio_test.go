@@ -0,0 +1,66 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddIdleRadioSummaries tests the radio active time without app network activity.
+func TestAddIdleRadioSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		// 2s idle before the sync and a 5s tail after it.
+		`Mobile radio active,bool,10000,20000,true,`,
+		`SyncManager,service,12000,15000,com.example.provider,10045`,
+		// Fully covered by overlapping jobs.
+		`Mobile radio active,bool,30000,35000,true,`,
+		`JobScheduler,service,29000,33000,com.example.app/.SyncJob,10045`,
+		`JobScheduler,service,32000,36000,com.example.app/.UploadJob,10045`,
+		// Covered by the top app, then idle across the summary boundary.
+		`Wifi radio,bool,90000,110000,true,`,
+		`Top app,service,80000,95000,com.example.app,10045`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 100000},
+		{StartTimeMs: 100000, EndTimeMs: 200000},
+	}
+	if errs := AddIdleRadioSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddIdleRadioSummaries generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		mobile, wifi Dist
+	}{
+		{
+			mobile: Dist{Num: 2, TotalDuration: 7 * time.Second, MaxDuration: 5 * time.Second},
+			wifi:   Dist{Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+		},
+		{
+			wifi: Dist{Num: 1, TotalDuration: 10 * time.Second, MaxDuration: 10 * time.Second},
+		},
+	}
+	for i, w := range want {
+		if got := summaries[i].IdleMobileRadioSummary; got != w.mobile {
+			t.Errorf("Summary %d IdleMobileRadioSummary = %v, want %v", i, got, w.mobile)
+		}
+		if got := summaries[i].IdleWifiRadioSummary; got != w.wifi {
+			t.Errorf("Summary %d IdleWifiRadioSummary = %v, want %v", i, got, w.wifi)
+		}
+	}
+}
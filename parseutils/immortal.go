@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// immortal.go flags the "immortal" wakelocks, held across the whole report. A wakelock that never
+// lets the device suspend makes most of the other analysis moot, so it's surfaced first rather than
+// left for the reader to spot in the wakelock tables.
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+)
+
+// ImmortalUnpluggedPercent is the percentage of the unplugged time above which a wakelock held is
+// flagged as immortal.
+const ImmortalUnpluggedPercent = 95.0
+
+// ImmortalWakelock is a wakelock held across the report.
+type ImmortalWakelock struct {
+	Tag string
+	// UID is the app UID the wakelock is attributed to, empty if unknown.
+	UID string
+	// HeldAtStart and HeldAtEnd are whether the wakelock was held at the start and the end of the report.
+	HeldAtStart, HeldAtEnd bool
+	// Held is the total time the wakelock was held.
+	Held time.Duration
+	// Unplugged is the total unplugged time of the report, and UnpluggedHeld the part of it the
+	// wakelock was held for.
+	Unplugged, UnpluggedHeld time.Duration
+	// Holds are the merged periods the wakelock was held, sorted by start time.
+	Holds []csv.Interval
+	// Reasons are the reasons the wakelock was flagged, printed in the default language.
+	Reasons []messages.Message
+}
+
+// UnpluggedPercent returns the percentage of the unplugged time the wakelock was held, or 0 if the
+// device was never unplugged.
+func (w ImmortalWakelock) UnpluggedPercent() float64 {
+	if w.Unplugged <= 0 {
+		return 0
+	}
+	return 100 * float64(w.UnpluggedHeld) / float64(w.Unplugged)
+}
+
+// byUnpluggedHeld sorts immortal wakelocks in descending order of the unplugged time held, then by
+// tag and UID.
+type byUnpluggedHeld []ImmortalWakelock
+
+func (a byUnpluggedHeld) Len() int      { return len(a) }
+func (a byUnpluggedHeld) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUnpluggedHeld) Less(i, j int) bool {
+	if a[i].UnpluggedHeld != a[j].UnpluggedHeld {
+		return a[i].UnpluggedHeld > a[j].UnpluggedHeld
+	}
+	if a[i].Tag != a[j].Tag {
+		return a[i].Tag < a[j].Tag
+	}
+	return a[i].UID < a[j].UID
+}
+
+// ImmortalWakelocks returns the wakelocks held at both the start and the end of the report, or for
+// more than ImmortalUnpluggedPercent of its unplugged time, from the battery history CSV generated by
+// AnalyzeHistory. The holds are taken from the full wakelock history if present, otherwise from the
+// first wakelock holders, along with the long wakelocks. The report range is taken from the battery
+// level events.
+func ImmortalWakelocks(csvInput string) ([]ImmortalWakelock, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{BatteryLevel, Plugged, fullWakelockMetric, partialWakelockMetric, LongWakelocks})
+	levels := es[BatteryLevel]
+	if len(levels) == 0 {
+		return nil, errs
+	}
+	startMs, endMs := int64(math.MaxInt64), historyEnd(levels)
+	for _, e := range levels {
+		if e.Start < startMs {
+			startMs = e.Start
+		}
+	}
+	var plugged []csv.Event
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	unpluggedIntervals := unplugged(plugged, startMs, endMs)
+	var unpluggedMs int64
+	for _, u := range unpluggedIntervals {
+		unpluggedMs += u.End - u.Start
+	}
+
+	wakelocks := es[fullWakelockMetric]
+	if len(wakelocks) == 0 {
+		wakelocks = es[partialWakelockMetric]
+	}
+	type key struct {
+		tag, uid string
+	}
+	holds := make(map[key][]csv.Event)
+	for _, w := range append(wakelocks, es[LongWakelocks]...) {
+		k := key{strings.Trim(w.Value, `"`), w.Opt}
+		holds[k] = append(holds[k], w)
+	}
+
+	var res []ImmortalWakelock
+	for k, hs := range holds {
+		hs = csv.MergeEvents(hs)
+		w := ImmortalWakelock{
+			Tag:           k.tag,
+			UID:           k.uid,
+			HeldAtStart:   hs[0].Start <= startMs,
+			HeldAtEnd:     hs[len(hs)-1].End >= endMs,
+			Unplugged:     time.Duration(unpluggedMs) * time.Millisecond,
+			UnpluggedHeld: time.Duration(coveredMs(hs, unpluggedIntervals)) * time.Millisecond,
+		}
+		for _, h := range hs {
+			w.Holds = append(w.Holds, h.Interval())
+			w.Held += time.Duration(h.End-h.Start) * time.Millisecond
+		}
+		if w.HeldAtStart && w.HeldAtEnd {
+			w.Reasons = append(w.Reasons, messages.New(messages.ImmortalWakelockStartEnd))
+		}
+		if p := w.UnpluggedPercent(); p > ImmortalUnpluggedPercent {
+			w.Reasons = append(w.Reasons, messages.New(messages.ImmortalWakelockUnplugged, p, w.Unplugged, ImmortalUnpluggedPercent))
+		}
+		if len(w.Reasons) > 0 {
+			res = append(res, w)
+		}
+	}
+	sort.Sort(byUnpluggedHeld(res))
+	return res, errs
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/messages"
+)
+
+// TestImmortalWakelocks tests the detection of the wakelocks held across the report.
+func TestImmortalWakelocks(t *testing.T) {
+	hour := int64(time.Hour / time.Millisecond)
+	tests := []struct {
+		desc  string
+		input []string
+		want  []ImmortalWakelock
+	}{
+		{
+			desc: "No history",
+			input: []string{
+				`Partial wakelock,service,0,3600000,"*alarm*",1000`,
+			},
+		},
+		{
+			desc: "Immortal wakelocks",
+			input: []string{
+				`Battery Level,int,0,18000000,100,`,
+				`Battery Level,int,18000000,36000000,99,`,
+				`Plugged,bool,0,3600000,true,`,
+				// Held throughout, in two touching parts.
+				`Partial wakelock,service,0,18000000,"*alarm*",1000`,
+				`Partial wakelock,service,18000000,36000000,"*alarm*",1000`,
+				// Held for 8.8 of the 9 unplugged hours.
+				`Long Wakelocks,service,3600000,35280000,gps,10045`,
+				// Held at the start and at the end only.
+				`Long Wakelocks,service,0,1800000,*sync*/com.example,10030`,
+				`Long Wakelocks,service,34200000,36000000,*sync*/com.example,10030`,
+				// A regular wakelock.
+				`Partial wakelock,service,7200000,10800000,"short",10010`,
+			},
+			want: []ImmortalWakelock{
+				{
+					Tag:           "*alarm*",
+					UID:           "1000",
+					HeldAtStart:   true,
+					HeldAtEnd:     true,
+					Held:          10 * time.Hour,
+					Unplugged:     9 * time.Hour,
+					UnpluggedHeld: 9 * time.Hour,
+					Holds:         []csv.Interval{{Start: 0, End: 10 * hour}},
+					Reasons: []messages.Message{
+						messages.New(messages.ImmortalWakelockStartEnd),
+						messages.New(messages.ImmortalWakelockUnplugged, 100.0, 9*time.Hour, ImmortalUnpluggedPercent),
+					},
+				},
+				{
+					Tag:           "gps",
+					UID:           "10045",
+					Held:          8*time.Hour + 48*time.Minute,
+					Unplugged:     9 * time.Hour,
+					UnpluggedHeld: 8*time.Hour + 48*time.Minute,
+					Holds:         []csv.Interval{{Start: hour, End: 35280000}},
+					Reasons: []messages.Message{
+						messages.New(messages.ImmortalWakelockUnplugged, 100*float64(8*time.Hour+48*time.Minute)/float64(9*time.Hour), 9*time.Hour, ImmortalUnpluggedPercent),
+					},
+				},
+				{
+					Tag:           "*sync*/com.example",
+					UID:           "10030",
+					HeldAtStart:   true,
+					HeldAtEnd:     true,
+					Held:          time.Hour,
+					Unplugged:     9 * time.Hour,
+					UnpluggedHeld: 30 * time.Minute,
+					Holds:         []csv.Interval{{Start: 0, End: 1800000}, {Start: 34200000, End: 10 * hour}},
+					Reasons:       []messages.Message{messages.New(messages.ImmortalWakelockStartEnd)},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(append([]string{csv.FileHeader}, test.input...), "\n")
+		got, errs := ImmortalWakelocks(input)
+		if len(errs) > 0 {
+			t.Errorf("%s: ImmortalWakelocks(%s) generated unexpected errors: %v", test.desc, input, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ImmortalWakelocks(%s)\n got: %+v\n want: %+v", test.desc, input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// levelsteps.go converts the summaries generated with FormatBatteryLevel to a map keyed by level
+// step, for charting the time spent per battery percent.
+
+import (
+	"fmt"
+	"time"
+)
+
+// LevelStep aggregates the summaries of a single battery level drop, e.g. 100->99.
+type LevelStep struct {
+	// Count is the number of times the level drop was seen, e.g. more than once if the device was charged in between.
+	Count int `json:"count"`
+	// DurationMs is the total time spent before the level dropped.
+	DurationMs int64 `json:"durationMs"`
+	// DominantApp is the wakelock holder attributed the most CPU running time over the step, empty if none.
+	DominantApp           string `json:"dominantApp"`
+	DominantAppDurationMs int64  `json:"dominantAppDurationMs"`
+	// Wakeups is the number of times the CPU started running.
+	Wakeups int32 `json:"wakeups"`
+	// ScreenOnMs is the total time the screen was on.
+	ScreenOnMs int64 `json:"screenOnMs"`
+}
+
+// LevelStepKey returns the key of a level drop in the map returned by BatteryLevelSummariesToSteps, e.g. "100->99".
+func LevelStepKey(from, to int) string {
+	return fmt.Sprintf("%d->%d", from, to)
+}
+
+// BatteryLevelSummariesToSteps returns the summaries generated with FormatBatteryLevel keyed by
+// level drop, e.g. "100->99". Summaries without a level drop, or with untimed durations, are skipped.
+// The result can be marshalled to JSON.
+func BatteryLevelSummariesToSteps(summaries []ActivitySummary) map[string]LevelStep {
+	steps := make(map[string]LevelStep)
+	attributed := make(map[string]map[string]time.Duration)
+	for _, s := range summaries {
+		if s.Untimed || s.InitialBatteryLevel <= s.FinalBatteryLevel {
+			continue
+		}
+		k := LevelStepKey(s.InitialBatteryLevel, s.FinalBatteryLevel)
+		st := steps[k]
+		st.Count++
+		st.DurationMs += s.EndTimeMs - s.StartTimeMs
+		st.Wakeups += s.CPURunningSummary.Num
+		st.ScreenOnMs += int64(s.ScreenOnSummary.TotalDuration / time.Millisecond)
+		steps[k] = st
+
+		if attributed[k] == nil {
+			attributed[k] = make(map[string]time.Duration)
+		}
+		for app, d := range s.AttributedCPURunningSummary {
+			attributed[k][app] += d.TotalDuration
+		}
+	}
+	for k, apps := range attributed {
+		st := steps[k]
+		var max time.Duration
+		for app, d := range apps {
+			// Ties are broken by name so the result is deterministic.
+			if d > max || (d == max && d > 0 && app < st.DominantApp) {
+				max = d
+				st.DominantApp = app
+			}
+		}
+		st.DominantAppDurationMs = int64(max / time.Millisecond)
+		steps[k] = st
+	}
+	return steps
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestBatteryLevelSummariesToSteps tests the aggregation of batteryLevel summaries by level drop.
+func TestBatteryLevelSummariesToSteps(t *testing.T) {
+	summaries := []ActivitySummary{
+		{
+			StartTimeMs:         0,
+			EndTimeMs:           60000,
+			InitialBatteryLevel: 100,
+			FinalBatteryLevel:   99,
+			CPURunningSummary:   Dist{Num: 3},
+			ScreenOnSummary:     Dist{TotalDuration: 10 * time.Second},
+			AttributedCPURunningSummary: map[string]Dist{
+				"com.google.android.gms": {TotalDuration: 5 * time.Second},
+				"com.google.android.gm":  {TotalDuration: 2 * time.Second},
+			},
+		},
+		{
+			StartTimeMs:         60000,
+			EndTimeMs:           180000,
+			InitialBatteryLevel: 99,
+			FinalBatteryLevel:   98,
+			CPURunningSummary:   Dist{Num: 1},
+		},
+		// Charging in between, so no level drop.
+		{
+			StartTimeMs:         180000,
+			EndTimeMs:           240000,
+			InitialBatteryLevel: 98,
+			FinalBatteryLevel:   100,
+		},
+		{
+			StartTimeMs:         240000,
+			EndTimeMs:           270000,
+			InitialBatteryLevel: 100,
+			FinalBatteryLevel:   99,
+			CPURunningSummary:   Dist{Num: 2},
+			AttributedCPURunningSummary: map[string]Dist{
+				"com.google.android.gm": {TotalDuration: 4 * time.Second},
+			},
+		},
+	}
+	want := map[string]LevelStep{
+		"100->99": {
+			Count:                 2,
+			DurationMs:            90000,
+			DominantApp:           "com.google.android.gm",
+			DominantAppDurationMs: 6000,
+			Wakeups:               5,
+			ScreenOnMs:            10000,
+		},
+		"99->98": {
+			Count:      1,
+			DurationMs: 120000,
+			Wakeups:    1,
+		},
+	}
+	if got := BatteryLevelSummariesToSteps(summaries); !reflect.DeepEqual(got, want) {
+		t.Errorf("BatteryLevelSummariesToSteps(%v)\n got: %v\n want: %v", summaries, got, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// maintenance.go finds the Doze maintenance windows, where apps are allowed to run while the device is idle,
+// and summarizes the app activity inside and outside of them.
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// MaintenanceWindowMetric is the battery history CSV metric for Doze maintenance windows.
+	MaintenanceWindowMetric = "Doze maintenance window"
+
+	// doze is the battery history CSV metric for the Doze mode.
+	doze = "Doze"
+
+	// maxMaintenanceWindow is the longest a Doze off period between idle periods can be to be
+	// considered a maintenance window. Longer periods are likely the user briefly using the device.
+	maxMaintenanceWindow = 10 * time.Minute
+)
+
+// maintenanceMetrics are the battery history CSV metrics of app activity summarized by MaintenanceWindowUsage.
+var maintenanceMetrics = []string{"Wakelock_in", "JobScheduler", "SyncManager"}
+
+// isIdle returns whether the Doze mode value is an idle mode.
+func isIdle(mode string) bool {
+	return mode == "light" || mode == "full"
+}
+
+// MaintenanceWindows returns the Doze maintenance windows, the periods Doze was off between two idle
+// periods, sorted by start time.
+func MaintenanceWindows(dozeEvents []csv.Event) []csv.Event {
+	es := append([]csv.Event(nil), dozeEvents...)
+	sort.Sort(sortByStart(es))
+	maxMs := int64(maxMaintenanceWindow / time.Millisecond)
+	var windows []csv.Event
+	for i := 1; i+1 < len(es); i++ {
+		prev, e, next := es[i-1], es[i], es[i+1]
+		if e.Value != "off" || e.End-e.Start > maxMs {
+			continue
+		}
+		if isIdle(prev.Value) && prev.End == e.Start && isIdle(next.Value) && next.Start == e.End {
+			windows = append(windows, csv.Event{Type: "bool", Start: e.Start, End: e.End, Value: "true"})
+		}
+	}
+	return windows
+}
+
+// MaintenanceUsage is the activity of a single metric of an app inside and outside of the Doze maintenance windows.
+type MaintenanceUsage struct {
+	// UID is the app ID the events were logged with.
+	UID    string
+	Metric string
+	// InsideCount and OutsideCount are the number of events overlapping, and not overlapping any window.
+	InsideCount, OutsideCount int
+	// Inside and Outside are the time the app had an event active inside and outside of the windows.
+	Inside, Outside time.Duration
+	// Utilization is the percentage of the total maintenance window time the app had an event active.
+	Utilization float64
+}
+
+// MaintenanceSummary is the Doze maintenance windows of a report, and the app activity during them.
+type MaintenanceSummary struct {
+	Windows []csv.Event
+	// Total is the total duration of the windows.
+	Total time.Duration
+	// Usage is sorted by descending time inside the windows.
+	Usage []MaintenanceUsage
+}
+
+// byInside sorts usage in descending order of time inside the maintenance windows, then by UID and metric.
+type byInside []MaintenanceUsage
+
+func (a byInside) Len() int      { return len(a) }
+func (a byInside) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byInside) Less(i, j int) bool {
+	if a[i].Inside != a[j].Inside {
+		return a[i].Inside > a[j].Inside
+	}
+	if a[i].UID != a[j].UID {
+		return a[i].UID < a[j].UID
+	}
+	return a[i].Metric < a[j].Metric
+}
+
+// MaintenanceWindowUsage finds the Doze maintenance windows in the battery history CSV generated by
+// AnalyzeHistory, and computes the wakelocks, jobs and syncs of each app inside and outside of them.
+// Apps with no activity inside the windows are included so they can be compared.
+func MaintenanceWindowUsage(csvInput string) (*MaintenanceSummary, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{doze}, maintenanceMetrics...))
+	windows := MaintenanceWindows(es[doze])
+	s := &MaintenanceSummary{Windows: windows}
+	var totalMs int64
+	for _, w := range windows {
+		totalMs += w.End - w.Start
+	}
+	s.Total = time.Duration(totalMs) * time.Millisecond
+
+	for _, m := range maintenanceMetrics {
+		byUID := make(map[string][]csv.Event)
+		var uids []string
+		for _, e := range es[m] {
+			if _, ok := byUID[e.Opt]; !ok {
+				uids = append(uids, e.Opt)
+			}
+			byUID[e.Opt] = append(byUID[e.Opt], e)
+		}
+		for _, uid := range uids {
+			u := MaintenanceUsage{UID: uid, Metric: m}
+			for _, e := range byUID[uid] {
+				if csv.Overlap(windows, e.Start, e.End) > 0 || inWindow(windows, e.Start) {
+					u.InsideCount++
+				} else {
+					u.OutsideCount++
+				}
+			}
+			// MergeEvents reorders the events, so is given a copy.
+			merged := csv.MergeEvents(append([]csv.Event(nil), byUID[uid]...))
+			var activeMs, insideMs int64
+			for _, e := range merged {
+				activeMs += e.End - e.Start
+				insideMs += csv.Overlap(windows, e.Start, e.End)
+			}
+			u.Inside = time.Duration(insideMs) * time.Millisecond
+			u.Outside = time.Duration(activeMs-insideMs) * time.Millisecond
+			if totalMs > 0 {
+				u.Utilization = 100 * float64(insideMs) / float64(totalMs)
+			}
+			s.Usage = append(s.Usage, u)
+		}
+	}
+	sort.Sort(byInside(s.Usage))
+	return s, errs
+}
+
+// inWindow returns whether the instant is within one of the half-open windows, for events with no
+// duration.
+func inWindow(windows []csv.Event, ms int64) bool {
+	for _, w := range windows {
+		if w.Interval().Contains(ms) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMaintenanceWindows writes a MaintenanceWindowMetric row for each Doze maintenance window found in
+// the battery history CSV, so they can be shown on the timeline.
+func WriteMaintenanceWindows(w io.Writer, csvInput string) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{doze})
+	csvState := csv.NewState(w, false)
+	for _, e := range MaintenanceWindows(es[doze]) {
+		csvState.PrintEvent(MaintenanceWindowMetric, e)
+	}
+	return errs
+}
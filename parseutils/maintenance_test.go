@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestMaintenanceWindowUsage tests the detection of Doze maintenance windows and the app activity during them.
+func TestMaintenanceWindowUsage(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		"Doze,string,0,100000,light,",
+		"Doze,string,100000,160000,off,", // Maintenance window.
+		"Doze,string,160000,400000,light,",
+		"Doze,string,400000,1400000,off,", // Too long to be a maintenance window.
+		"Doze,string,1400000,2000000,full,",
+		"Doze,string,2000000,2030000,off,", // Maintenance window.
+		"Doze,string,2030000,3000000,full,",
+		"Doze,string,3000000,3010000,off,", // Not followed by idle.
+		"JobScheduler,service,110000,130000,com.example.app/.SyncJob,10010",
+		"JobScheduler,service,500000,510000,com.example.app/.SyncJob,10010",
+		"Wakelock_in,service,150000,2010000,*alarm*,10020",
+		"SyncManager,service,600000,700000,com.example.mail/com.example,10030",
+	}, "\n")
+
+	wantWindows := []csv.Event{
+		{Type: "bool", Start: 100000, End: 160000, Value: "true"},
+		{Type: "bool", Start: 2000000, End: 2030000, Value: "true"},
+	}
+	wantUsage := []MaintenanceUsage{
+		{
+			UID:          "10010",
+			Metric:       "JobScheduler",
+			InsideCount:  1,
+			OutsideCount: 1,
+			Inside:       20 * time.Second,
+			Outside:      10 * time.Second,
+			Utilization:  100 * 20000.0 / 90000,
+		},
+		{
+			UID:         "10020",
+			Metric:      "Wakelock_in",
+			InsideCount: 1,
+			Inside:      20 * time.Second,
+			Outside:     1840 * time.Second,
+			Utilization: 100 * 20000.0 / 90000,
+		},
+		{
+			UID:          "10030",
+			Metric:       "SyncManager",
+			OutsideCount: 1,
+			Outside:      100 * time.Second,
+		},
+	}
+
+	s, errs := MaintenanceWindowUsage(input)
+	if len(errs) > 0 {
+		t.Fatalf("MaintenanceWindowUsage(%v) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(s.Windows, wantWindows) {
+		t.Errorf("MaintenanceWindowUsage(%v).Windows\n got: %v\n want: %v", input, s.Windows, wantWindows)
+	}
+	if want := 90 * time.Second; s.Total != want {
+		t.Errorf("MaintenanceWindowUsage(%v).Total = %v, want %v", input, s.Total, want)
+	}
+	if !reflect.DeepEqual(s.Usage, wantUsage) {
+		t.Errorf("MaintenanceWindowUsage(%v).Usage\n got: %v\n want: %v", input, s.Usage, wantUsage)
+	}
+
+	var b bytes.Buffer
+	if errs := WriteMaintenanceWindows(&b, input); len(errs) > 0 {
+		t.Fatalf("WriteMaintenanceWindows(%v) generated unexpected errors: %v", input, errs)
+	}
+	want := strings.Join([]string{
+		"Doze maintenance window,bool,100000,160000,true,",
+		"Doze maintenance window,bool,2000000,2030000,true,",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("WriteMaintenanceWindows(%v)\n got: %q\n want: %q", input, got, want)
+	}
+}
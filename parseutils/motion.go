@@ -0,0 +1,151 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// motion.go splits the unplugged time of each summary by the coarse motion state of the device, so
+// that drain while carried around, e.g. in a pocket while commuting, can be told apart from drain
+// while left on a desk. The battery history has no location, so the state is guessed from the
+// significant motion and device active events.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// The MotionStateSummary keys.
+const (
+	// MotionStationary is the unplugged time outside of any motion period.
+	MotionStationary = "Stationary"
+	// MotionMoving is the unplugged time in motion periods shorter than commuteDuration.
+	MotionMoving = "Moving"
+	// MotionCommuting is the unplugged time in motion periods of at least commuteDuration.
+	MotionCommuting = "Commuting"
+)
+
+const (
+	significantMotion = "Significant motion"
+	deviceActive      = "Device active"
+
+	// motionWindow is how long the device is assumed to keep moving after a significant motion.
+	motionWindow = 5 * time.Minute
+	// commuteDuration is the shortest motion period counted as commuting rather than moving around.
+	commuteDuration = 20 * time.Minute
+)
+
+// motionPeriods returns the periods the device was moving, sorted by start time and non overlapping,
+// from the sorted significant motion and device active times. Each significant motion starts or extends
+// a period to motionWindow after it. The significant motion sensor is one shot and only re-armed once
+// the device goes inactive, so device activity during a period extends it in the same way.
+func motionPeriods(motion, active []int64) []csv.Event {
+	window := int64(motionWindow / time.Millisecond)
+	var periods []csv.Event
+	i, j := 0, 0
+	for i < len(motion) || j < len(active) {
+		isMotion := j == len(active) || (i < len(motion) && motion[i] <= active[j])
+		var t int64
+		if isMotion {
+			t = motion[i]
+			i++
+		} else {
+			t = active[j]
+			j++
+		}
+		n := len(periods)
+		inPeriod := n > 0 && t <= periods[n-1].End
+		switch {
+		case inPeriod && t+window > periods[n-1].End:
+			periods[n-1].End = t + window
+		case !inPeriod && isMotion:
+			periods = append(periods, csv.Event{Start: t, End: t + window})
+		}
+	}
+	return periods
+}
+
+// instantTimes returns the sorted start times of the events.
+func instantTimes(es []csv.Event) []int64 {
+	var ts []int64
+	for _, e := range es {
+		ts = append(ts, e.Start)
+	}
+	sort.Sort(int64s(ts))
+	return ts
+}
+
+// AddMotionSummaries populates the MotionStateSummary and MotionStateLevelDrop of each summary from the
+// battery history CSV generated by AnalyzeHistory, keyed by MotionStationary, MotionMoving and
+// MotionCommuting. Only the unplugged time is split, as the drain while charging isn't comparable.
+func AddMotionSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{significantMotion, deviceActive, Plugged, BatteryLevel})
+	all := motionPeriods(instantTimes(es[significantMotion]), instantTimes(es[deviceActive]))
+	periods := make(map[string][]csv.Event)
+	for _, p := range all {
+		k := MotionMoving
+		if time.Duration(p.End-p.Start)*time.Millisecond >= commuteDuration {
+			k = MotionCommuting
+		}
+		periods[k] = append(periods[k], p)
+	}
+	var plugged []csv.Event
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	sort.Sort(sortByStart(plugged))
+	drops := levelDrops(es[BatteryLevel])
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.MotionStateSummary = make(map[string]Dist)
+		s.MotionStateLevelDrop = make(map[string]int)
+		unpl := unplugged(plugged, s.StartTimeMs, s.EndTimeMs)
+		states := map[string][]csv.Event{MotionStationary: idleRadio(unpl, all)}
+		for k, ps := range periods {
+			for _, u := range unpl {
+				for _, p := range ps {
+					if d := csv.Overlap([]csv.Event{p}, u.Start, u.End); d > 0 {
+						states[k] = append(states[k], p)
+						dist := s.MotionStateSummary[k]
+						dist.addDuration(time.Duration(d) * time.Millisecond)
+						s.MotionStateSummary[k] = dist
+					}
+				}
+			}
+		}
+		for _, e := range states[MotionStationary] {
+			dist := s.MotionStateSummary[MotionStationary]
+			dist.addDuration(time.Duration(e.End-e.Start) * time.Millisecond)
+			s.MotionStateSummary[MotionStationary] = dist
+		}
+		for _, d := range drops {
+			if d.Start <= s.StartTimeMs || d.Start > s.EndTimeMs || !inWindow(unpl, d.Start) {
+				continue
+			}
+			n, _ := strconv.Atoi(d.Value)
+			for _, k := range []string{MotionCommuting, MotionMoving, MotionStationary} {
+				if inWindow(states[k], d.Start) {
+					s.MotionStateLevelDrop[k] += n
+					break
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddMotionSummaries tests the breakdown of the unplugged time of a summary by motion state.
+func TestAddMotionSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,300000,91,`,
+		`Battery Level,int,300000,700000,90,`,
+		`Battery Level,int,700000,1000000,89,`,
+		`Battery Level,int,1000000,2000000,88,`,
+		`Battery Level,int,2000000,2500000,86,`,
+		`Battery Level,int,2500000,3600000,85,`,
+		// The level drop at 300s is while plugged in, so isn't counted.
+		`Plugged,bool,0,600000,true,`,
+		// Device activity outside of any motion period doesn't start one.
+		`Device active,bool,100000,100000,true,`,
+		// A short walk.
+		`Significant motion,bool,900000,900000,true,`,
+		// A commute, kept going by the device activity.
+		`Significant motion,bool,1800000,1800000,true,`,
+		`Device active,bool,2040000,2040000,true,`,
+		`Significant motion,bool,2300000,2300000,true,`,
+		`Device active,bool,2500000,2500000,true,`,
+		`Significant motion,bool,2700000,2700000,true,`,
+		`Device active,bool,3300000,3300000,true,`,
+	}, "\n")
+	summaries := []ActivitySummary{{StartTimeMs: 0, EndTimeMs: 3600000}}
+	if errs := AddMotionSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddMotionSummaries generated unexpected errors: %v", errs)
+	}
+	wantStates := map[string]Dist{
+		MotionStationary: {Num: 3, TotalDuration: 25 * time.Minute, MaxDuration: 10 * time.Minute},
+		MotionMoving:     {Num: 1, TotalDuration: 5 * time.Minute, MaxDuration: 5 * time.Minute},
+		MotionCommuting:  {Num: 1, TotalDuration: 20 * time.Minute, MaxDuration: 20 * time.Minute},
+	}
+	wantDrops := map[string]int{MotionStationary: 1, MotionMoving: 1, MotionCommuting: 3}
+	s := summaries[0]
+	if !reflect.DeepEqual(s.MotionStateSummary, wantStates) {
+		t.Errorf("MotionStateSummary\n got: %v\n want: %v", s.MotionStateSummary, wantStates)
+	}
+	if !reflect.DeepEqual(s.MotionStateLevelDrop, wantDrops) {
+		t.Errorf("MotionStateLevelDrop\n got: %v\n want: %v", s.MotionStateLevelDrop, wantDrops)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// network.go reconstructs the default network from the per type network connectivity events in
+// the generated battery history CSV. When several networks are connected at the same time, e.g.
+// wifi and mobile, only the default network carries the app traffic.
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// DefaultNetworkMetric is the CSV description of the reconstructed default network.
+	DefaultNetworkMetric = "Default network"
+	// NoConnectivityMetric is the CSV description of the periods without any connected network.
+	NoConnectivityMetric = "No connectivity"
+
+	// networkConnectivity is the battery history CSV metric for the Ecn events.
+	networkConnectivity = "Network connectivity"
+)
+
+// defaultNetworkRank is the preference of the network types that can become the default network,
+// lowest first. Special purpose networks, such as TYPE_MOBILE_MMS, and virtual networks running on
+// top of another network, such as TYPE_VPN, never replace the default network.
+var defaultNetworkRank = map[string]int{
+	"TYPE_ETHERNET":  0,
+	"TYPE_WIFI":      1,
+	"TYPE_WIMAX":     2,
+	"TYPE_BLUETOOTH": 3,
+	"TYPE_MOBILE":    4,
+}
+
+// connectedNetworks returns the periods the network types that can become the default network were
+// connected, from the network connectivity events, e.g. `TYPE_WIFI:"CONNECTED"`. Suspended networks
+// can't pass traffic, so aren't included.
+func connectedNetworks(es []csv.Event) []csv.Event {
+	var connected []csv.Event
+	for _, e := range es {
+		parts := strings.SplitN(e.Value, ":", 2)
+		if len(parts) != 2 || parts[1] != ecnConnected {
+			continue
+		}
+		if _, ok := defaultNetworkRank[parts[0]]; !ok {
+			continue
+		}
+		e.Value = parts[0]
+		connected = append(connected, e)
+	}
+	sort.Sort(sortByStart(connected))
+	return connected
+}
+
+// defaultNetworks returns the periods each network was the default network, and the periods without
+// any connected network, between the first and last network connectivity events. When several networks
+// are connected, the most preferred one is the default, or the most recently connected one for a tie.
+func defaultNetworks(es []csv.Event) ([]csv.Event, []csv.Event) {
+	if len(es) == 0 {
+		return nil, nil
+	}
+	start, end := es[0].Start, es[0].End
+	for _, e := range es {
+		if e.Start < start {
+			start = e.Start
+		}
+		if e.End > end {
+			end = e.End
+		}
+	}
+	connected := connectedNetworks(es)
+	times := []int64{start, end}
+	for _, c := range connected {
+		times = append(times, c.Start, c.End)
+	}
+	sort.Sort(int64s(times))
+
+	var defaults, none []csv.Event
+	// add appends the period to the events, extending the last event if it's the same network.
+	add := func(events []csv.Event, value string, start, end int64) []csv.Event {
+		if n := len(events); n > 0 && events[n-1].Value == value && events[n-1].End == start {
+			events[n-1].End = end
+			return events
+		}
+		return append(events, csv.Event{Type: "string", Start: start, End: end, Value: value})
+	}
+	for i := 0; i+1 < len(times); i++ {
+		from, to := times[i], times[i+1]
+		if from == to {
+			continue
+		}
+		best := -1
+		for j, c := range connected {
+			if c.Start > from {
+				// Connected events are sorted by start, so none of the remaining cover the period.
+				break
+			}
+			if c.End < to {
+				continue
+			}
+			if best == -1 || defaultNetworkRank[c.Value] <= defaultNetworkRank[connected[best].Value] {
+				best = j
+			}
+		}
+		if best == -1 {
+			none = add(none, "true", from, to)
+			continue
+		}
+		defaults = add(defaults, connected[best].Value, from, to)
+	}
+	for i := range none {
+		none[i].Type = "bool"
+	}
+	return defaults, none
+}
+
+// WriteDefaultNetwork writes the reconstructed default network and the periods without connectivity
+// for the battery history CSV generated by AnalyzeHistory, as DefaultNetworkMetric and
+// NoConnectivityMetric CSV events.
+func WriteDefaultNetwork(w io.Writer, csvInput string) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{networkConnectivity})
+	defaults, none := defaultNetworks(es[networkConnectivity])
+	csvState := csv.NewState(w, false)
+	for _, e := range defaults {
+		csvState.Print(DefaultNetworkMetric, e.Type, e.Start, e.End, e.Value, "")
+	}
+	for _, e := range none {
+		csvState.Print(NoConnectivityMetric, e.Type, e.Start, e.End, e.Value, "")
+	}
+	return errs
+}
+
+// AddNetworkSummaries populates the DefaultNetworkSummary, NetworkSwitches and NoConnectivitySummary
+// of each summary from the battery history CSV generated by AnalyzeHistory.
+func AddNetworkSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{networkConnectivity})
+	defaults, none := defaultNetworks(es[networkConnectivity])
+	for i := range summaries {
+		s := &summaries[i]
+		s.DefaultNetworkSummary = make(map[string]Dist)
+		s.NetworkSwitches = 0
+		s.NoConnectivitySummary = Dist{}
+		for j, e := range defaults {
+			if d := csv.Overlap([]csv.Event{e}, s.StartTimeMs, s.EndTimeMs); d > 0 {
+				dist := s.DefaultNetworkSummary[e.Value]
+				dist.addDuration(time.Duration(d) * time.Millisecond)
+				s.DefaultNetworkSummary[e.Value] = dist
+			}
+			// A period without connectivity in between still counts as a switch, as switching
+			// from wifi to mobile usually has a short gap.
+			if j > 0 && defaults[j-1].Value != e.Value && e.Start > s.StartTimeMs && e.Start <= s.EndTimeMs {
+				s.NetworkSwitches++
+			}
+		}
+		for _, e := range none {
+			if d := csv.Overlap([]csv.Event{e}, s.StartTimeMs, s.EndTimeMs); d > 0 {
+				s.NoConnectivitySummary.addDuration(time.Duration(d) * time.Millisecond)
+			}
+		}
+	}
+	return errs
+}
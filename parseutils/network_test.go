@@ -0,0 +1,97 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestDefaultNetwork tests the reconstruction of the default network and the network summaries.
+func TestDefaultNetwork(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Network connectivity,service,1000,10000,"TYPE_MOBILE:""CONNECTED""",`,
+		// Wifi is preferred over mobile while both are connected.
+		`Network connectivity,service,3000,6000,"TYPE_WIFI:""CONNECTED""",`,
+		// Special purpose networks never become the default network.
+		`Network connectivity,service,4000,5000,"TYPE_MOBILE_MMS:""CONNECTED""",`,
+		`Network connectivity,service,10000,12000,"TYPE_MOBILE:""SUSPENDED""",`,
+		`Network connectivity,service,13000,15000,"TYPE_WIFI:""CONNECTED""",`,
+	}, "\n")
+
+	var b bytes.Buffer
+	if errs := WriteDefaultNetwork(&b, input); len(errs) > 0 {
+		t.Fatalf("WriteDefaultNetwork generated unexpected errors: %v", errs)
+	}
+	want := strings.Join([]string{
+		"Default network,string,1000,3000,TYPE_MOBILE,",
+		"Default network,string,3000,6000,TYPE_WIFI,",
+		"Default network,string,6000,10000,TYPE_MOBILE,",
+		"Default network,string,13000,15000,TYPE_WIFI,",
+		"No connectivity,bool,10000,13000,true,",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("WriteDefaultNetwork(%v)\n got: %q\n want: %q", input, got, want)
+	}
+
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 8000},
+		{StartTimeMs: 8000, EndTimeMs: 20000},
+	}
+	if errs := AddNetworkSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddNetworkSummaries generated unexpected errors: %v", errs)
+	}
+	wantSummaries := []struct {
+		defaults map[string]Dist
+		switches int
+		none     Dist
+	}{
+		{
+			defaults: map[string]Dist{
+				"TYPE_MOBILE": {Num: 2, TotalDuration: 4 * time.Second, MaxDuration: 2 * time.Second},
+				"TYPE_WIFI":   {Num: 1, TotalDuration: 3 * time.Second, MaxDuration: 3 * time.Second},
+			},
+			switches: 2,
+		},
+		{
+			defaults: map[string]Dist{
+				"TYPE_MOBILE": {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+				"TYPE_WIFI":   {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+			},
+			// Mobile to wifi, through the period without connectivity.
+			switches: 1,
+			none:     Dist{Num: 1, TotalDuration: 3 * time.Second, MaxDuration: 3 * time.Second},
+		},
+	}
+	for i, w := range wantSummaries {
+		s := summaries[i]
+		if !reflect.DeepEqual(s.DefaultNetworkSummary, w.defaults) {
+			t.Errorf("Summary %d DefaultNetworkSummary\n got: %v\n want: %v", i, s.DefaultNetworkSummary, w.defaults)
+		}
+		if s.NetworkSwitches != w.switches {
+			t.Errorf("Summary %d NetworkSwitches = %d, want %d", i, s.NetworkSwitches, w.switches)
+		}
+		if s.NoConnectivitySummary != w.none {
+			t.Errorf("Summary %d NoConnectivitySummary = %v, want %v", i, s.NoConnectivitySummary, w.none)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package parseutils
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -50,18 +51,28 @@ const (
 	tsStringDefault       = "default"
 	unknownScreenOnReason = "unknown screen on reason"
 
+	// cancelCheckLines is the number of history lines analyzed between checks for cancellation.
+	cancelCheckLines = 1000
+
+	// workProfileSuffix is appended to package names of secondary user UIDs when profile names are enabled.
+	// Bug reports don't say which secondary users are managed profiles, so they are all assumed to be work profiles.
+	workProfileSuffix = " (work)"
+
 	// Strings related to Ecn broadcasts.
 	ecnConnected    = `"CONNECTED"`
 	ecnDisconnected = `"DISCONNECTED"`
 	ecnSuspended    = `"SUSPENDED"`
 
 	// Battery history event names.
-	BatteryLevel  = "Battery Level"
-	Charging      = "Charging on"
-	Foreground    = "Foreground process"
-	LongWakelocks = "Long Wakelocks"
-	Plugged       = "Plugged"
-	Top           = "Top app"
+	BatteryLevel   = "Battery Level"
+	Charging       = "Charging on"
+	CPURunningApp  = "CPU running app"
+	Foreground     = "Foreground process"
+	LongWakelocks  = "Long Wakelocks"
+	MobileRadioApp = "Mobile radio active app"
+	OnBody         = "On body"
+	Plugged        = "Plugged"
+	Top            = "Top app"
 )
 
 var (
@@ -242,6 +253,37 @@ func (s *DPST) initStart(curTime int64) {
 	}
 }
 
+// Battery history CSV metrics derived from the /proc/stat times of each battery level step.
+const (
+	DpstBusyMetric   = "CPU busy fraction"
+	DpstIOWaitMetric = "CPU iowait fraction"
+)
+
+// totalTime returns the total /proc/stat time of the step, idle included.
+func (s DPST) totalTime() time.Duration {
+	return s.StatUserTime + s.StatSystemTime + s.StatIOWaitTime + s.StatIrqTime + s.StatSoftIrqTime + s.StatIdlTime
+}
+
+// BusyFraction returns the fraction of the /proc/stat time of the step spent in user and system code,
+// or 0 if no time was recorded.
+func (s DPST) BusyFraction() float64 {
+	t := s.totalTime()
+	if t <= 0 {
+		return 0
+	}
+	return float64(s.StatUserTime+s.StatSystemTime) / float64(t)
+}
+
+// IOWaitFraction returns the fraction of the /proc/stat time of the step spent waiting for IO,
+// or 0 if no time was recorded.
+func (s DPST) IOWaitFraction() float64 {
+	t := s.totalTime()
+	if t <= 0 {
+		return 0
+	}
+	return float64(s.StatIOWaitTime) / float64(t)
+}
+
 // Voter represents a voter for one of the low power states.
 type Voter struct {
 	// Name of the voter.
@@ -376,17 +418,19 @@ func calTotalSync(state *DeviceState) Dist {
 	return d
 }
 
-// addSummaryEntry adds an event spanning a time interval from suid.Start to curTime into summary map.
-func (s *ServiceUID) addSummaryEntry(curTime int64, suid *ServiceUID, summary map[string]Dist) {
+// addSummaryEntry adds an event spanning a time interval from suid.Start to curTime into summary map,
+// and into the per app stats.
+func (s *ServiceUID) addSummaryEntry(curTime int64, suid *ServiceUID, summary map[string]Dist, app appStat) {
 	d := summary[s.Service]
 	if duration := time.Duration(curTime-suid.Start) * time.Millisecond; duration > 0 {
 		d.addDuration(duration)
 		summary[s.Service] = d
+		app.add(s, duration)
 	}
 }
 
 // Counts on negative transitions
-func (s *ServiceUID) assign(curTime int64, summaryActive, logEvent bool, summaryStartTime int64, activeMap map[string]*ServiceUID, summary map[string]Dist, tr, value, desc string, csv *csv.State) error {
+func (s *ServiceUID) assign(curTime int64, summaryActive, logEvent bool, summaryStartTime int64, activeMap map[string]*ServiceUID, summary map[string]Dist, app appStat, tr, value, desc string, csv *csv.State) error {
 
 	_, alreadyActive := activeMap[value]
 	appID, err := packageutils.AppIDFromString(s.UID)
@@ -424,7 +468,7 @@ func (s *ServiceUID) assign(curTime int64, summaryActive, logEvent bool, summary
 			}
 		}
 		if summaryActive && logEvent {
-			s.addSummaryEntry(curTime, activeMap[value], summary)
+			s.addSummaryEntry(curTime, activeMap[value], summary, app)
 		}
 		delete(activeMap, value)
 
@@ -446,7 +490,7 @@ func (s *ServiceUID) initStart(curTime int64) {
 	}
 }
 
-func (s *ServiceUID) updateSummary(curTime int64, summaryActive bool, summaryStartTime int64, summary map[string]Dist) {
+func (s *ServiceUID) updateSummary(curTime int64, summaryActive bool, summaryStartTime int64, summary map[string]Dist, app appStat) {
 	if s.Start == 0 {
 		s.Start = summaryStartTime
 	}
@@ -455,6 +499,7 @@ func (s *ServiceUID) updateSummary(curTime int64, summaryActive bool, summarySta
 		duration := time.Duration(curTime-s.Start) * time.Millisecond
 		d.addDuration(duration)
 		summary[s.Service] = d
+		app.add(s, duration)
 	}
 	s.Start = curTime
 }
@@ -746,6 +791,7 @@ type DeviceState struct {
 	UserRunning         tsString
 	UserForeground      tsString
 	IdleMode            tsString
+	BodyState           tsString // on, off: whether a wearable is worn
 	//WakeLockType tsString // Alarm, WAlarm
 
 	// Device State metrics from BatteryStats
@@ -767,6 +813,7 @@ type DeviceState struct {
 	WakeLockHeld    tsBool
 	FlashlightOn    tsBool
 	ChargingOn      tsBool
+	USBDataOn       tsBool
 	CameraOn        tsBool
 	VideoOn         tsBool
 	AudioOn         tsBool
@@ -776,8 +823,24 @@ type DeviceState struct {
 	WakeLockHolder ServiceUID
 	WakeupReason   ServiceUID
 
+	// MobileRadioOwner is the app charged for the current mobile radio active (+Pr) period.
+	// It is nil if the radio is off or no app has been identified as waking it up.
+	MobileRadioOwner *ServiceUID
+	// lastAPWakeup is the most recent Ewa event. Ewa events can be logged before the +Pr
+	// they caused on the same history line, so we keep it around to attribute the +Pr.
+	lastAPWakeup *ServiceUID
+
 	syncIntervals []csv.Event
 
+	// summarizeCharging is whether charging periods are summarized too. It's an option of the
+	// analysis, so is kept when the state is reset.
+	summarizeCharging bool
+	// deviceModel is the model of the device, used to decode vendor wakeup reasons. It's also kept when the state is reset.
+	deviceModel string
+	// statusDebounced is whether the charging status change on the history line being analyzed is
+	// debounced, so doesn't start a new summary. It's set for each line.
+	statusDebounced bool
+
 	// Map of uid -> serviceUID for all active entities
 	ActiveProcessMap     map[string]*ServiceUID
 	AppSyncingMap        map[string]*ServiceUID
@@ -797,6 +860,9 @@ type DeviceState struct {
 	// wakelock gets charged, so the map will have just one entry
 	WakeLockMap map[string]*ServiceUID
 
+	// cpuRunningHolders are the wakelock holders seen during the current CPU running period, keyed by UID.
+	cpuRunningHolders map[string]ServiceUID
+
 	// device state for a debugging event
 	AlarmMap map[string]*ServiceUID
 
@@ -859,12 +925,17 @@ func (state *DeviceState) initStartTimeForAllStates() {
 	state.CameraOn.initStart(state.CurrentTime)
 	state.LowPowerModeOn.initStart(state.CurrentTime)
 	state.IdleMode.initStart(state.CurrentTime)
+	state.BodyState.initStart(state.CurrentTime)
 	state.FlashlightOn.initStart(state.CurrentTime)
 	state.ChargingOn.initStart(state.CurrentTime)
+	state.USBDataOn.initStart(state.CurrentTime)
 	state.WifiSuppl.initStart(state.CurrentTime)
 	state.WifiSignalStrength.initStart(state.CurrentTime)
 	state.DcpuStats.initStart(state.CurrentTime)
 	state.DpstStats.initStart(state.CurrentTime)
+	if state.MobileRadioOwner != nil {
+		state.MobileRadioOwner.initStart(state.CurrentTime)
+	}
 
 	for _, s := range state.ActiveProcessMap {
 		s.initStart(state.CurrentTime)
@@ -933,6 +1004,7 @@ func newDeviceState() *DeviceState {
 		ScheduledJobMap:       make(map[string]*ServiceUID),
 		TmpWhiteListMap:       make(map[string]*ServiceUID),
 		AlarmMap:              make(map[string]*ServiceUID),
+		cpuRunningHolders:     make(map[string]ServiceUID),
 		ScreenOn:              tsBool{data: unknownScreenOnReason},
 		CummulativePowerState: make(map[string]*PowerState),
 		InitialPowerState:     make(map[string]*PowerState),
@@ -949,6 +1021,13 @@ type ActivitySummary struct {
 	InitialBatteryLevel int
 	FinalBatteryLevel   int
 	SummaryFormat       string
+	// Charging is whether the summary covers a charging period, only summarized if the
+	// HistoryOptions.SummarizeCharging option is set.
+	Charging bool
+	// Untimed is whether the summary started before the clock was set, e.g. on a device whose real
+	// time clock starts at epoch after a reboot, and no later TIME statement could be used to fix its
+	// times. Its times are meaningless, so it should be excluded from rates over time.
+	Untimed bool
 
 	PluggedInSummary     Dist
 	ScreenOnSummary      Dist
@@ -970,6 +1049,7 @@ type ActivitySummary struct {
 	LowPowerModeOnSummary Dist
 	FlashlightOnSummary   Dist
 	ChargingOnSummary     Dist
+	USBDataSummary        Dist
 
 	PhoneCallSummary Dist
 	PhoneScanSummary Dist
@@ -987,10 +1067,25 @@ type ActivitySummary struct {
 	LongWakelockSummary      map[string]Dist
 	TopApplicationSummary    map[string]Dist
 	PerAppSyncSummary        map[string]Dist
-	WakeupReasonSummary      map[string]Dist
-	ScheduledJobSummary      map[string]Dist
-	TmpWhiteListSummary      map[string]Dist
-	IdleModeSummary          map[string]Dist
+	// CPU running time attributed to the app holding the only wakelocks during a running period.
+	AttributedCPURunningSummary map[string]Dist
+	// Mobile radio active time attributed to the app that woke up the radio. It's a heuristic: the
+	// whole active period is charged to the app of the AP wakeup logged with the +Pr, or else to the
+	// first app waking up the AP while the radio is active, even if other apps used the radio too.
+	// Periods without an AP wakeup, e.g. when the network woke up the radio, aren't attributed. The
+	// checkin apportions the active time between apps by their packets instead, see consistency.Check.
+	MobileRadioActiveAppSummary map[string]Dist
+	WakeupReasonSummary         map[string]Dist
+	ScheduledJobSummary         map[string]Dist
+	TmpWhiteListSummary         map[string]Dist
+	IdleModeSummary             map[string]Dist
+	BodyStateSummary            map[string]Dist // on, off
+	// Battery level drops by body state, for comparing drain rates of wearables on and off body.
+	BodyStateLevelDrop map[string]int
+
+	// Application processor wakeups (Ewa) by app, and those while the screen was off.
+	APWakeupSummary          map[string]Dist
+	APWakeupScreenOffSummary map[string]Dist
 
 	HealthSummary              map[string]Dist
 	PlugTypeSummary            map[string]Dist
@@ -1020,9 +1115,87 @@ type ActivitySummary struct {
 	// device state for debug
 	AlarmSummary map[string]Dist
 
+	// WakeupAlarmSummary, WakeupAlarmAwakeSummary and NonWakeupAlarmSummary are populated by
+	// AddAlarmSummaries, keyed by the app. WakeupAlarmAwakeSummary is the time the CPU stayed awake
+	// after each wakeup alarm went off.
+	WakeupAlarmSummary      map[string]Dist
+	WakeupAlarmAwakeSummary map[string]Dist
+	NonWakeupAlarmSummary   map[string]Dist
+
+	// ScreenOnSessionSummary and ScreenPulseSummary are populated by AddScreenSessionSummaries, with the
+	// screen ons merged into sessions as perceived by the user, and the short pulses counted separately.
+	ScreenOnSessionSummary Dist
+	ScreenPulseSummary     Dist
+
+	// WakeAttributionSummary is populated by AddWakeAttributionSummaries, with the wakes from suspend
+	// keyed by the app with the first activity after each wake, and the time the CPU then ran.
+	WakeAttributionSummary map[string]Dist
+
+	// CPURunningWakeupSummary and CPURunningAbortSummary are populated by AddSuspendAbortSummaries, with
+	// the CPU running time split between genuine wakeups and aborted suspends.
+	CPURunningWakeupSummary Dist
+	CPURunningAbortSummary  Dist
+
+	// IdleMobileRadioSummary and IdleWifiRadioSummary are populated by AddIdleRadioSummaries, with the
+	// periods each radio was active without any app network activity.
+	IdleMobileRadioSummary Dist
+	IdleWifiRadioSummary   Dist
+
+	// MobileRadioEnergyAppSummary is populated by AddMobileRadioTailSummaries, with the mobile radio
+	// active time charged to the app network bursts and their tails, keyed by the app.
+	MobileRadioEnergyAppSummary map[string]Dist
+
+	// WorstWindows is populated by AddWorstWindows.
+	WorstWindows []WindowStat
+
+	// DefaultNetworkSummary, NetworkSwitches and NoConnectivitySummary are populated by AddNetworkSummaries.
+	DefaultNetworkSummary map[string]Dist
+	// NetworkSwitches is the number of times the default network changed to a different network.
+	NetworkSwitches       int
+	NoConnectivitySummary Dist
+
+	// CallSummary and CallLevelDrop are populated by AddCallSummaries, keyed by CellularCall or the VoIP app.
+	CallSummary   map[string]Dist
+	CallLevelDrop map[string]int
+
+	// TetheringSummary and TetheringLevelDrop are populated by AddTetheringSummaries, keyed by tethering
+	// type, e.g. "Wi-Fi hotspot".
+	TetheringSummary   map[string]Dist
+	TetheringLevelDrop map[string]int
+
+	// MotionStateSummary and MotionStateLevelDrop are populated by AddMotionSummaries, with the unplugged
+	// time keyed by MotionStationary, MotionMoving or MotionCommuting.
+	MotionStateSummary   map[string]Dist
+	MotionStateLevelDrop map[string]int
+
+	// WakelockChurnSummary is populated by AddWakelockChurnSummaries, with the wakelock tags acquired
+	// most often in descending order of acquisitions.
+	WakelockChurnSummary []WakelockChurn
+
+	// SyncCostSummary is populated by AddSyncCostSummaries, with the apps with the highest network
+	// weighted sync time in descending order of it.
+	SyncCostSummary []SyncCost
+
+	// UnattributedLevelDrop and UnattributedDuration are populated by AddUnattributedDrain. They are the
+	// estimated battery level drop, in percent, and the time while no tracked activity was on.
+	UnattributedLevelDrop float64
+	UnattributedDuration  time.Duration
+
+	// AppDists are the entries of the per app summary maps keyed by their canonical AppKey, sorted by metric
+	// then in descending order of total duration, so that they can be joined across summaries and reports.
+	AppDists []AppDist
+	// appStats accumulates the AppDists during the summary.
+	appStats map[appMetric]Dist
+
 	Date string
 }
 
+// Interval returns the time interval [StartTimeMs, EndTimeMs) of the summary. The instant events
+// logged at the boundary of two summaries, e.g. at a RESET, belong to the summary starting there.
+func (s ActivitySummary) Interval() csv.Interval {
+	return csv.Interval{Start: s.StartTimeMs, End: s.EndTimeMs}
+}
+
 func (s *ActivitySummary) appendPowerState(ps *PowerState) error {
 	s.PowerStateSummary = append(s.PowerStateSummary, *ps)
 
@@ -1071,34 +1244,40 @@ func (s *ActivitySummary) appendPowerState(ps *PowerState) error {
 // newActivitySummary returns a new properly initialized ActivitySummary structure.
 func newActivitySummary(summaryFormat string) *ActivitySummary {
 	return &ActivitySummary{
-		Active:                     true,
-		SummaryFormat:              summaryFormat,
-		InitialBatteryLevel:        -1,
-		IdleModeSummary:            make(map[string]Dist),
-		DataConnectionSummary:      make(map[string]Dist),
-		ConnectivitySummary:        make(map[string]Dist),
-		ForegroundProcessSummary:   make(map[string]Dist),
-		ActiveProcessSummary:       make(map[string]Dist),
-		TopApplicationSummary:      make(map[string]Dist),
-		PerAppSyncSummary:          make(map[string]Dist),
-		WakeupReasonSummary:        make(map[string]Dist),
-		HealthSummary:              make(map[string]Dist),
-		PlugTypeSummary:            make(map[string]Dist),
-		ChargingStatusSummary:      make(map[string]Dist),
-		LongWakelockSummary:        make(map[string]Dist),
-		PhoneStateSummary:          make(map[string]Dist),
-		WakeLockSummary:            make(map[string]Dist),
-		WakeLockDetailedSummary:    make(map[string]Dist),
-		ScheduledJobSummary:        make(map[string]Dist),
-		TmpWhiteListSummary:        make(map[string]Dist),
-		WifiSupplSummary:           make(map[string]Dist),
-		PhoneSignalStrengthSummary: make(map[string]Dist),
-		WifiSignalStrengthSummary:  make(map[string]Dist),
-		AlarmSummary:               make(map[string]Dist),
-		UserRunningSummary:         make(map[string]Dist),
-		UserForegroundSummary:      make(map[string]Dist),
-		PowerStateOverallSummary:   make(map[string]PowerState),
-		DcpuOverallSummary:         make(map[string]time.Duration),
+		Active:                      true,
+		SummaryFormat:               summaryFormat,
+		InitialBatteryLevel:         -1,
+		IdleModeSummary:             make(map[string]Dist),
+		BodyStateSummary:            make(map[string]Dist),
+		BodyStateLevelDrop:          make(map[string]int),
+		DataConnectionSummary:       make(map[string]Dist),
+		ConnectivitySummary:         make(map[string]Dist),
+		ForegroundProcessSummary:    make(map[string]Dist),
+		ActiveProcessSummary:        make(map[string]Dist),
+		TopApplicationSummary:       make(map[string]Dist),
+		PerAppSyncSummary:           make(map[string]Dist),
+		MobileRadioActiveAppSummary: make(map[string]Dist),
+		APWakeupSummary:             make(map[string]Dist),
+		APWakeupScreenOffSummary:    make(map[string]Dist),
+		AttributedCPURunningSummary: make(map[string]Dist),
+		WakeupReasonSummary:         make(map[string]Dist),
+		HealthSummary:               make(map[string]Dist),
+		PlugTypeSummary:             make(map[string]Dist),
+		ChargingStatusSummary:       make(map[string]Dist),
+		LongWakelockSummary:         make(map[string]Dist),
+		PhoneStateSummary:           make(map[string]Dist),
+		WakeLockSummary:             make(map[string]Dist),
+		WakeLockDetailedSummary:     make(map[string]Dist),
+		ScheduledJobSummary:         make(map[string]Dist),
+		TmpWhiteListSummary:         make(map[string]Dist),
+		WifiSupplSummary:            make(map[string]Dist),
+		PhoneSignalStrengthSummary:  make(map[string]Dist),
+		WifiSignalStrengthSummary:   make(map[string]Dist),
+		AlarmSummary:                make(map[string]Dist),
+		UserRunningSummary:          make(map[string]Dist),
+		UserForegroundSummary:       make(map[string]Dist),
+		PowerStateOverallSummary:    make(map[string]PowerState),
+		DcpuOverallSummary:          make(map[string]time.Duration),
 		DpstOverallSummary: map[string]time.Duration{
 			"usr":  0,
 			"sys":  0,
@@ -1140,6 +1319,12 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 	//////////////// Boolean States ////////////
 
 	// CPURunning: r **
+	if state.CPURunning.Value {
+		if o, _, ok := state.cpuRunningOwner(); ok {
+			o.Start = state.CPURunning.Start
+			o.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.AttributedCPURunningSummary, appStat{summary, "AttributedCPURunningSummary"})
+		}
+	}
 	state.CPURunning.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.CPURunningSummary)
 
 	// Screen: S **
@@ -1151,6 +1336,11 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 	// Mobile Radio: Pr **
 	state.MobileRadioOn.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.MobileRadioOnSummary)
 
+	// Mobile Radio owner: Pr + Ewa
+	if o := state.MobileRadioOwner; o != nil {
+		o.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.MobileRadioActiveAppSummary, appStat{summary, "MobileRadioActiveAppSummary"})
+	}
+
 	// Phone scanning: Psc **
 	state.PhoneScanning.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.PhoneScanSummary)
 
@@ -1187,6 +1377,12 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 	// Idle Mode: di
 	state.IdleMode.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.IdleModeSummary)
 
+	// Body State: Ewd
+	// Only wearables report the body state, so don't summarize it as the default state for other devices.
+	if state.BodyState.Value != "" {
+		state.BodyState.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.BodyStateSummary)
+	}
+
 	// Audio: a
 	state.AudioOn.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.AudioOnSummary)
 
@@ -1202,6 +1398,9 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 	// Charging: ch
 	state.ChargingOn.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.ChargingOnSummary)
 
+	// USB data: Ud
+	state.USBDataOn.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, &summary.USBDataSummary)
+
 	//////////////// String States ////////////
 
 	// Phone state: Pst
@@ -1231,30 +1430,30 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 	/////////////////////////
 	// wake_reason: wr **
 	if state.WakeupReason.Service != "" {
-		state.WakeupReason.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeupReasonSummary)
+		state.WakeupReason.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeupReasonSummary, appStat{})
 	}
 
 	// wake_lock: w **
 	if state.WakeLockHeld.Value {
-		state.WakeLockHolder.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeLockSummary)
+		state.WakeLockHolder.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeLockSummary, appStat{summary, "WakeLockSummary"})
 	}
 
 	///////////////////
 
 	// Active processes: Epr **
 	for _, suid := range state.ActiveProcessMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ActiveProcessSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ActiveProcessSummary, appStat{summary, "ActiveProcessSummary"})
 	}
 
 	// Foreground processes: Efg **
 	for _, suid := range state.ForegroundProcessMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ForegroundProcessSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ForegroundProcessSummary, appStat{summary, "ForegroundProcessSummary"})
 	}
 
 	// Top application: Etp **
 	for _, suid := range state.TopApplicationMap {
 		if suid.Start < state.CurrentTime {
-			suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.TopApplicationSummary)
+			suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.TopApplicationSummary, appStat{summary, "TopApplicationSummary"})
 		}
 	}
 
@@ -1271,22 +1470,22 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 			state.syncIntervals = append(state.syncIntervals, i)
 		}
 
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.PerAppSyncSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.PerAppSyncSummary, appStat{summary, "PerAppSyncSummary"})
 	}
 
 	// Long-held wakelocks: Elw
 	for _, suid := range state.LongWakelockMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.LongWakelockSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.LongWakelockSummary, appStat{summary, "LongWakelockSummary"})
 	}
 
 	// wakelock_in: Ewl **
 	for _, suid := range state.WakeLockMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeLockDetailedSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.WakeLockDetailedSummary, appStat{summary, "WakeLockDetailedSummary"})
 	}
 
 	// Alarm : Eal **
 	for _, suid := range state.AlarmMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.AlarmSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.AlarmSummary, appStat{summary, "AlarmSummary"})
 	}
 
 	// Connectivity changes: Ecn **
@@ -1309,12 +1508,12 @@ func concludeActiveFromState(state *DeviceState, summary *ActivitySummary) (*Dev
 
 	// Applications execute scheduled jobs: Ejb
 	for _, suid := range state.ScheduledJobMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ScheduledJobSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.ScheduledJobSummary, appStat{summary, "ScheduledJobSummary"})
 	}
 
 	// Applications on the temporary white list: Etw
 	for _, suid := range state.TmpWhiteListMap {
-		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.TmpWhiteListSummary)
+		suid.updateSummary(state.CurrentTime, summary.Active, summary.StartTimeMs, summary.TmpWhiteListSummary, appStat{summary, "TmpWhiteListSummary"})
 	}
 
 	// Temperature: Bt
@@ -1331,11 +1530,15 @@ func summarizeActiveState(d *DeviceState, s *ActivitySummary, summaries *[]Activ
 	// summary for durations when the device was charging
 	if s.StartTimeMs != s.EndTimeMs {
 		s.Reason = reason
+		s.Untimed = s.StartTimeMs < minValidTimeMs
 		d, s = concludeActiveFromState(d, s)
+		s.concludeAppDists()
 		s.TotalSyncSummary = calTotalSync(d)
+		s.PlugTypeSummary = namedPlugTypes(s.PlugTypeSummary)
 		*summaries = append(*summaries, *s)
 	}
 
+	charging := s.Charging
 	s = newActivitySummary(s.SummaryFormat)
 	d.syncIntervals = []csv.Event{}
 
@@ -1344,8 +1547,11 @@ func summarizeActiveState(d *DeviceState, s *ActivitySummary, summaries *[]Activ
 		s.EndTimeMs = d.CurrentTime
 		s.InitialBatteryLevel = d.BatteryLevel.Value
 		s.FinalBatteryLevel = d.BatteryLevel.Value
+		s.Charging = charging
 	} else {
+		summarizeCharging, deviceModel := d.summarizeCharging, d.deviceModel
 		d = newDeviceState()
+		d.summarizeCharging, d.deviceModel = summarizeCharging, deviceModel
 	}
 	return d, s
 }
@@ -1358,6 +1564,9 @@ func (s *ActivitySummary) Print(b io.Writer) {
 
 	fmt.Fprintln(b, "Date: ", s.Date)
 	fmt.Fprintln(b, "Reason:", s.Reason)
+	if s.Charging {
+		fmt.Fprintln(b, "Charging period")
+	}
 
 	duration := time.Duration(s.EndTimeMs-s.StartTimeMs) * time.Millisecond
 	if duration == 0 {
@@ -1434,13 +1643,27 @@ func (s *ActivitySummary) Print(b io.Writer) {
 	fmt.Fprintf(b, "%30s", "ChargingOn:")
 	s.ChargingOnSummary.print(b, duration)
 
+	fmt.Fprintf(b, "%30s", "USBData:")
+	s.USBDataSummary.print(b, duration)
+
+	fmt.Fprintf(b, "%30s", "NoConnectivity:")
+	s.NoConnectivitySummary.print(b, duration)
+
 	printMap(b, "IdleMode", s.IdleModeSummary, duration)
+	printMap(b, "BodyState", s.BodyStateSummary, duration)
 	printMap(b, "DataConnectionSummary", s.DataConnectionSummary, duration)
 	printMap(b, "ConnectivitySummary", s.ConnectivitySummary, duration)
+	printMap(b, "DefaultNetworkSummary", s.DefaultNetworkSummary, duration)
+	printMap(b, "CallSummary", s.CallSummary, duration)
+	printMap(b, "TetheringSummary", s.TetheringSummary, duration)
 	printMap(b, "WakeLockSummary", s.WakeLockSummary, duration)
 	printMap(b, "WakeLockDetailedSummary", s.WakeLockDetailedSummary, duration)
 	printMap(b, "TopApplicationSummary", s.TopApplicationSummary, duration)
 	printMap(b, "PerAppSyncSummary", s.PerAppSyncSummary, duration)
+	printMap(b, "MobileRadioActiveAppSummary", s.MobileRadioActiveAppSummary, duration)
+	printMap(b, "APWakeupSummary", s.APWakeupSummary, duration)
+	printMap(b, "APWakeupScreenOffSummary", s.APWakeupScreenOffSummary, duration)
+	printMap(b, "AttributedCPURunningSummary", s.AttributedCPURunningSummary, duration)
 	fmt.Fprintf(b, "TotalSyncTime: %v, TotalSyncNum: %v\n", s.TotalSyncSummary.TotalDuration, s.TotalSyncSummary.Num)
 	printMap(b, "WakeupReasonSummary", s.WakeupReasonSummary, duration)
 
@@ -1478,14 +1701,18 @@ func printMap(b io.Writer, name string, m map[string]Dist, duration time.Duratio
 		return
 	}
 
-	stats := make([]MultiDist, len(m), len(m))
-	idx := 0
-	for k, v := range m {
-		stats[idx] = MultiDist{Name: k, Stat: v}
-		idx++
+	// Sort by name first, so that ties are printed in the same order every time.
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	stats := make([]MultiDist, 0, len(m))
+	for _, k := range names {
+		stats = append(stats, MultiDist{Name: k, Stat: m[k]})
 	}
 
-	sort.Sort(sort.Reverse(SortByTimeAndCount(stats)))
+	sort.Stable(sort.Reverse(SortByTimeAndCount(stats)))
 	fmt.Fprintln(b, name, "\n---------------------")
 	for _, s := range stats {
 		if s.Stat.TotalDuration.Nanoseconds() > 0 {
@@ -1528,8 +1755,13 @@ func printDuration(b io.Writer, name string, m map[string]time.Duration) {
 	}
 
 	fmt.Fprintln(b, name, "\n--------------------------")
-	for k, v := range m {
-		fmt.Fprintf(b, "\t Name: %10s\t Duration: %20s\t\n", k, v)
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(b, "\t Name: %10s\t Duration: %20s\t\n", k, m[k])
 	}
 	fmt.Fprintln(b)
 }
@@ -1554,84 +1786,6 @@ func printPowerStates(b io.Writer, states []PowerState) {
 	fmt.Fprintln(b)
 }
 
-// Regular expressions to match different sections of the low power state output.
-const (
-	voterREString = `voter_\d+\s+name=(?P<name>\S+)\s+time=(?P<time>\d+)\s+count=(?P<count>\d+)\s*`
-	stateREString = `state_(?P<idx>\d+)\s+name=(?P<name>\S+)\s+time=(?P<time>\d+)\s+count=(?P<count>\d+)\s*`
-)
-
-var (
-	voterRE          = regexp.MustCompile(voterREString)
-	stateRE          = regexp.MustCompile(stateREString)
-	fullPowerStateRE = regexp.MustCompile(stateREString + `\s*(?P<voters>(` + voterREString + `)*)`)
-)
-
-// parsePowerStates parses a full power state line.
-// Example format:
-// state_1 name=XO_shutdown time=0 count=0 voter_1 name=APSS time=264740801 count=85367 voter_2 name=MPSS time=314921409 count=286147 voter_3 name=LPASS time=339626342 count=96649 state_2 name=VMIN time=245626000 count=289658
-// Times are printed in milliseconds.
-func parsePowerStates(input string) ([]*PowerState, error) {
-	split := fullPowerStateRE.FindAllString(input, -1)
-	if len(split) == 0 {
-		return nil, fmt.Errorf("invalid power_state line: %q", input)
-	}
-	var states []*PowerState
-	for _, s := range split {
-		// Need to use stateRE here so that voter info doesn't accidentally get used.
-		match, st := historianutils.SubexpNames(stateRE, s)
-		if !match {
-			return nil, fmt.Errorf(`couldn't find power state info in "%v"`, s)
-		}
-		idx, err := strconv.Atoi(st["idx"])
-		if err != nil {
-			return nil, fmt.Errorf("error getting power state level from string: %v", err)
-		}
-		tm, err := strconv.Atoi(st["time"])
-		if err != nil {
-			return nil, fmt.Errorf("error getting power state time from string: %v", err)
-		}
-		c, err := strconv.Atoi(st["count"])
-		if err != nil {
-			return nil, fmt.Errorf("error getting power state count from string: %v", err)
-		}
-		ps := PowerState{
-			Level: int32(idx),
-			Name:  st["name"],
-			Time:  time.Duration(tm) * time.Millisecond,
-			Count: int32(c),
-		}
-
-		match, f := historianutils.SubexpNames(fullPowerStateRE, s)
-		if !match {
-			// This case should never happen because s is created from fullPowerStateRE.FindAllString.
-			return nil, fmt.Errorf("matched string didn't match: %q", s)
-		}
-		vs := voterRE.FindAllString(f["voters"], -1)
-		for _, v := range vs {
-			match, vt := historianutils.SubexpNames(voterRE, v)
-			if !match {
-				// This case should never happen because v is created from voterRE.FindAllString.
-				return nil, fmt.Errorf("matched string didn't match: %q", v)
-			}
-			tm, err = strconv.Atoi(vt["time"])
-			if err != nil {
-				return nil, fmt.Errorf("error getting voter time from string: %v", err)
-			}
-			c, err = strconv.Atoi(vt["count"])
-			if err != nil {
-				return nil, fmt.Errorf("error getting voter count from string: %v", err)
-			}
-			ps.Voters = append(ps.Voters, Voter{
-				Name:  vt["name"],
-				Time:  time.Duration(tm) * time.Millisecond,
-				Count: int32(c),
-			})
-		}
-		states = append(states, &ps)
-	}
-	return states, nil
-}
-
 // subtractPowerStates subtracts one power state from another.
 // Subtrahend is subtracted from Minuend (https://en.wikipedia.org/wiki/Subtraction).
 // The PowerStates are expected to be the same state (same name, level, and set of voters).
@@ -1677,8 +1831,9 @@ func subtractPowerStates(min, sub *PowerState) (*PowerState, error) {
 // updateState method interprets the events contained in the battery history string
 // according to the definitions in: frameworks/base/core/java/android/os/BatteryStats.java
 func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *ActivitySummary, summaries *[]ActivitySummary,
-	idxMap map[string]ServiceUID, pum PackageUIDMapping, idx, tr, key, value string) (*DeviceState, *ActivitySummary, error) {
+	idxMap map[string]ServiceUID, pum PackageUIDMapping, idx string, t HistoryToken) (*DeviceState, *ActivitySummary, error) {
 
+	tr, key, value := t.Transition, t.Key, t.Value
 	switch key {
 	case "Bs": // status
 		i := state.ChargingStatus
@@ -1686,17 +1841,29 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		ret := state.ChargingStatus.assign(state.CurrentTime,
 			summary.Active, summary.StartTimeMs,
 			summary.ChargingStatusSummary, value, "Charging status", csvState)
+		if state.statusDebounced {
+			// The flap is on the timeline, but the summary goes on as if it didn't happen.
+			return state, summary, ret
+		}
 
 		switch value {
 		case "?": // unknown
 		case "c": // charging
-			if active && i != state.ChargingStatus {
+			if active && i != state.ChargingStatus && !summary.Charging {
 				state, summary = summarizeActiveState(state, summary, summaries, false, "CHARGING")
-				summary.Active = false
+				if state.summarizeCharging {
+					summary.Charging = true
+				} else {
+					summary.Active = false
+				}
 			}
 		case "n": // not-charging
 			fallthrough
 		case "d": // discharging
+			if summary.Charging {
+				state, summary = summarizeActiveState(state, summary, summaries, false, "DISCHARGING")
+				summary.Charging = false
+			}
 			if !active {
 				state.initStartTimeForAllStates()
 				summary.StartTimeMs = state.CurrentTime
@@ -1754,6 +1921,9 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		ret := state.BatteryLevel.assign(state.CurrentTime, value, summary.Active, BatteryLevel, csvState)
 
 		summary.FinalBatteryLevel = parsedLevel
+		if bs := state.BodyState.Value; summary.Active && bs != "" && i.Value > parsedLevel {
+			summary.BodyStateLevelDrop[bs] += i.Value - parsedLevel
+		}
 
 		if !summary.Active || summary.InitialBatteryLevel == -1 {
 			summary.InitialBatteryLevel = parsedLevel
@@ -1785,6 +1955,15 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 			// Store the details of the last wakeup to correctly attribute wakeup reason
 			state.LastWakeupTime = state.CurrentTime
 
+			// Wakelocks held from before the CPU started running are also holders for this period.
+			state.cpuRunningHolders = make(map[string]ServiceUID)
+			if state.WakeLockHeld.Value {
+				state.cpuRunningHolders[state.WakeLockHolder.UID] = state.WakeLockHolder
+			}
+			for _, suid := range state.WakeLockMap {
+				state.cpuRunningHolders[suid.UID] = *suid
+			}
+
 		case "-":
 			if !state.CPURunning.Value {
 				// -r was received without a corresponding +r
@@ -1795,6 +1974,14 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 				state.CPURunning.Start = summary.StartTimeMs
 				csvState.AddEntry("CPU running", &tsString{state.CPURunning.Start, ""}, state.CPURunning.Start)
 			}
+			if o, appID, ok := state.cpuRunningOwner(); ok {
+				csvState.Print(CPURunningApp, "service", state.CPURunning.Start, state.CurrentTime, o.Service, fmt.Sprint(appID))
+				if summary.Active {
+					o.Start = state.CPURunning.Start
+					o.addSummaryEntry(state.CurrentTime, &o, summary.AttributedCPURunningSummary, appStat{summary, "AttributedCPURunningSummary"})
+				}
+			}
+			state.cpuRunningHolders = make(map[string]ServiceUID)
 			csvState.AddEntry("CPU running", &tsString{state.CPURunning.Start, state.WakeupReason.Service}, state.CurrentTime)
 			if summary.Active {
 				duration := time.Duration(state.CurrentTime-state.CPURunning.Start) * time.Millisecond
@@ -1840,7 +2027,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 			return state, summary, fmt.Errorf("unable to find index %q in idxMap for wakelock", value)
 		}
 		old := state.WakeupReason.Service
-		state.WakeupReason.Service = serviceUID.Service
+		state.WakeupReason.Service = WakeupReasonName(state.deviceModel, serviceUID.Service)
 
 		if state.CPURunning.Value && state.LastWakeupTime != 0 && (state.WakeLockHeld.Value || old == "") {
 			// If a wakelock is curently held or there wasn't a previously saved wakeup reason,
@@ -1945,6 +2132,8 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 				// The entity was already active when the summary was taken,
 				// so count the active time since the beginning of the summary.
 				state.WakeLockHolder.Service = "unknown-wakelock-holder"
+				state.WakeLockHolder.UID = ""
+				state.WakeLockHolder.Pkg = nil
 				if state.CurrentTime != summary.StartTimeMs {
 					return state, summary, errors.New("got w state in the middle of the summary")
 				}
@@ -1954,6 +2143,11 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 					return state, summary, fmt.Errorf("wakelock held by unknown service : %q", value)
 				}
 				state.WakeLockHolder.Service = serviceUID.Service
+				state.WakeLockHolder.UID = serviceUID.UID
+				state.WakeLockHolder.Pkg = serviceUID.Pkg
+			}
+			if state.CPURunning.Value {
+				state.cpuRunningHolders[state.WakeLockHolder.UID] = state.WakeLockHolder
 			}
 			state.WakeLockHolder.Start = state.CurrentTime
 			state.WakeLockHeld = tsBool{Start: state.CurrentTime, Value: true}
@@ -1968,6 +2162,8 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 				// +w without a value would be present and is handled above.
 				state.WakeLockHolder.Start = state.CurrentTime
 				state.WakeLockHolder.Service = "unknown-wakelock-holder"
+				state.WakeLockHolder.UID = ""
+				state.WakeLockHolder.Pkg = nil
 				addCSVInstantEvent(csvState, state, "Partial wakelock", "error", `"missing corresponding +w"`)
 				return state, summary, nil
 			}
@@ -1977,6 +2173,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 				if duration > 0 {
 					d.addDuration(duration)
 					summary.WakeLockSummary[state.WakeLockHolder.Service] = d
+					appStat{summary, "WakeLockSummary"}.add(&state.WakeLockHolder, duration)
 				}
 			}
 			state.WakeLockHeld = tsBool{Start: state.CurrentTime, Value: false}
@@ -2044,7 +2241,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		// Update stats if needed and screen got turned off.
 		if !state.ScreenOn.Value && summary.Active {
-			topAppSuid.addSummaryEntry(state.CurrentTime, topAppSuid, summary.TopApplicationSummary)
+			topAppSuid.addSummaryEntry(state.CurrentTime, topAppSuid, summary.TopApplicationSummary, appStat{summary, "TopApplicationSummary"})
 		}
 		// Add 'Top app' entry to the log and update the start time.
 		csvState.AddEntryWithOpt(Top, topAppSuid, state.CurrentTime, fmt.Sprint(appID))
@@ -2065,9 +2262,22 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 			summary.DataConnectionSummary, value, "Mobile network type", csvState)
 
 	case "Pr": // modile_radio
-		return state, summary, state.MobileRadioOn.assign(state.CurrentTime,
+		wasOn := state.MobileRadioOn.Value
+		if err := state.MobileRadioOn.assign(state.CurrentTime,
 			summary.Active, summary.StartTimeMs,
-			&summary.MobileRadioOnSummary, tr, "Mobile radio active", csvState)
+			&summary.MobileRadioOnSummary, tr, "Mobile radio active", csvState); err != nil {
+			return state, summary, err
+		}
+		switch {
+		case !wasOn && state.MobileRadioOn.Value:
+			// An Ewa logged earlier on the same history line identifies the app that woke up the radio.
+			if w := state.lastAPWakeup; w != nil && w.Start == state.CurrentTime {
+				return state, summary, state.startMobileRadioOwner(csvState, *w, state.CurrentTime)
+			}
+		case wasOn && !state.MobileRadioOn.Value:
+			return state, summary, state.endMobileRadioOwner(csvState, summary)
+		}
+		return state, summary, nil
 
 	case "Psc": // phone_scanning
 		return state, summary, state.PhoneScanning.assign(state.CurrentTime,
@@ -2115,7 +2325,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.ActiveProcessMap,
-			summary.ActiveProcessSummary, tr, value, "Active process", csvState)
+			summary.ActiveProcessSummary, appStat{summary, "ActiveProcessSummary"}, tr, value, "Active process", csvState)
 
 	case "Efg": // fg
 		serviceUID, ok := idxMap[value]
@@ -2124,7 +2334,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.ForegroundProcessMap,
-			summary.ForegroundProcessSummary, tr, value, Foreground, csvState)
+			summary.ForegroundProcessSummary, appStat{summary, "ForegroundProcessSummary"}, tr, value, Foreground, csvState)
 
 	case "Etp": // top
 		serviceUID, ok := idxMap[value]
@@ -2133,7 +2343,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, state.ScreenOn.Value, summary.StartTimeMs, state.TopApplicationMap,
-			summary.TopApplicationSummary, tr, value, Top, csvState)
+			summary.TopApplicationSummary, appStat{summary, "TopApplicationSummary"}, tr, value, Top, csvState)
 
 	case "Esy": // sync
 		serviceUID, ok := idxMap[value]
@@ -2156,7 +2366,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.AppSyncingMap,
-			summary.PerAppSyncSummary, tr, value, "SyncManager", csvState)
+			summary.PerAppSyncSummary, appStat{summary, "PerAppSyncSummary"}, tr, value, "SyncManager", csvState)
 
 	case "W": // wifi
 		if tr == "-" {
@@ -2360,9 +2570,12 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		if !ok {
 			return state, summary, fmt.Errorf("unable to find index %q in idxMap for wakelock_in", value)
 		}
+		if tr == "+" && state.CPURunning.Value {
+			state.cpuRunningHolders[serviceUID.UID] = serviceUID
+		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.WakeLockMap,
-			summary.WakeLockDetailedSummary, tr, value, "Wakelock_in", csvState)
+			summary.WakeLockDetailedSummary, appStat{summary, "WakeLockDetailedSummary"}, tr, value, "Wakelock_in", csvState)
 
 	case "di": // Doze mode
 		if value == "" { // This will be the case for histories from M devices.
@@ -2419,7 +2632,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.ScheduledJobMap,
-			summary.ScheduledJobSummary, tr, value, "JobScheduler", csvState)
+			summary.ScheduledJobSummary, appStat{summary, "ScheduledJobSummary"}, tr, value, "JobScheduler", csvState)
 
 	case "Elw": // longwake: long-held wakelocks
 		serviceUID, ok := idxMap[value]
@@ -2433,7 +2646,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(curTime,
 			summary.Active, true, summary.StartTimeMs, state.LongWakelockMap,
-			summary.LongWakelockSummary, tr, value, LongWakelocks, csvState)
+			summary.LongWakelockSummary, appStat{summary, "LongWakelockSummary"}, tr, value, LongWakelocks, csvState)
 
 	case "Etw": // tmpwhitelist: an application on the temporary whitelist
 		// Etw events log apps going on/off the temporary whitelist, for example when GCM delivers a high priority message to the app and temporarily whitelists it for network access
@@ -2443,7 +2656,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		}
 		return state, summary, serviceUID.assign(state.CurrentTime,
 			summary.Active, true, summary.StartTimeMs, state.TmpWhiteListMap,
-			summary.TmpWhiteListSummary, tr, value, "Temp White List", csvState)
+			summary.TmpWhiteListSummary, appStat{summary, "TmpWhiteListSummary"}, tr, value, "Temp White List", csvState)
 
 	case "Wsp": // Wifi Supplicant
 		switch value {
@@ -2479,11 +2692,27 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 			summary.Active, summary.StartTimeMs,
 			&summary.ChargingOnSummary, tr, Charging, csvState)
 
+	case "Ud": // usb_data
+		// The USB data link is up, e.g. for MTP or ADB, which keeps the SoC awake even if the device
+		// isn't charging from the connection.
+		return state, summary, state.USBDataOn.assign(state.CurrentTime,
+			summary.Active, summary.StartTimeMs,
+			&summary.USBDataSummary, tr, USBData, csvState)
+
 	case "Epi": // pkginst: package being installed, regardless of whether an older version of
-		return state, summary, addCSVInstantAppEvent(csvState, state, idxMap, "Package install", value)
+		return state, summary, addCSVPackageEvent(csvState, state, idxMap, pum, "Package install", value)
 
 	case "Epu": // pkgunin: package being uninstalled, applys to updates as well.
-		return state, summary, addCSVInstantAppEvent(csvState, state, idxMap, "Package uninstall", value)
+		return state, summary, addCSVPackageEvent(csvState, state, idxMap, pum, "Package uninstall", value)
+
+	case "Ewd": // wrist detection: a wearable was put on (+) or taken off (-) the body.
+		bs := "off"
+		if tr == "+" {
+			bs = "on"
+		}
+		return state, summary, state.BodyState.assign(state.CurrentTime,
+			summary.Active, summary.StartTimeMs,
+			summary.BodyStateSummary, bs, OnBody, csvState)
 
 	case "Esm": // significant motion
 		// Significant Motion Detection is a state change event that is added to CSV as a point event without a duration.
@@ -2493,6 +2722,21 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 	case "Ewa": // wakeup AP: a UID caused the application processor to wakeup.
 		// This can be caused by either +mobile-radio or +wifi, but those don't have to be on the same history line.
 		addCSVInstantAppEvent(csvState, state, idxMap, "App Processor wakeup", value)
+		suid, ok := idxMap[value]
+		if !ok {
+			return state, summary, nil
+		}
+		suid.Start = state.CurrentTime
+		state.lastAPWakeup = &suid
+		if summary.Active {
+			if err := summary.addAPWakeup(suid, !state.ScreenOn.Value); err != nil {
+				return state, summary, err
+			}
+		}
+		if state.MobileRadioOn.Value && state.MobileRadioOwner == nil {
+			// The first app to wake up the AP while the radio is active is charged for the whole active period.
+			return state, summary, state.startMobileRadioOwner(csvState, suid, state.MobileRadioOn.Start)
+		}
 		return state, summary, nil
 
 	case "Eaa": // package active. Event for a package becoming active due to an interaction.
@@ -2512,7 +2756,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 		if !ok {
 			return state, summary, fmt.Errorf("unable to find index %q in idxMap for Alarm going off (Eal)", value)
 		}
-		err := suid.assign(state.CurrentTime, summary.Active, true, summary.StartTimeMs, state.AlarmMap, summary.AlarmSummary, tr, value, "Alarm", csvState)
+		err := suid.assign(state.CurrentTime, summary.Active, true, summary.StartTimeMs, state.AlarmMap, summary.AlarmSummary, appStat{summary, "AlarmSummary"}, tr, value, "Alarm", csvState)
 		return state, summary, err
 
 	case "Est": // stats
@@ -2574,11 +2818,14 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 					return state, summary, err
 				}
 				app.pkgName = pum.packageName(appID)
+				if pum.redactions.redactsApp(appID, app.pkgName) {
+					app.UID, app.pkgName, appID = Redacted, Redacted, 0
+				}
 				// The implementation of addEntryWithOpt requires two calls in order for the csv line to be printed out.
 				csvState.AddEntryWithOpt("Highest App CPU Usage", &app, app.start, fmt.Sprint(appID))
 				csvState.AddEntryWithOpt("Highest App CPU Usage", &app, state.CurrentTime, fmt.Sprint(appID))
 				dcpu.CPUUtilizers = append(dcpu.CPUUtilizers, app)
-				calDcpuOverallSummary(ss[0], s[1], s[2], summary.DcpuOverallSummary, summary.Active)
+				calDcpuOverallSummary(app.UID, s[1], s[2], summary.DcpuOverallSummary, summary.Active)
 			default:
 				return state, summary, fmt.Errorf("unknown Dcpu part: %q", sub)
 			}
@@ -2614,7 +2861,7 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 	// so we have to look for these instead
 	case "null":
 		// The system doesn't support this hardware for low power states. Nothing to do here.
-	case "state_1":
+	case powerStatesKey:
 		pStates, err := parsePowerStates(value)
 		if err != nil {
 			return state, summary, err
@@ -2718,15 +2965,22 @@ func updateState(b io.Writer, csvState *csv.State, state *DeviceState, summary *
 			}
 			if state.dpstTokenIndex == 5 {
 				summary.DpstStatsSummary = append(summary.DpstStatsSummary, state.DpstStats)
+				// The derived series show CPU saturation and IO bound steps on the timeline.
+				if st := state.DpstStats; st.totalTime() > 0 {
+					csvState.Print(DpstBusyMetric, "float", st.Start, state.CurrentTime, fmt.Sprintf("%.3f", st.BusyFraction()), "")
+					csvState.Print(DpstIOWaitMetric, "float", st.Start, state.CurrentTime, fmt.Sprintf("%.3f", st.IOWaitFraction()), "")
+				}
 				state.DpstStats.Start = state.CurrentTime
 
 				state.isDpstEvent = false
 				return state, summary, nil
 			}
 			state.dpstTokenIndex++
+		} else if h, ok := tokenHandlers[key]; ok {
+			return state, summary, h(csvState, state, summary, t)
 		} else {
 			fmt.Printf("Unknown history key: %s%s / %s\n", tr, key, value)
-			return state, summary, errors.New("unknown key " + key)
+			return state, summary, unknownKeyError(key)
 		}
 	}
 	return state, summary, nil
@@ -2754,14 +3008,112 @@ func addCSVInstantAppEvent(csv *csv.State, state *DeviceState, idxMap map[string
 			s = fmt.Sprintf(`%q`, suid.Pkg.GetPkgName())
 		}
 	}
-	e := ServiceUID{
-		Start:   state.CurrentTime,
-		Service: s,
-		UID:     suid.UID,
+	printCSVAppEvent(csv, state, eventName, ServiceUID{Service: s, UID: suid.UID}, fmt.Sprint(appID))
+	return nil
+}
+
+// addCSVPackageEvent adds an instant event for the package install or uninstall event of the string
+// pool entry with the given index. The UID field of those entries is the version code of the package,
+// so the app is matched from the package name alone, and the app UID is left out of the CSV if the
+// package isn't known.
+func addCSVPackageEvent(csv *csv.State, state *DeviceState, idxMap map[string]ServiceUID, pum PackageUIDMapping, eventName, value string) error {
+	suid, ok := idxMap[value]
+	if !ok {
+		return fmt.Errorf("unable to find index %q in idxMap for %q", value, eventName)
 	}
+	app := ServiceUID{Service: suid.Service, UID: normalizeUID(suid.UID, versionCode)}
+	if err := pum.matchServiceWithPackageInfo(&app); err != nil {
+		return err
+	}
+	var opt string
+	if app.Pkg != nil {
+		opt = fmt.Sprint(app.Pkg.GetUid())
+		if app.Service == "" || app.Service == `""` {
+			app.Service = fmt.Sprintf(`%q`, app.Pkg.GetPkgName())
+		}
+	}
+	printCSVAppEvent(csv, state, eventName, ServiceUID{Service: app.Service}, opt)
+	return nil
+}
+
+// printCSVAppEvent adds an instant event for the given app at the current time to the csv log.
+func printCSVAppEvent(csv *csv.State, state *DeviceState, eventName string, e ServiceUID, opt string) {
+	e.Start = state.CurrentTime
 	// The implementation of addEntryWithOpt requires two calls in order for the csv line to be printed out.
-	csv.AddEntryWithOpt(eventName, &e, state.CurrentTime, fmt.Sprint(appID))
-	csv.AddEntryWithOpt(eventName, &e, state.CurrentTime, fmt.Sprint(appID))
+	csv.AddEntryWithOpt(eventName, &e, state.CurrentTime, opt)
+	csv.AddEntryWithOpt(eventName, &e, state.CurrentTime, opt)
+}
+
+// appServiceUID returns a copy of the given ServiceUID with an empty service replaced by the
+// app name, and the app ID to use in the csv opt field.
+func appServiceUID(suid ServiceUID) (ServiceUID, int32, error) {
+	var appID int32
+	if suid.Pkg == nil {
+		var err error
+		if appID, err = packageutils.AppIDFromString(suid.UID); err != nil {
+			return suid, 0, err
+		}
+	} else {
+		appID = suid.Pkg.GetUid()
+	}
+	if suid.Service == "" || suid.Service == `""` {
+		if suid.Pkg != nil {
+			suid.Service = fmt.Sprintf(`%q`, suid.Pkg.GetPkgName())
+		} else {
+			suid.Service = fmt.Sprintf(`"UID %d"`, appID)
+		}
+	}
+	return suid, appID, nil
+}
+
+// cpuRunningOwner returns the app to charge for the current CPU running period, and its app ID.
+// The period is only attributed if all of the wakelocks seen during it were held by the same known UID.
+func (state *DeviceState) cpuRunningOwner() (ServiceUID, int32, bool) {
+	if len(state.cpuRunningHolders) != 1 {
+		return ServiceUID{}, 0, false
+	}
+	for uid, suid := range state.cpuRunningHolders {
+		if uid == "" {
+			return ServiceUID{}, 0, false
+		}
+		// The summary is per app, so don't keep the wakelock name as the service.
+		suid.Service = ""
+		o, appID, err := appServiceUID(suid)
+		if err != nil {
+			return ServiceUID{}, 0, false
+		}
+		return o, appID, true
+	}
+	return ServiceUID{}, 0, false
+}
+
+// startMobileRadioOwner charges the current mobile radio active period, starting from start, to the given app.
+func (state *DeviceState) startMobileRadioOwner(csvState *csv.State, suid ServiceUID, start int64) error {
+	o, appID, err := appServiceUID(suid)
+	if err != nil {
+		return err
+	}
+	o.Start = start
+	state.MobileRadioOwner = &o
+	csvState.AddEntryWithOpt(MobileRadioApp, &o, start, fmt.Sprint(appID))
+	return nil
+}
+
+// endMobileRadioOwner ends the current mobile radio active period for the charged app, if any.
+func (state *DeviceState) endMobileRadioOwner(csvState *csv.State, summary *ActivitySummary) error {
+	o := state.MobileRadioOwner
+	if o == nil {
+		return nil
+	}
+	state.MobileRadioOwner = nil
+	_, appID, err := appServiceUID(*o)
+	if err != nil {
+		return err
+	}
+	if summary.Active {
+		o.addSummaryEntry(state.CurrentTime, o, summary.MobileRadioActiveAppSummary, appStat{summary, "MobileRadioActiveAppSummary"})
+	}
+	csvState.AddEntryWithOpt(MobileRadioApp, o, state.CurrentTime, fmt.Sprint(appID))
 	return nil
 }
 
@@ -2789,7 +3141,7 @@ func printDebugEvent(b io.Writer, event, line string, state *DeviceState, summar
 }
 
 func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *ActivitySummary, summaries *[]ActivitySummary,
-	idxMap map[string]ServiceUID, pum PackageUIDMapping, line string) (*DeviceState, *ActivitySummary, error) {
+	idxMap map[string]ServiceUID, pum PackageUIDMapping, unknown *unknownKeyCounts, line string) (*DeviceState, *ActivitySummary, error) {
 
 	/*
 	  8,h,60012:START
@@ -2831,7 +3183,7 @@ func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *Activ
 		}
 		state.CurrentTime += parsedInt64
 		summary.EndTimeMs = state.CurrentTime
-		csv.AddRebootEvent(state.CurrentTime)
+		csv.AddRebootEventWithOpt(state.CurrentTime, RebootClean)
 
 		return state, summary, nil
 	}
@@ -2841,9 +3193,9 @@ func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *Activ
 	if matches := StartRE.FindStringSubmatch(line); matches != nil {
 		csv.PrintAllReset(state.CurrentTime)
 		// If there was no SHUTDOWN event in the bugreport,
-		// we need to create the reboot entry here.
+		// we need to create the reboot entry here. The device didn't shut down cleanly.
 		if !csv.HasRebootEvent() {
-			csv.AddRebootEvent(state.CurrentTime)
+			csv.AddRebootEventWithOpt(state.CurrentTime, RebootAbrupt)
 		}
 		// printDebugEvent("START", line, state, summary)
 		// Reset state and summary and start from scratch, History string pool
@@ -2859,9 +3211,7 @@ func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *Activ
 			return state, summary, errors.New("int parsing error for TIME in line:" + line)
 		}
 		// Do not reset the summary start time if we are just parsing the next periodic report.
-		// This is a random timestamp chosen to filter out timestamps that start at epoch.
-		cutTime := time.Date(2005, time.August, 17, 12, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
-		if summary.StartTimeMs < cutTime && parsedInt64 > cutTime {
+		if summary.StartTimeMs < minValidTimeMs && parsedInt64 >= minValidTimeMs {
 			summary.StartTimeMs = parsedInt64
 			summary.EndTimeMs = parsedInt64
 		}
@@ -2897,25 +3247,28 @@ func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *Activ
 	if len(parts) >= 4 {
 		success := true
 		var errorBuffer bytes.Buffer
+		var unknownKeys []string
 		for _, part := range parts[3:] {
 			var err error
-			if matches, result := historianutils.SubexpNames(DataRE, part); matches {
-				v := result["value"]
-				if result["key"] == "state_1" {
+			if t, ok := parseHistoryToken(part); ok {
+				if powerStateProviderFor(part) != nil {
 					// DataRE doesn't get the rest of the output because it doesn't expect spaces.
-					v = part
+					t = HistoryToken{Transition: t.Transition, Key: powerStatesKey, Value: strings.TrimSpace(part)}
 				}
-				state, summary, err = updateState(b, csv, state, summary, summaries, idxMap, pum, timeDelta,
-					result["transition"], result["key"], v)
+				state, summary, err = updateState(b, csv, state, summary, summaries, idxMap, pum, timeDelta, t)
 				if err != nil {
 					success = false
 					errorBuffer.WriteString("** Error in " + line + " with " + part + " : " + err.Error() + "\n")
+					if k, ok := err.(unknownKeyError); ok {
+						unknownKeys = append(unknownKeys, string(k))
+					}
 				}
 			}
 		}
 		if success {
 			return state, summary, nil
 		}
+		unknown.add(unknownKeys)
 		return state, summary, errors.New(strings.TrimSpace(errorBuffer.String()))
 	} else if len(parts) == 3 {
 		return state, summary, nil
@@ -2924,9 +3277,10 @@ func analyzeData(b io.Writer, csv *csv.State, state *DeviceState, summary *Activ
 }
 
 // analyzeHistoryLine takes a battery history event string and updates the device state.
+// Lines with unsupported event codes are counted in unknown, if it is not nil.
 func analyzeHistoryLine(b io.Writer, csvState *csv.State, state *DeviceState, summary *ActivitySummary,
 	summaries *[]ActivitySummary, idxMap map[string]ServiceUID, pum PackageUIDMapping,
-	d *deltaMapping, line string, scrubPII bool) (*DeviceState, *ActivitySummary, error) {
+	d *deltaMapping, unknown *unknownKeyCounts, line string, scrubPII bool) (*DeviceState, *ActivitySummary, error) {
 
 	if match, result := historianutils.SubexpNames(GenericHistoryStringPoolLineRE, line); match {
 		index := result["index"]
@@ -2936,13 +3290,13 @@ func analyzeHistoryLine(b io.Writer, csvState *csv.State, state *DeviceState, su
 		}
 		suid := ServiceUID{
 			Service: service,
-			UID:     result["uid"],
+			UID:     normalizeUID(result["uid"], appUID),
 		}
 		err := pum.matchServiceWithPackageInfo(&suid)
 		idxMap[index] = suid
 		return state, summary, err
 	} else if match, result := historianutils.SubexpNames(GenericHistoryLineRE, line); match {
-		state, summary, err := analyzeData(b, csvState, state, summary, summaries, idxMap, pum, line)
+		state, summary, err := analyzeData(b, csvState, state, summary, summaries, idxMap, pum, unknown, line)
 		// Add a mapping from the timestamp to current cumulative delta.
 		// If there was no valid delta, don't add a mapping.
 		timeDelta := result["timeDelta"]
@@ -2981,6 +3335,22 @@ type AnalysisReport struct {
 	OverflowMs        int64
 	// The keys are the unix timestamp in ms, and the values are the human readable time deltas.
 	TimeToDelta map[string]string
+	// HistoryLines is the number of history event lines analyzed.
+	HistoryLines int
+	// UnknownKeyLines is the number of history event lines containing unsupported event codes.
+	UnknownKeyLines int
+	// UnknownKeys maps each unsupported event code to the number of lines it was seen in.
+	UnknownKeys map[string]int
+	// ClockChanges are the wall clock changes, such as NITZ updates, seen in the history before fixTimeline was applied.
+	ClockChanges []ClockChange
+	// Snapshots are the device states captured by AnalyzeHistoryWithSnapshots, in time order.
+	Snapshots []DeviceStateSnapshot
+	// FinalState is the device state at the last analyzed history time, which is usually around when
+	// the bug report was captured. Nil if the history has no events.
+	FinalState *DeviceStateSnapshot
+	// StateAt is the device state at HistoryOptions.StateAtMs. Nil if not requested, or if the time
+	// isn't covered by the history, e.g. while the device was rebooting.
+	StateAt *DeviceStateSnapshot
 }
 
 // levelSummaryDimension has the name of a dimension, its attribute name corresponding to the attributes of AcitivitySummary,
@@ -3091,17 +3461,77 @@ func BatteryLevelSummariesToCSV(buf io.Writer, summaries *[]ActivitySummary, pri
 // AnalyzeHistory takes as input a complete history log and desired summary format.
 // It then analyzes the log line by line (delimited by newline characters).
 // No summaries (before an OVERFLOW line) are excluded/filtered out.
+// If csvWriter is nil, no CSV is generated at all and only the summaries are computed.
 func AnalyzeHistory(csvWriter io.Writer, history, format string, pum PackageUIDMapping, scrubPII bool) *AnalysisReport {
+	return AnalyzeHistoryWithSnapshots(csvWriter, history, format, pum, scrubPII, 0)
+}
+
+// AnalyzeHistoryWithSnapshots is the same as AnalyzeHistory, but also captures a snapshot of the
+// device state every interval of history time into the report. No snapshots are captured if the
+// interval isn't positive.
+func AnalyzeHistoryWithSnapshots(csvWriter io.Writer, history, format string, pum PackageUIDMapping, scrubPII bool, interval time.Duration) *AnalysisReport {
+	return AnalyzeHistoryWithOptions(csvWriter, history, format, pum, scrubPII, HistoryOptions{SnapshotInterval: interval})
+}
+
+// HistoryOptions are the optional parts of the history analysis. The zero value is the default analysis.
+type HistoryOptions struct {
+	// SnapshotInterval is the history time between the device state snapshots captured into the report.
+	// No snapshots are captured if it isn't positive.
+	SnapshotInterval time.Duration
+	// LineIndex is written the line numbers in history that each CSV entry was read from, in the
+	// csv.LineIndexHeader format. It's only written for the FormatTotalTime format, and if not nil.
+	LineIndex io.Writer
+	// SummarizeCharging is whether charging periods are summarized too, in summaries with Charging set.
+	// By default only discharging periods are summarized.
+	SummarizeCharging bool
+	// DeviceModel is the model of the device the history is from, e.g. "Nexus 6P". Vendor encoded
+	// wakeup reasons are decoded with the wakeup reason names of the model, see AddWakeupReasonNames.
+	DeviceModel string
+	// Sink is sent each battery history event as it's finalized, in the same order as the CSV, if not nil.
+	// An error from the sink is added to the report errors, and no more events are sent to it.
+	Sink csv.EventSink
+	// ChargingDebounce is the longest time a charging status change can be reverted within for it to be
+	// taken for charger flapping, which doesn't start new summaries. Disabled if not positive.
+	ChargingDebounce time.Duration
+	// StateAtMs is the history time, in unix ms, of the device state captured into the report's StateAt.
+	// No state is captured if it isn't positive.
+	StateAtMs int64
+	// Progress is called with the number of lines of the history analyzed so far and the total number
+	// of lines, regularly during the analysis and once it's done, if not nil.
+	Progress func(done, total int)
+}
+
+// AnalyzeHistoryWithOptions is the same as AnalyzeHistory, with the given optional parts of the analysis.
+func AnalyzeHistoryWithOptions(csvWriter io.Writer, history, format string, pum PackageUIDMapping, scrubPII bool, opts HistoryOptions) *AnalysisReport {
+	return AnalyzeHistoryContext(context.Background(), csvWriter, history, format, pum, scrubPII, opts)
+}
+
+// AnalyzeHistoryContext is the same as AnalyzeHistoryWithOptions, but stops analyzing the history
+// once the context is done, e.g. when the client requesting the analysis disconnects. The report of
+// a stopped analysis only has the errors, ending with the context error, and any CSV already written
+// to csvWriter is incomplete.
+func AnalyzeHistoryContext(ctx context.Context, csvWriter io.Writer, history, format string, pum PackageUIDMapping, scrubPII bool, opts HistoryOptions) *AnalysisReport {
 	// 8,hsp,0,10073,"com.google.android.volta"
 	// 8,hsp,28,0,"200:qcom,smd-rpm:203:fc4281d0.qcom,mpm:222:fc4cf000.qcom,spmi"
 
-	h, c, err := fixTimeline(history)
 	var errs []error
+	clockChanges, err := detectClockChanges(strings.Split(history, "\n"))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	h, c, err := fixTimeline(history)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	debounced, err := debouncedStatusLines(h, opts.ChargingDebounce)
 	if err != nil {
 		errs = append(errs, err)
 	}
 
 	deviceState := newDeviceState()
+	deviceState.summarizeCharging = opts.SummarizeCharging
+	deviceState.deviceModel = opts.DeviceModel
 	summary := newActivitySummary(format)
 	summaries := []ActivitySummary{}
 	idxMap := make(map[string]ServiceUID)
@@ -3113,15 +3543,41 @@ func AnalyzeHistory(csvWriter io.Writer, history, format string, pum PackageUIDM
 		writer = ioutil.Discard
 	}
 
-	csvState := csv.NewState(writer, true)
+	var csvState *csv.State
+	if csvWriter != nil {
+		csvState = csv.NewState(writer, true)
+	}
+	// lineNumbers are the line numbers in history of the lines in h, if the line index is written.
+	var lineNumbers []int
+	// lastLine is the line number of the last analyzed line, if the line index is written.
+	var lastLine int
+	if opts.LineIndex != nil && csvState != nil && format == FormatTotalTime {
+		csvState.SetLineIndex(opts.LineIndex)
+		lineNumbers = historyLineNumbers(history)
+	}
+	if opts.Sink != nil {
+		csvState.SetSink(opts.Sink)
+	}
 	var b bytes.Buffer
 	var v int32
 	overflowIdx := -1
 	var overflowMs int64
+	var historyLines int
+	unknown := newUnknownKeyCounts()
 
 	d := newDeltaMapping()
+	snap := newSnapshotter(opts.SnapshotInterval)
+	var stateAt *DeviceStateSnapshot
 
 	for i, line := range h {
+		if i%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return &AnalysisReport{Errs: append(errs, err)}
+			}
+			if opts.Progress != nil {
+				opts.Progress(i, len(h))
+			}
+		}
 		if OverflowRE.MatchString(line) {
 			overflowIdx = i
 			// There can be multiple overflow events, but we only care about plotting the first one.
@@ -3137,10 +3593,28 @@ func AnalyzeHistory(csvWriter io.Writer, history, format string, pum PackageUIDM
 			}
 			v = int32(p)
 		} else {
-			deviceState, summary, err = analyzeHistoryLine(&b, csvState, deviceState, summary, &summaries, idxMap, pum, d, line, scrubPII)
+			if !GenericHistoryStringPoolLineRE.MatchString(line) && GenericHistoryLineRE.MatchString(line) {
+				historyLines++
+			}
+			if snap != nil {
+				snap.before(deviceState, line)
+			}
+			if opts.StateAtMs > 0 && stateAt == nil {
+				stateAt = deviceState.snapshotBefore(line, opts.StateAtMs)
+			}
+			if i < len(lineNumbers) {
+				lastLine = lineNumbers[i]
+				csvState.SetLine(lastLine)
+			}
+			deviceState.statusDebounced = debounced[i]
+			deviceState, summary, err = analyzeHistoryLine(&b, csvState, deviceState, summary, &summaries, idxMap, pum, d, unknown, line, scrubPII)
 			if err != nil && len(line) > 0 {
 				errs = append(errs, err)
 			}
+			if snap != nil {
+				snap.after(deviceState)
+			}
+
 		}
 	}
 
@@ -3168,21 +3642,39 @@ func AnalyzeHistory(csvWriter io.Writer, history, format string, pum PackageUIDM
 			csvState.EndEvent(BatteryLevel, "", es[0].Start)
 		}
 		// This may lead to CSV events being unordered, but we sort events on the JS side anyway.
+		// The events are analyzed separately, so aren't included in the line index.
+		csvState.SetLine(0)
 		for _, e := range es {
 			csvState.PrintEvent(BatteryLevel, e)
 		}
+		csvState.SetLine(lastLine)
+	}
+
+	var finalState *DeviceStateSnapshot
+	if deviceState.CurrentTime != 0 {
+		fs := deviceState.snapshot(deviceState.CurrentTime)
+		finalState = &fs
+		if opts.StateAtMs > 0 && stateAt == nil && opts.StateAtMs == deviceState.CurrentTime {
+			stateAt = finalState
+		}
 	}
 
 	csvState.PrintAllReset(deviceState.CurrentTime)
 	csvState.PrintRebootEvent(deviceState.CurrentTime)
+	if err := csvState.SinkErr(); err != nil {
+		errs = append(errs, fmt.Errorf("could not send events to the event sink: %v", err))
+	}
 	if summary.Active {
 		deviceState, summary = summarizeActiveState(deviceState, summary, &summaries, true, "END")
 	}
 
 	// csv generation must go after analyzing the history lines
-	if format == FormatBatteryLevel {
+	if format == FormatBatteryLevel && csvWriter != nil {
 		BatteryLevelSummariesToCSV(csvWriter, &summaries, true)
 	}
+	if opts.Progress != nil {
+		opts.Progress(len(h), len(h))
+	}
 
 	return &AnalysisReport{
 		ReportVersion:     v,
@@ -3193,6 +3685,13 @@ func AnalyzeHistory(csvWriter io.Writer, history, format string, pum PackageUIDM
 		Errs:              errs,
 		OverflowMs:        overflowMs,
 		TimeToDelta:       d.timeToDelta,
+		HistoryLines:      historyLines,
+		UnknownKeyLines:   unknown.lines,
+		UnknownKeys:       unknown.keys,
+		ClockChanges:      clockChanges,
+		Snapshots:         snap.result(),
+		FinalState:        finalState,
+		StateAt:           stateAt,
 	}
 }
 
@@ -3212,7 +3711,7 @@ func extractLevel(h []string, curMs int64, d *deltaMapping) ([]csv.Event, []erro
 	for _, l := range h {
 		// Ignore errors as most will be due to incomplete (non battery level) events.
 		// e.g. two negative transitions for "Temp White List
-		ds, _, _ = analyzeHistoryLine(ioutil.Discard, csvState, ds, as, &sums, nil, pum, d, l, true)
+		ds, _, _ = analyzeHistoryLine(ioutil.Discard, csvState, ds, as, &sums, nil, pum, d, nil, l, true)
 	}
 	csvState.PrintAllReset(ds.CurrentTime)
 	es, errs := csv.ExtractEvents(b.String(), []string{BatteryLevel})
@@ -3224,19 +3723,27 @@ func extractLevel(h []string, curMs int64, d *deltaMapping) ([]csv.Event, []erro
 // part of the history log, and returns a slice of the fixed history, split by new
 // lines, along with a boolean to indicate if the original history timestamps were
 // modified. The function operates with the assumption that the last time statement
-// in a history (between reboots) is the most accurate. This function should be
-// called before analyzing the history.
+// in a history (between reboots) is the most accurate. Earlier boots are then shifted
+// back if they would overlap later ones, as happens when the clock is moved backwards by
+// a NITZ update. TIME statements from a clock that wasn't set yet, such as one starting
+// at epoch, aren't used as the reference if the boot has a valid TIME statement, so the
+// times before the first valid TIME statement of a boot are backfilled from it. This function should be called before
+// analyzing the history.
 func fixTimeline(h string) ([]string, bool, error) {
 	var s []string
 	// Filter out non-history log lines.
 	for _, l := range strings.Split(h, "\n") {
 		l = strings.TrimSpace(l)
-		if GenericHistoryLineRE.MatchString(l) || GenericHistoryStringPoolLineRE.MatchString(l) || VersionLineRE.MatchString(l) {
+		if isHistoryLine(l) {
 			s = append(s, l)
 		}
 	}
 
 	changed := false
+	validBoot, err := validTimeBoots(s)
+	if err != nil {
+		return nil, false, err
+	}
 
 	var time int64 // time will be defined at the beginning of the current line --> the time before the delta has been added
 	timeFound := false
@@ -3281,11 +3788,37 @@ func fixTimeline(h string) ([]string, bool, error) {
 				if err != nil {
 					return nil, changed, err
 				}
+				if t < minValidTimeMs && validBoot[i] {
+					// The clock wasn't set yet, so the earlier statements are backfilled from the
+					// next valid TIME statement of the boot instead.
+					timeFound = false
+					continue
+				}
 				time = t - d
 			}
 		}
 	}
-	return s, changed, nil
+	rebased, err := rebaseBoots(s)
+	if err != nil {
+		return nil, changed, err
+	}
+	return s, changed || rebased, nil
+}
+
+// isHistoryLine returns whether the trimmed line is part of the history log.
+func isHistoryLine(l string) bool {
+	return GenericHistoryLineRE.MatchString(l) || GenericHistoryStringPoolLineRE.MatchString(l) || VersionLineRE.MatchString(l)
+}
+
+// historyLineNumbers returns the line numbers, starting from 1, of the lines of h kept by fixTimeline.
+func historyLineNumbers(h string) []int {
+	var n []int
+	for i, l := range strings.Split(h, "\n") {
+		if isHistoryLine(strings.TrimSpace(l)) {
+			n = append(n, i+1)
+		}
+	}
+	return n
 }
 
 // PackageUIDMapping contains a series of mapping between package names and their UIDs.
@@ -3301,6 +3834,20 @@ type PackageUIDMapping struct {
 	sharedUIDName map[int32]string
 	// pkgList contains all of the packages parsed to generate the mappings.
 	pkgList []*usagepb.PackageInfo
+	// profileNames is whether the packages of secondary users are given profile-qualified names.
+	profileNames bool
+	// inferPackages is whether package names are inferred from the service strings, for reports
+	// without a checkin log or package list to match them against.
+	inferPackages bool
+	// redactions contains the apps anonymized in the matched services, nil if none.
+	redactions *Redactions
+}
+
+// SetProfileNames sets whether the packages matched for secondary user UIDs, e.g. apps cloned in a
+// work profile, are named with the profile (e.g. "com.google.android.gm (work)") instead of being
+// merged with the primary user's package.
+func (pum *PackageUIDMapping) SetProfileNames(keep bool) {
+	pum.profileNames = keep
 }
 
 // UIDAndPackageNameMapping builds a mapping of UIDs to package names and package names to UIDs.
@@ -3367,11 +3914,41 @@ func UIDAndPackageNameMapping(checkin string, pkgs []*usagepb.PackageInfo) (Pack
 		}
 	}
 
-	return PackageUIDMapping{m, p, s, pkgs}, errs
+	// Without any apk line or package, the services can only be matched to packages by their names.
+	return PackageUIDMapping{m, p, s, pkgs, false, len(m) == 0 && len(pkgs) == 0, nil}, errs
 }
 
 // matchServiceWithPackageInfo attempts to match the best usagepb.PackageInfo for the given ServiceUID.
+// If profile names are enabled, packages matched for secondary user UIDs are qualified with the profile.
+// Services of redacted apps are anonymized.
 func (pum *PackageUIDMapping) matchServiceWithPackageInfo(suid *ServiceUID) error {
+	if err := pum.matchPackage(suid); err != nil {
+		return err
+	}
+	if pum.redactions.redacts(suid) {
+		redact(suid)
+		return nil
+	}
+	if !pum.profileNames || suid.Pkg == nil {
+		return nil
+	}
+	user, err := packageutils.UserIDFromString(suid.UID)
+	if err != nil {
+		return err
+	}
+	if user != 0 {
+		// The package may be shared with the package list, so it can't be modified in place.
+		suid.Pkg = &usagepb.PackageInfo{
+			PkgName: proto.String(suid.Pkg.GetPkgName() + workProfileSuffix),
+			Uid:     suid.Pkg.Uid,
+		}
+	}
+	return nil
+}
+
+// matchPackage attempts to match the best usagepb.PackageInfo for the given ServiceUID,
+// ignoring the user the UID belongs to.
+func (pum *PackageUIDMapping) matchPackage(suid *ServiceUID) error {
 	// Check hard-coded UIDs first
 	uid, err := packageutils.AppIDFromString(suid.UID)
 	if err != nil {
@@ -3463,6 +4040,14 @@ func (pum *PackageUIDMapping) matchServiceWithPackageInfo(suid *ServiceUID) erro
 			}
 		}
 	}
+	if pkg == nil && pum.inferPackages {
+		if n := packageutils.InferPackageName(suid.Service); n != "" {
+			pkg = &usagepb.PackageInfo{
+				PkgName: proto.String(n),
+				Uid:     proto.Int32(uid),
+			}
+		}
+	}
 	suid.Pkg = pkg
 	return nil
 }
@@ -16,6 +16,7 @@ package parseutils
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -1119,6 +1120,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			},
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
+				`CPU running app,service,1423000005000,1423000010000,UID 10054,10054`,
 				`CPU running,string,1423000005000,1423000010000,"1423000005000~289:bcmsdh_sdmmc:200:qcom,smd-rpm:240:msmgpio",`,
 				`Partial wakelock,service,1423000005000,1423000010000,com.google.android.apps.docs.editors.punch/com.google/XXX@google.com,`,
 			}, "\n"),
@@ -1155,6 +1157,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			},
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
+				`CPU running app,service,1423000005000,1423000015000,UID 10054,10054`,
 				`CPU running,string,1423000005000,1423000015000,"1423000005000~1423000010000~289:bcmsdh_sdmmc:200:qcom,smd-rpm:240:msmgpio",`,
 				`Partial wakelock,service,1423000010000,1423000015000,com.google.android.apps.docs.editors.punch/com.google/XXX@google.com,`,
 			}, "\n"),
@@ -1193,6 +1196,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			},
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
+				`CPU running app,service,1423000005000,1423000020000,UID 10054,10054`,
 				`CPU running,string,1423000005000,1423000020000,"1423000005000~1423000010000~289:bcmsdh_sdmmc:200:qcom,smd-rpm:240:msmgpio",`,
 				`Partial wakelock,service,1423000010000,1423000015000,com.google.android.apps.docs.editors.punch/com.google/XXX@google.com,`,
 			}, "\n"),
@@ -1231,6 +1235,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			},
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
+				`CPU running app,service,1423000005000,1423000020000,UID 10054,10054`,
 				`CPU running,string,1423000005000,1423000020000,"1423000005000~1423000010000~289:bcmsdh_sdmmc:200:qcom,smd-rpm:240:msmgpio",`,
 				`Partial wakelock,service,1423000010000,1423000020000,com.google.android.apps.docs.editors.punch/com.google/XXX@google.com,`,
 			}, "\n"),
@@ -1275,6 +1280,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			},
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
+				`CPU running app,service,1423000005000,1423000030000,UID 10054,10054`,
 				`CPU running,string,1423000005000,1423000030000,"1423000005000~1423000010000~289:bcmsdh_sdmmc:200:qcom,smd-rpm:240:msmgpio|1423000015000~1423000030000~Abort:some device prevented suspend :(",`,
 				`Partial wakelock,service,1423000010000,1423000015000,com.google.android.apps.docs.editors.punch/com.google/XXX@google.com,`,
 			}, "\n"),
@@ -1482,6 +1488,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
 				// Block 1
+				`CPU running app,service,1423000010000,1423000010500,UID 10017,10017`,
 				`CPU running,string,1423000010000,1423000010500,1423000010000~Abort:not important|1423000010500~Abort:important,`,
 				`Partial wakelock,service,1423000010000,1423000010500,GCM_READ,`,
 				// Block 2
@@ -1500,6 +1507,7 @@ func TestWakeupReasonParsing(t *testing.T) {
 				`Partial wakelock,service,1423000109000,1423000109500,bluetooth_timer,`,
 				`Partial wakelock,service,1423000110000,1423000110500,bluetooth_timer,`,
 				// Block 5
+				`CPU running app,service,1423000161000,1423000162000,BLUETOOTH,1002`,
 				`CPU running,string,1423000161000,1423000162000,1423000161000~Abort:not important|1423000161500~1423000162000~Abort:important|1423000162000~Abort:not important,`,
 				`Partial wakelock,service,1423000161000,1423000161500,bluetooth_timer,`,
 				// Block 6
@@ -1988,7 +1996,7 @@ func TestIdleModeParsing(t *testing.T) {
 				"Doze,string,1422620506000,1422620511000,light,",
 				"Doze,string,1422620511000,1422620515000,full,",
 				"Doze,string,1422620515000,1422620515500,off,",
-				"Reboot,bool,1422620515500,1422620530000,true,",
+				"Reboot,bool,1422620515500,1422620530000,true,clean",
 				"Doze,string,1422620531000,1422620531050,light,",
 				"Wifi full lock,bool,1422620531050,1422620531050,true,",
 			}, "\n"),
@@ -2124,7 +2132,7 @@ func TestCSVBoolEntry(t *testing.T) {
 			strings.Join([]string{
 				csv.FileHeader,
 				"Phone scanning,bool,1422620452417,1422620452917,true,",
-				"Reboot,bool,1422620452917,1430000000000,true,",
+				"Reboot,bool,1422620452917,1430000000000,true,clean",
 				"Phone scanning,bool,1430000001000,1430000003000,true,",
 			}, "\n"),
 		},
@@ -2142,7 +2150,7 @@ func TestCSVBoolEntry(t *testing.T) {
 			strings.Join([]string{
 				csv.FileHeader,
 				"Phone scanning,bool,1422620451417,1422620452417,true,",
-				"Reboot,bool,1422620452917,1430000000000,true,",
+				"Reboot,bool,1422620452917,1430000000000,true,clean",
 			}, "\n"),
 		},
 	}
@@ -2205,7 +2213,7 @@ func TestCSVIntEntry(t *testing.T) {
 				csv.FileHeader,
 				"Brightness,int,1422620452417,1422620453917,0,",
 				"Brightness,int,1422620453917,1422620454417,1,",
-				"Reboot,bool,1422620454417,1430000000000,true,",
+				"Reboot,bool,1422620454417,1430000000000,true,clean",
 				"Brightness,int,1430000001000,1430000003000,4,",
 				"Brightness,int,1430000003000,1430000003000,0,",
 			}, "\n"),
@@ -2269,7 +2277,7 @@ func TestCSVStringEntry(t *testing.T) {
 				csv.FileHeader,
 				"Mobile network type,string,1422620452417,1422620453917,hspa,",
 				"Mobile network type,string,1422620453917,1422620454417,lte,",
-				"Reboot,bool,1422620454417,1430000000000,true,",
+				"Reboot,bool,1422620454417,1430000000000,true,clean",
 				"Mobile network type,string,1430000001000,1430000003000,lte,",
 				"Mobile network type,string,1430000003000,1430000003000,hspap,",
 			}, "\n"),
@@ -2385,7 +2393,7 @@ func TestCSVServiceEntry(t *testing.T) {
 				csv.FileHeader,
 				`Wakelock_in,service,1422620454417,1422620456417,com.google.android.apps.docs/com.google/noogler@google.com,10051`,
 				`Wakelock_in,service,1422620452417,1422620456917,com.google.android.apps.docs.editors.punch/com.google/noogler@google.com,10054`,
-				`Reboot,bool,1422620456917,1430000000000,true,`,
+				`Reboot,bool,1422620456917,1430000000000,true,clean`,
 			}, "\n"),
 		},
 	}
@@ -2760,9 +2768,8 @@ func TestCSVRunningEntry(t *testing.T) {
 func normalizeCSV(text string) []string {
 	lines := strings.Split(strings.TrimSpace(text), "\n")
 
-	// Order of events generated might not be the same - if several transitions are open
-	// at a SHUTDOWN event, then we iterate through the open events and create csv entries.
-	// As iteration order of go maps is not defined, this may result a different order generated.
+	// Events are generated in the order they end, with the transitions still open at a SHUTDOWN
+	// event ordered by start time, metric and UID, which the expected CSVs aren't listed in.
 	sort.Strings(lines)
 
 	return lines
@@ -4230,14 +4237,15 @@ func TestPackageInstallParse(t *testing.T) {
 				`9,h,9962,Epi=8`,
 				`9,h,865,Epi=16`,
 			}, "\n"),
+			// The UIDs are version codes, so no app UID is known for the packages.
 			strings.Join([]string{
 				csv.FileHeader,
-				`Package install,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,28`,
-				`Package install,service,1432964322730,1432964322730,com.google.android.apps.interactiveevents,239`,
-				`Package install,service,1432964344800,1432964344800,com.google.android.apps.chromecast.app,10061`,
-				`Package install,service,1432964362191,1432964362191,com.google.android.apps.blogger,81`,
-				`Package install,service,1432964372153,1432964372153,com.google.android.apps.giant,119`,
-				`Package install,service,1432964373018,1432964373018,com.google.android.apps.vega,3900`,
+				`Package install,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,`,
+				`Package install,service,1432964322730,1432964322730,com.google.android.apps.interactiveevents,`,
+				`Package install,service,1432964344800,1432964344800,com.google.android.apps.chromecast.app,`,
+				`Package install,service,1432964362191,1432964362191,com.google.android.apps.blogger,`,
+				`Package install,service,1432964372153,1432964372153,com.google.android.apps.giant,`,
+				`Package install,service,1432964373018,1432964373018,com.google.android.apps.vega,`,
 			}, "\n"),
 			nil,
 		},
@@ -4251,7 +4259,7 @@ func TestPackageInstallParse(t *testing.T) {
 			}, "\n"),
 			strings.Join([]string{
 				csv.FileHeader,
-				`Package install,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,28`,
+				`Package install,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,`,
 			}, "\n"),
 			[]error{errors.New(`** Error in 9,h,22070,Epi=6 with Epi=6 : unable to find index "6" in idxMap for "Package install"`)},
 		},
@@ -4298,14 +4306,15 @@ func TestPackageUninstallParse(t *testing.T) {
 				`9,h,9962,Epu=8`,
 				`9,h,865,Epu=16`,
 			}, "\n"),
+			// The UIDs are version codes, so no app UID is known for the packages.
 			strings.Join([]string{
 				csv.FileHeader,
-				`Package uninstall,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,28`,
-				`Package uninstall,service,1432964322730,1432964322730,com.google.android.apps.interactiveevents,239`,
-				`Package uninstall,service,1432964344800,1432964344800,com.google.android.apps.chromecast.app,10061`,
-				`Package uninstall,service,1432964362191,1432964362191,com.google.android.apps.blogger,81`,
-				`Package uninstall,service,1432964372153,1432964372153,com.google.android.apps.giant,119`,
-				`Package uninstall,service,1432964373018,1432964373018,com.google.android.apps.vega,3900`,
+				`Package uninstall,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,`,
+				`Package uninstall,service,1432964322730,1432964322730,com.google.android.apps.interactiveevents,`,
+				`Package uninstall,service,1432964344800,1432964344800,com.google.android.apps.chromecast.app,`,
+				`Package uninstall,service,1432964362191,1432964362191,com.google.android.apps.blogger,`,
+				`Package uninstall,service,1432964372153,1432964372153,com.google.android.apps.giant,`,
+				`Package uninstall,service,1432964373018,1432964373018,com.google.android.apps.vega,`,
 			}, "\n"),
 			nil,
 		},
@@ -4319,7 +4328,7 @@ func TestPackageUninstallParse(t *testing.T) {
 			}, "\n"),
 			strings.Join([]string{
 				csv.FileHeader,
-				`Package uninstall,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,28`,
+				`Package uninstall,service,1432964316671,1432964316671,com.googlecode.eyesfree.brailleback,`,
 			}, "\n"),
 			[]error{fmt.Errorf(`** Error in 9,h,22070,Epu=6 with Epu=6 : unable to find index "6" in idxMap for "Package uninstall"`)},
 		},
@@ -4436,7 +4445,7 @@ func TestScreenWakeReasonParsing(t *testing.T) {
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
 				`Screen,bool,1437433550500,1437433551000,true,android.server.wm:TURN_ON`,
-				`Reboot,bool,1437433551000,1437433551000,true,`,
+				`Reboot,bool,1437433551000,1437433551000,true,clean`,
 			}, "\n"),
 		},
 		{
@@ -4456,7 +4465,7 @@ func TestScreenWakeReasonParsing(t *testing.T) {
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
 				`Screen,bool,1437433550500,1437433551000,true,android.server.wm:TURN_ON`,
-				`Reboot,bool,1437433551000,1437433551500,true,`,
+				`Reboot,bool,1437433551000,1437433551500,true,clean`,
 				`Screen,bool,1437433561500,1437433562500,true,android.policy:POWER`,
 			}, "\n"),
 		},
@@ -4472,7 +4481,7 @@ func TestScreenWakeReasonParsing(t *testing.T) {
 			wantCSV: strings.Join([]string{
 				csv.FileHeader,
 				`Screen,bool,1437433550500,1437433551000,true,unknown screen on reason`,
-				`Reboot,bool,1437433551000,1437433551000,true,`,
+				`Reboot,bool,1437433551000,1437433551000,true,clean`,
 			}, "\n"),
 		},
 		{
@@ -4707,13 +4716,18 @@ func TestDPSTDCPUParse(t *testing.T) {
 		`Highest App CPU Usage,summary,1422620451417,1422620452417,ANDROID_SYSTEM~32.93s~19.83s,1000`,
 		`Highest App CPU Usage,summary,1422620451417,1422620452417,ROOT~9.85s~23.18s,0`,
 		`Highest App CPU Usage,summary,1422620451417,1422620452417,com.google.android.keep~21.72s~5.57s,10019`,
+		// (176140+62360) / 498300 busy and 14690 / 498300 iowait.
+		`CPU busy fraction,float,1422620451417,1422620452417,0.479,`,
+		`CPU iowait fraction,float,1422620451417,1422620452417,0.029,`,
 		`Battery Level,int,1422620455417,1422620460417,98,`,
 		// 99->98 drop.
 		`Highest App CPU Usage,summary,1422620452417,1422620455417,ANDROID_SYSTEM~9.865s~28.63s,1000`,
 		`Highest App CPU Usage,summary,1422620452417,1422620455417,ROOT~5.07s~15.025s,0`,
 		`Highest App CPU Usage,summary,1422620452417,1422620455417,UID 10010~357ms~3.2s,10010`,
+		`CPU busy fraction,float,1422620452417,1422620455417,0.181,`,
+		`CPU iowait fraction,float,1422620452417,1422620455417,0.012,`,
 		`Battery Level,int,1422620460417,1422620461417,97,`,
-		// None for the 98->97 drop.
+		// None for the 98->97 drop, and no fractions for the empty Dpst.
 		`Screen,bool,1422620461417,1422620461417,true,unknown screen on reason`,
 	}, "\n")
 
@@ -4831,11 +4845,38 @@ func TestParsePowerStates(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "MediaTek SPM states",
+			input: `spm_1 name=suspend time=264740 count=85 spm_2 name=dpidle time=3149 count=286 spm_3 name=sodi3 time=0 count=0`,
+			wantStates: []*PowerState{
+				{
+					Level: 1,
+					Name:  `suspend`,
+					Time:  264740 * time.Millisecond,
+					Count: 85,
+				},
+				{
+					Level: 2,
+					Name:  `dpidle`,
+					Time:  3149 * time.Millisecond,
+					Count: 286,
+				},
+				{
+					Level: 3,
+					Name:  `sodi3`,
+				},
+			},
+		},
 		{
 			name:    "invalid input",
 			input:   `9,h,1000,-w`,
 			wantErr: errors.New(`invalid power_state line: "9,h,1000,-w"`),
 		},
+		{
+			name:    "invalid MediaTek input",
+			input:   `spm_1 name=suspend`,
+			wantErr: errors.New(`invalid power_state line: "spm_1 name=suspend"`),
+		},
 	}
 
 	for _, test := range tests {
@@ -5173,6 +5214,13 @@ func TestPowerStateParsing(t *testing.T) {
 				`XO_shutdown(APSS),float,1422620180000,1422620180000,0.150,`,
 				`XO_shutdown(LPASS),float,1422620180000,1422620180000,0.050,`,
 				`XO_shutdown(MPSS),float,1422620180000,1422620180000,0.100,`,
+				// Each Dpst line covers the battery level step before it.
+				`CPU busy fraction,float,1422620000000,1422620060000,0.659,`,
+				`CPU iowait fraction,float,1422620000000,1422620060000,0.008,`,
+				`CPU busy fraction,float,1422620060000,1422620120000,0.659,`,
+				`CPU iowait fraction,float,1422620060000,1422620120000,0.008,`,
+				`CPU busy fraction,float,1422620120000,1422620180000,0.659,`,
+				`CPU iowait fraction,float,1422620120000,1422620180000,0.008,`,
 				`RPM Stats,group,1422620000000,1422620000000,XO_shutdown|XO_shutdown(APSS)|XO_shutdown(MPSS)|XO_shutdown(LPASS)|VMIN,minutes`,
 			}, "\n"),
 		},
@@ -5848,6 +5896,119 @@ func TestUIDAndPackageNameMappingAndMatching(t *testing.T) {
 	}
 }
 
+// TestMatchServiceWithProfileNames tests that packages of secondary user UIDs are qualified with the profile when profile names are enabled.
+func TestMatchServiceWithProfileNames(t *testing.T) {
+	pkgs := []*usagepb.PackageInfo{
+		{
+			PkgName: proto.String("com.google.android.gm"),
+			Uid:     proto.Int32(10023),
+		},
+	}
+	tests := []struct {
+		desc         string
+		profileNames bool
+		uid          string
+		wantPkg      *usagepb.PackageInfo
+	}{
+		{
+			desc: "Secondary user merged with primary user by default",
+			uid:  "1010023",
+			wantPkg: &usagepb.PackageInfo{
+				PkgName: proto.String("com.google.android.gm"),
+				Uid:     proto.Int32(10023),
+			},
+		},
+		{
+			desc:         "Primary user not qualified",
+			profileNames: true,
+			uid:          "10023",
+			wantPkg: &usagepb.PackageInfo{
+				PkgName: proto.String("com.google.android.gm"),
+				Uid:     proto.Int32(10023),
+			},
+		},
+		{
+			desc:         "Secondary user UID",
+			profileNames: true,
+			uid:          "1010023",
+			wantPkg: &usagepb.PackageInfo{
+				PkgName: proto.String("com.google.android.gm (work)"),
+				Uid:     proto.Int32(10023),
+			},
+		},
+		{
+			desc:         "Abbreviated secondary user UID",
+			profileNames: true,
+			uid:          "u10a23",
+			wantPkg: &usagepb.PackageInfo{
+				PkgName: proto.String("com.google.android.gm (work)"),
+				Uid:     proto.Int32(10023),
+			},
+		},
+	}
+	for _, test := range tests {
+		upm, errs := UIDAndPackageNameMapping("", pkgs)
+		if len(errs) > 0 {
+			t.Fatalf("UIDAndPackageNameMapping generated unexpected errors: %v", errs)
+		}
+		upm.SetProfileNames(test.profileNames)
+		suid := &ServiceUID{Service: `"com.google.android.gm"`, UID: test.uid}
+		if err := upm.matchServiceWithPackageInfo(suid); err != nil {
+			t.Errorf("%v: error encountered when matching: %v", test.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.wantPkg, suid.Pkg) {
+			t.Errorf("%v: didn't get expected package:\n  got: %v\n  want: %v", test.desc, suid.Pkg, test.wantPkg)
+		}
+	}
+	if got := pkgs[0].GetPkgName(); got != "com.google.android.gm" {
+		t.Errorf("Package list modified when matching, got package name %q", got)
+	}
+}
+
+// TestInferredPackages tests that packages are inferred from the service strings when there is no
+// checkin log or package list.
+func TestInferredPackages(t *testing.T) {
+	tests := []struct {
+		desc    string
+		checkin string
+		service string
+		wantPkg *usagepb.PackageInfo
+	}{
+		{
+			desc:    "Sync wakelock",
+			service: `"*sync*/com.google.android.gmail.provider/com.google/XXX@gmail.com"`,
+			wantPkg: &usagepb.PackageInfo{
+				PkgName: proto.String("com.google.android.gm"),
+				Uid:     proto.Int32(10045),
+			},
+		},
+		{
+			desc:    "Not a package name",
+			service: `"NlpWakeLock"`,
+		},
+		{
+			desc:    "Checkin log available",
+			checkin: `9,10011,l,apk,1,com.example.other,...`,
+			service: `"*sync*/com.google.android.gmail.provider/com.google/XXX@gmail.com"`,
+		},
+	}
+	for _, test := range tests {
+		upm, errs := UIDAndPackageNameMapping(test.checkin, nil)
+		if len(errs) > 0 {
+			t.Fatalf("%v: UIDAndPackageNameMapping generated unexpected errors: %v", test.desc, errs)
+		}
+		suid := &ServiceUID{Service: test.service, UID: "1010045"}
+		if err := upm.matchServiceWithPackageInfo(suid); err != nil {
+			t.Errorf("%v: error encountered when matching: %v", test.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.wantPkg, suid.Pkg) {
+			t.Errorf("%v: didn't get expected package:\n  got: %v\n  want: %v", test.desc, suid.Pkg, test.wantPkg)
+		}
+	}
+}
+
 func TestTopAppSummary(t *testing.T) {
 	input := strings.Join([]string{
 		`9,hsp,0,10031,"com.google.android.googlequicksearchbox"`,
@@ -6039,3 +6200,312 @@ func TestOverflow(t *testing.T) {
 		t.Errorf("AnalyzeHistory(%v) generated incorrect csv:\n  got: %q\n  want: %q", input, got, want)
 	}
 }
+
+// TestMobileRadioAppAttribution tests that mobile radio active periods are charged to the app that woke up the radio.
+func TestMobileRadioAppAttribution(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,1,10066,"com.google.android.apps.messaging"`,
+		`9,hsp,2,10031,""`,
+		`9,h,0:RESET:TIME:1456809000000`,
+		`9,h,1000,Ewa=1,+Pr`, // Messaging wakes up the radio.
+		`9,h,2000,Ewa=2`,     // Radio is already charged to messaging.
+		`9,h,3000,-Pr`,
+		`9,h,1000,+Pr`, // No app woke up the radio yet.
+		`9,h,500,Ewa=2`,
+		`9,h,1500,-Pr`,
+		`9,h,1000,+Pr`, // Woken up by the network, without an AP wakeup, so not attributed.
+		`9,h,2000,-Pr`,
+	}, "\n")
+	want := map[string]Dist{
+		`"com.google.android.apps.messaging"`: {
+			Num:           1,
+			TotalDuration: 5000 * time.Millisecond,
+			MaxDuration:   5000 * time.Millisecond,
+		},
+		`"UID 10031"`: {
+			Num:           1,
+			TotalDuration: 2000 * time.Millisecond,
+			MaxDuration:   2000 * time.Millisecond,
+		},
+	}
+	wantCSV := strings.Join([]string{
+		csv.FileHeader,
+		`App Processor wakeup,service,1456809001000,1456809001000,com.google.android.apps.messaging,10066`,
+		`App Processor wakeup,service,1456809003000,1456809003000,,10031`,
+		`Mobile radio active app,service,1456809001000,1456809006000,com.google.android.apps.messaging,10066`,
+		`Mobile radio active,bool,1456809001000,1456809006000,true,`,
+		`App Processor wakeup,service,1456809007500,1456809007500,,10031`,
+		`Mobile radio active app,service,1456809007000,1456809009000,UID 10031,10031`,
+		`Mobile radio active,bool,1456809007000,1456809009000,true,`,
+		`Mobile radio active,bool,1456809010000,1456809012000,true,`,
+	}, "\n")
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	validateHistory(input, t, result, 0, 1)
+
+	if got := result.Summaries[0].MobileRadioActiveAppSummary; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].MobileRadioActiveAppSummary = %v, want %v", input, got, want)
+	}
+	gotCSV := normalizeCSV(b.String())
+	wantCSVNormalized := normalizeCSV(wantCSV)
+	if !reflect.DeepEqual(gotCSV, wantCSVNormalized) {
+		t.Errorf("AnalyzeHistory(%s,...) generated incorrect csv:\n  got: %q\n  want: %q", input, gotCSV, wantCSVNormalized)
+	}
+}
+
+// TestBodyStateSummary tests the parsing of wearable on and off body events, and the drain rates in each state.
+func TestBodyStateSummary(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,3,1000,"android"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=90,Bs=d`,
+		`9,h,1000,Bl=89`, // Body state unknown.
+		`9,h,1000,+Ewd=3`,
+		`9,h,10000,Bl=88`,
+		`9,h,10000,Bl=86`,
+		`9,h,10000,-Ewd=3`,
+		`9,h,5000,Bl=85`,
+	}, "\n")
+	wantSummary := map[string]Dist{
+		"on":  {Num: 1, TotalDuration: 30 * time.Second, MaxDuration: 30 * time.Second},
+		"off": {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+	}
+	wantDrop := map[string]int{
+		"on":  3,
+		"off": 1,
+	}
+	wantCSV := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,1000000,1001000,90,`,
+		`Battery Level,int,1001000,1012000,89,`,
+		`Battery Level,int,1012000,1022000,88,`,
+		`Battery Level,int,1022000,1037000,86,`,
+		`On body,string,1002000,1032000,on,`,
+		`Battery Level,int,1037000,1037000,85,`,
+		`On body,string,1032000,1037000,off,`,
+		`Charging status,string,1000000,1037000,d,`,
+	}, "\n")
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	validateHistory(input, t, result, 0, 1)
+
+	s := result.Summaries[0]
+	if !reflect.DeepEqual(s.BodyStateSummary, wantSummary) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].BodyStateSummary = %v, want %v", input, s.BodyStateSummary, wantSummary)
+	}
+	if !reflect.DeepEqual(s.BodyStateLevelDrop, wantDrop) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].BodyStateLevelDrop = %v, want %v", input, s.BodyStateLevelDrop, wantDrop)
+	}
+	gotCSV := normalizeCSV(b.String())
+	wantCSVNormalized := normalizeCSV(wantCSV)
+	if !reflect.DeepEqual(gotCSV, wantCSVNormalized) {
+		t.Errorf("AnalyzeHistory(%s,...) generated incorrect csv:\n  got: %q\n  want: %q", input, gotCSV, wantCSVNormalized)
+	}
+}
+
+// TestCPURunningAttribution tests that CPU running periods are attributed to the only app holding wakelocks during them.
+func TestCPURunningAttribution(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,1,10066,"*alarm*"`,
+		`9,hsp,2,10066,"GCM_READ"`,
+		`9,hsp,3,1000,"bluetooth_timer"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=90,Bs=d`,
+		`9,h,1000,+r,+w=1`, // Only UID 10066 holds wakelocks.
+		`9,h,1000,-w`,
+		`9,h,0,+w=2`,
+		`9,h,1000,-r,-w`,
+		`9,h,1000,+r,+w=1`, // Both UID 10066 and 1000 hold wakelocks.
+		`9,h,1000,-w`,
+		`9,h,0,+w=3`,
+		`9,h,1000,-r,-w`,
+		`9,h,1000,+r`, // No wakelocks held.
+		`9,h,1000,-r`,
+	}, "\n")
+	want := map[string]Dist{
+		`"UID 10066"`: {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+	}
+	wantCSV := strings.Join([]string{
+		csv.FileHeader,
+		`Partial wakelock,service,1001000,1002000,*alarm*,`,
+		`Partial wakelock,service,1002000,1003000,GCM_READ,`,
+		`CPU running app,service,1001000,1003000,UID 10066,10066`,
+		`CPU running,string,1001000,1003000,1001000~1003000~` + csv.UnknownWakeup + `,`,
+		`Partial wakelock,service,1004000,1005000,*alarm*,`,
+		`Partial wakelock,service,1005000,1006000,bluetooth_timer,`,
+		`CPU running,string,1004000,1006000,1004000~1006000~` + csv.UnknownWakeup + `,`,
+		`CPU running,string,1007000,1008000,1007000~1008000~` + csv.UnknownWakeup + `,`,
+		`Charging status,string,1000000,1008000,d,`,
+		`Battery Level,int,1000000,1008000,90,`,
+	}, "\n")
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	validateHistory(input, t, result, 0, 1)
+
+	if got := result.Summaries[0].AttributedCPURunningSummary; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].AttributedCPURunningSummary = %v, want %v", input, got, want)
+	}
+	gotCSV := normalizeCSV(b.String())
+	wantCSVNormalized := normalizeCSV(wantCSV)
+	if !reflect.DeepEqual(gotCSV, wantCSVNormalized) {
+		t.Errorf("AnalyzeHistory(%s,...) generated incorrect csv:\n  got: %q\n  want: %q", input, gotCSV, wantCSVNormalized)
+	}
+}
+
+// TestAnalyzeHistorySummariesOnly tests that analyzing the history without a CSV writer computes the same summaries.
+func TestAnalyzeHistorySummariesOnly(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,1,10066,"*alarm*"`,
+		`9,hsp,2,1000,"bluetooth_timer"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=90,Bs=d,+S`,
+		`9,h,1000,+r,+w=1,Wr=1`,
+		`9,h,1000,-w,Bl=89`,
+		`9,h,0,+w=2,-S`,
+		`9,h,1000,-r,-w`,
+		`9,h,0:START`,
+		`9,h,0:TIME:1010000`,
+		`9,h,1000,Bl=88,+r`,
+		`9,h,1000,-r`,
+	}, "\n")
+	for _, format := range []string{FormatTotalTime, FormatBatteryLevel} {
+		var b bytes.Buffer
+		want := AnalyzeHistory(&b, input, format, emptyUIDPackageMapping, true)
+		got := AnalyzeHistory(nil, input, format, emptyUIDPackageMapping, true)
+		if !reflect.DeepEqual(got.Summaries, want.Summaries) {
+			t.Errorf("AnalyzeHistory(nil, %s, %s,...) summaries\n got: %v\n want: %v", input, format, got.Summaries, want.Summaries)
+		}
+		if !reflect.DeepEqual(got.Errs, want.Errs) {
+			t.Errorf("AnalyzeHistory(nil, %s, %s,...) errors\n got: %v\n want: %v", input, format, got.Errs, want.Errs)
+		}
+	}
+}
+
+// TestAnalyzeHistoryLineIndex tests that CSV entries are traced back to the history lines they were read from.
+func TestAnalyzeHistoryLineIndex(t *testing.T) {
+	input := strings.Join([]string{
+		`== dumpstate: 2015-01-30 12:00:00`,
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,1000,+S`,
+		`Not a history line`,
+		`9,h,2000,-S,+r`,
+		`9,h,1000,-r`,
+	}, "\n")
+
+	want := strings.Join([]string{
+		csv.LineIndexHeader,
+		`Screen,1422620452417,1422620454417,true,4,6`,
+		`CPU running,1422620454417,1422620455417,1422620454417~1422620455417~Unknown wakeup reason,6,7`,
+	}, "\n")
+
+	var b, index bytes.Buffer
+	result := AnalyzeHistoryWithOptions(&b, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{LineIndex: &index})
+	validateHistory(input, t, result, 0, 1)
+	if got := strings.TrimSpace(index.String()); got != want {
+		t.Errorf("AnalyzeHistoryWithOptions(%s,...) wrote incorrect line index:\n  got: %s\n  want: %s", input, got, want)
+	}
+}
+
+// TestAnalyzeHistoryContext tests that the analysis stops once the context is done.
+func TestAnalyzeHistoryContext(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=50,Bs=d,Bh=g,Bp=n,Bt=300,Bv=3800`,
+		`9,h,1000,+S`,
+		`9,h,1000,Bl=49`,
+	}, "\n")
+
+	var b bytes.Buffer
+	result := AnalyzeHistoryContext(context.Background(), &b, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{})
+	validateHistory(input, t, result, 0, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result = AnalyzeHistoryContext(ctx, &b, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{})
+	if n := len(result.Errs); n == 0 || result.Errs[n-1] != context.Canceled {
+		t.Errorf("AnalyzeHistoryContext(canceled, %s,...) generated errors %v, want them to end with %v", input, result.Errs, context.Canceled)
+	}
+	if len(result.Summaries) > 0 {
+		t.Errorf("AnalyzeHistoryContext(canceled, %s,...) generated %d summaries, want none", input, len(result.Summaries))
+	}
+}
+
+// TestAnalyzeHistoryProgress tests that the progress of the analysis is reported until all lines are analyzed.
+func TestAnalyzeHistoryProgress(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=50,Bs=d,Bh=g,Bp=n,Bt=300,Bv=3800`,
+		`9,h,1000,+S`,
+		`9,h,1000,Bl=49`,
+	}, "\n")
+
+	var got [][2]int
+	AnalyzeHistoryWithOptions(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{
+		Progress: func(done, total int) { got = append(got, [2]int{done, total}) },
+	})
+	want := [][2]int{{0, 5}, {5, 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistoryWithOptions(%s,...) reported progress %v, want %v", input, got, want)
+	}
+}
+
+// TestAnalyzeHistorySummarizeCharging tests that charging periods are only summarized with the SummarizeCharging option.
+func TestAnalyzeHistorySummarizeCharging(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=50,Bs=d,Bh=g,Bp=n,Bt=300,Bv=3800`,
+		`9,h,1000,+S`,
+		`9,h,1000,Bl=49`,
+		`9,h,1000,Bs=c,Bp=a`,
+		`9,h,1000,Bl=50`,
+		`9,h,1000,Bs=d,Bp=n`,
+		`9,h,1000,-S`,
+		`9,h,1000,Bl=49`,
+	}, "\n")
+
+	type summary struct {
+		start, end int64
+		reason     string
+		charging   bool
+	}
+	tests := []struct {
+		desc      string
+		summarize bool
+		want      []summary
+	}{
+		{
+			desc: "Discharge intervals only",
+			want: []summary{
+				{1422620451417, 1422620454417, "CHARGING", false},
+				{1422620456417, 1422620458417, "END", false},
+			},
+		},
+		{
+			desc:      "Charging periods summarized",
+			summarize: true,
+			want: []summary{
+				{1422620451417, 1422620454417, "CHARGING", false},
+				{1422620454417, 1422620456417, "DISCHARGING", true},
+				{1422620456417, 1422620458417, "END", false},
+			},
+		},
+	}
+	for _, test := range tests {
+		result := AnalyzeHistoryWithOptions(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, false, HistoryOptions{SummarizeCharging: test.summarize})
+		validateHistory(input, t, result, 0, len(test.want))
+		var got []summary
+		for _, s := range result.Summaries {
+			got = append(got, summary{s.StartTimeMs, s.EndTimeMs, s.Reason, s.Charging})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: AnalyzeHistoryWithOptions(...) generated incorrect summaries:\n  got: %v\n  want: %v", test.desc, got, test.want)
+		}
+	}
+}
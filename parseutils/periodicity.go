@@ -0,0 +1,240 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// periodicity.go detects periodic wakeups, such as app heartbeats, from the autocorrelation of
+// the CPU running start times and alarm firings in the generated battery history CSV.
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// Periodicity detection parameters.
+	minPeriod = 10 * time.Second
+	maxPeriod = time.Hour
+	// periodBin is the resolution of the detected periods. Wakeups are rarely exact, as alarms are batched.
+	periodBin = 5 * time.Second
+	// minPeriodicOccurrences is the minimum number of times the period must be seen between events.
+	minPeriodicOccurrences = 5
+	// minPeriodicScore is how many times more often the period must be seen than expected for random events.
+	minPeriodicScore = 3
+	// maxPeriodicPatterns is the maximum number of patterns reported per source.
+	maxPeriodicPatterns = 5
+	// periodTolerance is the fraction of the period by which a culprit's intervals can differ from it.
+	periodTolerance = 0.05
+
+	// alarm is the battery history CSV metric for alarms going off.
+	alarm = "Alarm"
+)
+
+// periodicSources are the battery history CSV metrics whose start times are analyzed for periodicity.
+var periodicSources = []string{cpuRunning, alarm}
+
+// periodicCulpritMetrics are the battery history CSV metrics searched for the app responsible for a pattern.
+var periodicCulpritMetrics = []string{alarm, "Wakelock_in", "JobScheduler", "SyncManager"}
+
+// PeriodicPattern is a period at which events of a metric repeat more often than they would at random.
+type PeriodicPattern struct {
+	// Source is the battery history CSV metric the pattern was found in, e.g. "CPU running".
+	Source string
+	Period time.Duration
+	// Occurrences is the number of pairs of events separated by about the period.
+	Occurrences int
+	// Score is how many times more often the period was seen than expected for random events.
+	Score float64
+	// Culprit is the alarm, wakelock, job or sync whose events most often repeat at the period, empty if none.
+	Culprit       string
+	CulpritMetric string
+	CulpritUID    string
+}
+
+// String returns a readable description of the pattern, e.g. "CPU running every 15m0s (Alarm: *walarm*:com.example.HEARTBEAT)".
+func (p PeriodicPattern) String() string {
+	s := fmt.Sprintf("%s every %v", p.Source, p.Period)
+	if p.Culprit != "" {
+		s += fmt.Sprintf(" (%s: %s)", p.CulpritMetric, p.Culprit)
+	}
+	return s
+}
+
+// byScore sorts patterns in descending order of score, then by period.
+type byScore []PeriodicPattern
+
+func (a byScore) Len() int      { return len(a) }
+func (a byScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScore) Less(i, j int) bool {
+	if a[i].Score != a[j].Score {
+		return a[i].Score > a[j].Score
+	}
+	return a[i].Period < a[j].Period
+}
+
+// startTimes returns the sorted start times of the events.
+func startTimes(events []csv.Event) []int64 {
+	ts := make([]int64, len(events))
+	for i, e := range events {
+		ts[i] = e.Start
+	}
+	sort.Sort(int64s(ts))
+	return ts
+}
+
+// int64s sorts int64 values in ascending order.
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
+
+// lagHistogram returns the autocorrelation of the event times, as the number of pairs of events
+// separated by each lag, in periodBin bins up to maxPeriod.
+func lagHistogram(ts []int64) []int {
+	binMs := int64(periodBin / time.Millisecond)
+	maxMs := int64(maxPeriod / time.Millisecond)
+	h := make([]int, maxMs/binMs+1)
+	for i, t := range ts {
+		for _, u := range ts[i+1:] {
+			d := u - t
+			if d > maxMs {
+				break
+			}
+			// Round to the nearest bin.
+			h[(d+binMs/2)/binMs]++
+		}
+	}
+	return h
+}
+
+// isMultiple returns whether the period is close to a multiple of one of the found periods.
+func isMultiple(p time.Duration, found []PeriodicPattern) bool {
+	for _, f := range found {
+		n := math.Round(float64(p) / float64(f.Period))
+		if n >= 2 && math.Abs(float64(p)-n*float64(f.Period)) <= math.Max(float64(periodBin), periodTolerance*float64(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectPeriods returns the periods at which the event times repeat more often than random, sorted by
+// descending score. Multiples of a stronger period, which are a consequence of it, are not returned.
+func detectPeriods(source string, ts []int64) []PeriodicPattern {
+	if len(ts) < minPeriodicOccurrences+1 {
+		return nil
+	}
+	span := ts[len(ts)-1] - ts[0]
+	if span <= 0 {
+		return nil
+	}
+	h := lagHistogram(ts)
+	binMs := int64(periodBin / time.Millisecond)
+	// For random events, each event is followed by rate*binMs events in any bin.
+	expected := float64(len(ts)) * float64(len(ts)) / float64(span) * float64(binMs)
+
+	var candidates []PeriodicPattern
+	for i := int(minPeriod / periodBin); i < len(h); i++ {
+		// Only local maxima are candidates, so jitter across neighbouring bins isn't reported twice.
+		if h[i] < minPeriodicOccurrences || (i > 0 && h[i-1] > h[i]) || (i+1 < len(h) && h[i+1] >= h[i]) {
+			continue
+		}
+		// Include the neighbouring bins, as the events are rarely exactly periodic.
+		n := h[i]
+		if i > 0 {
+			n += h[i-1]
+		}
+		if i+1 < len(h) {
+			n += h[i+1]
+		}
+		score := float64(n) / (3 * expected)
+		if score < minPeriodicScore {
+			continue
+		}
+		candidates = append(candidates, PeriodicPattern{
+			Source:      source,
+			Period:      time.Duration(int64(i)*binMs) * time.Millisecond,
+			Occurrences: n,
+			Score:       score,
+		})
+	}
+	sort.Sort(byScore(candidates))
+	var found []PeriodicPattern
+	for _, c := range candidates {
+		if len(found) == maxPeriodicPatterns {
+			break
+		}
+		if !isMultiple(c.Period, found) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// periodicCount returns the number of consecutive intervals between the event times that are
+// within the tolerance of the period.
+func periodicCount(ts []int64, p time.Duration) int {
+	pMs := float64(p / time.Millisecond)
+	tol := math.Max(float64(periodBin/time.Millisecond), periodTolerance*pMs)
+	n := 0
+	for i := 1; i < len(ts); i++ {
+		if math.Abs(float64(ts[i]-ts[i-1])-pMs) <= tol {
+			n++
+		}
+	}
+	return n
+}
+
+// PeriodicWakeups detects periodic CPU wakeups and alarm firings in the battery history CSV generated
+// by AnalyzeHistory, and names the alarm, wakelock, job or sync most likely responsible for each.
+// Patterns are sorted by source, then by descending score.
+func PeriodicWakeups(csvInput string) ([]PeriodicPattern, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append(append([]string(nil), periodicSources...), periodicCulpritMetrics...))
+
+	// Group the culprit events by metric, name and UID.
+	type culprit struct {
+		metric, name, uid string
+	}
+	var culprits []culprit
+	culpritTimes := make(map[culprit][]csv.Event)
+	for _, m := range periodicCulpritMetrics {
+		for _, e := range es[m] {
+			c := culprit{m, e.Value, e.Opt}
+			if _, ok := culpritTimes[c]; !ok {
+				culprits = append(culprits, c)
+			}
+			culpritTimes[c] = append(culpritTimes[c], e)
+		}
+	}
+
+	var patterns []PeriodicPattern
+	for _, src := range periodicSources {
+		for _, p := range detectPeriods(src, startTimes(es[src])) {
+			best := 0
+			for _, c := range culprits {
+				if n := periodicCount(startTimes(culpritTimes[c]), p.Period); n >= minPeriodicOccurrences && n > best {
+					best = n
+					p.Culprit, p.CulpritMetric, p.CulpritUID = c.name, c.metric, c.uid
+				}
+			}
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, errs
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestPeriodicWakeups tests the detection of periodic wakeups and the attribution to the responsible alarm.
+func TestPeriodicWakeups(t *testing.T) {
+	lines := []string{csv.FileHeader}
+	// A heartbeat every 15 minutes, with up to 2s of jitter, over 4 hours.
+	for i := int64(0); i < 16; i++ {
+		start := i*900000 + (i%3)*1000
+		lines = append(lines,
+			fmt.Sprintf("CPU running,string,%d,%d,,", start, start+3000),
+			fmt.Sprintf(`Wakelock_in,service,%d,%d,"*alarm*:com.example.HEARTBEAT",10050`, start+100, start+2000),
+		)
+		// An unrelated wakelock held at random times.
+		rnd := start + 77000*(i%5) + 13000
+		lines = append(lines, fmt.Sprintf(`Wakelock_in,service,%d,%d,"*alarm*:com.example.OTHER",10060`, rnd, rnd+1000))
+	}
+	// Wakeups at irregular times, which shouldn't be reported.
+	for _, start := range []int64{123000, 2345000, 3456000, 4567000, 5678000, 7891000, 9012000, 11234000, 13001000} {
+		lines = append(lines, fmt.Sprintf("CPU running,string,%d,%d,,", start, start+500))
+	}
+	got, errs := PeriodicWakeups(strings.Join(lines, "\n"))
+	if len(errs) > 0 {
+		t.Fatalf("PeriodicWakeups generated unexpected errors: %v", errs)
+	}
+	if len(got) != 1 {
+		t.Fatalf("PeriodicWakeups got %d patterns, want 1: %v", len(got), got)
+	}
+	want := PeriodicPattern{
+		Source:        cpuRunning,
+		Period:        15 * time.Minute,
+		Occurrences:   15,
+		Culprit:       "*alarm*:com.example.HEARTBEAT",
+		CulpritMetric: "Wakelock_in",
+		CulpritUID:    "10050",
+	}
+	// The score depends on the number of events, so isn't compared exactly.
+	if got[0].Score < minPeriodicScore {
+		t.Errorf("PeriodicWakeups got score %v, want at least %v", got[0].Score, minPeriodicScore)
+	}
+	got[0].Score = 0
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("PeriodicWakeups\n got: %v\n want: %v", got[0], want)
+	}
+}
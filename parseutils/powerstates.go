@@ -0,0 +1,209 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// powerstates.go parses the low power state stats that the platform prints in the battery history.
+// Each SoC vendor has its own format, handled by a PowerStateProvider selected by detection.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// powerStatesKey is the history key the low power state entries are handled under, whatever the vendor format.
+	powerStatesKey = "state_1"
+)
+
+// PowerStateProvider parses the low power state stats of a SoC vendor format.
+type PowerStateProvider interface {
+	// Detect returns whether the battery history entry is in the provider's format.
+	Detect(entry string) bool
+	// Parse returns the low power states in the entry. Times and counts are aggregated since boot.
+	Parse(entry string) ([]*PowerState, error)
+}
+
+// powerStateProviders are the providers tried in order to parse low power state entries.
+var powerStateProviders = []PowerStateProvider{
+	qcomPowerStates{},
+	mtkPowerStates{},
+}
+
+// RegisterPowerStateProvider adds a provider for another vendor format. It is tried after the
+// built in providers, so it should not detect the Qualcomm or MediaTek formats.
+// It is not safe to call concurrently with parsing.
+func RegisterPowerStateProvider(p PowerStateProvider) {
+	powerStateProviders = append(powerStateProviders, p)
+}
+
+// powerStateProviderFor returns the provider for the battery history entry, or nil if the entry
+// isn't a low power state entry.
+func powerStateProviderFor(entry string) PowerStateProvider {
+	for _, p := range powerStateProviders {
+		if p.Detect(entry) {
+			return p
+		}
+	}
+	return nil
+}
+
+// parsePowerStates parses a full power state line, in any of the supported vendor formats.
+func parsePowerStates(input string) ([]*PowerState, error) {
+	p := powerStateProviderFor(input)
+	if p == nil {
+		return nil, fmt.Errorf("invalid power_state line: %q", input)
+	}
+	return p.Parse(input)
+}
+
+// Regular expressions to match different sections of the low power state output.
+const (
+	voterREString = `voter_\d+\s+name=(?P<name>\S+)\s+time=(?P<time>\d+)\s+count=(?P<count>\d+)\s*`
+	stateREString = `state_(?P<idx>\d+)\s+name=(?P<name>\S+)\s+time=(?P<time>\d+)\s+count=(?P<count>\d+)\s*`
+)
+
+var (
+	voterRE          = regexp.MustCompile(voterREString)
+	stateRE          = regexp.MustCompile(stateREString)
+	fullPowerStateRE = regexp.MustCompile(stateREString + `\s*(?P<voters>(` + voterREString + `)*)`)
+
+	// qcomDetectRE matches the start of a Qualcomm RPM stats entry.
+	qcomDetectRE = regexp.MustCompile(`^state_1\b`)
+
+	// mtkDetectRE matches the start of a MediaTek SPM stats entry.
+	mtkDetectRE = regexp.MustCompile(`^spm_1\b`)
+	// mtkStateRE matches a single MediaTek SPM low power state.
+	mtkStateRE = regexp.MustCompile(`spm_(?P<idx>\d+)\s+name=(?P<name>\S+)\s+time=(?P<time>\d+)\s+count=(?P<count>\d+)`)
+)
+
+// qcomPowerStates parses the Qualcomm RPM stats format.
+// Example format:
+// state_1 name=XO_shutdown time=0 count=0 voter_1 name=APSS time=264740801 count=85367 voter_2 name=MPSS time=314921409 count=286147 voter_3 name=LPASS time=339626342 count=96649 state_2 name=VMIN time=245626000 count=289658
+// Times are printed in milliseconds.
+type qcomPowerStates struct{}
+
+// Detect returns whether the entry starts with the first RPM state.
+func (qcomPowerStates) Detect(entry string) bool {
+	return qcomDetectRE.MatchString(entry)
+}
+
+// Parse returns the RPM states and their voters in the entry.
+func (qcomPowerStates) Parse(input string) ([]*PowerState, error) {
+	split := fullPowerStateRE.FindAllString(input, -1)
+	if len(split) == 0 {
+		return nil, fmt.Errorf("invalid power_state line: %q", input)
+	}
+	var states []*PowerState
+	for _, s := range split {
+		// Need to use stateRE here so that voter info doesn't accidentally get used.
+		match, st := historianutils.SubexpNames(stateRE, s)
+		if !match {
+			return nil, fmt.Errorf(`couldn't find power state info in "%v"`, s)
+		}
+		idx, err := strconv.Atoi(st["idx"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state level from string: %v", err)
+		}
+		tm, err := strconv.Atoi(st["time"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state time from string: %v", err)
+		}
+		c, err := strconv.Atoi(st["count"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state count from string: %v", err)
+		}
+		ps := PowerState{
+			Level: int32(idx),
+			Name:  st["name"],
+			Time:  time.Duration(tm) * time.Millisecond,
+			Count: int32(c),
+		}
+
+		match, f := historianutils.SubexpNames(fullPowerStateRE, s)
+		if !match {
+			// This case should never happen because s is created from fullPowerStateRE.FindAllString.
+			return nil, fmt.Errorf("matched string didn't match: %q", s)
+		}
+		vs := voterRE.FindAllString(f["voters"], -1)
+		for _, v := range vs {
+			match, vt := historianutils.SubexpNames(voterRE, v)
+			if !match {
+				// This case should never happen because v is created from voterRE.FindAllString.
+				return nil, fmt.Errorf("matched string didn't match: %q", v)
+			}
+			tm, err = strconv.Atoi(vt["time"])
+			if err != nil {
+				return nil, fmt.Errorf("error getting voter time from string: %v", err)
+			}
+			c, err = strconv.Atoi(vt["count"])
+			if err != nil {
+				return nil, fmt.Errorf("error getting voter count from string: %v", err)
+			}
+			ps.Voters = append(ps.Voters, Voter{
+				Name:  vt["name"],
+				Time:  time.Duration(tm) * time.Millisecond,
+				Count: int32(c),
+			})
+		}
+		states = append(states, &ps)
+	}
+	return states, nil
+}
+
+// mtkPowerStates parses the MediaTek SPM (system power manager) stats format.
+// Example format:
+// spm_1 name=suspend time=264740801 count=85367 spm_2 name=dpidle time=314921 count=2861 spm_3 name=sodi3 time=339626 count=9664
+// Times are printed in milliseconds. There are no voters.
+type mtkPowerStates struct{}
+
+// Detect returns whether the entry starts with the first SPM state.
+func (mtkPowerStates) Detect(entry string) bool {
+	return mtkDetectRE.MatchString(entry)
+}
+
+// Parse returns the SPM states in the entry.
+func (mtkPowerStates) Parse(input string) ([]*PowerState, error) {
+	split := mtkStateRE.FindAllString(input, -1)
+	if len(split) == 0 {
+		return nil, fmt.Errorf("invalid power_state line: %q", input)
+	}
+	var states []*PowerState
+	for _, s := range split {
+		_, st := historianutils.SubexpNames(mtkStateRE, s)
+		idx, err := strconv.Atoi(st["idx"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state level from string: %v", err)
+		}
+		tm, err := strconv.Atoi(st["time"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state time from string: %v", err)
+		}
+		c, err := strconv.Atoi(st["count"])
+		if err != nil {
+			return nil, fmt.Errorf("error getting power state count from string: %v", err)
+		}
+		states = append(states, &PowerState{
+			Level: int32(idx),
+			Name:  st["name"],
+			Time:  time.Duration(tm) * time.Millisecond,
+			Count: int32(c),
+		})
+	}
+	return states, nil
+}
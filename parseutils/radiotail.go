@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// radiotail.go attributes the mobile radio active time to the app network bursts that kept the radio
+// up. After the last data of a burst, the radio stays active for a tail set by the network's
+// inactivity timer, so an app that transfers a little data now and then costs far more than its
+// raw transfer time. Charging each burst with its tail gives a more realistic radio cost per app
+// than charging the whole active period to the app that first woke the radio.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// DefaultMobileRadioTail is the default time the mobile radio stays active after a burst,
+	// typical of the LTE inactivity timers.
+	DefaultMobileRadioTail = 10 * time.Second
+
+	// UnattributedRadioKey is the MobileRadioEnergyAppSummary key of the mobile radio active time
+	// outside of the energy windows of all bursts.
+	UnattributedRadioKey = "Unattributed"
+)
+
+// radioBurstMetrics are the battery history CSV metrics of the app network bursts. The battery
+// history doesn't log the per app traffic, so the app that woke up the radio, and the syncs and
+// jobs, are the bursts. The app that woke up the radio is only known to burst when it did so.
+var radioBurstMetrics = []string{MobileRadioApp, "SyncManager", "JobScheduler"}
+
+// MobileRadioEnergyWindow is a period of mobile radio active time charged to an app network burst,
+// up to the end of its tail.
+type MobileRadioEnergyWindow struct {
+	// App is the app of the burst, as "UID <app ID>".
+	App     string
+	StartMs int64
+	EndMs   int64
+}
+
+// radioBurst is an app network burst, with its energy window before the radio active time is shared
+// with the overlapping bursts.
+type radioBurst struct {
+	app        string
+	start, end int64
+}
+
+// byBurstStart sorts bursts by start time.
+type byBurstStart []radioBurst
+
+func (a byBurstStart) Len() int           { return len(a) }
+func (a byBurstStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byBurstStart) Less(i, j int) bool { return a[i].start < a[j].start }
+
+// radioEnergyWindows splits the radio active event between the energy windows of the bursts, which
+// are sorted by start time and already clipped to the event. Each instant is charged to the latest
+// burst started in whose window it is, as that burst is what is keeping the radio up.
+func radioEnergyWindows(r csv.Event, bursts []radioBurst) []MobileRadioEnergyWindow {
+	bounds := []int64{r.Start, r.End}
+	for _, b := range bursts {
+		bounds = append(bounds, b.start, b.end)
+	}
+	sort.Sort(int64s(bounds))
+
+	var windows []MobileRadioEnergyWindow
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if start == end {
+			continue
+		}
+		app := UnattributedRadioKey
+		for _, b := range bursts {
+			if b.start > start {
+				break
+			}
+			if b.end >= end {
+				app = b.app
+			}
+		}
+		if n := len(windows); n > 0 && windows[n-1].App == app && windows[n-1].EndMs == start {
+			windows[n-1].EndMs = end
+			continue
+		}
+		windows = append(windows, MobileRadioEnergyWindow{App: app, StartMs: start, EndMs: end})
+	}
+	return windows
+}
+
+// MobileRadioEnergyWindows returns the mobile radio active time of the battery history CSV generated
+// by AnalyzeHistory split into the energy windows of the app network bursts, sorted by start time.
+// The energy window of a burst lasts until the given tail after its end, within the radio active
+// period it's in. The active time outside of all windows is charged to UnattributedRadioKey.
+func MobileRadioEnergyWindows(csvInput string, tail time.Duration) ([]MobileRadioEnergyWindow, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{mobileRadio}, radioBurstMetrics...))
+	var bursts []radioBurst
+	for _, m := range radioBurstMetrics {
+		for _, e := range es[m] {
+			if e.Opt == "" {
+				continue
+			}
+			appID, err := packageutils.AppIDFromString(e.Opt)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s uid %q: %v", m, e.Opt, err))
+				continue
+			}
+			end := e.End
+			if m == MobileRadioApp {
+				end = e.Start
+			}
+			bursts = append(bursts, radioBurst{fmt.Sprintf("UID %d", appID), e.Start, end + int64(tail/time.Millisecond)})
+		}
+	}
+	sort.Sort(byBurstStart(bursts))
+
+	var active []csv.Event
+	for _, e := range es[mobileRadio] {
+		if e.Value == "true" {
+			active = append(active, e)
+		}
+	}
+	var windows []MobileRadioEnergyWindow
+	for _, r := range csv.MergeEvents(active) {
+		var in []radioBurst
+		for _, b := range bursts {
+			if b.start >= r.End {
+				break
+			}
+			if b.end <= r.Start {
+				continue
+			}
+			if b.start < r.Start {
+				b.start = r.Start
+			}
+			if b.end > r.End {
+				b.end = r.End
+			}
+			in = append(in, b)
+		}
+		windows = append(windows, radioEnergyWindows(r, in)...)
+	}
+	return windows, errs
+}
+
+// AddMobileRadioTailSummaries populates the MobileRadioEnergyAppSummary of each summary with the
+// MobileRadioEnergyWindows of the battery history CSV generated by AnalyzeHistory, keyed by app and
+// clipped to the summary.
+func AddMobileRadioTailSummaries(csvInput string, summaries []ActivitySummary, tail time.Duration) []error {
+	windows, errs := MobileRadioEnergyWindows(csvInput, tail)
+	for i := range summaries {
+		s := &summaries[i]
+		s.MobileRadioEnergyAppSummary = make(map[string]Dist)
+		for _, w := range windows {
+			ms := csv.Overlap([]csv.Event{{Start: w.StartMs, End: w.EndMs}}, s.StartTimeMs, s.EndTimeMs)
+			if ms <= 0 {
+				continue
+			}
+			d := s.MobileRadioEnergyAppSummary[w.App]
+			d.addDuration(time.Duration(ms) * time.Millisecond)
+			s.MobileRadioEnergyAppSummary[w.App] = d
+		}
+	}
+	return errs
+}
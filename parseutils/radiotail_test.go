@@ -0,0 +1,62 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddMobileRadioTailSummaries tests the charging of the mobile radio active time to the app network bursts and their tails.
+func TestAddMobileRadioTailSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Mobile radio active,bool,0,60000,true,`,
+		// The app that woke up the radio is only charged the tail after the wake.
+		`Mobile radio active app,service,0,60000,com.example.app,10045`,
+		// The sync's tail overlaps the first one, and is charged for the overlap as the later burst.
+		`SyncManager,service,5000,8000,com.example.provider,10050`,
+		// The job's tail is cut short by the end of the radio active period.
+		`JobScheduler,service,40000,55000,com.example.app/.SyncJob,1010045`,
+		`Mobile radio active,bool,70000,75000,true,`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 50000},
+		{StartTimeMs: 50000, EndTimeMs: 100000},
+	}
+	if errs := AddMobileRadioTailSummaries(input, summaries, 10*time.Second); len(errs) > 0 {
+		t.Fatalf("AddMobileRadioTailSummaries generated unexpected errors: %v", errs)
+	}
+	want := []map[string]Dist{
+		{
+			"UID 10045":          {Num: 2, TotalDuration: 15 * time.Second, MaxDuration: 10 * time.Second},
+			"UID 10050":          {Num: 1, TotalDuration: 13 * time.Second, MaxDuration: 13 * time.Second},
+			UnattributedRadioKey: {Num: 1, TotalDuration: 22 * time.Second, MaxDuration: 22 * time.Second},
+		},
+		{
+			"UID 10045":          {Num: 1, TotalDuration: 10 * time.Second, MaxDuration: 10 * time.Second},
+			UnattributedRadioKey: {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+		},
+	}
+	for i, w := range want {
+		if got := summaries[i].MobileRadioEnergyAppSummary; !reflect.DeepEqual(got, w) {
+			t.Errorf("Summary %d MobileRadioEnergyAppSummary\n got: %v\n want: %v", i, got, w)
+		}
+	}
+}
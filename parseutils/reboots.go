@@ -0,0 +1,96 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// reboots.go tells the kernel panics apart from the other abrupt reboots. Unexpected reboots often
+// come with battery complaints, e.g. from the work redone after each boot, so the reboot events are
+// annotated with how the device went down.
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+// The types of reboot in the opt field of the battery history CSV reboot events.
+const (
+	// RebootClean is a reboot after the device shut down, logged as a SHUTDOWN then a START.
+	RebootClean = "clean"
+	// RebootAbrupt is a reboot without a shutdown, logged as a START only, e.g. after a hard reset
+	// or the battery running out.
+	RebootAbrupt = "abrupt"
+	// RebootKernelPanic is an abrupt reboot after a kernel panic logged by the previous boot.
+	RebootKernelPanic = "kernel panic"
+)
+
+// kernelPanicRE matches the kernel log lines of a panic or of the oops leading to one.
+var kernelPanicRE = regexp.MustCompile(`Kernel panic - not syncing|Internal error: Oops|Unable to handle kernel`)
+
+// isLastKernelLogSection returns whether the bug report section has the kernel log of the previous
+// boot, e.g. "LAST KMSG (/proc/last_kmsg)" or "LAST KMSG (/sys/fs/pstore/console-ramoops)" depending
+// on the device and platform version.
+func isLastKernelLogSection(section string) bool {
+	section = strings.ToUpper(strings.TrimSpace(section))
+	return strings.HasPrefix(section, "LAST KMSG") || strings.Contains(section, "RAMOOPS")
+}
+
+// KernelPanic returns the first kernel panic line in the kernel log of the previous boot included
+// in the bug report, or an empty string if the previous boot didn't panic or its log is missing.
+func KernelPanic(bugReport string) string {
+	inSection := false
+	for _, line := range strings.Split(bugReport, "\n") {
+		if m, result := historianutils.SubexpNames(bugreportutils.BugReportSectionRE, line); m {
+			inSection = isLastKernelLogSection(result["section"])
+			continue
+		}
+		if inSection && kernelPanicRE.MatchString(line) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// AnnotateKernelPanic returns the battery history CSV generated by AnalyzeHistory with the last
+// reboot marked as a RebootKernelPanic if it was abrupt and the kernel log of the previous boot in
+// the bug report has a panic. Only the last reboot can be checked, as the kernel log of the boots
+// before it isn't kept. Rows that can't be parsed are dropped.
+func AnnotateKernelPanic(csvInput, bugReport string) (string, []error) {
+	if KernelPanic(bugReport) == "" {
+		return csvInput, nil
+	}
+	es, _ := csv.ExtractEvents(csvInput, []string{csv.Reboot})
+	reboots := es[csv.Reboot]
+	if len(reboots) == 0 {
+		return csvInput, nil
+	}
+	last := reboots[0]
+	for _, e := range reboots {
+		if e.Start > last.Start {
+			last = e
+		}
+	}
+	if last.Opt != RebootAbrupt {
+		return csvInput, nil
+	}
+	return csv.MapEvents(csvInput, func(metric string, e csv.Event) (csv.Event, bool) {
+		if metric == csv.Reboot && e.Start == last.Start {
+			e.Opt = RebootKernelPanic
+		}
+		return e, true
+	})
+}
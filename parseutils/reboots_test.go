@@ -0,0 +1,179 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestRebootTypes tests the reboot types of the reboot events generated by AnalyzeHistory.
+func TestRebootTypes(t *testing.T) {
+	tests := []struct {
+		desc  string
+		input string
+		want  string
+	}{
+		{
+			"Shutdown before start",
+			strings.Join([]string{
+				`9,h,0:RESET:TIME:1422620451417`,
+				`9,h,1000:SHUTDOWN`,
+				`9,h,4:START`,
+				`9,h,0:TIME:1430000000000`,
+			}, "\n"),
+			`Reboot,bool,1422620452417,1430000000000,true,clean`,
+		},
+		{
+			"Start without shutdown",
+			strings.Join([]string{
+				`9,h,0:RESET:TIME:1422620451417`,
+				`9,h,1000:START`,
+				`9,h,0:TIME:1430000000000`,
+			}, "\n"),
+			`Reboot,bool,1422620451417,1430000000000,true,abrupt`,
+		},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		AnalyzeHistory(&b, test.input, FormatTotalTime, emptyUIDPackageMapping, false)
+		var got []string
+		for _, l := range normalizeCSV(b.String()) {
+			if strings.HasPrefix(l, csv.Reboot+",") {
+				got = append(got, l)
+			}
+		}
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("%v: AnalyzeHistory(%v) generated reboots %q, want [%q]", test.desc, test.input, got, test.want)
+		}
+	}
+}
+
+// TestKernelPanic tests the detection of kernel panics in the kernel log of the previous boot.
+func TestKernelPanic(t *testing.T) {
+	tests := []struct {
+		desc      string
+		bugReport string
+		want      string
+	}{
+		{
+			"Panic in last kmsg",
+			strings.Join([]string{
+				`------ KERNEL LOG (dmesg) ------`,
+				`<6>[    0.000000] Booting Linux on physical CPU 0x0`,
+				`------ LAST KMSG (/proc/last_kmsg) ------`,
+				`[ 4022.125031] Unable to handle kernel NULL pointer dereference at virtual address 00000008`,
+				`[ 4022.125901] Kernel panic - not syncing: Fatal exception`,
+			}, "\n"),
+			`[ 4022.125031] Unable to handle kernel NULL pointer dereference at virtual address 00000008`,
+		},
+		{
+			"Panic in console ramoops",
+			strings.Join([]string{
+				`------ LAST KMSG (/sys/fs/pstore/console-ramoops) ------`,
+				`[ 4022.125901] Kernel panic - not syncing: Watchdog bark!`,
+			}, "\n"),
+			`[ 4022.125901] Kernel panic - not syncing: Watchdog bark!`,
+		},
+		{
+			"Panic in the current kernel log only",
+			strings.Join([]string{
+				`------ KERNEL LOG (dmesg) ------`,
+				`[ 4022.125901] Kernel panic - not syncing: Fatal exception`,
+				`------ LAST KMSG (/proc/last_kmsg) ------`,
+				`[ 4022.125031] reboot: Restarting system`,
+			}, "\n"),
+			"",
+		},
+		{
+			"No last kernel log",
+			`------ KERNEL LOG (dmesg) ------`,
+			"",
+		},
+	}
+	for _, test := range tests {
+		if got := KernelPanic(test.bugReport); got != test.want {
+			t.Errorf("%v: KernelPanic(%v) = %q, want %q", test.desc, test.bugReport, got, test.want)
+		}
+	}
+}
+
+// TestAnnotateKernelPanic tests the marking of the last reboot as a kernel panic.
+func TestAnnotateKernelPanic(t *testing.T) {
+	panicReport := strings.Join([]string{
+		`------ LAST KMSG (/proc/last_kmsg) ------`,
+		`[ 4022.125901] Kernel panic - not syncing: Fatal exception`,
+	}, "\n")
+	tests := []struct {
+		desc      string
+		input     string
+		bugReport string
+		want      string
+	}{
+		{
+			"Last reboot abrupt",
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,1000,2000,true,abrupt`,
+				`Reboot,bool,5000,6000,true,abrupt`,
+			}, "\n"),
+			panicReport,
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,1000,2000,true,abrupt`,
+				`Reboot,bool,5000,6000,true,kernel panic`,
+			}, "\n"),
+		},
+		{
+			"Last reboot clean",
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,1000,2000,true,abrupt`,
+				`Reboot,bool,5000,6000,true,clean`,
+			}, "\n"),
+			panicReport,
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,1000,2000,true,abrupt`,
+				`Reboot,bool,5000,6000,true,clean`,
+			}, "\n"),
+		},
+		{
+			"No panic",
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,5000,6000,true,abrupt`,
+			}, "\n"),
+			`------ LAST KMSG (/proc/last_kmsg) ------`,
+			strings.Join([]string{
+				csv.FileHeader,
+				`Reboot,bool,5000,6000,true,abrupt`,
+			}, "\n"),
+		},
+	}
+	for _, test := range tests {
+		got, errs := AnnotateKernelPanic(test.input, test.bugReport)
+		if len(errs) > 0 {
+			t.Errorf("%v: AnnotateKernelPanic(%v) generated unexpected errors: %v", test.desc, test.input, errs)
+			continue
+		}
+		if got = strings.TrimSpace(got); got != test.want {
+			t.Errorf("%v: AnnotateKernelPanic(%v)\n got: %v\n want: %v", test.desc, test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// redact.go anonymizes the apps some deployments must not report on, e.g. preinstalled apps or
+// packages chosen by the user. Their package names are replaced in the bug report before it's
+// parsed, so that no parser can emit them, and their services are renamed as they are parsed from
+// the history, so everything derived from the history only sees a single "redacted" app, and the
+// totals are kept.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/battery-historian/packageutils"
+	usagepb "github.com/google/battery-historian/pb/usagestats_proto"
+)
+
+// Redacted is the name the services and packages of redacted apps are reported under.
+const Redacted = "redacted"
+
+// Redactions is a list of apps to anonymize in the analysis output, by UID or package name.
+type Redactions struct {
+	// uids contains the app IDs to redact.
+	uids map[int32]bool
+	// pkgs contains the package names to redact.
+	pkgs map[string]bool
+}
+
+// ParseRedactions returns the redactions of the comma separated list of UIDs and package names,
+// e.g. "10015,com.example.app". UIDs are redacted for all users. Nil is returned for an empty list.
+func ParseRedactions(list string) *Redactions {
+	r := &Redactions{
+		uids: make(map[int32]bool),
+		pkgs: make(map[string]bool),
+	}
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if u, err := strconv.ParseInt(e, 10, 32); err == nil {
+			r.uids[packageutils.AppID(int32(u))] = true
+			continue
+		}
+		r.pkgs[e] = true
+	}
+	if len(r.uids) == 0 && len(r.pkgs) == 0 {
+		return nil
+	}
+	return r
+}
+
+// resolve returns a copy of the redactions with the UIDs of the redacted packages, so that the
+// services of the packages that can't be matched by name, e.g. wakelocks, are redacted too.
+func (r *Redactions) resolve(packageToUID map[string]int32) *Redactions {
+	if r == nil {
+		return nil
+	}
+	res := &Redactions{
+		uids: make(map[int32]bool),
+		pkgs: r.pkgs,
+	}
+	for u := range r.uids {
+		res.uids[u] = true
+	}
+	for p := range r.pkgs {
+		if u, ok := packageToUID[p]; ok && u != 0 {
+			res.uids[packageutils.AppID(u)] = true
+		}
+	}
+	return res
+}
+
+// redacts returns whether the service, matched with its package, belongs to a redacted app.
+func (r *Redactions) redacts(suid *ServiceUID) bool {
+	if r == nil {
+		return false
+	}
+	if suid.UID != "" {
+		if appID, err := packageutils.AppIDFromString(suid.UID); err == nil && r.uids[appID] {
+			return true
+		}
+	}
+	if suid.Pkg == nil {
+		return false
+	}
+	return r.redactsApp(packageutils.AppID(suid.Pkg.GetUid()), suid.Pkg.GetPkgName())
+}
+
+// redactsApp returns whether the app with the given app ID and package name is redacted. Packages
+// already renamed by RedactBugReport are redacted too.
+func (r *Redactions) redactsApp(appID int32, pkgName string) bool {
+	if r == nil {
+		return false
+	}
+	if appID != 0 && r.uids[appID] {
+		return true
+	}
+	// Packages of shared UIDs are delineated by ';'.
+	for _, p := range strings.Split(pkgName, ";") {
+		p = strings.TrimSuffix(p, workProfileSuffix)
+		if p == Redacted || r.pkgs[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns a short digest of the redactions, for use in storage keys, or "" if there are none.
+func (r *Redactions) Key() string {
+	if r == nil {
+		return ""
+	}
+	var entries []string
+	for u := range r.uids {
+		entries = append(entries, strconv.Itoa(int(u)))
+	}
+	for p := range r.pkgs {
+		entries = append(entries, p)
+	}
+	sort.Strings(entries)
+	h := sha256.Sum256([]byte(strings.Join(entries, ",")))
+	return hex.EncodeToString(h[:8])
+}
+
+// packageNames returns the names of the redacted packages, and of the packages of the redacted UIDs
+// listed in the bug report's checkin and package list, and the names of the other packages listed,
+// which aren't redacted.
+func (r *Redactions) packageNames(bugreport string) (redacted, kept []string) {
+	// names maps the package names to whether they're redacted.
+	names := make(map[string]bool)
+	for p := range r.pkgs {
+		names[p] = true
+	}
+	add := func(pkg string, uid int32) {
+		names[pkg] = names[pkg] || r.uids[packageutils.AppID(uid)]
+	}
+	pkgs, _ := packageutils.ExtractAppsFromBugReport(bugreport)
+	for _, p := range pkgs {
+		add(p.GetPkgName(), p.GetUid())
+	}
+	upm, _ := UIDAndPackageNameMapping(bugreport, pkgs)
+	for uid, pkg := range upm.uidToPackage {
+		// Packages of shared UIDs are delineated by ';'.
+		for _, p := range strings.Split(pkg, ";") {
+			add(p, uid)
+		}
+	}
+	for n, red := range names {
+		switch {
+		case n == "":
+		case red:
+			redacted = append(redacted, n)
+		default:
+			kept = append(kept, n)
+		}
+	}
+	return redacted, kept
+}
+
+// byLengthDesc sorts strings in descending order of length, then alphabetically.
+type byLengthDesc []string
+
+func (a byLengthDesc) Len() int      { return len(a) }
+func (a byLengthDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLengthDesc) Less(i, j int) bool {
+	if len(a[i]) != len(a[j]) {
+		return len(a[i]) > len(a[j])
+	}
+	return a[i] < a[j]
+}
+
+// RedactBugReport returns the bug report with the names of the redacted packages, and of the
+// packages of the redacted UIDs, replaced by Redacted wherever they appear, e.g. in the checkin,
+// the logs and the service dumps, so that no parser emits them. Class names qualified with a
+// package name are replaced as a whole, e.g. "com.example.app.SyncService", unless they name
+// another package listed in the bug report, e.g. "com.example.app.plugin". The bug report is
+// returned unchanged for nil redactions.
+func (r *Redactions) RedactBugReport(bugreport string) string {
+	if r == nil || bugreport == "" {
+		return bugreport
+	}
+	redacted, kept := r.packageNames(bugreport)
+	if len(redacted) == 0 {
+		return bugreport
+	}
+	isKept := make(map[string]bool)
+	names := redacted
+	for _, k := range kept {
+		// Only the kept packages that could be mistaken for a class of a redacted package matter.
+		for _, n := range redacted {
+			if strings.HasPrefix(k, n+".") {
+				isKept[k] = true
+				names = append(names, k)
+				break
+			}
+		}
+	}
+	// Longer names are tried first, so a package isn't partially replaced by a shorter one it starts with.
+	sort.Sort(byLengthDesc(names))
+	var quoted []string
+	for _, n := range names {
+		quoted = append(quoted, regexp.QuoteMeta(n))
+	}
+	// The name must not be part of a longer name, so it's neither preceded by a name character, nor
+	// followed by one other than the '.' of a qualified class name.
+	re := regexp.MustCompile(`(^|[^\w.])(` + strings.Join(quoted, "|") + `)(?:\.[\w$]+)*\b`)
+	return re.ReplaceAllStringFunc(bugreport, func(m string) string {
+		sub := re.FindStringSubmatch(m)
+		if isKept[sub[2]] {
+			return m
+		}
+		return sub[1] + Redacted
+	})
+}
+
+// redact anonymizes the service of a redacted app. The UID is cleared, and the service and package
+// are renamed to Redacted, so the summaries combine all redacted apps into a single entry.
+func redact(suid *ServiceUID) {
+	suid.Service = fmt.Sprintf("%q", Redacted)
+	suid.UID = ""
+	suid.Pkg = &usagepb.PackageInfo{
+		PkgName: proto.String(Redacted),
+		Uid:     proto.Int32(0),
+	}
+}
+
+// SetRedactions sets the apps anonymized in the services matched with the mapping. The services of
+// the UIDs of redacted packages are redacted too. Nil redactions disable the redaction.
+func (pum *PackageUIDMapping) SetRedactions(r *Redactions) {
+	pum.redactions = r.resolve(pum.packageToUID)
+}
@@ -0,0 +1,203 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	usagepb "github.com/google/battery-historian/pb/usagestats_proto"
+)
+
+// TestMatchServiceWithRedactions tests that the services of redacted apps are anonymized.
+func TestMatchServiceWithRedactions(t *testing.T) {
+	pkgs := []*usagepb.PackageInfo{
+		{
+			PkgName: proto.String("com.google.android.gm"),
+			Uid:     proto.Int32(10023),
+		},
+		{
+			PkgName: proto.String("com.example.app"),
+			Uid:     proto.Int32(10050),
+		},
+	}
+	redacted := ServiceUID{
+		Service: `"redacted"`,
+		Pkg: &usagepb.PackageInfo{
+			PkgName: proto.String(Redacted),
+			Uid:     proto.Int32(0),
+		},
+	}
+	tests := []struct {
+		desc   string
+		redact string
+		input  ServiceUID
+		want   ServiceUID
+	}{
+		{
+			desc:   "Redacted package",
+			redact: "com.google.android.gm",
+			input:  ServiceUID{Service: `"com.google.android.gm"`, UID: "10023"},
+			want:   redacted,
+		},
+		{
+			desc:   "Service of the UID of a redacted package",
+			redact: " com.google.android.gm ,",
+			input:  ServiceUID{Service: `"*alarm*"`, UID: "10023"},
+			want:   redacted,
+		},
+		{
+			desc:   "Redacted UID of a secondary user",
+			redact: "10050",
+			input:  ServiceUID{Service: `"*job*/com.example.app/.SyncJob"`, UID: "1010050"},
+			want:   redacted,
+		},
+		{
+			desc:   "App not redacted",
+			redact: "10050,com.google.android.gm",
+			input:  ServiceUID{Service: `"*alarm*"`, UID: "1000"},
+			want: ServiceUID{
+				Service: `"*alarm*"`,
+				UID:     "1000",
+				Pkg: &usagepb.PackageInfo{
+					PkgName: proto.String("ANDROID_SYSTEM"),
+					Uid:     proto.Int32(1000),
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		upm, errs := UIDAndPackageNameMapping("", pkgs)
+		if len(errs) > 0 {
+			t.Fatalf("UIDAndPackageNameMapping generated unexpected errors: %v", errs)
+		}
+		upm.SetRedactions(ParseRedactions(test.redact))
+		got := test.input
+		if err := upm.matchServiceWithPackageInfo(&got); err != nil {
+			t.Errorf("%v: error encountered when matching: %v", test.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: matchServiceWithPackageInfo(%v) = %v, want %v", test.desc, test.input, got, test.want)
+		}
+	}
+	if ParseRedactions(" , ") != nil {
+		t.Errorf(`ParseRedactions(" , ") = non nil, want nil`)
+	}
+}
+
+// TestRedactedSummaries tests that redacted apps are combined into a single entry of the summaries
+// and CSV, keeping the totals.
+func TestRedactedSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,1,10011,"*sync*"`,
+		`9,hsp,2,10023,"*alarm*"`,
+		`9,hsp,3,10050,"*job*"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,1000,+Ewl=1`,
+		`9,h,1000,+Ewl=2`,
+		`9,h,1000,-Ewl=1`,
+		`9,h,1000,+Ewl=3`,
+		`9,h,1000,-Ewl=2`,
+		`9,h,1000,-Ewl=3`,
+	}, "\n")
+
+	upm, errs := UIDAndPackageNameMapping("", []*usagepb.PackageInfo{
+		{
+			PkgName: proto.String("com.example.app"),
+			Uid:     proto.Int32(10050),
+		},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("UIDAndPackageNameMapping generated unexpected errors: %v", errs)
+	}
+	upm.SetRedactions(ParseRedactions("10023,com.example.app"))
+
+	var b bytes.Buffer
+	result := AnalyzeHistory(&b, input, FormatTotalTime, upm, false)
+	validateHistory(input, t, result, 0, 1)
+
+	want := map[string]Dist{
+		`"*sync*"`: {
+			Num:           1,
+			TotalDuration: 2000 * time.Millisecond,
+			MaxDuration:   2000 * time.Millisecond,
+		},
+		`"redacted"`: {
+			Num:           2,
+			TotalDuration: 5000 * time.Millisecond,
+			MaxDuration:   3000 * time.Millisecond,
+		},
+	}
+	if got := result.Summaries[0].WakeLockDetailedSummary; !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeHistory(%s,...).Summaries[0].WakeLockDetailedSummary = %v, want %v", input, got, want)
+	}
+	for _, s := range []string{"alarm", "job", "10023", "10050", "com.example.app"} {
+		if strings.Contains(b.String(), s) {
+			t.Errorf("AnalyzeHistory(%s,...) CSV contains redacted %q:\n%s", input, s, b.String())
+		}
+	}
+}
+
+// TestRedactBugReport tests that the names of redacted packages are replaced throughout the bug report.
+func TestRedactBugReport(t *testing.T) {
+	input := strings.Join([]string{
+		`9,10023,l,apk,1,com.google.android.gm,com.google.android.gm.SyncService,0,0,1`,
+		`9,10050,l,apk,1,com.example.app,com.example.app.JobService,0,0,1`,
+		`9,10051,l,apk,1,com.example.app.plugin,com.example.app.plugin.PluginService,0,0,1`,
+		`02-16 07:00:00.000  1000  1200 I ActivityManager: Start proc 2345:com.google.android.gm/u0a23 for service com.google.android.gm/.SyncService`,
+		`02-16 07:00:01.000  1000  1200 I am_crash: [2345,0,com.example.app,1,java.lang.NullPointerException]`,
+		`  Package [com.example.app.plugin] (3a2b1c):`,
+		`  Package [com.google.android.gms] (4b3c2d):`,
+	}, "\n")
+	want := strings.Join([]string{
+		`9,10023,l,apk,1,redacted,redacted,0,0,1`,
+		`9,10050,l,apk,1,redacted,redacted,0,0,1`,
+		`9,10051,l,apk,1,com.example.app.plugin,com.example.app.plugin.PluginService,0,0,1`,
+		`02-16 07:00:00.000  1000  1200 I ActivityManager: Start proc 2345:redacted/u0a23 for service redacted/.SyncService`,
+		`02-16 07:00:01.000  1000  1200 I am_crash: [2345,0,redacted,1,java.lang.NullPointerException]`,
+		`  Package [com.example.app.plugin] (3a2b1c):`,
+		`  Package [com.google.android.gms] (4b3c2d):`,
+	}, "\n")
+	r := ParseRedactions("10023,com.example.app")
+	if got := r.RedactBugReport(input); got != want {
+		t.Errorf("RedactBugReport(%s)\n got: %s\n want: %s", input, got, want)
+	}
+	var nilR *Redactions
+	if got := nilR.RedactBugReport(input); got != input {
+		t.Errorf("RedactBugReport(%s) with no redactions = %s, want unchanged", input, got)
+	}
+}
+
+// TestRedactionsKey tests that the key of the redactions only depends on the redacted apps.
+func TestRedactionsKey(t *testing.T) {
+	var nilR *Redactions
+	if k := nilR.Key(); k != "" {
+		t.Errorf("Key() with no redactions = %q, want empty", k)
+	}
+	a, b := ParseRedactions("10023,com.example.app"), ParseRedactions("com.example.app, 10023")
+	if a.Key() == "" || a.Key() != b.Key() {
+		t.Errorf("Key() = %q and %q, want equal non empty keys", a.Key(), b.Key())
+	}
+	if c := ParseRedactions("10023"); c.Key() == a.Key() {
+		t.Errorf("Key() of different redactions = %q, want different keys", c.Key())
+	}
+}
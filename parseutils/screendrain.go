@@ -0,0 +1,102 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// screendrain.go splits the battery drain between the time the screen was off and on, as the drain
+// rates of the two differ by an order of magnitude and are tracked separately.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// ScreenDrain is the battery level drop and the unplugged time with the screen off and on.
+type ScreenDrain struct {
+	// ScreenOffLevelDrop and ScreenOnLevelDrop are the battery level drops in percent. They are
+	// estimated, so aren't whole numbers.
+	ScreenOffLevelDrop float64
+	ScreenOnLevelDrop  float64
+	ScreenOff          time.Duration
+	ScreenOn           time.Duration
+}
+
+// ScreenOffPerHour returns the battery level drop per hour unplugged with the screen off, or 0 if the
+// screen was never off while unplugged.
+func (d ScreenDrain) ScreenOffPerHour() float64 {
+	if d.ScreenOff <= 0 {
+		return 0
+	}
+	return d.ScreenOffLevelDrop / d.ScreenOff.Hours()
+}
+
+// ScreenOnPerHour returns the battery level drop per hour unplugged with the screen on, or 0 if the
+// screen was never on while unplugged.
+func (d ScreenDrain) ScreenOnPerHour() float64 {
+	if d.ScreenOn <= 0 {
+		return 0
+	}
+	return d.ScreenOnLevelDrop / d.ScreenOn.Hours()
+}
+
+// ScreenDrainRates returns the battery drain with the screen off and on from the battery history CSV
+// generated by AnalyzeHistory. The level drop of each battery level step is split between the screen
+// off and on time of the unplugged parts of the step, proportionally to their length.
+func ScreenDrainRates(csvInput string) (ScreenDrain, []error) {
+	es, errs := csv.ExtractEvents(csvInput, []string{BatteryLevel, Plugged, screen})
+	var plugged []csv.Event
+	for _, e := range es[Plugged] {
+		if e.Value == "true" {
+			plugged = append(plugged, e)
+		}
+	}
+	plugged = csv.MergeEvents(plugged)
+	screenOn := csv.MergeEvents(es[screen])
+
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	sort.Sort(sortByStart(levels))
+
+	var d ScreenDrain
+	for i, cur := range levels {
+		var on, off int64
+		for _, u := range unplugged(plugged, cur.Start, cur.End) {
+			o := csv.Overlap(screenOn, u.Start, u.End)
+			on += o
+			off += u.End - u.Start - o
+		}
+		d.ScreenOn += time.Duration(on) * time.Millisecond
+		d.ScreenOff += time.Duration(off) * time.Millisecond
+		if i+1 == len(levels) || on+off == 0 {
+			continue
+		}
+		from, _ := strconv.Atoi(cur.Value)
+		to, _ := strconv.Atoi(levels[i+1].Value)
+		if to >= from {
+			continue
+		}
+		d.ScreenOnLevelDrop += float64(from-to) * float64(on) / float64(on+off)
+		d.ScreenOffLevelDrop += float64(from-to) * float64(off) / float64(on+off)
+	}
+	return d, errs
+}
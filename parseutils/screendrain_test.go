@@ -0,0 +1,54 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestScreenDrainRates tests the split of the level drops between the screen off and on time.
+func TestScreenDrainRates(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		// An hour with the screen off, then an hour with the screen on half of the time.
+		`Battery Level,int,0,3600000,90,`,
+		`Battery Level,int,3600000,7200000,89,`,
+		`Screen,bool,3600000,5400000,true,`,
+		// Charging while plugged in doesn't count.
+		`Battery Level,int,7200000,10800000,85,`,
+		`Plugged,bool,7200000,10800000,true,`,
+		`Battery Level,int,10800000,10800000,90,`,
+	}, "\n")
+	got, errs := ScreenDrainRates(input)
+	if len(errs) > 0 {
+		t.Fatalf("ScreenDrainRates generated unexpected errors: %v", errs)
+	}
+	want := ScreenDrain{
+		ScreenOffLevelDrop: 3,
+		ScreenOnLevelDrop:  2,
+		ScreenOff:          90 * time.Minute,
+		ScreenOn:           30 * time.Minute,
+	}
+	if got != want {
+		t.Errorf("ScreenDrainRates()\n got: %+v\n want: %+v", got, want)
+	}
+	if off, on := got.ScreenOffPerHour(), got.ScreenOnPerHour(); off != 2 || on != 4 {
+		t.Errorf("ScreenDrainRates() rates = %v, %v, want 2, 4", off, on)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// screensessions.go counts the screen on sessions as the user perceives them. The screen often turns
+// off and back on within a few seconds, e.g. after a proximity sensor flicker, and ambient display
+// pulses turn it on for a second or two without any interaction, both of which inflate the number of
+// screen ons in ScreenOnSummary.
+
+import (
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// DefaultScreenMergeGap is the default longest time the screen can be off between two screen ons
+	// for them to be counted as a single session.
+	DefaultScreenMergeGap = 5 * time.Second
+
+	// ScreenPulseDuration is the duration below which a session is counted as a pulse, such as an
+	// ambient display pulse, rather than an interaction.
+	ScreenPulseDuration = 2 * time.Second
+)
+
+// screenSessions returns the sessions formed by merging the sorted, non overlapping screen on events
+// separated by at most mergeGap. Each session spans the gaps it merged.
+func screenSessions(screenOn []csv.Event, mergeGap time.Duration) []csv.Event {
+	gapMs := int64(mergeGap / time.Millisecond)
+	var sessions []csv.Event
+	for _, e := range screenOn {
+		if n := len(sessions); n > 0 && e.Start-sessions[n-1].End <= gapMs {
+			if e.End > sessions[n-1].End {
+				sessions[n-1].End = e.End
+			}
+			continue
+		}
+		sessions = append(sessions, csv.Event{Type: e.Type, Start: e.Start, End: e.End, Value: e.Value})
+	}
+	return sessions
+}
+
+// AddScreenSessionSummaries populates the ScreenOnSessionSummary and ScreenPulseSummary of each summary
+// from the battery history CSV generated by AnalyzeHistory. Screen ons separated by at most mergeGap
+// are merged into a single session, and sessions shorter than ScreenPulseDuration are counted as
+// pulses. A session is counted in every summary it overlaps, with the duration of the overlap.
+func AddScreenSessionSummaries(csvInput string, summaries []ActivitySummary, mergeGap time.Duration) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{screen})
+	var screenOn []csv.Event
+	for _, e := range es[screen] {
+		if e.Value != "false" {
+			screenOn = append(screenOn, e)
+		}
+	}
+	sessions := screenSessions(csv.MergeEvents(screenOn), mergeGap)
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.ScreenOnSessionSummary = Dist{}
+		s.ScreenPulseSummary = Dist{}
+		for _, e := range sessions {
+			ms := csv.Overlap([]csv.Event{e}, s.StartTimeMs, s.EndTimeMs)
+			if ms <= 0 {
+				continue
+			}
+			d := time.Duration(ms) * time.Millisecond
+			if time.Duration(e.End-e.Start)*time.Millisecond < ScreenPulseDuration {
+				s.ScreenPulseSummary.addDuration(d)
+			} else {
+				s.ScreenOnSessionSummary.addDuration(d)
+			}
+		}
+	}
+	return errs
+}
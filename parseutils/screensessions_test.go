@@ -0,0 +1,86 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddScreenSessionSummaries tests the merging of screen ons into sessions, and the counting of pulses.
+func TestAddScreenSessionSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		// A session with a flicker 1s in.
+		`Screen,bool,10000,11000,true,power`,
+		`Screen,bool,12000,40000,true,`,
+		// An ambient display pulse.
+		`Screen,bool,60000,61500,true,`,
+		`Screen,bool,61500,90000,false,`,
+		// A session spanning both summaries.
+		`Screen,bool,95000,105000,true,`,
+		`Screen,bool,108000,108500,true,`,
+	}, "\n")
+
+	tests := []struct {
+		desc                     string
+		mergeGap                 time.Duration
+		wantSessions, wantPulses []Dist
+	}{
+		{
+			desc:     "Default merge gap",
+			mergeGap: DefaultScreenMergeGap,
+			wantSessions: []Dist{
+				{Num: 2, TotalDuration: 35 * time.Second, MaxDuration: 30 * time.Second},
+				{Num: 1, TotalDuration: 8500 * time.Millisecond, MaxDuration: 8500 * time.Millisecond},
+			},
+			wantPulses: []Dist{
+				{Num: 1, TotalDuration: 1500 * time.Millisecond, MaxDuration: 1500 * time.Millisecond},
+				{},
+			},
+		},
+		{
+			desc: "No merging",
+			wantSessions: []Dist{
+				{Num: 2, TotalDuration: 33 * time.Second, MaxDuration: 28 * time.Second},
+				{Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+			},
+			wantPulses: []Dist{
+				{Num: 2, TotalDuration: 2500 * time.Millisecond, MaxDuration: 1500 * time.Millisecond},
+				{Num: 1, TotalDuration: 500 * time.Millisecond, MaxDuration: 500 * time.Millisecond},
+			},
+		},
+	}
+	for _, test := range tests {
+		summaries := []ActivitySummary{
+			{StartTimeMs: 0, EndTimeMs: 100000},
+			{StartTimeMs: 100000, EndTimeMs: 200000},
+		}
+		if errs := AddScreenSessionSummaries(input, summaries, test.mergeGap); len(errs) > 0 {
+			t.Fatalf("%s: AddScreenSessionSummaries generated unexpected errors: %v", test.desc, errs)
+		}
+		for i, s := range summaries {
+			if s.ScreenOnSessionSummary != test.wantSessions[i] {
+				t.Errorf("%s: summary %d ScreenOnSessionSummary = %v, want %v", test.desc, i, s.ScreenOnSessionSummary, test.wantSessions[i])
+			}
+			if s.ScreenPulseSummary != test.wantPulses[i] {
+				t.Errorf("%s: summary %d ScreenPulseSummary = %v, want %v", test.desc, i, s.ScreenPulseSummary, test.wantPulses[i])
+			}
+		}
+	}
+}
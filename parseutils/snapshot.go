@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// snapshot.go captures the device state at regular checkpoints of the history, so what was held or
+// running at a given time can be looked up without analyzing the history again.
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotEntity is an app entity, such as a wakelock or job, active in a DeviceStateSnapshot.
+type SnapshotEntity struct {
+	Service string `json:"service"`
+	UID     string `json:"uid"`
+}
+
+// DeviceStateSnapshot is the device state at a point in the history.
+type DeviceStateSnapshot struct {
+	// TimeMs is the checkpoint the snapshot was taken at, in unix time ms.
+	TimeMs         int64  `json:"timeMs"`
+	BatteryLevel   int    `json:"batteryLevel"`
+	Plugged        bool   `json:"plugged"`
+	ScreenOn       bool   `json:"screenOn"`
+	CPURunning     bool   `json:"cpuRunning"`
+	WakeLockHeld   bool   `json:"wakeLockHeld"`
+	MobileRadioOn  bool   `json:"mobileRadioOn"`
+	WifiOn         bool   `json:"wifiOn"`
+	GPSOn          bool   `json:"gpsOn"`
+	IdleMode       string `json:"idleMode,omitempty"`
+	DataConnection string `json:"dataConnection,omitempty"`
+	// The active app entities, sorted by UID then service.
+	WakeLocks     []SnapshotEntity `json:"wakeLocks,omitempty"`
+	LongWakeLocks []SnapshotEntity `json:"longWakeLocks,omitempty"`
+	Jobs          []SnapshotEntity `json:"jobs,omitempty"`
+	Syncs         []SnapshotEntity `json:"syncs,omitempty"`
+	TopApp        []SnapshotEntity `json:"topApp,omitempty"`
+}
+
+// Time returns the time the snapshot was taken at.
+func (s DeviceStateSnapshot) Time() time.Time {
+	return time.Unix(0, s.TimeMs*int64(time.Millisecond))
+}
+
+// byUIDService sorts entities by UID, then service.
+type byUIDService []SnapshotEntity
+
+func (a byUIDService) Len() int      { return len(a) }
+func (a byUIDService) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byUIDService) Less(i, j int) bool {
+	if a[i].UID != a[j].UID {
+		return a[i].UID < a[j].UID
+	}
+	return a[i].Service < a[j].Service
+}
+
+// snapshotEntities returns the entities in the active map, sorted by UID then service.
+func snapshotEntities(m map[string]*ServiceUID) []SnapshotEntity {
+	var es []SnapshotEntity
+	for _, s := range m {
+		es = append(es, SnapshotEntity{Service: strings.Trim(s.Service, `"`), UID: s.UID})
+	}
+	sort.Sort(byUIDService(es))
+	return es
+}
+
+// snapshot returns the snapshot of the device state at the given time.
+func (state *DeviceState) snapshot(timeMs int64) DeviceStateSnapshot {
+	return DeviceStateSnapshot{
+		TimeMs:         timeMs,
+		BatteryLevel:   state.BatteryLevel.Value,
+		Plugged:        state.Plugged.Value,
+		ScreenOn:       state.ScreenOn.Value,
+		CPURunning:     state.CPURunning.Value,
+		WakeLockHeld:   state.WakeLockHeld.Value,
+		MobileRadioOn:  state.MobileRadioOn.Value,
+		WifiOn:         state.WifiOn.Value,
+		GPSOn:          state.GpsOn.Value,
+		IdleMode:       state.IdleMode.Value,
+		DataConnection: state.DataConnection.Value,
+		WakeLocks:      snapshotEntities(state.WakeLockMap),
+		LongWakeLocks:  snapshotEntities(state.LongWakelockMap),
+		Jobs:           snapshotEntities(state.ScheduledJobMap),
+		Syncs:          snapshotEntities(state.AppSyncingMap),
+		TopApp:         snapshotEntities(state.TopApplicationMap),
+	}
+}
+
+// snapshotBefore is called with the device state before the history line is analyzed, and returns the
+// snapshot of the device state at the given time if the state is valid then, i.e. the time is from the
+// current time until the time of the line. Nil is returned otherwise.
+func (state *DeviceState) snapshotBefore(line string, timeMs int64) *DeviceStateSnapshot {
+	if state.CurrentTime == 0 || timeMs < state.CurrentTime {
+		return nil
+	}
+	d, err := lineDelta(line)
+	if err != nil || timeMs >= state.CurrentTime+d {
+		return nil
+	}
+	s := state.snapshot(timeMs)
+	return &s
+}
+
+// DeviceStateAt returns the device state at the given time, in unix ms, reconstructed from the
+// battery history: the device booleans, connectivity, and the active wakelocks, jobs, syncs and top
+// app. Nil is returned if the time isn't covered by the history.
+func DeviceStateAt(history string, pum PackageUIDMapping, timeMs int64) (*DeviceStateSnapshot, []error) {
+	rep := AnalyzeHistoryWithOptions(nil, history, FormatTotalTime, pum, false, HistoryOptions{StateAtMs: timeMs})
+	return rep.StateAt, rep.Errs
+}
+
+// snapshotter captures snapshots of the device state every interval of history time.
+type snapshotter struct {
+	interval  int64
+	next      int64
+	snapshots []DeviceStateSnapshot
+}
+
+// newSnapshotter returns a snapshotter for the given interval, or nil if the interval isn't positive.
+func newSnapshotter(interval time.Duration) *snapshotter {
+	if interval <= 0 {
+		return nil
+	}
+	return &snapshotter{interval: int64(interval / time.Millisecond)}
+}
+
+// before is called with the device state before the history line is analyzed. The state is valid
+// until the time of the line, so a snapshot is taken for each checkpoint until then.
+func (s *snapshotter) before(state *DeviceState, line string) {
+	if state.CurrentTime == 0 {
+		// The time isn't known yet.
+		return
+	}
+	// The delta of a START line isn't counted, as the time is set by the TIME line following it.
+	d, err := lineDelta(line)
+	if err != nil {
+		return
+	}
+	if s.next == 0 {
+		s.next = state.CurrentTime
+	}
+	for lineTime := state.CurrentTime + d; s.next < lineTime; s.next += s.interval {
+		s.snapshots = append(s.snapshots, state.snapshot(s.next))
+	}
+}
+
+// after is called with the device state after the history line is analyzed. Checkpoints skipped by
+// a jump in time, e.g. a reboot, aren't captured as the device state during them is unknown.
+func (s *snapshotter) after(state *DeviceState) {
+	if s.next == 0 {
+		return
+	}
+	for s.next < state.CurrentTime {
+		s.next += s.interval
+	}
+}
+
+// result returns the captured snapshots. It is safe to call on a nil snapshotter.
+func (s *snapshotter) result() []DeviceStateSnapshot {
+	if s == nil {
+		return nil
+	}
+	return s.snapshots
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAnalyzeHistoryWithSnapshots tests the capture of device state snapshots at regular checkpoints.
+func TestAnalyzeHistoryWithSnapshots(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,19,10008,"com.android.providers.downloads/.DownloadIdleService"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=80,Bs=d,Bp=n`,
+		`9,h,30000,+S`,
+		`9,h,60000,+Ejb=19`,
+		`9,h,60000,-S,-Ejb=19`,
+		`9,h,30000,Bl=79`,
+	}, "\n")
+	job := []SnapshotEntity{{Service: "com.android.providers.downloads/.DownloadIdleService", UID: "10008"}}
+	want := []DeviceStateSnapshot{
+		{TimeMs: 1000000, BatteryLevel: 80},
+		{TimeMs: 1060000, BatteryLevel: 80, ScreenOn: true},
+		{TimeMs: 1120000, BatteryLevel: 80, ScreenOn: true, Jobs: job},
+	}
+
+	rep := AnalyzeHistoryWithSnapshots(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, true, time.Minute)
+	if len(rep.Errs) > 0 {
+		t.Fatalf("AnalyzeHistoryWithSnapshots(%v) generated unexpected errors: %v", input, rep.Errs)
+	}
+	if !reflect.DeepEqual(rep.Snapshots, want) {
+		t.Errorf("AnalyzeHistoryWithSnapshots(%v).Snapshots\n got: %+v\n want: %+v", input, rep.Snapshots, want)
+	}
+	if rep := AnalyzeHistory(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, true); rep.Snapshots != nil {
+		t.Errorf("AnalyzeHistory(%v).Snapshots = %+v, want nil", input, rep.Snapshots)
+	}
+}
+
+// TestFinalState tests the capture of the device state at the end of the history.
+func TestFinalState(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,19,10008,"com.android.providers.downloads/.DownloadIdleService"`,
+		`9,hsp,20,10045,"com.example.app.provider/com.example"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=80,Bs=d,Bp=n`,
+		`9,h,30000,+S`,
+		`9,h,60000,+Ejb=19,+Esy=20,+w=20`,
+		`9,h,30000,-S,Bl=79`,
+	}, "\n")
+	want := &DeviceStateSnapshot{
+		TimeMs:       1120000,
+		BatteryLevel: 79,
+		WakeLockHeld: true,
+		Jobs:         []SnapshotEntity{{Service: "com.android.providers.downloads/.DownloadIdleService", UID: "10008"}},
+		Syncs:        []SnapshotEntity{{Service: "com.example.app.provider/com.example", UID: "10045"}},
+	}
+
+	rep := AnalyzeHistory(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	if len(rep.Errs) > 0 {
+		t.Fatalf("AnalyzeHistory(%v) generated unexpected errors: %v", input, rep.Errs)
+	}
+	if !reflect.DeepEqual(rep.FinalState, want) {
+		t.Errorf("AnalyzeHistory(%v).FinalState\n got: %+v\n want: %+v", input, rep.FinalState, want)
+	}
+	if rep := AnalyzeHistory(ioutil.Discard, "", FormatTotalTime, emptyUIDPackageMapping, true); rep.FinalState != nil {
+		t.Errorf("AnalyzeHistory of an empty history .FinalState = %+v, want nil", rep.FinalState)
+	}
+}
+
+// TestDeviceStateAt tests the reconstruction of the device state at a given time.
+func TestDeviceStateAt(t *testing.T) {
+	input := strings.Join([]string{
+		`9,0,i,vers,11,116,LMY06B,LMY06B`,
+		`9,hsp,19,10008,"com.android.providers.downloads/.DownloadIdleService"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=80,Bs=d,Bp=n`,
+		`9,h,30000,+S`,
+		`9,h,60000,+Ejb=19`,
+		`9,h,60000,-S,-Ejb=19`,
+		`9,h,30000,Bl=79`,
+		`9,h,1000:START`,
+		`9,h,0:TIME:2000000`,
+		`9,h,10000,Bl=78`,
+	}, "\n")
+	job := []SnapshotEntity{{Service: "com.android.providers.downloads/.DownloadIdleService", UID: "10008"}}
+	tests := []struct {
+		desc   string
+		timeMs int64
+		want   *DeviceStateSnapshot
+	}{
+		{
+			desc:   "Start of the history",
+			timeMs: 1000000,
+			want:   &DeviceStateSnapshot{TimeMs: 1000000, BatteryLevel: 80},
+		},
+		{
+			desc:   "Job running",
+			timeMs: 1100000,
+			want:   &DeviceStateSnapshot{TimeMs: 1100000, BatteryLevel: 80, ScreenOn: true, Jobs: job},
+		},
+		{
+			desc:   "Time of an event",
+			timeMs: 1150000,
+			want:   &DeviceStateSnapshot{TimeMs: 1150000, BatteryLevel: 80},
+		},
+		{
+			desc:   "Rebooting",
+			timeMs: 1500000,
+		},
+		{
+			desc:   "End of the history",
+			timeMs: 2010000,
+			want:   &DeviceStateSnapshot{TimeMs: 2010000, BatteryLevel: 78},
+		},
+		{
+			desc:   "Before the history",
+			timeMs: 999999,
+		},
+		{
+			desc:   "After the history",
+			timeMs: 2010001,
+		},
+	}
+	for _, test := range tests {
+		got, errs := DeviceStateAt(input, emptyUIDPackageMapping, test.timeMs)
+		if len(errs) > 0 {
+			t.Errorf("%v: DeviceStateAt generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: DeviceStateAt(%d)\n got: %+v\n want: %+v", test.desc, test.timeMs, got, test.want)
+		}
+	}
+}
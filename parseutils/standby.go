@@ -0,0 +1,208 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// standby.go builds the periods apps were marked inactive by app standby, from the package
+// active (Eaa) and inactive (Eai) events, and finds the background work apps still did while
+// inactive, which the platform should have deferred.
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// AppInactiveMetric is the CSV description of the periods an app was marked inactive.
+	AppInactiveMetric = "App inactive"
+
+	// Battery history CSV metrics for the app standby events.
+	packageActive   = "Package active"
+	packageInactive = "Package inactive"
+)
+
+// standbyWorkMetrics are the battery history CSV metrics of background work checked during inactive periods.
+var standbyWorkMetrics = []string{"JobScheduler", "SyncManager", alarm, LongWakelocks, "Wakelock_in"}
+
+// AppStandby is the time an app was marked inactive, and the background work it did while inactive.
+type AppStandby struct {
+	Package string
+	// UID is the app ID the events were logged with.
+	UID string
+	// InactiveCount is the number of times the app was marked inactive.
+	InactiveCount int
+	Inactive      time.Duration
+	// WorkCount is the number of background work events overlapping the inactive periods.
+	WorkCount int
+	// Work is the time the app had background work active while inactive.
+	Work time.Duration
+	// WorkMetrics are the metrics of the background work done while inactive, e.g. "JobScheduler".
+	WorkMetrics []string
+}
+
+// byWork sorts apps in descending order of work while inactive, then of inactive time, then by package.
+type byWork []AppStandby
+
+func (a byWork) Len() int      { return len(a) }
+func (a byWork) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byWork) Less(i, j int) bool {
+	if a[i].Work != a[j].Work {
+		return a[i].Work > a[j].Work
+	}
+	if a[i].Inactive != a[j].Inactive {
+		return a[i].Inactive > a[j].Inactive
+	}
+	return a[i].Package < a[j].Package
+}
+
+// historyEnd returns the end of the history, which the battery level events span.
+func historyEnd(levels []csv.Event) int64 {
+	var end int64
+	for _, e := range levels {
+		if e.End > end {
+			end = e.End
+		}
+	}
+	return end
+}
+
+// standbyChange is a package active or inactive event.
+type standbyChange struct {
+	csv.Event
+	inactive bool
+}
+
+// byChangeStart sorts standby changes in ascending order of start time.
+type byChangeStart []standbyChange
+
+func (a byChangeStart) Len() int           { return len(a) }
+func (a byChangeStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byChangeStart) Less(i, j int) bool { return a[i].Start < a[j].Start }
+
+// inactivePeriods returns the periods each app was inactive, from each package inactive event to the
+// next package active event of the app, or the end of the history. The events have the package name as
+// value and the app ID as opt, which are kept in the returned events.
+func inactivePeriods(active, inactive []csv.Event, endMs int64) []csv.Event {
+	var changes []standbyChange
+	for _, e := range active {
+		changes = append(changes, standbyChange{e, false})
+	}
+	for _, e := range inactive {
+		changes = append(changes, standbyChange{e, true})
+	}
+	sort.Stable(byChangeStart(changes))
+
+	type app struct {
+		pkg, uid string
+	}
+	var apps []app
+	seen := make(map[app]bool)
+	starts := make(map[app]int64)
+	var periods []csv.Event
+	for _, c := range changes {
+		a := app{c.Value, c.Opt}
+		start, isInactive := starts[a]
+		switch {
+		case c.inactive && !isInactive:
+			if !seen[a] {
+				seen[a] = true
+				apps = append(apps, a)
+			}
+			starts[a] = c.Start
+		case !c.inactive && isInactive:
+			delete(starts, a)
+			periods = append(periods, csv.Event{Type: "service", Start: start, End: c.Start, Value: a.pkg, Opt: a.uid})
+		}
+	}
+	// Apps still inactive at the end of the history, in the order they first became inactive.
+	for _, a := range apps {
+		if start, ok := starts[a]; ok && endMs > start {
+			periods = append(periods, csv.Event{Type: "service", Start: start, End: endMs, Value: a.pkg, Opt: a.uid})
+		}
+	}
+	sort.Stable(sortByStart(periods))
+	return periods
+}
+
+// appInactivePeriods extracts the periods apps were inactive from the battery history CSV events.
+func appInactivePeriods(es map[string][]csv.Event) []csv.Event {
+	return inactivePeriods(es[packageActive], es[packageInactive], historyEnd(es[BatteryLevel]))
+}
+
+// WriteAppInactive writes an AppInactiveMetric row for each period an app was marked inactive in the
+// battery history CSV, so they can be shown on the timeline.
+func WriteAppInactive(w io.Writer, csvInput string) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{packageActive, packageInactive, BatteryLevel})
+	csvState := csv.NewState(w, false)
+	for _, e := range appInactivePeriods(es) {
+		csvState.PrintEvent(AppInactiveMetric, e)
+	}
+	return errs
+}
+
+// AppStandbyUsage computes the time each app was marked inactive in the battery history CSV generated
+// by AnalyzeHistory, and the jobs, syncs, alarms and wakelocks of the app overlapping those periods.
+func AppStandbyUsage(csvInput string) ([]AppStandby, []error) {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{packageActive, packageInactive, BatteryLevel}, standbyWorkMetrics...))
+	periods := appInactivePeriods(es)
+
+	// The inactive periods of each app are in ascending order of start time, and don't overlap.
+	type app struct {
+		pkg, uid string
+	}
+	var apps []app
+	inactive := make(map[app][]csv.Event)
+	for _, p := range periods {
+		a := app{p.Value, p.Opt}
+		if _, ok := inactive[a]; !ok {
+			apps = append(apps, a)
+		}
+		inactive[a] = append(inactive[a], p)
+	}
+
+	var usage []AppStandby
+	for _, a := range apps {
+		u := AppStandby{Package: a.pkg, UID: a.uid, InactiveCount: len(inactive[a])}
+		for _, p := range inactive[a] {
+			u.Inactive += time.Duration(p.End-p.Start) * time.Millisecond
+		}
+		var work []csv.Event
+		for _, m := range standbyWorkMetrics {
+			found := false
+			for _, e := range es[m] {
+				// Instant events, such as alarms, only have a start time.
+				if e.Opt != a.uid || (csv.Overlap(inactive[a], e.Start, e.End) == 0 && !inWindow(inactive[a], e.Start)) {
+					continue
+				}
+				u.WorkCount++
+				work = append(work, e)
+				found = true
+			}
+			if found {
+				u.WorkMetrics = append(u.WorkMetrics, m)
+			}
+		}
+		var workMs int64
+		for _, e := range csv.MergeEvents(work) {
+			workMs += csv.Overlap(inactive[a], e.Start, e.End)
+		}
+		u.Work = time.Duration(workMs) * time.Millisecond
+		usage = append(usage, u)
+	}
+	sort.Sort(byWork(usage))
+	return usage, errs
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAppStandby tests the reconstruction of the app inactive periods and the work done while inactive.
+func TestAppStandby(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,100000,90,`,
+		`Package inactive,service,10000,10000,"com.example.a",10001`,
+		// Repeated inactive events don't restart the period.
+		`Package inactive,service,15000,15000,"com.example.a",10001`,
+		`Package active,service,30000,30000,"com.example.a",10001`,
+		`Package inactive,service,60000,60000,"com.example.a",10001`,
+		// Still inactive at the end of the history.
+		`Package inactive,service,20000,20000,"com.example.b",10002`,
+		// Active events for apps that weren't inactive are ignored.
+		`Package active,service,25000,25000,"com.example.c",10003`,
+		`JobScheduler,service,5000,12000,"com.example.a/.SyncJob",10001`,
+		`SyncManager,service,11000,14000,"com.example.a/com.example.a.provider",10001`,
+		// Work by another app.
+		`JobScheduler,service,12000,20000,"com.example.c/.Job",10003`,
+		// Work while the app was active.
+		`Wakelock_in,service,40000,50000,"*job*/com.example.a/.SyncJob",10001`,
+		`Alarm,service,70000,70000,"*walarm*:com.example.a.SYNC",10001`,
+	}, "\n")
+
+	var b bytes.Buffer
+	if errs := WriteAppInactive(&b, input); len(errs) > 0 {
+		t.Fatalf("WriteAppInactive generated unexpected errors: %v", errs)
+	}
+	want := strings.Join([]string{
+		"App inactive,service,10000,30000,com.example.a,10001",
+		"App inactive,service,20000,100000,com.example.b,10002",
+		"App inactive,service,60000,100000,com.example.a,10001",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("WriteAppInactive(%v)\n got: %q\n want: %q", input, got, want)
+	}
+
+	wantUsage := []AppStandby{
+		{
+			Package:       "com.example.a",
+			UID:           "10001",
+			InactiveCount: 2,
+			Inactive:      60 * time.Second,
+			WorkCount:     3,
+			// The job and sync overlap between 11s and 12s.
+			Work:        4 * time.Second,
+			WorkMetrics: []string{"JobScheduler", "SyncManager", "Alarm"},
+		},
+		{
+			Package:       "com.example.b",
+			UID:           "10002",
+			InactiveCount: 1,
+			Inactive:      80 * time.Second,
+		},
+	}
+	usage, errs := AppStandbyUsage(input)
+	if len(errs) > 0 {
+		t.Fatalf("AppStandbyUsage generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(usage, wantUsage) {
+		t.Errorf("AppStandbyUsage(%v)\n got: %+v\n want: %+v", input, usage, wantUsage)
+	}
+}
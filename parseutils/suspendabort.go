@@ -0,0 +1,94 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// suspendabort.go separates the CPU running time caused by aborted suspends from the time caused by
+// genuine wakeups. An aborted suspend keeps the CPU awake, but isn't a new wakeup, so counting it with
+// the wakeups inflates both the number of wakeups and their duration.
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// SuspendAbortMetric is the battery history CSV metric for the CPU running periods only caused by
+	// aborted suspends.
+	SuspendAbortMetric = "CPU running (suspend abort)"
+
+	// abortPrefix is the prefix of the wakeup reasons of aborted suspends.
+	// e.g. "Abort:Last active Wakeup Source: eventpoll"
+	abortPrefix = "Abort:"
+)
+
+// IsSuspendAbort returns whether the CPU running event was only caused by aborted suspends, i.e. it
+// has wakeup reasons and they all start with abortPrefix. The event value is a list of wakeup reasons
+// separated by "|", each formatted as start~end~reason or start~reason.
+func IsSuspendAbort(e csv.Event) bool {
+	if e.Value == "" {
+		return false
+	}
+	for _, wr := range strings.Split(e.Value, "|") {
+		parts := strings.Split(wr, "~")
+		if !strings.HasPrefix(parts[len(parts)-1], abortPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddSuspendAbortSummaries populates the CPURunningWakeupSummary and CPURunningAbortSummary of each
+// summary from the battery history CSV generated by AnalyzeHistory. Each CPU running event is in the
+// summary it started in, in one of them depending on its wakeup reasons, with its duration clipped to the summary.
+func AddSuspendAbortSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{cpuRunning})
+	for i := range summaries {
+		s := &summaries[i]
+		s.CPURunningWakeupSummary = Dist{}
+		s.CPURunningAbortSummary = Dist{}
+		for _, e := range es[cpuRunning] {
+			if e.Start < s.StartTimeMs || e.Start >= s.EndTimeMs {
+				continue
+			}
+			end := e.End
+			if end > s.EndTimeMs {
+				end = s.EndTimeMs
+			}
+			d := time.Duration(end-e.Start) * time.Millisecond
+			if IsSuspendAbort(e) {
+				s.CPURunningAbortSummary.addDuration(d)
+			} else {
+				s.CPURunningWakeupSummary.addDuration(d)
+			}
+		}
+	}
+	return errs
+}
+
+// WriteSuspendAborts writes a SuspendAbortMetric row for each CPU running period only caused by
+// aborted suspends found in the battery history CSV, so they can be told apart on the timeline.
+func WriteSuspendAborts(w io.Writer, csvInput string) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{cpuRunning})
+	csvState := csv.NewState(w, false)
+	for _, e := range es[cpuRunning] {
+		if IsSuspendAbort(e) {
+			csvState.PrintEvent(SuspendAbortMetric, e)
+		}
+	}
+	return errs
+}
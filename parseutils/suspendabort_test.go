@@ -0,0 +1,82 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+var suspendAbortInput = strings.Join([]string{
+	csv.FileHeader,
+	`CPU running,string,10000,12000,"10000~12000~200:qcom,smd-rpm",`,
+	`CPU running,string,20000,21000,"20000~21000~Abort:Last active Wakeup Source: eventpoll",`,
+	// A wakeup followed by an abort is still a wakeup.
+	`CPU running,string,30000,33000,"30000~31000~200:qcom,smd-rpm|31000~33000~Abort:Pending Wakeup Sources: ipc",`,
+	`CPU running,string,40000,40000,40000~` + csv.UnknownWakeup + `,`,
+	// Clipped to the end of the first summary.
+	`CPU running,string,98000,104000,"98000~Abort:Some devices failed to suspend|99000~104000~Abort:Pending Wakeup Sources: ipc",`,
+	`CPU running,string,110000,115000,"110000~115000~Abort:Last active Wakeup Source: eventpoll",`,
+}, "\n")
+
+// TestAddSuspendAbortSummaries tests the separation of the CPU running time caused by aborted suspends.
+func TestAddSuspendAbortSummaries(t *testing.T) {
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 100000},
+		{StartTimeMs: 100000, EndTimeMs: 200000},
+	}
+	if errs := AddSuspendAbortSummaries(suspendAbortInput, summaries); len(errs) > 0 {
+		t.Fatalf("AddSuspendAbortSummaries generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		wakeups, aborts Dist
+	}{
+		{
+			wakeups: Dist{Num: 3, TotalDuration: 5 * time.Second, MaxDuration: 3 * time.Second},
+			aborts:  Dist{Num: 2, TotalDuration: 3 * time.Second, MaxDuration: 2 * time.Second},
+		},
+		{
+			aborts: Dist{Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+		},
+	}
+	for i, w := range want {
+		if got := summaries[i].CPURunningWakeupSummary; got != w.wakeups {
+			t.Errorf("Summary %d CPURunningWakeupSummary = %v, want %v", i, got, w.wakeups)
+		}
+		if got := summaries[i].CPURunningAbortSummary; got != w.aborts {
+			t.Errorf("Summary %d CPURunningAbortSummary = %v, want %v", i, got, w.aborts)
+		}
+	}
+}
+
+// TestWriteSuspendAborts tests that only the CPU running periods caused by aborted suspends are written.
+func TestWriteSuspendAborts(t *testing.T) {
+	var b bytes.Buffer
+	if errs := WriteSuspendAborts(&b, suspendAbortInput); len(errs) > 0 {
+		t.Fatalf("WriteSuspendAborts generated unexpected errors: %v", errs)
+	}
+	want := strings.Join([]string{
+		`CPU running (suspend abort),string,20000,21000,20000~21000~Abort:Last active Wakeup Source: eventpoll,`,
+		`CPU running (suspend abort),string,98000,104000,98000~Abort:Some devices failed to suspend|99000~104000~Abort:Pending Wakeup Sources: ipc,`,
+		`CPU running (suspend abort),string,110000,115000,110000~115000~Abort:Last active Wakeup Source: eventpoll,`,
+	}, "\n") + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteSuspendAborts wrote:\n%s\nwant:\n%s", got, want)
+	}
+}
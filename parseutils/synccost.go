@@ -0,0 +1,129 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// synccost.go weights the time each app spent syncing by the default network it synced over. A sync
+// over mobile keeps the mobile radio in its high power state for its duration and the tail after it,
+// so costs several times more than the same sync over wifi.
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// syncMetric is the battery history CSV metric for syncs.
+	syncMetric = "SyncManager"
+
+	// offNetworkSyncWeight is the weight of the sync time without a known default network, e.g. before
+	// the first network connectivity event or without connectivity, which only costs the CPU time.
+	offNetworkSyncWeight = 1.0
+
+	// maxSyncCost is the number of apps kept in each SyncCostSummary.
+	maxSyncCost = 10
+)
+
+// syncNetworkWeight is the cost of a unit of sync time over each default network type, relative to
+// wifi.
+var syncNetworkWeight = map[string]float64{
+	"TYPE_ETHERNET":  1,
+	"TYPE_WIFI":      1,
+	"TYPE_BLUETOOTH": 1.5,
+	"TYPE_WIMAX":     3,
+	"TYPE_MOBILE":    3,
+}
+
+// SyncCost contains the sync time of an app, weighted by the default network it synced over.
+type SyncCost struct {
+	// UID is the app UID the syncs are attributed to, empty if unknown.
+	UID   string
+	Syncs int
+	// Duration is the total sync time, and MobileDuration and WifiDuration the parts of it over mobile
+	// and wifi.
+	Duration       time.Duration
+	MobileDuration time.Duration
+	WifiDuration   time.Duration
+	// Weighted is the sync time weighted by the network type, in wifi equivalent time.
+	Weighted time.Duration
+}
+
+// bySyncCost sorts the sync costs in descending order of weighted time, then by UID.
+type bySyncCost []SyncCost
+
+func (a bySyncCost) Len() int      { return len(a) }
+func (a bySyncCost) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySyncCost) Less(i, j int) bool {
+	if a[i].Weighted != a[j].Weighted {
+		return a[i].Weighted > a[j].Weighted
+	}
+	return a[i].UID < a[j].UID
+}
+
+// AddSyncCostSummaries populates the SyncCostSummary of each summary from the battery history CSV
+// generated by AnalyzeHistory, with the apps with the highest network weighted sync time. Each sync is
+// clipped to the summary, and each part of it is weighted by the default network at the time.
+func AddSyncCostSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{syncMetric, networkConnectivity})
+	defaults, _ := defaultNetworks(es[networkConnectivity])
+	syncs := es[syncMetric]
+
+	for i := range summaries {
+		s := &summaries[i]
+		in := s.Interval()
+		costs := make(map[string]*SyncCost)
+		for _, e := range syncs {
+			sync := in.Intersect(e.Interval())
+			if sync.Empty() {
+				continue
+			}
+			c, ok := costs[e.Opt]
+			if !ok {
+				c = &SyncCost{UID: e.Opt}
+				costs[e.Opt] = c
+			}
+			c.Syncs++
+			c.Duration += time.Duration(sync.Duration()) * time.Millisecond
+			var onNetwork int64
+			var weighted float64
+			for _, n := range defaults {
+				d := sync.Intersect(n.Interval()).Duration()
+				if d == 0 {
+					continue
+				}
+				onNetwork += d
+				weighted += float64(d) * syncNetworkWeight[n.Value]
+				switch n.Value {
+				case "TYPE_MOBILE":
+					c.MobileDuration += time.Duration(d) * time.Millisecond
+				case "TYPE_WIFI":
+					c.WifiDuration += time.Duration(d) * time.Millisecond
+				}
+			}
+			weighted += float64(sync.Duration()-onNetwork) * offNetworkSyncWeight
+			c.Weighted += time.Duration(weighted) * time.Millisecond
+		}
+		s.SyncCostSummary = nil
+		for _, c := range costs {
+			s.SyncCostSummary = append(s.SyncCostSummary, *c)
+		}
+		sort.Sort(bySyncCost(s.SyncCostSummary))
+		if len(s.SyncCostSummary) > maxSyncCost {
+			s.SyncCostSummary = s.SyncCostSummary[:maxSyncCost]
+		}
+	}
+	return errs
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddSyncCostSummaries tests the weighting of the sync time by the default network.
+func TestAddSyncCostSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		// Mobile is the default network until wifi connects.
+		`Network connectivity,service,0,100000,"TYPE_MOBILE:""CONNECTED""",`,
+		`Network connectivity,service,50000,100000,"TYPE_WIFI:""CONNECTED""",`,
+		// Half over mobile, half over wifi.
+		`SyncManager,service,20000,80000,"com.example.mail/com.example:user@example.com",10045`,
+		// Over wifi, then without a network.
+		`SyncManager,service,60000,70000,"com.example.news/com.example:user@example.com",10046`,
+		`SyncManager,service,100000,120000,"com.example.news/com.example:user@example.com",10046`,
+		// Clipped to the summary.
+		`SyncManager,service,120000,200000,"com.example.photos/com.example:user@example.com",10047`,
+	}, "\n")
+	summaries := []ActivitySummary{{StartTimeMs: 0, EndTimeMs: 150000}}
+	if errs := AddSyncCostSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddSyncCostSummaries generated unexpected errors: %v", errs)
+	}
+	want := []SyncCost{
+		{UID: "10045", Syncs: 1, Duration: time.Minute, MobileDuration: 30 * time.Second, WifiDuration: 30 * time.Second, Weighted: 2 * time.Minute},
+		{UID: "10046", Syncs: 2, Duration: 30 * time.Second, WifiDuration: 10 * time.Second, Weighted: 30 * time.Second},
+		{UID: "10047", Syncs: 1, Duration: 30 * time.Second, Weighted: 30 * time.Second},
+	}
+	if got := summaries[0].SyncCostSummary; !reflect.DeepEqual(got, want) {
+		t.Errorf("AddSyncCostSummaries()\n got: %+v\n want: %+v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// tethering.go breaks down the time and battery drain of each summary by tethering type, such as a
+// Wi-Fi hotspot, from the tethering sessions added to the battery history CSV by the tethering package.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// tethering is the battery history CSV metric of tethering sessions, added by the tethering package.
+// The values are the tethering type followed by the interface, e.g. "Wi-Fi hotspot (wlan0)".
+const tethering = "Tethering"
+
+// AddTetheringSummaries populates the TetheringSummary and TetheringLevelDrop of each summary from the
+// tethering sessions in the battery history CSV generated by AnalyzeHistory. Overlapping sessions of
+// the same type, e.g. on two interfaces, are counted once.
+func AddTetheringSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{tethering, BatteryLevel})
+	sessions := make(map[string][]csv.Event)
+	for _, e := range es[tethering] {
+		k := e.Value
+		if i := strings.LastIndex(k, " ("); i > 0 {
+			k = k[:i]
+		}
+		sessions[k] = append(sessions[k], e)
+	}
+	for k, ss := range sessions {
+		sessions[k] = csv.MergeEvents(ss)
+	}
+	drops := levelDrops(es[BatteryLevel])
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.TetheringSummary = make(map[string]Dist)
+		s.TetheringLevelDrop = make(map[string]int)
+		for k, ss := range sessions {
+			var in []csv.Event
+			for _, e := range ss {
+				if d := csv.Overlap([]csv.Event{e}, s.StartTimeMs, s.EndTimeMs); d > 0 {
+					dist := s.TetheringSummary[k]
+					dist.addDuration(time.Duration(d) * time.Millisecond)
+					s.TetheringSummary[k] = dist
+					in = append(in, e)
+				}
+			}
+			for _, d := range drops {
+				if d.Start <= s.StartTimeMs || d.Start > s.EndTimeMs || !inWindow(in, d.Start) {
+					continue
+				}
+				n, _ := strconv.Atoi(d.Value)
+				s.TetheringLevelDrop[k] += n
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddTetheringSummaries tests the breakdown of each summary by tethering type.
+func TestAddTetheringSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,60000,90,`,
+		`Battery Level,int,60000,120000,89,`,
+		`Battery Level,int,120000,180000,87,`,
+		`Battery Level,int,180000,240000,86,`,
+		`Battery Level,int,240000,300000,85,`,
+		// The overlapping hotspot sessions are merged.
+		`Tethering,string,30000,100000,Wi-Fi hotspot (wlan0),`,
+		`Tethering,string,90000,130000,Wi-Fi hotspot (swlan0),`,
+		`Tethering,string,170000,250000,Bluetooth (bt-pan),`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 150000},
+		{StartTimeMs: 150000, EndTimeMs: 300000},
+	}
+	if errs := AddTetheringSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddTetheringSummaries generated unexpected errors: %v", errs)
+	}
+	want := []struct {
+		sessions map[string]Dist
+		drops    map[string]int
+	}{
+		{
+			sessions: map[string]Dist{
+				"Wi-Fi hotspot": {Num: 1, TotalDuration: 100 * time.Second, MaxDuration: 100 * time.Second},
+			},
+			// The level drops at 60s and 120s are while tethering.
+			drops: map[string]int{"Wi-Fi hotspot": 3},
+		},
+		{
+			sessions: map[string]Dist{
+				"Bluetooth": {Num: 1, TotalDuration: 80 * time.Second, MaxDuration: 80 * time.Second},
+			},
+			drops: map[string]int{"Bluetooth": 2},
+		},
+	}
+	for i, w := range want {
+		s := summaries[i]
+		if !reflect.DeepEqual(s.TetheringSummary, w.sessions) {
+			t.Errorf("Summary %d TetheringSummary\n got: %v\n want: %v", i, s.TetheringSummary, w.sessions)
+		}
+		if !reflect.DeepEqual(s.TetheringLevelDrop, w.drops) {
+			t.Errorf("Summary %d TetheringLevelDrop\n got: %v\n want: %v", i, s.TetheringLevelDrop, w.drops)
+		}
+	}
+}
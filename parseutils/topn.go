@@ -0,0 +1,128 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// topn.go truncates the per app and per state summary maps to their top entries, as reports with
+// thousands of apps otherwise generate very large responses and exports.
+
+import (
+	"reflect"
+	"sort"
+)
+
+// OthersKey is the summary map key the entries beyond the top N are rolled up into.
+const OthersKey = "others"
+
+// distMapType is the type of the summary maps that are truncated.
+var distMapType = reflect.TypeOf(map[string]Dist{})
+
+// TopN returns the n entries of the map with the highest total duration, then count, with the
+// remaining entries rolled up into a single OthersKey entry. The map is returned unchanged if it
+// has at most n entries, or if n isn't positive.
+func TopN(m map[string]Dist, n int) map[string]Dist {
+	if n <= 0 || len(m) <= n {
+		return m
+	}
+	// Sort by name first, so that ties are broken deterministically.
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	stats := make([]MultiDist, 0, len(m))
+	for _, k := range names {
+		stats = append(stats, MultiDist{Name: k, Stat: m[k]})
+	}
+	sort.Stable(sort.Reverse(SortByTimeAndCount(stats)))
+
+	top := make(map[string]Dist, n+1)
+	for _, s := range stats[:n] {
+		top[s.Name] = s.Stat
+	}
+	others := top[OthersKey]
+	for _, s := range stats[n:] {
+		others.Num += s.Stat.Num
+		others.TotalDuration += s.Stat.TotalDuration
+		if s.Stat.MaxDuration > others.MaxDuration {
+			others.MaxDuration = s.Stat.MaxDuration
+		}
+	}
+	top[OthersKey] = others
+	return top
+}
+
+// rollUpDrops moves the level drops of the states that were rolled up in the truncated duration
+// map into OthersKey, so that the drops and durations still match.
+func rollUpDrops(drops map[string]int, durations map[string]Dist) {
+	if _, ok := durations[OthersKey]; !ok {
+		return
+	}
+	for k, d := range drops {
+		if _, ok := durations[k]; !ok {
+			drops[OthersKey] += d
+			delete(drops, k)
+		}
+	}
+}
+
+// truncateAppDists keeps the top n entries of each metric in the app stats, which are sorted by
+// decreasing total duration within each metric, and rolls the rest up into an OthersKey labelled entry.
+func truncateAppDists(ds []AppDist, n int) []AppDist {
+	var top []AppDist
+	count, others := 0, -1
+	for i, d := range ds {
+		if i == 0 || d.Metric != ds[i-1].Metric {
+			count, others = 0, -1
+		}
+		if count++; count <= n {
+			top = append(top, d)
+			continue
+		}
+		if others < 0 {
+			top = append(top, AppDist{Metric: d.Metric, Key: AppKey{Label: OthersKey}})
+			others = len(top) - 1
+		}
+		o := &top[others]
+		o.Num += d.Num
+		o.TotalDurationMs += d.TotalDurationMs
+		if d.MaxDurationMs > o.MaxDurationMs {
+			o.MaxDurationMs = d.MaxDurationMs
+		}
+	}
+	return top
+}
+
+// TruncateSummaries truncates every map[string]Dist field of the summaries, such as the per app
+// wakelock and sync breakdowns, to its top n entries using TopN, and each metric of the AppDists
+// the same way. Nothing is truncated if n isn't positive.
+func TruncateSummaries(summaries []ActivitySummary, n int) {
+	if n <= 0 {
+		return
+	}
+	for i := range summaries {
+		v := reflect.ValueOf(&summaries[i]).Elem()
+		for j := 0; j < v.NumField(); j++ {
+			if f := v.Field(j); f.Type() == distMapType && !f.IsNil() {
+				f.Set(reflect.ValueOf(TopN(f.Interface().(map[string]Dist), n)))
+			}
+		}
+		s := &summaries[i]
+		rollUpDrops(s.BodyStateLevelDrop, s.BodyStateSummary)
+		rollUpDrops(s.CallLevelDrop, s.CallSummary)
+		rollUpDrops(s.TetheringLevelDrop, s.TetheringSummary)
+		rollUpDrops(s.MotionStateLevelDrop, s.MotionStateSummary)
+		s.AppDists = truncateAppDists(s.AppDists, n)
+	}
+}
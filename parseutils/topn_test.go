@@ -0,0 +1,137 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTopN tests truncating summary maps to their top entries.
+func TestTopN(t *testing.T) {
+	m := map[string]Dist{
+		"a": {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+		"b": {Num: 3, TotalDuration: 10 * time.Second, MaxDuration: 6 * time.Second},
+		// Same duration as c, but more events.
+		"c": {Num: 2, TotalDuration: 2 * time.Second, MaxDuration: time.Second},
+		"d": {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+		"e": {Num: 4, TotalDuration: time.Second, MaxDuration: 500 * time.Millisecond},
+	}
+	tests := []struct {
+		desc string
+		n    int
+		want map[string]Dist
+	}{
+		{
+			desc: "disabled",
+			n:    0,
+			want: m,
+		},
+		{
+			desc: "fewer entries than n",
+			n:    5,
+			want: m,
+		},
+		{
+			desc: "truncated",
+			n:    3,
+			want: map[string]Dist{
+				"a":       {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+				"b":       {Num: 3, TotalDuration: 10 * time.Second, MaxDuration: 6 * time.Second},
+				"c":       {Num: 2, TotalDuration: 2 * time.Second, MaxDuration: time.Second},
+				OthersKey: {Num: 5, TotalDuration: 3 * time.Second, MaxDuration: 2 * time.Second},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := TopN(m, test.n); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: TopN(%v, %d)\n got: %v\n want: %v", test.desc, m, test.n, got, test.want)
+		}
+	}
+}
+
+// TestTruncateSummaries tests that all summary maps are truncated, and the matching level drops rolled up.
+func TestTruncateSummaries(t *testing.T) {
+	summaries := []ActivitySummary{
+		{
+			WakeLockSummary: map[string]Dist{
+				"w1": {Num: 1, TotalDuration: 3 * time.Second, MaxDuration: 3 * time.Second},
+				"w2": {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+			},
+			PerAppSyncSummary: map[string]Dist{
+				"s1": {Num: 1, TotalDuration: time.Second, MaxDuration: time.Second},
+			},
+			CallSummary: map[string]Dist{
+				CellularCall:         {Num: 1, TotalDuration: time.Minute, MaxDuration: time.Minute},
+				"VoIP: com.whatsapp": {Num: 1, TotalDuration: 30 * time.Second, MaxDuration: 30 * time.Second},
+			},
+			CallLevelDrop: map[string]int{CellularCall: 2, "VoIP: com.whatsapp": 1},
+			TetheringSummary: map[string]Dist{
+				"rndis0": {Num: 1, TotalDuration: time.Hour, MaxDuration: time.Hour},
+				"wlan1":  {Num: 2, TotalDuration: time.Minute, MaxDuration: 40 * time.Second},
+			},
+			TetheringLevelDrop: map[string]int{"rndis0": 5, "wlan1": 1},
+			MotionStateSummary: map[string]Dist{
+				MotionStationary: {Num: 2, TotalDuration: 2 * time.Hour, MaxDuration: time.Hour},
+				MotionMoving:     {Num: 1, TotalDuration: 10 * time.Minute, MaxDuration: 10 * time.Minute},
+				MotionCommuting:  {Num: 1, TotalDuration: 20 * time.Minute, MaxDuration: 20 * time.Minute},
+			},
+			MotionStateLevelDrop: map[string]int{MotionStationary: 4, MotionMoving: 1, MotionCommuting: 2},
+			AppDists: []AppDist{
+				{Metric: "AlarmSummary", Key: AppKey{UID: 10011, Label: "*alarm*"}, Num: 1, TotalDurationMs: 100, MaxDurationMs: 100},
+				{Metric: "WakeLockSummary", Key: AppKey{UID: 10011, Label: "w1"}, Num: 1, TotalDurationMs: 3000, MaxDurationMs: 3000},
+				{Metric: "WakeLockSummary", Key: AppKey{UID: 10023, Label: "w1"}, Num: 2, TotalDurationMs: 2000, MaxDurationMs: 1500},
+				{Metric: "WakeLockSummary", Key: AppKey{UID: 10023, Label: "w2"}, Num: 1, TotalDurationMs: 1000, MaxDurationMs: 1000},
+			},
+		},
+	}
+	TruncateSummaries(summaries, 1)
+
+	s := summaries[0]
+	wantWakelocks := map[string]Dist{
+		"w1":      {Num: 1, TotalDuration: 3 * time.Second, MaxDuration: 3 * time.Second},
+		OthersKey: {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+	}
+	if !reflect.DeepEqual(s.WakeLockSummary, wantWakelocks) {
+		t.Errorf("WakeLockSummary\n got: %v\n want: %v", s.WakeLockSummary, wantWakelocks)
+	}
+	wantSyncs := map[string]Dist{
+		"s1": {Num: 1, TotalDuration: time.Second, MaxDuration: time.Second},
+	}
+	if !reflect.DeepEqual(s.PerAppSyncSummary, wantSyncs) {
+		t.Errorf("PerAppSyncSummary\n got: %v\n want: %v", s.PerAppSyncSummary, wantSyncs)
+	}
+	wantAppDists := []AppDist{
+		{Metric: "AlarmSummary", Key: AppKey{UID: 10011, Label: "*alarm*"}, Num: 1, TotalDurationMs: 100, MaxDurationMs: 100},
+		{Metric: "WakeLockSummary", Key: AppKey{UID: 10011, Label: "w1"}, Num: 1, TotalDurationMs: 3000, MaxDurationMs: 3000},
+		{Metric: "WakeLockSummary", Key: AppKey{Label: OthersKey}, Num: 3, TotalDurationMs: 3000, MaxDurationMs: 1500},
+	}
+	if !reflect.DeepEqual(s.AppDists, wantAppDists) {
+		t.Errorf("AppDists\n got: %v\n want: %v", s.AppDists, wantAppDists)
+	}
+	wantDrops := map[string]int{CellularCall: 2, OthersKey: 1}
+	if !reflect.DeepEqual(s.CallLevelDrop, wantDrops) {
+		t.Errorf("CallLevelDrop\n got: %v\n want: %v", s.CallLevelDrop, wantDrops)
+	}
+	wantTetheringDrops := map[string]int{"rndis0": 5, OthersKey: 1}
+	if !reflect.DeepEqual(s.TetheringLevelDrop, wantTetheringDrops) {
+		t.Errorf("TetheringLevelDrop\n got: %v\n want: %v", s.TetheringLevelDrop, wantTetheringDrops)
+	}
+	wantMotionDrops := map[string]int{MotionStationary: 4, OthersKey: 3}
+	if !reflect.DeepEqual(s.MotionStateLevelDrop, wantMotionDrops) {
+		t.Errorf("MotionStateLevelDrop\n got: %v\n want: %v", s.MotionStateLevelDrop, wantMotionDrops)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// uid.go normalizes the UID field of the history string pool entries, which isn't always the UID of
+// an app. Left as is, negative pseudo UIDs turned into negative app IDs, values too large for a UID
+// wrapped around into the app IDs of unrelated apps, and the modulo of the per user range turned
+// version codes into the app IDs of unrelated apps, e.g. the version code 10110061 into app 10061.
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// uidField is what the UID field of a history string pool entry holds, which depends on the event
+// the entry is logged for.
+type uidField int
+
+const (
+	// appUID is the UID of the app the entry is attributed to, including the user offset, e.g. 1010045
+	// for app 10045 of user 10.
+	appUID uidField = iota
+	// versionCode is the version code of the package, for package install and uninstall events.
+	versionCode
+)
+
+// normalizeUID returns the UID field of a history string pool entry as used for package matching and
+// in the CSV, given what the field holds:
+//   - A valid UID is returned in canonical decimal form, keeping the user offset, so that the secondary
+//     user of the app can still be told apart.
+//   - -1 (Process.INVALID_UID) and other negative pseudo UIDs, logged for entries not attributed to any
+//     app, return "".
+//   - Values too large for a UID, from buggy or synthetic sources, return "".
+//   - Version codes return "", as the package can only be matched from its name.
+//
+// The empty UID is matched from the entry's service name alone, and has app ID 0.
+func normalizeUID(uid string, f uidField) string {
+	if f == versionCode {
+		return ""
+	}
+	u, err := strconv.ParseInt(uid, 10, 32)
+	if err != nil || u < 0 {
+		return ""
+	}
+	return fmt.Sprint(u)
+}
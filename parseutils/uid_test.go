@@ -0,0 +1,79 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestNormalizeUID tests the normalization of the UID field of history string pool entries.
+func TestNormalizeUID(t *testing.T) {
+	tests := []struct {
+		desc  string
+		uid   string
+		field uidField
+		want  string
+	}{
+		{"App UID", "10045", appUID, "10045"},
+		{"System UID", "1000", appUID, "1000"},
+		{"Root UID", "0", appUID, "0"},
+		{"Secondary user keeps the user offset", "1010045", appUID, "1010045"},
+		{"Leading zeros", "010045", appUID, "10045"},
+		{"Invalid UID", "-1", appUID, ""},
+		{"Negative pseudo UID", "-2", appUID, ""},
+		{"Too large for a UID", "4294967295", appUID, ""},
+		{"Way too large for a UID", "99999999999999999999", appUID, ""},
+		{"High user ID", "10110061", appUID, "10110061"},
+		{"Version code", "10110061", versionCode, ""},
+	}
+	for _, test := range tests {
+		if got := normalizeUID(test.uid, test.field); got != test.want {
+			t.Errorf("%v: normalizeUID(%q, %v) = %q, want %q", test.desc, test.uid, test.field, got, test.want)
+		}
+	}
+}
+
+// TestStringPoolUIDs tests that odd UIDs in the string pool aren't taken for app UIDs.
+func TestStringPoolUIDs(t *testing.T) {
+	input := strings.Join([]string{
+		`9,hsp,1,-1,"kernel"`,
+		`9,hsp,2,4294967295,"synthetic"`,
+		`9,hsp,3,1010045,"com.example.work"`,
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,1000,Ewa=1`,
+		`9,h,1000,Ewa=2`,
+		`9,h,1000,Ewa=3`,
+	}, "\n")
+	want := strings.Join([]string{
+		csv.FileHeader,
+		`App Processor wakeup,service,1001000,1001000,kernel,0`,
+		`App Processor wakeup,service,1002000,1002000,synthetic,0`,
+		`App Processor wakeup,service,1003000,1003000,com.example.work,10045`,
+	}, "\n")
+	var b bytes.Buffer
+	rep := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	if len(rep.Errs) > 0 {
+		t.Fatalf("AnalyzeHistory generated unexpected errors: %v", rep.Errs)
+	}
+	got := normalizeCSV(b.String())
+	if w := normalizeCSV(want); !reflect.DeepEqual(got, w) {
+		t.Errorf("AnalyzeHistory(%v)\n got: %q\n want: %q", input, got, w)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// unsupported.go detects reports where too much of the history uses event codes we don't support,
+// in which case the generated summaries would be misleading.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unknownKeyError is returned when a history line contains an unsupported event code.
+type unknownKeyError string
+
+func (e unknownKeyError) Error() string {
+	return "unknown key " + string(e)
+}
+
+// unknownKeyCounts counts the history lines with unsupported event codes.
+type unknownKeyCounts struct {
+	// lines is the number of lines with at least one unsupported event code.
+	lines int
+	// keys maps each unsupported event code to the number of lines it was seen in.
+	keys map[string]int
+}
+
+func newUnknownKeyCounts() *unknownKeyCounts {
+	return &unknownKeyCounts{keys: make(map[string]int)}
+}
+
+// add counts a history line with the given unsupported event codes. It is a no-op for a nil receiver,
+// or if there are no codes.
+func (u *unknownKeyCounts) add(keys []string) {
+	if u == nil || len(keys) == 0 {
+		return
+	}
+	u.lines++
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if !seen[k] {
+			u.keys[k]++
+			seen[k] = true
+		}
+	}
+}
+
+// UnknownCode is an unsupported history event code and the number of lines it was seen in.
+type UnknownCode struct {
+	Code  string `json:"code"`
+	Lines int    `json:"lines"`
+}
+
+// UnsupportedReport is returned in strict mode instead of the analysis, when the fraction of history
+// lines with unsupported event codes exceeds the allowed threshold.
+type UnsupportedReport struct {
+	HistoryLines    int     `json:"historyLines"`
+	UnknownKeyLines int     `json:"unknownKeyLines"`
+	Percent         float64 `json:"percent"`
+	MaxPercent      float64 `json:"maxPercent"`
+	// UnknownCodes are sorted in descending order of lines seen in.
+	UnknownCodes []UnknownCode `json:"unknownCodes"`
+}
+
+func (r *UnsupportedReport) Error() string {
+	var codes []string
+	for _, c := range r.UnknownCodes {
+		codes = append(codes, c.Code)
+	}
+	return fmt.Sprintf("unsupported report: %.1f%% of history lines (%d of %d) have unknown event codes, over the %.1f%% limit: %s",
+		r.Percent, r.UnknownKeyLines, r.HistoryLines, r.MaxPercent, strings.Join(codes, ", "))
+}
+
+// byLinesDesc sorts unknown codes in descending order of lines, then by code.
+type byLinesDesc []UnknownCode
+
+func (a byLinesDesc) Len() int      { return len(a) }
+func (a byLinesDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLinesDesc) Less(i, j int) bool {
+	if a[i].Lines != a[j].Lines {
+		return a[i].Lines > a[j].Lines
+	}
+	return a[i].Code < a[j].Code
+}
+
+// CheckUnknownCodes returns an UnsupportedReport if more than maxPercent of the history lines analyzed
+// contain unsupported event codes, and nil otherwise. A non positive maxPercent disables the check.
+func CheckUnknownCodes(rep *AnalysisReport, maxPercent float64) *UnsupportedReport {
+	if maxPercent <= 0 || rep == nil || rep.HistoryLines == 0 {
+		return nil
+	}
+	percent := 100 * float64(rep.UnknownKeyLines) / float64(rep.HistoryLines)
+	if percent <= maxPercent {
+		return nil
+	}
+	var codes []UnknownCode
+	for k, n := range rep.UnknownKeys {
+		codes = append(codes, UnknownCode{k, n})
+	}
+	sort.Sort(byLinesDesc(codes))
+	return &UnsupportedReport{
+		HistoryLines:    rep.HistoryLines,
+		UnknownKeyLines: rep.UnknownKeyLines,
+		Percent:         percent,
+		MaxPercent:      maxPercent,
+		UnknownCodes:    codes,
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCheckUnknownCodes tests the counting of unknown event codes and the strict mode threshold.
+func TestCheckUnknownCodes(t *testing.T) {
+	input := strings.Join([]string{
+		"9,0,i,vers,14,135,LMY06B,LMY06B",
+		"9,h,0:RESET:TIME:1422620451417",
+		"9,h,1000,Bl=90,+S",
+		"9,h,1000,+Zz,Yy=3",
+		"9,h,1000,-S,+Zz",
+		"9,h,1000,Bl=89",
+	}, "\n")
+	rep := AnalyzeHistory(ioutil.Discard, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	if rep.HistoryLines != 5 || rep.UnknownKeyLines != 2 {
+		t.Errorf("AnalyzeHistory(%v) counted %d history lines with %d unknown, want 5 with 2", input, rep.HistoryLines, rep.UnknownKeyLines)
+	}
+
+	tests := []struct {
+		desc       string
+		maxPercent float64
+		want       *UnsupportedReport
+	}{
+		{
+			desc: "Strict mode disabled",
+		},
+		{
+			desc:       "Under threshold",
+			maxPercent: 40,
+		},
+		{
+			desc:       "Over threshold",
+			maxPercent: 10,
+			want: &UnsupportedReport{
+				HistoryLines:    5,
+				UnknownKeyLines: 2,
+				Percent:         40,
+				MaxPercent:      10,
+				UnknownCodes:    []UnknownCode{{"Zz", 2}, {"Yy", 1}},
+			},
+		},
+	}
+	for _, test := range tests {
+		if got := CheckUnknownCodes(rep, test.maxPercent); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: CheckUnknownCodes(%v, %v)\n got: %v\n want: %v", test.desc, rep, test.maxPercent, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// usb.go handles the USB connection states. A USB connection that doesn't charge the device, or that
+// has a data link up for MTP or ADB, keeps the SoC awake while the device counts as unplugged.
+
+import (
+	"io"
+	"sort"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// USBData is the battery history CSV metric for the USB data link being up.
+	USBData = "USB data"
+	// USBMetric is the battery history CSV metric for the USB connection states.
+	USBMetric = "USB"
+
+	// USB connection states.
+	USBCharging    = "charging"
+	USBNotCharging = "connected, not charging"
+	USBDataOnly    = "data link"
+)
+
+// usbMetrics are the battery history CSV metrics the USB connection states are computed from.
+var usbMetrics = []string{plugType, Plugged, USBData}
+
+// USBStates returns the USB connection states from the battery history CSV generated by AnalyzeHistory,
+// sorted by start time. The device is connected while the plug type is USB, and charging from the
+// connection while it's also plugged in. A data link up without a USB plug type is a data link only
+// connection, e.g. to a powered off or host mode peer.
+func USBStates(csvInput string) ([]csv.Event, []error) {
+	es, errs := csv.ExtractEvents(csvInput, usbMetrics)
+	return usbStates(es), errs
+}
+
+// usbStates returns the USB connection states from the events of the usbMetrics, sorted by start time.
+func usbStates(es map[string][]csv.Event) []csv.Event {
+	var usb []csv.Event
+	for _, e := range es[plugType] {
+		if e.Value == "u" {
+			usb = append(usb, e)
+		}
+	}
+	// MergeEvents sorts the merged events by start time.
+	usb = csv.MergeEvents(usb)
+	plugged := csv.MergeEvents(es[Plugged])
+	data := csv.MergeEvents(es[USBData])
+
+	var states []csv.Event
+	for _, u := range usb {
+		for _, n := range unplugged(plugged, u.Start, u.End) {
+			states = append(states, csv.Event{Type: "string", Start: n.Start, End: n.End, Value: USBNotCharging})
+		}
+		for _, p := range plugged {
+			s, e := historianutils.MaxInt64(p.Start, u.Start), p.End
+			if u.End < e {
+				e = u.End
+			}
+			if s < e {
+				states = append(states, csv.Event{Type: "string", Start: s, End: e, Value: USBCharging})
+			}
+		}
+	}
+	for _, d := range idleRadio(data, usb) {
+		states = append(states, csv.Event{Type: "string", Start: d.Start, End: d.End, Value: USBDataOnly})
+	}
+	sort.Sort(sortByStart(states))
+	return states
+}
+
+// usbConnected returns the merged intervals the USB was connected in the USB states, sorted by start time.
+func usbConnected(states []csv.Event) []csv.Event {
+	return csv.MergeEvents(append([]csv.Event(nil), states...))
+}
+
+// WriteUSBStates writes a USBMetric row for each USB connection state computed from the battery history
+// CSV, so the connections can be plotted on the timeline.
+func WriteUSBStates(w io.Writer, csvInput string) []error {
+	states, errs := USBStates(csvInput)
+	csvState := csv.NewState(w, false)
+	for _, s := range states {
+		csvState.Print(USBMetric, "string", s.Start, s.End, s.Value, "")
+	}
+	return errs
+}
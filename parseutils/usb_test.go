@@ -0,0 +1,72 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestUSBStates tests the USB connection states and the CPU running time while connected.
+func TestUSBStates(t *testing.T) {
+	input := strings.Join([]string{
+		`9,h,0:RESET:TIME:1000000`,
+		`9,h,0,Bl=80,Bs=d,Bp=n`,
+		// Connected to a computer that doesn't charge the device, with ADB up.
+		`9,h,60000,Bp=u,Bs=n,+Ud`,
+		`9,h,60000,+r`,
+		// Charging from the connection.
+		`9,h,60000,-r,+BP,Bs=c`,
+		// Unplugged with the data link still up.
+		`9,h,60000,-BP,Bp=n,Bs=d`,
+		`9,h,60000,-Ud`,
+		`9,h,60000,Bl=79`,
+	}, "\n")
+	var b bytes.Buffer
+	rep := AnalyzeHistory(&b, input, FormatTotalTime, emptyUIDPackageMapping, true)
+	if len(rep.Errs) > 0 {
+		t.Fatalf("AnalyzeHistory generated unexpected errors: %v", rep.Errs)
+	}
+	// The first summary ends when charging starts.
+	if got, want := rep.Summaries[0].USBDataSummary, (Dist{Num: 1, TotalDuration: 2 * time.Minute, MaxDuration: 2 * time.Minute}); got != want {
+		t.Errorf("AnalyzeHistory(...).Summaries[0].USBDataSummary = %v, want %v", got, want)
+	}
+
+	got, errs := USBStates(b.String())
+	if len(errs) > 0 {
+		t.Fatalf("USBStates generated unexpected errors: %v", errs)
+	}
+	want := []csv.Event{
+		{Type: "string", Start: 1060000, End: 1180000, Value: USBNotCharging},
+		{Type: "string", Start: 1180000, End: 1240000, Value: USBCharging},
+		{Type: "string", Start: 1240000, End: 1300000, Value: USBDataOnly},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("USBStates()\n got: %v\n want: %v", got, want)
+	}
+
+	points, errs := SuspendEfficiencyTrend(b.String(), time.Hour)
+	if len(errs) > 0 {
+		t.Fatalf("SuspendEfficiencyTrend generated unexpected errors: %v", errs)
+	}
+	if len(points) != 1 || points[0].Unplugged != 5*time.Minute || points[0].USBAwake != time.Minute {
+		t.Errorf("SuspendEfficiencyTrend() = %+v, want a single point with 5m0s unplugged and 1m0s USB awake", points)
+	}
+}
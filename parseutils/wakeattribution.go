@@ -0,0 +1,128 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// wakeattribution.go attributes each wake from suspend to the app with the first app level event,
+// such as an alarm going off or a job starting, right after the CPU started running. The app that
+// started doing work right after the wake most likely caused it.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/packageutils"
+)
+
+const (
+	// UnattributedWakeKey is the WakeAttributionSummary key of the wakes without an app level event
+	// shortly after the CPU started running.
+	UnattributedWakeKey = "Unattributed"
+
+	// wakeAttributionWindowMs is the longest time after the CPU started running for an app level event
+	// to be attributed the wake.
+	wakeAttributionWindowMs = 1000
+)
+
+// wakeActivityMetrics are the battery history CSV metrics of the app level events a wake can be
+// attributed to. If several start at the same time, the earlier metric in the list is attributed
+// the wake, as an alarm going off is what starts the other work.
+var wakeActivityMetrics = []string{alarm, "SyncManager", "JobScheduler", "Wakelock_in", "Partial wakelock"}
+
+// wakeActivity is an app level event which may have caused a wake.
+type wakeActivity struct {
+	start int64
+	// rank is the index of the metric in wakeActivityMetrics.
+	rank int
+	app  string
+}
+
+// byStartRank sorts activities by start time, then metric rank.
+type byStartRank []wakeActivity
+
+func (a byStartRank) Len() int      { return len(a) }
+func (a byStartRank) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStartRank) Less(i, j int) bool {
+	if a[i].start != a[j].start {
+		return a[i].start < a[j].start
+	}
+	return a[i].rank < a[j].rank
+}
+
+// AddWakeAttributionSummaries populates the WakeAttributionSummary of each summary from the battery
+// history CSV generated by AnalyzeHistory. Each CPU running event starting while the screen was off
+// is a wake from suspend, attributed to the app of the first app level event starting at most
+// wakeAttributionWindowMs after it, or else to UnattributedWakeKey. A wake is in the summary it
+// started in, with the CPU running duration.
+func AddWakeAttributionSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, append([]string{screen, cpuRunning}, wakeActivityMetrics...))
+	var screenOn []csv.Event
+	for _, e := range es[screen] {
+		if e.Value != "false" {
+			screenOn = append(screenOn, e)
+		}
+	}
+	screenOn = csv.MergeEvents(screenOn)
+
+	var activities []wakeActivity
+	for rank, m := range wakeActivityMetrics {
+		for _, e := range es[m] {
+			if e.Opt == "" {
+				// e.g. an error event without an app.
+				continue
+			}
+			appID, err := packageutils.AppIDFromString(e.Opt)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s uid %q: %v", m, e.Opt, err))
+				continue
+			}
+			activities = append(activities, wakeActivity{e.Start, rank, fmt.Sprintf("UID %d", appID)})
+		}
+	}
+	sort.Sort(byStartRank(activities))
+
+	type wake struct {
+		e   csv.Event
+		app string
+	}
+	var wakes []wake
+	for _, r := range es[cpuRunning] {
+		if inWindow(screenOn, r.Start) {
+			continue
+		}
+		app := UnattributedWakeKey
+		// The first activity starting at or after the wake.
+		i := sort.Search(len(activities), func(i int) bool { return activities[i].start >= r.Start })
+		if i < len(activities) && activities[i].start-r.Start <= wakeAttributionWindowMs {
+			app = activities[i].app
+		}
+		wakes = append(wakes, wake{r, app})
+	}
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.WakeAttributionSummary = make(map[string]Dist)
+		for _, w := range wakes {
+			if w.e.Start < s.StartTimeMs || w.e.Start >= s.EndTimeMs {
+				continue
+			}
+			dist := s.WakeAttributionSummary[w.app]
+			dist.addDuration(time.Duration(w.e.End-w.e.Start) * time.Millisecond)
+			s.WakeAttributionSummary[w.app] = dist
+		}
+	}
+	return errs
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddWakeAttributionSummaries tests the attribution of wakes to the first app activity after them.
+func TestAddWakeAttributionSummaries(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Screen,bool,50000,60000,true,`,
+		`CPU running,string,10000,12000,qcom_wake,`,
+		`CPU running,string,20000,21000,qcom_wake,`,
+		`CPU running,string,30000,33000,qcom_wake,`,
+		`CPU running,string,50000,70000,qcom_wake,`,
+		`CPU running,string,110000,115000,qcom_wake,`,
+		// The alarm goes off at the same time as the job starts, so it's attributed the wake.
+		`JobScheduler,service,10200,11000,com.example.app/.SyncJob,10045`,
+		`Alarm,service,10200,10300,"*walarm*:com.example.chat.PING",1010060`,
+		`Partial wakelock,service,10300,10400,"*alarm*",1000`,
+		// Started too long after the wake.
+		`SyncManager,service,22000,23000,com.example.provider,10045`,
+		`Partial wakelock,error,30100,30100,"missing corresponding +w",`,
+		`Wakelock_in,service,30500,31000,"*job*/com.example.app",10045`,
+		// The screen was on.
+		`Alarm,service,50000,50100,"*walarm*:com.example.chat.PING",10060`,
+		`SyncManager,service,110500,112000,com.example.provider,10045`,
+	}, "\n")
+	summaries := []ActivitySummary{
+		{StartTimeMs: 0, EndTimeMs: 100000},
+		{StartTimeMs: 100000, EndTimeMs: 200000},
+	}
+	if errs := AddWakeAttributionSummaries(input, summaries); len(errs) > 0 {
+		t.Fatalf("AddWakeAttributionSummaries generated unexpected errors: %v", errs)
+	}
+	want := []map[string]Dist{
+		{
+			"UID 10060":         {Num: 1, TotalDuration: 2 * time.Second, MaxDuration: 2 * time.Second},
+			"UID 10045":         {Num: 1, TotalDuration: 3 * time.Second, MaxDuration: 3 * time.Second},
+			UnattributedWakeKey: {Num: 1, TotalDuration: time.Second, MaxDuration: time.Second},
+		},
+		{
+			"UID 10045": {Num: 1, TotalDuration: 5 * time.Second, MaxDuration: 5 * time.Second},
+		},
+	}
+	for i, w := range want {
+		if got := summaries[i].WakeAttributionSummary; !reflect.DeepEqual(got, w) {
+			t.Errorf("Summary %d WakeAttributionSummary\n got: %v\n want: %v", i, got, w)
+		}
+	}
+}
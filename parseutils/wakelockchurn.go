@@ -0,0 +1,131 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// wakelockchurn.go computes how often each wakelock tag is acquired. Thousands of short acquisitions
+// wake the device as much as one long hold, but barely show in the total wakelock duration.
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// fullWakelockMetric is the battery history CSV metric for every wakelock held, only present if
+	// the full wakelock history was enabled on the device.
+	fullWakelockMetric = "Wakelock_in"
+	// partialWakelockMetric is the battery history CSV metric for the first wakelock holder.
+	partialWakelockMetric = "Partial wakelock"
+
+	// maxWakelockChurn is the number of wakelock tags kept in each WakelockChurnSummary.
+	maxWakelockChurn = 10
+)
+
+// WakelockChurn contains the acquisition stats of a wakelock tag held by an app.
+type WakelockChurn struct {
+	Tag string
+	// UID is the app UID the wakelock is attributed to, empty if unknown.
+	UID          string
+	Acquisitions int
+	// PerHour is the number of acquisitions per hour of the summary.
+	PerHour float64
+	// Median is the median hold duration.
+	Median time.Duration
+	// Total is the total hold duration.
+	Total time.Duration
+}
+
+// byChurn sorts the wakelock churn stats in descending order of acquisitions, then by tag and UID.
+type byChurn []WakelockChurn
+
+func (a byChurn) Len() int      { return len(a) }
+func (a byChurn) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byChurn) Less(i, j int) bool {
+	if a[i].Acquisitions != a[j].Acquisitions {
+		return a[i].Acquisitions > a[j].Acquisitions
+	}
+	if a[i].Tag != a[j].Tag {
+		return a[i].Tag < a[j].Tag
+	}
+	return a[i].UID < a[j].UID
+}
+
+// byDuration sorts durations in ascending order.
+type byDuration []time.Duration
+
+func (a byDuration) Len() int           { return len(a) }
+func (a byDuration) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byDuration) Less(i, j int) bool { return a[i] < a[j] }
+
+// medianDuration returns the median of the given durations, which are sorted in place.
+func medianDuration(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Sort(byDuration(ds))
+	m := len(ds) / 2
+	if len(ds)%2 == 1 {
+		return ds[m]
+	}
+	return (ds[m-1] + ds[m]) / 2
+}
+
+// AddWakelockChurnSummaries populates the WakelockChurnSummary of each summary from the battery history
+// CSV generated by AnalyzeHistory, with the wakelock tags acquired most often. The full wakelock history
+// is used if present, otherwise only the first wakelock holders are known. A wakelock is in the summary
+// it was acquired in.
+func AddWakelockChurnSummaries(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{fullWakelockMetric, partialWakelockMetric})
+	wakelocks := es[fullWakelockMetric]
+	if len(wakelocks) == 0 {
+		wakelocks = es[partialWakelockMetric]
+	}
+
+	type key struct {
+		tag, uid string
+	}
+	for i := range summaries {
+		s := &summaries[i]
+		holds := make(map[key][]time.Duration)
+		for _, w := range wakelocks {
+			if w.Start < s.StartTimeMs || w.Start >= s.EndTimeMs {
+				continue
+			}
+			k := key{strings.Trim(w.Value, `"`), w.Opt}
+			holds[k] = append(holds[k], time.Duration(w.End-w.Start)*time.Millisecond)
+		}
+		s.WakelockChurnSummary = nil
+		hours := float64(s.EndTimeMs-s.StartTimeMs) / float64(time.Hour/time.Millisecond)
+		for k, ds := range holds {
+			c := WakelockChurn{Tag: k.tag, UID: k.uid, Acquisitions: len(ds)}
+			if hours > 0 {
+				c.PerHour = float64(len(ds)) / hours
+			}
+			for _, d := range ds {
+				c.Total += d
+			}
+			c.Median = medianDuration(ds)
+			s.WakelockChurnSummary = append(s.WakelockChurnSummary, c)
+		}
+		sort.Sort(byChurn(s.WakelockChurnSummary))
+		if len(s.WakelockChurnSummary) > maxWakelockChurn {
+			s.WakelockChurnSummary = s.WakelockChurnSummary[:maxWakelockChurn]
+		}
+	}
+	return errs
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestAddWakelockChurnSummaries tests the acquisition stats of the wakelock tags.
+func TestAddWakelockChurnSummaries(t *testing.T) {
+	// 100 acquisitions of 50ms in the first half hour, and a long hold.
+	churn := []string{csv.FileHeader}
+	for i := 0; i < 100; i++ {
+		start := int64(i * 10000)
+		churn = append(churn, fmt.Sprintf(`Wakelock_in,service,%d,%d,"*alarm*",1000`, start, start+50))
+	}
+	churn = append(churn, `Wakelock_in,service,1000000,1600000,"*job*/com.example.app",10045`)
+
+	tests := []struct {
+		desc  string
+		input []string
+		want  []WakelockChurn
+	}{
+		{
+			desc:  "Full wakelock history",
+			input: churn,
+			want: []WakelockChurn{
+				{Tag: "*alarm*", UID: "1000", Acquisitions: 100, PerHour: 200, Median: 50 * time.Millisecond, Total: 5 * time.Second},
+				{Tag: "*job*/com.example.app", UID: "10045", Acquisitions: 1, PerHour: 2, Median: 10 * time.Minute, Total: 10 * time.Minute},
+			},
+		},
+		{
+			desc: "First holders only",
+			input: []string{
+				csv.FileHeader,
+				`Partial wakelock,service,0,1000,com.example.app,10045`,
+				`Partial wakelock,service,5000,8000,com.example.app,10045`,
+				`Partial wakelock,service,9000,11000,com.example.app,10045`,
+				`Partial wakelock,service,12000,13000,com.example.app,10045`,
+				// Acquired after the end of the summary.
+				`Partial wakelock,service,1800000,1801000,com.example.app,10045`,
+			},
+			want: []WakelockChurn{
+				{Tag: "com.example.app", UID: "10045", Acquisitions: 4, PerHour: 8, Median: 1500 * time.Millisecond, Total: 7 * time.Second},
+			},
+		},
+		{
+			desc: "Full wakelock history preferred",
+			input: []string{
+				csv.FileHeader,
+				`Partial wakelock,service,0,1000,com.example.app,10045`,
+				`Wakelock_in,service,0,1000,com.example.app,10045`,
+				`Wakelock_in,service,500,1500,com.example.other,10046`,
+			},
+			want: []WakelockChurn{
+				{Tag: "com.example.app", UID: "10045", Acquisitions: 1, PerHour: 2, Median: time.Second, Total: time.Second},
+				{Tag: "com.example.other", UID: "10046", Acquisitions: 1, PerHour: 2, Median: time.Second, Total: time.Second},
+			},
+		},
+	}
+	for _, test := range tests {
+		summaries := []ActivitySummary{{StartTimeMs: 0, EndTimeMs: 1800000}}
+		if errs := AddWakelockChurnSummaries(strings.Join(test.input, "\n"), summaries); len(errs) > 0 {
+			t.Errorf("%v: AddWakelockChurnSummaries generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if got := summaries[0].WakelockChurnSummary; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: AddWakelockChurnSummaries()\n got: %+v\n want: %+v", test.desc, got, test.want)
+		}
+	}
+}
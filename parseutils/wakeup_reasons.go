@@ -0,0 +1,114 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// wakeup_reasons.go loads the vendor tables decoding the encoded wakeup reasons of some devices into
+// readable names, from the wakeup_reasons.txt data file packaged with the binary, and from any
+// additional files given at runtime, which can be gzip compressed as vendors usually ship them.
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed" // For the packaged wakeup reason names.
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// anyModel is the device model of the wakeup reason names that apply to all devices.
+const anyModel = "*"
+
+// defaultWakeupReasonNames is the content of the packaged wakeup reason names file.
+//
+//go:embed wakeup_reasons.txt
+var defaultWakeupReasonNames string
+
+// wakeupReasonNames maps device models, with spaces replaced by _, to the names of their raw wakeup reasons.
+var wakeupReasonNames = make(map[string]map[string]string)
+
+func init() {
+	if err := AddWakeupReasonNames(defaultWakeupReasonNames); err != nil {
+		panic(fmt.Sprintf("invalid packaged wakeup reason names: %v", err))
+	}
+}
+
+// AddWakeupReasonNames adds the names in the given wakeup reason names file content, overriding any
+// existing names for the same model and reason. Each line has the format "<model> <reason> <name>",
+// where the model is * for all devices, and text after a # is ignored. Names must be added before any
+// parsing starts, as the name table isn't locked.
+func AddWakeupReasonNames(content string) error {
+	names := make(map[string]map[string]string)
+	for i, line := range strings.Split(content, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) < 3 {
+			return fmt.Errorf("line %d: expected at least 3 fields, got %d in %q", i+1, len(f), line)
+		}
+		if names[f[0]] == nil {
+			names[f[0]] = make(map[string]string)
+		}
+		names[f[0]][f[1]] = strings.Join(f[2:], " ")
+	}
+	// Only update the table once the whole content is valid.
+	for model, m := range names {
+		if wakeupReasonNames[model] == nil {
+			wakeupReasonNames[model] = make(map[string]string)
+		}
+		for k, v := range m {
+			wakeupReasonNames[model][k] = v
+		}
+	}
+	return nil
+}
+
+// LoadWakeupReasonNames adds the names in the wakeup reason names file at the given path, which is
+// decompressed first if gzip compressed. See AddWakeupReasonNames for the format.
+func LoadWakeupReasonNames(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Gzip files start with the magic number 0x1f 0x8b.
+	if bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := AddWakeupReasonNames(string(b)); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// WakeupReasonName returns the readable name of the raw wakeup reason logged by a device of the
+// given model, or the raw reason if it has no name.
+func WakeupReasonName(model, reason string) string {
+	if n, ok := wakeupReasonNames[strings.Replace(model, " ", "_", -1)][reason]; ok {
+		return n
+	}
+	if n, ok := wakeupReasonNames[anyModel][reason]; ok {
+		return n
+	}
+	return reason
+}
@@ -0,0 +1,76 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+// TestWakeupReasonNames tests loading and looking up the names of vendor wakeup reasons.
+func TestWakeupReasonNames(t *testing.T) {
+	defer func(old map[string]map[string]string) { wakeupReasonNames = old }(wakeupReasonNames)
+	wakeupReasonNames = make(map[string]map[string]string)
+
+	if err := AddWakeupReasonNames("Pixel_X 0x12 modem\nPixel_X"); err == nil {
+		t.Error("AddWakeupReasonNames with a line missing fields returned no error")
+	}
+	if len(wakeupReasonNames) > 0 {
+		t.Errorf("AddWakeupReasonNames with an invalid line added names: %v", wakeupReasonNames)
+	}
+
+	content := strings.Join([]string{
+		"# Vendor table.",
+		"Pixel_X 0x12 modem data   # Shared with the other model.",
+		"*       0x12 modem",
+		"*       0x40 sensor hub",
+	}, "\n")
+	gz, err := historianutils.GzipCompress([]byte(content))
+	if err != nil {
+		t.Fatalf("GzipCompress failed: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "wakeup_reasons")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.txt.gz")
+	if err := ioutil.WriteFile(path, gz, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := LoadWakeupReasonNames(path); err != nil {
+		t.Fatalf("LoadWakeupReasonNames(%q) failed: %v", path, err)
+	}
+
+	tests := []struct {
+		model, reason, want string
+	}{
+		{"Pixel X", "0x12", "modem data"},
+		{"Pixel Y", "0x12", "modem"},
+		{"Pixel X", "0x40", "sensor hub"},
+		{"Pixel X", "57:qcom,smd-modem", "57:qcom,smd-modem"},
+		{"", "0x40", "sensor hub"},
+	}
+	for _, test := range tests {
+		if got := WakeupReasonName(test.model, test.reason); got != test.want {
+			t.Errorf("WakeupReasonName(%q, %q) = %q, want %q", test.model, test.reason, got, test.want)
+		}
+	}
+}
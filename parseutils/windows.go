@@ -0,0 +1,201 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+// windows.go computes sliding window maxima (e.g. the worst 1 hour battery drop) from the generated battery history CSV.
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// cpuRunning is the battery history CSV metric for the CPU running.
+	cpuRunning = "CPU running"
+
+	// Window stat names.
+	WorstLevelDrop = "Battery level drop"
+	MostCPURunning = "CPU running"
+	MostCPUWakeups = "CPU wakeups"
+
+	// Window stat units.
+	UnitPercent = "%"
+	UnitMs      = "ms"
+	UnitCount   = "count"
+)
+
+// WindowStat is the worst value of a metric seen in any window of a fixed length.
+type WindowStat struct {
+	Name   string
+	Window time.Duration
+	// Value is in the given unit: a level drop in percent, a duration in ms or a count of events.
+	Value          int64
+	Unit           string
+	StartMs, EndMs int64
+}
+
+// AddWorstWindows computes the worst 1 hour and 3 hour battery level drops, the most CPU running time
+// and the most CPU wakeups in any hour, from the battery history CSV generated by AnalyzeHistory,
+// and stores them in each of the summaries. Windows longer than a summary are not computed for it.
+func AddWorstWindows(csvInput string, summaries []ActivitySummary) []error {
+	es, errs := csv.ExtractEvents(csvInput, []string{BatteryLevel, cpuRunning})
+	var levels []csv.Event
+	for _, e := range es[BatteryLevel] {
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels = append(levels, e)
+	}
+	running := csv.MergeEvents(es[cpuRunning])
+	wakeups := es[cpuRunning]
+	sort.Sort(sortByStart(levels))
+	sort.Sort(sortByStart(wakeups))
+
+	for i := range summaries {
+		s := &summaries[i]
+		s.WorstWindows = nil
+		for _, w := range []time.Duration{time.Hour, 3 * time.Hour} {
+			if ws, ok := worstLevelDrop(levels, s.StartTimeMs, s.EndTimeMs, w); ok {
+				s.WorstWindows = append(s.WorstWindows, ws)
+			}
+		}
+		if ws, ok := mostRunning(running, s.StartTimeMs, s.EndTimeMs, time.Hour); ok {
+			s.WorstWindows = append(s.WorstWindows, ws)
+		}
+		if ws, ok := mostStarts(wakeups, s.StartTimeMs, s.EndTimeMs, time.Hour); ok {
+			s.WorstWindows = append(s.WorstWindows, ws)
+		}
+	}
+	return errs
+}
+
+// sortByStart sorts events in ascending order of start time.
+type sortByStart []csv.Event
+
+func (a sortByStart) Len() int           { return len(a) }
+func (a sortByStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a sortByStart) Less(i, j int) bool { return a[i].Start < a[j].Start }
+
+// windowStarts returns the candidate window start times in [startMs, endMs-w] at which a window
+// starts or ends on one of the given times. Any step or interval based metric reaches its
+// maximum on one of these windows.
+func windowStarts(times []int64, startMs, endMs, w int64) []int64 {
+	c := []int64{startMs, endMs - w}
+	for _, t := range times {
+		for _, s := range []int64{t, t - w} {
+			if s >= startMs && s <= endMs-w {
+				c = append(c, s)
+			}
+		}
+	}
+	return c
+}
+
+// levelAt returns the battery level at the given time, or false if no level was reported before it.
+// The events must be sorted by start time.
+func levelAt(levels []csv.Event, t int64) (int, bool) {
+	i := sort.Search(len(levels), func(i int) bool { return levels[i].Start > t })
+	if i == 0 {
+		return 0, false
+	}
+	l, _ := strconv.Atoi(levels[i-1].Value)
+	return l, true
+}
+
+// worstLevelDrop returns the window of length w in [startMs, endMs] with the largest battery level drop.
+func worstLevelDrop(levels []csv.Event, startMs, endMs int64, w time.Duration) (WindowStat, bool) {
+	wMs := int64(w / time.Millisecond)
+	if endMs-startMs < wMs {
+		return WindowStat{}, false
+	}
+	var times []int64
+	for _, e := range levels {
+		times = append(times, e.Start)
+	}
+	ws := WindowStat{Name: WorstLevelDrop, Window: w, Unit: UnitPercent, StartMs: startMs, EndMs: startMs + wMs}
+	found := false
+	for _, t := range windowStarts(times, startMs, endMs, wMs) {
+		from, ok1 := levelAt(levels, t)
+		to, ok2 := levelAt(levels, t+wMs)
+		if !ok1 || !ok2 {
+			continue
+		}
+		if d := int64(from - to); !found || d > ws.Value || (d == ws.Value && t < ws.StartMs) {
+			ws.Value, ws.StartMs, ws.EndMs = d, t, t+wMs
+			found = true
+		}
+	}
+	return ws, found
+}
+
+// mostRunning returns the window of length w in [startMs, endMs] overlapping the most with the
+// given non overlapping events, which must be sorted by start time.
+func mostRunning(events []csv.Event, startMs, endMs int64, w time.Duration) (WindowStat, bool) {
+	wMs := int64(w / time.Millisecond)
+	if endMs-startMs < wMs {
+		return WindowStat{}, false
+	}
+	// cum[i] is the total duration of the first i events.
+	cum := make([]int64, len(events)+1)
+	var times []int64
+	for i, e := range events {
+		cum[i+1] = cum[i] + e.End - e.Start
+		times = append(times, e.Start, e.End)
+	}
+	// runningBefore returns the total duration of the events before time t.
+	runningBefore := func(t int64) int64 {
+		i := sort.Search(len(events), func(i int) bool { return events[i].Start >= t })
+		d := cum[i]
+		if i > 0 && events[i-1].End > t {
+			d -= events[i-1].End - t
+		}
+		return d
+	}
+	ws := WindowStat{Name: MostCPURunning, Window: w, Unit: UnitMs, StartMs: startMs, EndMs: startMs + wMs}
+	for _, t := range windowStarts(times, startMs, endMs, wMs) {
+		if d := runningBefore(t+wMs) - runningBefore(t); d > ws.Value || (d == ws.Value && t < ws.StartMs) {
+			ws.Value, ws.StartMs, ws.EndMs = d, t, t+wMs
+		}
+	}
+	return ws, true
+}
+
+// mostStarts returns the window of length w in [startMs, endMs] in which the most of the given
+// events started. The events must be sorted by start time.
+func mostStarts(events []csv.Event, startMs, endMs int64, w time.Duration) (WindowStat, bool) {
+	wMs := int64(w / time.Millisecond)
+	if endMs-startMs < wMs {
+		return WindowStat{}, false
+	}
+	var times []int64
+	for _, e := range events {
+		times = append(times, e.Start)
+	}
+	// startsBefore returns the number of events started before time t.
+	startsBefore := func(t int64) int64 {
+		return int64(sort.Search(len(times), func(i int) bool { return times[i] >= t }))
+	}
+	ws := WindowStat{Name: MostCPUWakeups, Window: w, Unit: UnitCount, StartMs: startMs, EndMs: startMs + wMs}
+	for _, t := range windowStarts(times, startMs, endMs, wMs) {
+		if n := startsBefore(t+wMs) - startsBefore(t); n > ws.Value || (n == ws.Value && t < ws.StartMs) {
+			ws.Value, ws.StartMs, ws.EndMs = n, t, t+wMs
+		}
+	}
+	return ws, true
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parseutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	hourMs = int64(time.Hour / time.Millisecond)
+	minMs  = int64(time.Minute / time.Millisecond)
+)
+
+// TestAddWorstWindows tests the computation of sliding window maxima from the battery history CSV.
+func TestAddWorstWindows(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     []string
+		summaries []ActivitySummary
+		want      [][]WindowStat
+	}{
+		{
+			desc: "Summary shorter than all windows",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,1000,100,`,
+				`CPU running,string,0,1000,,`,
+			},
+			summaries: []ActivitySummary{{StartTimeMs: 0, EndTimeMs: 30 * minMs}},
+			want:      [][]WindowStat{nil},
+		},
+		{
+			desc: "Multiple events",
+			input: []string{
+				csv.FileHeader,
+				`Battery Level,int,0,3600000,100,`,
+				`Battery Level,int,3600000,5400000,98,`,
+				`Battery Level,int,5400000,6000000,90,`,
+				`Battery Level,int,6000000,14400000,88,`,
+				`Battery Level,int,14400000,14400000,80,`,
+				`CPU running,string,1000,2000,,`,
+				`CPU running,string,7200000,9000000,,`,
+				`CPU running,string,8000000,10000000,,`, // Overlaps with the previous event.
+				`CPU running,string,12600000,13200000,,`,
+				`CPU running,string,13500000,13500100,,`,
+			},
+			summaries: []ActivitySummary{{StartTimeMs: 0, EndTimeMs: 4 * hourMs}},
+			want: [][]WindowStat{
+				{
+					// 100% at 0h40m to 88% at 1h40m.
+					{Name: WorstLevelDrop, Window: time.Hour, Value: 12, Unit: UnitPercent, StartMs: 2400000, EndMs: 6000000},
+					// 98% at 1h to 80% at 4h.
+					{Name: WorstLevelDrop, Window: 3 * time.Hour, Value: 18, Unit: UnitPercent, StartMs: 3600000, EndMs: 14400000},
+					// The earliest window fully containing the merged 2h - 2h46m40s CPU running event.
+					{Name: MostCPURunning, Window: time.Hour, Value: 2800000, Unit: UnitMs, StartMs: 6400000, EndMs: 10000000},
+					{Name: MostCPUWakeups, Window: time.Hour, Value: 2, Unit: UnitCount, StartMs: 7200000, EndMs: 10800000},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		if errs := AddWorstWindows(input, test.summaries); len(errs) > 0 {
+			t.Errorf("%v: AddWorstWindows(%v) generated unexpected errors: %v", test.desc, input, errs)
+		}
+		for i, s := range test.summaries {
+			if !reflect.DeepEqual(s.WorstWindows, test.want[i]) {
+				t.Errorf("%v: AddWorstWindows(%v) summary %d got windows %v, want %v", test.desc, input, i, s.WorstWindows, test.want[i])
+			}
+		}
+	}
+}
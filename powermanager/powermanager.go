@@ -0,0 +1,208 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package powermanager parses the power manager service dump (dumpsys power) in a bug report,
+// extracting the wakelocks held and the screen timeout settings at the time the report was taken.
+package powermanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// powerService is the name of the power manager service dump.
+	powerService = "power"
+
+	// noTimeout is the value of Integer.MAX_VALUE used by the device admin setting when there is no maximum timeout.
+	noTimeout = 2147483647
+)
+
+var (
+	// settingRE matches a setting line in the power manager dump.
+	// e.g. mScreenOffTimeoutSetting=60000
+	settingRE = regexp.MustCompile(`^\s*(?P<name>m\w+)=(?P<value>-?\d+)`)
+
+	// wakeLockRE matches a held wakelock line in the power manager dump. Newer Android versions include the time since acquired.
+	// e.g. PARTIAL_WAKE_LOCK              'AudioMix' ACQ=-7s460ms (uid=1041)
+	//      SCREEN_BRIGHT_WAKE_LOCK        'WindowManager' ON_AFTER_RELEASE (uid=1000, pid=786, ws=null)
+	wakeLockRE = regexp.MustCompile(`^\s*(?P<type>\w+_WAKE_LOCK)\s+'(?P<tag>.*)'(?P<flags>[^'(]*)\((?P<info>[^)]*)\)`)
+
+	// uidRE matches the UID in the wakelock info.
+	uidRE = regexp.MustCompile(`uid=(?P<uid>\d+)`)
+
+	// stayOnPlugTypes are the names of the plug types in the stay on while plugged in bitmask.
+	stayOnPlugTypes = []struct {
+		mask int64
+		name string
+	}{
+		{1, "AC"},
+		{2, "USB"},
+		{4, "Wireless"},
+	}
+)
+
+// WakeLock is a wakelock held at the time the bug report was taken.
+type WakeLock struct {
+	// Type is the wakelock level, e.g. PARTIAL_WAKE_LOCK or SCREEN_BRIGHT_WAKE_LOCK.
+	Type string
+	Tag  string
+	UID  int32
+	// Flags are the wakelock flags, e.g. ON_AFTER_RELEASE.
+	Flags []string
+	// Held is how long the wakelock had been held for, or 0 if not reported.
+	Held time.Duration
+}
+
+// Config is the power manager configuration, which affects the interpretation of the battery history.
+// Timeouts that are not set are 0.
+type Config struct {
+	ScreenOffTimeout time.Duration
+	SleepTimeout     time.Duration
+	// MaxScreenOffTimeoutFromDeviceAdmin is the maximum screen off timeout enforced by a device admin.
+	MaxScreenOffTimeoutFromDeviceAdmin time.Duration
+	// UserActivityTimeoutOverride is the timeout override set by the window manager.
+	UserActivityTimeoutOverride time.Duration
+	// StayOnWhilePluggedIn are the plug types the screen stays on for while plugged in.
+	StayOnWhilePluggedIn []string
+	WakeLocks            []WakeLock
+}
+
+// timeout converts a timeout setting value in milliseconds, where negative values mean unset.
+func timeout(v int64) time.Duration {
+	if v < 0 {
+		return 0
+	}
+	return time.Duration(v) * time.Millisecond
+}
+
+// extractPowerDump returns the lines of the power manager service dump in the bug report.
+func extractPowerDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == powerService
+			continue
+		}
+		if in {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse returns the power manager configuration from the bug report, or nil if the
+// bug report has no power manager service dump.
+func Parse(input string) (*Config, []error) {
+	lines := extractPowerDump(input)
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	c := &Config{}
+	var errs []error
+	seen := make(map[string]bool)
+	inWakeLocks := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "Wake Locks:"):
+			inWakeLocks = true
+			continue
+		case trimmed == "":
+			inWakeLocks = false
+			continue
+		}
+		if inWakeLocks {
+			if m, result := historianutils.SubexpNames(wakeLockRE, line); m {
+				w, err := wakeLock(result)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				c.WakeLocks = append(c.WakeLocks, w)
+			}
+			continue
+		}
+		m, result := historianutils.SubexpNames(settingRE, line)
+		if !m || seen[result["name"]] {
+			// Settings can be repeated in later parts of the dump, only the first value is used.
+			continue
+		}
+		seen[result["name"]] = true
+		v, err := strconv.ParseInt(result["value"], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s value: %v", result["name"], err))
+			continue
+		}
+		switch result["name"] {
+		case "mScreenOffTimeoutSetting":
+			c.ScreenOffTimeout = timeout(v)
+		case "mSleepTimeoutSetting":
+			c.SleepTimeout = timeout(v)
+		case "mMaximumScreenOffTimeoutFromDeviceAdmin":
+			if v != noTimeout {
+				c.MaxScreenOffTimeoutFromDeviceAdmin = timeout(v)
+			}
+		case "mUserActivityTimeoutOverrideFromWindowManager":
+			c.UserActivityTimeoutOverride = timeout(v)
+		case "mStayOnWhilePluggedInSetting":
+			for _, p := range stayOnPlugTypes {
+				if v&p.mask != 0 {
+					c.StayOnWhilePluggedIn = append(c.StayOnWhilePluggedIn, p.name)
+				}
+			}
+		}
+	}
+	return c, errs
+}
+
+// wakeLock creates a WakeLock from the matched wakelock line.
+func wakeLock(result map[string]string) (WakeLock, error) {
+	w := WakeLock{Type: result["type"], Tag: result["tag"]}
+	if m, u := historianutils.SubexpNames(uidRE, result["info"]); m {
+		uid, err := strconv.ParseInt(u["uid"], 10, 32)
+		if err != nil {
+			return w, fmt.Errorf("invalid uid for wakelock %q: %v", w.Tag, err)
+		}
+		w.UID = int32(uid)
+	}
+	for _, f := range strings.Fields(result["flags"]) {
+		if !strings.HasPrefix(f, "ACQ=") {
+			w.Flags = append(w.Flags, f)
+			continue
+		}
+		ms, err := historianutils.ParseDurationWithDays(strings.TrimPrefix(strings.TrimPrefix(f, "ACQ="), "-"))
+		if err != nil {
+			return w, fmt.Errorf("invalid acquire time for wakelock %q: %v", w.Tag, err)
+		}
+		w.Held = time.Duration(ms) * time.Millisecond
+	}
+	return w, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package powermanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParse tests the parsing of the power manager service dump.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		desc     string
+		input    []string
+		want     *Config
+		wantErrs bool
+	}{
+		{
+			desc: "No power manager dump",
+			input: []string{
+				`------ DUMPSYS (dumpsys) ------`,
+				`DUMP OF SERVICE alarm:`,
+				`  mScreenOffTimeoutSetting=60000`,
+			},
+		},
+		{
+			desc: "Marshmallow dump",
+			input: []string{
+				`------ DUMPSYS (dumpsys) ------`,
+				`DUMP OF SERVICE power:`,
+				`POWER MANAGER (dumpsys power)`,
+				``,
+				`Power Manager State:`,
+				`  mWakefulness=Awake`,
+				``,
+				`Settings and Configuration:`,
+				`  mSleepTimeoutSetting=-1`,
+				`  mScreenOffTimeoutSetting=60000`,
+				`  mMaximumScreenOffTimeoutFromDeviceAdmin=2147483647 (enforced=false)`,
+				`  mStayOnWhilePluggedInSetting=3`,
+				`  mUserActivityTimeoutOverrideFromWindowManager=-1`,
+				``,
+				`Screen off timeout: 60000 ms`,
+				``,
+				`Wake Locks: size=3`,
+				`  PARTIAL_WAKE_LOCK              'AudioMix' (uid=1013, pid=225, ws=null)`,
+				`  SCREEN_BRIGHT_WAKE_LOCK        'WindowManager' ON_AFTER_RELEASE (uid=1000, pid=786, ws=WorkSource{10042})`,
+				`  PARTIAL_WAKE_LOCK              '*job*/com.google.android.gms/.gcm.nts.TaskExecutionService' ACQ=-1m7s460ms (uid=10014 pid=1234)`,
+				``,
+				`Suspend Blockers: size=4`,
+				`  PowerManagerService.WakeLocks: ref count=1`,
+				`DUMP OF SERVICE print:`,
+				`  mScreenOffTimeoutSetting=30000`,
+			},
+			want: &Config{
+				ScreenOffTimeout:     time.Minute,
+				StayOnWhilePluggedIn: []string{"AC", "USB"},
+				WakeLocks: []WakeLock{
+					{Type: "PARTIAL_WAKE_LOCK", Tag: "AudioMix", UID: 1013},
+					{Type: "SCREEN_BRIGHT_WAKE_LOCK", Tag: "WindowManager", UID: 1000, Flags: []string{"ON_AFTER_RELEASE"}},
+					{Type: "PARTIAL_WAKE_LOCK", Tag: "*job*/com.google.android.gms/.gcm.nts.TaskExecutionService", UID: 10014, Held: time.Minute + 7460*time.Millisecond},
+				},
+			},
+		},
+		{
+			desc: "Device admin and window manager timeouts",
+			input: []string{
+				`DUMP OF SERVICE power:`,
+				`  mSleepTimeoutSetting=600000`,
+				`  mScreenOffTimeoutSetting=1800000`,
+				`  mMaximumScreenOffTimeoutFromDeviceAdmin=300000 (enforced=true)`,
+				`  mUserActivityTimeoutOverrideFromWindowManager=10000`,
+				`------ APP SERVICES (dumpsys activity service all) ------`,
+				`  mScreenOffTimeoutSetting=30000`,
+			},
+			want: &Config{
+				ScreenOffTimeout:                   30 * time.Minute,
+				SleepTimeout:                       10 * time.Minute,
+				MaxScreenOffTimeoutFromDeviceAdmin: 5 * time.Minute,
+				UserActivityTimeoutOverride:        10 * time.Second,
+			},
+		},
+		{
+			desc: "Invalid acquire time",
+			input: []string{
+				`DUMP OF SERVICE power:`,
+				`Wake Locks: size=1`,
+				`  PARTIAL_WAKE_LOCK              'AudioMix' ACQ=-7x (uid=1013)`,
+			},
+			want:     &Config{},
+			wantErrs: true,
+		},
+	}
+	for _, test := range tests {
+		input := strings.Join(test.input, "\n")
+		got, errs := Parse(input)
+		if test.wantErrs != (len(errs) > 0) {
+			t.Errorf("%v: Parse(%v) got errors: %v, want errors: %v", test.desc, input, errs, test.wantErrs)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Parse(%v)\n got: %+v\n want: %+v", test.desc, input, got, test.want)
+		}
+	}
+}
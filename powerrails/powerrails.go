@@ -0,0 +1,292 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package powerrails parses auxiliary power rail measurement files, such as on device power monitor
+// (ODPM) readings or external power monitor captures, with the power of each rail in mW, and outputs
+// CSV entries so the rails can be aligned with the battery history on the Historian v2 timeline. It
+// also correlates the power of each rail with the battery history events, so that the power the
+// history attributes to the events can be checked against measurements.
+package powerrails
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+const (
+	// metricPrefix and metricSuffix surround the rail name in the CSV description of the rail series.
+	metricPrefix = "Power rail "
+	metricSuffix = " (mW)"
+
+	// msThreshold is the value above which unix timestamps are assumed to be in milliseconds,
+	// as a timestamp in seconds would be many millennia in the future.
+	msThreshold = 1e11
+)
+
+// activityMetrics are the battery history CSV metrics the rails are correlated with.
+var activityMetrics = []string{
+	"Screen",
+	"CPU running",
+	"Mobile radio active",
+	"Wifi radio",
+	"Wifi scan",
+	"GPS",
+	"Phone call",
+	"Audio",
+	"Video",
+	"Camera",
+	"Flashlight on",
+}
+
+// fieldSepRE matches the separator of the fields of a line, which can be commas or tabs.
+// e.g. "1433786064000,512.5,80.25" or "1433786064.5	512.5	80.25"
+var fieldSepRE = regexp.MustCompile(`\s*[,\t]\s*`)
+
+// Metric returns the CSV description of the series of a rail, e.g. "Power rail S4M_VDD_CX (mW)".
+func Metric(rail string) string {
+	return metricPrefix + rail + metricSuffix
+}
+
+// railName returns the rail of a CSV description, and whether the description is of a rail series.
+func railName(metric string) (string, bool) {
+	if !strings.HasPrefix(metric, metricPrefix) || !strings.HasSuffix(metric, metricSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(metric, metricPrefix), metricSuffix), true
+}
+
+// parseTimestamp returns the unix time in milliseconds of a timestamp in unix seconds (optionally
+// fractional), unix milliseconds or RFC 3339 format.
+func parseTimestamp(s string) (int64, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > msThreshold {
+			return int64(f), nil
+		}
+		return int64(f * 1000), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// lines returns the fields of the non empty lines of the file that aren't comments.
+func lines(f string) [][]string {
+	var res [][]string
+	for _, l := range strings.Split(f, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		res = append(res, fieldSepRE.Split(l, -1))
+	}
+	return res
+}
+
+// header returns the rail names of the header line, in the format: timestamp_label,rail[,rail...]
+// A header whose first field is a timestamp is a reading without a header.
+func header(fields []string) ([]string, bool) {
+	if len(fields) < 2 {
+		return nil, false
+	}
+	if _, err := parseTimestamp(fields[0]); err == nil {
+		return nil, false
+	}
+	for _, r := range fields[1:] {
+		if r == "" {
+			return nil, false
+		}
+	}
+	return fields[1:], true
+}
+
+// IsValid returns whether the file starts with a header naming the rails.
+func IsValid(f []byte) bool {
+	ls := lines(string(f))
+	if len(ls) == 0 {
+		return false
+	}
+	_, ok := header(ls[0])
+	return ok
+}
+
+// Parse writes a CSV entry for each reading of each rail in the file, and returns whether the file
+// was valid. The file starts with a header line naming the rails, followed by one line per reading
+// with the timestamp and the power of each rail in mW, in the same order. Empty values are missing
+// readings. Each reading lasts until the next reading of the rail, and the last one until the last
+// timestamp of the file.
+func Parse(f string) (bool, string, []error) {
+	ls := lines(f)
+	if len(ls) == 0 {
+		return false, "", nil
+	}
+	rails, ok := header(ls[0])
+	if !ok {
+		return false, "", nil
+	}
+	var buf bytes.Buffer
+	var errs []error
+	csvState := csv.NewState(&buf, true)
+
+	type reading struct {
+		startMs int64
+		value   string
+	}
+	// last is the current reading of each rail, nil if the rail has none.
+	last := make([]*reading, len(rails))
+	flush := func(i int, endMs int64) {
+		if r := last[i]; r != nil {
+			csvState.Print(Metric(rails[i]), "float", r.startMs, endMs, r.value, "")
+			last[i] = nil
+		}
+	}
+	var prevMs int64
+	found := false
+	for _, fields := range ls[1:] {
+		if len(fields) != len(rails)+1 {
+			errs = append(errs, fmt.Errorf("line %q has %d values, want %d", strings.Join(fields, ","), len(fields)-1, len(rails)))
+			continue
+		}
+		ms, err := parseTimestamp(fields[0])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ms < prevMs {
+			errs = append(errs, fmt.Errorf("timestamp %q is before the previous reading", fields[0]))
+			continue
+		}
+		for i, v := range fields[1:] {
+			if v == "" {
+				continue
+			}
+			mW, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s power %q", rails[i], v))
+				continue
+			}
+			flush(i, ms)
+			last[i] = &reading{ms, strconv.FormatFloat(mW, 'f', 3, 64)}
+			found = true
+		}
+		prevMs = ms
+	}
+	for i := range rails {
+		flush(i, prevMs)
+	}
+	return found, buf.String(), errs
+}
+
+// Correlation is the mean power of a rail while a battery history event was on and off.
+type Correlation struct {
+	Rail string
+	// Metric is the battery history CSV metric of the event, e.g. "Screen".
+	Metric string
+	// OnDuration and OffDuration are the time the rail was measured while the event was on and off.
+	OnDuration, OffDuration time.Duration
+	// OnMeanMW and OffMeanMW are the time weighted mean power of the rail while the event was on and off.
+	OnMeanMW, OffMeanMW float64
+}
+
+// DeltaMW returns the increase of the mean power of the rail while the event was on, which is the
+// power measured for the event if nothing else on the rail correlates with it.
+func (c Correlation) DeltaMW() float64 {
+	return c.OnMeanMW - c.OffMeanMW
+}
+
+// byDelta sorts correlations in descending order of power increase, then ascending order of rail and metric.
+type byDelta []Correlation
+
+func (a byDelta) Len() int      { return len(a) }
+func (a byDelta) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDelta) Less(i, j int) bool {
+	switch {
+	case a[i].DeltaMW() != a[j].DeltaMW():
+		return a[i].DeltaMW() > a[j].DeltaMW()
+	case a[i].Rail != a[j].Rail:
+		return a[i].Rail < a[j].Rail
+	}
+	return a[i].Metric < a[j].Metric
+}
+
+// Correlate returns the mean power of each rail of the CSV generated by Parse while each activity
+// event of the battery history CSV generated by AnalyzeHistory was on and off, for the events that
+// were both on and off while the rail was measured, sorted in descending order of power increase.
+func Correlate(railsCSV, historyCSV string) ([]Correlation, []error) {
+	railEvents, errs := csv.ExtractEvents(railsCSV, nil)
+	es, histErrs := csv.ExtractEvents(historyCSV, activityMetrics)
+	errs = append(errs, histErrs...)
+
+	on := make(map[string][]csv.Event)
+	for _, m := range activityMetrics {
+		var events []csv.Event
+		for _, e := range es[m] {
+			if e.Value != "false" {
+				events = append(events, e)
+			}
+		}
+		on[m] = csv.MergeEvents(events)
+	}
+
+	var res []Correlation
+	for metric, events := range railEvents {
+		rail, ok := railName(metric)
+		if !ok {
+			continue
+		}
+		var readings []csv.Event
+		var mWs []float64
+		for _, e := range events {
+			mW, err := strconv.ParseFloat(e.Value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s power %q", rail, e.Value))
+				continue
+			}
+			readings = append(readings, e)
+			mWs = append(mWs, mW)
+		}
+		for _, m := range activityMetrics {
+			var onMs, offMs int64
+			var onEnergy, offEnergy float64
+			for i, r := range readings {
+				o := csv.Overlap(on[m], r.Start, r.End)
+				onMs += o
+				offMs += r.End - r.Start - o
+				onEnergy += mWs[i] * float64(o)
+				offEnergy += mWs[i] * float64(r.End-r.Start-o)
+			}
+			if onMs == 0 || offMs == 0 {
+				continue
+			}
+			res = append(res, Correlation{
+				Rail:        rail,
+				Metric:      m,
+				OnDuration:  time.Duration(onMs) * time.Millisecond,
+				OffDuration: time.Duration(offMs) * time.Millisecond,
+				OnMeanMW:    onEnergy / float64(onMs),
+				OffMeanMW:   offEnergy / float64(offMs),
+			})
+		}
+	}
+	sort.Sort(byDelta(res))
+	return res, errs
+}
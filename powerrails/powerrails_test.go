@@ -0,0 +1,125 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package powerrails
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+)
+
+// TestParse tests the generation of the rail series from power rail files.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     string
+		wantValid bool
+		wantCSV   string
+		wantErrs  int
+	}{
+		{
+			desc: "Millisecond timestamps with a missing reading",
+			input: strings.Join([]string{
+				"# ODPM export",
+				"timestamp_ms,S4M_VDD_CX,L2A_MODEM",
+				"1433786064000,512.5,80.25",
+				"1433786065000,600,",
+				"1433786066000,480,90",
+			}, "\n"),
+			wantValid: true,
+			wantCSV: strings.Join([]string{
+				csv.FileHeader,
+				"Power rail S4M_VDD_CX (mW),float,1433786064000,1433786065000,512.500,",
+				"Power rail S4M_VDD_CX (mW),float,1433786065000,1433786066000,600.000,",
+				"Power rail L2A_MODEM (mW),float,1433786064000,1433786066000,80.250,",
+				"Power rail S4M_VDD_CX (mW),float,1433786066000,1433786066000,480.000,",
+				"Power rail L2A_MODEM (mW),float,1433786066000,1433786066000,90.000,",
+			}, "\n") + "\n",
+		},
+		{
+			desc: "Tab separated fractional second timestamps with invalid lines",
+			input: strings.Join([]string{
+				"time\tDISPLAY",
+				"1433786064.5\t100",
+				"1433786064.0\t200",
+				"1433786065.5\t300\t400",
+				"1433786066.5\tabc",
+				"1433786067.5\t150",
+			}, "\n"),
+			wantValid: true,
+			wantCSV: strings.Join([]string{
+				csv.FileHeader,
+				"Power rail DISPLAY (mW),float,1433786064500,1433786067500,100.000,",
+				"Power rail DISPLAY (mW),float,1433786067500,1433786067500,150.000,",
+			}, "\n") + "\n",
+			wantErrs: 3,
+		},
+		{
+			desc:  "No header",
+			input: "1433786064000,512.5,80.25",
+		},
+	}
+	for _, test := range tests {
+		valid, got, errs := Parse(test.input)
+		if valid != test.wantValid {
+			t.Errorf("%v: Parse(%v) valid = %v, want %v", test.desc, test.input, valid, test.wantValid)
+		}
+		if got != test.wantCSV {
+			t.Errorf("%v: Parse(%v)\n got: %q\n want: %q", test.desc, test.input, got, test.wantCSV)
+		}
+		if len(errs) != test.wantErrs {
+			t.Errorf("%v: Parse(%v) generated %d errors, want %d: %v", test.desc, test.input, len(errs), test.wantErrs, errs)
+		}
+		if v := IsValid([]byte(test.input)); v != test.wantValid {
+			t.Errorf("%v: IsValid(%v) = %v, want %v", test.desc, test.input, v, test.wantValid)
+		}
+	}
+}
+
+// TestCorrelate tests the mean power of the rails while the battery history events were on and off.
+func TestCorrelate(t *testing.T) {
+	railsCSV := strings.Join([]string{
+		csv.FileHeader,
+		"Power rail DISPLAY (mW),float,0,10000,100.000,",
+		"Power rail DISPLAY (mW),float,10000,20000,500.000,",
+		"Power rail MODEM (mW),float,0,20000,50.000,",
+		"Power monitor (mA),float,0,20000,10.000,",
+	}, "\n")
+	historyCSV := strings.Join([]string{
+		csv.FileHeader,
+		"Screen,bool,10000,20000,true,",
+		"Mobile radio active,bool,0,5000,true,",
+		"Mobile radio active,bool,5000,20000,false,",
+		// Never off while the rails were measured.
+		"CPU running,bool,0,30000,true,",
+	}, "\n")
+
+	want := []Correlation{
+		{Rail: "DISPLAY", Metric: "Screen", OnDuration: 10 * time.Second, OffDuration: 10 * time.Second, OnMeanMW: 500, OffMeanMW: 100},
+		{Rail: "MODEM", Metric: "Mobile radio active", OnDuration: 5 * time.Second, OffDuration: 15 * time.Second, OnMeanMW: 50, OffMeanMW: 50},
+		{Rail: "MODEM", Metric: "Screen", OnDuration: 10 * time.Second, OffDuration: 10 * time.Second, OnMeanMW: 50, OffMeanMW: 50},
+		{Rail: "DISPLAY", Metric: "Mobile radio active", OnDuration: 5 * time.Second, OffDuration: 15 * time.Second, OnMeanMW: 100, OffMeanMW: 1100.0 / 3},
+	}
+	got, errs := Correlate(railsCSV, historyCSV)
+	if len(errs) > 0 {
+		t.Fatalf("Correlate(%s, %s) generated unexpected errors: %v", railsCSV, historyCSV, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Correlate(%s, %s)\n got: %+v\n want: %+v", railsCSV, historyCSV, got, want)
+	}
+}
@@ -20,14 +20,22 @@ const (
 	hScreenOnNumPerHr  = "ScreenOnNumPerHr"
 	hScreenOnSecsPerHr = "ScreenOnSecsPerHr"
 
+	hScreenOnSessions = "ScreenOnSessions"
+	hScreenPulses     = "ScreenPulses"
+
 	hCPURunning          = "CPURunning"
 	hCPURunningNumPerHr  = "CPURunningNumPerHr"
 	hCPURunningSecsPerHr = "CPURunningSecsPerHr"
 
+	hCPURunningWakeups       = "CPURunningWakeups"
+	hCPURunningSuspendAborts = "CPURunningSuspendAborts"
+
 	hRadioOn          = "RadioOn"
 	hRadioOnNumPerHr  = "RadioOnNumPerHr"
 	hRadioOnSecsPerHr = "RadioOnSecsPerHr"
 
+	hRadioOnIdle = "RadioOnIdle"
+
 	hPhoneCall          = "PhoneCall"
 	hPhoneCallNumPerHr  = "PhoneCallNumPerHr"
 	hPhoneCallSecsPerHr = "PhoneCallSecsPerHr"
@@ -80,6 +88,8 @@ const (
 	hWifiRadioNumPerHr  = "WifiRadioNumPerHr"
 	hWifiRadioSecsPerHr = "WifiRadioSecsPerHr"
 
+	hWifiRadioIdle = "WifiRadioIdle"
+
 	hFlashlightOn          = "FlashlightOn"
 	hFlashlightOnNumPerHr  = "FlashlightOnNumPerHr"
 	hFlashlightOnSecsPerHr = "FlashlightOnSecsPerHr"
@@ -92,19 +102,34 @@ const (
 	hVideoOnNumPerHr  = "VideoOnNumPerHr"
 	hVideoOnSecsPerHr = "VideoOnSecsPerHr"
 
+	hNoConnectivity          = "NoConnectivity"
+	hNoConnectivityNumPerHr  = "NoConnectivityNumPerHr"
+	hNoConnectivitySecsPerHr = "NoConnectivitySecsPerHr"
+
 	// Multi variable stats
 	hIdleModeSummary            = "DozeModeSummary"
 	hDataConnectionSummary      = "DataConnectionSummary"
 	hConnectivitySummary        = "ConnectivitySummary"
+	hDefaultNetworkSummary      = "DefaultNetworkSummary"
 	hPerAppSyncSummary          = "PerAppSyncSummary"
+	hMobileRadioAppSummary      = "MobileRadioActiveAppSummary"
+	hMobileRadioEnergySummary   = "MobileRadioEnergyAppSummary"
+	hAPWakeupSummary            = "APWakeupSummary"
+	hAPWakeupScreenOffSummary   = "APWakeupScreenOffSummary"
+	hAttributedCPURunning       = "AttributedCPURunningSummary"
 	hWakeupReasonSummary        = "WakeupReasonSummary"
 	hPhoneStateSummary          = "PhoneStateSummary"
 	hForegroundProcessSummary   = "ForegroundProcessSummary"
 	hFirstWakelockAfterSuspend  = "FirstWakelockAfterSuspend"
 	hDetailedWakelockSummary    = "DetailedWakelockSummary"
 	hScheduledJobSummary        = "ScheduledJobSummary"
+	hWakeupAlarmSummary         = "WakeupAlarmSummary"
+	hWakeupAlarmAwakeSummary    = "WakeupAlarmAwakeSummary"
+	hNonWakeupAlarmSummary      = "NonWakeupAlarmSummary"
+	hWakeAttributionSummary     = "WakeAttributionSummary"
 	hWifiSupplSummary           = "WifiSupplicantSummary"
 	hPhoneSignalStrengthSummary = "PhoneSignalStrengthSummary"
 	hWifiSignalStrengthSummary  = "WifiSignalStrengthSummary"
 	hTopApplicationSummary      = "TopApplicationSummary"
+	hBodyStateSummary           = "BodyStateSummary"
 )
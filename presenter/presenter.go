@@ -26,11 +26,22 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/google/battery-historian/activity"
 	"github.com/google/battery-historian/aggregated"
+	"github.com/google/battery-historian/apperrors"
+	"github.com/google/battery-historian/audio"
+	"github.com/google/battery-historian/automotive"
+	"github.com/google/battery-historian/bluetooth"
 	"github.com/google/battery-historian/bugreportutils"
 	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/jobscheduler"
+	"github.com/google/battery-historian/netstats"
 	"github.com/google/battery-historian/parseutils"
 	bspb "github.com/google/battery-historian/pb/batterystats_proto"
+	"github.com/google/battery-historian/powermanager"
+	"github.com/google/battery-historian/powerrails"
+	"github.com/google/battery-historian/sysupdate"
+	"github.com/google/battery-historian/telephony"
 	"github.com/google/battery-historian/wakeupreason"
 )
 
@@ -80,6 +91,61 @@ type HTMLData struct {
 	AppStats               []AppStat
 	Overflow               bool
 	HasBatteryStatsHistory bool
+	ChargeStats            []parseutils.ChargeStats
+	// ImmortalWakelocks are the wakelocks held across the report, which keep the device from suspending.
+	ImmortalWakelocks []parseutils.ImmortalWakelock
+	// ActivityDrain is the drain normalized by the steps taken, nil if the step counter logged no steps.
+	ActivityDrain *parseutils.ActivityDrain
+	// ChargingTemperatureAlerts are the charging sessions where the battery got too hot, or heated up
+	// unusually fast for the charging current.
+	ChargingTemperatureAlerts []parseutils.ChargingTemperatureAlert
+	// ChargeCycles are the charge cycles of the history and the battery aging they are estimated to
+	// have caused, nil if there were none.
+	ChargeCycles *parseutils.CycleSummary
+	// PowerConfig is the power manager configuration at the time the bug report was taken, nil if unavailable.
+	PowerConfig *powermanager.Config
+	// BLEAdvertising is the per app Bluetooth LE advertising found in the logs.
+	BLEAdvertising []activity.AdvertisingSummary
+	// CrashLoops are the processes repeatedly crashing or not responding.
+	CrashLoops []apperrors.CrashLoop
+	// MaintenanceWindows are the Doze maintenance windows and the app activity inside and outside of them.
+	MaintenanceWindows *parseutils.MaintenanceSummary
+	// AudioPlayback is the audio playback attributed to each app holding audio focus.
+	AudioPlayback []audio.AppSummary
+	// PeriodicWakeups are the periods at which the device repeatedly wakes up, with the likely responsible app.
+	PeriodicWakeups []parseutils.PeriodicPattern
+	// AppStandby is the time apps were marked inactive, and the background work they did while inactive.
+	AppStandby []parseutils.AppStandby
+	// MetricCoverage is the part of the unplugged time each metric has data for.
+	MetricCoverage []parseutils.MetricCoverage
+	// SystemUpdates are the system updates found in the bug report.
+	SystemUpdates []sysupdate.Update
+	// UpdateDrain is the battery drain before and after the system updates, nil if it couldn't be compared.
+	UpdateDrain *sysupdate.Comparison
+	// ParkedSessions are the garage mode and suspend to RAM sessions of automotive reports, and
+	// ParkedTotals their totals per session type.
+	ParkedSessions []automotive.Session
+	ParkedTotals   []automotive.Total
+	// GNSS is the GPS duty cycle and background GPS sessions, nil if GPS was never on.
+	GNSS *parseutils.GNSSSummary
+	// TmpWhiteListNetwork is the network usage attributed to the temporary whitelist grants of each app,
+	// from the netstats dump.
+	TmpWhiteListNetwork []netstats.AppUsage
+	// BLEScans are the BLE scan statistics of each app from the Bluetooth manager dump, with the BLE
+	// scanning time of the battery history split between them.
+	BLEScans []bluetooth.AppScans
+	// UnconstrainedJobs are the jobs that ran while a charging, idle or unmetered network constraint
+	// they require wasn't met.
+	UnconstrainedJobs []jobscheduler.Summary
+	// PowerRailCorrelations are the mean power of each rail of an uploaded power rails file while
+	// the battery history events were on and off.
+	PowerRailCorrelations []powerrails.Correlation
+	// Telephony is the airplane mode and SIM state changes, with the drain with airplane mode on and
+	// off, nil if airplane mode was never on and no SIM state was logged.
+	Telephony *telephony.Summary
+	// FinalState is the device state at the end of the battery history, around when the bug report was
+	// captured, nil if there is no history.
+	FinalState *parseutils.DeviceStateSnapshot
 }
 
 // CombinedCheckinSummary is the combined structure for the 2 files being compared
@@ -171,16 +237,40 @@ type HistogramStats struct {
 
 // UnplugSummary contains stats processed from battery history during discharge intervals.
 type UnplugSummary struct {
-	Date             string
-	Reason           string
+	Date   string
+	Reason string
+	// Charging is whether the summary covers a charging period rather than a discharge interval.
+	Charging bool
+	// Untimed is whether the summary is from before the device clock was set, so its times and rates are meaningless.
+	Untimed          bool
 	SummaryStart     string
 	SummaryEnd       string
 	Duration         string
 	LevelDrop        int32
 	LevelDropPerHour float64
-	SystemStats      []DurationStats
-	BreakdownStats   []MultiDurationStats
-	PowerStates      map[string]parseutils.PowerState
+	// SuspendEfficiency is the percentage of the unplugged time the CPU was asleep.
+	SuspendEfficiency float64
+	// NetworkSwitchesPerHour is the number of times per hour the default network changed.
+	NetworkSwitchesPerHour float64
+	// UnattributedLevelDrop is the estimated level drop while no tracked activity was on, over UnattributedDuration.
+	UnattributedLevelDrop        float64
+	UnattributedDuration         time.Duration
+	UnattributedLevelDropPerHour float64
+	SystemStats                  []DurationStats
+	BreakdownStats               []MultiDurationStats
+	PowerStates                  map[string]parseutils.PowerState
+	WorstWindows                 []WindowStats
+	BodyStateDrain               []LevelDropRate
+	// CallDrain is the drain during cellular calls and the VoIP calls of each app.
+	CallDrain []LevelDropRate
+	// TetheringDrain is the drain while tethering of each type, e.g. a Wi-Fi hotspot.
+	TetheringDrain []TetheringDrain
+	// MotionDrain is the drain while unplugged in each motion state, e.g. commuting.
+	MotionDrain []LevelDropRate
+	// WakelockChurn are the wakelock tags acquired most often.
+	WakelockChurn []parseutils.WakelockChurn
+	// SyncCost are the apps with the highest sync time weighted by the network they synced over.
+	SyncCost []parseutils.SyncCost
 }
 
 // DurationStats contain stats on the occrurence frequency and activity duration of a metric present in history.
@@ -201,6 +291,100 @@ type MultiDurationStats struct {
 	Stats  []DurationStats
 }
 
+// LevelDropRate contains the battery level drop rate while the device was in a given state.
+type LevelDropRate struct {
+	State            string
+	LevelDrop        int
+	Duration         time.Duration
+	LevelDropPerHour float64
+}
+
+// LevelDropPerMinute returns the battery level drop per minute in the state.
+func (r LevelDropRate) LevelDropPerMinute() float64 {
+	return r.LevelDropPerHour / 60
+}
+
+// levelDropRates returns the drop rates for the states in the given duration summary, sorted by state.
+func levelDropRates(drops map[string]int, durations map[string]parseutils.Dist) []LevelDropRate {
+	var states []string
+	for st, d := range durations {
+		if d.TotalDuration > 0 {
+			states = append(states, st)
+		}
+	}
+	sort.Strings(states)
+
+	var rates []LevelDropRate
+	for _, st := range states {
+		d := durations[st].TotalDuration
+		rates = append(rates, LevelDropRate{
+			State:            st,
+			LevelDrop:        drops[st],
+			Duration:         d,
+			LevelDropPerHour: float64(drops[st]) / d.Hours(),
+		})
+	}
+	return rates
+}
+
+// TetheringDrain contains the battery level drop rate while tethering, compared to the rest of the summary.
+type TetheringDrain struct {
+	LevelDropRate
+	// DeltaPerHour is the drop rate while tethering minus the drop rate while unplugged and not tethering.
+	DeltaPerHour float64
+}
+
+// tetheringDrain returns the drop rates while tethering in the summary, compared to the drop rate
+// while unplugged and not tethering over the rest of the summary.
+func tetheringDrain(s parseutils.ActivitySummary, duration time.Duration) []TetheringDrain {
+	rates := levelDropRates(s.TetheringLevelDrop, s.TetheringSummary)
+	drop := s.InitialBatteryLevel - s.FinalBatteryLevel
+	rest := duration - s.PluggedInSummary.TotalDuration
+	for _, r := range rates {
+		drop -= r.LevelDrop
+		rest -= r.Duration
+	}
+	var base float64
+	if rest > 0 {
+		base = float64(drop) / rest.Hours()
+	}
+	var res []TetheringDrain
+	for _, r := range rates {
+		res = append(res, TetheringDrain{r, r.LevelDropPerHour - base})
+	}
+	return res
+}
+
+// WindowStats contains the worst value of a metric seen in any window of a fixed length.
+type WindowStats struct {
+	Name   string
+	Window time.Duration
+	Value  string
+	Start  string
+	End    string
+}
+
+func windowsPrint(ws []parseutils.WindowStat) []WindowStats {
+	var stats []WindowStats
+	for _, w := range ws {
+		v := fmt.Sprintf("%d %s", w.Value, w.Unit)
+		switch w.Unit {
+		case parseutils.UnitMs:
+			v = (time.Duration(w.Value) * time.Millisecond).String()
+		case parseutils.UnitPercent:
+			v = fmt.Sprintf("%d%%", w.Value)
+		}
+		stats = append(stats, WindowStats{
+			Name:   w.Name,
+			Window: w.Window,
+			Value:  v,
+			Start:  time.Unix(0, w.StartMs*int64(time.Millisecond)).String(),
+			End:    time.Unix(0, w.EndMs*int64(time.Millisecond)).String(),
+		})
+	}
+	return stats
+}
+
 type internalDist struct {
 	parseutils.Dist
 }
@@ -840,18 +1024,30 @@ func Data(meta *bugreportutils.MetaInfo, fname string, summaries []parseutils.Ac
 		}
 
 		t := UnplugSummary{
-			Date:             s.Date,
-			Reason:           s.Reason,
-			SummaryStart:     time.Unix(0, s.StartTimeMs*int64(time.Millisecond)).String(),
-			SummaryEnd:       time.Unix(0, s.EndTimeMs*int64(time.Millisecond)).String(),
-			Duration:         (time.Duration(s.EndTimeMs-s.StartTimeMs) * time.Millisecond).String(),
-			LevelDrop:        int32(s.InitialBatteryLevel - s.FinalBatteryLevel),
-			LevelDropPerHour: float64(s.InitialBatteryLevel-s.FinalBatteryLevel) / duration.Hours(),
+			Date:                         s.Date,
+			Reason:                       s.Reason,
+			Charging:                     s.Charging,
+			Untimed:                      s.Untimed,
+			SummaryStart:                 time.Unix(0, s.StartTimeMs*int64(time.Millisecond)).String(),
+			SummaryEnd:                   time.Unix(0, s.EndTimeMs*int64(time.Millisecond)).String(),
+			Duration:                     (time.Duration(s.EndTimeMs-s.StartTimeMs) * time.Millisecond).String(),
+			LevelDrop:                    int32(s.InitialBatteryLevel - s.FinalBatteryLevel),
+			LevelDropPerHour:             float64(s.InitialBatteryLevel-s.FinalBatteryLevel) / duration.Hours(),
+			SuspendEfficiency:            parseutils.SuspendEfficiency(s.CPURunningSummary.TotalDuration, duration-s.PluggedInSummary.TotalDuration),
+			NetworkSwitchesPerHour:       float64(s.NetworkSwitches) / duration.Hours(),
+			UnattributedLevelDrop:        s.UnattributedLevelDrop,
+			UnattributedDuration:         s.UnattributedDuration,
+			UnattributedLevelDropPerHour: s.UnattributedLevelDropPerHour(),
 			SystemStats: []DurationStats{
 				internalDist{s.ScreenOnSummary}.print(hScreenOn, duration),
+				internalDist{s.ScreenOnSessionSummary}.print(hScreenOnSessions, duration),
+				internalDist{s.ScreenPulseSummary}.print(hScreenPulses, duration),
 				internalDist{s.CPURunningSummary}.print(hCPURunning, duration),
+				internalDist{s.CPURunningWakeupSummary}.print(hCPURunningWakeups, duration),
+				internalDist{s.CPURunningAbortSummary}.print(hCPURunningSuspendAborts, duration),
 				internalDist{s.TotalSyncSummary}.print(hTotalSync, duration),
 				internalDist{s.MobileRadioOnSummary}.print(hRadioOn, duration),
+				internalDist{s.IdleMobileRadioSummary}.print(hRadioOnIdle, duration),
 				internalDist{s.PhoneCallSummary}.print(hPhoneCall, duration),
 				internalDist{s.GpsOnSummary}.print(hGpsOn, duration),
 				internalDist{s.WifiFullLockSummary}.print(hWifiFullLock, duration),
@@ -860,6 +1056,7 @@ func Data(meta *bugreportutils.MetaInfo, fname string, summaries []parseutils.Ac
 				internalDist{s.WifiOnSummary}.print(hWifiOn, duration),
 				internalDist{s.WifiRunningSummary}.print(hWifiRunning, duration),
 				internalDist{s.WifiRadioSummary}.print(hWifiRadio, duration),
+				internalDist{s.IdleWifiRadioSummary}.print(hWifiRadioIdle, duration),
 				internalDist{s.PhoneScanSummary}.print(hPhoneScan, duration),
 				internalDist{s.SensorOnSummary}.print(hSensorOn, duration),
 				internalDist{s.PluggedInSummary}.print(hPluggedIn, duration),
@@ -867,22 +1064,34 @@ func Data(meta *bugreportutils.MetaInfo, fname string, summaries []parseutils.Ac
 				internalDist{s.LowPowerModeOnSummary}.print(hLowPowerModeOn, duration),
 				internalDist{s.AudioOnSummary}.print(hAudioOn, duration),
 				internalDist{s.VideoOnSummary}.print(hVideoOn, duration),
+				internalDist{s.NoConnectivitySummary}.print(hNoConnectivity, duration),
 			},
 			BreakdownStats: []MultiDurationStats{
 				mapPrint(hDataConnectionSummary, s.DataConnectionSummary, duration),
 				mapPrint(hConnectivitySummary, s.ConnectivitySummary, duration),
+				mapPrint(hDefaultNetworkSummary, s.DefaultNetworkSummary, duration),
 				mapPrint(hPerAppSyncSummary, s.PerAppSyncSummary, duration),
+				mapPrint(hMobileRadioAppSummary, s.MobileRadioActiveAppSummary, duration),
+				mapPrint(hAPWakeupSummary, s.APWakeupSummary, duration),
+				mapPrint(hAPWakeupScreenOffSummary, s.APWakeupScreenOffSummary, duration),
+				mapPrint(hMobileRadioEnergySummary, s.MobileRadioEnergyAppSummary, duration),
+				mapPrint(hAttributedCPURunning, s.AttributedCPURunningSummary, duration),
 				mapPrint(hWakeupReasonSummary, s.WakeupReasonSummary, duration),
 				mapPrint(hFirstWakelockAfterSuspend, s.WakeLockSummary, duration),
 				mapPrint(hDetailedWakelockSummary, s.WakeLockDetailedSummary, duration),
 				mapPrint(hForegroundProcessSummary, s.ForegroundProcessSummary, duration),
 				mapPrint(hPhoneStateSummary, s.PhoneStateSummary, duration),
 				mapPrint(hScheduledJobSummary, s.ScheduledJobSummary, duration),
+				mapPrint(hWakeupAlarmSummary, s.WakeupAlarmSummary, duration),
+				mapPrint(hWakeupAlarmAwakeSummary, s.WakeupAlarmAwakeSummary, duration),
+				mapPrint(hNonWakeupAlarmSummary, s.NonWakeupAlarmSummary, duration),
+				mapPrint(hWakeAttributionSummary, s.WakeAttributionSummary, duration),
 				mapPrint(hWifiSupplSummary, s.WifiSupplSummary, duration),
 				mapPrint(hPhoneSignalStrengthSummary, s.PhoneSignalStrengthSummary, duration),
 				mapPrint(hWifiSignalStrengthSummary, s.WifiSignalStrengthSummary, duration),
 				mapPrint(hTopApplicationSummary, s.TopApplicationSummary, duration),
 				mapPrint(hIdleModeSummary, s.IdleModeSummary, duration),
+				mapPrint(hBodyStateSummary, s.BodyStateSummary, duration),
 				// Disabled as they were not found to be very useful.
 				/*
 				   mapPrint("HealthSummary", s.HealthSummary, duration),
@@ -890,7 +1099,23 @@ func Data(meta *bugreportutils.MetaInfo, fname string, summaries []parseutils.Ac
 				   mapPrint("ChargingStatusSummary", s.ChargingStatusSummary, duration),
 				*/
 			},
-			PowerStates: s.PowerStateOverallSummary,
+			PowerStates:   s.PowerStateOverallSummary,
+			WorstWindows:  windowsPrint(s.WorstWindows),
+			WakelockChurn: s.WakelockChurnSummary,
+			SyncCost:      s.SyncCostSummary,
+		}
+		// Only wearables report the body state.
+		if len(s.BodyStateSummary) > 0 {
+			t.BodyStateDrain = levelDropRates(s.BodyStateLevelDrop, s.BodyStateSummary)
+		}
+		if len(s.CallSummary) > 0 {
+			t.CallDrain = levelDropRates(s.CallLevelDrop, s.CallSummary)
+		}
+		if len(s.TetheringSummary) > 0 {
+			t.TetheringDrain = tetheringDrain(s, duration)
+		}
+		if len(s.MotionStateSummary) > 0 {
+			t.MotionDrain = levelDropRates(s.MotionStateLevelDrop, s.MotionStateSummary)
 		}
 		output = append(output, t)
 	}
@@ -0,0 +1,193 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rollup reduces the analysis of a bug report to a single row of KPIs, so that bots can
+// append it to a tracking sheet or post it to chat after each upload.
+//
+// The row is available as JSON, as a CSV row with the columns of Header, and as a line of text. Rates
+// are per hour unplugged, and the period is in milliseconds since epoch in JSON, and RFC 3339 in the
+// CSV and text.
+package rollup
+
+import (
+	encodingcsv "encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// Header is the header of the CSV rows.
+var Header = []string{
+	"model",
+	"build fingerprint",
+	"start",
+	"end",
+	"unplugged hours",
+	"screen off drain %/hr",
+	"screen on drain %/hr",
+	"wakeups/hr",
+	"top offender",
+	"suspend efficiency %",
+}
+
+// Row is the KPIs of a single bug report.
+type Row struct {
+	Model            string `json:"model"`
+	BuildFingerprint string `json:"buildFingerprint"`
+	// StartMs and EndMs are the period covered by the battery history.
+	StartMs int64 `json:"startMs"`
+	EndMs   int64 `json:"endMs"`
+	// UnpluggedHours is the time the device was unplugged, which the rates are relative to.
+	UnpluggedHours float64 `json:"unpluggedHours"`
+	// ScreenOffDrainPerHour and ScreenOnDrainPerHour are the battery level drops in percent per hour
+	// unplugged with the screen off and on.
+	ScreenOffDrainPerHour float64 `json:"screenOffDrainPerHour"`
+	ScreenOnDrainPerHour  float64 `json:"screenOnDrainPerHour"`
+	// WakeupsPerHour is the number of times the CPU started running.
+	WakeupsPerHour float64 `json:"wakeupsPerHour"`
+	// TopOffender is the app with the most attributed CPU running time unplugged, empty if none.
+	TopOffender string `json:"topOffender"`
+	// SuspendEfficiency is the percentage of the unplugged time the CPU was not running.
+	SuspendEfficiency float64 `json:"suspendEfficiency"`
+}
+
+// appDuration is the attributed CPU running time of an app.
+type appDuration struct {
+	app string
+	d   time.Duration
+}
+
+// byDuration sorts apps in descending order of duration, then by name.
+type byDuration []appDuration
+
+func (a byDuration) Len() int      { return len(a) }
+func (a byDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDuration) Less(i, j int) bool {
+	if a[i].d != a[j].d {
+		return a[i].d > a[j].d
+	}
+	return a[i].app < a[j].app
+}
+
+// New returns the KPIs of the battery history CSV and summaries generated by AnalyzeHistory. The
+// wakeups, top offender and suspend efficiency are taken from the discharge summaries, skipping
+// charging summaries and summaries from before the clock was set. meta may be nil.
+func New(meta *bugreportutils.MetaInfo, csvInput string, summaries []parseutils.ActivitySummary) (*Row, []error) {
+	r := &Row{}
+	if meta != nil {
+		r.Model, r.BuildFingerprint = meta.ModelName, meta.BuildFingerprint
+	}
+	var wakeups int32
+	var unplugged, cpu time.Duration
+	apps := make(map[string]time.Duration)
+	for _, s := range summaries {
+		if s.Untimed {
+			continue
+		}
+		if r.StartMs == 0 || s.StartTimeMs < r.StartMs {
+			r.StartMs = s.StartTimeMs
+		}
+		if s.EndTimeMs > r.EndMs {
+			r.EndMs = s.EndTimeMs
+		}
+		if s.Charging {
+			continue
+		}
+		d := time.Duration(s.EndTimeMs-s.StartTimeMs)*time.Millisecond - s.PluggedInSummary.TotalDuration
+		if d <= 0 {
+			continue
+		}
+		unplugged += d
+		wakeups += s.CPURunningSummary.Num
+		cpu += s.CPURunningSummary.TotalDuration
+		for app, dist := range s.AttributedCPURunningSummary {
+			apps[app] += dist.TotalDuration
+		}
+	}
+	if unplugged > 0 {
+		r.UnpluggedHours = unplugged.Hours()
+		r.WakeupsPerHour = float64(wakeups) / r.UnpluggedHours
+		r.SuspendEfficiency = parseutils.SuspendEfficiency(cpu, unplugged)
+	}
+	var sorted []appDuration
+	for app, d := range apps {
+		if d > 0 {
+			sorted = append(sorted, appDuration{app, d})
+		}
+	}
+	sort.Sort(byDuration(sorted))
+	if len(sorted) > 0 {
+		r.TopOffender = sorted[0].app
+	}
+
+	drain, errs := parseutils.ScreenDrainRates(csvInput)
+	r.ScreenOffDrainPerHour = drain.ScreenOffPerHour()
+	r.ScreenOnDrainPerHour = drain.ScreenOnPerHour()
+	return r, errs
+}
+
+// formatTime returns the time in ms since epoch in RFC 3339 format, in UTC.
+func formatTime(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+// formatFloat returns the value rounded to 2 decimals.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// Record returns the CSV record of the row, with the columns of Header.
+func (r *Row) Record() []string {
+	return []string{
+		r.Model,
+		r.BuildFingerprint,
+		formatTime(r.StartMs),
+		formatTime(r.EndMs),
+		formatFloat(r.UnpluggedHours),
+		formatFloat(r.ScreenOffDrainPerHour),
+		formatFloat(r.ScreenOnDrainPerHour),
+		formatFloat(r.WakeupsPerHour),
+		r.TopOffender,
+		formatFloat(r.SuspendEfficiency),
+	}
+}
+
+// WriteCSV writes the row as CSV, preceded by Header if header is true.
+func (r *Row) WriteCSV(w io.Writer, header bool) error {
+	cw := encodingcsv.NewWriter(w)
+	if header {
+		cw.Write(Header)
+	}
+	cw.Write(r.Record())
+	cw.Flush()
+	return cw.Error()
+}
+
+// String returns the row as a single line of text, e.g. for chat.
+func (r *Row) String() string {
+	model, top := r.Model, r.TopOffender
+	if model == "" {
+		model = "Unknown device"
+	}
+	if top == "" {
+		top = "none"
+	}
+	return fmt.Sprintf("%s %s to %s: %.2f%%/hr screen off, %.2f%%/hr screen on, %.2f wakeups/hr, top offender %s, %.2f%% suspend efficiency over %.2fh unplugged",
+		model, formatTime(r.StartMs), formatTime(r.EndMs), r.ScreenOffDrainPerHour, r.ScreenOnDrainPerHour, r.WakeupsPerHour, top, r.SuspendEfficiency, r.UnpluggedHours)
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollup
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// TestNew tests the KPIs of the discharge summaries and their CSV and text forms.
+func TestNew(t *testing.T) {
+	input := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,1422620451417,1422624051417,90,`,
+		`Battery Level,int,1422624051417,1422627651417,88,`,
+		`Screen,bool,1422624051417,1422627651417,true,`,
+		`Battery Level,int,1422627651417,1422627651417,84,`,
+	}, "\n")
+	summaries := []parseutils.ActivitySummary{
+		{
+			StartTimeMs:       1422620451417,
+			EndTimeMs:         1422627651417,
+			CPURunningSummary: parseutils.Dist{Num: 10, TotalDuration: 36 * time.Minute},
+			AttributedCPURunningSummary: map[string]parseutils.Dist{
+				"com.example.mail":  {Num: 3, TotalDuration: 10 * time.Minute},
+				"com.example.maps":  {Num: 1, TotalDuration: 20 * time.Minute},
+				"com.example.music": {Num: 6, TotalDuration: 20 * time.Minute},
+			},
+		},
+		{
+			StartTimeMs:       1422627651417,
+			EndTimeMs:         1422631251417,
+			Charging:          true,
+			CPURunningSummary: parseutils.Dist{Num: 100, TotalDuration: time.Hour},
+		},
+	}
+	meta := &bugreportutils.MetaInfo{ModelName: "Nexus 6", BuildFingerprint: "google/shamu/shamu:6.0/MRA58K"}
+	got, errs := New(meta, input, summaries)
+	if len(errs) > 0 {
+		t.Fatalf("New generated unexpected errors: %v", errs)
+	}
+	want := &Row{
+		Model:                 "Nexus 6",
+		BuildFingerprint:      "google/shamu/shamu:6.0/MRA58K",
+		StartMs:               1422620451417,
+		EndMs:                 1422631251417,
+		UnpluggedHours:        2,
+		ScreenOffDrainPerHour: 2,
+		ScreenOnDrainPerHour:  4,
+		WakeupsPerHour:        5,
+		TopOffender:           "com.example.maps",
+		SuspendEfficiency:     70,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("New()\n got: %+v\n want: %+v", got, want)
+	}
+
+	var b bytes.Buffer
+	if err := got.WriteCSV(&b, true); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	wantCSV := strings.Join(Header, ",") + "\n" +
+		"Nexus 6,google/shamu/shamu:6.0/MRA58K,2015-01-30T12:20:51Z,2015-01-30T15:20:51Z,2.00,2.00,4.00,5.00,com.example.maps,70.00\n"
+	if b.String() != wantCSV {
+		t.Errorf("WriteCSV()\n got: %q\n want: %q", b.String(), wantCSV)
+	}
+	wantText := "Nexus 6 2015-01-30T12:20:51Z to 2015-01-30T15:20:51Z: 2.00%/hr screen off, 4.00%/hr screen on, 5.00 wakeups/hr, top offender com.example.maps, 70.00% suspend efficiency over 2.00h unplugged"
+	if s := got.String(); s != wantText {
+		t.Errorf("String()\n got: %q\n want: %q", s, wantText)
+	}
+}
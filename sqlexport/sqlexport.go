@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqlexport exports the summaries generated by parseutils into SQLite tables,
+// so that many reports can be analyzed together with ad-hoc SQL queries.
+package sqlexport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+)
+
+// Schema contains the statements creating the exported tables. All durations are in milliseconds.
+const Schema = `CREATE TABLE IF NOT EXISTS summaries (
+  report TEXT,
+  summary INTEGER,
+  reason TEXT,
+  start_ms INTEGER,
+  end_ms INTEGER,
+  initial_battery_level INTEGER,
+  final_battery_level INTEGER
+);
+CREATE TABLE IF NOT EXISTS summary_stats (
+  report TEXT,
+  summary INTEGER,
+  metric TEXT,
+  num INTEGER,
+  total_duration_ms INTEGER,
+  max_duration_ms INTEGER
+);
+CREATE TABLE IF NOT EXISTS breakdown_stats (
+  report TEXT,
+  summary INTEGER,
+  metric TEXT,
+  name TEXT,
+  num INTEGER,
+  total_duration_ms INTEGER,
+  max_duration_ms INTEGER
+);
+CREATE TABLE IF NOT EXISTS app_stats (
+  report TEXT,
+  summary INTEGER,
+  metric TEXT,
+  uid INTEGER,
+  package TEXT,
+  label TEXT,
+  num INTEGER,
+  total_duration_ms INTEGER,
+  max_duration_ms INTEGER
+);
+`
+
+var (
+	distType    = reflect.TypeOf(parseutils.Dist{})
+	distMapType = reflect.TypeOf(map[string]parseutils.Dist{})
+)
+
+// quote returns s as a SQL string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func ms(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// WriteSQL writes the statements inserting the given summaries of a report into the exported tables.
+// Each Dist field of a summary is written as a row in summary_stats, and each entry of a
+// map[string]Dist field (e.g. per app breakdowns) as a row in breakdown_stats, using the
+// field name as the metric. The AppDists of a summary are written as rows in app_stats, so that
+// apps can be joined by UID and package instead of by breakdown name.
+func WriteSQL(w io.Writer, report string, summaries []parseutils.ActivitySummary) {
+	io.WriteString(w, "BEGIN TRANSACTION;\n")
+	for i, s := range summaries {
+		fmt.Fprintf(w, "INSERT INTO summaries VALUES (%s, %d, %s, %d, %d, %d, %d);\n",
+			quote(report), i, quote(s.Reason), s.StartTimeMs, s.EndTimeMs, s.InitialBatteryLevel, s.FinalBatteryLevel)
+
+		v := reflect.ValueOf(s)
+		for j := 0; j < v.NumField(); j++ {
+			f := v.Type().Field(j)
+			switch f.Type {
+			case distType:
+				d := v.Field(j).Interface().(parseutils.Dist)
+				fmt.Fprintf(w, "INSERT INTO summary_stats VALUES (%s, %d, %s, %d, %d, %d);\n",
+					quote(report), i, quote(f.Name), d.Num, ms(d.TotalDuration), ms(d.MaxDuration))
+			case distMapType:
+				m := v.Field(j).Interface().(map[string]parseutils.Dist)
+				// Sort the keys so that the output is deterministic.
+				var names []string
+				for n := range m {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				for _, n := range names {
+					d := m[n]
+					fmt.Fprintf(w, "INSERT INTO breakdown_stats VALUES (%s, %d, %s, %s, %d, %d, %d);\n",
+						quote(report), i, quote(f.Name), quote(n), d.Num, ms(d.TotalDuration), ms(d.MaxDuration))
+				}
+			}
+		}
+		for _, d := range s.AppDists {
+			fmt.Fprintf(w, "INSERT INTO app_stats VALUES (%s, %d, %s, %d, %s, %s, %d, %d, %d);\n",
+				quote(report), i, quote(d.Metric), d.Key.UID, quote(d.Key.Package), quote(d.Key.Label), d.Num, d.TotalDurationMs, d.MaxDurationMs)
+		}
+	}
+	io.WriteString(w, "COMMIT;\n")
+}
+
+// Export writes the given summaries of a report into the SQLite database at path, creating
+// the database and tables if needed. Requires the sqlite3 command line tool to be installed.
+func Export(path, report string, summaries []parseutils.ActivitySummary) error {
+	var b bytes.Buffer
+	b.WriteString(Schema)
+	WriteSQL(&b, report, summaries)
+
+	cmd := exec.Command("sqlite3", "-bail", path)
+	cmd.Stdin = &b
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write sqlite database %q:\n  %v\n  %s", path, err, stderr.String())
+	}
+	return nil
+}
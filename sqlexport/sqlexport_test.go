@@ -0,0 +1,84 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlexport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+)
+
+// TestWriteSQL tests the statements generated for a summary.
+func TestWriteSQL(t *testing.T) {
+	s := parseutils.ActivitySummary{
+		Reason:              "UNPLUG",
+		StartTimeMs:         1000,
+		EndTimeMs:           5000,
+		InitialBatteryLevel: 90,
+		FinalBatteryLevel:   88,
+		ScreenOnSummary: parseutils.Dist{
+			Num:           2,
+			TotalDuration: 3 * time.Second,
+			MaxDuration:   2 * time.Second,
+		},
+		TopApplicationSummary: map[string]parseutils.Dist{
+			`"com.google.android.apps.maps"`: {
+				Num:           1,
+				TotalDuration: 1500 * time.Millisecond,
+				MaxDuration:   1500 * time.Millisecond,
+			},
+			`"com.it's.quoted"`: {
+				Num:           1,
+				TotalDuration: time.Second,
+				MaxDuration:   time.Second,
+			},
+		},
+		AppDists: []parseutils.AppDist{
+			{
+				Metric:          "TopApplicationSummary",
+				Key:             parseutils.AppKey{UID: 10023, Package: "com.google.android.apps.maps"},
+				Num:             1,
+				TotalDurationMs: 1500,
+				MaxDurationMs:   1500,
+			},
+		},
+	}
+	want := []string{
+		`BEGIN TRANSACTION;`,
+		`INSERT INTO summaries VALUES ('report.txt', 0, 'UNPLUG', 1000, 5000, 90, 88);`,
+		`INSERT INTO summary_stats VALUES ('report.txt', 0, 'ScreenOnSummary', 2, 3000, 2000);`,
+		`INSERT INTO breakdown_stats VALUES ('report.txt', 0, 'TopApplicationSummary', '"com.google.android.apps.maps"', 1, 1500, 1500);`,
+		`INSERT INTO breakdown_stats VALUES ('report.txt', 0, 'TopApplicationSummary', '"com.it''s.quoted"', 1, 1000, 1000);`,
+		`INSERT INTO app_stats VALUES ('report.txt', 0, 'TopApplicationSummary', 10023, 'com.google.android.apps.maps', '', 1, 1500, 1500);`,
+		`COMMIT;`,
+	}
+
+	var b bytes.Buffer
+	WriteSQL(&b, "report.txt", []parseutils.ActivitySummary{s})
+	got := strings.Split(strings.TrimSpace(b.String()), "\n")
+	// Only check the statements for the populated fields, other Dist fields generate zero rows.
+	var filtered []string
+	for _, l := range got {
+		if !strings.HasSuffix(l, ", 0, 0, 0);") {
+			filtered = append(filtered, l)
+		}
+	}
+	if strings.Join(filtered, "\n") != strings.Join(want, "\n") {
+		t.Errorf("WriteSQL(%v) =\n%s\nwant:\n%s", s, strings.Join(filtered, "\n"), strings.Join(want, "\n"))
+	}
+}
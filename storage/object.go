@@ -0,0 +1,291 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// object.go implements stores backed by the REST APIs of Google Cloud Storage and Amazon S3.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	// gcsMetadataTokenURL returns an access token for the default service account when running on Google Cloud.
+	gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	defaultS3Region = "us-east-1"
+)
+
+// objectStore stores objects under a base URL using PUT, GET and DELETE requests.
+type objectStore struct {
+	baseURL string
+	prefix  string
+	client  *http.Client
+	// authorize adds the credentials to the request before it is sent.
+	authorize func(req *http.Request, payload []byte) error
+}
+
+// uriEncode percent encodes every byte of s except unreserved characters and slashes,
+// as required by the AWS signature and accepted by both object stores.
+func uriEncode(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (s *objectStore) do(method, key string, payload []byte) ([]byte, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	req, err := http.NewRequest(method, s.baseURL+"/"+uriEncode(key), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if s.authorize != nil {
+		if err := s.authorize(req, payload); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, body)
+	}
+	return body, nil
+}
+
+// Put stores the data under the key.
+func (s *objectStore) Put(key string, data []byte) error {
+	_, err := s.do("PUT", key, data)
+	return err
+}
+
+// Get returns the data stored under the key.
+func (s *objectStore) Get(key string) ([]byte, error) {
+	return s.do("GET", key, nil)
+}
+
+// Delete removes the data stored under the key.
+func (s *objectStore) Delete(key string) error {
+	if _, err := s.do("DELETE", key, nil); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// gcsToken provides OAuth2 access tokens for Google Cloud Storage.
+type gcsToken struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// get returns a cached token, or fetches a new one from the metadata server if it is about to expire.
+func (t *gcsToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	req, err := http.NewRequest("GET", gcsMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not get access token from metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get access token from metadata server: %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	t.token = tok.AccessToken
+	// Refresh a minute early so that tokens don't expire during a request.
+	t.expires = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+// NewGCSStore returns a store writing to the Google Cloud Storage bucket, with keys prefixed by prefix if non empty.
+// The access token is read from the GOOGLE_OAUTH_ACCESS_TOKEN environment variable if set, otherwise
+// it is requested from the metadata server of the Google Cloud instance the server is running on.
+func NewGCSStore(bucket, prefix string) (Store, error) {
+	if bucket == "" {
+		return nil, errors.New("empty GCS bucket")
+	}
+	client := &http.Client{Timeout: time.Minute}
+	s := &objectStore{baseURL: gcsEndpoint + "/" + bucket, prefix: prefix, client: client}
+	if tok := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); tok != "" {
+		s.authorize = func(req *http.Request, _ []byte) error {
+			req.Header.Set("Authorization", "Bearer "+tok)
+			return nil
+		}
+		return s, nil
+	}
+	t := &gcsToken{client: client}
+	s.authorize = func(req *http.Request, _ []byte) error {
+		tok, err := t.get()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+		return nil
+	}
+	return s, nil
+}
+
+// s3Signer signs requests with AWS Signature Version 4.
+type s3Signer struct {
+	accessKey, secretKey, sessionToken, region string
+	now                                        func() time.Time
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// sign adds the date, payload hash and authorization headers to the request. All headers
+// already set on the request, and the host, are signed.
+func (s *s3Signer) sign(req *http.Request, payload []byte) error {
+	t := s.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", n, headers[n])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// NewS3Store returns a store writing to the Amazon S3 bucket, with keys prefixed by prefix if non empty.
+// The credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and optional AWS_SESSION_TOKEN
+// environment variables, and the region from AWS_REGION (defaults to us-east-1). AWS_S3_ENDPOINT can be set
+// to use an S3 compatible service, in which case path style URLs are used.
+func NewS3Store(bucket, prefix string) (Store, error) {
+	if bucket == "" {
+		return nil, errors.New("empty S3 bucket")
+	}
+	signer := &s3Signer{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		region:       os.Getenv("AWS_REGION"),
+		now:          time.Now,
+	}
+	if signer.accessKey == "" || signer.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use S3 storage")
+	}
+	if signer.region == "" {
+		signer.region = defaultS3Region
+	}
+	baseURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, signer.region)
+	if e := os.Getenv("AWS_S3_ENDPOINT"); e != "" {
+		baseURL = strings.TrimSuffix(e, "/") + "/" + bucket
+	}
+	return &objectStore{
+		baseURL:   baseURL,
+		prefix:    prefix,
+		client:    &http.Client{Timeout: time.Minute},
+		authorize: signer.sign,
+	}, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage provides backends for persisting uploaded reports and cached analyses,
+// so that the Historian server can run statelessly behind a load balancer.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get if there is no object stored under the key.
+var ErrNotFound = errors.New("object not found")
+
+// Store is a key value store for blobs. Keys are slash separated paths, e.g. "uploads/abc/bugreport".
+type Store interface {
+	// Put stores the data under the key, replacing any existing data.
+	Put(key string, data []byte) error
+	// Get returns the data stored under the key, or ErrNotFound if there is none.
+	Get(key string) ([]byte, error)
+	// Delete removes the data stored under the key. Deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// Object describes an object in a store.
+type Object struct {
+	Key  string
+	Size int64
+	// Modified is when the object was last stored.
+	Modified time.Time
+}
+
+// Lister is implemented by stores that can list their objects, which is needed to clean them up.
+type Lister interface {
+	// List returns the objects whose keys start with the prefix, in key order.
+	List(prefix string) ([]Object, error)
+}
+
+// New returns the Store described by spec, which is one of:
+//
+//	gs://bucket[/prefix]  Google Cloud Storage, see NewGCSStore.
+//	s3://bucket[/prefix]  Amazon S3, see NewS3Store.
+//	[file://]directory    A directory on the local disk.
+func New(spec string) (Store, error) {
+	switch {
+	case spec == "":
+		return nil, errors.New("empty storage spec")
+	case strings.HasPrefix(spec, "gs://"):
+		bucket, prefix := splitBucket(strings.TrimPrefix(spec, "gs://"))
+		return NewGCSStore(bucket, prefix)
+	case strings.HasPrefix(spec, "s3://"):
+		bucket, prefix := splitBucket(strings.TrimPrefix(spec, "s3://"))
+		return NewS3Store(bucket, prefix)
+	default:
+		return NewDiskStore(strings.TrimPrefix(spec, "file://"))
+	}
+}
+
+// splitBucket splits "bucket/some/prefix" into the bucket and prefix.
+func splitBucket(s string) (string, string) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.Trim(parts[1], "/")
+}
+
+// validKey returns an error if the key is empty or could escape the store root.
+func validKey(key string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	for _, p := range strings.Split(key, "/") {
+		if p == "" || p == "." || p == ".." {
+			return fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// DiskStore stores objects as files under a directory.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore returns a store writing to the given directory, creating it if needed.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if dir == "" {
+		return nil, errors.New("empty storage directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir}, nil
+}
+
+func (s *DiskStore) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put stores the data under the key. The data is written to a temporary file first,
+// so concurrent readers never see a partially written object.
+func (s *DiskStore) Put(key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get returns the data stored under the key.
+func (s *DiskStore) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Delete removes the data stored under the key.
+func (s *DiskStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the objects whose keys start with the prefix. Temporary files of writes in progress aren't listed.
+func (s *DiskStore) List(prefix string) ([]Object, error) {
+	var objs []Object
+	err := filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			objs = append(objs, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		}
+		return nil
+	})
+	return objs, err
+}
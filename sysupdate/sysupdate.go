@@ -0,0 +1,219 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sysupdate detects system updates in a bug report, from the update_engine logs and the
+// installs of platform packages, and compares the battery drain before and after the update.
+package sysupdate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// Metric is the CSV description of system update events.
+	Metric = "System update"
+
+	// updateGap is the maximum time between consecutive events of the same update.
+	updateGap = 30 * time.Minute
+	// minDrainDuration is the minimum unplugged time on each side of the updates for the drain to be compared.
+	minDrainDuration = 30 * time.Minute
+
+	// platformPackagePrefix is the prefix of the packages updated with the system image.
+	platformPackagePrefix = "com.android."
+
+	// Battery history metrics used for the detection and the drain comparison.
+	batteryLevel   = "Battery Level"
+	packageInstall = "Package install"
+	plugged        = "Plugged"
+)
+
+// logRE matches an update_engine or update_verifier logcat line.
+// e.g. "02-15 03:12:45.123  1234  1250 I update_engine: [INFO:delta_performer.cc(215)] Completed 1023/1024 operations (99%)"
+var logRE = bugreportutils.LogcatTagRE(`update_engine|update_verifier`)
+
+// Event is a log line or package install related to a system update.
+type Event struct {
+	// Source is the log tag, e.g. update_engine, or packageInstall.
+	Source string
+	// Package is the installed package, empty for log lines.
+	Package string
+	TimeMs  int64
+}
+
+// byTime sorts events in ascending order of time.
+type byTime []Event
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].TimeMs < a[j].TimeMs }
+
+// Parse returns the update_engine and update_verifier log lines found in the bug report, sorted by time.
+func Parse(bugreport string, taken time.Time) ([]Event, []error) {
+	var events []Event
+	var errs []error
+	for _, line := range strings.Split(bugreport, "\n") {
+		m, result := historianutils.SubexpNames(logRE, strings.TrimRight(line, "\r"))
+		if !m {
+			continue
+		}
+		ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s timestamp %q: %v", result["tag"], line, err))
+			continue
+		}
+		events = append(events, Event{Source: result["tag"], TimeMs: ms})
+	}
+	sort.Stable(byTime(events))
+	return events, errs
+}
+
+// Update is a group of system update events close together in time.
+type Update struct {
+	StartMs, EndMs int64
+	// Sources are the log tags and packageInstall, if the update was seen in them.
+	Sources []string
+	// Packages are the platform packages installed during the update.
+	Packages []string
+	// Count is the number of events in the update.
+	Count int
+}
+
+// Start returns the time of the first event of the update.
+func (u Update) Start() time.Time {
+	return time.Unix(0, u.StartMs*int64(time.Millisecond))
+}
+
+// End returns the time of the last event of the update.
+func (u Update) End() time.Time {
+	return time.Unix(0, u.EndMs*int64(time.Millisecond))
+}
+
+// String returns a readable description of the update, e.g. "update_engine, Package install: com.android.phone".
+func (u Update) String() string {
+	s := strings.Join(u.Sources, ", ")
+	if len(u.Packages) > 0 {
+		s += ": " + strings.Join(u.Packages, ", ")
+	}
+	return s
+}
+
+// addUnique appends the value to the sorted values if not already present.
+func addUnique(values []string, v string) []string {
+	i := sort.SearchStrings(values, v)
+	if i < len(values) && values[i] == v {
+		return values
+	}
+	values = append(values, "")
+	copy(values[i+1:], values[i:])
+	values[i] = v
+	return values
+}
+
+// Updates groups the events and the platform package installs in the battery history CSV generated
+// by AnalyzeHistory into system updates. Events less than updateGap apart are part of the same update.
+func Updates(events []Event, historyCSV string) ([]Update, []error) {
+	es, errs := csv.ExtractEvents(historyCSV, []string{packageInstall})
+	all := append([]Event(nil), events...)
+	for _, e := range es[packageInstall] {
+		if strings.HasPrefix(e.Value, platformPackagePrefix) {
+			all = append(all, Event{Source: packageInstall, Package: e.Value, TimeMs: e.Start})
+		}
+	}
+	sort.Stable(byTime(all))
+
+	var updates []Update
+	gapMs := int64(updateGap / time.Millisecond)
+	for _, e := range all {
+		n := len(updates)
+		if n == 0 || e.TimeMs-updates[n-1].EndMs > gapMs {
+			updates = append(updates, Update{StartMs: e.TimeMs})
+			n++
+		}
+		u := &updates[n-1]
+		u.EndMs = e.TimeMs
+		u.Count++
+		u.Sources = addUnique(u.Sources, e.Source)
+		if e.Package != "" {
+			u.Packages = addUnique(u.Packages, e.Package)
+		}
+	}
+	return updates, errs
+}
+
+// CSV returns the updates as CSV events, so they can be seen on the timeline.
+func CSV(updates []Update) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, u := range updates {
+		csvState.Print(Metric, "string", u.StartMs, u.EndMs, u.String(), "")
+	}
+	return b.String()
+}
+
+// Comparison is the battery drain before the first and after the last system update in the history.
+type Comparison struct {
+	Before, After parseutils.Drain
+}
+
+// Change returns the relative change in drain rate after the updates, in percent.
+func (c Comparison) Change() float64 {
+	before := c.Before.PercentPerHour()
+	if before == 0 {
+		return 0
+	}
+	return (c.After.PercentPerHour() - before) / before * 100
+}
+
+// drain returns the battery drain while unplugged in [fromMs, toMs).
+func drain(levels, pluggedIn []csv.Event, fromMs, toMs int64) parseutils.Drain {
+	return parseutils.UnpluggedDrain(levels, pluggedIn, []csv.Event{{Start: fromMs, End: toMs}})
+}
+
+// Compare computes the battery drain while unplugged before the first update and after the last
+// update, from the battery history CSV generated by AnalyzeHistory. It returns nil if there are no
+// updates, or if the device was unplugged for less than minDrainDuration on either side.
+func Compare(updates []Update, historyCSV string) (*Comparison, []error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{batteryLevel, plugged})
+	levels := es[batteryLevel]
+	if len(levels) == 0 {
+		return nil, errs
+	}
+	csv.SortByStart(levels)
+	var pluggedIn []csv.Event
+	for _, e := range es[plugged] {
+		if e.Value == "true" {
+			pluggedIn = append(pluggedIn, e)
+		}
+	}
+	c := &Comparison{
+		Before: drain(levels, pluggedIn, levels[0].Start, updates[0].StartMs),
+		After:  drain(levels, pluggedIn, updates[len(updates)-1].EndMs, levels[len(levels)-1].End),
+	}
+	if c.Before.Duration < minDrainDuration || c.After.Duration < minDrainDuration {
+		return nil, errs
+	}
+	return c, errs
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sysupdate
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.February, 15, 0, 0, 0, 0, time.UTC)
+
+// TestUpdates tests the detection of system updates and the drain comparison around them.
+func TestUpdates(t *testing.T) {
+	br := strings.Join([]string{
+		"------ SYSTEM LOG (logcat -v threadtime -d *:v) ------",
+		"02-15 04:05:00.000  1234  1250 I update_engine: [INFO:update_attempter_android.cc(212)] Using this install plan:",
+		"02-15 04:10:00.000  1234  1250 I ActivityManager: Start proc 4321:com.example/u0a67 for service com.example/.Service",
+		// Logcat line with a uid column.
+		"02-15 04:40:00.000  root   567   567 I update_verifier: Leaving update_verifier.",
+	}, "\n")
+	taken := time.Date(2017, time.February, 15, 10, 0, 0, 0, time.UTC)
+
+	wantEvents := []Event{
+		{Source: "update_engine", TimeMs: historianutils.ClockMs(reportDay, 4, 5, 0)},
+		{Source: "update_verifier", TimeMs: historianutils.ClockMs(reportDay, 4, 40, 0)},
+	}
+	events, errs := Parse(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", br, events, wantEvents)
+	}
+
+	history := strings.Join([]string{
+		csv.FileHeader,
+		fmt.Sprintf("Battery Level,int,%d,%d,90,", historianutils.ClockMs(reportDay, 0, 0, 0), historianutils.ClockMs(reportDay, 2, 0, 0)),
+		fmt.Sprintf("Battery Level,int,%d,%d,88,", historianutils.ClockMs(reportDay, 2, 0, 0), historianutils.ClockMs(reportDay, 4, 0, 0)),
+		fmt.Sprintf("Battery Level,int,%d,%d,87,", historianutils.ClockMs(reportDay, 4, 0, 0), historianutils.ClockMs(reportDay, 5, 0, 0)),
+		fmt.Sprintf("Battery Level,int,%d,%d,83,", historianutils.ClockMs(reportDay, 5, 0, 0), historianutils.ClockMs(reportDay, 7, 0, 0)),
+		fmt.Sprintf("Battery Level,int,%d,%d,79,", historianutils.ClockMs(reportDay, 7, 0, 0), historianutils.ClockMs(reportDay, 9, 0, 0)),
+		fmt.Sprintf("Plugged,bool,%d,%d,true,", historianutils.ClockMs(reportDay, 3, 0, 0), historianutils.ClockMs(reportDay, 4, 30, 0)),
+		fmt.Sprintf("Package install,service,%d,%d,com.android.phone,1001", historianutils.ClockMs(reportDay, 4, 20, 0), historianutils.ClockMs(reportDay, 4, 20, 0)),
+		// Installs of other packages aren't part of the system update.
+		fmt.Sprintf("Package install,service,%d,%d,com.example.app,10067", historianutils.ClockMs(reportDay, 4, 25, 0), historianutils.ClockMs(reportDay, 4, 25, 0)),
+		// Too far from the update to be part of it.
+		fmt.Sprintf("Package install,service,%d,%d,com.android.chrome,10020", historianutils.ClockMs(reportDay, 8, 0, 0), historianutils.ClockMs(reportDay, 8, 0, 0)),
+	}, "\n")
+
+	wantUpdates := []Update{
+		{
+			StartMs:  historianutils.ClockMs(reportDay, 4, 5, 0),
+			EndMs:    historianutils.ClockMs(reportDay, 4, 40, 0),
+			Sources:  []string{"Package install", "update_engine", "update_verifier"},
+			Packages: []string{"com.android.phone"},
+			Count:    3,
+		},
+		{
+			StartMs:  historianutils.ClockMs(reportDay, 8, 0, 0),
+			EndMs:    historianutils.ClockMs(reportDay, 8, 0, 0),
+			Sources:  []string{"Package install"},
+			Packages: []string{"com.android.chrome"},
+			Count:    1,
+		},
+	}
+	updates, errs := Updates(events, history)
+	if len(errs) > 0 {
+		t.Fatalf("Updates generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(updates, wantUpdates) {
+		t.Errorf("Updates(%v, %v)\n got: %v\n want: %v", events, history, updates, wantUpdates)
+	}
+
+	wantCSV := strings.Join([]string{
+		fmt.Sprintf(`System update,string,%d,%d,"Package install, update_engine, update_verifier: com.android.phone",`, historianutils.ClockMs(reportDay, 4, 5, 0), historianutils.ClockMs(reportDay, 4, 40, 0)),
+		fmt.Sprintf(`System update,string,%d,%d,Package install: com.android.chrome,`, historianutils.ClockMs(reportDay, 8, 0, 0), historianutils.ClockMs(reportDay, 8, 0, 0)),
+		"",
+	}, "\n")
+	if got := CSV(updates); got != wantCSV {
+		t.Errorf("CSV(%v)\n got: %q\n want: %q", updates, got, wantCSV)
+	}
+
+	c, errs := Compare(updates[:1], history)
+	if len(errs) > 0 {
+		t.Fatalf("Compare generated unexpected errors: %v", errs)
+	}
+	want := &Comparison{
+		// Plugged in from 3:00, so the drop at 4:00 isn't counted.
+		Before: parseutils.Drain{Duration: 3 * time.Hour, LevelDrop: 2},
+		After:  parseutils.Drain{Duration: 4*time.Hour + 20*time.Minute, LevelDrop: 8},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("Compare(%v, %v)\n got: %+v\n want: %+v", updates[:1], history, c, want)
+	}
+	if got, want := c.Change(), 176.9; math.Abs(got-want) > 0.1 {
+		t.Errorf("Change() = %.1f, want %.1f", got, want)
+	}
+	// The drain after the updates is measured from the end of the last one.
+	want = &Comparison{
+		Before: parseutils.Drain{Duration: 3 * time.Hour, LevelDrop: 2},
+		After:  parseutils.Drain{Duration: time.Hour},
+	}
+	if c, _ := Compare(updates, history); !reflect.DeepEqual(c, want) {
+		t.Errorf("Compare(%v, %v)\n got: %+v\n want: %+v", updates, history, c, want)
+	}
+	// Not enough unplugged time before the update.
+	if c, _ := Compare([]Update{{StartMs: historianutils.ClockMs(reportDay, 0, 20, 0), EndMs: historianutils.ClockMs(reportDay, 0, 20, 0)}}, history); c != nil {
+		t.Errorf("Compare() for an update at 0:20 = %+v, want nil", c)
+	}
+}
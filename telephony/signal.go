@@ -0,0 +1,225 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telephony
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// SignalStrengthMetric is the battery history CSV metric of the mobile signal strength.
+	SignalStrengthMetric = "Mobile signal strength"
+
+	// registryService is the name of the telephony registry service dump.
+	registryService = "telephony.registry"
+)
+
+var (
+	// signalLevels are the names of the signal strength levels in the battery history CSV, by level.
+	signalLevels = []string{"none", "poor", "moderate", "good", "great"}
+
+	// registryLogRE matches a signal strength notification in the local logs of the telephony registry
+	// dump. The year is only logged by some releases.
+	// e.g. "2017-02-15T03:14:07.123 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: ..."
+	registryLogRE = regexp.MustCompile(`^\s*((?P<year>\d{4})-)?(?P<month>\d{2})-(?P<day>\d{2})[T ](?P<time>\d{2}:\d{2}:\d{2})[.](?P<remainder>\d+)\s+-\s+notifySignalStrength\w*:.*\bss=SignalStrength:\s*(?P<ss>.*)$`)
+
+	// levelRE matches the level of a signal strength, logged by newer releases for each radio technology.
+	// e.g. "{mCdma=Invalid,...,mLte=CellSignalStrengthLte: rssi=-65 rsrp=-95 ... level=3 ...,mNr=Invalid,...}"
+	levelRE = regexp.MustCompile(`\blevel=(?P<level>\d)\b`)
+)
+
+// SignalSample is a mobile signal strength level logged by the telephony registry.
+type SignalSample struct {
+	TimeMs int64
+	// Level is the signal strength level as in the battery history CSV, e.g. "good".
+	Level string
+}
+
+// bySampleTime sorts samples in ascending order of time.
+type bySampleTime []SignalSample
+
+func (a bySampleTime) Len() int           { return len(a) }
+func (a bySampleTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySampleTime) Less(i, j int) bool { return a[i].TimeMs < a[j].TimeMs }
+
+// legacyLevel returns the level of a signal strength logged by older releases as a list of numbers,
+// from the LTE RSRP if valid, else from the GSM signal strength in ASU, with the thresholds of
+// android.telephony.SignalStrength. ok is false if neither is valid.
+// e.g. "99 0 -120 -160 -120 -1 -1 24 -95 -11 92 2147483647 2147483647 0x4 gsm|lte"
+func legacyLevel(ss string) (level int, ok bool) {
+	fields := strings.Fields(ss)
+	if len(fields) > 8 {
+		if rsrp, err := strconv.Atoi(fields[8]); err == nil && rsrp >= -140 && rsrp <= -44 {
+			switch {
+			case rsrp >= -85:
+				return 4, true
+			case rsrp >= -95:
+				return 3, true
+			case rsrp >= -105:
+				return 2, true
+			case rsrp >= -115:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if len(fields) > 0 {
+		if asu, err := strconv.Atoi(fields[0]); err == nil && asu != 99 {
+			switch {
+			case asu <= 2:
+				return 0, true
+			case asu >= 12:
+				return 4, true
+			case asu >= 8:
+				return 3, true
+			case asu >= 5:
+				return 2, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// extractRegistryDump returns the lines of the telephony registry service dump in the bug report.
+func extractRegistryDump(input string) []string {
+	in := false
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if bugreportutils.BugReportSectionRE.MatchString(line) {
+			if in {
+				break
+			}
+			continue
+		}
+		if m, result := historianutils.SubexpNames(historianutils.ServiceDumpRE, line); m {
+			if in {
+				break
+			}
+			in = result["service"] == registryService
+			continue
+		}
+		if in {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+	return lines
+}
+
+// ParseSignalStrengths returns the mobile signal strength levels logged in the telephony registry
+// dump of the bug report, taken at the given time, sorted by time. Repeated levels are only returned
+// once. The level of each radio technology is logged by newer releases, and the best one is used.
+func ParseSignalStrengths(bugreport string, taken time.Time) ([]SignalSample, []error) {
+	var errs []error
+	var samples []SignalSample
+	for _, l := range extractRegistryDump(bugreport) {
+		m, result := historianutils.SubexpNames(registryLogRE, l)
+		if !m {
+			continue
+		}
+		level := -1
+		for _, lm := range levelRE.FindAllStringSubmatch(result["ss"], -1) {
+			if n, err := strconv.Atoi(lm[1]); err == nil && n > level && n < len(signalLevels) {
+				level = n
+			}
+		}
+		if level < 0 {
+			n, ok := legacyLevel(result["ss"])
+			if !ok {
+				continue
+			}
+			level = n
+		}
+		var ms int64
+		var err error
+		if y := result["year"]; y != "" {
+			ms, err = bugreportutils.TimeStampToMs(fmt.Sprintf("%s-%s-%s %s", y, result["month"], result["day"], result["time"]), result["remainder"], taken.Location())
+		} else {
+			ms, err = bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid signal strength timestamp %q: %v", l, err))
+			continue
+		}
+		samples = append(samples, SignalSample{TimeMs: ms, Level: signalLevels[level]})
+	}
+	// The local logs are usually in time order, but aren't guaranteed to be.
+	sort.Stable(bySampleTime(samples))
+
+	var res []SignalSample
+	for _, s := range samples {
+		if n := len(res); n > 0 && res[n-1].Level == s.Level {
+			continue
+		}
+		res = append(res, s)
+	}
+	return res, errs
+}
+
+// DensifySignalStrength returns the battery history CSV generated by AnalyzeHistory with the mobile
+// signal strength levels of the telephony registry merged into the Mobile signal strength series.
+// Each history event is split at the samples within it that change the level, so the series stays
+// without overlaps or repeated levels, and samples outside of the history series are dropped. The
+// CSV is returned unchanged if no sample changes the series.
+func DensifySignalStrength(historyCSV string, samples []SignalSample) (string, []error) {
+	if len(samples) == 0 {
+		return historyCSV, nil
+	}
+	es, errs := csv.ExtractEvents(historyCSV, []string{SignalStrengthMetric})
+	history := es[SignalStrengthMetric]
+	csv.SortByStart(history)
+
+	var series []csv.Event
+	split := false
+	for _, e := range history {
+		cur := e
+		for _, s := range samples {
+			if s.TimeMs <= cur.Start || s.TimeMs >= e.End || s.Level == cur.Value {
+				continue
+			}
+			prev := cur
+			prev.End = s.TimeMs
+			series = append(series, prev)
+			cur.Start, cur.Value = s.TimeMs, s.Level
+			split = true
+		}
+		series = append(series, cur)
+	}
+	if !split {
+		return historyCSV, errs
+	}
+
+	out, mErrs := csv.MapEvents(historyCSV, func(metric string, e csv.Event) (csv.Event, bool) {
+		return e, metric != SignalStrengthMetric
+	})
+	errs = append(errs, mErrs...)
+	var b bytes.Buffer
+	b.WriteString(out)
+	csvState := csv.NewState(&b, false)
+	for _, e := range series {
+		csvState.PrintEvent(SignalStrengthMetric, e)
+	}
+	return b.String(), errs
+}
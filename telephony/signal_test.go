@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telephony
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+// TestParseSignalStrengths tests the parsing of the signal strengths of the telephony registry dump.
+func TestParseSignalStrengths(t *testing.T) {
+	taken := time.Date(2017, time.February, 16, 8, 0, 0, 0, time.UTC)
+	input := strings.Join([]string{
+		`------ DUMPSYS (dumpsys) ------`,
+		`DUMP OF SERVICE telephony.registry:`,
+		`last known state:`,
+		`  mSignalStrength=SignalStrength: 99 0 -120 -160 -120 -1 -1 24 -95 -11 92 2147483647 2147483647 0x4 gsm|lte`,
+		`local logs:`,
+		`  2017-02-15T22:00:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: 99 0 -120 -160 -120 -1 -1 24 -95 -11 92 2147483647 2147483647 0x4 gsm|lte`,
+		// GSM only.
+		`  2017-02-15T22:05:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: 6 0 -120 -160 -120 -1 -1 99 2147483647 2147483647 2147483647 2147483647 2147483647 0x4 gsm|lte`,
+		// The same level.
+		`  2017-02-15T22:07:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: 7 0 -120 -160 -120 -1 -1 99 2147483647 2147483647 2147483647 2147483647 2147483647 0x4 gsm|lte`,
+		// Newer releases, without the year.
+		`  02-15 22:10:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength:{mCdma=Invalid,mGsm=Invalid,mWcdma=Invalid,mTdscdma=Invalid,mLte=CellSignalStrengthLte: rssi=-65 rsrp=-80 rsrq=-11 rssnr=2147483647 cqi=2147483647 ta=2147483647 level=4 parametersUseForLevel=0,mNr=Invalid,primary=CellSignalStrengthLte}`,
+		// No valid signal strength.
+		`  2017-02-15T22:15:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: 99 0 -120 -160 -120 -1 -1 99 2147483647 2147483647 2147483647 2147483647 2147483647 0x4 gsm|lte`,
+		`  2017-02-15T22:20:00.000 - notifyServiceStateForPhoneId: subId=1 phoneId=0 state=0 0 home`,
+		`DUMP OF SERVICE wifi:`,
+		`  2017-02-15T22:25:00.000 - notifySignalStrengthForPhoneId: subId=1 phoneId=0 ss=SignalStrength: 2 0 -120 -160 -120 -1 -1 99 2147483647 2147483647 2147483647 2147483647 2147483647 0x4 gsm|lte`,
+	}, "\n")
+	want := []SignalSample{
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 0, 0), Level: "good"},
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 5, 0), Level: "moderate"},
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 10, 0), Level: "great"},
+	}
+	got, errs := ParseSignalStrengths(input, taken)
+	if len(errs) > 0 {
+		t.Fatalf("ParseSignalStrengths(%s) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSignalStrengths(%s)\n got: %+v\n want: %+v", input, got, want)
+	}
+}
+
+// TestDensifySignalStrength tests the merging of the registry signal strengths into the history series.
+func TestDensifySignalStrength(t *testing.T) {
+	history := strings.Join([]string{
+		csv.FileHeader,
+		`Mobile signal strength,string,` + fmt.Sprint(historianutils.ClockMs(reportDay, 22, 0, 0)) + `,` + fmt.Sprint(historianutils.ClockMs(reportDay, 22, 30, 0)) + `,moderate,`,
+		`Screen,bool,` + fmt.Sprint(historianutils.ClockMs(reportDay, 22, 0, 0)) + `,` + fmt.Sprint(historianutils.ClockMs(reportDay, 22, 10, 0)) + `,true,`,
+		`Mobile signal strength,string,` + fmt.Sprint(historianutils.ClockMs(reportDay, 22, 30, 0)) + `,` + fmt.Sprint(historianutils.ClockMs(reportDay, 23, 0, 0)) + `,good,`,
+	}, "\n")
+	samples := []SignalSample{
+		// Before the series.
+		{TimeMs: historianutils.ClockMs(reportDay, 21, 50, 0), Level: "poor"},
+		// At the start of a history event.
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 0, 0), Level: "great"},
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 10, 0), Level: "poor"},
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 20, 0), Level: "moderate"},
+		// The same level as the history event.
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 40, 0), Level: "good"},
+		{TimeMs: historianutils.ClockMs(reportDay, 22, 50, 0), Level: "none"},
+	}
+	got, errs := DensifySignalStrength(history, samples)
+	if len(errs) > 0 {
+		t.Fatalf("DensifySignalStrength generated unexpected errors: %v", errs)
+	}
+	es, errs := csv.ExtractEvents(got, []string{SignalStrengthMetric, "Screen"})
+	if len(errs) > 0 {
+		t.Fatalf("ExtractEvents(%s) generated unexpected errors: %v", got, errs)
+	}
+	want := []csv.Event{
+		{Type: "string", Start: historianutils.ClockMs(reportDay, 22, 0, 0), End: historianutils.ClockMs(reportDay, 22, 10, 0), Value: "moderate"},
+		{Type: "string", Start: historianutils.ClockMs(reportDay, 22, 10, 0), End: historianutils.ClockMs(reportDay, 22, 20, 0), Value: "poor"},
+		{Type: "string", Start: historianutils.ClockMs(reportDay, 22, 20, 0), End: historianutils.ClockMs(reportDay, 22, 30, 0), Value: "moderate"},
+		{Type: "string", Start: historianutils.ClockMs(reportDay, 22, 30, 0), End: historianutils.ClockMs(reportDay, 22, 50, 0), Value: "good"},
+		{Type: "string", Start: historianutils.ClockMs(reportDay, 22, 50, 0), End: historianutils.ClockMs(reportDay, 23, 0, 0), Value: "none"},
+	}
+	if !reflect.DeepEqual(es[SignalStrengthMetric], want) {
+		t.Errorf("DensifySignalStrength(%s)\n got: %+v\n want: %+v", history, es[SignalStrengthMetric], want)
+	}
+	if len(es["Screen"]) != 1 {
+		t.Errorf("DensifySignalStrength(%s) got Screen events %v, want them kept", history, es["Screen"])
+	}
+
+	if got, _ := DensifySignalStrength(history, samples[:2]); got != history {
+		t.Errorf("DensifySignalStrength(%s) without a level change = %s, want the CSV unchanged", history, got)
+	}
+}
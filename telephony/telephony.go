@@ -0,0 +1,256 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telephony detects the airplane mode and SIM state changes of a bug report, from the
+// telephony logs and the phone state of the battery history, and compares the battery drain with
+// airplane mode on and off, to tell whether bad coverage or a flaky SIM is behind the drain. It also
+// merges the signal strengths logged by the telephony registry into the sparse signal strength
+// series of the battery history.
+package telephony
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// CSV descriptions of the airplane mode and SIM state timelines.
+	AirplaneModeMetric = "Airplane mode"
+	SIMStateMetric     = "SIM state"
+
+	// Battery history CSV metrics used for the airplane mode fallback and the drain comparison.
+	batteryLevel = "Battery Level"
+	phoneState   = "Phone state"
+	plugged      = "Plugged"
+
+	// radioOff is the phone state of the cellular radio being powered off, as in airplane mode.
+	radioOff = "off"
+)
+
+var (
+	// airplaneModeRE matches a message logging the airplane mode being turned on or off.
+	// e.g. "Airplane mode changed to true" or "setAirplaneMode: enabled"
+	airplaneModeRE = regexp.MustCompile(`(?i)airplane[ _]?mode(_on)?\W+(changed\W+)?(to\W+)?(?P<state>true|false|on|off|enabled|disabled)\b`)
+
+	// simStateRE matches a message logging a SIM state change.
+	// e.g. "Broadcasting intent ACTION_SIM_STATE_CHANGED ABSENT reason null" or "mExternalState=READY"
+	simStateRE = regexp.MustCompile(`(SIM_STATE_CHANGED|(?i:sim ?state)|mExternalState)\W+((?i:changed)\W+)?((?i:to)\W+)?(?P<state>ABSENT|NOT_READY|READY|PIN_REQUIRED|PUK_REQUIRED|NETWORK_LOCKED|PERM_DISABLED|CARD_IO_ERROR|CARD_RESTRICTED|LOADED|IMSI|LOCKED|UNKNOWN)\b`)
+)
+
+// Change is an airplane mode or SIM state change.
+type Change struct {
+	// Metric is AirplaneModeMetric or SIMStateMetric.
+	Metric string
+	TimeMs int64
+	// Value is "true" or "false" for airplane mode, and the new state for the SIM, e.g. "ABSENT".
+	Value string
+}
+
+// byTime sorts changes in ascending order of time.
+type byTime []Change
+
+func (a byTime) Len() int           { return len(a) }
+func (a byTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTime) Less(i, j int) bool { return a[i].TimeMs < a[j].TimeMs }
+
+// Parse returns the airplane mode and SIM state changes logged in the bug report, taken at the given
+// time, sorted by time. Repeated logs of the same state, e.g. by different telephony components, are
+// only returned once.
+func Parse(bugreport string, taken time.Time) ([]Change, []error) {
+	var errs []error
+	var changes []Change
+	for _, line := range strings.Split(bugreport, "\n") {
+		m, result := historianutils.SubexpNames(bugreportutils.LogcatLineRE, strings.TrimRight(line, "\r"))
+		if !m {
+			continue
+		}
+		var c Change
+		if m, r := historianutils.SubexpNames(airplaneModeRE, result["msg"]); m {
+			c.Metric = AirplaneModeMetric
+			switch strings.ToLower(r["state"]) {
+			case "true", "on", "enabled":
+				c.Value = "true"
+			default:
+				c.Value = "false"
+			}
+		} else if m, r := historianutils.SubexpNames(simStateRE, result["msg"]); m {
+			c.Metric, c.Value = SIMStateMetric, r["state"]
+		} else {
+			continue
+		}
+		ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s timestamp %q: %v", result["tag"], line, err))
+			continue
+		}
+		c.TimeMs = ms
+		changes = append(changes, c)
+	}
+	// The logcat sections aren't necessarily in time order, e.g. the last logcat before a reboot.
+	sort.Stable(byTime(changes))
+
+	var res []Change
+	last := make(map[string]string)
+	for _, c := range changes {
+		if last[c.Metric] == c.Value {
+			continue
+		}
+		last[c.Metric] = c.Value
+		res = append(res, c)
+	}
+	return res, errs
+}
+
+// periods returns the changes of the metric as events lasting until the next change, or endMs.
+func periods(changes []Change, metric string, endMs int64) []csv.Event {
+	var res []csv.Event
+	for _, c := range changes {
+		if c.Metric != metric {
+			continue
+		}
+		if n := len(res); n > 0 {
+			res[n-1].End = c.TimeMs
+		}
+		res = append(res, csv.Event{Start: c.TimeMs, End: endMs, Value: c.Value})
+	}
+	return res
+}
+
+// StateTotal is the time spent in a SIM state.
+type StateTotal struct {
+	State string
+	// Count is the number of times the SIM changed to the state.
+	Count    int
+	Duration time.Duration
+}
+
+// byDuration sorts state totals in descending order of duration, then ascending order of state.
+type byDuration []StateTotal
+
+func (a byDuration) Len() int      { return len(a) }
+func (a byDuration) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDuration) Less(i, j int) bool {
+	if a[i].Duration != a[j].Duration {
+		return a[i].Duration > a[j].Duration
+	}
+	return a[i].State < a[j].State
+}
+
+// Summary is the airplane mode and SIM state timelines of a report, with the drain with airplane mode on and off.
+type Summary struct {
+	// AirplaneMode are the periods airplane mode was on.
+	AirplaneMode []csv.Event
+	// AirplaneModeFromHistory is whether airplane mode was taken from the cellular radio being powered
+	// off in the battery history, as no airplane mode change was logged.
+	AirplaneModeFromHistory bool
+	// AirplaneModeOn and AirplaneModeOff are the drain while unplugged with airplane mode on and off.
+	AirplaneModeOn, AirplaneModeOff parseutils.Drain
+	// SIMStates are the periods the SIM was in each state, and SIMStateTotals their totals per state.
+	SIMStates      []csv.Event
+	SIMStateTotals []StateTotal
+}
+
+// SIMStateChanges returns the number of SIM state changes after the first logged state.
+func (s *Summary) SIMStateChanges() int {
+	if len(s.SIMStates) == 0 {
+		return 0
+	}
+	return len(s.SIMStates) - 1
+}
+
+// Summarize returns the airplane mode and SIM state timelines from the changes, lasting until endMs,
+// and the drain with airplane mode on and off from the battery history CSV generated by
+// AnalyzeHistory. If no airplane mode change was logged, airplane mode is taken to be on while
+// the cellular radio was powered off. It returns nil if airplane mode was never on and no SIM
+// state was logged.
+func Summarize(changes []Change, historyCSV string, endMs int64) (*Summary, []error) {
+	es, errs := csv.ExtractEvents(historyCSV, []string{batteryLevel, phoneState, plugged})
+	s := &Summary{SIMStates: periods(changes, SIMStateMetric, endMs)}
+	for _, e := range periods(changes, AirplaneModeMetric, endMs) {
+		if e.Value == "true" {
+			s.AirplaneMode = append(s.AirplaneMode, e)
+		}
+	}
+	if len(s.AirplaneMode) == 0 {
+		for _, e := range es[phoneState] {
+			if e.Value == radioOff {
+				s.AirplaneMode = append(s.AirplaneMode, e)
+				s.AirplaneModeFromHistory = true
+			}
+		}
+		s.AirplaneMode = csv.MergeEvents(s.AirplaneMode)
+	}
+	if len(s.AirplaneMode) == 0 && len(s.SIMStates) == 0 {
+		return nil, errs
+	}
+
+	totals := make(map[string]*StateTotal)
+	for _, e := range s.SIMStates {
+		t, ok := totals[e.Value]
+		if !ok {
+			t = &StateTotal{State: e.Value}
+			totals[e.Value] = t
+		}
+		t.Count++
+		t.Duration += time.Duration(e.End-e.Start) * time.Millisecond
+	}
+	for _, t := range totals {
+		s.SIMStateTotals = append(s.SIMStateTotals, *t)
+	}
+	sort.Sort(byDuration(s.SIMStateTotals))
+
+	levels := es[batteryLevel]
+	csv.SortByStart(levels)
+	var pluggedIn []csv.Event
+	for _, e := range es[plugged] {
+		if e.Value == "true" {
+			pluggedIn = append(pluggedIn, e)
+		}
+	}
+	if len(levels) == 0 {
+		return s, errs
+	}
+	all := parseutils.UnpluggedDrain(levels, pluggedIn, []csv.Event{{Start: levels[0].Start, End: levels[len(levels)-1].End}})
+	s.AirplaneModeOn = parseutils.UnpluggedDrain(levels, pluggedIn, s.AirplaneMode)
+	s.AirplaneModeOff = parseutils.Drain{
+		Duration:  all.Duration - s.AirplaneModeOn.Duration,
+		LevelDrop: all.LevelDrop - s.AirplaneModeOn.LevelDrop,
+	}
+	return s, errs
+}
+
+// CSV returns the airplane mode and SIM state periods as CSV events, so they can be seen on the timeline.
+func CSV(s *Summary) string {
+	if s == nil {
+		return ""
+	}
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, e := range s.AirplaneMode {
+		csvState.Print(AirplaneModeMetric, "bool", e.Start, e.End, "true", "")
+	}
+	for _, e := range s.SIMStates {
+		csvState.Print(SIMStateMetric, "string", e.Start, e.End, e.Value, "")
+	}
+	return b.String()
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telephony
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.February, 15, 0, 0, 0, 0, time.UTC)
+
+// TestParse tests the detection of the airplane mode and SIM state changes in the logcat.
+func TestParse(t *testing.T) {
+	taken := time.Date(2017, time.February, 16, 8, 0, 0, 0, time.UTC)
+	input := strings.Join([]string{
+		`02-15 22:00:00.000  1234  1250 D IccCardProxy: Broadcasting intent ACTION_SIM_STATE_CHANGED READY reason null`,
+		// Repeated by another component.
+		`02-15 22:00:00.100  1234  1250 D UiccCard: mExternalState=READY`,
+		`02-15 22:10:00.000  1234  1250 D ConnectivityService: Airplane mode changed to true`,
+		`02-15 22:20:00.000  1234  1250 D IccCardProxy: SIM state changed to ABSENT`,
+		`02-15 22:30:00.000  1234  1250 I AirplaneModeEnabler: setAirplaneMode: disabled`,
+		`02-15 22:31:00.000  1234  1250 D IccCardProxy: Broadcasting intent ACTION_SIM_STATE_CHANGED READY reason null`,
+		// Neither airplane mode nor SIM state.
+		`02-15 22:32:00.000  1234  1250 I ActivityManager: Start proc 4321:com.example.app/u0a123`,
+	}, "\n")
+	want := []Change{
+		{Metric: SIMStateMetric, TimeMs: historianutils.ClockMs(reportDay, 22, 0, 0), Value: "READY"},
+		{Metric: AirplaneModeMetric, TimeMs: historianutils.ClockMs(reportDay, 22, 10, 0), Value: "true"},
+		{Metric: SIMStateMetric, TimeMs: historianutils.ClockMs(reportDay, 22, 20, 0), Value: "ABSENT"},
+		{Metric: AirplaneModeMetric, TimeMs: historianutils.ClockMs(reportDay, 22, 30, 0), Value: "false"},
+		{Metric: SIMStateMetric, TimeMs: historianutils.ClockMs(reportDay, 22, 31, 0), Value: "READY"},
+	}
+	got, errs := Parse(input, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse(%s) generated unexpected errors: %v", input, errs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse(%s)\n got: %+v\n want: %+v", input, got, want)
+	}
+}
+
+// TestSummarize tests the airplane mode and SIM state timelines, and the drain comparison.
+func TestSummarize(t *testing.T) {
+	const hourMs = 3600 * 1000
+	history := strings.Join([]string{
+		csv.FileHeader,
+		`Battery Level,int,0,3600000,90,`,
+		`Battery Level,int,3600000,7200000,88,`,
+		`Battery Level,int,7200000,10800000,87,`,
+		`Battery Level,int,10800000,14400000,86,`,
+		`Plugged,bool,10800000,14400000,true,`,
+		`Phone state,string,0,3600000,in,`,
+		`Phone state,string,3600000,7200000,off,`,
+		`Phone state,string,7200000,14400000,in,`,
+	}, "\n")
+
+	tests := []struct {
+		desc    string
+		changes []Change
+		want    *Summary
+		wantCSV string
+	}{
+		{
+			desc: "Airplane mode from the radio power",
+			want: &Summary{
+				AirplaneMode:            []csv.Event{{Type: "string", Start: hourMs, End: 2 * hourMs, Value: "off"}},
+				AirplaneModeFromHistory: true,
+				// The drop by 2h is counted while in airplane mode, and the one by 3h while unplugged.
+				AirplaneModeOn:  parseutils.Drain{Duration: time.Hour, LevelDrop: 1},
+				AirplaneModeOff: parseutils.Drain{Duration: 2 * time.Hour, LevelDrop: 3},
+			},
+			wantCSV: "Airplane mode,bool,3600000,7200000,true,\n",
+		},
+		{
+			desc: "Logged airplane mode and SIM states",
+			changes: []Change{
+				{Metric: SIMStateMetric, TimeMs: 0, Value: "READY"},
+				{Metric: AirplaneModeMetric, TimeMs: 1800000, Value: "true"},
+				{Metric: SIMStateMetric, TimeMs: hourMs, Value: "ABSENT"},
+				{Metric: AirplaneModeMetric, TimeMs: 5400000, Value: "false"},
+				{Metric: SIMStateMetric, TimeMs: 2 * hourMs, Value: "READY"},
+			},
+			want: &Summary{
+				AirplaneMode:    []csv.Event{{Start: 1800000, End: 5400000, Value: "true"}},
+				AirplaneModeOn:  parseutils.Drain{Duration: time.Hour, LevelDrop: 2},
+				AirplaneModeOff: parseutils.Drain{Duration: 2 * time.Hour, LevelDrop: 2},
+				SIMStates: []csv.Event{
+					{Start: 0, End: hourMs, Value: "READY"},
+					{Start: hourMs, End: 2 * hourMs, Value: "ABSENT"},
+					{Start: 2 * hourMs, End: 4 * hourMs, Value: "READY"},
+				},
+				SIMStateTotals: []StateTotal{
+					{State: "READY", Count: 2, Duration: 3 * time.Hour},
+					{State: "ABSENT", Count: 1, Duration: time.Hour},
+				},
+			},
+			wantCSV: strings.Join([]string{
+				"Airplane mode,bool,1800000,5400000,true,",
+				"SIM state,string,0,3600000,READY,",
+				"SIM state,string,3600000,7200000,ABSENT,",
+				"SIM state,string,7200000,14400000,READY,",
+			}, "\n") + "\n",
+		},
+	}
+	for _, test := range tests {
+		got, errs := Summarize(test.changes, history, 4*hourMs)
+		if len(errs) > 0 {
+			t.Errorf("%s: Summarize generated unexpected errors: %v", test.desc, errs)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Summarize(%v, %s)\n got: %+v\n want: %+v", test.desc, test.changes, history, got, test.want)
+		}
+		if c := CSV(got); c != test.wantCSV {
+			t.Errorf("%s: CSV(%+v)\n got: %q\n want: %q", test.desc, got, c, test.wantCSV)
+		}
+	}
+
+	if got, _ := Summarize(nil, strings.Replace(history, ",off,", ",in,", 1), 4*hourMs); got != nil {
+		t.Errorf("Summarize without airplane mode or SIM states = %+v, want nil", got)
+	}
+}
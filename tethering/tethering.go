@@ -0,0 +1,160 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tethering finds the Wi-Fi hotspot, Bluetooth and USB tethering sessions of a device, from
+// the interfaces tethered and untethered in the Tethering logcat lines of a bug report. Tethering
+// isn't logged in the battery history, but sharing the mobile connection keeps the radios busy.
+package tethering
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/csv"
+	"github.com/google/battery-historian/historianutils"
+)
+
+const (
+	// Metric is the CSV description of tethering sessions.
+	Metric = "Tethering"
+
+	// Session types, by tethered interface.
+	WifiHotspot = "Wi-Fi hotspot"
+	Bluetooth   = "Bluetooth"
+	USB         = "USB"
+	Other       = "Other"
+)
+
+// logRE matches a Tethering logcat line.
+// e.g. "02-15 03:12:45.123  1234  1250 D Tethering: Tethering wlan0"
+var logRE = bugreportutils.LogcatTagRE(`Tethering`)
+
+// transitionRE matches a Tethering message tethering or untethering an interface.
+var transitionRE = regexp.MustCompile(`^(?P<transition>Tethering|Untethering) (?P<iface>\S+)`)
+
+// Session is an interface being tethered over a time range.
+type Session struct {
+	// Type is the type of tethering, e.g. WifiHotspot.
+	Type      string
+	Interface string
+	// StartMs and EndMs are the time range the interface was tethered, in unix time ms.
+	StartMs, EndMs int64
+}
+
+// byStart sorts sessions in ascending order of start time, then by interface.
+type byStart []Session
+
+func (a byStart) Len() int      { return len(a) }
+func (a byStart) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool {
+	if a[i].StartMs != a[j].StartMs {
+		return a[i].StartMs < a[j].StartMs
+	}
+	return a[i].Interface < a[j].Interface
+}
+
+// interfaceType returns the type of tethering done over the interface.
+func interfaceType(iface string) string {
+	switch {
+	case strings.HasPrefix(iface, "wlan"), strings.HasPrefix(iface, "swlan"), strings.HasPrefix(iface, "softap"), strings.HasPrefix(iface, "ap"):
+		return WifiHotspot
+	case strings.HasPrefix(iface, "bt-pan"):
+		return Bluetooth
+	case strings.HasPrefix(iface, "rndis"), strings.HasPrefix(iface, "usb"), strings.HasPrefix(iface, "ncm"):
+		return USB
+	}
+	return Other
+}
+
+// transition is an interface tethered or untethered at a time.
+type transition struct {
+	ms        int64
+	iface     string
+	tethering bool
+}
+
+// Parse replays the tethering logcat lines of the bug report, taken at the given time, and returns the
+// tethering sessions sorted by start time. The logcat sections aren't in time order, so the lines are
+// replayed in time order. The logcat is a ring buffer, so an interface whose first line untethers it
+// is assumed to be tethered since the first tethering line. An interface still tethered when the bug
+// report was taken is tethered until then.
+func Parse(bugreport string, taken time.Time) ([]Session, []error) {
+	var errs []error
+	var transitions []transition
+	for _, line := range strings.Split(bugreport, "\n") {
+		m, result := historianutils.SubexpNames(logRE, strings.TrimRight(line, "\r"))
+		if !m {
+			continue
+		}
+		m, t := historianutils.SubexpNames(transitionRE, result["msg"])
+		if !m {
+			continue
+		}
+		ms, err := bugreportutils.LogTimeStampToMs(result["month"], result["day"], result["time"], result["remainder"], taken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid tethering timestamp %q: %v", line, err))
+			continue
+		}
+		transitions = append(transitions, transition{ms: ms, iface: t["iface"], tethering: t["transition"] == "Tethering"})
+	}
+	// Lines logged at the same time are kept in file order.
+	sort.SliceStable(transitions, func(i, j int) bool { return transitions[i].ms < transitions[j].ms })
+
+	var sessions []Session
+	// active maps the tethered interfaces to their current session.
+	active := make(map[string]*Session)
+	seen := make(map[string]bool)
+	for _, t := range transitions {
+		if t.tethering {
+			if active[t.iface] == nil {
+				active[t.iface] = &Session{Type: interfaceType(t.iface), Interface: t.iface, StartMs: t.ms}
+			}
+		} else {
+			s := active[t.iface]
+			if s == nil {
+				if seen[t.iface] {
+					// Already untethered, e.g. untether was requested twice.
+					continue
+				}
+				s = &Session{Type: interfaceType(t.iface), Interface: t.iface, StartMs: transitions[0].ms}
+			}
+			s.EndMs = t.ms
+			sessions = append(sessions, *s)
+			delete(active, t.iface)
+		}
+		seen[t.iface] = true
+	}
+	takenMs := taken.UnixNano() / int64(time.Millisecond)
+	for _, s := range active {
+		s.EndMs = takenMs
+		sessions = append(sessions, *s)
+	}
+	sort.Sort(byStart(sessions))
+	return sessions, errs
+}
+
+// CSV returns the sessions as Metric CSV events, so they can be seen on the timeline.
+func CSV(sessions []Session) string {
+	var b bytes.Buffer
+	csvState := csv.NewState(&b, false)
+	for _, s := range sessions {
+		csvState.Print(Metric, "string", s.StartMs, s.EndMs, fmt.Sprintf("%s (%s)", s.Type, s.Interface), "")
+	}
+	return b.String()
+}
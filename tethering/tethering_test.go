@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tethering
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/historianutils"
+)
+
+// reportDay is the day of the test bug report.
+var reportDay = time.Date(2017, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// TestParse tests replaying the tethered and untethered interfaces.
+func TestParse(t *testing.T) {
+	br := strings.Join([]string{
+		"------ SYSTEM LOG (logcat -v threadtime -v printable -d *:v) ------",
+		// Tethered before the start of the log.
+		"10-16 09:00:00.000  1000  1234  1250 D Tethering: Untethering bt-pan",
+		"10-16 09:10:00.000  1234  1250 D Tethering: Tethering wlan0",
+		// Already tethered.
+		"10-16 09:12:00.000  1234  1250 D Tethering: Tethering wlan0",
+		"10-16 09:12:30.000  1234  1250 D Tethering: TetherMasterSM state changed",
+		"10-16 09:40:00.000  1234  1250 D Tethering: Untethering wlan0",
+		// Already untethered.
+		"10-16 09:40:01.000  1234  1250 D Tethering: Untethering wlan0",
+		"10-16 10:00:00.000  1234  1250 D Tethering: Tethering rndis0",
+	}, "\n")
+	taken := time.Date(2017, time.October, 16, 11, 0, 0, 0, time.UTC)
+
+	want := []Session{
+		{Type: Bluetooth, Interface: "bt-pan", StartMs: historianutils.ClockMs(reportDay, 9, 0, 0), EndMs: historianutils.ClockMs(reportDay, 9, 0, 0)},
+		{Type: WifiHotspot, Interface: "wlan0", StartMs: historianutils.ClockMs(reportDay, 9, 10, 0), EndMs: historianutils.ClockMs(reportDay, 9, 40, 0)},
+		// Still tethered when the bug report was taken.
+		{Type: USB, Interface: "rndis0", StartMs: historianutils.ClockMs(reportDay, 10, 0, 0), EndMs: historianutils.ClockMs(reportDay, 11, 0, 0)},
+	}
+	sessions, errs := Parse(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", br, sessions, want)
+	}
+
+	wantCSV := strings.Join([]string{
+		fmt.Sprintf("Tethering,string,%d,%d,Bluetooth (bt-pan),", historianutils.ClockMs(reportDay, 9, 0, 0), historianutils.ClockMs(reportDay, 9, 0, 0)),
+		fmt.Sprintf("Tethering,string,%d,%d,Wi-Fi hotspot (wlan0),", historianutils.ClockMs(reportDay, 9, 10, 0), historianutils.ClockMs(reportDay, 9, 40, 0)),
+		fmt.Sprintf("Tethering,string,%d,%d,USB (rndis0),", historianutils.ClockMs(reportDay, 10, 0, 0), historianutils.ClockMs(reportDay, 11, 0, 0)),
+		"",
+	}, "\n")
+	if got := CSV(sessions); got != wantCSV {
+		t.Errorf("CSV(%v)\n got: %q\n want: %q", sessions, got, wantCSV)
+	}
+}
+
+// TestParseOutOfOrder tests that the lines of logcat sections out of time order are replayed in time order.
+func TestParseOutOfOrder(t *testing.T) {
+	br := strings.Join([]string{
+		"------ SYSTEM LOG (logcat -v threadtime -v printable -d *:v) ------",
+		"10-16 08:00:00.000  1234  1250 D Tethering: Tethering wlan0",
+		"------ LAST LOGCAT (logcat -L -v threadtime -v printable -d *:v) ------",
+		"10-15 09:00:00.000  1234  1250 D Tethering: Tethering wlan0",
+		"10-15 09:30:00.000  1234  1250 D Tethering: Untethering wlan0",
+	}, "\n")
+	taken := time.Date(2017, time.October, 16, 11, 0, 0, 0, time.UTC)
+	prevDay := reportDay.AddDate(0, 0, -1)
+
+	want := []Session{
+		{Type: WifiHotspot, Interface: "wlan0", StartMs: historianutils.ClockMs(prevDay, 9, 0, 0), EndMs: historianutils.ClockMs(prevDay, 9, 30, 0)},
+		{Type: WifiHotspot, Interface: "wlan0", StartMs: historianutils.ClockMs(reportDay, 8, 0, 0), EndMs: historianutils.ClockMs(reportDay, 11, 0, 0)},
+	}
+	sessions, errs := Parse(br, taken)
+	if len(errs) > 0 {
+		t.Fatalf("Parse generated unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("Parse(%v)\n got: %v\n want: %v", br, sessions, want)
+	}
+}
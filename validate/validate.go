@@ -0,0 +1,181 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validate checks that a bug report can be analyzed by Historian, without analyzing it.
+// It only scans the lines of the report, so that automated pipelines can reject unusable files
+// early without paying for a full analysis.
+package validate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/battery-historian/bugreportutils"
+	"github.com/google/battery-historian/historianutils"
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// checkinSection is the bug report section containing the battery history.
+	checkinSection = "CHECKIN BATTERYSTATS"
+	// systemPropertiesSection is the bug report section containing the SDK version.
+	systemPropertiesSection = "SYSTEM PROPERTIES"
+)
+
+// historyLinePrefix is the prefix of the battery history lines.
+var historyLinePrefix = parseutils.BatteryStatsCheckinVersion + "," + parseutils.HistoryData + ","
+
+// History is an overview of the battery history of a bug report.
+type History struct {
+	// ReportVersion is the version of the batterystats checkin format, 0 if unknown.
+	ReportVersion int32 `json:"reportVersion"`
+	// StartMs and EndMs are the approximate times of the first and last history events, ignoring
+	// clock changes. They are 0 if the history has no valid TIME statement.
+	StartMs int64 `json:"startMs"`
+	EndMs   int64 `json:"endMs"`
+	// Lines is the number of history lines, excluding the string pool.
+	Lines int `json:"lines"`
+	// Events is the approximate number of events, counted as the comma separated items of the lines.
+	Events int `json:"events"`
+	// Overflow is whether the history buffer overflowed, in which case events are missing.
+	Overflow bool `json:"overflow"`
+}
+
+// Duration returns the approximate time span of the history.
+func (h History) Duration() time.Duration {
+	return time.Duration(h.EndMs-h.StartMs) * time.Millisecond
+}
+
+// Report is the result of validating a bug report.
+type Report struct {
+	// Valid is whether the bug report can be analyzed. If not, Problems has the reasons.
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems,omitempty"`
+	// FileName is the name of the bug report, within the zip file if the upload was zipped.
+	FileName   string  `json:"fileName,omitempty"`
+	SDKVersion int     `json:"sdkVersion,omitempty"`
+	Model      string  `json:"model,omitempty"`
+	History    History `json:"history"`
+	// SectionLines maps each bug report section to its number of lines.
+	SectionLines map[string]int `json:"sectionLines,omitempty"`
+}
+
+// scanHistory scans the lines of the checkin batterystats section into the history overview.
+func scanHistory(lines []string) History {
+	var h History
+	var cur int64
+	known := false
+	for _, line := range lines {
+		if m, result := historianutils.SubexpNames(parseutils.VersionLineRE, line); m {
+			if v, err := strconv.ParseInt(result["version"], 10, 32); err == nil {
+				h.ReportVersion = int32(v)
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, historyLinePrefix) {
+			continue
+		}
+		h.Lines++
+		if parseutils.OverflowRE.MatchString(line) {
+			h.Overflow = true
+			continue
+		}
+		m, result := historianutils.SubexpNames(parseutils.TimeRE, line)
+		if !m {
+			m, result = historianutils.SubexpNames(parseutils.ResetRE, line)
+		}
+		if m {
+			if ts, err := strconv.ParseInt(result["timeStamp"], 10, 64); err == nil {
+				cur, known = ts, true
+				if h.StartMs == 0 {
+					h.StartMs = ts
+				}
+			}
+			continue
+		}
+		if parseutils.StartRE.MatchString(line) || parseutils.ShutdownRE.MatchString(line) {
+			continue
+		}
+		if m, result := historianutils.SubexpNames(parseutils.GenericHistoryLineRE, line); m {
+			if d, err := strconv.ParseInt(result["timeDelta"], 10, 64); err == nil {
+				cur += d
+			}
+		}
+		// The items following the "9,h,<delta>" prefix.
+		h.Events += strings.Count(line, ",") - 2
+	}
+	if known {
+		h.EndMs = cur
+	}
+	return h
+}
+
+// Check validates the uploaded file with the given name, which may be a zipped or compressed bug
+// report. The report is valid if it's a bug report with a known SDK version and a battery history.
+func Check(fname string, b []byte) *Report {
+	br, n, err := bugreportutils.ExtractBugReport(fname, b)
+	if err != nil {
+		return &Report{Problems: []string{err.Error()}}
+	}
+	return CheckContents(n, br)
+}
+
+// CheckContents validates the contents of a bug report.
+func CheckContents(fname, contents string) *Report {
+	r := &Report{FileName: fname, SectionLines: make(map[string]int)}
+	section := ""
+	hasCheckin := false
+	var checkin []string
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "------") {
+			if m, result := historianutils.SubexpNames(bugreportutils.BugReportSectionRE, line); m {
+				section = result["section"]
+				if _, ok := r.SectionLines[section]; !ok {
+					r.SectionLines[section] = 0
+				}
+				hasCheckin = hasCheckin || strings.Contains(section, checkinSection)
+				continue
+			}
+		}
+		if section == "" {
+			continue
+		}
+		r.SectionLines[section]++
+		if strings.Contains(section, checkinSection) {
+			checkin = append(checkin, line)
+		}
+	}
+	r.History = scanHistory(checkin)
+
+	if meta, err := bugreportutils.ParseMetaInfo(contents); err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("no SDK version found in the %s section", systemPropertiesSection))
+	} else {
+		r.SDKVersion = meta.SdkVersion
+		r.Model = meta.ModelName
+	}
+	switch {
+	case !hasCheckin:
+		r.Problems = append(r.Problems, fmt.Sprintf("no %s section", checkinSection))
+	case r.History.Lines == 0:
+		r.Problems = append(r.Problems, "no battery history in the "+checkinSection+" section")
+	}
+	if r.History.Lines > 0 && r.History.StartMs == 0 {
+		r.Problems = append(r.Problems, "no TIME statement in the battery history")
+	}
+	r.Valid = len(r.Problems) == 0
+	return r
+}
@@ -0,0 +1,111 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheck tests the validation of bug reports.
+func TestCheck(t *testing.T) {
+	header := []string{
+		`========================================================`,
+		`== dumpstate: 2015-01-30 14:20:51`,
+		`========================================================`,
+		`Build fingerprint: 'google/shamu/shamu:5.1/LMY47D/1743759:userdebug/dev-keys'`,
+	}
+	props := []string{
+		`------ SYSTEM PROPERTIES (getprop) ------`,
+		`[ro.build.version.sdk]: [22]`,
+		`[ro.product.model]: [Nexus 6]`,
+	}
+	checkin := []string{
+		`------ CHECKIN BATTERYSTATS (dumpsys batterystats -c) ------`,
+		`9,0,i,vers,14,125,LMY47D,LMY47D`,
+		`9,hsp,0,10011,"com.google.android.gms"`,
+		`9,h,0:RESET:TIME:1422620451417`,
+		`9,h,0,Bl=90,Bs=d,Bh=g,Bp=n,Bt=236,Bv=3986,+r,+S`,
+		`9,h,60000,-S`,
+		`9,h,1200000,+Esy=0,+w=0`,
+	}
+
+	tests := []struct {
+		desc  string
+		input []string
+		want  *Report
+	}{
+		{
+			desc:  "Valid bug report",
+			input: append(append(append([]string{}, header...), props...), checkin...),
+			want: &Report{
+				Valid:      true,
+				FileName:   "bugreport.txt",
+				SDKVersion: 22,
+				Model:      "Nexus 6",
+				History: History{
+					ReportVersion: 14,
+					StartMs:       1422620451417,
+					EndMs:         1422621711417,
+					Lines:         4,
+					Events:        11,
+				},
+				SectionLines: map[string]int{
+					"SYSTEM PROPERTIES (getprop)":                    2,
+					"CHECKIN BATTERYSTATS (dumpsys batterystats -c)": 6,
+				},
+			},
+		},
+		{
+			desc:  "No battery history",
+			input: append(append(append([]string{}, header...), props...), checkin[:3]...),
+			want: &Report{
+				Problems:   []string{"no battery history in the CHECKIN BATTERYSTATS section"},
+				FileName:   "bugreport.txt",
+				SDKVersion: 22,
+				Model:      "Nexus 6",
+				History:    History{ReportVersion: 14},
+				SectionLines: map[string]int{
+					"SYSTEM PROPERTIES (getprop)":                    2,
+					"CHECKIN BATTERYSTATS (dumpsys batterystats -c)": 2,
+				},
+			},
+		},
+		{
+			desc:  "No SDK version or checkin section",
+			input: append(append([]string{}, header...), `------ SYSTEM LOG (logcat -v threadtime -d *:v) ------`),
+			want: &Report{
+				Problems:     []string{"no SDK version found in the SYSTEM PROPERTIES section", "no CHECKIN BATTERYSTATS section"},
+				FileName:     "bugreport.txt",
+				SectionLines: map[string]int{"SYSTEM LOG (logcat -v threadtime -d *:v)": 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := Check("bugreport.txt", []byte(strings.Join(test.input, "\n")))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: Check()\n got: %+v\n want: %+v", test.desc, got, test.want)
+		}
+	}
+	if got := Check("bugreport.txt", []byte("not a bug report")); got.Valid || len(got.Problems) != 1 {
+		t.Errorf("Check() = %+v for a file that isn't a bug report, want a single problem", got)
+	}
+	h := History{StartMs: 1000, EndMs: 61000}
+	if got, want := h.Duration(), time.Minute; got != want {
+		t.Errorf("%+v.Duration() = %v, want %v", h, got, want)
+	}
+}
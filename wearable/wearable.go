@@ -18,6 +18,7 @@ package wearable
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
@@ -51,9 +52,18 @@ var transportRE = regexp.MustCompile(
 		`(,\s(?P<reason>.*))?$`)
 var serviceDumpSectionRE = regexp.MustCompile(`^SERVICE\s(?P<service>\S+)\s\w+\spid=\d+$`)
 
+// cancelCheckLines is the number of lines parsed between checks for cancellation.
+const cancelCheckLines = 1000
+
 // Parse returns whether the format was valid, and writes a CSV entry for each line in
 // WearableService dump.
 func Parse(f string, loc string) (bool, string, []error) {
+	return ParseContext(context.Background(), f, loc)
+}
+
+// ParseContext is the same as Parse, but stops parsing once the context is done. A stopped parse
+// isn't valid, and its errors end with the context error.
+func ParseContext(ctx context.Context, f string, loc string) (bool, string, []error) {
 	var buf bytes.Buffer
 	csvState := csv.NewState(&buf, true)
 	timeZone, err := time.LoadLocation(loc)
@@ -64,7 +74,12 @@ func Parse(f string, loc string) (bool, string, []error) {
 	matched := false
 
 	f = extractWearableServiceDump(f)
-	for _, l := range strings.Split(f, "\n") {
+	for i, l := range strings.Split(f, "\n") {
+		if i%cancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, "", append(errs, err)
+			}
+		}
 		if matches, result := historianutils.SubexpNames(rpcRE, l); matches {
 			timestamp, err := bugreportutils.TimeStampToMs(result["timeStamp"], result["remainder"], timeZone)
 			if err != nil {
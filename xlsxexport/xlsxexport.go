@@ -0,0 +1,247 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xlsxexport exports the summaries generated by parseutils as an Excel (.xlsx) workbook,
+// with a cover sheet of KPIs and a sheet per breakdown of the summaries, e.g. per app wakelocks.
+package xlsxexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+)
+
+const (
+	// kpiSheet is the name of the cover sheet.
+	kpiSheet = "KPIs"
+	// maxSheetName is the longest sheet name Excel accepts.
+	maxSheetName = 31
+	// timeFormat is the format of the summary start and end times, in UTC.
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+var (
+	distType    = reflect.TypeOf(parseutils.Dist{})
+	distMapType = reflect.TypeOf(map[string]parseutils.Dist{})
+)
+
+// Report contains the summaries of a report to export.
+type Report struct {
+	Name      string
+	Summaries []parseutils.ActivitySummary
+}
+
+// sheet is a worksheet of the exported workbook. Each cell is either a string or a number.
+type sheet struct {
+	name string
+	rows [][]interface{}
+}
+
+// part is a file of the workbook package.
+type part struct {
+	name  string
+	write func(io.Writer)
+}
+
+func ms(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// kpis returns the cover sheet, with a row per summary of the reports. Each Dist field of a summary
+// is a column of its total duration.
+func kpis(reports []Report) sheet {
+	header := []interface{}{"Report", "Summary", "Reason", "Start (UTC)", "End (UTC)", "Duration (h)",
+		"Initial Level", "Final Level", "Level Drop / Hr"}
+	var fields []int
+	t := reflect.TypeOf(parseutils.ActivitySummary{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Type == distType {
+			fields = append(fields, i)
+			header = append(header, f.Name+" (ms)")
+		}
+	}
+	sh := sheet{name: kpiSheet, rows: [][]interface{}{header}}
+	for _, r := range reports {
+		for i, s := range r.Summaries {
+			hours := float64(s.EndTimeMs-s.StartTimeMs) / float64(time.Hour/time.Millisecond)
+			var rate float64
+			if hours > 0 {
+				rate = float64(s.InitialBatteryLevel-s.FinalBatteryLevel) / hours
+			}
+			row := []interface{}{r.Name, i, s.Reason,
+				time.Unix(0, s.StartTimeMs*int64(time.Millisecond)).UTC().Format(timeFormat),
+				time.Unix(0, s.EndTimeMs*int64(time.Millisecond)).UTC().Format(timeFormat),
+				hours, s.InitialBatteryLevel, s.FinalBatteryLevel, rate}
+			v := reflect.ValueOf(s)
+			for _, f := range fields {
+				row = append(row, ms(v.Field(f).Interface().(parseutils.Dist).TotalDuration))
+			}
+			sh.rows = append(sh.rows, row)
+		}
+	}
+	return sh
+}
+
+// breakdowns returns a sheet per map[string]Dist field of the summaries, named after the field, with a
+// row per entry of the map in each summary. Fields empty in all summaries are left out.
+func breakdowns(reports []Report) []sheet {
+	var sheets []sheet
+	t := reflect.TypeOf(parseutils.ActivitySummary{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type != distMapType {
+			continue
+		}
+		name := f.Name
+		if len(name) > maxSheetName {
+			name = name[:maxSheetName]
+		}
+		sh := sheet{name: name, rows: [][]interface{}{{"Report", "Summary", "Name", "Num", "Total Duration (ms)", "Max Duration (ms)"}}}
+		for _, r := range reports {
+			for j, s := range r.Summaries {
+				m := reflect.ValueOf(s).Field(i).Interface().(map[string]parseutils.Dist)
+				// Sort the keys so that the output is deterministic.
+				var names []string
+				for n := range m {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				for _, n := range names {
+					d := m[n]
+					sh.rows = append(sh.rows, []interface{}{r.Name, j, n, d.Num, ms(d.TotalDuration), ms(d.MaxDuration)})
+				}
+			}
+		}
+		if len(sh.rows) > 1 {
+			sheets = append(sheets, sh)
+		}
+	}
+	return sheets
+}
+
+// column returns the letters of the zero based column index, e.g. "AA" for 26.
+func column(i int) string {
+	var s string
+	for i++; i > 0; i = (i - 1) / 26 {
+		s = string(rune('A'+(i-1)%26)) + s
+	}
+	return s
+}
+
+// escape returns s escaped as XML character data.
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeSheet writes the worksheet XML of the sheet, using inline strings so that no shared strings
+// table is needed.
+func writeSheet(w io.Writer, sh sheet) {
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range sh.rows {
+		fmt.Fprintf(w, `<row r="%d">`, i+1)
+		for j, c := range row {
+			ref := fmt.Sprintf("%s%d", column(j), i+1)
+			switch v := c.(type) {
+			case string:
+				fmt.Fprintf(w, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, escape(v))
+			case float64:
+				fmt.Fprintf(w, `<c r="%s"><v>%.4f</v></c>`, ref, v)
+			default:
+				fmt.Fprintf(w, `<c r="%s"><v>%d</v></c>`, ref, v)
+			}
+		}
+		io.WriteString(w, `</row>`)
+	}
+	io.WriteString(w, `</sheetData></worksheet>`)
+}
+
+// Write writes the summaries of the given reports to w as an .xlsx workbook. The KPIs cover sheet
+// has a row per summary, and each map[string]Dist field of the summaries (e.g. per app breakdowns)
+// has its own sheet, named after the field, with a row per entry. All durations are in milliseconds.
+func Write(w io.Writer, reports []Report) error {
+	sheets := append([]sheet{kpis(reports)}, breakdowns(reports)...)
+
+	z := zip.NewWriter(w)
+	parts := []part{
+		{"[Content_Types].xml", func(w io.Writer) {
+			io.WriteString(w, xml.Header)
+			io.WriteString(w, `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+			io.WriteString(w, `<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+			io.WriteString(w, `<Default Extension="xml" ContentType="application/xml"/>`)
+			io.WriteString(w, `<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+			for i := range sheets {
+				fmt.Fprintf(w, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+			}
+			io.WriteString(w, `</Types>`)
+		}},
+		{"_rels/.rels", func(w io.Writer) {
+			io.WriteString(w, xml.Header)
+			io.WriteString(w, `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+			io.WriteString(w, `<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`)
+			io.WriteString(w, `</Relationships>`)
+		}},
+		{"xl/workbook.xml", func(w io.Writer) {
+			io.WriteString(w, xml.Header)
+			io.WriteString(w, `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+			for i, sh := range sheets {
+				fmt.Fprintf(w, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(sh.name), i+1, i+1)
+			}
+			io.WriteString(w, `</sheets></workbook>`)
+		}},
+		{"xl/_rels/workbook.xml.rels", func(w io.Writer) {
+			io.WriteString(w, xml.Header)
+			io.WriteString(w, `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+			for i := range sheets {
+				fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+			}
+			io.WriteString(w, `</Relationships>`)
+		}},
+	}
+	for i, sh := range sheets {
+		sh := sh
+		parts = append(parts, part{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), func(w io.Writer) { writeSheet(w, sh) }})
+	}
+	for _, p := range parts {
+		pw, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		p.write(pw)
+	}
+	return z.Close()
+}
+
+// Export writes the summaries of the given reports to the .xlsx workbook at path, replacing any
+// existing file.
+func Export(path string, reports []Report) error {
+	var b bytes.Buffer
+	if err := Write(&b, reports); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write xlsx workbook %q: %v", path, err)
+	}
+	return nil
+}
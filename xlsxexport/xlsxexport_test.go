@@ -0,0 +1,105 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlsxexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/battery-historian/parseutils"
+)
+
+// TestColumn tests the column letters of column indexes.
+func TestColumn(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := column(i); got != want {
+			t.Errorf("column(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestWrite tests the sheets of the workbook generated for a summary.
+func TestWrite(t *testing.T) {
+	s := parseutils.ActivitySummary{
+		Reason:              "UNPLUG",
+		StartTimeMs:         0,
+		EndTimeMs:           7200000,
+		InitialBatteryLevel: 90,
+		FinalBatteryLevel:   80,
+		ScreenOnSummary: parseutils.Dist{
+			Num:           2,
+			TotalDuration: 3 * time.Second,
+			MaxDuration:   2 * time.Second,
+		},
+		WakeLockSummary: map[string]parseutils.Dist{
+			`"com.example.app" & <co>`: {
+				Num:           1,
+				TotalDuration: 1500 * time.Millisecond,
+				MaxDuration:   1500 * time.Millisecond,
+			},
+		},
+	}
+	var b bytes.Buffer
+	if err := Write(&b, []Report{{Name: "report.txt", Summaries: []parseutils.ActivitySummary{s}}}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("Write() generated an invalid zip file: %v", err)
+	}
+	parts := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %q: %v", f.Name, err)
+		}
+		c, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", f.Name, err)
+		}
+		parts[f.Name] = string(c)
+	}
+
+	// Only the cover sheet and the non empty breakdowns are in the workbook.
+	want := `<sheets><sheet name="KPIs" sheetId="1" r:id="rId1"/><sheet name="WakeLockSummary" sheetId="2" r:id="rId2"/></sheets>`
+	if got := parts["xl/workbook.xml"]; !strings.Contains(got, want) {
+		t.Errorf("Write() generated workbook:\n%s\nwant sheets:\n%s", got, want)
+	}
+	for _, w := range []string{
+		`<c r="C2" t="inlineStr"><is><t>UNPLUG</t></is></c>`,
+		`<c r="D2" t="inlineStr"><is><t>1970-01-01 00:00:00</t></is></c>`,
+		`<c r="F2"><v>2.0000</v></c>`,
+		`<c r="I2"><v>5.0000</v></c>`,
+	} {
+		if got := parts["xl/worksheets/sheet1.xml"]; !strings.Contains(got, w) {
+			t.Errorf("Write() generated KPIs sheet:\n%s\nwant cell:\n%s", got, w)
+		}
+	}
+	want = `<row r="2"><c r="A2" t="inlineStr"><is><t>report.txt</t></is></c><c r="B2"><v>0</v></c>` +
+		`<c r="C2" t="inlineStr"><is><t>&#34;com.example.app&#34; &amp; &lt;co&gt;</t></is></c>` +
+		`<c r="D2"><v>1</v></c><c r="E2"><v>1500</v></c><c r="F2"><v>1500</v></c></row>`
+	if got := parts["xl/worksheets/sheet2.xml"]; !strings.Contains(got, want) {
+		t.Errorf("Write() generated WakeLockSummary sheet:\n%s\nwant row:\n%s", got, want)
+	}
+	if _, ok := parts["xl/worksheets/sheet3.xml"]; ok {
+		t.Errorf("Write() generated unexpected sheet3.xml")
+	}
+}